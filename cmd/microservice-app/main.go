@@ -1,12 +1,14 @@
 package main
 
 import (
+	"cmp"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"slices"
 	"strconv"
 	"time"
 
@@ -75,6 +77,12 @@ func NewMicroserviceApp(dbPath, port string) (*MicroserviceApp, error) {
 	if err := l.InitSchema(); err != nil {
 		return nil, err
 	}
+	// The audit endpoints filter events by these payload fields.
+	for _, path := range []string{"user_id", "data.id", "data.order_id"} {
+		if err := l.IndexJSONPath(path); err != nil {
+			return nil, err
+		}
+	}
 
 	return &MicroserviceApp{
 		ledger:  l,
@@ -111,12 +119,21 @@ func (app *MicroserviceApp) Start() error {
 	mux.HandleFunc("GET /health", app.wrap("health", app.handleHealth))
 	mux.HandleFunc("GET /metrics", app.wrap("metrics", app.handleMetrics))
 
+	limiter := api.NewRateLimiter(100, 200)
+	limiter.OnExceeded(func(key string) {
+		app.webhook.Publish(ledger.WebhookEvent{
+			EventType: ledger.EventQuotaExceeded,
+			Timestamp: time.Now(),
+			Data:      map[string]interface{}{"client": key},
+		})
+	})
+
 	handler := api.Chain(
 		mux,
 		api.RecoveryMiddleware(),
 		api.LoggingMiddleware(),
 		api.RequestIDMiddleware(),
-		api.RateLimitMiddleware(api.NewRateLimiter(100, 200)),
+		api.RateLimitMiddleware(limiter),
 		api.CORSMiddleware([]string{"*"}),
 	)
 
@@ -154,21 +171,11 @@ func (app *MicroserviceApp) recordEvent(eventType EventType, userID string, data
 		return err
 	}
 
-	compressed, err := ledger.CompressPayload(string(payload))
-	if err != nil {
-		return err
-	}
-
-	payloadStr := string(compressed)
-	if len(payloadStr) > 100 {
-		payloadStr = payloadStr[:100]
-	}
-
 	rec, err := app.ledger.Append(ledger.RecordInput{
 		Timestamp: time.Now().Unix(),
 		Type:      "event",
 		Source:    "microservice",
-		Payload:   fmt.Sprintf("%s:%s:%s", eventType, userID, payloadStr),
+		Payload:   string(payload),
 	})
 	if err != nil {
 		return err
@@ -416,20 +423,17 @@ func (app *MicroserviceApp) handleListEvents(w http.ResponseWriter, r *http.Requ
 
 func (app *MicroserviceApp) handleUserAudit(w http.ResponseWriter, r *http.Request) error {
 	userID := r.PathValue("id")
-	records, err := app.ledger.List(ledger.ListQuery{
-		Since: 0,
-		Until: time.Now().Unix(),
-		Limit: 1000,
+	filtered, err := app.ledger.List(ledger.ListQuery{
+		Until:       time.Now().Unix(),
+		Limit:       1000,
+		Types:       []string{"event"},
+		JSONFilters: map[string]string{"user_id": userID},
 	})
 	if err != nil {
 		return err
 	}
-
-	filtered := []ledger.Record{}
-	for _, rec := range records {
-		if contains(rec.Payload, userID) {
-			filtered = append(filtered, rec)
-		}
+	if filtered == nil {
+		filtered = []ledger.Record{}
 	}
 
 	writeJSON(w, http.StatusOK, APIResponse{
@@ -446,21 +450,21 @@ func (app *MicroserviceApp) handleUserAudit(w http.ResponseWriter, r *http.Reque
 
 func (app *MicroserviceApp) handleOrderAudit(w http.ResponseWriter, r *http.Request) error {
 	orderID := r.PathValue("id")
-	records, err := app.ledger.List(ledger.ListQuery{
-		Since: 0,
-		Until: time.Now().Unix(),
-		Limit: 1000,
-	})
-	if err != nil {
-		return err
-	}
-
+	// Order events carry the order as data, payments its ID as data.order_id.
 	filtered := []ledger.Record{}
-	for _, rec := range records {
-		if contains(rec.Payload, orderID) {
-			filtered = append(filtered, rec)
+	for _, path := range []string{"data.id", "data.order_id"} {
+		records, err := app.ledger.List(ledger.ListQuery{
+			Until:       time.Now().Unix(),
+			Limit:       1000,
+			Types:       []string{"event"},
+			JSONFilters: map[string]string{path: orderID},
+		})
+		if err != nil {
+			return err
 		}
+		filtered = append(filtered, records...)
 	}
+	slices.SortFunc(filtered, func(a, b ledger.Record) int { return cmp.Compare(a.ID, b.ID) })
 
 	writeJSON(w, http.StatusOK, APIResponse{
 		Success: true,
@@ -490,17 +494,6 @@ func writeJSON(w http.ResponseWriter, status int, payload APIResponse) {
 	_ = json.NewEncoder(w).Encode(payload)
 }
 
-func contains(s, substr string) bool {
-	for i := 0; i < len(s); i++ {
-		if s[i : i+1] == substr[0:1] {
-			if len(s[i:]) >= len(substr) && s[i:i+len(substr)] == substr {
-				return true
-			}
-		}
-	}
-	return false
-}
-
 func (app *MicroserviceApp) Close() error {
 	return app.ledger.Close()
 }
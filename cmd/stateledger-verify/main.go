@@ -0,0 +1,205 @@
+// Command stateledger-verify independently checks StateLedger exports,
+// segment files and audit bundles. It contains only verification: no
+// server, no database and no way to write to a ledger, so it can be built
+// and handed to external auditors.
+package main
+
+import (
+	"crypto"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Retr0-XD/StateLedger/pkg/ledgerverify"
+)
+
+// Version is set at build time via -ldflags "-X main.Version=...".
+var Version = "dev"
+
+// Exit codes match those of the stateledger command.
+const (
+	exitError        = 1
+	exitUsage        = 2
+	exitVerifyFailed = 3
+)
+
+// verifyKeysEnv holds comma-separated public keys, as for stateledger.
+const verifyKeysEnv = "STATELEDGER_VERIFY_KEYS"
+
+func main() {
+	if len(os.Args) < 2 {
+		printUsage()
+		os.Exit(exitUsage)
+	}
+	switch os.Args[1] {
+	case "export":
+		runExport(os.Args[2:])
+	case "segments":
+		runSegments(os.Args[2:])
+	case "bundle":
+		runBundle(os.Args[2:])
+	case "version":
+		fmt.Println(Version)
+	case "help", "-h", "--help":
+		printUsage()
+	default:
+		printUsage()
+		os.Exit(exitUsage)
+	}
+}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, `stateledger-verify checks StateLedger exports without access to the ledger.
+
+Usage:
+  stateledger-verify export   [--public-key K] [--prev-hash H] [--contiguous] FILE.jsonl
+  stateledger-verify segments [--public-key K] FILE...
+  stateledger-verify bundle   --public-key K [--export FILE.jsonl] FILE.json
+  stateledger-verify version
+
+--public-key takes comma-separated public keys (default $STATELEDGER_VERIFY_KEYS).
+Exit status is 0 when everything verifies, 3 when a check fails, 2 on
+usage errors and 1 when a file cannot be read.`)
+}
+
+func runExport(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	publicKeys := fs.String("public-key", os.Getenv(verifyKeysEnv), "comma-separated public keys to check record signatures with")
+	prevHash := fs.String("prev-hash", "", "chain hash the first record must follow (for exports that do not start at record 1)")
+	contiguous := fs.Bool("contiguous", false, "fail on missing record ids instead of counting gaps (unfiltered exports)")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		usageFatal("export takes one JSONL file")
+	}
+
+	recs := readExport(fs.Arg(0))
+	report, err := ledgerverify.VerifyRecords(recs, ledgerverify.Options{
+		Keys:       parseKeys(*publicKeys),
+		PrevHash:   *prevHash,
+		Contiguous: *contiguous,
+	})
+	if err != nil {
+		exitWith(exitVerifyFailed, err)
+	}
+	printJSON(report)
+}
+
+func runSegments(args []string) {
+	fs := flag.NewFlagSet("segments", flag.ExitOnError)
+	publicKeys := fs.String("public-key", os.Getenv(verifyKeysEnv), "comma-separated public keys to check record signatures with")
+	_ = fs.Parse(args)
+	if fs.NArg() == 0 {
+		usageFatal("segments takes segment files in transfer order")
+	}
+
+	var segs []ledgerverify.Segment
+	for _, path := range fs.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			exitWith(exitError, err)
+		}
+		seg, err := ledgerverify.ReadSegment(f)
+		f.Close()
+		if err != nil {
+			exitWith(exitVerifyFailed, fmt.Errorf("%s: %w", path, err))
+		}
+		segs = append(segs, seg)
+	}
+	report, err := ledgerverify.VerifySegments(segs, parseKeys(*publicKeys)...)
+	if err != nil {
+		exitWith(exitVerifyFailed, err)
+	}
+	printJSON(struct {
+		Segments int `json:"segments"`
+		ledgerverify.Report
+		SegmentHash string `json:"segment_hash"`
+	}{len(segs), report, segs[len(segs)-1].Trailer.SegmentHash})
+}
+
+func runBundle(args []string) {
+	fs := flag.NewFlagSet("bundle", flag.ExitOnError)
+	publicKeys := fs.String("public-key", os.Getenv(verifyKeysEnv), "comma-separated public keys to check the bundle signature with")
+	export := fs.String("export", "", "verified JSONL export that must contain the bundle's chain head")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		usageFatal("bundle takes one audit bundle file")
+	}
+	keys := parseKeys(*publicKeys)
+	if len(keys) == 0 {
+		usageFatal("bundle requires --public-key")
+	}
+
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		exitWith(exitError, err)
+	}
+	bundle, err := ledgerverify.VerifyBundle(data, keys...)
+	if err != nil {
+		exitWith(exitVerifyFailed, err)
+	}
+	out := struct {
+		ledgerverify.Signature
+		TargetTime int64                `json:"target_time"`
+		Export     *ledgerverify.Report `json:"export,omitempty"`
+	}{Signature: *bundle.Signature, TargetTime: bundle.TargetTime}
+	if *export != "" {
+		recs := readExport(*export)
+		report, err := ledgerverify.VerifyRecords(recs, ledgerverify.Options{Keys: keys})
+		if err != nil {
+			exitWith(exitVerifyFailed, fmt.Errorf("%s: %w", *export, err))
+		}
+		if err := bundle.CheckRecords(recs); err != nil {
+			exitWith(exitVerifyFailed, err)
+		}
+		out.Export = &report
+	}
+	printJSON(out)
+}
+
+func readExport(path string) []ledgerverify.Record {
+	f, err := os.Open(path)
+	if err != nil {
+		exitWith(exitError, err)
+	}
+	defer f.Close()
+	recs, err := ledgerverify.ReadJSONL(f)
+	if err != nil {
+		exitWith(exitVerifyFailed, fmt.Errorf("%s: %w", path, err))
+	}
+	if len(recs) == 0 {
+		exitWith(exitVerifyFailed, fmt.Errorf("%s: no records", path))
+	}
+	return recs
+}
+
+func parseKeys(s string) []crypto.PublicKey {
+	var keys []crypto.PublicKey
+	for _, k := range strings.Split(s, ",") {
+		if k = strings.TrimSpace(k); k == "" {
+			continue
+		}
+		pub, err := ledgerverify.ParsePublicKey(k)
+		if err != nil {
+			usageFatal(err.Error())
+		}
+		keys = append(keys, pub)
+	}
+	return keys
+}
+
+func printJSON(v any) {
+	out, _ := json.Marshal(v)
+	fmt.Println(string(out))
+}
+
+func usageFatal(msg string) {
+	exitWith(exitUsage, errors.New(msg))
+}
+
+func exitWith(code int, err error) {
+	fmt.Fprintln(os.Stderr, err.Error())
+	os.Exit(code)
+}
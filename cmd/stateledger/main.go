@@ -1,74 +1,252 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"math"
+	"net/http"
 	"os"
+	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/Retr0-XD/StateLedger/internal/api"
 	"github.com/Retr0-XD/StateLedger/internal/artifacts"
 	"github.com/Retr0-XD/StateLedger/internal/collectors"
+	"github.com/Retr0-XD/StateLedger/internal/config"
 	"github.com/Retr0-XD/StateLedger/internal/ledger"
 	"github.com/Retr0-XD/StateLedger/internal/manifest"
 	"github.com/Retr0-XD/StateLedger/internal/sources"
+	"github.com/Retr0-XD/StateLedger/pkg/agent"
+	"github.com/Retr0-XD/StateLedger/pkg/client"
+	"github.com/Retr0-XD/StateLedger/pkg/replay"
 )
 
+// Version is set at build time via -ldflags "-X main.Version=...".
+var Version = "dev"
+
 func main() {
-	if len(os.Args) < 2 {
-		printUsage()
-		os.Exit(2)
+	args := os.Args[1:]
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		switch strings.TrimLeft(args[0], "-") {
+		case "json-errors":
+			jsonErrors = true
+		default:
+			usageFatal(fmt.Sprintf("unknown option %s", args[0]))
+		}
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		commandUsage("command required")
 	}
 
-	switch os.Args[1] {
+	switch args[0] {
 	case "init":
-		runInit(os.Args[2:])
+		runInit(args[1:])
 	case "collect":
-		runCollect(os.Args[2:])
+		runCollect(args[1:])
 	case "capture":
-		runCapture(os.Args[2:])
+		runCapture(args[1:])
 	case "manifest":
-		runManifest(os.Args[2:])
+		runManifest(args[1:])
 	case "append":
-		runAppend(os.Args[2:])
+		runAppend(args[1:])
 	case "query":
-		runQuery(os.Args[2:])
+		runQuery(args[1:])
+	case "import":
+		runImport(args[1:])
+	case "export":
+		runExport(args[1:])
 	case "verify":
-		runVerify(os.Args[2:])
+		runVerify(args[1:])
+	case "graph":
+		runGraph(args[1:])
+	case "archive":
+		runArchive(args[1:])
+	case "anchor":
+		runAnchor(args[1:])
+	case "hold":
+		runHold(args[1:])
+	case "prune":
+		runPrune(args[1:])
+	case "compact":
+		runCompact(args[1:])
+	case "redact":
+		runRedact(args[1:])
+	case "segment":
+		runSegment(args[1:])
 	case "snapshot":
-		runSnapshot(os.Args[2:])
+		runSnapshot(args[1:])
 	case "advisory":
-		runAdvisory(os.Args[2:])
+		runAdvisory(args[1:])
+	case "source":
+		runSource(args[1:])
+	case "types":
+		runTypes(args[1:])
+	case "agent":
+		runAgent(args[1:])
+	case "mirror":
+		runMirror(args[1:])
+	case "schema":
+		runSchema(args[1:])
+	case "digest":
+		runDigest(args[1:])
 	case "audit":
-		runAudit(os.Args[2:])
+		runAudit(args[1:])
+	case "diff":
+		runDiff(args[1:])
+	case "config":
+		runConfig(args[1:])
+	case "replay":
+		runReplay(args[1:])
 	case "artifact":
-		runArtifact(os.Args[2:])
+		runArtifact(args[1:])
+	case "storage":
+		runStorage(args[1:])
+	case "stats":
+		runStats(args[1:])
+	case "watchdog":
+		runWatchdog(args[1:])
+	case "backup":
+		runBackup(args[1:])
+	case "restore":
+		runRestore(args[1:])
+	case "report":
+		runReport(args[1:])
+	case "recover":
+		runRecover(args[1:])
+	case "journal":
+		runJournal(args[1:])
+	case "encrypt":
+		runEncrypt(args[1:])
+	case "decrypt":
+		runDecrypt(args[1:])
+	case "keys", "key":
+		runKeys(args[1:])
+	case "credentials":
+		runCredentials(args[1:])
+	case "policy":
+		runPolicy(args[1:])
 	case "server":
-		runServer(os.Args[2:])
+		runServer(args[1:])
+	case "all-in-one":
+		runAllInOne(args[1:])
 	default:
-		printUsage()
-		os.Exit(2)
+		commandUsage(fmt.Sprintf("unknown command %q", args[0]))
 	}
 }
 
 func printUsage() {
-	fmt.Fprintln(os.Stderr, "stateledger <command> [options]")
-	fmt.Fprintln(os.Stderr, "commands: init, collect, capture, manifest, append, query, verify, snapshot, advisory, audit, artifact, server")
+	fmt.Fprintln(os.Stderr, "stateledger [--json-errors] <command> [options]")
+	fmt.Fprintln(os.Stderr, "commands: init, collect, capture, manifest, append, query, import, export, verify, graph, archive, hold, prune, redact, anchor, segment, snapshot, advisory, source, types, agent, mirror, schema, digest, audit, diff, config, replay, artifact, storage, stats, watchdog, backup, restore, report, recover, journal, encrypt, decrypt, keys, credentials, policy, server, all-in-one")
 }
 
 func defaultDBPath() string {
 	return filepath.Join("data", "ledger.db")
 }
 
+// dbKeyEnv names the environment variable holding the key of an encrypted
+// ledger database.
+const dbKeyEnv = "STATELEDGER_DB_KEY"
+
+// signingKeyEnv names the environment variable holding the base64 ed25519
+// key that signs appended records, as written by `stateledger keys
+// generate`.
+const signingKeyEnv = "STATELEDGER_SIGNING_KEY"
+
+// signerEnv names the environment variable holding a reference to a
+// signing key in a KMS or PKCS #11 token, as accepted by ledger.OpenSigner.
+const signerEnv = "STATELEDGER_SIGNER"
+
+// verifyKeysEnv names the environment variable holding comma-separated
+// base64 ed25519 public keys that record signatures are verified with.
+const verifyKeysEnv = "STATELEDGER_VERIFY_KEYS"
+
+// openLedger opens the ledger at path, encrypted with the key in dbKeyEnv
+// when it is set. Records are signed with the key in signingKeyEnv or
+// signerEnv and signatures verified with the keys in verifyKeysEnv.
+func openLedger(path string) (*ledger.Ledger, error) {
+	l, err := ledger.OpenWithOptions(path, ledger.OpenOptions{Key: []byte(os.Getenv(dbKeyEnv))})
+	if errors.Is(err, ledger.ErrDatabaseEncrypted) {
+		return nil, fmt.Errorf("%w: set %s", err, dbKeyEnv)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := addVerifyKeys(l, os.Getenv(verifyKeysEnv)); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("%s: %w", verifyKeysEnv, err)
+	}
+	if err := setSigner(l); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// setSigner sets the signer configured in signingKeyEnv or signerEnv on l.
+func setSigner(l *ledger.Ledger) error {
+	key, ref := os.Getenv(signingKeyEnv), os.Getenv(signerEnv)
+	switch {
+	case key != "" && ref != "":
+		return fmt.Errorf("set only one of %s and %s", signingKeyEnv, signerEnv)
+	case key != "":
+		priv, err := ledger.ParseSigningKey(key)
+		if err == nil {
+			err = l.SetSigningKey(priv)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", signingKeyEnv, err)
+		}
+	case ref != "":
+		signer, err := ledger.OpenSigner(ref)
+		if err == nil {
+			err = l.SetSigner(signer)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", signerEnv, err)
+		}
+	}
+	return nil
+}
+
+// addVerifyKeys adds the comma-separated base64 public keys in keys to l.
+func addVerifyKeys(l *ledger.Ledger, keys string) error {
+	for _, k := range strings.Split(keys, ",") {
+		if k = strings.TrimSpace(k); k == "" {
+			continue
+		}
+		pub, err := ledger.ParsePublicKey(k)
+		if err != nil {
+			return err
+		}
+		l.AddVerifyKeys(pub)
+	}
+	return nil
+}
+
 func defaultArtifactsPath() string {
 	return "artifacts"
 }
 
 func runInit(args []string) {
-	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	fs := newFlagSet("init")
 	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
 	artifactsPath := fs.String("artifacts", defaultArtifactsPath(), "path to artifacts store")
 	_ = fs.Parse(args)
@@ -80,7 +258,7 @@ func runInit(args []string) {
 		fatal(err)
 	}
 
-	l, err := ledger.Open(*dbPath)
+	l, err := openLedger(*dbPath)
 	if err != nil {
 		fatal(err)
 	}
@@ -94,17 +272,19 @@ func runInit(args []string) {
 }
 
 func runAppend(args []string) {
-	fs := flag.NewFlagSet("append", flag.ExitOnError)
+	fs := newFlagSet("append")
 	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
 	rtype := fs.String("type", "", "record type")
 	source := fs.String("source", "", "record source")
-	payloadFile := fs.String("payload-file", "", "path to payload file")
+	payloadFile := fs.String("payload-file", "", "path to payload file, or - for stdin")
 	payloadJSON := fs.String("payload-json", "", "payload JSON string")
 	timestamp := fs.Int64("time", 0, "unix timestamp (seconds)")
+	freeForm := fs.Bool("free-form", false, "skip payload validation for code, config, environment and mutation types")
+	labels := labelFlag(fs, "label the record, as key=value (repeatable or comma-separated)")
 	_ = fs.Parse(args)
 
 	if *rtype == "" {
-		fatal(errors.New("--type is required"))
+		usageFatal("--type is required")
 	}
 
 	payload, err := readPayload(*payloadFile, *payloadJSON)
@@ -117,7 +297,7 @@ func runAppend(args []string) {
 		ts = time.Now().Unix()
 	}
 
-	l, err := ledger.Open(*dbPath)
+	l, err := openLedger(*dbPath)
 	if err != nil {
 		fatal(err)
 	}
@@ -128,6 +308,8 @@ func runAppend(args []string) {
 		Type:      *rtype,
 		Source:    *source,
 		Payload:   payload,
+		FreeForm:  *freeForm,
+		Labels:    labels(),
 	})
 	if err != nil {
 		fatal(err)
@@ -138,17 +320,20 @@ func runAppend(args []string) {
 }
 
 func runCollect(args []string) {
-	fs := flag.NewFlagSet("collect", flag.ExitOnError)
+	fs := newFlagSet("collect")
 	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
 	kind := fs.String("kind", "", "collector kind: code|config|environment|mutation")
 	source := fs.String("source", "", "record source")
-	payloadFile := fs.String("payload-file", "", "path to payload file (JSON)")
+	payloadFile := fs.String("payload-file", "", "path to payload file (JSON), or - for stdin")
 	payloadJSON := fs.String("payload-json", "", "payload JSON string")
 	timestamp := fs.Int64("time", 0, "unix timestamp (seconds)")
+	agentID := fs.String("agent-id", "", "attribute the record to a registered agent")
+	spoolPath := fs.String("spool", "", "buffer the record in an agent spool instead of the ledger")
+	push := pushFlags(fs, "push the spool to this StateLedger API URL; records stay spooled while it is unreachable")
 	_ = fs.Parse(args)
 
 	if *kind == "" {
-		fatal(errors.New("--kind is required"))
+		usageFatal("--kind is required")
 	}
 
 	raw, err := readPayload(*payloadFile, *payloadJSON)
@@ -167,7 +352,15 @@ func runCollect(args []string) {
 		ts = time.Now().Unix()
 	}
 
-	l, err := ledger.Open(*dbPath)
+	if *spoolPath != "" {
+		spoolRecord(*spoolPath, client.AgentRecord{Timestamp: ts, Type: recordType, Source: *source, Payload: payload}, push)
+		return
+	}
+	if *push.server != "" {
+		usageFatal("--server requires --spool")
+	}
+
+	l, err := openLedger(*dbPath)
 	if err != nil {
 		fatal(err)
 	}
@@ -178,6 +371,7 @@ func runCollect(args []string) {
 		Type:      recordType,
 		Source:    *source,
 		Payload:   payload,
+		AgentID:   *agentID,
 	})
 	if err != nil {
 		fatal(err)
@@ -187,400 +381,4124 @@ func runCollect(args []string) {
 	fmt.Println(string(out))
 }
 
+// spoolRecord buffers rec in the agent spool at path, then pushes the spool
+// if a server is configured.
+func spoolRecord(path string, rec client.AgentRecord, push pushOptions) {
+	spool, err := agent.OpenSpool(path)
+	if err != nil {
+		fatal(err)
+	}
+	defer spool.Close()
+
+	seq, err := spool.Add(context.Background(), rec)
+	if err != nil {
+		fatal(err)
+	}
+	result := map[string]any{"spooled": seq, "type": rec.Type}
+	for k, v := range push.syncSpool(spool) {
+		result[k] = v
+	}
+	out, _ := json.Marshal(result)
+	fmt.Println(string(out))
+}
+
 func runQuery(args []string) {
-	fs := flag.NewFlagSet("query", flag.ExitOnError)
+	fs := newFlagSet("query")
 	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
 	id := fs.Int64("id", 0, "record id")
+	hash := fs.String("hash", "", "record hash")
 	since := fs.Int64("since", 0, "unix timestamp (seconds)")
 	until := fs.Int64("until", 0, "unix timestamp (seconds)")
 	limit := fs.Int("limit", 100, "max records")
+	traceID := fs.String("trace-id", "", "only mutations recorded under this W3C trace id")
+	afterID := fs.Int64("after-id", 0, "only records after this id, to page through results")
+	types := fs.String("type", "", "only records of these types (comma-separated)")
+	sources := fs.String("source", "", "only records of these sources (comma-separated)")
+	sinceSeq := fs.Int64("since-seq", 0, "only records after this seq of the one --source, to find what a collector sent since")
+	var filters map[string]string
+	fs.Func("json", "only records whose JSON payload has this value at a field path, as path=value (repeatable)", func(v string) error {
+		path, value, ok := strings.Cut(v, "=")
+		if !ok {
+			return errors.New("want path=value")
+		}
+		if filters == nil {
+			filters = map[string]string{}
+		}
+		filters[path] = value
+		return nil
+	})
+	labels := labelFlag(fs, "only records with this label, as key=value (repeatable or comma-separated)")
+	timeZone := tzFlag(fs)
 	_ = fs.Parse(args)
+	if *sinceSeq > 0 && (*sources == "" || strings.Contains(*sources, ",")) {
+		usageFatal("--since-seq needs exactly one --source")
+	}
+
+	// With --tz each record also carries its timestamp formatted as time.
+	var printed func(ledger.Record) any = func(rec ledger.Record) any { return rec }
+	if fs.Lookup("tz").Value.String() != "" {
+		loc := timeZone()
+		printed = func(rec ledger.Record) any {
+			return recordWithTime{Record: rec, Time: ledger.FormatTime(rec.Timestamp, loc)}
+		}
+	}
 
-	l, err := ledger.Open(*dbPath)
+	l, err := openLedger(*dbPath)
 	if err != nil {
 		fatal(err)
 	}
 	defer l.Close()
 
-	if *id > 0 {
-		rec, err := l.GetByID(*id)
+	if *id > 0 || *hash != "" {
+		var rec ledger.Record
+		if *id > 0 {
+			rec, err = l.GetByID(*id)
+		} else {
+			rec, err = l.GetByHash(*hash)
+		}
 		if err != nil {
 			fatal(err)
 		}
-		out, _ := json.Marshal(rec)
+		out, _ := json.Marshal(printed(rec))
 		fmt.Println(string(out))
 		return
 	}
 
-	recs, err := l.List(ledger.ListQuery{
-		Since: *since,
-		Until: *until,
-		Limit: *limit,
-	})
+	q := ledger.ListQuery{
+		Since:       *since,
+		Until:       *until,
+		Limit:       *limit,
+		AfterID:     *afterID,
+		TraceID:     *traceID,
+		JSONFilters: filters,
+		SinceSeq:    *sinceSeq,
+		Labels:      labels(),
+	}
+	if *types != "" {
+		q.Types = strings.Split(*types, ",")
+	}
+	if *sources != "" {
+		q.Sources = strings.Split(*sources, ",")
+	}
+	recs, err := l.List(q)
 	if err != nil {
 		fatal(err)
 	}
 
 	enc := json.NewEncoder(os.Stdout)
 	for _, rec := range recs {
-		_ = enc.Encode(rec)
+		_ = enc.Encode(printed(rec))
 	}
 }
 
-func runVerify(args []string) {
-	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+// recordWithTime is a record printed by query --tz.
+type recordWithTime struct {
+	ledger.Record
+	Time string `json:"time"`
+}
+
+func runExport(args []string) {
+	fs := newFlagSet("export")
 	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	output := fs.String("out", "", "file to write (default: stdout)")
+	format := fs.String("format", ledger.ExportNDJSON, "output format: ndjson, csv or parquet")
+	since := fs.Int64("since", 0, "unix timestamp (seconds)")
+	until := fs.Int64("until", 0, "unix timestamp (seconds)")
+	rtype := fs.String("type", "", "only records of this type")
+	source := fs.String("source", "", "only records from this source")
+	worm := fs.String("worm", "", "write object-locked segments to this s3://bucket/prefix instead")
+	retain := fs.Duration("retain", 0, "with --worm: how long each object stays locked (e.g. 61320h for 7 years)")
+	lockMode := fs.String("lock-mode", ledger.ObjectLockCompliance, "with --worm: object lock mode, COMPLIANCE or GOVERNANCE")
+	size := fs.Int("size", 10000, "with --worm: max records per object")
 	_ = fs.Parse(args)
 
-	l, err := ledger.Open(*dbPath)
-	if err != nil {
-		fatal(err)
+	if *worm != "" {
+		if *output != "" || *format != ledger.ExportNDJSON || *since != 0 || *until != 0 || *rtype != "" || *source != "" {
+			usageFatal("--worm exports every record and cannot be combined with --out, --format or filters")
+		}
+		if *retain <= 0 {
+			usageFatal("--worm requires --retain")
+		}
+	}
+	if _, err := ledger.NewExporter(io.Discard, *format); err != nil {
+		usageFatal(err.Error())
 	}
-	defer l.Close()
 
-	result, err := l.VerifyChain()
+	l, err := openLedger(*dbPath)
 	if err != nil {
 		fatal(err)
 	}
+	defer l.Close()
 
-	out, _ := json.Marshal(result)
-	fmt.Println(string(out))
-}
+	if *worm != "" {
+		store, err := ledger.OpenArchiveStore(*worm)
+		if err != nil {
+			fatal(err)
+		}
+		ws, ok := store.(ledger.WORMStore)
+		if !ok {
+			usageFatal("--worm requires an s3:// location")
+		}
+		res, err := l.ExportWORM(ws, ledger.WORMExportOptions{Retain: *retain, Mode: strings.ToUpper(*lockMode), SegmentSize: *size})
+		if err != nil {
+			fatal(err)
+		}
+		out, _ := json.Marshal(res)
+		fmt.Println(string(out))
+		return
+	}
 
-func runArtifact(args []string) {
-	if len(args) == 0 {
-		fmt.Fprintln(os.Stderr, "artifact subcommands: put")
-		os.Exit(2)
+	var w io.Writer = os.Stdout
+	if *output != "" && *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fatal(err)
+		}
+		defer f.Close()
+		w = f
 	}
 
-	switch args[0] {
-	case "put":
-		runArtifactPut(args[1:])
-	default:
-		fmt.Fprintln(os.Stderr, "unknown artifact command")
-		os.Exit(2)
+	n, err := l.Export(w, *format, ledger.ExportOptions{Since: *since, Until: *until, Type: *rtype, Source: *source})
+	if err != nil {
+		fatal(err)
 	}
+	// stdout carries only records, so the summary goes to stderr.
+	out, _ := json.Marshal(map[string]any{"exported": n})
+	fmt.Fprintln(os.Stderr, string(out))
 }
 
-func runArtifactPut(args []string) {
-	fs := flag.NewFlagSet("artifact put", flag.ExitOnError)
-	artifactsPath := fs.String("artifacts", defaultArtifactsPath(), "path to artifacts store")
-	source := fs.String("file", "", "file to store")
+func runImport(args []string) {
+	fs := newFlagSet("import")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	input := fs.String("file", "", "file to import (default: stdin)")
+	format := fs.String("format", "jsonl", "input format: jsonl")
+	rebuildChain := fs.Bool("rebuild-chain", false, "recompute hashes sequentially from the current chain head")
+	restore := fs.Bool("restore", false, "restore an export into an empty ledger with its original ids and hashes, verifying the chain")
+	batchSize := fs.Int("batch-size", 5000, "records per transaction")
+	deferIndexes := fs.Bool("defer-indexes", true, "drop secondary indexes during import and rebuild them afterwards")
+	freeForm := fs.Bool("free-form", false, "skip payload validation for code, config, environment and mutation types")
+	forceQuarantine := fs.Bool("force-quarantine", false, "with --restore: import the records before a broken link and quarantine the rest instead of refusing")
+	quarantine := fs.String("quarantine", "", "with --force-quarantine: file to save the quarantined records to (default: <db>.import-<unix time>.jsonl)")
 	_ = fs.Parse(args)
 
-	if *source == "" {
-		fatal(errors.New("--file is required"))
+	if *format != "jsonl" && *format != ledger.ExportNDJSON {
+		usageFatal(fmt.Sprintf("unsupported import format: %s", *format))
+	}
+	if *rebuildChain == *restore {
+		usageFatal("import requires exactly one of --rebuild-chain and --restore")
+	}
+	if *forceQuarantine && !*restore {
+		usageFatal("--force-quarantine requires --restore")
+	}
+	if *quarantine != "" && !*forceQuarantine {
+		usageFatal("--quarantine requires --force-quarantine")
+	}
+	if *forceQuarantine && *quarantine == "" {
+		*quarantine = fmt.Sprintf("%s.import-%d.jsonl", *dbPath, time.Now().Unix())
 	}
 
-	if err := os.MkdirAll(*artifactsPath, 0o755); err != nil {
-		fatal(err)
+	var r io.Reader = os.Stdin
+	if *input != "" && *input != "-" {
+		f, err := os.Open(*input)
+		if err != nil {
+			fatal(err)
+		}
+		defer f.Close()
+		r = f
 	}
 
-	info, err := artifacts.Store(*artifactsPath, *source)
+	l, err := openLedger(*dbPath)
 	if err != nil {
 		fatal(err)
 	}
+	defer l.Close()
 
-	out, _ := json.Marshal(info)
-	fmt.Println(string(out))
-}
+	if err := l.InitSchema(); err != nil {
+		fatal(err)
+	}
 
-func readPayload(filePath, inline string) (string, error) {
-	if filePath != "" {
-		data, err := os.ReadFile(filePath)
-		if err != nil {
-			return "", err
-		}
-		return string(data), nil
+	result, err := l.ImportJSONL(r, ledger.ImportOptions{
+		BatchSize:      *batchSize,
+		RebuildChain:   *rebuildChain,
+		Restore:        *restore,
+		DeferIndexes:   *deferIndexes,
+		FreeForm:       *freeForm,
+		QuarantinePath: *quarantine,
+	})
+	if errors.Is(err, ledger.ErrImportChainBroken) {
+		exitWith(exitVerifyFailed, err)
 	}
-	if inline != "" {
-		return inline, nil
+	if err != nil {
+		fatal(err)
 	}
-	return "", errors.New("payload required: --payload-file or --payload-json")
-}
 
-func buildCollectorPayload(kind, raw string) (string, string, error) {
-	switch kind {
-	case "code":
-		var payload collectors.CodePayload
-		if err := collectors.ParseJSON(raw, &payload); err != nil {
-			return "", "", err
-		}
-		if err := payload.Validate(); err != nil {
-			return "", "", err
-		}
-		body, err := collectors.MarshalPayload(payload)
-		return "code", body, err
-	case "config":
-		var payload collectors.ConfigPayload
-		if err := collectors.ParseJSON(raw, &payload); err != nil {
-			return "", "", err
-		}
-		if err := payload.Validate(); err != nil {
-			return "", "", err
-		}
-		body, err := collectors.MarshalPayload(payload)
-		return "config", body, err
-	case "environment":
-		var payload collectors.EnvironmentPayload
-		if err := collectors.ParseJSON(raw, &payload); err != nil {
-			return "", "", err
-		}
-		if err := payload.Validate(); err != nil {
-			return "", "", err
-		}
-		body, err := collectors.MarshalPayload(payload)
-		return "environment", body, err
-	case "mutation":
-		var payload collectors.MutationPayload
-		if err := collectors.ParseJSON(raw, &payload); err != nil {
-			return "", "", err
-		}
-		if err := payload.Validate(); err != nil {
-			return "", "", err
-		}
-		body, err := collectors.MarshalPayload(payload)
-		return "mutation", body, err
-	default:
-		return "", "", fmt.Errorf("unknown kind: %s", kind)
-	}
+	out, _ := json.Marshal(result)
+	fmt.Println(string(out))
 }
 
-func runCapture(args []string) {
-	fs := flag.NewFlagSet("capture", flag.ExitOnError)
-	kind := fs.String("kind", "", "capture kind: code|config|environment")
-	path := fs.String("path", "", "path to capture from (repo, config file, etc)")
+func runVerify(args []string) {
+	fs := newFlagSet("verify")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	publicKeys := fs.String("public-key", "", "comma-separated base64 ed25519 public keys to check record signatures with")
+	full := fs.Bool("full", false, "verify the whole chain instead of the records since the last checkpoint")
+	from := fs.Int64("from", 0, "verify only the records from this id")
+	to := fs.Int64("to", 0, "with --from: last record id to verify (0 = chain head)")
 	_ = fs.Parse(args)
 
-	if *kind == "" {
-		fatal(errors.New("--kind is required"))
+	if *to != 0 && *from == 0 {
+		usageFatal("--to requires --from")
 	}
-	if *path == "" {
-		fatal(errors.New("--path is required"))
+	if *from != 0 && *full {
+		usageFatal("--from cannot be combined with --full")
 	}
 
-	result, err := sources.CaptureFromManifest(*kind, *path, nil)
+	l, err := openLedger(*dbPath)
 	if err != nil {
 		fatal(err)
 	}
+	defer l.Close()
+	if err := addVerifyKeys(l, *publicKeys); err != nil {
+		usageFatal(err.Error())
+	}
 
-	out, _ := json.Marshal(result)
-	fmt.Println(string(out))
-}
+	setArchiveKeys(l)
 
-func runManifest(args []string) {
-	if len(args) == 0 {
-		fmt.Fprintln(os.Stderr, "manifest subcommands: create, run, show")
-		os.Exit(2)
+	var result ledger.VerifyResult
+	if *from != 0 {
+		last := *to
+		if last == 0 {
+			last = math.MaxInt64
+		}
+		result, err = l.VerifyRange(*from, last)
+	} else {
+		result, err = l.VerifyIncremental(*full)
+	}
+	if err != nil {
+		fatal(err)
 	}
 
-	switch args[0] {
-	case "create":
-		runManifestCreate(args[1:])
-	case "run":
-		runManifestRun(args[1:])
-	case "show":
-		runManifestShow(args[1:])
-	default:
-		fmt.Fprintln(os.Stderr, "unknown manifest command")
-		os.Exit(2)
+	out, _ := json.Marshal(result)
+	fmt.Println(string(out))
+	if !result.OK {
+		exitWith(exitVerifyFailed, fmt.Errorf("chain verification failed at record %d: %s", result.FailedID, result.Reason))
 	}
 }
 
-func runManifestCreate(args []string) {
-	fs := flag.NewFlagSet("manifest create", flag.ExitOnError)
-	name := fs.String("name", "default", "manifest name")
-	output := fs.String("output", "manifest.json", "output file")
+func runGraph(args []string) {
+	fs := newFlagSet("graph")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	from := fs.Int64("from", 0, "first record id (0 = the last --limit records)")
+	to := fs.Int64("to", 0, "last record id (0 = chain head)")
+	limit := fs.Int("limit", ledger.DefaultGraphLimit, "max records in the graph")
+	dot := fs.Bool("dot", false, "print Graphviz DOT instead of JSON")
 	_ = fs.Parse(args)
 
-	m := manifest.NewManifest(*name)
-	m.AddCollector("code", ".", nil)
-	m.AddCollector("environment", "", nil)
-
-	json, err := m.ToJSON()
+	l, err := openLedger(*dbPath)
 	if err != nil {
 		fatal(err)
 	}
+	defer l.Close()
 
-	if err := os.WriteFile(*output, []byte(json), 0o644); err != nil {
+	graph, err := l.ChainGraph(*from, *to, *limit)
+	if err != nil {
 		fatal(err)
 	}
+	if *dot {
+		fmt.Print(graph.DOT())
+		return
+	}
+	out, _ := json.Marshal(graph)
+	fmt.Println(string(out))
+}
 
-	fmt.Println("created: " + *output)
+// archiveKeyEnv names the environment variable holding the key that signs
+// and authenticates archive segments.
+const archiveKeyEnv = "STATELEDGER_ARCHIVE_KEY"
+
+// archiveRetiredKeysEnv names the environment variable holding the
+// comma-separated archive keys retired by `stateledger keys rotate`, which
+// still authenticate the segments they signed.
+const archiveRetiredKeysEnv = "STATELEDGER_ARCHIVE_RETIRED_KEYS"
+
+// setArchiveKeys hands the archive keys from the environment to l.
+func setArchiveKeys(l *ledger.Ledger) {
+	key := os.Getenv(archiveKeyEnv)
+	if key == "" {
+		return
+	}
+	var retired [][]byte
+	for _, k := range strings.Split(os.Getenv(archiveRetiredKeysEnv), ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			retired = append(retired, []byte(k))
+		}
+	}
+	l.SetArchiveKey([]byte(key), retired...)
 }
 
-func runManifestRun(args []string) {
-	fs := flag.NewFlagSet("manifest run", flag.ExitOnError)
-	manifestPath := fs.String("file", "manifest.json", "manifest file")
+func runArchive(args []string) {
+	fs := newFlagSet("archive")
 	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
-	source := fs.String("source", "manifest-run", "record source identifier")
+	before := fs.Int64("before", 0, "archive records older than this unix timestamp (seconds)")
+	to := fs.String("to", "", "archive location (s3://bucket/prefix, file:///path or a directory)")
+	segmentSize := fs.Int("segment-size", 10000, "max records per segment file")
+	approval := fs.Int64("approval", 0, "ID of the approved archive request this run executes (required)")
 	_ = fs.Parse(args)
 
-	m, err := manifest.LoadManifest(*manifestPath)
+	if *before <= 0 {
+		usageFatal("--before is required")
+	}
+	if *to == "" {
+		usageFatal("--to is required")
+	}
+	key := os.Getenv(archiveKeyEnv)
+	if key == "" {
+		usageFatal(fmt.Sprintf("%s must be set to sign archive segments", archiveKeyEnv))
+	}
+
+	store, err := ledger.OpenArchiveStore(*to)
 	if err != nil {
 		fatal(err)
 	}
 
-	l, err := ledger.Open(*dbPath)
+	l, err := openLedger(*dbPath)
 	if err != nil {
 		fatal(err)
 	}
 	defer l.Close()
 
-	for _, c := range m.Collectors {
-		result, _ := sources.CaptureFromManifest(c.Kind, c.Source, c.Params)
-
-		if result.Error != "" {
-			fmt.Fprintf(os.Stderr, "error capturing %s from %s: %s\n", c.Kind, c.Source, result.Error)
-			continue
+	consumeApproval(l, *approval, ledger.AdminActionArchive, func(params json.RawMessage) bool {
+		var p struct {
+			Before int64  `json:"before"`
+			To     string `json:"to"`
 		}
+		return json.Unmarshal(params, &p) == nil && p.Before == *before && p.To == *to
+	})
 
-		rec, err := l.Append(ledger.RecordInput{
-			Timestamp: time.Now().Unix(),
-			Type:      c.Kind,
-			Source:    *source,
-			Payload:   result.Payload,
-		})
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "error appending %s: %s\n", c.Kind, err)
-			continue
-		}
+	result, err := l.Archive(store, ledger.ArchiveOptions{
+		Before:      *before,
+		SegmentSize: *segmentSize,
+		Key:         []byte(key),
+	})
+	if err != nil {
+		fatal(err)
+	}
 
-		out, _ := json.Marshal(rec)
-		fmt.Println(string(out))
+	out, _ := json.Marshal(result)
+	fmt.Println(string(out))
+}
+
+// consumeApproval uses up the approved request id for action, on behalf
+// of $USER, before a command that removes or rewrites records runs. The command is refused
+// without an approval, or when match rejects the params it was approved
+// with.
+func consumeApproval(l *ledger.Ledger, id int64, action string, match func(params json.RawMessage) bool) {
+	if id <= 0 {
+		usageFatal(fmt.Sprintf("--approval is required: request the %s action with POST /api/v1/admin/requests and have a second admin key approve it", action))
+	}
+	executedBy := os.Getenv("USER")
+	if executedBy == "" {
+		executedBy = "cli"
+	}
+	if _, err := l.ConsumeApproval(id, action, executedBy, match); err != nil {
+		fatal(err)
 	}
 }
 
-func runManifestShow(args []string) {
-	fs := flag.NewFlagSet("manifest show", flag.ExitOnError)
-	manifestPath := fs.String("file", "manifest.json", "manifest file")
+func runHold(args []string) {
+	if len(args) == 0 {
+		usageFatal("hold subcommands: create, release, list")
+	}
+
+	switch args[0] {
+	case "create":
+		runHoldCreate(args[1:])
+	case "release":
+		runHoldRelease(args[1:])
+	case "list":
+		runHoldList(args[1:])
+	default:
+		usageFatal("unknown hold command")
+	}
+}
+
+func runHoldCreate(args []string) {
+	fs := newFlagSet("hold create")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	from := fs.Int64("from", 0, "first held unix timestamp (seconds)")
+	to := fs.Int64("to", 0, "last held unix timestamp (seconds)")
+	caseID := fs.String("case", "", "legal case the hold is for, e.g. LEGAL-7")
 	_ = fs.Parse(args)
 
-	m, err := manifest.LoadManifest(*manifestPath)
+	if *caseID == "" || *to <= 0 {
+		usageFatal("--case and --to are required")
+	}
+
+	l, err := openLedger(*dbPath)
 	if err != nil {
 		fatal(err)
 	}
+	defer l.Close()
 
-	out, err := m.ToJSON()
+	hold, err := l.PlaceHold(*caseID, *from, *to)
+	if err != nil {
+		fatal(err)
+	}
+	out, _ := json.Marshal(hold)
+	fmt.Println(string(out))
+}
+
+func runHoldRelease(args []string) {
+	fs := newFlagSet("hold release")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	caseID := fs.String("case", "", "legal case whose hold is released")
+	_ = fs.Parse(args)
+
+	if *caseID == "" {
+		usageFatal("--case is required")
+	}
+
+	l, err := openLedger(*dbPath)
 	if err != nil {
 		fatal(err)
 	}
+	defer l.Close()
 
-	fmt.Println(out)
+	hold, err := l.ReleaseHold(*caseID)
+	if err != nil {
+		fatal(err)
+	}
+	out, _ := json.Marshal(hold)
+	fmt.Println(string(out))
 }
-func runSnapshot(args []string) {
-	fs := flag.NewFlagSet("snapshot", flag.ExitOnError)
+
+func runHoldList(args []string) {
+	fs := newFlagSet("hold list")
 	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
-	targetTime := fs.Int64("time", 0, "unix timestamp (seconds, 0=now)")
 	_ = fs.Parse(args)
 
-	if *targetTime == 0 {
-		*targetTime = time.Now().Unix()
+	l, err := openLedger(*dbPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+
+	holds, err := l.Holds()
+	if err != nil {
+		fatal(err)
+	}
+	out, _ := json.Marshal(holds)
+	fmt.Println(string(out))
+}
+
+func runCredentials(args []string) {
+	if len(args) == 0 {
+		usageFatal("credentials subcommands: list, revoke, restore")
 	}
 
-	l, err := ledger.Open(*dbPath)
+	switch args[0] {
+	case "list":
+		runCredentialsList(args[1:])
+	case "revoke":
+		runCredentialsChange("revoke", args[1:])
+	case "restore":
+		runCredentialsChange("restore", args[1:])
+	default:
+		usageFatal("unknown credentials command")
+	}
+}
+
+func runCredentialsList(args []string) {
+	fs := newFlagSet("credentials list")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	kind := fs.String("kind", "", "only credentials of this kind: webhook or api_key")
+	includeRevoked := fs.Bool("include-revoked", false, "also list revoked credentials")
+	_ = fs.Parse(args)
+
+	l, err := openLedger(*dbPath)
 	if err != nil {
 		fatal(err)
 	}
 	defer l.Close()
 
-	rec := ledger.New(l)
-	report := rec.ReconstructAtTime(*targetTime)
+	creds, err := l.Credentials(*kind, *includeRevoked)
+	if err != nil {
+		fatal(err)
+	}
+	out, _ := json.Marshal(creds)
+	fmt.Println(string(out))
+}
 
-	out, _ := json.MarshalIndent(report, "", "  ")
+// runCredentialsChange revokes or restores a webhook subscription or API
+// key. A running server applies the change on its next config reload.
+func runCredentialsChange(action string, args []string) {
+	fs := newFlagSet("credentials " + action)
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	kind := fs.String("kind", ledger.CredentialAPIKey, "credential kind: webhook or api_key")
+	id := fs.String("id", "", "webhook subscription id or API key fingerprint")
+	apiKey := fs.String("api-key", "", "API key, identified by its fingerprint (instead of --id)")
+	actor := fs.String("actor", os.Getenv("USER"), "who makes the change")
+	reason := fs.String("reason", "", "why the change is made")
+	_ = fs.Parse(args)
+
+	if *apiKey != "" {
+		*kind, *id = ledger.CredentialAPIKey, api.APIKeyFingerprint(*apiKey)
+	}
+	if *id == "" {
+		usageFatal("--id or --api-key is required")
+	}
+
+	l, err := openLedger(*dbPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+
+	var cred ledger.Credential
+	if action == "revoke" {
+		cred, err = l.RevokeCredential(*kind, *id, *actor, *reason)
+	} else {
+		cred, err = l.RestoreCredential(*kind, *id, *actor, *reason)
+	}
+	if err != nil {
+		fatal(err)
+	}
+	out, _ := json.Marshal(cred)
 	fmt.Println(string(out))
 }
 
-func runAdvisory(args []string) {
-	fs := flag.NewFlagSet("advisory", flag.ExitOnError)
+func runPolicy(args []string) {
+	if len(args) == 0 {
+		usageFatal("policy subcommands: apply, show, history")
+	}
+
+	switch args[0] {
+	case "apply":
+		runPolicyApply(args[1:])
+	case "show":
+		runPolicyShow(args[1:])
+	case "history":
+		runPolicyHistory(args[1:])
+	default:
+		usageFatal("unknown policy command")
+	}
+}
+
+// runPolicyApply records the policies section of a config file in the
+// ledger, as the server does at startup and on reload.
+func runPolicyApply(args []string) {
+	fs := newFlagSet("policy apply")
 	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
-	targetTime := fs.Int64("time", 0, "unix timestamp (seconds, 0=now)")
+	path := fs.String("config", "", "config file whose policies section is applied")
 	_ = fs.Parse(args)
 
-	if *targetTime == 0 {
-		*targetTime = time.Now().Unix()
+	if *path == "" {
+		usageFatal("--config is required")
+	}
+	cfg, err := config.Load(*path)
+	if err != nil {
+		fatal(err)
 	}
 
-	l, err := ledger.Open(*dbPath)
+	l, err := openLedger(*dbPath)
 	if err != nil {
 		fatal(err)
 	}
 	defer l.Close()
 
-	rec := ledger.New(l)
-	report := rec.ReconstructAtTime(*targetTime)
+	policies, err := l.SetPolicies(policySet(cfg.Policies))
+	if err != nil {
+		fatal(err)
+	}
+	out, _ := json.Marshal(policies)
+	fmt.Println(string(out))
+}
 
-	// Analyze determinism
-	envAnalysis := ledger.AnalyzeEnvironment(report.State.Environment)
-	codeAnalysis := ledger.AnalyzeCode(report.State.Code)
-	configAnalysis := ledger.AnalyzeConfig(report.State.Config)
-	summary := ledger.SummarizeAnalyses(envAnalysis, codeAnalysis, configAnalysis)
+func runPolicyShow(args []string) {
+	fs := newFlagSet("policy show")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	at := fs.Int64("time", 0, "unix timestamp (seconds, 0=now) to show the policies in force at")
+	_ = fs.Parse(args)
 
-	// Print analysis
-	fmt.Println("=== Determinism Advisory ===")
-	fmt.Println(ledger.ReportJSON(summary))
-	fmt.Println("\n=== Explanation ===")
-	fmt.Println(rec.ExplainFailure(report))
+	if *at == 0 {
+		*at = time.Now().Unix()
+	}
+
+	l, err := openLedger(*dbPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+
+	policies, err := l.PoliciesAt(*at)
+	if err != nil {
+		fatal(err)
+	}
+	out, _ := json.Marshal(policies)
+	fmt.Println(string(out))
 }
 
-func runAudit(args []string) {
-	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+func runPolicyHistory(args []string) {
+	fs := newFlagSet("policy history")
 	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
-	targetTime := fs.Int64("time", 0, "unix timestamp (seconds, 0=now)")
-	output := fs.String("out", "", "write bundle to file")
+	kind := fs.String("kind", "", "policy kind: determinism, retention or coverage")
 	_ = fs.Parse(args)
 
-	if *targetTime == 0 {
-		*targetTime = time.Now().Unix()
+	if *kind == "" {
+		usageFatal("--kind is required")
 	}
 
-	l, err := ledger.Open(*dbPath)
+	l, err := openLedger(*dbPath)
 	if err != nil {
 		fatal(err)
 	}
 	defer l.Close()
 
-	rec := ledger.New(l)
-	bundle, err := rec.ExportAuditBundle(*targetTime)
+	policies, err := l.PolicyHistory(*kind)
 	if err != nil {
 		fatal(err)
 	}
+	out, _ := json.Marshal(policies)
+	fmt.Println(string(out))
+}
+
+// policySet converts the policies section of a config file.
+func policySet(p config.Policies) ledger.PolicySet {
+	var set ledger.PolicySet
+	if p.Determinism != nil {
+		set.Determinism = &ledger.DeterminismPolicy{MinScore: p.Determinism.MinScore}
+	}
+	if p.Retention != nil {
+		set.Retention = &ledger.RetentionRules{Rules: []ledger.RetentionPolicy{}}
+		for _, r := range p.Retention {
+			set.Retention.Rules = append(set.Retention.Rules, ledger.RetentionPolicy{Type: r.Type, MaxAge: time.Duration(r.MaxAge), KeepLast: r.KeepLast})
+		}
+	}
+	if p.Coverage != nil {
+		set.Coverage = &ledger.CoveragePolicy{Require: p.Coverage.Require}
+	}
+	return set
+}
 
-	json, err := bundle.ToJSON()
+func runPrune(args []string) {
+	fs := newFlagSet("prune")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	recordType := fs.String("type", "", "prune only records of this type (a trailing * matches a prefix)")
+	maxAge := fs.Duration("max-age", 0, "prune records older than this (e.g. 2160h)")
+	keepLast := fs.Int("keep-last", 0, "keep the newest N matching records")
+	dryRun := fs.Bool("dry-run", false, "report what would be pruned without changing the ledger")
+	approval := fs.Int64("approval", 0, "ID of the approved prune request this run executes (required unless --dry-run)")
+	_ = fs.Parse(args)
+
+	l, err := openLedger(*dbPath)
 	if err != nil {
 		fatal(err)
 	}
+	defer l.Close()
 
-	if *output != "" {
-		if err := os.WriteFile(*output, []byte(json), 0o644); err != nil {
+	// Without rules of its own, a prune applies the retention policy in
+	// force.
+	policies := []ledger.RetentionPolicy{{Type: *recordType, MaxAge: *maxAge, KeepLast: *keepLast}}
+	if *maxAge <= 0 && *keepLast <= 0 {
+		policy, err := l.Policy(ledger.PolicyRetention, time.Now().Unix())
+		if errors.Is(err, ledger.ErrUnknownPolicy) {
+			usageFatal("--max-age or --keep-last is required without a retention policy")
+		}
+		if err != nil {
 			fatal(err)
 		}
-		fmt.Println("written: " + *output)
-		return
+		policies = policy.Retention.Rules
+	}
+	if !*dryRun {
+		consumeApproval(l, *approval, ledger.AdminActionPrune, func(params json.RawMessage) bool {
+			var p struct {
+				Type     string          `json:"type"`
+				MaxAge   config.Duration `json:"max_age"`
+				KeepLast int             `json:"keep_last"`
+			}
+			return json.Unmarshal(params, &p) == nil && p.Type == *recordType && time.Duration(p.MaxAge) == *maxAge && p.KeepLast == *keepLast
+		})
 	}
 
-	fmt.Println(json)
+	result, err := l.Prune(ledger.PruneOptions{
+		Policies: policies,
+		DryRun:   *dryRun,
+	})
+	if err != nil {
+		fatal(err)
+	}
+	out, _ := json.Marshal(result)
+	fmt.Println(string(out))
 }
 
-func runServer(args []string) {
-	fs := flag.NewFlagSet("server", flag.ExitOnError)
+func runCompact(args []string) {
+	fs := newFlagSet("compact")
 	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
-	addr := fs.String("addr", ":8080", "server address (host:port)")
+	types := fs.String("types", strings.Join(ledger.DefaultCompactTypes, ","), "comma-separated capture types to compact")
+	minRun := fs.Int("min-run", 2, "shortest run of identical captures to compact")
+	dryRun := fs.Bool("dry-run", false, "report what would be compacted without changing the ledger")
+	approval := fs.Int64("approval", 0, "ID of the approved compact request this run executes (required unless --dry-run)")
 	_ = fs.Parse(args)
 
-	l, err := ledger.Open(*dbPath)
+	l, err := openLedger(*dbPath)
 	if err != nil {
 		fatal(err)
 	}
 	defer l.Close()
 
-	server := api.NewServer(l, *addr)
-	if err := server.Start(); err != nil {
+	// Params without types or min_run mean the defaults of the flags.
+	compactTypes := strings.Split(*types, ",")
+	if !*dryRun {
+		consumeApproval(l, *approval, ledger.AdminActionCompact, func(params json.RawMessage) bool {
+			var p struct {
+				Types  []string `json:"types"`
+				MinRun int      `json:"min_run"`
+			}
+			if json.Unmarshal(params, &p) != nil {
+				return false
+			}
+			if p.Types == nil {
+				p.Types = ledger.DefaultCompactTypes
+			}
+			if p.MinRun == 0 {
+				p.MinRun = 2
+			}
+			return slices.Equal(p.Types, compactTypes) && p.MinRun == *minRun
+		})
+	}
+
+	result, err := l.Compact(ledger.CompactOptions{
+		Types:  compactTypes,
+		MinRun: *minRun,
+		DryRun: *dryRun,
+	})
+	if err != nil {
 		fatal(err)
 	}
+	out, _ := json.Marshal(result)
+	fmt.Println(string(out))
 }
 
-func fatal(err error) {
-	fmt.Fprintln(os.Stderr, err.Error())
-	os.Exit(1)
+func runRedact(args []string) {
+	fs := newFlagSet("redact")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	id := fs.Int64("id", 0, "ID of the record whose payload is blanked")
+	reason := fs.String("reason", "", "why the payload is redacted, e.g. an erasure request reference")
+	approval := fs.Int64("approval", 0, "ID of the approved redact request this run executes (required)")
+	_ = fs.Parse(args)
+
+	if *id <= 0 || strings.TrimSpace(*reason) == "" {
+		usageFatal("--id and --reason are required")
+	}
+
+	l, err := openLedger(*dbPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+
+	consumeApproval(l, *approval, ledger.AdminActionRedact, func(params json.RawMessage) bool {
+		var p struct {
+			ID     int64  `json:"id"`
+			Reason string `json:"reason"`
+		}
+		return json.Unmarshal(params, &p) == nil && p.ID == *id && p.Reason == *reason
+	})
+
+	rec, err := l.Redact(*id, *reason)
+	if err != nil {
+		fatal(err)
+	}
+	out, _ := json.Marshal(rec)
+	fmt.Println(string(out))
+}
+
+func runAnchor(args []string) {
+	if len(args) == 0 {
+		usageFatal("anchor subcommands: publish, list, verify")
+	}
+
+	switch args[0] {
+	case "publish":
+		runAnchorPublish(args[1:])
+	case "list":
+		runAnchorList(args[1:])
+	case "verify":
+		runAnchorVerify(args[1:])
+	default:
+		usageFatal("unknown anchor command")
+	}
+}
+
+// openWitnesses resolves witness locations, exiting on the first that
+// cannot be used.
+func openWitnesses(locations []string) []ledger.Witness {
+	witnesses := make([]ledger.Witness, 0, len(locations))
+	for _, loc := range locations {
+		w, err := ledger.OpenWitness(strings.TrimSpace(loc))
+		if err != nil {
+			usageFatal(err.Error())
+		}
+		witnesses = append(witnesses, w)
+	}
+	return witnesses
+}
+
+func runAnchorPublish(args []string) {
+	fs := newFlagSet("anchor publish")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	to := fs.String("to", "", "comma-separated witnesses: https://host/path, s3://bucket/prefix or rfc3161+https://host/path")
+	_ = fs.Parse(args)
+
+	if *to == "" {
+		usageFatal("--to is required")
+	}
+	witnesses := openWitnesses(strings.Split(*to, ","))
+
+	l, err := openLedger(*dbPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+
+	receipts, err := l.Anchor(context.Background(), witnesses...)
+	out, _ := json.Marshal(receipts)
+	fmt.Println(string(out))
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func runAnchorList(args []string) {
+	fs := newFlagSet("anchor list")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	_ = fs.Parse(args)
+
+	l, err := openLedger(*dbPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+
+	receipts, err := l.Anchors()
+	if err != nil {
+		fatal(err)
+	}
+	out, _ := json.Marshal(receipts)
+	fmt.Println(string(out))
+}
+
+func runAnchorVerify(args []string) {
+	fs := newFlagSet("anchor verify")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	_ = fs.Parse(args)
+
+	l, err := openLedger(*dbPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+
+	setArchiveKeys(l)
+
+	checks, err := l.VerifyAnchors(context.Background())
+	if err != nil {
+		fatal(err)
+	}
+	out, _ := json.Marshal(checks)
+	fmt.Println(string(out))
+	for _, c := range checks {
+		if !c.OK {
+			exitWith(exitVerifyFailed, fmt.Errorf("anchor receipt %d: %s", c.Receipt.ID, c.Reason))
+		}
+	}
+}
+
+func runArtifact(args []string) {
+	if len(args) == 0 {
+		usageFatal("artifact subcommands: put")
+	}
+
+	switch args[0] {
+	case "put":
+		runArtifactPut(args[1:])
+	default:
+		usageFatal("unknown artifact command")
+	}
+}
+
+func runArtifactPut(args []string) {
+	fs := newFlagSet("artifact put")
+	artifactsPath := fs.String("artifacts", defaultArtifactsPath(), "path to artifacts store")
+	source := fs.String("file", "", "file to store")
+	_ = fs.Parse(args)
+
+	if *source == "" {
+		usageFatal("--file is required")
+	}
+
+	if err := os.MkdirAll(*artifactsPath, 0o755); err != nil {
+		fatal(err)
+	}
+
+	info, err := artifacts.Store(*artifactsPath, *source)
+	if err != nil {
+		fatal(err)
+	}
+
+	out, _ := json.Marshal(info)
+	fmt.Println(string(out))
+}
+
+func runStorage(args []string) {
+	if len(args) == 0 {
+		usageFatal("storage subcommands: report")
+	}
+
+	switch args[0] {
+	case "report":
+		runStorageReport(args[1:])
+	default:
+		usageFatal("unknown storage command")
+	}
+}
+
+func runStorageReport(args []string) {
+	fs := newFlagSet("storage report")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	artifactsPath := fs.String("artifacts", defaultArtifactsPath(), "path to artifacts store")
+	top := fs.Int("top", ledger.DefaultStorageTop, "number of largest payloads to list")
+	sample := fs.Int("sample", ledger.DefaultStorageSample, "recent payloads per type to compress when estimating compression")
+	window := fs.Duration("window", ledger.DefaultGrowthWindow, "recent period that growth is projected from")
+	_ = fs.Parse(args)
+
+	l, err := openLedger(*dbPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+
+	report, err := l.StorageReport(ledger.StorageOptions{Top: *top, Sample: *sample, GrowthWindow: *window})
+	if err != nil {
+		fatal(err)
+	}
+	usage, err := artifacts.StoreUsage(*artifactsPath)
+	if err != nil {
+		fatal(err)
+	}
+
+	out, _ := json.MarshalIndent(struct {
+		ledger.StorageReport
+		Artifacts artifacts.Usage `json:"artifacts"`
+	}{report, usage}, "", "  ")
+	fmt.Println(string(out))
+}
+
+func runStats(args []string) {
+	fs := newFlagSet("stats")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	_ = fs.Parse(args)
+
+	l, err := openLedger(*dbPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+
+	stats, err := l.Stats()
+	if err != nil {
+		fatal(err)
+	}
+	out, _ := json.MarshalIndent(stats, "", "  ")
+	fmt.Println(string(out))
+}
+
+func runWatchdog(args []string) {
+	fs := newFlagSet("watchdog")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	publicKeys := fs.String("public-key", "", "comma-separated base64 ed25519 public keys to check record signatures with")
+	full := fs.Bool("full", false, "verify the whole chain instead of the records since the last checkpoint")
+	maxAge := fs.Duration("max-age", 0, "degraded when the newest record is older than this (0 = only check registered sources)")
+	minFreeMB := fs.Uint64("min-free-mb", 0, "degraded when the file system holding the database has less free space, in MiB")
+	minFreePercent := fs.Float64("min-free-percent", 10, "degraded when the file system holding the database has a smaller share free")
+	metricsFile := fs.String("metrics-file", "", "write Prometheus metrics to this file, such as a node_exporter textfile collector .prom file")
+	jsonOut := fs.Bool("json", false, "print the whole report as JSON instead of a status line")
+	_ = fs.Parse(args)
+
+	l, err := openLedger(*dbPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+	if err := addVerifyKeys(l, *publicKeys); err != nil {
+		usageFatal(err.Error())
+	}
+	setArchiveKeys(l)
+
+	report, err := l.Watchdog(time.Now(), ledger.WatchdogOptions{
+		Full:           *full,
+		MaxCaptureAge:  *maxAge,
+		MinFreeBytes:   *minFreeMB << 20,
+		MinFreePercent: *minFreePercent,
+	})
+	if err != nil {
+		fatal(err)
+	}
+	if *metricsFile != "" {
+		if err := writeMetricsFile(*metricsFile, report); err != nil {
+			fatal(err)
+		}
+	}
+
+	if *jsonOut {
+		out, _ := json.Marshal(report)
+		fmt.Println(string(out))
+	} else {
+		fmt.Println(report.StatusLine())
+	}
+	if report.OK {
+		return
+	}
+	code := map[string]int{
+		ledger.WatchdogChain:     exitVerifyFailed,
+		ledger.WatchdogDisk:      exitDiskLow,
+		ledger.WatchdogFreshness: exitStale,
+	}[report.Category]
+	for _, c := range report.Checks {
+		if c.Name == report.Category {
+			exitWith(code, fmt.Errorf("watchdog: %s check failed: %s", c.Name, c.Detail))
+		}
+	}
+}
+
+func runBackup(args []string) {
+	fs := newFlagSet("backup")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	outPath := fs.String("out", "", "backup file to write; must not exist")
+	_ = fs.Parse(args)
+
+	if *outPath == "" {
+		usageFatal("--out is required")
+	}
+
+	l, err := openLedger(*dbPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+
+	result, err := l.Backup(*outPath)
+	if err != nil {
+		fatal(err)
+	}
+	out, _ := json.Marshal(result)
+	fmt.Println(string(out))
+}
+
+func runRestore(args []string) {
+	fs := newFlagSet("restore")
+	dbPath := fs.String("db", defaultDBPath(), "path to the ledger database to restore into")
+	from := fs.String("from", "", "backup file written by stateledger backup")
+	force := fs.Bool("force", false, "replace a ledger that already has records")
+	_ = fs.Parse(args)
+
+	if *from == "" {
+		usageFatal("--from is required")
+	}
+	if _, err := os.Stat(*from); err != nil {
+		fatal(err)
+	}
+
+	src, err := openLedger(*from)
+	if err != nil {
+		fatal(err)
+	}
+	defer src.Close()
+	setArchiveKeys(src)
+
+	info, statErr := os.Stat(*dbPath)
+	l, err := openLedger(*dbPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+	setArchiveKeys(l)
+
+	// A ledger that cannot be read may be the damaged one being replaced,
+	// but that is for the operator to say.
+	if !*force && statErr == nil && info.Size() > 0 {
+		stats, err := l.ChainStats()
+		if err != nil {
+			exitWith(exitPolicy, fmt.Errorf("cannot read %s (%v); pass --force to replace it", *dbPath, err))
+		}
+		if stats.Records > 0 {
+			exitWith(exitPolicy, fmt.Errorf("%s already has %d records; pass --force to replace them", *dbPath, stats.Records))
+		}
+	}
+
+	result, err := l.Restore(src)
+	if errors.Is(err, ledger.ErrBackupUnverified) {
+		exitWith(exitVerifyFailed, err)
+	}
+	if err != nil {
+		fatal(err)
+	}
+	out, _ := json.Marshal(result)
+	fmt.Println(string(out))
+	if !result.Verify.OK {
+		exitWith(exitVerifyFailed, fmt.Errorf("restored chain fails verification at record %d: %s", result.Verify.FailedID, result.Verify.Reason))
+	}
+}
+
+// writeMetricsFile replaces path with the report's metrics, so a collector
+// reading it never sees a partial file.
+func writeMetricsFile(path string, report ledger.WatchdogReport) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := report.WriteMetrics(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// CreateTemp makes the file readable by its owner only; collectors
+	// often run as another user.
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func runReport(args []string) {
+	if len(args) == 0 {
+		usageFatal("report subcommands: coverage")
+	}
+
+	switch args[0] {
+	case "coverage":
+		runReportCoverage(args[1:])
+	default:
+		usageFatal("unknown report command")
+	}
+}
+
+func runReportCoverage(args []string) {
+	fs := newFlagSet("report coverage")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	from := fs.String("from", "", "first UTC day, YYYY-MM-DD (default: 29 days before --to)")
+	to := fs.String("to", "", "last UTC day, YYYY-MM-DD (default: today)")
+	format := fs.String("format", "csv", "output format: csv or json")
+	output := fs.String("out", "", "write the report to file")
+	_ = fs.Parse(args)
+
+	if *format != "csv" && *format != "json" {
+		usageFatal("--format must be csv or json")
+	}
+	toDay := time.Now().UTC()
+	if *to != "" {
+		day, err := time.Parse(time.DateOnly, *to)
+		if err != nil {
+			usageFatal("--to must be a date (YYYY-MM-DD)")
+		}
+		toDay = day
+	}
+	fromDay := toDay.AddDate(0, 0, -29)
+	if *from != "" {
+		day, err := time.Parse(time.DateOnly, *from)
+		if err != nil {
+			usageFatal("--from must be a date (YYYY-MM-DD)")
+		}
+		fromDay = day
+	}
+	if toDay.Before(fromDay) {
+		usageFatal("--to is before --from")
+	}
+
+	l, err := openLedger(*dbPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+
+	days, err := l.CoverageHistory(fromDay, toDay)
+	if err != nil {
+		fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if *format == "json" {
+		out, _ := json.Marshal(days)
+		buf.Write(append(out, '\n'))
+	} else if err := ledger.WriteCoverageCSV(&buf, days); err != nil {
+		fatal(err)
+	}
+	if *output != "" {
+		if err := os.WriteFile(*output, buf.Bytes(), 0o644); err != nil {
+			fatal(err)
+		}
+		fmt.Println("written: " + *output)
+		return
+	}
+	os.Stdout.Write(buf.Bytes())
+}
+
+// readPayload returns the payload given inline or in a file; a file path
+// of "-" reads standard input.
+func readPayload(filePath, inline string) (string, error) {
+	if filePath == "-" {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	}
+	if filePath != "" {
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	}
+	if inline != "" {
+		return inline, nil
+	}
+	return "", errors.New("payload required: --payload-file or --payload-json")
+}
+
+func buildCollectorPayload(kind, raw string) (string, string, error) {
+	switch kind {
+	case "code":
+		var payload collectors.CodePayload
+		if err := collectors.ParseJSON(raw, &payload); err != nil {
+			return "", "", err
+		}
+		if err := payload.Validate(); err != nil {
+			return "", "", err
+		}
+		body, err := collectors.MarshalPayload(payload)
+		return "code", body, err
+	case "config":
+		var payload collectors.ConfigPayload
+		if err := collectors.ParseJSON(raw, &payload); err != nil {
+			return "", "", err
+		}
+		if err := payload.Validate(); err != nil {
+			return "", "", err
+		}
+		body, err := collectors.MarshalPayload(payload)
+		return "config", body, err
+	case "environment":
+		var payload collectors.EnvironmentPayload
+		if err := collectors.ParseJSON(raw, &payload); err != nil {
+			return "", "", err
+		}
+		if err := payload.Validate(); err != nil {
+			return "", "", err
+		}
+		body, err := collectors.MarshalPayload(payload)
+		return "environment", body, err
+	case "mutation":
+		var payload collectors.MutationPayload
+		if err := collectors.ParseJSON(raw, &payload); err != nil {
+			return "", "", err
+		}
+		if err := payload.Validate(); err != nil {
+			return "", "", err
+		}
+		body, err := collectors.MarshalPayload(payload)
+		return "mutation", body, err
+	default:
+		return "", "", fmt.Errorf("unknown kind: %s", kind)
+	}
+}
+
+func runCapture(args []string) {
+	fs := newFlagSet("capture")
+	kind := fs.String("kind", "", "capture kind: code|config|environment")
+	path := fs.String("path", "", "path to capture from (repo, config file, etc)")
+	binary := fs.String("binary", "", "with --kind code: Go binary whose build settings to record")
+	lockfiles := fs.String("lockfiles", "", "with --kind code: comma-separated lockfile paths to hash instead of the default set")
+	_ = fs.Parse(args)
+
+	if *kind == "" {
+		usageFatal("--kind is required")
+	}
+	if *path == "" {
+		usageFatal("--path is required")
+	}
+
+	params := map[string]string{}
+	if *binary != "" {
+		params["binary"] = *binary
+	}
+	if *lockfiles != "" {
+		params["lockfiles"] = *lockfiles
+	}
+	result, err := sources.CaptureFromManifest(*kind, *path, params)
+	if err != nil {
+		fatal(err)
+	}
+
+	out, _ := json.Marshal(result)
+	fmt.Println(string(out))
+}
+
+func runManifest(args []string) {
+	if len(args) == 0 {
+		usageFatal("manifest subcommands: create, run, show")
+	}
+
+	switch args[0] {
+	case "create":
+		runManifestCreate(args[1:])
+	case "run":
+		runManifestRun(args[1:])
+	case "show":
+		runManifestShow(args[1:])
+	default:
+		usageFatal("unknown manifest command")
+	}
+}
+
+func runManifestCreate(args []string) {
+	fs := newFlagSet("manifest create")
+	name := fs.String("name", "default", "manifest name")
+	output := fs.String("output", "manifest.json", "output file")
+	_ = fs.Parse(args)
+
+	m := manifest.NewManifest(*name)
+	m.AddCollector("code", ".", nil)
+	m.AddCollector("environment", "", nil)
+
+	json, err := m.ToJSON()
+	if err != nil {
+		fatal(err)
+	}
+
+	if err := os.WriteFile(*output, []byte(json), 0o644); err != nil {
+		fatal(err)
+	}
+
+	fmt.Println("created: " + *output)
+}
+
+func runManifestRun(args []string) {
+	fs := newFlagSet("manifest run")
+	manifestPath := fs.String("file", "manifest.json", "manifest file")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	source := fs.String("source", "manifest-run", "record source identifier")
+	agentID := fs.String("agent-id", "", "attribute records to a registered agent")
+	spoolPath := fs.String("spool", "", "buffer records in an agent spool instead of the ledger")
+	push := pushFlags(fs, "push the spool to this StateLedger API URL; records stay spooled while it is unreachable")
+	_ = fs.Parse(args)
+
+	m, err := manifest.LoadManifest(*manifestPath)
+	if err != nil {
+		fatal(err)
+	}
+	if *push.server != "" && *spoolPath == "" {
+		usageFatal("--server requires --spool")
+	}
+
+	var appendRecord func(in ledger.RecordInput) (any, error)
+	var spool *agent.Spool
+	if *spoolPath != "" {
+		spool, err = agent.OpenSpool(*spoolPath)
+		if err != nil {
+			fatal(err)
+		}
+		defer spool.Close()
+		appendRecord = func(in ledger.RecordInput) (any, error) {
+			seq, err := spool.Add(context.Background(), client.AgentRecord{Timestamp: in.Timestamp, Type: in.Type, Source: in.Source, Payload: in.Payload})
+			return map[string]any{"spooled": seq, "type": in.Type}, err
+		}
+	} else {
+		l, err := openLedger(*dbPath)
+		if err != nil {
+			fatal(err)
+		}
+		defer l.Close()
+		appendRecord = func(in ledger.RecordInput) (any, error) {
+			return l.Append(in)
+		}
+	}
+
+	captureManifest(m, *source, *agentID, appendRecord, func(rec any) {
+		out, _ := json.Marshal(rec)
+		fmt.Println(string(out))
+	})
+
+	if spool != nil && *push.server != "" {
+		out, _ := json.Marshal(push.syncSpool(spool))
+		fmt.Println(string(out))
+	}
+}
+
+// captureManifest runs each collector of m and appends its payload with
+// appendRecord, passing every appended record to emit. Failed collectors are
+// reported to stderr and skipped; their errors are returned together.
+func captureManifest(m manifest.Manifest, source, agentID string, appendRecord func(ledger.RecordInput) (any, error), emit func(any)) error {
+	var errs []error
+	for _, c := range m.Collectors {
+		result, _ := sources.CaptureFromManifest(c.Kind, c.Source, c.Params)
+
+		if result.Error != "" {
+			err := fmt.Errorf("capturing %s from %s: %s", c.Kind, c.Source, result.Error)
+			fmt.Fprintln(os.Stderr, "error "+err.Error())
+			errs = append(errs, err)
+			continue
+		}
+
+		rec, err := appendRecord(ledger.RecordInput{
+			Timestamp: time.Now().Unix(),
+			Type:      c.Kind,
+			Source:    source,
+			Payload:   result.Payload,
+			AgentID:   agentID,
+		})
+		if err != nil {
+			err = fmt.Errorf("appending %s: %w", c.Kind, err)
+			fmt.Fprintln(os.Stderr, "error "+err.Error())
+			errs = append(errs, err)
+			continue
+		}
+		emit(rec)
+	}
+	return errors.Join(errs...)
+}
+
+func runManifestShow(args []string) {
+	fs := newFlagSet("manifest show")
+	manifestPath := fs.String("file", "manifest.json", "manifest file")
+	_ = fs.Parse(args)
+
+	m, err := manifest.LoadManifest(*manifestPath)
+	if err != nil {
+		fatal(err)
+	}
+
+	out, err := m.ToJSON()
+	if err != nil {
+		fatal(err)
+	}
+
+	fmt.Println(out)
+}
+func runSnapshot(args []string) {
+	fs := newFlagSet("snapshot")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	targetTime := fs.Int64("time", 0, "unix timestamp (seconds, 0=now)")
+	artifactsPath := fs.String("artifacts", "", "artifacts store to check referenced artifacts against")
+	mode := fs.String("mode", ledger.ModeLenient, "strict fails the report on any parse error, provenance issue or coverage gap; lenient reports them")
+	_ = fs.Parse(args)
+
+	if _, err := ledger.ParseMode(*mode); err != nil {
+		usageFatal(err.Error())
+	}
+	if *targetTime == 0 {
+		*targetTime = time.Now().Unix()
+	}
+
+	l, err := openLedger(*dbPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+	l.SetArtifactStore(*artifactsPath)
+
+	rec := ledger.New(l)
+	rec.Mode = *mode
+	report := rec.ReconstructAtTime(*targetTime)
+
+	out, _ := json.MarshalIndent(report, "", "  ")
+	fmt.Println(string(out))
+	if *mode == ledger.ModeStrict && !report.Success {
+		exitWith(exitVerifyFailed, fmt.Errorf("%w: %s", ledger.ErrStrictReconstruction, strings.Join(report.StrictFailures(), "; ")))
+	}
+}
+
+func runAdvisory(args []string) {
+	fs := newFlagSet("advisory")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	targetTime := fs.Int64("time", 0, "unix timestamp (seconds, 0=now)")
+	artifactsPath := fs.String("artifacts", "", "artifacts store to check referenced artifacts against")
+	_ = fs.Parse(args)
+
+	if *targetTime == 0 {
+		*targetTime = time.Now().Unix()
+	}
+
+	l, err := openLedger(*dbPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+	l.SetArtifactStore(*artifactsPath)
+
+	rec := ledger.New(l)
+	report := rec.ReconstructAtTime(*targetTime)
+
+	// Analyze determinism
+	envAnalysis := ledger.AnalyzeEnvironment(report.State.Environment)
+	codeAnalysis := ledger.AnalyzeCode(report.State.Code)
+	configAnalysis := ledger.AnalyzeConfig(report.State.Config)
+	summary := ledger.SummarizeAnalyses(envAnalysis, codeAnalysis, configAnalysis)
+
+	sourceReport, err := l.SourceStatus(*targetTime, ledger.DefaultNewSourceWindow)
+	if err != nil {
+		fatal(err)
+	}
+	summary.Warnings = append(summary.Warnings, sourceReport.Warnings()...)
+
+	// Print analysis
+	fmt.Println("=== Determinism Advisory ===")
+	fmt.Println(ledger.ReportJSON(summary))
+	fmt.Println("\n=== Sources ===")
+	out, _ := json.MarshalIndent(sourceReport, "", "  ")
+	fmt.Println(string(out))
+	fmt.Println("\n=== Explanation ===")
+	fmt.Println(rec.ExplainFailure(report))
+}
+
+func runSource(args []string) {
+	if len(args) == 0 {
+		usageFatal("source subcommands: add, list, remove, status, verify, key, keys")
+	}
+
+	switch args[0] {
+	case "add":
+		runSourceAdd(args[1:])
+	case "list":
+		runSourceList(args[1:])
+	case "remove":
+		runSourceRemove(args[1:])
+	case "status":
+		runSourceStatus(args[1:])
+	case "verify":
+		runSourceVerify(args[1:])
+	case "key":
+		runSourceKey(args[1:])
+	case "keys":
+		runSourceKeys(args[1:])
+	default:
+		usageFatal("unknown source command")
+	}
+}
+
+func runTypes(args []string) {
+	if len(args) == 0 {
+		usageFatal("types subcommands: list, add, remove, enforce")
+	}
+
+	switch args[0] {
+	case "list":
+		runTypesList(args[1:])
+	case "add":
+		runTypesAdd(args[1:])
+	case "remove":
+		runTypesRemove(args[1:])
+	case "enforce":
+		runTypesEnforce(args[1:])
+	default:
+		usageFatal("unknown types command")
+	}
+}
+
+func runTypesList(args []string) {
+	fs := newFlagSet("types list")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	_ = fs.Parse(args)
+
+	l, err := openLedger(*dbPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+
+	reg, err := l.Types()
+	if err != nil {
+		fatal(err)
+	}
+	out, _ := json.Marshal(reg)
+	fmt.Println(string(out))
+}
+
+func runTypesAdd(args []string) {
+	fs := newFlagSet("types add")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	name := fs.String("name", "", "record type, or a prefix ending in * such as http.*")
+	description := fs.String("description", "", "what records of the type hold")
+	canonical := fs.Bool("canonical", false, "store JSON payloads of the type in canonical form, so equal payloads hash the same")
+	_ = fs.Parse(args)
+
+	if *name == "" {
+		usageFatal("--name is required")
+	}
+
+	l, err := openLedger(*dbPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+
+	if err := l.RegisterType(ledger.TypeSpec{Name: *name, Description: *description, Canonical: *canonical}); err != nil {
+		fatal(err)
+	}
+	fmt.Println("registered: " + *name)
+}
+
+func runTypesRemove(args []string) {
+	fs := newFlagSet("types remove")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	name := fs.String("name", "", "type to remove")
+	_ = fs.Parse(args)
+
+	if *name == "" {
+		usageFatal("--name is required")
+	}
+
+	l, err := openLedger(*dbPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+
+	if err := l.RemoveType(*name); err != nil {
+		fatal(err)
+	}
+	fmt.Println("removed: " + *name)
+}
+
+func runTypesEnforce(args []string) {
+	fs := newFlagSet("types enforce")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	off := fs.Bool("off", false, "accept records of any type again")
+	_ = fs.Parse(args)
+
+	l, err := openLedger(*dbPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+
+	if err := l.EnforceTypes(!*off); err != nil {
+		fatal(err)
+	}
+	if *off {
+		fmt.Println("type enforcement off")
+	} else {
+		fmt.Println("type enforcement on")
+	}
+}
+
+func runSourceAdd(args []string) {
+	fs := newFlagSet("source add")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	name := fs.String("name", "", "source name as recorded on its records")
+	kinds := fs.String("kinds", "", "comma-separated record types the source captures")
+	cadence := fs.Duration("cadence", 0, "maximum expected gap between captures (e.g. 1h)")
+	_ = fs.Parse(args)
+
+	if *name == "" || *kinds == "" || *cadence <= 0 {
+		usageFatal("--name, --kinds and --cadence are required")
+	}
+
+	l, err := openLedger(*dbPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+
+	spec := ledger.SourceSpec{Name: *name, Kinds: strings.Split(*kinds, ","), Cadence: int64(cadence.Seconds())}
+	if err := l.RegisterSource(spec); err != nil {
+		fatal(err)
+	}
+	fmt.Println("registered: " + *name)
+}
+
+func runSourceKey(args []string) {
+	fs := newFlagSet("source key")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	name := fs.String("name", "", "source whose inbound records must be signed")
+	algorithm := fs.String("algorithm", ledger.SourceKeyEd25519, "ed25519 or hmac-sha256")
+	publicKey := fs.String("public-key", "", "base64 ed25519 public key of the producer")
+	secretEnv := fs.String("secret-env", "", "environment variable holding the hmac-sha256 secret")
+	generate := fs.Bool("generate", false, "generate an ed25519 key pair and write the private key to --out")
+	out := fs.String("out", "", "key file for --generate, for agent push --source-key-file")
+	remove := fs.Bool("remove", false, "stop requiring signatures for the source")
+	_ = fs.Parse(args)
+
+	if *name == "" {
+		usageFatal("--name is required")
+	}
+	if *generate && *out == "" {
+		usageFatal("--generate requires --out")
+	}
+
+	l, err := openLedger(*dbPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+
+	if *remove {
+		if err := l.RemoveSourceKey(*name); err != nil {
+			fatal(err)
+		}
+		fmt.Println("removed: " + *name)
+		return
+	}
+
+	key := *publicKey
+	var keyFile []byte
+	switch {
+	case *generate:
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			fatal(err)
+		}
+		*algorithm, key = ledger.SourceKeyEd25519, base64.StdEncoding.EncodeToString(pub)
+		keyFile, _ = json.MarshalIndent(sourceKeyFile{Source: *name, Algorithm: *algorithm, Key: base64.StdEncoding.EncodeToString(priv.Seed())}, "", "  ")
+	case *algorithm == ledger.SourceKeyHMAC:
+		if *secretEnv == "" {
+			usageFatal("--secret-env is required for hmac-sha256")
+		}
+		key = os.Getenv(*secretEnv)
+	}
+
+	sk, err := l.SetSourceKey(*name, *algorithm, key)
+	if err != nil {
+		fatal(err)
+	}
+	if keyFile != nil {
+		if err := os.WriteFile(*out, append(keyFile, '\n'), 0o600); err != nil {
+			fatal(err)
+		}
+		fmt.Println("written: " + *out)
+	}
+	res, _ := json.Marshal(sk)
+	fmt.Println(string(res))
+}
+
+func runSourceKeys(args []string) {
+	fs := newFlagSet("source keys")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	_ = fs.Parse(args)
+
+	l, err := openLedger(*dbPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+
+	keys, err := l.SourceKeys()
+	if err != nil {
+		fatal(err)
+	}
+	out, _ := json.Marshal(keys)
+	fmt.Println(string(out))
+}
+
+func runSourceList(args []string) {
+	fs := newFlagSet("source list")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	_ = fs.Parse(args)
+
+	l, err := openLedger(*dbPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+
+	specs, err := l.Sources()
+	if err != nil {
+		fatal(err)
+	}
+	out, _ := json.Marshal(specs)
+	fmt.Println(string(out))
+}
+
+func runSourceRemove(args []string) {
+	fs := newFlagSet("source remove")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	name := fs.String("name", "", "source to remove")
+	_ = fs.Parse(args)
+
+	if *name == "" {
+		usageFatal("--name is required")
+	}
+
+	l, err := openLedger(*dbPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+
+	if err := l.RemoveSource(*name); err != nil {
+		fatal(err)
+	}
+	fmt.Println("removed: " + *name)
+}
+
+func runSourceStatus(args []string) {
+	fs := newFlagSet("source status")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	targetTime := fs.Int64("time", 0, "unix timestamp (seconds, 0=now)")
+	window := fs.Duration("new-window", ledger.DefaultNewSourceWindow, "how long an unregistered source is reported as new")
+	_ = fs.Parse(args)
+
+	l, err := openLedger(*dbPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+
+	report, err := l.SourceStatus(*targetTime, *window)
+	if err != nil {
+		fatal(err)
+	}
+	out, _ := json.Marshal(report)
+	fmt.Println(string(out))
+}
+
+func runSourceVerify(args []string) {
+	fs := newFlagSet("source verify")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	file := fs.String("file", "", "log file the mutations were recorded from; external_ref offsets are line numbers")
+	command := fs.String("exec", "", "shell command printing the event of one mutation, e.g. kcat; empty output means not found")
+	targetTime := fs.Int64("time", 0, "only mutations up to this unix timestamp (seconds, 0=now)")
+	namespace := fs.String("namespace", "", "only mutations whose external_ref has this namespace")
+	mtype := fs.String("type", "", "only mutations of this type")
+	source := fs.String("source", "", "only mutations from this source")
+	refFrom := fs.Int64("ref-from", -1, "only external_ref offsets from this one")
+	refTo := fs.Int64("ref-to", -1, "only external_ref offsets up to this one")
+	_ = fs.Parse(args)
+
+	if (*file == "") == (*command == "") {
+		usageFatal("one of --file or --exec is required")
+	}
+	filter := ledger.MutationFilter{Until: *targetTime, Namespace: *namespace, Type: *mtype, Source: *source}
+	if *refFrom >= 0 {
+		filter.RefFrom = refFrom
+	}
+	if *refTo >= 0 {
+		filter.RefTo = refTo
+	}
+	var src ledger.EventSource = &ledger.LogFileSource{Path: *file}
+	if *command != "" {
+		src = commandEventSource(*command)
+	}
+
+	l, err := openLedger(*dbPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+
+	res, err := l.VerifyMutationSource(context.Background(), src, filter)
+	if err != nil {
+		fatal(err)
+	}
+	out, _ := json.Marshal(res)
+	fmt.Println(string(out))
+	if !res.OK {
+		exitWith(exitVerifyFailed, fmt.Errorf("%d mutations missing at the source, %d differ from it", res.Missing, res.Mismatched))
+	}
+}
+
+// commandEventSource fetches each mutation's event with a shell command.
+// The command finds the event from STATELEDGER_EXTERNAL_REF,
+// STATELEDGER_NAMESPACE and STATELEDGER_OFFSET, or for Kafka
+// STATELEDGER_TOPIC and STATELEDGER_PARTITION, and prints it verbatim.
+type commandEventSource string
+
+func (c commandEventSource) Event(ctx context.Context, m ledger.MutationRecord) ([]byte, error) {
+	topic, partition := ledger.KafkaTopicPartition(m.Namespace)
+	cmd := shellCommand(ctx, string(c))
+	cmd.Env = append(os.Environ(),
+		"STATELEDGER_EXTERNAL_REF="+m.ExternalRef,
+		"STATELEDGER_NAMESPACE="+m.Namespace,
+		fmt.Sprintf("STATELEDGER_OFFSET=%d", m.Offset),
+		"STATELEDGER_TOPIC="+topic,
+		fmt.Sprintf("STATELEDGER_PARTITION=%d", partition),
+		"STATELEDGER_MUTATION_ID="+m.ID,
+	)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("fetch %s: %w", m.ExternalRef, err)
+	}
+	if len(out) == 0 {
+		return nil, ledger.ErrEventNotFound
+	}
+	return out, nil
+}
+
+func runAgent(args []string) {
+	if len(args) == 0 {
+		usageFatal("agent subcommands: register, heartbeat, list, csr, push, spool, verify")
+	}
+
+	switch args[0] {
+	case "register":
+		runAgentRegister(args[1:])
+	case "heartbeat":
+		runAgentHeartbeat(args[1:])
+	case "list":
+		runAgentList(args[1:])
+	case "csr":
+		runAgentCSR(args[1:])
+	case "push":
+		runAgentPush(args[1:])
+	case "spool":
+		runAgentSpool(args[1:])
+	case "verify":
+		runAgentVerify(args[1:])
+	default:
+		usageFatal("unknown agent command")
+	}
+}
+
+// agentFlags registers the flags shared by the agent commands. The agent
+// talks to --server when set and to the local --db otherwise.
+func agentFlags(fs *flag.FlagSet) (dbPath, server *string) {
+	dbPath = fs.String("db", defaultDBPath(), "path to ledger database")
+	server = fs.String("server", "", "StateLedger API URL (overrides --db)")
+	return dbPath, server
+}
+
+func runAgentRegister(args []string) {
+	fs := newFlagSet("agent register")
+	dbPath, server := agentFlags(fs)
+	keyFile := fs.String("key-file", filepath.Join("data", "agent.key"), "agent private key; generated if missing")
+	hostname := fs.String("hostname", "", "hostname to register (default: os hostname)")
+	_ = fs.Parse(args)
+
+	if *hostname == "" {
+		h, err := os.Hostname()
+		if err != nil {
+			fatal(err)
+		}
+		*hostname = h
+	}
+	key, err := agent.LoadOrCreateKey(*keyFile)
+	if err != nil {
+		fatal(err)
+	}
+	reg := ledger.AgentRegistration{Hostname: *hostname, Version: Version, PublicKey: agent.PublicKey(key)}
+
+	var registered any
+	if *server != "" {
+		registered, err = agentClient(*server).RegisterAgent(context.Background(), client.AgentRegistration(reg))
+	} else {
+		l, openErr := openLedger(*dbPath)
+		if openErr != nil {
+			fatal(openErr)
+		}
+		defer l.Close()
+		registered, err = l.RegisterAgent(reg)
+	}
+	if err != nil {
+		fatal(err)
+	}
+	out, _ := json.Marshal(registered)
+	fmt.Println(string(out))
+}
+
+func runAgentHeartbeat(args []string) {
+	fs := newFlagSet("agent heartbeat")
+	dbPath, server := agentFlags(fs)
+	id := fs.String("id", "", "agent id")
+	_ = fs.Parse(args)
+
+	if *id == "" {
+		usageFatal("--id is required")
+	}
+
+	var seen any
+	var err error
+	if *server != "" {
+		seen, err = agentClient(*server).AgentHeartbeat(context.Background(), *id)
+	} else {
+		l, openErr := openLedger(*dbPath)
+		if openErr != nil {
+			fatal(openErr)
+		}
+		defer l.Close()
+		seen, err = l.TouchAgent(*id)
+	}
+	if err != nil {
+		fatal(err)
+	}
+	out, _ := json.Marshal(seen)
+	fmt.Println(string(out))
+}
+
+func runAgentList(args []string) {
+	fs := newFlagSet("agent list")
+	dbPath, server := agentFlags(fs)
+	_ = fs.Parse(args)
+
+	var agents any
+	var err error
+	if *server != "" {
+		agents, err = agentClient(*server).Agents(context.Background())
+	} else {
+		l, openErr := openLedger(*dbPath)
+		if openErr != nil {
+			fatal(openErr)
+		}
+		defer l.Close()
+		agents, err = l.Agents()
+	}
+	if err != nil {
+		fatal(err)
+	}
+	out, _ := json.Marshal(agents)
+	fmt.Println(string(out))
+}
+
+func runAgentCSR(args []string) {
+	fs := newFlagSet("agent csr")
+	keyFile := fs.String("key-file", filepath.Join("data", "agent.key"), "agent private key; generated if missing")
+	hostname := fs.String("hostname", "", "hostname to embed (default: os hostname)")
+	output := fs.String("out", "agent.csr", "write the certificate request to file")
+	_ = fs.Parse(args)
+
+	if *hostname == "" {
+		h, err := os.Hostname()
+		if err != nil {
+			fatal(err)
+		}
+		*hostname = h
+	}
+	key, err := agent.LoadOrCreateKey(*keyFile)
+	if err != nil {
+		fatal(err)
+	}
+	csr, err := agent.CertificateRequest(key, *hostname)
+	if err != nil {
+		fatal(err)
+	}
+	if err := os.WriteFile(*output, csr, 0o644); err != nil {
+		fatal(err)
+	}
+	fmt.Println("written: " + *output)
+}
+
+func runAgentPush(args []string) {
+	fs := newFlagSet("agent push")
+	push := pushFlags(fs, "StateLedger API URL (https)")
+	spoolPath := fs.String("spool", filepath.Join("data", "spool.db"), "local spool database")
+	batch := fs.Int("batch", 500, "records per request")
+	interval := fs.Duration("interval", 30*time.Second, "push interval")
+	once := fs.Bool("once", false, "push pending records once and exit")
+	_ = fs.Parse(args)
+
+	if *push.server == "" {
+		usageFatal("--server is required")
+	}
+	spool, err := agent.OpenSpool(*spoolPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer spool.Close()
+
+	p := push.pusher(spool)
+	p.BatchSize = *batch
+	p.Interval = *interval
+	p.OnError = func(err error) { fmt.Fprintln(os.Stderr, "push: "+err.Error()) }
+	if *once {
+		n, err := p.PushOnce(context.Background())
+		if err != nil {
+			fatal(err)
+		}
+		pending, _ := spool.PendingCount(context.Background())
+		out, _ := json.Marshal(map[string]any{"pushed": n, "pending": pending})
+		fmt.Println(string(out))
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := p.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		fatal(err)
+	}
+}
+
+func runAgentSpool(args []string) {
+	fs := newFlagSet("agent spool")
+	spoolPath := fs.String("spool", filepath.Join("data", "spool.db"), "local spool database")
+	_ = fs.Parse(args)
+
+	spool, err := agent.OpenSpool(*spoolPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer spool.Close()
+
+	ctx := context.Background()
+	pending, err := spool.PendingCount(ctx)
+	if err != nil {
+		fatal(err)
+	}
+	head, err := spool.Head(ctx)
+	if err != nil {
+		fatal(err)
+	}
+	result := map[string]any{"pending": pending, "head": head, "valid": true}
+	err = spool.Verify(ctx)
+	if errors.Is(err, agent.ErrSpoolTampered) {
+		result["valid"] = false
+		result["reason"] = err.Error()
+	} else if err != nil {
+		fatal(err)
+	}
+	out, _ := json.Marshal(result)
+	fmt.Println(string(out))
+	if err != nil {
+		exitWith(exitVerifyFailed, err)
+	}
+}
+
+func runAgentVerify(args []string) {
+	fs := newFlagSet("agent verify")
+	dbPath, server := agentFlags(fs)
+	id := fs.String("id", "", "agent ID")
+	_ = fs.Parse(args)
+
+	if *id == "" {
+		usageFatal("--id is required")
+	}
+	var result client.VerifyResult
+	if *server != "" {
+		var err error
+		if result, err = agentClient(*server).VerifyAgent(context.Background(), *id); err != nil {
+			fatal(err)
+		}
+	} else {
+		l, err := openLedger(*dbPath)
+		if err != nil {
+			fatal(err)
+		}
+		defer l.Close()
+		res, err := l.VerifyAgentChain(*id)
+		if err != nil {
+			fatal(err)
+		}
+		result = client.VerifyResult{Valid: res.OK, Checked: res.Checked, FailedID: res.FailedID, Reason: res.Reason,
+			Time: time.Unix(res.Timestamp, 0).UTC().Format(time.RFC3339)}
+	}
+	out, _ := json.Marshal(result)
+	fmt.Println(string(out))
+	if !result.Valid {
+		exitWith(exitVerifyFailed, fmt.Errorf("agent chain verification failed at record %d: %s", result.FailedID, result.Reason))
+	}
+}
+
+// pushOptions holds the flags needed to push a spool over mTLS.
+type pushOptions struct {
+	server, keyFile, certFile, caFile *string
+	sourceKeyFiles                    *[]string
+}
+
+func pushFlags(fs *flag.FlagSet, serverUsage string) pushOptions {
+	o := pushOptions{
+		server:         fs.String("server", "", serverUsage),
+		keyFile:        fs.String("key-file", filepath.Join("data", "agent.key"), "agent private key"),
+		certFile:       fs.String("cert", "", "client certificate issued for the agent key (PEM)"),
+		caFile:         fs.String("ca", "", "CA that signed the server certificate (PEM, default: system roots)"),
+		sourceKeyFiles: new([]string),
+	}
+	fs.Func("source-key-file", "sign pushes with a source key written by source key --generate (repeatable)", func(v string) error {
+		*o.sourceKeyFiles = append(*o.sourceKeyFiles, v)
+		return nil
+	})
+	return o
+}
+
+// sourceKeyFile is the key file written by `source key --generate`.
+type sourceKeyFile struct {
+	Source    string `json:"source"`
+	Algorithm string `json:"algorithm"`
+	Key       string `json:"key"`
+}
+
+// pusher returns a Pusher that sends spool to the configured server.
+func (o pushOptions) pusher(spool *agent.Spool) *agent.Pusher {
+	if *o.certFile == "" {
+		usageFatal("--cert is required to push to --server")
+	}
+	key, err := agent.LoadOrCreateKey(*o.keyFile)
+	if err != nil {
+		fatal(err)
+	}
+	certPEM, err := os.ReadFile(*o.certFile)
+	if err != nil {
+		fatal(err)
+	}
+	var caPEM []byte
+	if *o.caFile != "" {
+		if caPEM, err = os.ReadFile(*o.caFile); err != nil {
+			fatal(err)
+		}
+	}
+	tlsConfig, err := agent.TLSConfig(key, certPEM, caPEM)
+	if err != nil {
+		fatal(err)
+	}
+	opts := []client.Option{
+		client.WithUserAgent("stateledger-agent/" + Version),
+		client.WithHTTPClient(&http.Client{Timeout: time.Minute, Transport: &http.Transport{TLSClientConfig: tlsConfig}}),
+	}
+	for _, path := range *o.sourceKeyFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fatal(err)
+		}
+		var kf sourceKeyFile
+		if err := json.Unmarshal(data, &kf); err != nil {
+			fatal(fmt.Errorf("%s: %w", path, err))
+		}
+		opts = append(opts, client.WithSourceKey(kf.Source, kf.Algorithm, kf.Key))
+	}
+	c, err := client.New(*o.server, opts...)
+	if err != nil {
+		fatal(err)
+	}
+	return &agent.Pusher{Client: c, Spool: spool, AgentID: agent.ID(key)}
+}
+
+// syncSpool pushes spool when a server is configured. While the server is
+// unreachable the records stay buffered for a later push.
+func (o pushOptions) syncSpool(spool *agent.Spool) map[string]any {
+	if *o.server == "" {
+		return nil
+	}
+	ctx := context.Background()
+	pushed, offline, err := o.pusher(spool).Sync(ctx)
+	if err != nil {
+		fatal(fmt.Errorf("records remain spooled: %w", err))
+	}
+	if offline {
+		fmt.Fprintln(os.Stderr, "server unreachable; records remain spooled")
+	}
+	pending, _ := spool.PendingCount(ctx)
+	return map[string]any{"pushed": pushed, "pending": pending, "offline": offline}
+}
+
+func agentClient(server string) *client.Client {
+	c, err := client.New(server, client.WithUserAgent("stateledger-agent/"+Version))
+	if err != nil {
+		fatal(err)
+	}
+	return c
+}
+
+func runMirror(args []string) {
+	if len(args) == 0 {
+		usageFatal("mirror subcommands: sync, check")
+	}
+
+	switch args[0] {
+	case "sync":
+		runMirrorSync(args[1:])
+	case "check":
+		runMirrorCheck(args[1:])
+	default:
+		usageFatal("unknown mirror command")
+	}
+}
+
+func runMirrorSync(args []string) {
+	fs := newFlagSet("mirror sync")
+	dbPath := fs.String("db", defaultDBPath(), "path to primary ledger database")
+	mirrorPath := fs.String("mirror-db", "", "path to secondary ledger database")
+	_ = fs.Parse(args)
+
+	secondary := openSecondary(*mirrorPath)
+	defer secondary.Close()
+	l, err := openLedger(*dbPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+
+	l.SetMirror(secondary, nil)
+	n, err := l.SyncMirror()
+	if err != nil {
+		fatal(err)
+	}
+	check, err := l.CompareMirror()
+	if err != nil {
+		fatal(err)
+	}
+	out, _ := json.Marshal(map[string]any{"copied": n, "check": check})
+	fmt.Println(string(out))
+}
+
+func runMirrorCheck(args []string) {
+	fs := newFlagSet("mirror check")
+	dbPath := fs.String("db", defaultDBPath(), "path to primary ledger database")
+	mirrorPath := fs.String("mirror-db", "", "path to secondary ledger database")
+	_ = fs.Parse(args)
+
+	secondary := openSecondary(*mirrorPath)
+	defer secondary.Close()
+	l, err := openLedger(*dbPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+
+	check, err := ledger.CompareLedgers(l, secondary)
+	if err != nil {
+		fatal(err)
+	}
+	out, _ := json.Marshal(check)
+	fmt.Println(string(out))
+	if !check.Consistent {
+		exitWith(exitVerifyFailed, errors.New("mirror does not match the primary"))
+	}
+}
+
+// openSecondary opens the ledger a primary dual-writes to, creating its
+// schema if needed.
+func openSecondary(path string) *ledger.Ledger {
+	if path == "" {
+		usageFatal("--mirror-db is required")
+	}
+	secondary, err := openLedger(path)
+	if err != nil {
+		fatal(err)
+	}
+	if err := secondary.InitSchema(); err != nil {
+		fatal(err)
+	}
+	return secondary
+}
+
+func runSchema(args []string) {
+	if len(args) == 0 {
+		usageFatal("schema subcommands: list, show, register")
+	}
+
+	switch args[0] {
+	case "list":
+		runSchemaList(args[1:])
+	case "show":
+		runSchemaShow(args[1:])
+	case "register":
+		runSchemaRegister(args[1:])
+	default:
+		usageFatal("unknown schema command")
+	}
+}
+
+func runSchemaList(args []string) {
+	fs := newFlagSet("schema list")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	_ = fs.Parse(args)
+
+	l, err := openLedger(*dbPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+
+	schemas, err := l.Schemas()
+	if err != nil {
+		fatal(err)
+	}
+	out, _ := json.Marshal(schemas)
+	fmt.Println(string(out))
+}
+
+func runSchemaShow(args []string) {
+	fs := newFlagSet("schema show")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	rtype := fs.String("type", "", "record type")
+	version := fs.Int("version", 0, "schema version (default latest)")
+	history := fs.Bool("history", false, "print every version")
+	_ = fs.Parse(args)
+
+	if *rtype == "" {
+		usageFatal("--type is required")
+	}
+
+	l, err := openLedger(*dbPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+
+	var result any
+	if *history {
+		result, err = l.SchemaHistory(*rtype)
+	} else {
+		result, err = l.Schema(*rtype, *version)
+	}
+	if err != nil {
+		fatal(err)
+	}
+	out, _ := json.Marshal(result)
+	fmt.Println(string(out))
+}
+
+func runSchemaRegister(args []string) {
+	fs := newFlagSet("schema register")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	rtype := fs.String("type", "", "custom record type")
+	schemaFile := fs.String("file", "", "path to JSON Schema file")
+	_ = fs.Parse(args)
+
+	if *rtype == "" || *schemaFile == "" {
+		usageFatal("--type and --file are required")
+	}
+	schema, err := os.ReadFile(*schemaFile)
+	if err != nil {
+		fatal(err)
+	}
+
+	l, err := openLedger(*dbPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+
+	registered, err := l.RegisterSchema(*rtype, schema)
+	if err != nil {
+		fatal(err)
+	}
+	out, _ := json.Marshal(registered)
+	fmt.Println(string(out))
+}
+
+func runAudit(args []string) {
+	fs := newFlagSet("audit")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	targetTime := fs.Int64("time", 0, "unix timestamp (seconds, 0=now)")
+	artifactsPath := fs.String("artifacts", "", "artifacts store to check referenced artifacts against")
+	output := fs.String("out", "", "write bundle to file")
+	verify := fs.String("verify", "", "check the signature of this bundle file instead of exporting one")
+	publicKeys := fs.String("public-key", os.Getenv(verifyKeysEnv), "comma-separated public keys for --verify")
+	mode := fs.String("mode", ledger.ModeLenient, "strict refuses to export a bundle whose reconstruction has any issue; lenient notes them")
+	_ = fs.Parse(args)
+
+	if *verify != "" {
+		verifyAuditBundle(*verify, *publicKeys)
+		return
+	}
+	if _, err := ledger.ParseMode(*mode); err != nil {
+		usageFatal(err.Error())
+	}
+	if *targetTime == 0 {
+		*targetTime = time.Now().Unix()
+	}
+
+	l, err := openLedger(*dbPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+	l.SetArtifactStore(*artifactsPath)
+
+	rec := ledger.New(l)
+	rec.Mode = *mode
+	bundle, err := rec.ExportAuditBundle(*targetTime)
+	if errors.Is(err, ledger.ErrStrictReconstruction) {
+		exitWith(exitVerifyFailed, err)
+	}
+	if err != nil {
+		fatal(err)
+	}
+
+	json, err := bundle.ToJSON()
+	if err != nil {
+		fatal(err)
+	}
+
+	if *output != "" {
+		if err := os.WriteFile(*output, []byte(json), 0o644); err != nil {
+			fatal(err)
+		}
+		fmt.Println("written: " + *output)
+		return
+	}
+
+	fmt.Println(json)
+}
+
+// verifyAuditBundle checks the signature of the bundle at path, exiting
+// with exitVerifyFailed when it does not verify.
+func verifyAuditBundle(path, publicKeys string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fatal(err)
+	}
+	var bundle ledger.AuditBundle
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		fatal(fmt.Errorf("%s: %w", path, err))
+	}
+	var keys []crypto.PublicKey
+	for _, k := range strings.Split(publicKeys, ",") {
+		if k = strings.TrimSpace(k); k == "" {
+			continue
+		}
+		pub, err := ledger.ParsePublicKey(k)
+		if err != nil {
+			usageFatal(err.Error())
+		}
+		keys = append(keys, pub)
+	}
+	if len(keys) == 0 {
+		usageFatal("--verify requires --public-key")
+	}
+	if err := ledger.VerifyAuditBundle(bundle, keys...); err != nil {
+		exitWith(exitVerifyFailed, err)
+	}
+	out, _ := json.Marshal(bundle.Signature)
+	fmt.Println(string(out))
+}
+
+func runDiff(args []string) {
+	fs := newFlagSet("diff")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	fromID := fs.Int64("from", 0, "config record id to diff from")
+	toID := fs.Int64("to", 0, "config record id to diff to")
+	targetTime := fs.Int64("time", 0, "diff the latest config at this unix time against its predecessor (0=now)")
+	source := fs.String("source", "", "config source to diff (default: latest captured)")
+	unmasked := fs.Bool("unmasked", false, "do not mask sensitive values")
+	output := fs.String("out", "", "write the diff to file")
+	keys := fs.Bool("keys", false, "list changed keys instead of a line diff")
+	_ = fs.Parse(args)
+
+	l, err := openLedger(*dbPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+
+	var diff ledger.ConfigDiff
+	if *fromID > 0 || *toID > 0 {
+		if *fromID <= 0 || *toID <= 0 {
+			usageFatal("--from and --to must be used together")
+		}
+		diff, err = l.DiffConfigRecords(*fromID, *toID, !*unmasked)
+		if err != nil {
+			fatal(err)
+		}
+	} else {
+		if *targetTime == 0 {
+			*targetTime = time.Now().Unix()
+		}
+		var ok bool
+		diff, ok, err = l.LatestConfigDiff(*targetTime, *source, !*unmasked)
+		if err != nil {
+			fatal(err)
+		}
+		if !ok {
+			fatal(errors.New("no earlier config snapshot to compare against"))
+		}
+	}
+
+	text := diff.Diff
+	if *keys {
+		if diff.Format == "" {
+			fatal(errors.New("key diff needs snapshots in a parseable format (json, yaml, toml, env)"))
+		}
+		var b strings.Builder
+		for _, k := range diff.Keys {
+			b.WriteString(k.String() + "\n")
+		}
+		text = b.String()
+	}
+
+	if *output != "" {
+		if err := os.WriteFile(*output, []byte(text), 0o644); err != nil {
+			fatal(err)
+		}
+		fmt.Println("written: " + *output)
+		return
+	}
+
+	if !diff.Changed || (*keys && len(diff.Keys) == 0) {
+		fmt.Println("no changes")
+		return
+	}
+	fmt.Print(text)
+}
+
+func runConfig(args []string) {
+	if len(args) == 0 {
+		usageFatal("config subcommands: history")
+	}
+
+	switch args[0] {
+	case "history":
+		runConfigHistory(args[1:])
+	default:
+		usageFatal("unknown config command")
+	}
+}
+
+func runConfigHistory(args []string) {
+	fs := newFlagSet("config history")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	source := fs.String("source", "", "config source, e.g. app.yaml")
+	key := fs.String("key", "", "dotted key path, e.g. database.pool_size")
+	unmasked := fs.Bool("unmasked", false, "do not mask sensitive values")
+	format := fs.String("format", "text", "output format (text, json)")
+	timeZone := tzFlag(fs)
+	_ = fs.Parse(args)
+	loc := timeZone()
+
+	if *source == "" || *key == "" {
+		usageFatal("--source and --key are required")
+	}
+	if *format != "text" && *format != "json" {
+		usageFatal(fmt.Sprintf("unknown format %q", *format))
+	}
+
+	l, err := openLedger(*dbPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+
+	history, err := l.ConfigKeyHistory(*source, *key, !*unmasked)
+	if err != nil {
+		fatal(err)
+	}
+	if *format == "json" {
+		out, _ := json.Marshal(history)
+		fmt.Println(string(out))
+		return
+	}
+	for _, c := range history.Changes {
+		value := c.Value
+		if !c.Present {
+			value = "(unset)"
+		}
+		fmt.Printf("%s  record %d  %s\n", ledger.FormatTime(c.Timestamp, loc), c.RecordID, value)
+	}
+}
+
+func runSegment(args []string) {
+	if len(args) == 0 {
+		usageFatal("segment subcommands: export, import, verify")
+	}
+
+	switch args[0] {
+	case "export":
+		runSegmentExport(args[1:])
+	case "import":
+		runSegmentImport(args[1:])
+	case "verify":
+		runSegmentVerify(args[1:])
+	default:
+		usageFatal("unknown segment command")
+	}
+}
+
+func runSegmentExport(args []string) {
+	fs := newFlagSet("segment export")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	outDir := fs.String("out", "", "directory to write segment files to")
+	fromID := fs.Int64("from-id", 1, "first record id to export")
+	toID := fs.Int64("to-id", 0, "last record id to export (0=chain head)")
+	size := fs.Int("size", 10000, "max records per segment file")
+	prevSegment := fs.String("prev-segment-hash", "", "segment hash of the last file of a previous transfer")
+	_ = fs.Parse(args)
+
+	if *outDir == "" {
+		usageFatal("--out is required")
+	}
+	if *size <= 0 {
+		usageFatal("--size must be positive")
+	}
+	if err := os.MkdirAll(*outDir, 0o755); err != nil {
+		fatal(err)
+	}
+
+	l, err := openLedger(*dbPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+
+	type exported struct {
+		File        string `json:"file"`
+		FirstID     int64  `json:"first_id"`
+		LastID      int64  `json:"last_id"`
+		Count       int    `json:"count"`
+		SegmentHash string `json:"segment_hash"`
+	}
+	var files []exported
+
+	prevHash := *prevSegment
+	next := *fromID
+	for n := 1; ; n++ {
+		recs, err := l.RecordsByID(next, *toID, *size)
+		if err != nil {
+			fatal(err)
+		}
+		if len(recs) == 0 {
+			break
+		}
+
+		name := filepath.Join(*outDir, fmt.Sprintf("segment-%06d.jsonl", n))
+		f, err := os.Create(name)
+		if err != nil {
+			fatal(err)
+		}
+		trailer, err := ledger.WriteSegment(f, recs, prevHash)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			fatal(err)
+		}
+
+		files = append(files, exported{
+			File:        name,
+			FirstID:     recs[0].ID,
+			LastID:      recs[len(recs)-1].ID,
+			Count:       trailer.Count,
+			SegmentHash: trailer.SegmentHash,
+		})
+		prevHash = trailer.SegmentHash
+		next = recs[len(recs)-1].ID + 1
+	}
+
+	out, _ := json.Marshal(map[string]any{"segments": files, "last_segment_hash": prevHash})
+	fmt.Println(string(out))
+}
+
+// readSegmentFiles decodes and verifies segment files, and checks that they
+// form one linked sequence in the given order.
+func readSegmentFiles(paths []string) []ledger.Segment {
+	if len(paths) == 0 {
+		usageFatal("no segment files given")
+	}
+	segs := make([]ledger.Segment, 0, len(paths))
+	for _, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			fatal(err)
+		}
+		seg, err := ledger.ReadSegment(f)
+		f.Close()
+		if err != nil {
+			fatal(fmt.Errorf("%s: %w", p, err))
+		}
+		segs = append(segs, seg)
+	}
+	if err := ledger.VerifySegmentSequence(segs); err != nil {
+		exitWith(exitVerifyFailed, err)
+	}
+	return segs
+}
+
+func runSegmentVerify(args []string) {
+	fs := newFlagSet("segment verify")
+	_ = fs.Parse(args)
+
+	segs := readSegmentFiles(fs.Args())
+	var records int
+	for _, seg := range segs {
+		records += len(seg.Records)
+	}
+	last := segs[len(segs)-1]
+
+	out, _ := json.Marshal(map[string]any{
+		"ok":                true,
+		"segments":          len(segs),
+		"records":           records,
+		"first_id":          segs[0].Header.FirstID,
+		"last_id":           last.Header.LastID,
+		"last_hash":         last.Trailer.LastHash,
+		"last_segment_hash": last.Trailer.SegmentHash,
+	})
+	fmt.Println(string(out))
+}
+
+func runSegmentImport(args []string) {
+	fs := newFlagSet("segment import")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	_ = fs.Parse(args)
+
+	segs := readSegmentFiles(fs.Args())
+
+	l, err := openLedger(*dbPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+	if err := l.InitSchema(); err != nil {
+		fatal(err)
+	}
+
+	var imported int
+	for _, seg := range segs {
+		n, err := l.ImportSegment(seg)
+		if err != nil {
+			fatal(err)
+		}
+		imported += n
+	}
+
+	result, err := l.VerifyChain()
+	if err != nil {
+		fatal(err)
+	}
+	out, _ := json.Marshal(map[string]any{"imported": imported, "verify": result})
+	fmt.Println(string(out))
+	if !result.OK {
+		exitWith(exitVerifyFailed, fmt.Errorf("imported chain failed verification at record %d: %s", result.FailedID, result.Reason))
+	}
+}
+
+func runReplay(args []string) {
+	if len(args) == 0 {
+		usageFatal("replay subcommands: preflight, run, simulate, export, progress, reset")
+	}
+
+	switch args[0] {
+	case "preflight":
+		runReplayPreflight(args[1:])
+	case "run":
+		runReplayRun(args[1:])
+	case "simulate":
+		runReplaySimulate(args[1:])
+	case "export":
+		runReplayExport(args[1:])
+	case "progress":
+		runReplayProgress(args[1:])
+	case "reset":
+		runReplayReset(args[1:])
+	default:
+		usageFatal("unknown replay command")
+	}
+}
+
+func runReplayPreflight(args []string) {
+	fs := newFlagSet("replay preflight")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	targetTime := fs.Int64("time", 0, "unix timestamp of the target snapshot (seconds, 0=now)")
+	threshold := fs.Float64("threshold", ledger.DefaultPreflightThreshold, "minimum compatibility score for destructive replay")
+	destructive := fs.Bool("destructive", false, "the planned replay is destructive; refuse when below threshold")
+	_ = fs.Parse(args)
+
+	if *targetTime == 0 {
+		*targetTime = time.Now().Unix()
+	}
+
+	l, err := openLedger(*dbPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+
+	report := ledger.New(l).ReconstructAtTime(*targetTime)
+
+	current, err := sources.CaptureEnvironment()
+	if err != nil {
+		fatal(err)
+	}
+
+	var captured *collectors.EnvironmentPayload
+	if report.State != nil {
+		captured = report.State.Environment
+	}
+	drift := ledger.CompareEnvironments(captured, &current)
+	allowed := !*destructive || drift.Score >= *threshold
+
+	out, _ := json.MarshalIndent(map[string]any{
+		"target_time": *targetTime,
+		"threshold":   *threshold,
+		"destructive": *destructive,
+		"allowed":     allowed,
+		"captured":    captured,
+		"current":     current,
+		"drift":       drift,
+	}, "", "  ")
+	fmt.Println(string(out))
+
+	if !allowed {
+		exitWith(exitPolicy, fmt.Errorf("destructive replay refused: compatibility score %.1f below threshold %.1f", drift.Score, *threshold))
+	}
+}
+
+func runReplayRun(args []string) {
+	fs := newFlagSet("replay run")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	targetTime := fs.Int64("time", 0, "unix timestamp of the target snapshot (seconds, 0=now)")
+	name := fs.String("name", ledger.DefaultReplayName, "replay name; progress is kept per name and namespace")
+	command := fs.String("exec", "", "shell command applying one mutation; the record payload is on stdin")
+	namespaces := fs.String("namespace", "", "comma-separated namespaces to replay (default: all)")
+	fromStart := fs.Bool("from-start", false, "ignore stored progress and replay every mutation")
+	tokens := fs.String("resume-token", "", "comma-separated resume tokens; each resumes its namespace after the position it names")
+	_ = fs.Parse(args)
+
+	if *command == "" {
+		usageFatal("--exec is required")
+	}
+	if *targetTime == 0 {
+		*targetTime = time.Now().Unix()
+	}
+	executor := ledger.ReplayExecutor{Name: *name, FromStart: *fromStart}
+	if *namespaces != "" {
+		executor.Namespaces = strings.Split(*namespaces, ",")
+	}
+	for _, t := range strings.Split(*tokens, ",") {
+		if t == "" {
+			continue
+		}
+		pos, err := ledger.ParseResumeToken(t)
+		if err != nil {
+			usageFatal(err.Error())
+		}
+		executor.Resume = append(executor.Resume, pos)
+	}
+
+	l, err := openLedger(*dbPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+	executor.Ledger = l
+	executor.Apply = func(ctx context.Context, m ledger.MutationRecord, rec ledger.Record) error {
+		cmd := shellCommand(ctx, *command)
+		cmd.Stdin = strings.NewReader(rec.Payload)
+		cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+		cmd.Env = append(os.Environ(),
+			fmt.Sprintf("STATELEDGER_RECORD_ID=%d", m.LedgerID),
+			"STATELEDGER_NAMESPACE="+m.Namespace,
+			"STATELEDGER_EXTERNAL_REF="+m.ExternalRef,
+			"STATELEDGER_MUTATION_ID="+m.ID)
+		return cmd.Run()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	result, err := executor.Run(ctx, *targetTime)
+	out, _ := json.Marshal(result)
+	fmt.Println(string(out))
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func runReplaySimulate(args []string) {
+	fs := newFlagSet("replay simulate")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	targetTime := fs.Int64("time", 0, "unix timestamp of the target snapshot (seconds, 0=now)")
+	expect := fs.String("expect", "", "expected state hash; exit 3 when the rebuilt state differs")
+	namespaces := fs.String("namespace", "", "comma-separated namespaces to replay (default: all)")
+	_ = fs.Parse(args)
+
+	if *targetTime == 0 {
+		*targetTime = time.Now().Unix()
+	}
+	opts := replay.Options{Expected: *expect}
+	if *namespaces != "" {
+		opts.Namespaces = strings.Split(*namespaces, ",")
+	}
+
+	l, err := openLedger(*dbPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+
+	result, err := replay.Simulate(context.Background(), l, *targetTime, replay.NewEntityState(), opts)
+	out, _ := json.Marshal(result)
+	fmt.Println(string(out))
+	switch {
+	case errors.Is(err, replay.ErrStateMismatch):
+		exitWith(exitVerifyFailed, err)
+	case err != nil:
+		fatal(err)
+	}
+}
+
+func runReplayExport(args []string) {
+	fs := newFlagSet("replay export")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	targetTime := fs.Int64("time", 0, "unix timestamp of the target snapshot (seconds, 0=now)")
+	format := fs.String("format", "kafka-reset", "output format: kafka-reset (kafka-consumer-groups script) or kafka-reset-json (Admin API offsets)")
+	group := fs.String("group", "", "Kafka consumer group to reset")
+	position := fs.String("position", ledger.KafkaResetFirst, "reset to the first planned offset (first) or the one after the last (next)")
+	namespaces := fs.String("namespace", "", "comma-separated namespaces to export (default: all)")
+	execute := fs.Bool("execute", false, "make the script apply the reset instead of a dry run")
+	_ = fs.Parse(args)
+
+	if *format != "kafka-reset" && *format != "kafka-reset-json" {
+		usageFatal("--format must be kafka-reset or kafka-reset-json")
+	}
+	if *group == "" {
+		usageFatal("--group is required")
+	}
+	if *targetTime == 0 {
+		*targetTime = time.Now().Unix()
+	}
+
+	l, err := openLedger(*dbPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+
+	plan := ledger.New(l).ReconstructAtTime(*targetTime).ReplayPlan
+	if plan != nil && *namespaces != "" {
+		only := strings.Split(*namespaces, ",")
+		plan.Namespaces = slices.DeleteFunc(plan.Namespaces, func(ns ledger.NamespacePlan) bool { return !slices.Contains(only, ns.Namespace) })
+	}
+	reset, err := ledger.KafkaResetFromPlan(plan, *group, *position)
+	if err != nil {
+		usageFatal(err.Error())
+	}
+	if *format == "kafka-reset-json" {
+		out, _ := json.MarshalIndent(reset, "", "  ")
+		fmt.Println(string(out))
+		return
+	}
+	fmt.Print(reset.Script(*execute))
+}
+
+// shellCommand runs command with the platform shell.
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
+
+func runReplayProgress(args []string) {
+	fs := newFlagSet("replay progress")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	name := fs.String("name", "", "replay name (default: all replays)")
+	_ = fs.Parse(args)
+
+	l, err := openLedger(*dbPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+
+	progress, err := l.ReplayProgress(*name)
+	if err != nil {
+		fatal(err)
+	}
+	out, _ := json.Marshal(progress)
+	fmt.Println(string(out))
+}
+
+func runReplayReset(args []string) {
+	fs := newFlagSet("replay reset")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	name := fs.String("name", ledger.DefaultReplayName, "replay name")
+	namespace := fs.String("namespace", "", "namespace to reset (default: all)")
+	_ = fs.Parse(args)
+
+	l, err := openLedger(*dbPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+
+	if err := l.ResetReplayProgress(*name, *namespace); err != nil {
+		fatal(err)
+	}
+	fmt.Println("reset")
+}
+
+func runRecover(args []string) {
+	fs := newFlagSet("recover")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	apply := fs.Bool("apply", false, "remove the torn tail (default: report only)")
+	maxTail := fs.Int("max-tail", ledger.DefaultMaxTornTail, "refuse to remove more records than this")
+	quarantine := fs.String("quarantine", "", "file to save removed records to (default: <db>.torn-<unix time>.jsonl)")
+	_ = fs.Parse(args)
+
+	if *quarantine == "" {
+		*quarantine = fmt.Sprintf("%s.torn-%d.jsonl", *dbPath, time.Now().Unix())
+	}
+
+	l, err := openLedger(*dbPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+
+	report, err := l.Recover(ledger.RecoverOptions{Apply: *apply, MaxTail: *maxTail, QuarantinePath: *quarantine})
+	out, _ := json.Marshal(report)
+	fmt.Println(string(out))
+	if err != nil {
+		fatal(err)
+	}
+	if !report.Clean() && !*apply {
+		exitWith(exitVerifyFailed, errors.New("torn tail found; rerun with --apply to remove it"))
+	}
+}
+
+func runJournal(args []string) {
+	if len(args) == 0 {
+		usageFatal("journal subcommands: verify, rebuild")
+	}
+
+	switch args[0] {
+	case "verify":
+		runJournalVerify(args[1:])
+	case "rebuild":
+		runJournalRebuild(args[1:])
+	default:
+		usageFatal("unknown journal command")
+	}
+}
+
+func runJournalVerify(args []string) {
+	fs := newFlagSet("journal verify")
+	journalPath := fs.String("journal", "", "path to journal file")
+	dbPath := fs.String("db", "", "also compare the journal with this ledger database")
+	_ = fs.Parse(args)
+
+	if *journalPath == "" {
+		usageFatal("--journal is required")
+	}
+	var check ledger.JournalCheck
+	var err error
+	if *dbPath != "" {
+		l, openErr := openLedger(*dbPath)
+		if openErr != nil {
+			fatal(openErr)
+		}
+		defer l.Close()
+		check, err = ledger.CompareJournal(l, *journalPath)
+	} else {
+		check, err = ledger.VerifyJournal(*journalPath)
+	}
+	if err != nil {
+		fatal(err)
+	}
+	out, _ := json.Marshal(check)
+	fmt.Println(string(out))
+	if !check.OK {
+		exitWith(exitVerifyFailed, fmt.Errorf("journal check failed: %s", check.Reason))
+	}
+}
+
+func runJournalRebuild(args []string) {
+	fs := newFlagSet("journal rebuild")
+	journalPath := fs.String("journal", "", "path to journal file")
+	dbPath := fs.String("db", "", "path of the new ledger database to create")
+	_ = fs.Parse(args)
+
+	if *journalPath == "" || *dbPath == "" {
+		usageFatal("--journal and --db are required")
+	}
+	check, err := ledger.RebuildFromJournal(*journalPath, *dbPath)
+	if err != nil {
+		fatal(err)
+	}
+	out, _ := json.Marshal(check)
+	fmt.Println(string(out))
+}
+
+func runEncrypt(args []string) {
+	fs := newFlagSet("encrypt")
+	dbPath := fs.String("db", defaultDBPath(), "path to unencrypted ledger database")
+	out := fs.String("out", "", "path to write the encrypted copy")
+	_ = fs.Parse(args)
+
+	key := os.Getenv(dbKeyEnv)
+	if key == "" {
+		usageFatal(fmt.Sprintf("%s must be set to encrypt the database", dbKeyEnv))
+	}
+	if *out == "" {
+		usageFatal("--out is required")
+	}
+	if err := ledger.EncryptDatabase(*dbPath, *out, []byte(key)); err != nil {
+		fatal(err)
+	}
+	fmt.Println("encrypted", *out)
+}
+
+func runDecrypt(args []string) {
+	fs := newFlagSet("decrypt")
+	dbPath := fs.String("db", defaultDBPath(), "path to encrypted ledger database")
+	out := fs.String("out", "", "path to write the unencrypted copy")
+	_ = fs.Parse(args)
+
+	key := os.Getenv(dbKeyEnv)
+	if key == "" {
+		usageFatal(fmt.Sprintf("%s must be set to decrypt the database", dbKeyEnv))
+	}
+	if *out == "" {
+		usageFatal("--out is required")
+	}
+	if err := ledger.DecryptDatabase(*dbPath, *out, []byte(key)); err != nil {
+		fatal(err)
+	}
+	fmt.Println("decrypted", *out)
+}
+
+func runKeys(args []string) {
+	if len(args) == 0 {
+		usageFatal("keys subcommands: generate, public, rotate, list")
+	}
+
+	switch args[0] {
+	case "generate":
+		runKeysGenerate(args[1:])
+	case "public":
+		runKeysPublic(args[1:])
+	case "rotate":
+		runKeysRotate(args[1:])
+	case "list":
+		runKeysList(args[1:])
+	default:
+		usageFatal("unknown keys command")
+	}
+}
+
+func runKeysGenerate(args []string) {
+	fs := newFlagSet("keys generate")
+	out := fs.String("out", "", "file to write the base64 private key to, for "+signingKeyEnv)
+	_ = fs.Parse(args)
+
+	if *out == "" {
+		usageFatal("--out is required")
+	}
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		fatal(err)
+	}
+	if err := os.WriteFile(*out, []byte(base64.StdEncoding.EncodeToString(priv.Seed())+"\n"), 0o600); err != nil {
+		fatal(err)
+	}
+	fmt.Fprintln(os.Stderr, "written: "+*out)
+	res, _ := json.Marshal(map[string]string{
+		"key_id":     ledger.SigningKeyID(pub),
+		"public_key": base64.StdEncoding.EncodeToString(pub),
+	})
+	fmt.Println(string(res))
+}
+
+func runKeysPublic(args []string) {
+	fs := newFlagSet("keys public")
+	ref := fs.String("signer", os.Getenv(signerEnv), "signing key reference: awskms:ARN, gcpkms:RESOURCE or a pkcs11: URI")
+	_ = fs.Parse(args)
+
+	if *ref == "" {
+		usageFatal("--signer is required")
+	}
+	signer, err := ledger.OpenSigner(*ref)
+	if err != nil {
+		usageFatal(err.Error())
+	}
+	pub, err := signer.PublicKey(context.Background())
+	if err != nil {
+		fatal(err)
+	}
+	encoded, err := ledger.MarshalPublicKey(pub)
+	if err != nil {
+		fatal(err)
+	}
+	res, _ := json.Marshal(map[string]string{
+		"key_id":     ledger.SigningKeyID(pub),
+		"key_ref":    signer.KeyRef(),
+		"public_key": encoded,
+	})
+	fmt.Println(string(res))
+}
+
+// keyEnvs maps key purposes to the environment variable holding the
+// current key.
+var keyEnvs = map[string]string{
+	ledger.KeyArchive:  archiveKeyEnv,
+	ledger.KeyDatabase: dbKeyEnv,
+}
+
+func runKeysRotate(args []string) {
+	fs := newFlagSet("keys rotate")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	purpose := fs.String("purpose", "", "key to rotate: archive or database")
+	newKeyEnv := fs.String("new-key-env", "", "environment variable holding the new key")
+	_ = fs.Parse(args)
+
+	env, ok := keyEnvs[*purpose]
+	if !ok {
+		usageFatal("--purpose must be archive or database")
+	}
+	current := os.Getenv(env)
+	if current == "" {
+		usageFatal(fmt.Sprintf("%s must be set to the current %s key", env, *purpose))
+	}
+	if *newKeyEnv == "" {
+		usageFatal("--new-key-env is required")
+	}
+	next := os.Getenv(*newKeyEnv)
+	if next == "" {
+		usageFatal(fmt.Sprintf("%s is empty", *newKeyEnv))
+	}
+
+	l, err := openLedger(*dbPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+
+	if *purpose == ledger.KeyArchive {
+		// Segments signed so far must verify before their key is retired.
+		setArchiveKeys(l)
+		result, err := l.VerifyChain()
+		if err != nil {
+			fatal(err)
+		}
+		if !result.OK {
+			exitWith(exitVerifyFailed, fmt.Errorf("chain verification failed at record %d: %s", result.FailedID, result.Reason))
+		}
+	}
+
+	kv, err := l.RotateKey(*purpose, []byte(current), []byte(next))
+	if err != nil {
+		fatal(err)
+	}
+	out, _ := json.Marshal(kv)
+	fmt.Println(string(out))
+	if *purpose == ledger.KeyArchive {
+		fmt.Fprintf(os.Stderr, "set %s to the new key and add the old key to %s\n", archiveKeyEnv, archiveRetiredKeysEnv)
+	} else {
+		fmt.Fprintf(os.Stderr, "set %s to the new key\n", dbKeyEnv)
+	}
+}
+
+func runKeysList(args []string) {
+	fs := newFlagSet("keys list")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	purpose := fs.String("purpose", ledger.KeyArchive, "key purpose: archive or database")
+	_ = fs.Parse(args)
+
+	if _, ok := keyEnvs[*purpose]; !ok {
+		usageFatal("--purpose must be archive or database")
+	}
+
+	l, err := openLedger(*dbPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+
+	versions, err := l.KeyVersions(*purpose)
+	if err != nil {
+		fatal(err)
+	}
+	out, _ := json.Marshal(versions)
+	fmt.Println(string(out))
+}
+
+func runServer(args []string) {
+	fs := newFlagSet("server")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	addr := fs.String("addr", config.DefaultAddr, "server address (host:port)")
+	cacheTTL := fs.Duration("cache-ttl", config.DefaultCacheTTL, "read cache TTL (0 disables the cache)")
+	tlsCert := fs.String("tls-cert", "", "serve HTTPS with this certificate (PEM)")
+	tlsKey := fs.String("tls-key", "", "private key for --tls-cert (PEM)")
+	clientCA := fs.String("client-ca", "", "CA that issues agent client certificates (PEM); enables agent ingestion")
+	mirrorPath := fs.String("mirror-db", "", "also write every record to this secondary ledger database")
+	journalPath := fs.String("journal", "", "also write every record to this append-only journal file")
+	ha := fs.Bool("ha", false, "elect a leader among replicas sharing --db; only the leader runs background jobs")
+	replicaID := fs.String("replica-id", "", "this replica's lease holder ID (default: hostname-pid)")
+	leaseTTL := fs.Duration("lease-ttl", ledger.DefaultLeaseTTL, "leader lease duration")
+	verifyInterval := fs.Duration("verify-interval", 0, "verify the hash chain on the leader this often (0 disables)")
+	recordIDs := fs.String("record-ids", "", "also give every appended record a globally unique ID: ulid or uuidv7")
+	appendSLO := fs.Duration("append-slo", 0, "append latency objective; batch appends are refused with 503 and Retry-After while the recent p95 exceeds it (0 disables)")
+	queryBudget := fs.Duration("query-budget", config.DefaultQueryBudget, "time a list or snapshot request may spend reading; longer reads return a truncated page with a cursor (0 disables)")
+	alertInterval := fs.Duration("alert-interval", 0, "check for config drift, overdue sources, offline agents, low determinism and mutation rate anomalies on the leader this often (0 disables)")
+	agentOffline := fs.Duration("agent-offline-after", config.DefaultAgentOfflineAfter, "report an agent offline after this long without a heartbeat or record (0 disables)")
+	minScore := fs.Float64("min-determinism-score", 0, "report the determinism score dropping below this (0-100, 0 disables)")
+	anomalyWindow := fs.Duration("anomaly-window", 0, "report mutation rates per source and type that deviate sharply from the previous 12 windows of this length (0 disables)")
+	anomalySensitivity := fs.Float64("anomaly-sensitivity", ledger.DefaultAnomalySensitivity, "standard deviations from its baseline a mutation rate must be to be reported")
+	notifySlack := fs.String("notify-slack", "", "Slack incoming webhook URL for high-severity events")
+	notifyTeams := fs.String("notify-teams", "", "Microsoft Teams incoming webhook URL for high-severity events")
+	notifyTemplates := fs.String("notify-templates", "", "JSON file mapping event types to message templates")
+	notifySeverity := fs.String("notify-min-severity", ledger.SeverityWarning, "lowest severity sent to notifiers (info, warning, critical)")
+	digestInterval := fs.Duration("digest-interval", 0, "email a digest of each period's activity on the leader, e.g. 24h or 168h (0 disables)")
+	anchorTo := fs.String("anchor-to", "", "comma-separated witnesses the chain head is published to (see `stateledger anchor`)")
+	anchorInterval := fs.Duration("anchor-interval", time.Hour, "with --anchor-to: publish the chain head on the leader this often")
+	smtpConfig := smtpFlags(fs)
+	readReplicas := fs.String("read-replicas", "", "comma-separated DSNs of read-only database copies to serve reads from")
+	insecureNoAuth := fs.Bool("insecure-no-auth", false, "serve appends and other writes without an API key; without it the server only serves reads")
+	configPath := fs.String("config", "", "YAML config file replacing the other flags; reloaded on SIGHUP")
+	_ = fs.Parse(args)
+
+	if *configPath != "" {
+		if fs.NFlag() > 1 {
+			usageFatal("--config cannot be combined with other flags")
+		}
+		runConfigured(*configPath, false)
+		return
+	}
+
+	if (*tlsCert == "") != (*tlsKey == "") {
+		usageFatal("--tls-cert and --tls-key must be used together")
+	}
+	if *clientCA != "" && *tlsCert == "" {
+		usageFatal("--client-ca requires --tls-cert")
+	}
+	smtp := smtpConfig()
+	if *digestInterval > 0 && smtp.Addr == "" {
+		usageFatal("--digest-interval requires --smtp-addr, --smtp-from and --smtp-to")
+	}
+
+	cfg := config.Config{
+		DB: *dbPath,
+		Server: config.Server{
+			Addr:                *addr,
+			CacheTTL:            config.Duration(*cacheTTL),
+			TLSCert:             *tlsCert,
+			TLSKey:              *tlsKey,
+			ClientCA:            *clientCA,
+			MirrorDB:            *mirrorPath,
+			Journal:             *journalPath,
+			HA:                  *ha,
+			ReplicaID:           *replicaID,
+			LeaseTTL:            config.Duration(*leaseTTL),
+			VerifyInterval:      config.Duration(*verifyInterval),
+			AppendSLO:           config.Duration(*appendSLO),
+			QueryBudget:         config.Duration(*queryBudget),
+			RecordIDs:           *recordIDs,
+			AlertInterval:       config.Duration(*alertInterval),
+			AgentOfflineAfter:   config.Duration(*agentOffline),
+			MinDeterminismScore: *minScore,
+			AnomalyWindow:       config.Duration(*anomalyWindow),
+			AnomalySensitivity:  *anomalySensitivity,
+		},
+		Auth:   config.Auth{InsecureNoAuth: *insecureNoAuth},
+		Notify: config.Notify{Slack: *notifySlack, Teams: *notifyTeams, Templates: *notifyTemplates, MinSeverity: *notifySeverity},
+		Digest: config.Digest{Interval: config.Duration(*digestInterval), SMTPAddr: smtp.Addr, SMTPFrom: smtp.From, SMTPTo: smtp.To, SMTPUser: smtp.Username},
+	}
+	if *readReplicas != "" {
+		cfg.Server.ReadReplicas = strings.Split(*readReplicas, ",")
+	}
+	if *anchorTo != "" {
+		cfg.Anchor = config.Anchor{Interval: config.Duration(*anchorInterval), Witnesses: strings.Split(*anchorTo, ",")}
+	}
+
+	l, err := openLedger(cfg.DB)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+	server := startServer(l, cfg)
+	if err := server.SyncWebhooks(); err != nil {
+		fatal(err)
+	}
+	if err := listen(server, cfg.Server); err != nil {
+		fatal(err)
+	}
+}
+
+// startServer configures l and an API server as cfg describes and starts
+// the server's background jobs. The caller starts listening.
+func startServer(l *ledger.Ledger, cfg config.Config) *api.Server {
+	if l.Encrypted() {
+		// The server only stops on a signal; flush the encrypted database
+		// before exiting so the last writes are not lost.
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-sigs
+			if err := l.Close(); err != nil {
+				fatal(err)
+			}
+			os.Exit(exitOK)
+		}()
+	}
+
+	if err := l.CheckGuard(); err != nil {
+		fatal(fmt.Errorf("%w; run `stateledger init --db %s` to install it", err, cfg.DB))
+	}
+	if cfg.Server.CacheTTL > 0 {
+		l.EnableReadCache(time.Duration(cfg.Server.CacheTTL))
+	}
+	if len(cfg.Server.ReadReplicas) > 0 {
+		if err := l.SetReadReplicas(cfg.Server.ReadReplicas...); err != nil {
+			fatal(err)
+		}
+	}
+	setArchiveKeys(l)
+	l.SetArtifactStore(cfg.Artifacts)
+	l.SetAppendSLO(time.Duration(cfg.Server.AppendSLO))
+	gen, err := ledger.IDGeneratorByName(cfg.Server.RecordIDs)
+	if err != nil {
+		fatal(err)
+	}
+	if err := l.SetIDGenerator(gen); err != nil {
+		fatal(err)
+	}
+
+	server := api.NewServer(l, cfg.Server.Addr)
+	if len(cfg.Auth.APIKeys) == 0 {
+		if cfg.Auth.InsecureNoAuth {
+			fmt.Fprintln(os.Stderr, "warning: serving writes without authentication")
+		} else {
+			fmt.Fprintln(os.Stderr, "no API keys configured; serving reads only (set auth.api_keys, or pass --insecure-no-auth to allow unauthenticated writes)")
+		}
+	}
+	server.SetPolicy(api.Policy{InsecureNoAuth: cfg.Auth.InsecureNoAuth})
+	server.SetQueryBudget(time.Duration(cfg.Server.QueryBudget))
+	for _, n := range notifiers(map[string]string{ledger.NotifierSlack: cfg.Notify.Slack, ledger.NotifierTeams: cfg.Notify.Teams}, cfg.Notify.Templates, cfg.Notify.MinSeverity) {
+		server.AddNotifier(n)
+	}
+	if cfg.Server.MirrorDB != "" {
+		// The secondary stays open for the life of the process.
+		secondary := openSecondary(cfg.Server.MirrorDB)
+		l.SetMirror(secondary, func(d ledger.Divergence) {
+			fmt.Fprintf(os.Stderr, "mirror divergence (%s) at record %d: %s\n", d.Kind, d.PrimaryID, d.Error)
+			server.Publish(ledger.WebhookEvent{EventType: ledger.EventMirrorDivergence, Timestamp: time.Now(), Data: d})
+		})
+		if n, err := l.SyncMirror(); err != nil {
+			fmt.Fprintln(os.Stderr, "mirror: "+err.Error())
+		} else if n > 0 {
+			fmt.Fprintf(os.Stderr, "mirror: backfilled %d records\n", n)
+		}
+	}
+	if cfg.Server.Journal != "" {
+		if err := l.SetJournal(cfg.Server.Journal); err != nil {
+			fatal(fmt.Errorf("%w; check it with `stateledger journal verify --journal %s --db %s`", err, cfg.Server.Journal, cfg.DB))
+		}
+	}
+	ctx := context.Background()
+	if cfg.Server.HA {
+		replicaID := cfg.Server.ReplicaID
+		if replicaID == "" {
+			host, _ := os.Hostname()
+			replicaID = fmt.Sprintf("%s-%d", host, os.Getpid())
+		}
+		leaseTTL := time.Duration(cfg.Server.LeaseTTL)
+		if leaseTTL <= 0 {
+			leaseTTL = ledger.DefaultLeaseTTL
+		}
+		elector := &ledger.Elector{
+			Ledger:   l,
+			ID:       replicaID,
+			TTL:      leaseTTL,
+			OnChange: func(leader bool) { fmt.Fprintf(os.Stderr, "replica %s leader=%t\n", replicaID, leader) },
+			OnError:  func(err error) { fmt.Fprintln(os.Stderr, "leader lease: "+err.Error()) },
+		}
+		server.SetElector(elector)
+		go elector.Run(ctx)
+	}
+	if cfg.Server.VerifyInterval > 0 {
+		go server.RunVerifier(ctx, time.Duration(cfg.Server.VerifyInterval))
+	}
+	if cfg.Digest.Interval > 0 {
+		smtp := ledger.SMTPConfig{Addr: cfg.Digest.SMTPAddr, From: cfg.Digest.SMTPFrom, To: cfg.Digest.SMTPTo, Username: cfg.Digest.SMTPUser, Password: os.Getenv(smtpPasswordEnv)}
+		go server.RunDigest(ctx, time.Duration(cfg.Digest.Interval), smtp)
+	}
+	if cfg.Server.AlertInterval > 0 {
+		monitor := &ledger.AlertMonitor{
+			Ledger:              l,
+			AgentOfflineAfter:   time.Duration(cfg.Server.AgentOfflineAfter),
+			MinDeterminismScore: cfg.Server.MinDeterminismScore,
+			AnomalyWindow:       time.Duration(cfg.Server.AnomalyWindow),
+			AnomalySensitivity:  cfg.Server.AnomalySensitivity,
+		}
+		go server.RunAlerts(ctx, time.Duration(cfg.Server.AlertInterval), monitor)
+	}
+	if cfg.Anchor.Interval > 0 {
+		go server.RunAnchor(ctx, time.Duration(cfg.Anchor.Interval), openWitnesses(cfg.Anchor.Witnesses))
+	}
+	return server
+}
+
+// defaultConfigPath is where all-in-one looks for its config file.
+const defaultConfigPath = "stateledger.yaml"
+
+// runAllInOne runs the API server, scheduled manifest captures and
+// retention archiving in one process, configured by a YAML file. Scheduled
+// jobs run on the leader only, so several all-in-one replicas can share a
+// database with server.ha enabled.
+func runAllInOne(args []string) {
+	fs := newFlagSet("all-in-one")
+	configPath := fs.String("config", defaultConfigPath, "YAML config file")
+	_ = fs.Parse(args)
+
+	runConfigured(*configPath, true)
+}
+
+// runConfigured runs the server configured by the file at path, reloading
+// it on SIGHUP. allInOne also initializes the ledger and runs the capture
+// schedule.
+func runConfigured(path string, allInOne bool) {
+	cfg, err := config.Load(path)
+	if err != nil {
+		usageFatal(err.Error())
+	}
+	if !allInOne && len(cfg.Schedule) > 0 {
+		usageFatal(path + ": schedule is only run by `stateledger all-in-one`")
+	}
+	manifests := make([]manifest.Manifest, len(cfg.Schedule))
+	for i, job := range cfg.Schedule {
+		if manifests[i], err = manifest.LoadManifest(job.Manifest); err != nil {
+			usageFatal(fmt.Sprintf("schedule %s: %s: %s", job.Name, job.Manifest, err))
+		}
+	}
+	archiveKey := os.Getenv(archiveKeyEnv)
+	if cfg.Retention.ArchiveAfter > 0 && archiveKey == "" {
+		usageFatal(fmt.Sprintf("%s must be set to sign archive segments", archiveKeyEnv))
+	}
+
+	if allInOne {
+		if err := os.MkdirAll(filepath.Dir(cfg.DB), 0o755); err != nil {
+			fatal(err)
+		}
+		if err := os.MkdirAll(cfg.Artifacts, 0o755); err != nil {
+			fatal(err)
+		}
+	}
+	l, err := openLedger(cfg.DB)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+	if allInOne {
+		if err := l.InitSchema(); err != nil {
+			fatal(err)
+		}
+	}
+
+	server := startServer(l, cfg)
+	live := &liveConfig{path: path, server: server, ledger: l}
+	if err := live.apply(cfg); err != nil {
+		fatal(err)
+	}
+	go live.reloadOnSignal()
+
+	ctx := context.Background()
+	for i, job := range cfg.Schedule {
+		m := manifests[i]
+		capture := func(context.Context) error {
+			return captureManifest(m, job.Source, job.AgentID, func(in ledger.RecordInput) (any, error) {
+				return l.Append(in)
+			}, func(any) {})
+		}
+		if job.RunAtStart && server.IsLeader() {
+			if err := capture(ctx); err != nil {
+				fmt.Fprintf(os.Stderr, "schedule %s: %s\n", job.Name, err)
+			}
+		}
+		go server.RunLeaderJob(ctx, "capture:"+job.Name, time.Duration(job.Interval), capture)
+	}
+	if cfg.Retention.ArchiveAfter > 0 {
+		go server.RunLeaderJob(ctx, "retention", time.Duration(cfg.Retention.Interval), func(context.Context) error {
+			// Read the settings on each run so a reload applies to the next one.
+			retention := live.current().Retention
+			if retention.ArchiveAfter <= 0 {
+				return nil
+			}
+			store, err := ledger.OpenArchiveStore(retention.To)
+			if err != nil {
+				return err
+			}
+			result, err := l.Archive(store, ledger.ArchiveOptions{
+				Before:      time.Now().Add(-time.Duration(retention.ArchiveAfter)).Unix(),
+				SegmentSize: retention.SegmentSize,
+				Key:         []byte(archiveKey),
+			})
+			if err == nil && result.Archived > 0 {
+				fmt.Fprintf(os.Stderr, "retention: archived %d records to %s\n", result.Archived, result.Location)
+			}
+			if err == nil && result.HeldBy != "" {
+				fmt.Fprintf(os.Stderr, "retention: stopped at records under legal hold %s\n", result.HeldBy)
+			}
+			return err
+		})
+	}
+
+	if allInOne {
+		fmt.Fprintf(os.Stderr, "all-in-one: serving on %s with %d scheduled captures\n", cfg.Server.Addr, len(cfg.Schedule))
+	}
+	if err := listen(server, cfg.Server); err != nil {
+		fatal(err)
+	}
+}
+
+// liveConfig is the config a running server was last loaded or reloaded
+// with.
+type liveConfig struct {
+	path   string
+	server *api.Server
+	ledger *ledger.Ledger
+
+	mu  sync.Mutex
+	cfg config.Config
+}
+
+func (c *liveConfig) current() config.Config {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cfg
+}
+
+// apply installs the request policy and webhook subscriptions of next.
+// Subscriptions from the previous config that next drops or changes are
+// removed; subscriptions created through the API are left alone. Every
+// subscription is then revoked or restored as the ledger records it. A
+// retention change must match an approved retention request, which it
+// uses up.
+func (c *liveConfig) apply(next config.Config) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.cfg.Auth.RequireApproval && !next.Auth.RequireApproval {
+		return errors.New("auth.require_approval can only be turned off by a restart")
+	}
+	if next.Retention != c.cfg.Retention {
+		if err := c.consumeRetentionApproval(next.Retention); err != nil {
+			return err
+		}
+	}
+
+	c.server.SetPolicy(api.Policy{
+		APIKeys:         next.Auth.APIKeys,
+		AdminKeys:       next.Auth.AdminKeys,
+		InsecureNoAuth:  next.Auth.InsecureNoAuth,
+		ApprovalWindow:  time.Duration(next.Auth.ApprovalWindow),
+		RateLimit:       next.RateLimit.RequestsPerSecond,
+		RateBurst:       next.RateLimit.Burst,
+		SharedRateLimit: next.RateLimit.Shared,
+		OnRateLimitError: func(err error) {
+			fmt.Fprintln(os.Stderr, "rate limit store: "+err.Error())
+		},
+		LogRequests: next.Log.Requests,
+		LogFormat:   next.Log.Format,
+		LogOutput:   os.Stderr,
+	})
+
+	if _, err := c.ledger.SetPolicies(policySet(next.Policies)); err != nil {
+		return fmt.Errorf("policies: %w", err)
+	}
+
+	webhooks := c.server.Webhooks()
+	keep := map[string]bool{}
+	for _, w := range next.Webhooks {
+		for _, old := range c.cfg.Webhooks {
+			if old.ID == w.ID && reflect.DeepEqual(old, w) {
+				keep[w.ID] = true
+			}
+		}
+	}
+	for _, old := range c.cfg.Webhooks {
+		if !keep[old.ID] {
+			_ = webhooks.Unsubscribe(old.ID)
+		}
+	}
+	for _, w := range next.Webhooks {
+		if keep[w.ID] {
+			continue
+		}
+		if err := webhooks.Subscribe(w.ID, w.URL, w.Events, w.Secret); err != nil {
+			return fmt.Errorf("webhook %s: %w", w.ID, err)
+		}
+	}
+	if err := c.server.SyncWebhooks(); err != nil {
+		return err
+	}
+	c.cfg = next
+	return nil
+}
+
+// consumeRetentionApproval uses up an approved retention request whose
+// params are the retention settings want.
+func (c *liveConfig) consumeRetentionApproval(want config.Retention) error {
+	reqs, err := c.ledger.AdminRequests()
+	if err != nil {
+		return err
+	}
+	match := func(params json.RawMessage) bool {
+		var got config.Retention
+		if json.Unmarshal(params, &got) != nil {
+			return false
+		}
+		if got.Interval == 0 {
+			got.Interval = config.Duration(config.DefaultRetentionInterval)
+		}
+		if got.SegmentSize == 0 {
+			got.SegmentSize = config.DefaultSegmentSize
+		}
+		return got == want
+	}
+	for _, req := range reqs {
+		if req.Action != ledger.AdminActionRetention || req.Status != "approved" || !match(req.Params) {
+			continue
+		}
+		if _, err := c.ledger.ConsumeApproval(req.ID, ledger.AdminActionRetention, "config-reload", match); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("retention change: %w; request it with POST /api/v1/admin/requests and have a second admin key approve it", ledger.ErrNotApproved)
+}
+
+// reloadOnSignal reloads the config file on each SIGHUP. An invalid file
+// is reported and the running config kept. Open connections are not
+// interrupted.
+func (c *liveConfig) reloadOnSignal() {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	for range sighup {
+		next, err := config.Load(c.path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "config reload: "+err.Error())
+			continue
+		}
+		if c.current().RestartRequired(next) {
+			fmt.Fprintln(os.Stderr, "config reload: some changed settings take effect on restart")
+		}
+		if err := c.apply(next); err != nil {
+			fmt.Fprintln(os.Stderr, "config reload: "+err.Error())
+			continue
+		}
+		fmt.Fprintln(os.Stderr, "config reloaded from "+c.path)
+	}
+}
+
+// listen serves the API over HTTP, or HTTPS when a certificate is set.
+func listen(server *api.Server, cfg config.Server) error {
+	if cfg.TLSCert != "" {
+		return server.StartTLS(cfg.TLSCert, cfg.TLSKey, cfg.ClientCA)
+	}
+	return server.Start()
+}
+
+// smtpPasswordEnv names the environment variable holding the SMTP password,
+// so it does not appear in process listings.
+const smtpPasswordEnv = "STATELEDGER_SMTP_PASSWORD"
+
+// smtpFlags registers the SMTP flags on fs; call the returned function after
+// parsing.
+func smtpFlags(fs *flag.FlagSet) func() ledger.SMTPConfig {
+	addr := fs.String("smtp-addr", "", "SMTP server (host:port) digests are sent through")
+	from := fs.String("smtp-from", "", "digest sender address")
+	to := fs.String("smtp-to", "", "comma-separated digest recipients")
+	user := fs.String("smtp-user", "", "SMTP username (password from "+smtpPasswordEnv+")")
+	return func() ledger.SMTPConfig {
+		cfg := ledger.SMTPConfig{Addr: *addr, From: *from, Username: *user, Password: os.Getenv(smtpPasswordEnv)}
+		if *to != "" {
+			cfg.To = strings.Split(*to, ",")
+		}
+		return cfg
+	}
+}
+
+// tzFlag registers the --tz flag of commands that print times; call the
+// returned function after parsing. Only printing uses the zone: --since,
+// --until and stored timestamps stay Unix seconds.
+func tzFlag(fs *flag.FlagSet) func() *time.Location {
+	name := fs.String("tz", "", "time zone of printed times: UTC (default), Local, an offset such as +05:30 or an IANA name such as Europe/Berlin")
+	return func() *time.Location {
+		loc, err := ledger.ParseTimeZone(*name)
+		if err != nil {
+			usageFatal(err.Error())
+		}
+		return loc
+	}
+}
+
+// labelFlag adds a repeatable --label flag and returns the labels it set,
+// or nil when it was not given.
+func labelFlag(fs *flag.FlagSet, usage string) func() map[string]string {
+	var terms []string
+	fs.Func("label", usage, func(v string) error {
+		terms = append(terms, v)
+		return nil
+	})
+	return func() map[string]string {
+		if len(terms) == 0 {
+			return nil
+		}
+		labels, err := ledger.ParseLabelSelector(strings.Join(terms, ","))
+		if err != nil {
+			usageFatal(err.Error())
+		}
+		return labels
+	}
+}
+
+func runDigest(args []string) {
+	fs := newFlagSet("digest")
+	dbPath := fs.String("db", defaultDBPath(), "path to ledger database")
+	period := fs.Duration("period", 24*time.Hour, "period the digest covers, ending now")
+	format := fs.String("format", "text", "output format when not mailing (text, json)")
+	send := fs.Bool("send", false, "mail the digest instead of printing it")
+	smtpConfig := smtpFlags(fs)
+	timeZone := tzFlag(fs)
+	_ = fs.Parse(args)
+	loc := timeZone()
+
+	l, err := openLedger(*dbPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+
+	now := time.Now()
+	digest, err := l.Digest(now.Add(-*period).Unix(), now.Unix())
+	if err != nil {
+		fatal(err)
+	}
+	switch {
+	case *send:
+		if err := ledger.SendDigest(smtpConfig(), digest); err != nil {
+			fatal(err)
+		}
+		fmt.Println("sent")
+	case *format == "json":
+		out, _ := json.Marshal(digest)
+		fmt.Println(string(out))
+	default:
+		fmt.Print(digest.TextIn(loc))
+	}
+}
+
+// notifiers builds a chat notifier for each configured URL, keyed by kind.
+func notifiers(urls map[string]string, templatesFile, minSeverity string) []*ledger.Notifier {
+	switch minSeverity {
+	case ledger.SeverityInfo, ledger.SeverityWarning, ledger.SeverityCritical:
+	default:
+		usageFatal(fmt.Sprintf("unknown severity %q", minSeverity))
+	}
+	var templates map[string]string
+	if templatesFile != "" {
+		data, err := os.ReadFile(templatesFile)
+		if err != nil {
+			fatal(err)
+		}
+		if err := json.Unmarshal(data, &templates); err != nil {
+			fatal(fmt.Errorf("%s: %w", templatesFile, err))
+		}
+	}
+
+	var out []*ledger.Notifier
+	for _, kind := range []string{ledger.NotifierSlack, ledger.NotifierTeams} {
+		if urls[kind] == "" {
+			continue
+		}
+		n, err := ledger.NewNotifier(kind, urls[kind], templates)
+		if err != nil {
+			fatal(err)
+		}
+		n.MinSeverity = minSeverity
+		n.OnError = func(event ledger.WebhookEvent, err error) {
+			fmt.Fprintf(os.Stderr, "%s notifier: %s: %v\n", kind, event.EventType, err)
+		}
+		out = append(out, n)
+	}
+	return out
+}
+
+// Exit codes. Scripts and CI steps branch on these, so they must not
+// change meaning.
+const (
+	exitOK           = 0
+	exitError        = 1 // runtime error: I/O, database, network
+	exitUsage        = 2 // bad command line or missing configuration
+	exitVerifyFailed = 3 // a chain, journal, mirror, spool or anchor check failed
+	exitPolicy       = 4 // the command was refused by a safety policy
+	exitStale        = 5 // watchdog: captures are overdue or the ledger has gone quiet
+	exitDiskLow      = 6 // watchdog: the database is running out of disk space
+)
+
+// exitKinds names each exit code in --json-errors output.
+var exitKinds = map[int]string{
+	exitError:        "error",
+	exitUsage:        "usage",
+	exitVerifyFailed: "verification_failed",
+	exitPolicy:       "policy_violation",
+	exitStale:        "stale_capture",
+	exitDiskLow:      "disk_low",
+}
+
+// jsonErrors is set by the global --json-errors option.
+var jsonErrors bool
+
+// cliError is an error as printed to stderr with --json-errors.
+type cliError struct {
+	Code  int    `json:"code"`
+	Kind  string `json:"kind"`
+	Error string `json:"error"`
+}
+
+// fatal exits with the code for err: verification failures and policy
+// refusals reported by the ledger keep their own codes.
+func fatal(err error) {
+	exitWith(exitCode(err), err)
+}
+
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, ledger.ErrGuardMissing),
+		errors.Is(err, ledger.ErrJournalDiverged),
+		errors.Is(err, ledger.ErrMirrorDiverged),
+		errors.Is(err, ledger.ErrInvalidProof),
+		errors.Is(err, agent.ErrSpoolTampered):
+		return exitVerifyFailed
+	case errors.Is(err, ledger.ErrRecoveryRefused), errors.Is(err, ledger.ErrNotApproved), errors.Is(err, ledger.ErrApprovalNotFound):
+		return exitPolicy
+	}
+	return exitError
+}
+
+// usageFatal reports a bad command line.
+func usageFatal(msg string) {
+	exitWith(exitUsage, errors.New(msg))
+}
+
+// commandUsage reports a missing or unknown command, with the command list
+// unless errors are printed as JSON.
+func commandUsage(msg string) {
+	if !jsonErrors {
+		printUsage()
+		os.Exit(exitUsage)
+	}
+	usageFatal(msg)
+}
+
+// exitWith prints err to stderr, as a cliError with --json-errors, and
+// exits with code.
+func exitWith(code int, err error) {
+	if jsonErrors {
+		out, _ := json.Marshal(cliError{Code: code, Kind: exitKinds[code], Error: err.Error()})
+		fmt.Fprintln(os.Stderr, string(out))
+	} else {
+		fmt.Fprintln(os.Stderr, err.Error())
+	}
+	os.Exit(code)
+}
+
+// newFlagSet returns a flag set for a command. Flag errors exit with
+// exitUsage; with --json-errors they are printed as a cliError.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	if jsonErrors {
+		var msg bytes.Buffer
+		fs.SetOutput(&msg)
+		fs.Usage = func() {
+			if msg.Len() == 0 {
+				// -h: print the flags as usual.
+				fs.SetOutput(os.Stderr)
+				fs.PrintDefaults()
+				return
+			}
+			line, _, _ := strings.Cut(msg.String(), "\n")
+			usageFatal(line)
+		}
+	}
+	return fs
 }
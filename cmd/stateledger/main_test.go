@@ -2,11 +2,19 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/Retr0-XD/StateLedger/internal/api"
+	"github.com/Retr0-XD/StateLedger/internal/config"
+	"github.com/Retr0-XD/StateLedger/internal/ledger"
 )
 
 // TestCLIWorkflow tests the full CLI workflow end-to-end
@@ -163,6 +171,36 @@ func TestCLIWorkflow(t *testing.T) {
 		}
 	})
 
+	t.Run("append from stdin and export", func(t *testing.T) {
+		cmd := exec.Command(binaryPath, "append", "-db", dbPath, "-type", "deploy", "-source", "pipe", "-payload-file", "-")
+		cmd.Stdin = strings.NewReader(`{"version":"1.2.3"}`)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("append from stdin failed: %v\n%s", err, output)
+		}
+
+		cmd = exec.Command(binaryPath, "export", "-db", dbPath, "-type", "deploy")
+		var stderr strings.Builder
+		cmd.Stderr = &stderr
+		output, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("export failed: %v\n%s", err, stderr.String())
+		}
+		lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+		if len(lines) != 1 {
+			t.Fatalf("stdout should hold only the exported record, got:\n%s", output)
+		}
+		var record map[string]any
+		if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+			t.Fatalf("export line should be valid JSON: %v", err)
+		}
+		if record["payload"] != `{"version":"1.2.3"}` || record["source"] != "pipe" {
+			t.Errorf("unexpected record: %s", lines[0])
+		}
+		if !strings.Contains(stderr.String(), `"exported":1`) {
+			t.Errorf("summary should go to stderr, got %q", stderr.String())
+		}
+	})
+
 	t.Run("verify chain", func(t *testing.T) {
 		cmd := exec.Command(binaryPath, "verify", "-db", dbPath)
 		output, err := cmd.CombinedOutput()
@@ -323,4 +361,195 @@ func TestCLIErrorHandling(t *testing.T) {
 		}
 		_ = output // Error is expected
 	})
+
+	exitCode := func(t *testing.T, err error) int {
+		t.Helper()
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			t.Fatalf("expected exit error, got %v", err)
+		}
+		return exitErr.ExitCode()
+	}
+
+	t.Run("usage errors exit 2", func(t *testing.T) {
+		for _, args := range [][]string{
+			{},
+			{"bogus"},
+			{"collect", "-db", "/tmp/test.db"},
+			{"verify", "-nope"},
+			{"journal"},
+			{"all-in-one", "-config", "/nonexistent/stateledger.yaml"},
+		} {
+			_, err := exec.Command(binaryPath, args...).CombinedOutput()
+			if code := exitCode(t, err); code != 2 {
+				t.Errorf("%v: exit code %d, want 2", args, code)
+			}
+		}
+	})
+
+	t.Run("json errors", func(t *testing.T) {
+		cmd := exec.Command(binaryPath, "--json-errors", "collect", "-db", "/tmp/test.db")
+		var stderr strings.Builder
+		cmd.Stderr = &stderr
+		err := cmd.Run()
+		if code := exitCode(t, err); code != 2 {
+			t.Fatalf("exit code %d, want 2", code)
+		}
+		var cliErr struct {
+			Code  int    `json:"code"`
+			Kind  string `json:"kind"`
+			Error string `json:"error"`
+		}
+		if err := json.Unmarshal([]byte(stderr.String()), &cliErr); err != nil {
+			t.Fatalf("stderr is not JSON: %q", stderr.String())
+		}
+		if cliErr.Code != 2 || cliErr.Kind != "usage" || !strings.Contains(cliErr.Error, "kind") {
+			t.Errorf("unexpected error object: %+v", cliErr)
+		}
+	})
+
+	t.Run("verification failure exits 3", func(t *testing.T) {
+		journal := filepath.Join(tmpDir, "bad.journal")
+		line := `{"id":1,"timestamp":1,"type":"code","source":"s","payload":"{}","hash":"bad","prev_hash":""}` + "\n"
+		if err := os.WriteFile(journal, []byte(line), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		cmd := exec.Command(binaryPath, "--json-errors", "journal", "verify", "-journal", journal)
+		var stderr strings.Builder
+		cmd.Stderr = &stderr
+		err := cmd.Run()
+		if code := exitCode(t, err); code != 3 {
+			t.Fatalf("exit code %d, want 3", code)
+		}
+		if !strings.Contains(stderr.String(), `"kind":"verification_failed"`) {
+			t.Errorf("stderr = %q", stderr.String())
+		}
+	})
+
+	t.Run("runtime error exits 1", func(t *testing.T) {
+		_, err := exec.Command(binaryPath, "manifest", "run", "-db", "/tmp/test.db", "-file", "/nonexistent/manifest.json").CombinedOutput()
+		if code := exitCode(t, err); code != 1 {
+			t.Errorf("exit code %d, want 1", code)
+		}
+	})
+}
+
+// TestCLIApprovals tests that commands removing or rewriting records run
+// only with an approved request for their params
+func TestCLIApprovals(t *testing.T) {
+	tmpDir := t.TempDir()
+	binaryPath := filepath.Join(tmpDir, "stateledger")
+
+	buildCmd := exec.Command("go", "build", "-o", binaryPath, ".")
+	if output, err := buildCmd.CombinedOutput(); err != nil {
+		t.Fatalf("Failed to build binary: %v\n%s", err, output)
+	}
+
+	dbPath := filepath.Join(tmpDir, "ledger.db")
+	l, err := ledger.Open(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.InitSchema(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Append(ledger.RecordInput{Timestamp: 1000, Type: "deploy", Source: "ci", Payload: "v1"}); err != nil {
+		t.Fatal(err)
+	}
+	pending, err := l.RequestAdminAction(ledger.AdminActionPrune, json.RawMessage(`{"max_age":"1h"}`), "alice", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	redact, err := l.RequestAdminAction(ledger.AdminActionRedact, json.RawMessage(`{"id":1,"reason":"erasure 17"}`), "alice", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.ApproveAdminAction(redact.ID, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	run := func(args ...string) (int, string) {
+		t.Helper()
+		cmd := exec.Command(binaryPath, append(args, "--db", dbPath)...)
+		cmd.Env = append(os.Environ(), "STATELEDGER_ARCHIVE_KEY=archive-key")
+		output, err := cmd.CombinedOutput()
+		var exitErr *exec.ExitError
+		if err != nil && !errors.As(err, &exitErr) {
+			t.Fatalf("%v: %v", args, err)
+		}
+		if exitErr != nil {
+			return exitErr.ExitCode(), string(output)
+		}
+		return 0, string(output)
+	}
+
+	archive := []string{"archive", "--before", "500", "--to", filepath.Join(tmpDir, "archive")}
+	prune := []string{"prune", "--max-age", "1h"}
+	redactArgs := []string{"redact", "--id", "1", "--reason", "erasure 17"}
+	for _, args := range [][]string{archive, prune, {"compact"}, redactArgs, append(slices.Clone(prune), "--approval", "0")} {
+		if code, out := run(args...); code != 2 || !strings.Contains(out, "--approval is required") {
+			t.Errorf("%v without an approval: exit %d, %s", args, code, out)
+		}
+	}
+
+	strID := func(id int64) string { return strconv.FormatInt(id, 10) }
+	for name, args := range map[string][]string{
+		"unknown request":  append(slices.Clone(prune), "--approval", "999"),
+		"pending request":  append(slices.Clone(prune), "--approval", strID(pending.ID)),
+		"other action":     append(slices.Clone(archive), "--approval", strID(redact.ID)),
+		"other params":     {"redact", "--id", "1", "--reason", "other", "--approval", strID(redact.ID)},
+		"compact approval": {"compact", "--approval", strID(redact.ID)},
+	} {
+		if code, out := run(args...); code != 4 {
+			t.Errorf("%s: exit %d, want 4: %s", name, code, out)
+		}
+	}
+
+	for _, args := range [][]string{{"prune", "--max-age", "1h", "--dry-run"}, {"compact", "--dry-run"}} {
+		if code, out := run(args...); code != 0 {
+			t.Errorf("%v: exit %d: %s", args, code, out)
+		}
+	}
+	approved := append(slices.Clone(redactArgs), "--approval", strID(redact.ID))
+	if code, out := run(approved...); code != 0 {
+		t.Fatalf("approved redact: exit %d: %s", code, out)
+	}
+	if code, out := run(approved...); code != 4 {
+		t.Errorf("redact with a used approval: exit %d, want 4: %s", code, out)
+	}
+}
+
+// TestRetentionReloadNeedsApproval tests that a reload changing retention
+// uses up an approved retention request, with or without require_approval
+func TestRetentionReloadNeedsApproval(t *testing.T) {
+	l, err := ledger.Open(filepath.Join(t.TempDir(), "ledger.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if err := l.InitSchema(); err != nil {
+		t.Fatal(err)
+	}
+	live := &liveConfig{server: api.NewServer(l, "localhost:0"), ledger: l}
+	next := live.cfg
+	next.Retention = config.Retention{ArchiveAfter: config.Duration(720 * time.Hour), Interval: config.Duration(time.Hour), To: "/tmp/archive", SegmentSize: 100}
+
+	if err := live.apply(next); !errors.Is(err, ledger.ErrNotApproved) {
+		t.Fatalf("unapproved retention change: %v", err)
+	}
+	params, _ := json.Marshal(next.Retention)
+	req, err := l.RequestAdminAction(ledger.AdminActionRetention, params, "alice", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.ApproveAdminAction(req.ID, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := live.apply(next); err != nil {
+		t.Fatalf("approved retention change: %v", err)
+	}
+	if live.current().Retention != next.Retention {
+		t.Fatalf("retention not applied: %+v", live.current().Retention)
+	}
 }
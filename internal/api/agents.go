@@ -0,0 +1,108 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Retr0-XD/StateLedger/internal/ledger"
+)
+
+// handleListAgents lists registered agents with their last-seen times
+func (s *Server) handleListAgents(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	agents, err := s.ledger.Agents()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SuccessResponse(map[string]interface{}{
+		"agents": agents,
+		"total":  len(agents),
+	}))
+}
+
+// handleRegisterAgent registers an agent or refreshes its registration
+func (s *Server) handleRegisterAgent(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req ledger.AgentRegistration
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse("Invalid request body: " + err.Error()))
+		return
+	}
+	if strings.TrimSpace(req.Hostname) == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse("hostname is required"))
+		return
+	}
+	if _, err := ledger.AgentID(req.PublicKey); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
+
+	agent, err := s.ledger.RegisterAgent(req)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SuccessResponse(agent))
+}
+
+// handleAgentHeartbeat refreshes an agent's last-seen time
+func (s *Server) handleAgentHeartbeat(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	agent, err := s.ledger.TouchAgent(r.PathValue("id"))
+	if errors.Is(err, ledger.ErrUnknownAgent) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SuccessResponse(agent))
+}
+
+// handleVerifyAgentChain re-checks the chain proofs an agent pushed with its
+// records against the records the ledger holds
+func (s *Server) handleVerifyAgentChain(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	result, err := s.ledger.VerifyAgentChain(r.PathValue("id"))
+	if errors.Is(err, ledger.ErrUnknownAgent) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SuccessResponse(map[string]interface{}{
+		"valid":     result.OK,
+		"checked":   result.Checked,
+		"failed_id": result.FailedID,
+		"reason":    result.Reason,
+		"time":      time.Now().UTC().Format(time.RFC3339),
+	}))
+}
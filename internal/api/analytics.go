@@ -0,0 +1,88 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/Retr0-XD/StateLedger/internal/ledger"
+)
+
+// maxCoverageDays bounds the range of a coverage ranking, which replays
+// the coverage of every day in it
+const maxCoverageDays = 366
+
+// handleCoverageAnalytics ranks sources by the coverage and determinism
+// score reconstruction gave them each UTC day from from to to
+// (YYYY-MM-DD, default the last 30 days), least reconstructable first
+func (s *Server) handleCoverageAnalytics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	badRequest := func(msg string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse(msg))
+	}
+	q := r.URL.Query()
+
+	to := time.Now().UTC()
+	if v := q.Get("to"); v != "" {
+		day, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			badRequest("invalid to: use YYYY-MM-DD")
+			return
+		}
+		to = day
+	}
+	from := to.AddDate(0, 0, -29)
+	if v := q.Get("from"); v != "" {
+		day, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			badRequest("invalid from: use YYYY-MM-DD")
+			return
+		}
+		from = day
+	}
+	if to.Before(from) {
+		badRequest("to is before from")
+		return
+	}
+	if to.Sub(from) >= maxCoverageDays*24*time.Hour {
+		badRequest("range is longer than " + strconv.Itoa(maxCoverageDays) + " days")
+		return
+	}
+	limit := 0
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			badRequest("invalid limit")
+			return
+		}
+		limit = n
+	}
+
+	days, err := s.ledger.CoverageHistory(from, to)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
+	overall, sources := ledger.SummarizeCoverage(days, q.Get("series") == "true")
+	if only := queryList(r, "source"); len(only) > 0 {
+		sources = slices.DeleteFunc(sources, func(c ledger.SourceCoverage) bool { return !slices.Contains(only, c.Source) })
+	}
+	total := len(sources)
+	if limit > 0 && limit < len(sources) {
+		sources = sources[:limit]
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SuccessResponse(map[string]interface{}{
+		"from":    from.Format(time.DateOnly),
+		"to":      to.Format(time.DateOnly),
+		"overall": overall,
+		"sources": sources,
+		"total":   total,
+	}))
+}
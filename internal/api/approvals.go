@@ -0,0 +1,110 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/Retr0-XD/StateLedger/internal/ledger"
+)
+
+// adminRequestBody is the body of POST /api/v1/admin/requests
+type adminRequestBody struct {
+	Action string          `json:"action"`
+	Params json.RawMessage `json:"params"`
+}
+
+// adminKey returns the fingerprint of the request's admin key. When the
+// key is not an admin key it writes a 403 and returns false.
+func (s *Server) adminKey(w http.ResponseWriter, r *http.Request) (string, bool) {
+	admin := s.policy.admin.Load()
+	key := requestAPIKey(r)
+	if admin == nil || key == "" || !admin.keys[key] || s.keyRevoked(key) {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(ErrorResponse("an admin key is required"))
+		return "", false
+	}
+	return APIKeyFingerprint(key), true
+}
+
+// handleListAdminRequests returns the administrative requests and their
+// approvals, newest first
+func (s *Server) handleListAdminRequests(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, ok := s.adminKey(w, r); !ok {
+		return
+	}
+
+	reqs, err := s.ledger.AdminRequests()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SuccessResponse(reqs))
+}
+
+// handleCreateAdminRequest records a request for a destructive action,
+// which a second admin key must approve within the approval window
+func (s *Server) handleCreateAdminRequest(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	requester, ok := s.adminKey(w, r)
+	if !ok {
+		return
+	}
+
+	var body adminRequestBody
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse("invalid request body: " + err.Error()))
+		return
+	}
+
+	req, err := s.ledger.RequestAdminAction(body.Action, body.Params, requester, s.policy.admin.Load().window)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(SuccessResponse(req))
+}
+
+// handleApproveAdminRequest approves a pending request with an admin key
+// other than the one that made it
+func (s *Server) handleApproveAdminRequest(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	approver, ok := s.adminKey(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse("invalid request ID"))
+		return
+	}
+
+	req, err := s.ledger.ApproveAdminAction(id, approver)
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(SuccessResponse(req))
+		return
+	case errors.Is(err, ledger.ErrApprovalNotFound):
+		w.WriteHeader(http.StatusNotFound)
+	case errors.Is(err, ledger.ErrSelfApproval):
+		w.WriteHeader(http.StatusForbidden)
+	case errors.Is(err, ledger.ErrApprovalExpired), errors.Is(err, ledger.ErrAlreadyApproved):
+		w.WriteHeader(http.StatusConflict)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+}
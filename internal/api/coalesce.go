@@ -0,0 +1,49 @@
+package api
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// flightGroup coalesces identical expensive requests: a request that
+// arrives while the same computation is running waits for it and shares its
+// result, so a burst of dashboard refreshes costs one chain scan
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+	// coalesced counts the requests served by another request's computation
+	coalesced atomic.Int64
+}
+
+type flight struct {
+	done chan struct{}
+	val  any
+	err  error
+}
+
+// do runs fn for key unless a run for key is already in flight, in which
+// case it waits for that run and returns its result
+func (g *flightGroup) do(key string, fn func() (any, error)) (any, error) {
+	g.mu.Lock()
+	if f, ok := g.flights[key]; ok {
+		g.mu.Unlock()
+		g.coalesced.Add(1)
+		<-f.done
+		return f.val, f.err
+	}
+	if g.flights == nil {
+		g.flights = map[string]*flight{}
+	}
+	f := &flight{done: make(chan struct{})}
+	g.flights[key] = f
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.flights, key)
+		g.mu.Unlock()
+		close(f.done)
+	}()
+	f.val, f.err = fn()
+	return f.val, f.err
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// handleConfigDiff diffs two config records, or the latest snapshot of a
+// source at a time against its predecessor. Secrets are always masked.
+func (s *Server) handleConfigDiff(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	q := r.URL.Query()
+
+	if q.Get("from") != "" || q.Get("to") != "" {
+		from, ferr := strconv.ParseInt(q.Get("from"), 10, 64)
+		to, terr := strconv.ParseInt(q.Get("to"), 10, 64)
+		if ferr != nil || terr != nil || from <= 0 || to <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorResponse("from and to must both be record IDs"))
+			return
+		}
+		diff, err := s.ledger.DiffConfigRecords(from, to, true)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(SuccessResponse(diff))
+		return
+	}
+
+	targetTime := time.Now()
+	if ts := q.Get("time"); ts != "" {
+		t, err := time.Parse(time.RFC3339, ts)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorResponse("time must be RFC3339"))
+			return
+		}
+		targetTime = t
+	}
+	diff, ok, err := s.ledger.LatestConfigDiff(targetTime.Unix(), q.Get("source"), true)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorResponse("no earlier config snapshot to compare against"))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SuccessResponse(diff))
+}
@@ -0,0 +1,191 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/Retr0-XD/StateLedger/internal/ledger"
+)
+
+// credentialChange is the optional body of a revoke or restore request
+type credentialChange struct {
+	Reason string `json:"reason"`
+}
+
+// APIKeyResponse describes an API key, with its fingerprint as ID
+type APIKeyResponse struct {
+	ledger.Credential
+	Admin      bool `json:"admin"`
+	Configured bool `json:"configured"`
+}
+
+// requestActor names who made r in credential lifecycle records: the
+// fingerprint of its API key, or "anonymous" without one
+func requestActor(r *http.Request) string {
+	if key := requestAPIKey(r); key != "" {
+		return APIKeyFingerprint(key)
+	}
+	return "anonymous"
+}
+
+// readCredentialChange decodes the reason of a revoke or restore request,
+// from the body or the reason query parameter
+func readCredentialChange(r *http.Request) (credentialChange, error) {
+	change := credentialChange{Reason: r.URL.Query().Get("reason")}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&change); err != nil && !errors.Is(err, io.EOF) {
+		return credentialChange{}, err
+	}
+	return change, nil
+}
+
+// loadRevokedKeys reads the fingerprints of revoked API keys from the
+// ledger. Keys revoked with the CLI while the server runs take effect on
+// the next config reload.
+func (s *Server) loadRevokedKeys() error {
+	creds, err := s.ledger.Credentials(ledger.CredentialAPIKey, true)
+	if err != nil {
+		return err
+	}
+	revoked := map[string]bool{}
+	for _, c := range creds {
+		if c.Revoked() {
+			revoked[c.ID] = true
+		}
+	}
+	s.policy.revoked.Store(&revoked)
+	return nil
+}
+
+// keyRevoked reports whether key has been revoked
+func (s *Server) keyRevoked(key string) bool {
+	revoked := s.policy.revoked.Load()
+	return key != "" && revoked != nil && (*revoked)[APIKeyFingerprint(key)]
+}
+
+// credentialError writes err from a lifecycle change with its status
+func credentialError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ledger.ErrCredentialRevoked), errors.Is(err, ledger.ErrCredentialActive), errors.Is(err, ledger.ErrWebhookExists):
+		w.WriteHeader(http.StatusConflict)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+}
+
+// handleListAPIKeys lists the configured API keys by fingerprint, and with
+// include_revoked=true also the revoked ones
+func (s *Server) handleListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, ok := s.adminKey(w, r); !ok {
+		return
+	}
+	includeRevoked := r.URL.Query().Get("include_revoked") == "true"
+
+	creds, err := s.ledger.Credentials(ledger.CredentialAPIKey, true)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
+	keys := map[string]*APIKeyResponse{}
+	for id, admin := range s.policy.admin.Load().fingerprints {
+		keys[id] = &APIKeyResponse{Credential: ledger.Credential{Kind: ledger.CredentialAPIKey, ID: id}, Admin: admin, Configured: true}
+	}
+	for _, c := range creds {
+		if k, ok := keys[c.ID]; ok {
+			k.Credential = c
+		} else if c.Revoked() {
+			keys[c.ID] = &APIKeyResponse{Credential: c}
+		}
+	}
+
+	responses := make([]APIKeyResponse, 0, len(keys))
+	for _, k := range keys {
+		if k.Revoked() && !includeRevoked {
+			continue
+		}
+		responses = append(responses, *k)
+	}
+	sort.Slice(responses, func(i, j int) bool { return responses[i].ID < responses[j].ID })
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SuccessResponse(map[string]interface{}{
+		"api_keys": responses,
+		"total":    len(responses),
+	}))
+}
+
+// handleRevokeAPIKey revokes an API key by fingerprint. Requests with the
+// key are refused from then on, across restarts.
+func (s *Server) handleRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	actor, ok := s.adminKey(w, r)
+	if !ok {
+		return
+	}
+	id := r.PathValue("id")
+	if !strings.HasPrefix(id, "api-key:") {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse("id must be an API key fingerprint, as listed by GET /api/v1/admin/api-keys"))
+		return
+	}
+	if id == actor {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse("an admin key cannot revoke itself"))
+		return
+	}
+	change, err := readCredentialChange(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse("invalid request body: " + err.Error()))
+		return
+	}
+
+	cred, err := s.ledger.RevokeCredential(ledger.CredentialAPIKey, id, actor, change.Reason)
+	if err != nil {
+		credentialError(w, err)
+		return
+	}
+	if err := s.loadRevokedKeys(); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SuccessResponse(cred))
+}
+
+// handleRestoreAPIKey lifts the revocation of an API key
+func (s *Server) handleRestoreAPIKey(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	actor, ok := s.adminKey(w, r)
+	if !ok {
+		return
+	}
+	change, err := readCredentialChange(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse("invalid request body: " + err.Error()))
+		return
+	}
+
+	cred, err := s.ledger.RestoreCredential(ledger.CredentialAPIKey, r.PathValue("id"), actor, change.Reason)
+	if err != nil {
+		credentialError(w, err)
+		return
+	}
+	if err := s.loadRevokedKeys(); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SuccessResponse(cred))
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// handleChainGraph returns a range of the chain as nodes and edges for
+// rendering: hash links, trace links and artifact references
+func (s *Server) handleChainGraph(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	params := map[string]int64{}
+	for _, name := range []string{"from", "to", "limit"} {
+		v := r.URL.Query().Get(name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorResponse("invalid " + name))
+			return
+		}
+		params[name] = n
+	}
+
+	graph, err := s.ledger.ChainGraph(params["from"], params["to"], int(params["limit"]))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SuccessResponse(graph))
+}
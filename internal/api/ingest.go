@@ -0,0 +1,166 @@
+package api
+
+import (
+	"compress/gzip"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/Retr0-XD/StateLedger/internal/ledger"
+)
+
+// maxIngestBody bounds the decompressed size of an agent push.
+const maxIngestBody = 64 << 20
+
+// IngestRequest is the body of an agent push
+type IngestRequest struct {
+	Records []ledger.AgentRecord `json:"records"`
+}
+
+// handleIngestCursor returns the agent's last accepted sequence so it can
+// resume pushing after a disconnect
+func (s *Server) handleIngestCursor(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id := r.PathValue("id")
+	if status, err := s.authenticateAgent(r, id); err != nil {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
+
+	seq, err := s.ledger.IngestCursor(id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SuccessResponse(map[string]interface{}{
+		"agent_id": id,
+		"last_seq": seq,
+	}))
+}
+
+// handleIngest accepts a batch of buffered agent records, optionally
+// gzip-compressed, and acknowledges the last accepted sequence
+func (s *Server) handleIngest(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id := r.PathValue("id")
+	if status, err := s.authenticateAgent(r, id); err != nil {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
+
+	body := io.Reader(r.Body)
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorResponse("Invalid gzip body: " + err.Error()))
+			return
+		}
+		defer zr.Close()
+		body = zr
+	}
+
+	if s.shedBatch(w) {
+		return
+	}
+
+	raw, err := io.ReadAll(io.LimitReader(body, maxIngestBody))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse("Invalid request body: " + err.Error()))
+		return
+	}
+	var req IngestRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse("Invalid request body: " + err.Error()))
+		return
+	}
+	sources := make([]string, len(req.Records))
+	for i, rec := range req.Records {
+		sources[i] = rec.Source
+	}
+	if status, err := s.verifySourceSignatures(r, raw, sources); err != nil {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
+
+	result, err := s.ledger.IngestAgentBatch(id, req.Records)
+	switch {
+	case errors.Is(err, ledger.ErrSequenceGap):
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	case err != nil:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SuccessResponse(result))
+}
+
+// HeaderSourceSignature carries the signature of an inbound body by a
+// source key, as "<source>=<signature>". A body with records of several
+// signing sources carries one header per source.
+const HeaderSourceSignature = "X-StateLedger-Source-Signature"
+
+// verifySourceSignatures checks that the decompressed body is signed by the
+// key of every source in sources that has one.
+func (s *Server) verifySourceSignatures(r *http.Request, body []byte, sources []string) (int, error) {
+	sigs := map[string]string{}
+	for _, v := range r.Header.Values(HeaderSourceSignature) {
+		// Signatures are unpadded base64url, so the last '=' separates
+		// them from the source.
+		if i := strings.LastIndexByte(v, '='); i > 0 {
+			sigs[strings.TrimSpace(v[:i])] = strings.TrimSpace(v[i+1:])
+		}
+	}
+	err := s.ledger.VerifySourceSignatures(body, sources, sigs)
+	switch {
+	case errors.Is(err, ledger.ErrInvalidSignature):
+		return http.StatusUnauthorized, err
+	case err != nil:
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+// authenticateAgent requires a verified client certificate carrying the
+// ed25519 key the agent registered with
+func (s *Server) authenticateAgent(r *http.Request, id string) (int, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return http.StatusUnauthorized, errors.New("verified client certificate required")
+	}
+
+	agent, err := s.ledger.Agent(id)
+	if errors.Is(err, ledger.ErrUnknownAgent) {
+		return http.StatusNotFound, err
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	registered, err := base64.StdEncoding.DecodeString(agent.PublicKey)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	presented, ok := r.TLS.VerifiedChains[0][0].PublicKey.(ed25519.PublicKey)
+	if !ok || !presented.Equal(ed25519.PublicKey(registered)) {
+		return http.StatusForbidden, errors.New("client certificate does not match the agent's registered key")
+	}
+	return http.StatusOK, nil
+}
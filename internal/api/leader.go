@@ -0,0 +1,171 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Retr0-XD/StateLedger/internal/ledger"
+)
+
+// JobStatus is the last outcome of a leader-only background job
+type JobStatus struct {
+	Interval  string `json:"interval"`
+	LastRun   int64  `json:"last_run,omitempty"`
+	LastError string `json:"last_error,omitempty"`
+	Runs      int64  `json:"runs"`
+}
+
+// leaderState tracks leadership and the background jobs gated on it
+type leaderState struct {
+	elector *ledger.Elector
+
+	mu   sync.Mutex
+	jobs map[string]*JobStatus
+}
+
+// SetElector makes background jobs and webhook dispatch run only while
+// this replica holds the leader lease. Without an elector the server acts
+// as leader, as a single replica does. Reads are served either way.
+func (s *Server) SetElector(e *ledger.Elector) {
+	s.leader.elector = e
+}
+
+// IsLeader reports whether this replica runs background jobs
+func (s *Server) IsLeader() bool {
+	return s.leader.elector == nil || s.leader.elector.IsLeader()
+}
+
+// Publish dispatches a webhook event from the leader only, so replicas
+// sharing a ledger deliver each event once
+func (s *Server) Publish(event ledger.WebhookEvent) {
+	if s.IsLeader() {
+		s.webhooks.Publish(event)
+	}
+}
+
+// RunLeaderJob runs job every interval while this replica is leader, until
+// ctx is cancelled. Ticks on followers are skipped.
+func (s *Server) RunLeaderJob(ctx context.Context, name string, interval time.Duration, job func(ctx context.Context) error) {
+	status := &JobStatus{Interval: interval.String()}
+	s.leader.mu.Lock()
+	if s.leader.jobs == nil {
+		s.leader.jobs = map[string]*JobStatus{}
+	}
+	s.leader.jobs[name] = status
+	s.leader.mu.Unlock()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !s.IsLeader() {
+			continue
+		}
+
+		err := job(ctx)
+		s.leader.mu.Lock()
+		status.Runs++
+		status.LastRun = time.Now().Unix()
+		status.LastError = ""
+		if err != nil {
+			status.LastError = err.Error()
+		}
+		s.leader.mu.Unlock()
+	}
+}
+
+// RunVerifier verifies the hash chain every interval on the leader and
+// publishes a verification.failed event when it breaks
+func (s *Server) RunVerifier(ctx context.Context, interval time.Duration) {
+	s.RunLeaderJob(ctx, "verify", interval, func(ctx context.Context) error {
+		result, err := s.ledger.VerifyChain()
+		if err != nil {
+			return err
+		}
+		s.observeVerification(result)
+		if !result.OK {
+			s.Publish(ledger.WebhookEvent{EventType: ledger.EventVerificationFailed, Timestamp: time.Now(), Data: result})
+			return fmt.Errorf("chain verification failed at record %d: %s", result.FailedID, result.Reason)
+		}
+		return nil
+	})
+}
+
+// RunAlerts checks monitor every interval on the leader and publishes the
+// drift, coverage and agent events it reports
+func (s *Server) RunAlerts(ctx context.Context, interval time.Duration, monitor *ledger.AlertMonitor) {
+	s.RunLeaderJob(ctx, "alerts", interval, func(ctx context.Context) error {
+		events, err := monitor.Check(time.Now())
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			s.Publish(event)
+		}
+		return nil
+	})
+}
+
+// RunDigest mails a digest of the previous interval's activity every
+// interval on the leader
+func (s *Server) RunDigest(ctx context.Context, interval time.Duration, cfg ledger.SMTPConfig) {
+	s.RunLeaderJob(ctx, "digest", interval, func(ctx context.Context) error {
+		now := time.Now()
+		digest, err := s.ledger.Digest(now.Add(-interval).Unix(), now.Unix())
+		if err != nil {
+			return err
+		}
+		return ledger.SendDigest(cfg, digest)
+	})
+}
+
+// RunAnchor publishes the chain head to witnesses every interval on the
+// leader and records their receipts
+func (s *Server) RunAnchor(ctx context.Context, interval time.Duration, witnesses []ledger.Witness) {
+	s.RunLeaderJob(ctx, "anchor", interval, func(ctx context.Context) error {
+		_, err := s.ledger.Anchor(ctx, witnesses...)
+		return err
+	})
+}
+
+// handleLeader reports which replica holds the leader lease and the state
+// of the leader-only jobs
+func (s *Server) handleLeader(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	resp := map[string]interface{}{
+		"is_leader": s.IsLeader(),
+		"ha":        s.leader.elector != nil,
+	}
+	if e := s.leader.elector; e != nil {
+		resp["replica"] = e.ID
+		lease, held, err := s.ledger.CurrentLease(e.LeaseName())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+			return
+		}
+		if held {
+			resp["lease"] = lease
+		}
+	}
+
+	s.leader.mu.Lock()
+	jobs := make(map[string]JobStatus, len(s.leader.jobs))
+	for name, status := range s.leader.jobs {
+		jobs[name] = *status
+	}
+	s.leader.mu.Unlock()
+	resp["jobs"] = jobs
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SuccessResponse(resp))
+}
@@ -1,34 +1,62 @@
 package api
 
 import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
+// Latency histograms use exponentially sized buckets from 1µs, each
+// latencyGrowth times wider than the last, so a percentile read from a
+// bucket is within about 5% of the true value. Durations past the last
+// bucket (about 3 minutes) are counted in it.
+const (
+	latencyBase    = time.Microsecond
+	latencyGrowth  = 1.1
+	latencyBuckets = 200
+)
+
+// latencyQuantiles are the percentiles reported per endpoint.
+var latencyQuantiles = []float64{0.5, 0.95, 0.99}
+
 // Metrics tracks API performance metrics
 type Metrics struct {
 	mu sync.RWMutex
 
 	// Request metrics
-	totalRequests     atomic.Uint64
-	failedRequests    atomic.Uint64
-	requestDurations  []time.Duration
-	maxDurationsCount int
+	totalRequests  atomic.Uint64
+	failedRequests atomic.Uint64
+	all            *latencyHistogram
+	endpoints      map[string]*latencyHistogram
 
 	// Endpoint-specific metrics
-	healthChecks      atomic.Uint64
-	listRecords       atomic.Uint64
-	getRecord         atomic.Uint64
-	verifyChain       atomic.Uint64
-	snapshots         atomic.Uint64
+	healthChecks atomic.Uint64
+	listRecords  atomic.Uint64
+	getRecord    atomic.Uint64
+	verifyChain  atomic.Uint64
+	snapshots    atomic.Uint64
+}
+
+// latencyHistogram is a streaming histogram of request durations. It
+// keeps every observation in constant space.
+type latencyHistogram struct {
+	count  uint64
+	failed uint64
+	sum    time.Duration
+	min    time.Duration
+	max    time.Duration
+	counts [latencyBuckets]uint64
 }
 
 // NewMetrics creates a new metrics tracker
 func NewMetrics() *Metrics {
 	return &Metrics{
-		maxDurationsCount: 1000,
-		requestDurations:  make([]time.Duration, 0, 1000),
+		all:       &latencyHistogram{},
+		endpoints: make(map[string]*latencyHistogram),
 	}
 }
 
@@ -41,12 +69,13 @@ func (m *Metrics) RecordRequest(endpoint string, duration time.Duration, err err
 	}
 
 	m.mu.Lock()
-	if len(m.requestDurations) < m.maxDurationsCount {
-		m.requestDurations = append(m.requestDurations, duration)
-	} else {
-		m.requestDurations = m.requestDurations[1:]
-		m.requestDurations = append(m.requestDurations, duration)
+	m.all.observe(duration, err != nil)
+	h, ok := m.endpoints[endpoint]
+	if !ok {
+		h = &latencyHistogram{}
+		m.endpoints[endpoint] = h
 	}
+	h.observe(duration, err != nil)
 	m.mu.Unlock()
 
 	// Track endpoint-specific metrics
@@ -69,33 +98,22 @@ func (m *Metrics) GetStats() MetricsStats {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	var total time.Duration
-	var min, max time.Duration
-	if len(m.requestDurations) > 0 {
-		min = m.requestDurations[0]
-		max = m.requestDurations[0]
-		for _, d := range m.requestDurations {
-			total += d
-			if d < min {
-				min = d
-			}
-			if d > max {
-				max = d
-			}
-		}
-	}
-
-	avg := time.Duration(0)
-	if len(m.requestDurations) > 0 {
-		avg = total / time.Duration(len(m.requestDurations))
+	overall := m.all.stats()
+	endpoints := make(map[string]LatencyStats, len(m.endpoints))
+	for name, h := range m.endpoints {
+		endpoints[name] = h.stats()
 	}
 
 	return MetricsStats{
 		TotalRequests:  m.totalRequests.Load(),
 		FailedRequests: m.failedRequests.Load(),
-		AvgDuration:    avg,
-		MinDuration:    min,
-		MaxDuration:    max,
+		AvgDuration:    overall.Avg,
+		MinDuration:    overall.Min,
+		MaxDuration:    overall.Max,
+		P50Duration:    overall.P50,
+		P95Duration:    overall.P95,
+		P99Duration:    overall.P99,
+		Endpoints:      endpoints,
 		HealthChecks:   m.healthChecks.Load(),
 		ListRecords:    m.listRecords.Load(),
 		GetRecord:      m.getRecord.Load(),
@@ -106,59 +124,163 @@ func (m *Metrics) GetStats() MetricsStats {
 
 // MetricsStats holds metrics statistics
 type MetricsStats struct {
-	TotalRequests  uint64        `json:"total_requests"`
-	FailedRequests uint64        `json:"failed_requests"`
-	AvgDuration    time.Duration `json:"avg_duration_ns"`
-	MinDuration    time.Duration `json:"min_duration_ns"`
-	MaxDuration    time.Duration `json:"max_duration_ns"`
-	HealthChecks   uint64        `json:"health_checks"`
-	ListRecords    uint64        `json:"list_records"`
-	GetRecord      uint64        `json:"get_record"`
-	VerifyChain    uint64        `json:"verify_chain"`
-	Snapshots      uint64        `json:"snapshots"`
+	TotalRequests  uint64                  `json:"total_requests"`
+	FailedRequests uint64                  `json:"failed_requests"`
+	AvgDuration    time.Duration           `json:"avg_duration_ns"`
+	MinDuration    time.Duration           `json:"min_duration_ns"`
+	MaxDuration    time.Duration           `json:"max_duration_ns"`
+	P50Duration    time.Duration           `json:"p50_duration_ns"`
+	P95Duration    time.Duration           `json:"p95_duration_ns"`
+	P99Duration    time.Duration           `json:"p99_duration_ns"`
+	Endpoints      map[string]LatencyStats `json:"endpoints"`
+	HealthChecks   uint64                  `json:"health_checks"`
+	ListRecords    uint64                  `json:"list_records"`
+	GetRecord      uint64                  `json:"get_record"`
+	VerifyChain    uint64                  `json:"verify_chain"`
+	Snapshots      uint64                  `json:"snapshots"`
+}
+
+// LatencyStats holds the request count and latency distribution of one
+// endpoint
+type LatencyStats struct {
+	Requests uint64        `json:"requests"`
+	Failed   uint64        `json:"failed"`
+	Sum      time.Duration `json:"sum_ns"`
+	Avg      time.Duration `json:"avg_ns"`
+	Min      time.Duration `json:"min_ns"`
+	Max      time.Duration `json:"max_ns"`
+	P50      time.Duration `json:"p50_ns"`
+	P95      time.Duration `json:"p95_ns"`
+	P99      time.Duration `json:"p99_ns"`
+}
+
+func (h *latencyHistogram) observe(d time.Duration, failed bool) {
+	if d < 0 {
+		d = 0
+	}
+	if h.count == 0 || d < h.min {
+		h.min = d
+	}
+	if d > h.max {
+		h.max = d
+	}
+	h.count++
+	if failed {
+		h.failed++
+	}
+	h.sum += d
+	h.counts[latencyBucket(d)]++
+}
+
+func (h *latencyHistogram) stats() LatencyStats {
+	s := LatencyStats{Requests: h.count, Failed: h.failed, Sum: h.sum, Min: h.min, Max: h.max}
+	if h.count == 0 {
+		return s
+	}
+	s.Avg = h.sum / time.Duration(h.count)
+	s.P50 = h.quantile(0.5)
+	s.P95 = h.quantile(0.95)
+	s.P99 = h.quantile(0.99)
+	return s
+}
+
+// quantile estimates the q-th quantile as the midpoint of the bucket that
+// holds it, clamped to the observed range.
+func (h *latencyHistogram) quantile(q float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(h.count)))
+	if rank == 0 {
+		rank = 1
+	}
+	var seen uint64
+	for i, n := range h.counts {
+		seen += n
+		if seen < rank {
+			continue
+		}
+		lower, upper := latencyBound(i-1), latencyBound(i)
+		d := lower + (upper-lower)/2
+		if d < h.min {
+			d = h.min
+		}
+		if d > h.max {
+			d = h.max
+		}
+		return d
+	}
+	return h.max
+}
+
+// latencyBucket returns the index of the bucket holding d: bucket i holds
+// durations in (latencyBound(i-1), latencyBound(i)].
+func latencyBucket(d time.Duration) int {
+	if d <= latencyBase {
+		return 0
+	}
+	i := int(math.Ceil(math.Log(float64(d)/float64(latencyBase)) / math.Log(latencyGrowth)))
+	// Guard against rounding putting d just past its bucket's bound.
+	for i > 0 && d <= latencyBound(i-1) {
+		i--
+	}
+	for i < latencyBuckets-1 && d > latencyBound(i) {
+		i++
+	}
+	if i >= latencyBuckets {
+		i = latencyBuckets - 1
+	}
+	return i
+}
+
+// latencyBound returns the upper bound of bucket i; bucket -1 is the lower
+// bound of bucket 0.
+func latencyBound(i int) time.Duration {
+	if i < 0 {
+		return 0
+	}
+	return time.Duration(float64(latencyBase) * math.Pow(latencyGrowth, float64(i)))
 }
 
 // PrometheusMetrics exports metrics in Prometheus format
 func (m *Metrics) PrometheusMetrics() string {
 	stats := m.GetStats()
-	return `# HELP stateledger_requests_total Total number of HTTP requests
-# TYPE stateledger_requests_total counter
-stateledger_requests_total ` + formatUint64(stats.TotalRequests) + `
-
-# HELP stateledger_requests_failed Total number of failed HTTP requests
-# TYPE stateledger_requests_failed counter
-stateledger_requests_failed ` + formatUint64(stats.FailedRequests) + `
-
-# HELP stateledger_request_duration_avg Average request duration in nanoseconds
-# TYPE stateledger_request_duration_avg gauge
-stateledger_request_duration_avg ` + formatDuration(stats.AvgDuration) + `
-
-# HELP stateledger_health_checks_total Total number of health check requests
-# TYPE stateledger_health_checks_total counter
-stateledger_health_checks_total ` + formatUint64(stats.HealthChecks) + `
-
-# HELP stateledger_list_records_total Total number of list records requests
-# TYPE stateledger_list_records_total counter
-stateledger_list_records_total ` + formatUint64(stats.ListRecords) + `
-
-# HELP stateledger_get_record_total Total number of get record requests
-# TYPE stateledger_get_record_total counter
-stateledger_get_record_total ` + formatUint64(stats.GetRecord) + `
-
-# HELP stateledger_verify_chain_total Total number of verify chain requests
-# TYPE stateledger_verify_chain_total counter
-stateledger_verify_chain_total ` + formatUint64(stats.VerifyChain) + `
-
-# HELP stateledger_snapshots_total Total number of snapshot requests
-# TYPE stateledger_snapshots_total counter
-stateledger_snapshots_total ` + formatUint64(stats.Snapshots) + `
-`
-}
 
-func formatUint64(v uint64) string {
-	return string(rune(v + '0'))
+	var b strings.Builder
+	metric := func(name, kind, help string, value interface{}) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n%s %v\n\n", name, help, name, kind, name, value)
+	}
+	metric("stateledger_requests_total", "counter", "Total number of HTTP requests", stats.TotalRequests)
+	metric("stateledger_requests_failed", "counter", "Total number of failed HTTP requests", stats.FailedRequests)
+	metric("stateledger_request_duration_avg", "gauge", "Average request duration in nanoseconds", stats.AvgDuration.Nanoseconds())
+
+	endpoints := make([]string, 0, len(stats.Endpoints))
+	for name := range stats.Endpoints {
+		endpoints = append(endpoints, name)
+	}
+	sort.Strings(endpoints)
+
+	const summary = "stateledger_request_duration_seconds"
+	fmt.Fprintf(&b, "# HELP %s Request latency by endpoint\n# TYPE %s summary\n", summary, summary)
+	for _, name := range endpoints {
+		s := stats.Endpoints[name]
+		label := fmt.Sprintf("endpoint=%q", name)
+		for i, d := range []time.Duration{s.P50, s.P95, s.P99} {
+			fmt.Fprintf(&b, "%s{%s,quantile=\"%g\"} %s\n", summary, label, latencyQuantiles[i], formatSeconds(d))
+		}
+		fmt.Fprintf(&b, "%s_sum{%s} %s\n", summary, label, formatSeconds(s.Sum))
+		fmt.Fprintf(&b, "%s_count{%s} %d\n", summary, label, s.Requests)
+	}
+	b.WriteString("\n")
+
+	metric("stateledger_health_checks_total", "counter", "Total number of health check requests", stats.HealthChecks)
+	metric("stateledger_list_records_total", "counter", "Total number of list records requests", stats.ListRecords)
+	metric("stateledger_get_record_total", "counter", "Total number of get record requests", stats.GetRecord)
+	metric("stateledger_verify_chain_total", "counter", "Total number of verify chain requests", stats.VerifyChain)
+	metric("stateledger_snapshots_total", "counter", "Total number of snapshot requests", stats.Snapshots)
+	return strings.TrimSuffix(b.String(), "\n")
 }
 
-func formatDuration(d time.Duration) string {
-	return string(rune(d.Nanoseconds() + '0'))
+func formatSeconds(d time.Duration) string {
+	return fmt.Sprintf("%g", d.Seconds())
 }
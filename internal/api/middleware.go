@@ -1,6 +1,8 @@
 package api
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"net/http"
 	"strings"
 	"sync"
@@ -61,18 +63,8 @@ func AuthMiddleware(validKeys map[string]bool) Middleware {
 				return
 			}
 
-			// Check API key in header
-			apiKey := r.Header.Get("X-API-Key")
-			if apiKey == "" {
-				// Try Authorization header
-				auth := r.Header.Get("Authorization")
-				if strings.HasPrefix(auth, "Bearer ") {
-					apiKey = strings.TrimPrefix(auth, "Bearer ")
-				}
-			}
-
 			// Validate API key
-			if !validKeys[apiKey] {
+			if !validKeys[requestAPIKey(r)] {
 				http.Error(w, "Unauthorized", http.StatusUnauthorized)
 				return
 			}
@@ -82,6 +74,25 @@ func AuthMiddleware(validKeys map[string]bool) Middleware {
 	}
 }
 
+// requestAPIKey returns the API key sent in X-API-Key or as an
+// Authorization bearer token
+func requestAPIKey(r *http.Request) string {
+	if apiKey := r.Header.Get("X-API-Key"); apiKey != "" {
+		return apiKey
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return ""
+}
+
+// APIKeyFingerprint identifies an API key without revealing it, as the
+// admin API lists keys and as revocations name them
+func APIKeyFingerprint(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return "api-key:" + hex.EncodeToString(sum[:6])
+}
+
 // RateLimiter implements token bucket rate limiting
 type RateLimiter struct {
 	mu       sync.Mutex
@@ -89,11 +100,27 @@ type RateLimiter struct {
 	rate     int           // tokens per second
 	capacity int           // max tokens
 	cleanup  time.Duration // cleanup interval
+	store    RateStore     // shared buckets, nil for in-memory only
+
+	onExceeded func(key string)
+	onError    func(error)
+}
+
+// RateStore keeps token buckets outside the process, so limits survive
+// restarts and hold across replicas. *ledger.Ledger implements it with a
+// table in the ledger database.
+type RateStore interface {
+	// TakeRateToken spends a token of key's bucket; exceeded is set on
+	// the first rejection since key was last allowed.
+	TakeRateToken(key string, rate, capacity int, now time.Time) (allowed, exceeded bool, err error)
+	// PruneRateTokens drops buckets idle since before idleBefore.
+	PruneRateTokens(idleBefore time.Time) error
 }
 
 type bucket struct {
 	tokens    float64
 	lastCheck time.Time
+	limited   bool // rejected since the last allowed request
 }
 
 // NewRateLimiter creates a new rate limiter
@@ -111,8 +138,62 @@ func NewRateLimiter(rate, capacity int) *RateLimiter {
 	return rl
 }
 
+// NewSharedRateLimiter creates a rate limiter whose buckets live in store.
+// While the store fails, requests are limited by in-memory buckets and the
+// errors are passed to the OnError callback.
+func NewSharedRateLimiter(rate, capacity int, store RateStore) *RateLimiter {
+	rl := NewRateLimiter(rate, capacity)
+	rl.store = store
+	return rl
+}
+
+// OnError registers fn to receive rate store failures
+func (rl *RateLimiter) OnError(fn func(error)) {
+	rl.mu.Lock()
+	rl.onError = fn
+	rl.mu.Unlock()
+}
+
+// OnExceeded registers fn to be called when a key is first rejected after
+// having been allowed, so a client hitting its limit is reported once per
+// burst rather than once per request
+func (rl *RateLimiter) OnExceeded(fn func(key string)) {
+	rl.mu.Lock()
+	rl.onExceeded = fn
+	rl.mu.Unlock()
+}
+
 // Allow checks if a request should be allowed
 func (rl *RateLimiter) Allow(key string) bool {
+	if rl.store != nil {
+		allowed, exceeded, err := rl.store.TakeRateToken(key, rl.rate, rl.capacity, time.Now())
+		if err == nil {
+			rl.mu.Lock()
+			fn := rl.onExceeded
+			rl.mu.Unlock()
+			if exceeded && fn != nil {
+				fn(key)
+			}
+			return allowed
+		}
+		rl.mu.Lock()
+		onError := rl.onError
+		rl.mu.Unlock()
+		if onError != nil {
+			onError(err)
+		}
+	}
+
+	allowed, exceeded, fn := rl.take(key)
+	if exceeded && fn != nil {
+		fn(key)
+	}
+	return allowed
+}
+
+// take spends a token for key. exceeded is set on the first rejection
+// since key was last allowed.
+func (rl *RateLimiter) take(key string) (allowed, exceeded bool, fn func(string)) {
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
 
@@ -125,7 +206,7 @@ func (rl *RateLimiter) Allow(key string) bool {
 			lastCheck: now,
 		}
 		rl.buckets[key] = b
-		return true
+		return true, false, nil
 	}
 
 	// Add tokens based on elapsed time
@@ -139,10 +220,13 @@ func (rl *RateLimiter) Allow(key string) bool {
 	// Check if request can proceed
 	if b.tokens >= 1 {
 		b.tokens--
-		return true
+		b.limited = false
+		return true, false, nil
 	}
 
-	return false
+	exceeded = !b.limited
+	b.limited = true
+	return false, exceeded, rl.onExceeded
 }
 
 // cleanupLoop removes old buckets
@@ -151,6 +235,17 @@ func (rl *RateLimiter) cleanupLoop() {
 	defer ticker.Stop()
 
 	for range ticker.C {
+		if rl.store != nil {
+			if err := rl.store.PruneRateTokens(time.Now().Add(-rl.cleanup)); err != nil {
+				rl.mu.Lock()
+				onError := rl.onError
+				rl.mu.Unlock()
+				if onError != nil {
+					onError(err)
+				}
+			}
+		}
+
 		rl.mu.Lock()
 		now := time.Now()
 		for key, b := range rl.buckets {
@@ -166,10 +261,11 @@ func (rl *RateLimiter) cleanupLoop() {
 func RateLimitMiddleware(limiter *RateLimiter) Middleware {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Use API key if present, otherwise use IP
-			key := r.Header.Get("X-API-Key")
-			if key == "" {
-				key = r.RemoteAddr
+			// Use API key if present, otherwise use IP. Keys are
+			// fingerprinted so OnExceeded callbacks never see them.
+			key := r.RemoteAddr
+			if apiKey := r.Header.Get("X-API-Key"); apiKey != "" {
+				key = APIKeyFingerprint(apiKey)
 			}
 
 			if !limiter.Allow(key) {
@@ -0,0 +1,33 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// handleMirror reports the dual-write mirror's progress and divergences, and
+// compares the two chains when check=true
+func (s *Server) handleMirror(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	status, ok := s.ledger.MirrorStatus()
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorResponse("no mirror configured"))
+		return
+	}
+
+	resp := map[string]interface{}{"status": status}
+	if r.URL.Query().Get("check") == "true" {
+		check, err := s.ledger.CompareMirror()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+			return
+		}
+		resp["check"] = check
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SuccessResponse(resp))
+}
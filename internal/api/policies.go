@@ -0,0 +1,81 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Retr0-XD/StateLedger/internal/ledger"
+)
+
+// handleListPolicies lists the policies in force at time (RFC3339, default
+// now), or with kind every version of that kind
+func (s *Server) handleListPolicies(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	at, ok := policyTime(w, r)
+	if !ok {
+		return
+	}
+
+	var policies []ledger.Policy
+	var err error
+	if kind := r.URL.Query().Get("kind"); kind != "" {
+		policies, err = s.ledger.PolicyHistory(kind)
+	} else {
+		policies, err = s.ledger.PoliciesAt(at.Unix())
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SuccessResponse(map[string]interface{}{
+		"policies": policies,
+		"total":    len(policies),
+	}))
+}
+
+// handleGetPolicy returns the version of a policy kind in force at time
+// (RFC3339, default now)
+func (s *Server) handleGetPolicy(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	at, ok := policyTime(w, r)
+	if !ok {
+		return
+	}
+
+	policy, err := s.ledger.Policy(r.PathValue("kind"), at.Unix())
+	if err != nil {
+		if errors.Is(err, ledger.ErrUnknownPolicy) {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SuccessResponse(policy))
+}
+
+// policyTime reads the time query parameter, writing a 400 response when
+// it is not RFC3339
+func policyTime(w http.ResponseWriter, r *http.Request) (time.Time, bool) {
+	v := r.URL.Query().Get("time")
+	if v == "" {
+		return time.Now(), true
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse("invalid time: use RFC3339"))
+		return time.Time{}, false
+	}
+	return t, true
+}
@@ -0,0 +1,236 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Retr0-XD/StateLedger/internal/ledger"
+)
+
+// Policy is the authentication, rate limiting and request logging applied
+// in front of the API routes. SetPolicy replaces it while the server runs,
+// without closing open connections.
+type Policy struct {
+	// APIKeys, when set, are required in X-API-Key or an Authorization
+	// bearer token on every request except health checks and agent ingest
+	// requests with a verified client certificate issued to that agent.
+	APIKeys []string
+	// AdminKeys may request and approve administrative actions under
+	// /api/v1/admin. They are accepted wherever APIKeys are.
+	AdminKeys []string
+	// InsecureNoAuth lets requests that write, such as appends, webhook
+	// subscriptions and schema registrations, through without an API key
+	// when APIKeys is empty. Without it, a server with no APIKeys only
+	// serves reads and answers writes with 401.
+	InsecureNoAuth bool
+	// ApprovalWindow is how long an administrative request waits for its
+	// second key. Defaults to ledger.DefaultApprovalWindow.
+	ApprovalWindow time.Duration
+	// RateLimit is the sustained requests per second allowed per client,
+	// with bursts of up to RateBurst. 0 disables rate limiting.
+	RateLimit int
+	RateBurst int
+	// SharedRateLimit keeps the per-client buckets in the ledger database
+	// instead of in memory, so limits survive restarts and are enforced
+	// across all replicas sharing the database.
+	SharedRateLimit bool
+	// OnRateLimitError receives failures of the shared rate limit store.
+	// Requests are limited in memory while the store fails.
+	OnRateLimitError func(error)
+	// LogRequests writes one line per request to LogOutput, as text or, with
+	// LogFormat "json", as a JSON object.
+	LogRequests bool
+	LogFormat   string
+	LogOutput   io.Writer
+}
+
+// policyState holds the handler built from the current Policy.
+type policyState struct {
+	mu      sync.Mutex
+	handler atomic.Pointer[http.Handler]
+	limiter *RateLimiter
+	rate    [2]int
+	shared  bool
+	admin   atomic.Pointer[adminPolicy]
+	// revoked holds the fingerprints of revoked API keys
+	revoked atomic.Pointer[map[string]bool]
+}
+
+// adminPolicy is the part of Policy read by the admin handlers.
+type adminPolicy struct {
+	keys   map[string]bool
+	window time.Duration
+	// fingerprints holds the fingerprint of every configured key, true
+	// for admin keys
+	fingerprints map[string]bool
+}
+
+// SetPolicy applies p to all requests received from now on. Requests in
+// flight finish under the policy they started with. Per-client rate limit
+// state is kept when the limits are unchanged.
+func (s *Server) SetPolicy(p Policy) {
+	st := &s.policy
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	var middlewares []Middleware
+	if p.LogRequests {
+		out := p.LogOutput
+		if out == nil {
+			out = io.Discard
+		}
+		middlewares = append(middlewares, requestLogMiddleware(out, p.LogFormat))
+	}
+	admin := &adminPolicy{keys: make(map[string]bool, len(p.AdminKeys)), window: p.ApprovalWindow, fingerprints: map[string]bool{}}
+	for _, k := range p.APIKeys {
+		admin.fingerprints[APIKeyFingerprint(k)] = false
+	}
+	for _, k := range p.AdminKeys {
+		admin.keys[k] = true
+		admin.fingerprints[APIKeyFingerprint(k)] = true
+	}
+	st.admin.Store(admin)
+	// Revocations made with the CLI are picked up here. Should the ledger
+	// be unreadable, the previous revocations stay in force.
+	_ = s.loadRevokedKeys()
+	if len(p.APIKeys) > 0 {
+		keys := make(map[string]bool, len(p.APIKeys)+len(p.AdminKeys))
+		for _, k := range p.APIKeys {
+			keys[k] = true
+		}
+		for k := range admin.keys {
+			keys[k] = true
+		}
+		middlewares = append(middlewares, apiKeyMiddleware(keys, s.keyRevoked))
+	} else if !p.InsecureNoAuth {
+		middlewares = append(middlewares, readOnlyMiddleware)
+	}
+	if p.RateLimit > 0 {
+		burst := p.RateBurst
+		if burst < p.RateLimit {
+			burst = p.RateLimit
+		}
+		if st.limiter == nil || st.rate != [2]int{p.RateLimit, burst} || st.shared != p.SharedRateLimit {
+			if p.SharedRateLimit {
+				st.limiter = NewSharedRateLimiter(p.RateLimit, burst, s.ledger)
+			} else {
+				st.limiter = NewRateLimiter(p.RateLimit, burst)
+			}
+			st.limiter.OnExceeded(func(key string) {
+				s.Publish(ledger.WebhookEvent{
+					EventType: ledger.EventQuotaExceeded,
+					Timestamp: time.Now(),
+					Data:      map[string]interface{}{"client": key},
+				})
+			})
+			st.rate = [2]int{p.RateLimit, burst}
+			st.shared = p.SharedRateLimit
+		}
+		st.limiter.OnError(p.OnRateLimitError)
+		middlewares = append(middlewares, RateLimitMiddleware(st.limiter))
+	} else {
+		st.limiter = nil
+	}
+
+	h := Chain(s.router, middlewares...)
+	st.handler.Store(&h)
+}
+
+// serveWithPolicy routes r through the current policy.
+func (s *Server) serveWithPolicy(w http.ResponseWriter, r *http.Request) {
+	(*s.policy.handler.Load()).ServeHTTP(w, r)
+}
+
+// apiKeyMiddleware is AuthMiddleware that also lets through health checks,
+// agent ingest requests, which authenticate with client certificates
+// instead, and the static web UI, which holds no data and sends the key on
+// its API calls. Keys for which revoked reports true are refused.
+func apiKeyMiddleware(keys map[string]bool, revoked func(key string) bool) Middleware {
+	auth := AuthMiddleware(keys)
+	return func(next http.Handler) http.Handler {
+		checked := auth(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/api/v1/health" || r.URL.Path == "/ui" || strings.HasPrefix(r.URL.Path, "/ui/") || agentIngestRequest(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if revoked(requestAPIKey(r)) {
+				http.Error(w, "Unauthorized: API key revoked", http.StatusUnauthorized)
+				return
+			}
+			checked.ServeHTTP(w, r)
+		})
+	}
+}
+
+// readOnlyMiddleware refuses requests that may write, other than agent
+// ingest requests with a verified client certificate, for servers without
+// API keys. Snapshots are posted but only read.
+func readOnlyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if r.URL.Path != "/api/v1/snapshot" && !agentIngestRequest(r) {
+				http.Error(w, "Unauthorized: writes need an API key, and none is configured", http.StatusUnauthorized)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// agentIngestRequest reports whether r is a request to an agent's ingest
+// endpoint with a verified client certificate issued to that agent. A
+// certificate authenticates its agent's ingestion only; every other route
+// still needs a key.
+func agentIngestRequest(r *http.Request) bool {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return false
+	}
+	rest, ok := strings.CutPrefix(r.URL.Path, "/api/v1/agents/")
+	if !ok {
+		return false
+	}
+	id, ok := strings.CutSuffix(rest, "/ingest")
+	if !ok || id == "" || strings.Contains(id, "/") {
+		return false
+	}
+	return r.TLS.VerifiedChains[0][0].Subject.CommonName == id
+}
+
+// requestLogMiddleware writes the method, path, status and duration of each
+// request to out.
+func requestLogMiddleware(out io.Writer, format string) Middleware {
+	var mu sync.Mutex
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+			next.ServeHTTP(rw, r)
+			duration := time.Since(start)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if format == "json" {
+				line, _ := json.Marshal(map[string]interface{}{
+					"time":        start.UTC().Format(time.RFC3339),
+					"method":      r.Method,
+					"path":        r.URL.Path,
+					"status":      rw.statusCode,
+					"duration_ms": float64(duration.Microseconds()) / 1000,
+					"remote":      r.RemoteAddr,
+				})
+				fmt.Fprintln(out, string(line))
+				return
+			}
+			fmt.Fprintf(out, "%s %s %s %d %s %s\n", start.UTC().Format(time.RFC3339), r.Method, r.URL.Path, rw.statusCode, duration, r.RemoteAddr)
+		})
+	}
+}
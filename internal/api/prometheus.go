@@ -0,0 +1,144 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Retr0-XD/StateLedger/internal/ledger"
+)
+
+// chainHealth holds the outcome of the last chain verification run by this
+// server
+type chainHealth struct {
+	mu       sync.Mutex
+	last     ledger.VerifyResult
+	verified bool
+}
+
+// observeVerification records a verification result for /metrics
+func (s *Server) observeVerification(result ledger.VerifyResult) {
+	s.health.mu.Lock()
+	s.health.last = result
+	s.health.verified = true
+	s.health.mu.Unlock()
+}
+
+// lastVerification returns the last verification result, verifying the
+// chain first if this server has not done so yet
+func (s *Server) lastVerification() (ledger.VerifyResult, error) {
+	s.health.mu.Lock()
+	result, verified := s.health.last, s.health.verified
+	s.health.mu.Unlock()
+	if verified {
+		return result, nil
+	}
+
+	return s.verifyChain()
+}
+
+// verifyChain verifies the chain, sharing the run with concurrent callers,
+// and records the result for /metrics
+func (s *Server) verifyChain() (ledger.VerifyResult, error) {
+	v, err := s.flights.do("verify", func() (any, error) {
+		result, err := s.ledger.VerifyChain()
+		if err != nil {
+			return nil, err
+		}
+		s.observeVerification(result)
+		if !result.OK {
+			s.Publish(ledger.WebhookEvent{EventType: ledger.EventVerificationFailed, Timestamp: time.Now(), Data: result})
+		}
+		return result, nil
+	})
+	if err != nil {
+		return ledger.VerifyResult{}, err
+	}
+	return v.(ledger.VerifyResult), nil
+}
+
+// labelEscaper escapes a Prometheus label value
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// handlePrometheusMetrics exports chain health gauges and append counters
+// in the Prometheus text format
+func (s *Server) handlePrometheusMetrics(w http.ResponseWriter, r *http.Request) {
+	stats, err := s.ledger.ChainStats()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	verify, err := s.lastVerification()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	valid := 0
+	if verify.OK {
+		valid = 1
+	}
+
+	var b strings.Builder
+	gauge := func(name, help string, value int64) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n%s %d\n", name, help, name, name, value)
+	}
+	gauge("stateledger_chain_valid", "Whether the last hash chain verification succeeded (1) or failed (0)", int64(valid))
+	gauge("stateledger_last_verification_timestamp", "Unix time of the last hash chain verification", verify.Timestamp)
+	gauge("stateledger_last_append_timestamp", "Unix timestamp of the newest record in the ledger", stats.LastTimestamp)
+	gauge("stateledger_records_total", "Number of records in the ledger, including archived records", stats.Records)
+
+	appends := s.ledger.AppendStats()
+	counter := func(name, help, label string, counts map[string]ledger.AppendCount, value func(ledger.AppendCount) int64) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		keys := make([]string, 0, len(counts))
+		for k := range counts {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(&b, "%s{%s=\"%s\"} %d\n", name, label, labelEscaper.Replace(k), value(counts[k]))
+		}
+	}
+	records := func(c ledger.AppendCount) int64 { return c.Records }
+	bytes := func(c ledger.AppendCount) int64 { return c.PayloadBytes }
+	counter("stateledger_appended_records_by_type_total", "Records appended since start, by record type", "type", appends.ByType, records)
+	counter("stateledger_appended_payload_bytes_by_type_total", "Payload bytes appended since start, by record type", "type", appends.ByType, bytes)
+	counter("stateledger_appended_records_by_source_total", "Records appended since start, by source", "source", appends.BySource, records)
+	counter("stateledger_appended_payload_bytes_by_source_total", "Payload bytes appended since start, by source", "source", appends.BySource, bytes)
+
+	latency := s.ledger.AppendLatency()
+	behind := 0
+	if latency.Behind {
+		behind = 1
+	}
+	const p95 = "stateledger_append_latency_p95_seconds"
+	fmt.Fprintf(&b, "# HELP %s 95th percentile append latency over the last 10 seconds\n# TYPE %s gauge\n%s %g\n", p95, p95, p95, latency.RecentP95.Seconds())
+	const breaches = "stateledger_append_slo_breaches_total"
+	fmt.Fprintf(&b, "# HELP %s Appends slower than the append SLO since start\n# TYPE %s counter\n%s %d\n", breaches, breaches, breaches, latency.Breaches)
+	gauge("stateledger_append_backpressure", "Whether appends are behind the SLO and batch appends are refused (1) or not (0)", int64(behind))
+	const coalesced = "stateledger_coalesced_requests_total"
+	fmt.Fprintf(&b, "# HELP %s Verify, snapshot and audit requests served by a concurrent identical request's computation\n# TYPE %s counter\n%s %d\n", coalesced, coalesced, coalesced, s.flights.coalesced.Load())
+
+	webhooks := s.webhooks.Stats()
+	gauge("stateledger_webhook_deliveries_in_flight", "Webhook deliveries being sent or waiting to be retried", int64(webhooks.InFlight))
+	gauge("stateledger_webhook_deliveries_queued", "Webhook deliveries waiting in the queue of their subscription", int64(webhooks.Queued))
+	gauge("stateledger_webhook_slow_consumers", "Webhook subscriptions that lag consistently", int64(webhooks.SlowConsumers))
+	const deliveries = "stateledger_webhook_deliveries_total"
+	fmt.Fprintf(&b, "# HELP %s Webhook deliveries finished since start, by result\n# TYPE %s counter\n", deliveries, deliveries)
+	fmt.Fprintf(&b, "%s{result=\"delivered\"} %d\n%s{result=\"failed\"} %d\n", deliveries, webhooks.Delivered, deliveries, webhooks.Failed)
+	const dropped = "stateledger_webhook_events_dropped_total"
+	fmt.Fprintf(&b, "# HELP %s Webhook events dropped since start because the subscription's queue was full\n# TYPE %s counter\n%s %d\n", dropped, dropped, dropped, webhooks.Dropped)
+	const lag = "stateledger_webhook_lag_seconds"
+	fmt.Fprintf(&b, "# HELP %s Age of the oldest pending delivery, by subscription\n# TYPE %s gauge\n", lag, lag)
+	for _, sub := range webhooks.Subscriptions {
+		fmt.Fprintf(&b, "%s{subscription=\"%s\"} %g\n", lag, labelEscaper.Replace(sub.ID), sub.LagSeconds)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, b.String())
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/Retr0-XD/StateLedger/internal/ledger"
+)
+
+// maxSchemaBytes caps the size of a registered schema document
+const maxSchemaBytes = 1 << 20
+
+// handleListSchemas returns the latest payload schema of every record type
+func (s *Server) handleListSchemas(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	schemas, err := s.ledger.Schemas()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SuccessResponse(schemas))
+}
+
+// handleListTypes returns the record type registry: built-in, registered
+// and schema-described types, and any other types found on records
+func (s *Server) handleListTypes(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	reg, err := s.ledger.Types()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SuccessResponse(reg))
+}
+
+// handleGetSchema returns a record type's payload schema with its version
+// history. ?version=N selects an older version; the latest is the default.
+func (s *Server) handleGetSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	recordType := r.PathValue("type")
+	version := 0
+	if v := r.URL.Query().Get("version"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorResponse("version must be a positive integer"))
+			return
+		}
+		version = n
+	}
+
+	schema, err := s.ledger.Schema(recordType, version)
+	if err == nil {
+		var history []ledger.PayloadSchema
+		history, err = s.ledger.SchemaHistory(recordType)
+		if err == nil {
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(SuccessResponse(map[string]interface{}{
+				"type":     recordType,
+				"schema":   schema,
+				"versions": history,
+			}))
+			return
+		}
+	}
+	if errors.Is(err, ledger.ErrUnknownSchema) {
+		w.WriteHeader(http.StatusNotFound)
+	} else {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+}
+
+// handleRegisterSchema records the request body as the next schema version
+// of a custom record type
+func (s *Server) handleRegisterSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSchemaBytes))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse("Invalid request body: " + err.Error()))
+		return
+	}
+
+	schema, err := s.ledger.RegisterSchema(r.PathValue("type"), body)
+	if errors.Is(err, ledger.ErrInvalidSchema) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(SuccessResponse(schema))
+}
@@ -1,30 +1,52 @@
 package api
 
 import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"os"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/Retr0-XD/StateLedger/internal/collectors"
 	"github.com/Retr0-XD/StateLedger/internal/ledger"
 )
 
 // Server handles HTTP requests for StateLedger API
 type Server struct {
-	ledger *ledger.Ledger
-	router *http.ServeMux
-	addr   string
+	ledger   *ledger.Ledger
+	webhooks *ledger.WebhookManager
+	router   *http.ServeMux
+	addr     string
+	leader   leaderState
+	health   chainHealth
+	policy   policyState
+	flights  flightGroup
+
+	// queryBudget is the time.Duration set with SetQueryBudget
+	queryBudget atomic.Int64
 }
 
 // NewServer creates a new API server
 func NewServer(l *ledger.Ledger, addr string) *Server {
 	s := &Server{
-		ledger: l,
-		addr:   addr,
-		router: http.NewServeMux(),
+		ledger:   l,
+		webhooks: ledger.NewWebhookManager(),
+		addr:     addr,
+		router:   http.NewServeMux(),
 	}
 	s.setupRoutes()
+	s.SetPolicy(Policy{})
+	s.SetQueryBudget(defaultQueryBudget)
 	return s
 }
 
@@ -32,21 +54,106 @@ func NewServer(l *ledger.Ledger, addr string) *Server {
 func (s *Server) setupRoutes() {
 	// Health check
 	s.router.HandleFunc("GET /health", s.handleHealth)
+	s.router.HandleFunc("GET /metrics", s.handlePrometheusMetrics)
 
 	// Ledger endpoints
 	s.router.HandleFunc("GET /api/v1/health", s.handleHealth)
 	s.router.HandleFunc("GET /api/v1/records", s.handleListRecords)
 	s.router.HandleFunc("GET /api/v1/records/{id}", s.handleGetRecord)
+	s.router.HandleFunc("GET /api/v1/records/hash/{hash}", s.handleGetRecordByHash)
 	s.router.HandleFunc("POST /api/v1/records", s.handleCreateRecord)
+	s.router.HandleFunc("POST /api/v1/records/batch", s.handleCreateRecordBatch)
 	s.router.HandleFunc("GET /api/v1/verify", s.handleVerify)
+	s.router.HandleFunc("GET /api/v1/chain/graph", s.handleChainGraph)
 	s.router.HandleFunc("GET /api/v1/snapshot", s.handleSnapshot)
 	s.router.HandleFunc("POST /api/v1/snapshot", s.handleSnapshot)
+	s.router.HandleFunc("GET /api/v1/snapshot/mutations", s.handleSnapshotMutations)
+	s.router.HandleFunc("GET /api/v1/config/diff", s.handleConfigDiff)
+	s.router.HandleFunc("GET /api/v1/replay/progress", s.handleReplayProgress)
+	s.router.HandleFunc("GET /api/v1/cache", s.handleCacheStats)
+	s.router.HandleFunc("GET /api/v1/stats", s.handleStats)
+	s.router.HandleFunc("GET /api/v1/analytics/coverage", s.handleCoverageAnalytics)
+	s.router.HandleFunc("GET /api/v1/audit", s.handleAudit)
+	s.router.HandleFunc("GET /api/v1/state/latest", s.handleLatestState)
+	s.router.HandleFunc("GET /api/v1/sources", s.handleSources)
+	s.router.HandleFunc("GET /api/v1/mirror", s.handleMirror)
+	s.router.HandleFunc("GET /api/v1/leader", s.handleLeader)
+
+	// Agent registry
+	s.router.HandleFunc("GET /api/v1/agents", s.handleListAgents)
+	s.router.HandleFunc("POST /api/v1/agents", s.handleRegisterAgent)
+	s.router.HandleFunc("POST /api/v1/agents/{id}/heartbeat", s.handleAgentHeartbeat)
+	s.router.HandleFunc("GET /api/v1/agents/{id}/verify", s.handleVerifyAgentChain)
+	s.router.HandleFunc("GET /api/v1/agents/{id}/ingest", s.handleIngestCursor)
+	s.router.HandleFunc("POST /api/v1/agents/{id}/ingest", s.handleIngest)
+
+	// Payload schemas
+	s.router.HandleFunc("GET /api/v1/schemas", s.handleListSchemas)
+	s.router.HandleFunc("GET /api/v1/schemas/{type}", s.handleGetSchema)
+	s.router.HandleFunc("POST /api/v1/schemas/{type}", s.handleRegisterSchema)
+	s.router.HandleFunc("GET /api/v1/types", s.handleListTypes)
+
+	// Policies recorded in the ledger
+	s.router.HandleFunc("GET /api/v1/policies", s.handleListPolicies)
+	s.router.HandleFunc("GET /api/v1/policies/{kind}", s.handleGetPolicy)
+
+	// Web UI
+	s.router.HandleFunc("GET /ui", handleUIRedirect)
+	s.router.Handle("GET /ui/", uiHandler())
+
+	// Two-person approval of administrative actions
+	s.router.HandleFunc("GET /api/v1/admin/requests", s.handleListAdminRequests)
+	s.router.HandleFunc("POST /api/v1/admin/requests", s.handleCreateAdminRequest)
+	s.router.HandleFunc("POST /api/v1/admin/requests/{id}/approve", s.handleApproveAdminRequest)
+	s.router.HandleFunc("GET /api/v1/admin/api-keys", s.handleListAPIKeys)
+	s.router.HandleFunc("POST /api/v1/admin/api-keys/{id}/revoke", s.handleRevokeAPIKey)
+	s.router.HandleFunc("POST /api/v1/admin/api-keys/{id}/restore", s.handleRestoreAPIKey)
+
+	// Webhook subscriptions
+	s.router.HandleFunc("GET /api/v1/webhooks", s.handleListWebhooks)
+	s.router.HandleFunc("POST /api/v1/webhooks", s.handleCreateWebhook)
+	s.router.HandleFunc("DELETE /api/v1/webhooks/{id}", s.handleDeleteWebhook)
+	s.router.HandleFunc("POST /api/v1/webhooks/{id}/restore", s.handleRestoreWebhook)
+}
+
+// Handler returns the HTTP handler serving the API routes under the
+// current Policy
+func (s *Server) Handler() http.Handler {
+	return http.HandlerFunc(s.serveWithPolicy)
+}
+
+// Webhooks returns the server's webhook manager
+func (s *Server) Webhooks() *ledger.WebhookManager {
+	return s.webhooks
 }
 
 // Start starts the HTTP server
 func (s *Server) Start() error {
 	fmt.Printf("Starting StateLedger API server on %s\n", s.addr)
-	return http.ListenAndServe(s.addr, s.router)
+	return http.ListenAndServe(s.addr, s.Handler())
+}
+
+// StartTLS starts the HTTPS server. When clientCAFile is set, client
+// certificates signed by that CA are verified, which agents need to push
+// records; other endpoints remain reachable without a certificate.
+func (s *Server) StartTLS(certFile, keyFile, clientCAFile string) error {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if clientCAFile != "" {
+		pem, err := os.ReadFile(clientCAFile)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("%s: no certificates found", clientCAFile)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	srv := &http.Server{Addr: s.addr, Handler: s.Handler(), TLSConfig: cfg}
+	fmt.Printf("Starting StateLedger API server on %s (TLS)\n", s.addr)
+	return srv.ListenAndServeTLS(certFile, keyFile)
 }
 
 // Response wraps API responses
@@ -86,7 +193,8 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 
 // ListRecordsRequest represents query parameters for listing records
 type ListRecordsRequest struct {
-	Kind      string `json:"kind,omitempty"`      // code, config, environment, mutation
+	Kind      string `json:"kind,omitempty"`      // Record types, comma-separated
+	Source    string `json:"source,omitempty"`    // Record sources, comma-separated
 	Namespace string `json:"namespace,omitempty"` // For filtering
 	Limit     int    `json:"limit,omitempty"`     // Default 100
 	Offset    int    `json:"offset,omitempty"`    // For pagination
@@ -96,23 +204,106 @@ type ListRecordsRequest struct {
 
 // RecordResponse represents a ledger record in API response
 type RecordResponse struct {
-	ID        int64       `json:"id"`
-	Kind      string      `json:"kind"`
-	Timestamp string      `json:"timestamp"`
-	Hash      string      `json:"hash"`
-	Payload   interface{} `json:"payload"`
+	ID        int64             `json:"id"`
+	UID       string            `json:"uid,omitempty"`
+	Kind      string            `json:"kind"`
+	Timestamp string            `json:"timestamp"`
+	Hash      string            `json:"hash"`
+	Payload   interface{}       `json:"payload"`
+	AgentID   string            `json:"agent_id,omitempty"`
+	Seq       int64             `json:"seq,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+// recordFormat is how a request asks for records to be presented: parse
+// decodes payloads, and timestamps are formatted in loc
+type recordFormat struct {
+	parse bool
+	loc   *time.Location
 }
 
-// handleListRecords lists records with optional filtering
+// requestRecordFormat reads the parse and tz query parameters. tz names
+// the time zone of formatted times, UTC by default; filters still compare
+// Unix seconds.
+func requestRecordFormat(r *http.Request) (recordFormat, error) {
+	loc, err := ledger.ParseTimeZone(r.URL.Query().Get("tz"))
+	if err != nil {
+		return recordFormat{}, err
+	}
+	return recordFormat{parse: r.URL.Query().Get("parse") == "true", loc: loc}, nil
+}
+
+// newRecordResponse converts a record for the API. The payload is returned
+// as the stored string unless f.parse is set, in which case it is decoded
+// as its collector type, or as generic JSON for other types. Payloads that
+// do not decode are still returned as strings.
+func newRecordResponse(rec ledger.Record, f recordFormat) RecordResponse {
+	resp := RecordResponse{
+		ID:        rec.ID,
+		UID:       rec.UID,
+		Kind:      rec.Type,
+		Timestamp: ledger.FormatTime(rec.Timestamp, f.loc),
+		Hash:      rec.Hash,
+		Payload:   rec.Payload,
+		AgentID:   rec.AgentID,
+		Seq:       rec.Seq,
+		Labels:    rec.Labels,
+	}
+	if f.parse {
+		if payload, ok := parsePayload(rec.Type, rec.Payload); ok {
+			resp.Payload = payload
+		}
+	}
+	return resp
+}
+
+// parsePayload decodes a payload as the collector type of kind, falling
+// back to generic JSON for other kinds
+func parsePayload(kind, raw string) (interface{}, bool) {
+	var payload interface{}
+	var err error
+	switch kind {
+	case "code":
+		payload, err = decodePayload[collectors.CodePayload](raw)
+	case "config":
+		payload, err = decodePayload[collectors.ConfigPayload](raw)
+	case "environment":
+		payload, err = decodePayload[collectors.EnvironmentPayload](raw)
+	case "mutation":
+		payload, err = decodePayload[collectors.MutationPayload](raw)
+	default:
+		err = errors.New("not a collector type")
+	}
+	if err == nil {
+		return payload, true
+	}
+	if json.Valid([]byte(raw)) {
+		return json.RawMessage(raw), true
+	}
+	return nil, false
+}
+
+func decodePayload[T any](raw string) (interface{}, error) {
+	var p T
+	if err := collectors.ParseJSON(raw, &p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// handleListRecords lists records with optional filtering. Pages are
+// fetched with ?cursor= set to the next_cursor of the previous page, or
+// with ?offset=.
 func (s *Server) handleListRecords(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	limit := 100
 	offset := 0
+	var cursor int64
 
 	// Parse query parameters
 	if l := r.URL.Query().Get("limit"); l != "" {
-		if val, err := strconv.Atoi(l); err == nil && val > 0 && val <= 1000 {
+		if val, err := strconv.Atoi(l); err == nil && val > 0 {
 			limit = val
 		}
 	}
@@ -121,92 +312,430 @@ func (s *Server) handleListRecords(w http.ResponseWriter, r *http.Request) {
 			offset = val
 		}
 	}
+	if c := r.URL.Query().Get("cursor"); c != "" {
+		val, err := strconv.ParseInt(c, 10, 64)
+		if err != nil || val < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorResponse("invalid cursor"))
+			return
+		}
+		if offset > 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorResponse("cursor and offset cannot be combined"))
+			return
+		}
+		cursor = val
+	}
+	var sinceSeq int64
+	if v := r.URL.Query().Get("since_seq"); v != "" {
+		val, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || val < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorResponse("invalid since_seq"))
+			return
+		}
+		sinceSeq = val
+	}
+	labels, err := ledger.ParseLabelSelector(strings.Join(r.URL.Query()["label"], ","))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
 
-	// Get records from ledger
-	records, err := s.ledger.List(ledger.ListQuery{
-		Since: 0,
-		Until: time.Now().Unix(),
-		Limit: limit + offset,
-	})
+	format, err := requestRecordFormat(r)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
 		return
 	}
 
-	// Apply pagination
-	if offset > len(records) {
-		offset = len(records)
+	// Stream records from the ledger as they are read, asking for one
+	// more than the page to learn whether another page follows. A page
+	// that runs out of query budget ends early, marked truncated.
+	stream := newRecordStream(w, limit, format, s.queryDeadline())
+	head, err := s.ledger.IterateWithHead(ledger.ListQuery{
+		Since:       0,
+		Until:       time.Now().Unix(),
+		Limit:       limit + 1,
+		Offset:      offset,
+		AfterID:     cursor,
+		TraceID:     r.URL.Query().Get("trace_id"),
+		Types:       queryList(r, "kind"),
+		Sources:     queryList(r, "source"),
+		JSONFilters: payloadFilters(r),
+		SinceSeq:    sinceSeq,
+		Labels:      labels,
+	}, stream.add)
+	if errors.Is(err, errPageDone) {
+		err = nil
 	}
-	end := offset + limit
-	if end > len(records) {
-		end = len(records)
+	if err != nil && !stream.started {
+		status := http.StatusInternalServerError
+		if errors.Is(err, ledger.ErrInvalidJSONPath) || errors.Is(err, ledger.ErrSinceSeqSource) {
+			status = http.StatusBadRequest
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
 	}
 
-	records = records[offset:end]
+	var errMsg string
+	if err != nil {
+		errMsg = err.Error()
+	}
+	stream.finish(map[string]interface{}{
+		"offset": offset,
+		"limit":  limit,
+		"head":   head,
+	}, errMsg)
+}
 
-	// Convert to response format
-	var responses []RecordResponse
-	for _, rec := range records {
-		responses = append(responses, RecordResponse{
-			ID:        rec.ID,
-			Kind:      rec.Type,
-			Timestamp: time.Unix(rec.Timestamp, 0).Format(time.RFC3339),
-			Hash:      rec.Hash,
-			Payload:   rec.Payload,
-		})
+// payloadFilters collects payload.<path>=<value> query parameters as JSON
+// filters
+func payloadFilters(r *http.Request) map[string]string {
+	var filters map[string]string
+	for name, values := range r.URL.Query() {
+		path, ok := strings.CutPrefix(name, "payload.")
+		if !ok || len(values) == 0 {
+			continue
+		}
+		if filters == nil {
+			filters = map[string]string{}
+		}
+		filters[path] = values[0]
 	}
+	return filters
+}
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(SuccessResponse(map[string]interface{}{
-		"records": responses,
-		"offset":  offset,
-		"limit":   limit,
-		"total":   len(records),
-	}))
+// queryList collects a query parameter given either repeated or as a
+// comma-separated list.
+func queryList(r *http.Request, name string) []string {
+	var out []string
+	for _, v := range r.URL.Query()[name] {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				out = append(out, item)
+			}
+		}
+	}
+	return out
 }
 
 // handleGetRecord retrieves a specific record
 func (s *Server) handleGetRecord(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
+	format, err := requestRecordFormat(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
+
+	// The ID is the integer ID or, for ledgers with an ID generator, the
+	// record's UID.
 	idStr := r.PathValue("id")
+	var rec ledger.Record
 	id, err := strconv.ParseInt(idStr, 10, 64)
-	if err != nil {
+	switch {
+	case err == nil:
+		rec, err = s.ledger.GetByID(id)
+	case ledger.IsRecordUID(idStr):
+		rec, err = s.ledger.GetByUID(idStr)
+	default:
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(ErrorResponse("Invalid record ID"))
 		return
 	}
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorResponse("Record not found"))
+		return
+	}
 
-	rec, err := s.ledger.GetByID(id)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SuccessResponse(newRecordResponse(rec, format)))
+}
+
+// handleGetRecordByHash returns the record with a chain hash, for auditors
+// holding a hash from a proof or an anchor
+func (s *Server) handleGetRecordByHash(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	format, err := requestRecordFormat(r)
 	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
+
+	hash := strings.ToLower(r.PathValue("hash"))
+	if b, err := hex.DecodeString(hash); err != nil || len(b) != sha256.Size {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse("Invalid record hash"))
+		return
+	}
+	rec, err := s.ledger.GetByHash(hash)
+	if errors.Is(err, sql.ErrNoRows) {
 		w.WriteHeader(http.StatusNotFound)
 		json.NewEncoder(w).Encode(ErrorResponse("Record not found"))
 		return
 	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
 
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(SuccessResponse(RecordResponse{
-		ID:        rec.ID,
-		Kind:      rec.Type,
-		Timestamp: time.Unix(rec.Timestamp, 0).Format(time.RFC3339),
-		Hash:      rec.Hash,
-		Payload:   rec.Payload,
-	}))
+	json.NewEncoder(w).Encode(SuccessResponse(newRecordResponse(rec, format)))
 }
 
-// handleCreateRecord creates a new record (placeholder)
+// maxRecordBody caps the size of a record created through the API
+const maxRecordBody = 4 << 20
+
+// CreateRecordRequest is the body of POST /api/v1/records. Payload is a
+// JSON object, which is stored canonicalized, or a string stored as is.
+type CreateRecordRequest struct {
+	Type      string          `json:"type"`
+	Source    string          `json:"source,omitempty"`
+	Timestamp int64           `json:"timestamp,omitempty"` // Unix seconds (default: now)
+	Payload   json.RawMessage `json:"payload"`
+	// IdempotencyKey makes retrying the append safe: a record already
+	// appended under the key is returned instead of a duplicate
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	// Labels tag the record for ?label= selectors and are part of its hash
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// handleCreateRecord appends a record and returns it with its hash.
+// Payloads of the collector kinds (code, config, environment, mutation)
+// must be valid for their collector. Writes are authenticated by the API
+// keys of the current Policy and, for sources with a registered key, by a
+// source signature over the body. The idempotency key may also be sent as
+// the Idempotency-Key header; a retried append returns 200 with the record
+// appended under the key.
 func (s *Server) handleCreateRecord(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusNotImplemented)
-	json.NewEncoder(w).Encode(ErrorResponse("Record creation via API not implemented yet"))
+
+	loc, err := ledger.ParseTimeZone(r.URL.Query().Get("tz"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
+
+	raw, err := io.ReadAll(io.LimitReader(r.Body, maxRecordBody))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse("Invalid request body: " + err.Error()))
+		return
+	}
+	var req CreateRecordRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse("Invalid request body: " + err.Error()))
+		return
+	}
+	payload, err := recordPayload(req.Payload)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
+	if status, err := s.verifySourceSignatures(r, raw, []string{req.Source}); err != nil {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
+
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		if req.IdempotencyKey != "" && req.IdempotencyKey != key {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorResponse("Idempotency-Key header and idempotency_key differ"))
+			return
+		}
+		req.IdempotencyKey = key
+	}
+
+	if req.Timestamp == 0 {
+		req.Timestamp = time.Now().Unix()
+	}
+	records, created, err := s.ledger.AppendBatchIdempotent([]ledger.RecordInput{{
+		Timestamp:      req.Timestamp,
+		Type:           req.Type,
+		Source:         req.Source,
+		Payload:        payload,
+		IdempotencyKey: req.IdempotencyKey,
+		Labels:         req.Labels,
+	}})
+	if err != nil {
+		w.WriteHeader(appendStatus(err))
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
+	rec := records[0]
+	if !created[0] {
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(SuccessResponse(newRecordResponse(rec, recordFormat{loc: loc})))
+		return
+	}
+	s.Publish(ledger.WebhookEvent{EventType: ledger.EventRecordAppended, Timestamp: time.Now(), Data: rec})
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(SuccessResponse(newRecordResponse(rec, recordFormat{loc: loc})))
+}
+
+// maxRecordBatch and maxRecordBatchBody cap the records and the body size
+// of one batch append
+const (
+	maxRecordBatch     = 1000
+	maxRecordBatchBody = 64 << 20
+)
+
+// backpressureRetryAfter is the Retry-After, in seconds, of batch appends
+// refused while the ledger is behind its append SLO
+const backpressureRetryAfter = "5"
+
+// shedBatch refuses a batch append with 503 and Retry-After while appends
+// are slower than the SLO, so producers back off instead of timing out.
+// It reports whether the request was refused.
+func (s *Server) shedBatch(w http.ResponseWriter) bool {
+	latency := s.ledger.AppendLatency()
+	if !latency.Behind {
+		return false
+	}
+	w.Header().Set("Retry-After", backpressureRetryAfter)
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(ErrorResponse(fmt.Sprintf("appends are behind: p95 %s exceeds the %s SLO", latency.RecentP95, latency.SLO)))
+	return true
+}
+
+// handleCreateRecordBatch appends an array of records in one transaction.
+// Either all records are appended, chained in order onto the current head,
+// or none are.
+func (s *Server) handleCreateRecordBatch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if s.shedBatch(w) {
+		return
+	}
+
+	loc, err := ledger.ParseTimeZone(r.URL.Query().Get("tz"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
+
+	raw, err := io.ReadAll(io.LimitReader(r.Body, maxRecordBatchBody))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse("Invalid request body: " + err.Error()))
+		return
+	}
+	var reqs []CreateRecordRequest
+	if err := json.Unmarshal(raw, &reqs); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse("Invalid request body: " + err.Error()))
+		return
+	}
+	if len(reqs) == 0 || len(reqs) > maxRecordBatch {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse(fmt.Sprintf("a batch holds 1 to %d records", maxRecordBatch)))
+		return
+	}
+
+	now := time.Now().Unix()
+	inputs := make([]ledger.RecordInput, len(reqs))
+	sources := make([]string, len(reqs))
+	for i, req := range reqs {
+		payload, err := recordPayload(req.Payload)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorResponse(fmt.Sprintf("record %d: %s", i, err)))
+			return
+		}
+		if req.Timestamp == 0 {
+			req.Timestamp = now
+		}
+		inputs[i] = ledger.RecordInput{Timestamp: req.Timestamp, Type: req.Type, Source: req.Source, Payload: payload, IdempotencyKey: req.IdempotencyKey, Labels: req.Labels}
+		sources[i] = req.Source
+	}
+	if status, err := s.verifySourceSignatures(r, raw, sources); err != nil {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
+
+	records, created, err := s.ledger.AppendBatchIdempotent(inputs)
+	if err != nil {
+		w.WriteHeader(appendStatus(err))
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
+	out := make([]RecordResponse, len(records))
+	appended := 0
+	for i, rec := range records {
+		if created[i] {
+			s.Publish(ledger.WebhookEvent{EventType: ledger.EventRecordAppended, Timestamp: time.Now(), Data: rec})
+			appended++
+		}
+		out[i] = newRecordResponse(rec, recordFormat{loc: loc})
+	}
+
+	// A batch that only repeats earlier idempotency keys created nothing.
+	status := http.StatusCreated
+	if appended == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(SuccessResponse(map[string]interface{}{
+		"records": out,
+		"count":   len(out),
+		"created": appended,
+	}))
+}
+
+// appendStatus returns the status of a failed append: 400 for records the
+// ledger refuses, 409 for an idempotency key whose record was pruned, 422
+// for an idempotency key reused for a different record and 500 for
+// anything else, such as a storage failure
+func appendStatus(err error) int {
+	switch {
+	case errors.Is(err, ledger.ErrInvalidRecord), errors.Is(err, ledger.ErrInvalidPayload), errors.Is(err, ledger.ErrUnknownType):
+		return http.StatusBadRequest
+	case errors.Is(err, ledger.ErrIdempotentRecordPruned):
+		return http.StatusConflict
+	case errors.Is(err, ledger.ErrIdempotencyConflict):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// recordPayload returns the payload to store for a created record: a JSON
+// string as its contents and any other JSON value canonicalized
+func recordPayload(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", errors.New("payload required")
+	}
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text, nil
+	}
+	canonical, err := collectors.CanonicalizeJSON(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid payload: %w", err)
+	}
+	return string(canonical), nil
 }
 
 // handleVerify verifies ledger integrity
 func (s *Server) handleVerify(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	result, err := s.ledger.VerifyChain()
+	result, err := s.verifyChain()
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
@@ -223,10 +752,215 @@ func (s *Server) handleVerify(w http.ResponseWriter, r *http.Request) {
 	}))
 }
 
+// handleCacheStats reports read cache hit rates and read replica routing
+func (s *Server) handleCacheStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	stats, enabled := s.ledger.CacheStats()
+	replicas := s.ledger.ReplicaStats()
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SuccessResponse(map[string]interface{}{
+		"enabled":           enabled,
+		"hits":              stats.Hits,
+		"misses":            stats.Misses,
+		"hit_rate":          stats.HitRate,
+		"entries":           stats.Entries,
+		"read_replicas":     replicas.Replicas,
+		"replica_fallbacks": replicas.Fallbacks,
+	}))
+}
+
+// handleStats reports the ledger's size, records by type and source and last
+// verification, the records appended through this server per type and per
+// source, append latency against the SLO, and pending webhook deliveries
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	stats, err := s.ledger.Stats()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SuccessResponse(map[string]interface{}{
+		"chain":          stats.ChainStats,
+		"ledger":         stats,
+		"appends":        s.ledger.AppendStats(),
+		"append_latency": s.ledger.AppendLatency(),
+		"webhooks":       s.webhooks.Stats(),
+	}))
+}
+
+// handleSnapshotMutations filters and pages the mutation records of a snapshot
+func (s *Server) handleSnapshotMutations(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	q := r.URL.Query()
+	filter := ledger.MutationFilter{
+		Until:     time.Now().Unix(),
+		Type:      q.Get("type"),
+		Source:    q.Get("source"),
+		Namespace: q.Get("namespace"),
+	}
+
+	badRequest := func(msg string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse(msg))
+	}
+
+	loc, err := ledger.ParseTimeZone(q.Get("tz"))
+	if err != nil {
+		badRequest(err.Error())
+		return
+	}
+
+	if ts := q.Get("time"); ts != "" {
+		t, err := time.Parse(time.RFC3339, ts)
+		if err != nil {
+			badRequest("Invalid time: " + err.Error())
+			return
+		}
+		filter.Until = t.Unix()
+	}
+	for name, dst := range map[string]**int64{"ref_from": &filter.RefFrom, "ref_to": &filter.RefTo} {
+		if v := q.Get(name); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				badRequest("Invalid " + name + ": must be an integer offset")
+				return
+			}
+			*dst = &n
+		}
+	}
+	for name, dst := range map[string]*int{"offset": &filter.Offset, "limit": &filter.Limit} {
+		if v := q.Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				badRequest("Invalid " + name + ": must be a non-negative integer")
+				return
+			}
+			*dst = n
+		}
+	}
+
+	page, err := s.ledger.QueryMutations(filter)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SuccessResponse(map[string]interface{}{
+		"time":    ledger.FormatTime(filter.Until, loc),
+		"records": page.Records,
+		"total":   page.Total,
+		"offset":  page.Offset,
+		"limit":   page.Limit,
+	}))
+}
+
+// handleSources reports registered sources that are overdue or missing and
+// unregistered sources that have captured records
+func (s *Server) handleSources(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	at := time.Now().Unix()
+	if ts := r.URL.Query().Get("time"); ts != "" {
+		t, err := time.Parse(time.RFC3339, ts)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorResponse("Invalid time: " + err.Error()))
+			return
+		}
+		at = t.Unix()
+	}
+	window := ledger.DefaultNewSourceWindow
+	if v := r.URL.Query().Get("new_window"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorResponse("Invalid new_window: must be a duration such as 24h"))
+			return
+		}
+		window = d
+	}
+
+	report, err := s.ledger.SourceStatus(at, window)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SuccessResponse(report))
+}
+
+// handleLatestState returns the current known state per dimension
+func (s *Server) handleLatestState(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	state, err := s.ledger.LatestState()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SuccessResponse(state))
+}
+
+// handleAudit exports an audit bundle for a point in time; with
+// mode=strict a reconstruction with any issue is refused with 422
+func (s *Server) handleAudit(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	targetTime := time.Now()
+	if ts := r.URL.Query().Get("time"); ts != "" {
+		t, err := time.Parse(time.RFC3339, ts)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorResponse("Invalid time: " + err.Error()))
+			return
+		}
+		targetTime = t
+	}
+	mode, err := ledger.ParseMode(r.URL.Query().Get("mode"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
+
+	bundle, err := s.flights.do("audit|"+mode+"|"+strconv.FormatInt(targetTime.Unix(), 10), func() (any, error) {
+		rec := ledger.New(s.ledger)
+		rec.Mode = mode
+		return rec.ExportAuditBundle(targetTime.Unix())
+	})
+	if errors.Is(err, ledger.ErrStrictReconstruction) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SuccessResponse(bundle))
+}
+
 // SnapshotRequest represents a snapshot query
 type SnapshotRequest struct {
-	Time      string `json:"time,omitempty"`       // RFC3339 timestamp (default: now)
-	Namespace string `json:"namespace,omitempty"`  // Filter by namespace
+	Time      string `json:"time,omitempty"`      // RFC3339 timestamp (default: now)
+	Namespace string `json:"namespace,omitempty"` // Filter by namespace
 }
 
 // handleSnapshot reconstructs state at a point in time
@@ -245,22 +979,99 @@ func (s *Server) handleSnapshot(w http.ResponseWriter, r *http.Request) {
 			targetTime = t
 		}
 	}
+	loc, err := ledger.ParseTimeZone(r.URL.Query().Get("tz"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
+
+	// A limit or cursor pages through a large snapshot; without a limit
+	// the snapshot holds every record, unless the query budget runs out.
+	var limit int
+	var cursor int64
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorResponse("Invalid limit: must be a non-negative integer"))
+			return
+		}
+		limit = n
+	}
+	if v := r.URL.Query().Get("cursor"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorResponse("invalid cursor"))
+			return
+		}
+		cursor = n
+	}
 
-	records, err := s.ledger.List(ledger.ListQuery{
-		Since: 0,
-		Until: targetTime.Unix(),
-		Limit: 1000,
+	key := fmt.Sprintf("snapshot|%d|%d|%d", targetTime.Unix(), limit, cursor)
+	v, err := s.flights.do(key, func() (any, error) {
+		return s.snapshotPage(targetTime, limit, cursor)
 	})
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
 		return
 	}
+	page := v.(snapshotPage)
 
+	data := map[string]interface{}{
+		"time":    targetTime.In(loc).Format(time.RFC3339),
+		"records": page.records,
+		"count":   len(page.records),
+		"head":    page.head,
+	}
+	if page.nextCursor != "" {
+		data["next_cursor"] = page.nextCursor
+	}
+	if page.truncated {
+		data["truncated"] = true
+	}
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(SuccessResponse(map[string]interface{}{
-		"time":    targetTime.Format(time.RFC3339),
-		"records": records,
-		"count":   len(records),
-	}))
+	json.NewEncoder(w).Encode(SuccessResponse(data))
+}
+
+// snapshotPage is the part of a snapshot one request returns
+type snapshotPage struct {
+	records    []ledger.Record
+	head       ledger.ChainHead
+	nextCursor string
+	truncated  bool
+}
+
+// snapshotPage reads up to limit records known at targetTime after cursor,
+// stopping early when the query budget is spent
+func (s *Server) snapshotPage(targetTime time.Time, limit int, cursor int64) (snapshotPage, error) {
+	deadline := s.queryDeadline()
+	page := snapshotPage{records: []ledger.Record{}}
+	more := false
+	q := ledger.ListQuery{Until: targetTime.Unix(), AfterID: cursor}
+	if limit > 0 {
+		q.Limit = limit + 1
+	}
+	head, err := s.ledger.IterateWithHead(q, func(rec ledger.Record) error {
+		if limit > 0 && len(page.records) == limit {
+			more = true
+			return errPageDone
+		}
+		if len(page.records) > 0 && pastDeadline(deadline) {
+			page.truncated = true
+			return errPageDone
+		}
+		page.records = append(page.records, rec)
+		return nil
+	})
+	if err != nil && !errors.Is(err, errPageDone) {
+		return snapshotPage{}, err
+	}
+	page.head = head
+	if more || page.truncated {
+		page.nextCursor = strconv.FormatInt(page.records[len(page.records)-1].ID, 10)
+	}
+	return page, nil
 }
@@ -2,9 +2,21 @@ package api
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -85,6 +97,172 @@ func TestHandleListRecordsWithPagination(t *testing.T) {
 	}
 }
 
+func TestHandleListRecordsCursor(t *testing.T) {
+	s := setupTestServer(t)
+	for i := 0; i < 5; i++ {
+		if _, err := s.ledger.Append(ledger.RecordInput{Timestamp: 1000 + int64(i), Type: "event", Source: "app", Payload: "e"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	page := func(query string) (int, []int64, string) {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/records?"+query, nil))
+		var resp struct {
+			Data struct {
+				Records    []RecordResponse `json:"records"`
+				NextCursor string           `json:"next_cursor"`
+			} `json:"data"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		var ids []int64
+		for _, rec := range resp.Data.Records {
+			ids = append(ids, rec.ID)
+		}
+		return w.Code, ids, resp.Data.NextCursor
+	}
+
+	var all []int64
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatal("cursor paging did not end")
+		}
+		code, ids, next := page("limit=2&cursor=" + cursor)
+		if code != http.StatusOK {
+			t.Fatalf("status %d", code)
+		}
+		all = append(all, ids...)
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if len(all) != 5 || all[0] != 1 || all[4] != 5 {
+		t.Fatalf("cursor pages = %v", all)
+	}
+
+	if _, ids, next := page("limit=5"); len(ids) != 5 || next != "" {
+		t.Errorf("exactly full last page: %v next %q", ids, next)
+	}
+	if _, ids, _ := page("limit=2&offset=3"); len(ids) != 2 || ids[0] != 4 {
+		t.Errorf("offset page = %v", ids)
+	}
+	if code, _, _ := page("cursor=abc"); code != http.StatusBadRequest {
+		t.Errorf("invalid cursor: status %d", code)
+	}
+	if code, _, _ := page("cursor=2&offset=1"); code != http.StatusBadRequest {
+		t.Errorf("cursor with offset: status %d", code)
+	}
+}
+
+func TestQueryBudgetTruncates(t *testing.T) {
+	s := setupTestServer(t)
+	for i := 0; i < 5; i++ {
+		if _, err := s.ledger.Append(ledger.RecordInput{Timestamp: 1000 + int64(i), Type: "event", Source: "app", Payload: "e"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Every page runs out of budget after its first record.
+	s.SetQueryBudget(time.Nanosecond)
+
+	type page struct {
+		Records    []json.RawMessage `json:"records"`
+		Total      int               `json:"total"`
+		Head       ledger.ChainHead  `json:"head"`
+		NextCursor string            `json:"next_cursor"`
+		Truncated  bool              `json:"truncated"`
+	}
+	get := func(path string) page {
+		t.Helper()
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", path, w.Code, w.Body)
+		}
+		var resp struct {
+			Success bool `json:"success"`
+			Data    page `json:"data"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || !resp.Success {
+			t.Fatalf("%s: invalid response %q: %v", path, w.Body, err)
+		}
+		return resp.Data
+	}
+
+	for _, path := range []string{"/api/v1/records?limit=10&cursor=", "/api/v1/snapshot?cursor="} {
+		cursor, seen := "", 0
+		for {
+			p := get(path + cursor)
+			seen += len(p.Records)
+			if p.Head.ID != 5 {
+				t.Fatalf("%s: head = %+v", path, p.Head)
+			}
+			if p.NextCursor == "" {
+				if p.Truncated {
+					t.Fatalf("%s: truncated page without a cursor", path)
+				}
+				break
+			}
+			if !p.Truncated || len(p.Records) != 1 {
+				t.Fatalf("%s: page of %d records, truncated %v", path, len(p.Records), p.Truncated)
+			}
+			if seen > 5 {
+				t.Fatalf("%s: paging did not end", path)
+			}
+			cursor = p.NextCursor
+		}
+		if seen != 5 {
+			t.Fatalf("%s: read %d records, want 5", path, seen)
+		}
+	}
+
+	// Without a budget the whole listing fits one response.
+	s.SetQueryBudget(0)
+	if p := get("/api/v1/records?limit=2000"); p.Total != 5 || p.Truncated || p.NextCursor != "" {
+		t.Fatalf("unbudgeted page = %+v", p)
+	}
+	if p := get("/api/v1/snapshot?limit=2"); len(p.Records) != 2 || p.Truncated || p.NextCursor != "2" {
+		t.Fatalf("snapshot page = %+v", p)
+	}
+}
+
+func TestHandleListRecordsFilters(t *testing.T) {
+	s := setupTestServer(t)
+	inputs := []ledger.RecordInput{
+		{Timestamp: 1000, Type: "deploy", Source: "app.yaml", Payload: "e"},
+		{Timestamp: 1001, Type: "alert", Source: "git", Payload: "e"},
+		{Timestamp: 1002, Type: "deploy", Source: "db.yaml", Payload: "e"},
+	}
+	if _, err := s.ledger.AppendBatch(inputs); err != nil {
+		t.Fatal(err)
+	}
+	list := func(query string) []int64 {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/records?"+query, nil))
+		var resp struct {
+			Data struct {
+				Records []RecordResponse `json:"records"`
+			} `json:"data"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		var ids []int64
+		for _, rec := range resp.Data.Records {
+			ids = append(ids, rec.ID)
+		}
+		return ids
+	}
+
+	if ids := list("kind=deploy"); len(ids) != 2 || ids[0] != 1 || ids[1] != 3 {
+		t.Errorf("kind=deploy: %v", ids)
+	}
+	if ids := list("kind=deploy,alert&source=git"); len(ids) != 1 || ids[0] != 2 {
+		t.Errorf("kind list with source: %v", ids)
+	}
+	if ids := list("source=app.yaml&source=db.yaml"); len(ids) != 2 {
+		t.Errorf("repeated source: %v", ids)
+	}
+}
+
 func TestHandleGetRecord(t *testing.T) {
 	s := setupTestServer(t)
 	req := httptest.NewRequest("GET", "/api/v1/records/1", nil)
@@ -110,6 +288,26 @@ func TestHandleGetRecordInvalidID(t *testing.T) {
 	}
 }
 
+func TestHandleGetRecordByUID(t *testing.T) {
+	s := setupTestServer(t)
+	if err := s.ledger.SetIDGenerator(ledger.NewULID); err != nil {
+		t.Fatal(err)
+	}
+	rec, err := s.ledger.Append(ledger.RecordInput{Timestamp: 1, Type: "deploy", Source: "ci", Payload: "v"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/records/"+rec.UID, nil))
+	var resp struct {
+		Data RecordResponse `json:"data"`
+	}
+	if w.Code != http.StatusOK || json.NewDecoder(w.Body).Decode(&resp) != nil || resp.Data.ID != rec.ID || resp.Data.UID != rec.UID {
+		t.Fatalf("get by uid: %d %+v", w.Code, resp.Data)
+	}
+}
+
 func TestHandleVerify(t *testing.T) {
 	s := setupTestServer(t)
 	req := httptest.NewRequest("GET", "/api/v1/verify", nil)
@@ -174,13 +372,171 @@ func TestHandleSnapshotPOST(t *testing.T) {
 
 func TestHandleCreateRecord(t *testing.T) {
 	s := setupTestServer(t)
-	req := httptest.NewRequest("POST", "/api/v1/records", nil)
+	post := func(body string) (int, RecordResponse) {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/records", strings.NewReader(body)))
+		var resp struct {
+			Data RecordResponse `json:"data"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp.Data
+	}
+
+	code, rec := post(`{"type":"code","source":"ci","timestamp":1000,"payload":{"repo":"app","commit":"abc"}}`)
+	if code != http.StatusCreated || rec.ID != 1 || rec.Hash == "" {
+		t.Fatalf("create: status %d, %+v", code, rec)
+	}
+	stored, err := s.ledger.GetByID(rec.ID)
+	if err != nil || stored.Hash != rec.Hash || stored.Payload != `{"commit":"abc","repo":"app"}` {
+		t.Fatalf("stored record = %+v (%v)", stored, err)
+	}
+	if code, rec := post(`{"type":"deploy","payload":"v1.2 rolled out"}`); code != http.StatusCreated || rec.Payload != "v1.2 rolled out" {
+		t.Errorf("string payload: status %d, %+v", code, rec)
+	}
+
+	bad := map[string]string{
+		"no body":         ``,
+		"no payload":      `{"type":"code"}`,
+		"no type":         `{"payload":{"repo":"app"}}`,
+		"invalid payload": `{"type":"code","payload":{"repo":"app","unknown":1}}`,
+	}
+	for name, body := range bad {
+		if code, _ := post(body); code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", name, code)
+		}
+	}
+
+	if _, err := s.ledger.SetSourceKey("ci", ledger.SourceKeyHMAC, "0123456789abcdef"); err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := post(`{"type":"code","source":"ci","payload":{"repo":"app"}}`); code != http.StatusUnauthorized {
+		t.Errorf("unsigned record of a signing source: status %d, want 401", code)
+	}
+}
+
+func TestHandleCreateRecordIdempotencyKey(t *testing.T) {
+	s := setupTestServer(t)
+	post := func(path, key, body string) (int, *httptest.ResponseRecorder) {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, req)
+		return w.Code, w
+	}
+
+	if code, _ := post("/api/v1/records", "evt-1", `{"type":"deploy","payload":"a"}`); code != http.StatusCreated {
+		t.Fatalf("first append: status %d", code)
+	}
+	// The retry, with the key in the header or the body, returns record 1.
+	for _, retry := range []struct{ key, body string }{
+		{"evt-1", `{"type":"deploy","payload":"a"}`},
+		{"", `{"type":"deploy","payload":"a","idempotency_key":"evt-1"}`},
+	} {
+		code, w := post("/api/v1/records", retry.key, retry.body)
+		var resp struct {
+			Data RecordResponse `json:"data"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		if code != http.StatusOK || resp.Data.ID != 1 {
+			t.Fatalf("retry %+v: status %d, record %d", retry, code, resp.Data.ID)
+		}
+	}
+	if code, _ := post("/api/v1/records", "evt-2", `{"type":"deploy","payload":"a","idempotency_key":"evt-3"}`); code != http.StatusBadRequest {
+		t.Fatalf("conflicting keys: status %d, want 400", code)
+	}
+	if code, _ := post("/api/v1/records", "evt-1", `{"type":"deploy","payload":"changed"}`); code != http.StatusUnprocessableEntity {
+		t.Fatalf("key reused for another payload: status %d, want 422", code)
+	}
+
+	batch := `[{"type":"deploy","payload":"a","idempotency_key":"evt-1"},{"type":"deploy","payload":"b","idempotency_key":"evt-2"}]`
+	if code, _ := post("/api/v1/records/batch", "", batch); code != http.StatusCreated {
+		t.Fatalf("batch: status %d", code)
+	}
+	code, w := post("/api/v1/records/batch", "", batch)
+	var resp struct {
+		Data struct {
+			Records []RecordResponse `json:"records"`
+			Created int              `json:"created"`
+		} `json:"data"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if code != http.StatusOK || resp.Data.Created != 0 || len(resp.Data.Records) != 2 || resp.Data.Records[1].ID != 2 {
+		t.Fatalf("batch retry: status %d, %+v", code, resp.Data)
+	}
+	if stats, _ := s.ledger.ChainStats(); stats.Records != 2 {
+		t.Fatalf("ledger holds %d records, want 2", stats.Records)
+	}
+}
+
+func TestHandleCreateRecordBatch(t *testing.T) {
+	s := setupTestServer(t)
+	post := func(body string) int {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/records/batch", strings.NewReader(body)))
+		return w.Code
+	}
+
+	if code := post(`[{"type":"deploy","payload":"a"},{"type":"deploy","payload":"b"}]`); code != http.StatusCreated {
+		t.Fatalf("batch: status %d", code)
+	}
+	first, _ := s.ledger.GetByID(1)
+	second, err := s.ledger.GetByID(2)
+	if err != nil || second.PrevHash != first.Hash {
+		t.Fatalf("batch records not chained: %+v %+v (%v)", first, second, err)
+	}
+	for _, body := range []string{`[]`, `{"type":"deploy"}`, `[{"type":"deploy","payload":"c"},{"type":"deploy"}]`} {
+		if code := post(body); code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", body, code)
+		}
+	}
+	if _, err := s.ledger.GetByID(3); err == nil {
+		t.Error("rejected batch appended a record")
+	}
+
+	// Behind the append SLO, batches are refused with Retry-After.
+	s.ledger.SetAppendSLO(time.Nanosecond)
+	if _, err := s.ledger.Append(ledger.RecordInput{Timestamp: 1, Type: "deploy", Source: "ci", Payload: "c"}); err != nil {
+		t.Fatal(err)
+	}
 	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/records/batch", strings.NewReader(`[{"type":"deploy","payload":"d"}]`)))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("batch behind the SLO: status %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	s.ledger.SetAppendSLO(time.Hour)
+	if code := post(`[{"type":"deploy","payload":"d"}]`); code != http.StatusCreated {
+		t.Fatalf("batch within the SLO: status %d", code)
+	}
+}
 
-	s.router.ServeHTTP(w, req)
+func TestAppendStatus(t *testing.T) {
+	for err, want := range map[error]int{
+		fmt.Errorf("%w: type required", ledger.ErrInvalidRecord):       http.StatusBadRequest,
+		fmt.Errorf("%w: code: missing repo", ledger.ErrInvalidPayload): http.StatusBadRequest,
+		fmt.Errorf("%w %q", ledger.ErrUnknownType, "deploys"):          http.StatusBadRequest,
+		fmt.Errorf("%w: record 7", ledger.ErrIdempotentRecordPruned):   http.StatusConflict,
+		fmt.Errorf("%w: key %q", ledger.ErrIdempotencyConflict, "k"):   http.StatusUnprocessableEntity,
+		errors.New("database is locked"):                               http.StatusInternalServerError,
+	} {
+		if got := appendStatus(err); got != want {
+			t.Errorf("appendStatus(%v) = %d, want %d", err, got, want)
+		}
+	}
 
-	if w.Code != http.StatusNotImplemented {
-		t.Errorf("Expected 501, got %d", w.Code)
+	// An append the ledger fails to store is a server error.
+	s := setupTestServer(t)
+	s.ledger.Close()
+	for path, body := range map[string]string{
+		"/api/v1/records":       `{"type":"deploy","payload":"a"}`,
+		"/api/v1/records/batch": `[{"type":"deploy","payload":"a"}]`,
+	} {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, httptest.NewRequest("POST", path, strings.NewReader(body)))
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("%s on a closed ledger: status %d, want 500", path, w.Code)
+		}
 	}
 }
 
@@ -204,3 +560,1229 @@ func TestSuccessResponse(t *testing.T) {
 		t.Error("Expected data to be set")
 	}
 }
+
+func TestHandleCacheStats(t *testing.T) {
+	s := setupTestServer(t)
+	req := httptest.NewRequest("GET", "/api/v1/cache", nil)
+	w := httptest.NewRecorder()
+
+	s.router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %d", w.Code)
+	}
+
+	var resp Response
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	data := resp.Data.(map[string]interface{})
+	if data["enabled"] != false {
+		t.Errorf("Expected cache disabled by default, got %v", data["enabled"])
+	}
+}
+
+func TestHandleLatestState(t *testing.T) {
+	s := setupTestServer(t)
+	req := httptest.NewRequest("GET", "/api/v1/state/latest", nil)
+	w := httptest.NewRecorder()
+
+	s.router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+
+	var resp Response
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	data := resp.Data.(map[string]interface{})
+	if _, ok := data["mutations"].([]interface{}); !ok {
+		t.Errorf("Expected mutations list, got %v", data["mutations"])
+	}
+}
+
+func TestHandleSnapshotMutationsValidation(t *testing.T) {
+	s := setupTestServer(t)
+
+	for _, target := range []string{
+		"/api/v1/snapshot/mutations?ref_from=abc",
+		"/api/v1/snapshot/mutations?limit=-1",
+		"/api/v1/snapshot/mutations?time=yesterday",
+	} {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", target, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/snapshot/mutations?namespace=kafka:payments&limit=10", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+}
+
+func TestHandleSourcesValidation(t *testing.T) {
+	s := setupTestServer(t)
+
+	for _, target := range []string{
+		"/api/v1/sources?time=yesterday",
+		"/api/v1/sources?new_window=soon",
+	} {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", target, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/sources?new_window=1h", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestHandleMirrorNotConfigured(t *testing.T) {
+	s := setupTestServer(t)
+
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/mirror", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Expected 404 without a mirror, got %d", w.Code)
+	}
+}
+
+func TestHandleLeaderSingleReplica(t *testing.T) {
+	s := setupTestServer(t)
+
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/leader", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+	var resp Response
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := resp.Data.(map[string]interface{})
+	if data["is_leader"] != true || data["ha"] != false {
+		t.Fatalf("a server without an elector should lead: %v", data)
+	}
+}
+
+func TestHandleAgents(t *testing.T) {
+	s := setupTestServer(t)
+
+	for _, body := range []string{
+		`{"hostname":"h","public_key":"not-a-key"}`,
+		`{"public_key":"npYQjaTAl/wUsjEd+kXl2a/MVOQYZAGK+7GfjZLBqvc="}`,
+		`not json`,
+	} {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/agents", bytes.NewBufferString(body)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/agents/agent-unknown/heartbeat", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown agent heartbeat, got %d", w.Code)
+	}
+}
+
+func TestHandleSchemas(t *testing.T) {
+	s := setupTestServer(t)
+
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/schemas/code", bytes.NewBufferString(`{"type":"object"}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for a built-in type, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/schemas/deploy", bytes.NewBufferString(`{"type":"object"}`)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/schemas/deploy?version=0", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for version 0, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/schemas/unknown", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Expected 404 for an unregistered type, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/schemas/code", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 for a built-in type, got %d", w.Code)
+	}
+	var resp struct {
+		Data struct {
+			Schema   ledger.PayloadSchema   `json:"schema"`
+			Versions []ledger.PayloadSchema `json:"versions"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Data.Schema.Builtin || len(resp.Data.Versions) != 1 {
+		t.Fatalf("unexpected built-in schema response: %s", w.Body.String())
+	}
+}
+
+func TestHandlePrometheusMetrics(t *testing.T) {
+	s := setupTestServer(t)
+	if _, err := s.ledger.Append(ledger.RecordInput{Timestamp: 1700000000, Type: "code", Source: "ci", Payload: `{"repo":"app","commit":"abc"}`}); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+	body := w.Body.String()
+	for _, line := range []string{
+		"stateledger_chain_valid 1\n",
+		"stateledger_last_append_timestamp 1700000000\n",
+		"stateledger_records_total 1\n",
+		"# TYPE stateledger_last_verification_timestamp gauge\n",
+		`stateledger_appended_records_by_type_total{type="code"} 1` + "\n",
+		`stateledger_appended_payload_bytes_by_source_total{source="ci"} 29` + "\n",
+	} {
+		if !bytes.Contains([]byte(body), []byte(line)) {
+			t.Errorf("metrics missing %q:\n%s", line, body)
+		}
+	}
+}
+
+func TestHandleStats(t *testing.T) {
+	s := setupTestServer(t)
+	if _, err := s.ledger.AppendBatch([]ledger.RecordInput{
+		{Timestamp: 1, Type: "setting", Source: "svc\"a", Payload: `{"k":1}`},
+		{Timestamp: 2, Type: "setting", Source: "svc\"a", Payload: `{"k":22}`},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/stats", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Data struct {
+			Chain    ledger.ChainStats   `json:"chain"`
+			Ledger   ledger.Stats        `json:"ledger"`
+			Appends  ledger.AppendStats  `json:"appends"`
+			Webhooks ledger.WebhookStats `json:"webhooks"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Data.Chain.Records != 2 {
+		t.Errorf("chain = %+v", resp.Data.Chain)
+	}
+	if got := resp.Data.Ledger; got.ByType["setting"] != 2 || got.BySource["svc\"a"] != 2 || got.FirstTimestamp != 1 || got.ChainLength != 2 {
+		t.Errorf("ledger = %+v", got)
+	}
+	if got := resp.Data.Appends.ByType["setting"]; got.Records != 2 || got.PayloadBytes != 15 {
+		t.Errorf("by_type = %+v", resp.Data.Appends.ByType)
+	}
+	if got := resp.Data.Webhooks; got.InFlight != 0 || got.Queued != 0 || got.Subscriptions == nil {
+		t.Errorf("webhooks = %+v", got)
+	}
+
+	w = httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	for _, line := range []string{
+		`stateledger_appended_records_by_source_total{source="svc\"a"} 2`,
+		"stateledger_webhook_deliveries_queued 0\n",
+		"stateledger_webhook_events_dropped_total 0\n",
+	} {
+		if !strings.Contains(w.Body.String(), line) {
+			t.Errorf("metrics missing %q:\n%s", line, w.Body.String())
+		}
+	}
+}
+
+func TestHandleAuditMode(t *testing.T) {
+	s := setupTestServer(t)
+	if _, err := s.ledger.Append(ledger.RecordInput{Timestamp: 1, Type: "code", Source: "git", Payload: `{"repo":"app","commit":"abc1234"}`}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		mode string
+		want int
+	}{
+		{"", http.StatusOK},
+		{"lenient", http.StatusOK},
+		{"strict", http.StatusUnprocessableEntity},
+		{"paranoid", http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/audit?mode="+tc.mode, nil))
+		if w.Code != tc.want {
+			t.Errorf("mode %q: expected %d, got %d: %s", tc.mode, tc.want, w.Code, w.Body.String())
+		}
+		if tc.mode == "strict" && !strings.Contains(w.Body.String(), "no config snapshot") {
+			t.Errorf("strict refusal does not name the gap: %s", w.Body.String())
+		}
+	}
+}
+
+func TestHandleListTypes(t *testing.T) {
+	s := setupTestServer(t)
+	if err := s.ledger.RegisterType(ledger.TypeSpec{Name: "deploy"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ledger.Append(ledger.RecordInput{Timestamp: 1, Type: "confg", Source: "app", Payload: "x"}); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/types", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Data ledger.TypeRegistry `json:"data"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	found := map[string]ledger.TypeInfo{}
+	for _, ti := range resp.Data.Types {
+		found[ti.Name] = ti
+	}
+	if !found["deploy"].Registered || !found["code"].Builtin || found["confg"].Suggestion != "config" {
+		t.Errorf("types = %+v", resp.Data.Types)
+	}
+}
+
+func TestHandleReplayProgress(t *testing.T) {
+	s := setupTestServer(t)
+	if _, err := s.ledger.Append(ledger.RecordInput{Timestamp: 1, Type: "mutation", Source: "kafka",
+		Payload: `{"type":"payment","id":"p1","source":"payments","hash":"h","external_ref":"kafka:payments:1"}`}); err != nil {
+		t.Fatal(err)
+	}
+	exec := &ledger.ReplayExecutor{Ledger: s.ledger, Name: "staging", Apply: func(context.Context, ledger.MutationRecord, ledger.Record) error { return nil }}
+	if _, err := exec.Run(context.Background(), 10); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/replay/progress?name=staging", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Data []ledger.ReplayProgress `json:"data"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Data) != 1 || resp.Data[0].LastExternalRef != "kafka:payments:1" || resp.Data[0].Token == "" {
+		t.Errorf("progress = %+v", resp.Data)
+	}
+}
+
+func TestHandleChainGraph(t *testing.T) {
+	s := setupTestServer(t)
+	for i := 0; i < 3; i++ {
+		if _, err := s.ledger.Append(ledger.RecordInput{Timestamp: int64(i + 1), Type: "event", Source: "svc", Payload: `{"trace_id":"t1"}`}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/chain/graph?from=2&to=3", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Data ledger.ChainGraph `json:"data"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Data.Nodes) != 2 || len(resp.Data.Edges) != 2 || resp.Data.Nodes[0].ID != "record:2" {
+		t.Errorf("graph = %+v", resp.Data)
+	}
+
+	w = httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/chain/graph?from=x", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid from, got %d", w.Code)
+	}
+}
+
+func TestWebUI(t *testing.T) {
+	s := setupTestServer(t)
+	s.SetPolicy(Policy{APIKeys: []string{"k1"}})
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	if w := get("/ui"); w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/ui/" {
+		t.Errorf("/ui: status %d, location %q", w.Code, w.Header().Get("Location"))
+	}
+	w := get("/ui/")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "<title>StateLedger</title>") {
+		t.Errorf("/ui/: status %d, body %.80q", w.Code, w.Body.String())
+	}
+	for _, asset := range []string{"/ui/app.js", "/ui/style.css"} {
+		if w := get(asset); w.Code != http.StatusOK || w.Body.Len() == 0 {
+			t.Errorf("%s: status %d", asset, w.Code)
+		}
+	}
+	if w := get("/api/v1/records"); w.Code != http.StatusUnauthorized {
+		t.Errorf("API without key: status %d, want 401", w.Code)
+	}
+}
+
+func TestHandleConfigDiff(t *testing.T) {
+	s := setupTestServer(t)
+	for i, snapshot := range []string{"db:\n  password: old\n  pool: 5\n", "db:\n  password: new\n  pool: 10\n"} {
+		payload, _ := json.Marshal(map[string]string{"source": "app.yaml", "version": "1", "hash": "h", "snapshot": snapshot})
+		if _, err := s.ledger.Append(ledger.RecordInput{Timestamp: int64(i + 1), Type: "config", Source: "app", Payload: string(payload)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, path := range []string{"/api/v1/config/diff?source=app.yaml", "/api/v1/config/diff?from=1&to=2"} {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d: %s", path, w.Code, w.Body.String())
+		}
+		var resp struct {
+			Data ledger.ConfigDiff `json:"data"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if !resp.Data.Masked || len(resp.Data.Keys) != 2 || strings.Contains(resp.Data.Diff, "new") {
+			t.Errorf("%s: diff = %+v", path, resp.Data)
+		}
+	}
+
+	for path, code := range map[string]int{
+		"/api/v1/config/diff?from=1":         http.StatusBadRequest,
+		"/api/v1/config/diff?source=other":   http.StatusNotFound,
+		"/api/v1/config/diff?time=yesterday": http.StatusBadRequest,
+	} {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != code {
+			t.Errorf("%s: status %d, want %d", path, w.Code, code)
+		}
+	}
+}
+
+func TestMetricsPercentiles(t *testing.T) {
+	m := NewMetrics()
+	for i := 1; i <= 1000; i++ {
+		m.RecordRequest("list", time.Duration(i)*time.Millisecond, nil)
+	}
+	for i := 0; i < 20; i++ {
+		m.RecordRequest("health", 200*time.Microsecond, nil)
+	}
+	m.RecordRequest("health", time.Second, errors.New("boom"))
+
+	stats := m.GetStats()
+	if stats.TotalRequests != 1021 || stats.FailedRequests != 1 {
+		t.Fatalf("counts = %d/%d", stats.TotalRequests, stats.FailedRequests)
+	}
+	list := stats.Endpoints["list"]
+	within := func(name string, got, want time.Duration) {
+		t.Helper()
+		if diff := math.Abs(float64(got-want)) / float64(want); diff > 0.06 {
+			t.Errorf("%s = %v, want about %v", name, got, want)
+		}
+	}
+	within("list p50", list.P50, 500*time.Millisecond)
+	within("list p95", list.P95, 950*time.Millisecond)
+	within("list p99", list.P99, 990*time.Millisecond)
+	if list.Min != time.Millisecond || list.Max != time.Second || list.Requests != 1000 {
+		t.Errorf("list stats = %+v", list)
+	}
+	health := stats.Endpoints["health"]
+	within("health p50", health.P50, 200*time.Microsecond)
+	within("health p99", health.P99, time.Second)
+	if health.Failed != 1 {
+		t.Errorf("health stats = %+v", health)
+	}
+
+	out := m.PrometheusMetrics()
+	for _, line := range []string{
+		"stateledger_requests_total 1021\n",
+		"stateledger_requests_failed 1\n",
+		"# TYPE stateledger_request_duration_seconds summary\n",
+		`stateledger_request_duration_seconds_count{endpoint="list"} 1000` + "\n",
+		`stateledger_request_duration_seconds{endpoint="health",quantile="0.5"} 0.0002` + "\n",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("prometheus output missing %q:\n%s", line, out)
+		}
+	}
+}
+
+func TestRateLimiterReportsExceededOnce(t *testing.T) {
+	limiter := NewRateLimiter(1, 1)
+	var exceeded []string
+	limiter.OnExceeded(func(key string) { exceeded = append(exceeded, key) })
+
+	for i := 0; i < 3; i++ {
+		limiter.Allow("client")
+	}
+	if len(exceeded) != 1 || exceeded[0] != "client" {
+		t.Fatalf("expected one exceeded report, got %v", exceeded)
+	}
+}
+
+func TestSetPolicy(t *testing.T) {
+	s := setupTestServer(t)
+	get := func(path, key string) int {
+		req := httptest.NewRequest("GET", path, nil)
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := get("/api/v1/stats", ""); code != http.StatusOK {
+		t.Fatalf("default policy: status %d", code)
+	}
+
+	var log bytes.Buffer
+	s.SetPolicy(Policy{APIKeys: []string{"k1"}, LogRequests: true, LogFormat: "json", LogOutput: &log})
+	if code := get("/api/v1/stats", ""); code != http.StatusUnauthorized {
+		t.Errorf("without key: status %d, want 401", code)
+	}
+	if code := get("/api/v1/stats", "k1"); code != http.StatusOK {
+		t.Errorf("with key: status %d, want 200", code)
+	}
+	if code := get("/api/v1/health", ""); code != http.StatusOK {
+		t.Errorf("health check: status %d, want 200", code)
+	}
+	var entry struct {
+		Path   string `json:"path"`
+		Status int    `json:"status"`
+	}
+	first, _, _ := strings.Cut(log.String(), "\n")
+	if err := json.Unmarshal([]byte(first), &entry); err != nil || entry.Path != "/api/v1/stats" || entry.Status != http.StatusUnauthorized {
+		t.Errorf("request log = %q", log.String())
+	}
+
+	// Replacing the policy swaps keys and keeps rate limit state while the
+	// limits are unchanged.
+	s.SetPolicy(Policy{APIKeys: []string{"k2"}, RateLimit: 1, RateBurst: 2})
+	if code := get("/api/v1/stats", "k1"); code != http.StatusUnauthorized {
+		t.Errorf("old key after reload: status %d, want 401", code)
+	}
+	get("/api/v1/stats", "k2")
+	s.SetPolicy(Policy{APIKeys: []string{"k2"}, RateLimit: 1, RateBurst: 2})
+	get("/api/v1/stats", "k2")
+	if code := get("/api/v1/stats", "k2"); code != http.StatusTooManyRequests {
+		t.Errorf("third request in burst of 2: status %d, want 429", code)
+	}
+
+	s.SetPolicy(Policy{})
+	if code := get("/api/v1/stats", ""); code != http.StatusOK {
+		t.Errorf("cleared policy: status %d", code)
+	}
+}
+
+func TestPolicyWithoutKeysServesReadsOnly(t *testing.T) {
+	s := setupTestServer(t)
+	do := func(method, path, body string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, req)
+		return w.Code
+	}
+	writes := []struct{ method, path, body string }{
+		{"POST", "/api/v1/records", `{"type":"deploy","payload":"a"}`},
+		{"POST", "/api/v1/records/batch", `[{"type":"deploy","payload":"a"}]`},
+		{"POST", "/api/v1/webhooks", `{"id":"w","url":"https://hooks.example.com/"}`},
+		{"POST", "/api/v1/schemas/deploy", `{"type":"object"}`},
+		{"POST", "/api/v1/admin/api-keys/abc/revoke", ``},
+		{"DELETE", "/api/v1/webhooks/w", ``},
+	}
+
+	// The default policy has no keys, so every write is refused.
+	for _, w := range writes {
+		if code := do(w.method, w.path, w.body); code != http.StatusUnauthorized {
+			t.Errorf("%s %s without keys: status %d, want 401", w.method, w.path, code)
+		}
+	}
+	if code := do("GET", "/api/v1/stats", ""); code != http.StatusOK {
+		t.Errorf("read without keys: status %d", code)
+	}
+	if code := do("POST", "/api/v1/snapshot", `{}`); code == http.StatusUnauthorized {
+		t.Errorf("snapshot without keys: status %d", code)
+	}
+	if stats, _ := s.ledger.ChainStats(); stats.Records != 0 {
+		t.Fatalf("ledger holds %d records, want 0", stats.Records)
+	}
+
+	s.SetPolicy(Policy{InsecureNoAuth: true})
+	if code := do("POST", "/api/v1/records", `{"type":"deploy","payload":"a"}`); code != http.StatusCreated {
+		t.Errorf("append with InsecureNoAuth: status %d, want 201", code)
+	}
+}
+
+func TestClientCertificateAuthenticatesIngestOnly(t *testing.T) {
+	s := setupTestServer(t)
+	s.SetPolicy(Policy{APIKeys: []string{"reader"}, AdminKeys: []string{"alice"}})
+	do := func(method, path, agent string) int {
+		req := httptest.NewRequest(method, path, nil)
+		req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: agent}}}}}
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, req)
+		return w.Code
+	}
+
+	for _, route := range []struct{ method, path string }{
+		{"GET", "/api/v1/admin/requests"},
+		{"GET", "/api/v1/admin/api-keys"},
+		{"POST", "/api/v1/records"},
+		{"GET", "/api/v1/webhooks"},
+		{"POST", "/api/v1/agents/agent-1/heartbeat"},
+	} {
+		if code := do(route.method, route.path, "agent-1"); code != http.StatusUnauthorized {
+			t.Errorf("%s %s with a client certificate and no key: status %d, want 401", route.method, route.path, code)
+		}
+	}
+	if code := do("GET", "/api/v1/agents/agent-2/ingest", "agent-1"); code != http.StatusUnauthorized {
+		t.Errorf("ingest with another agent's certificate: status %d, want 401", code)
+	}
+	// The certificate gets past the key check; the ingest handler then
+	// looks the agent up.
+	if code := do("GET", "/api/v1/agents/agent-1/ingest", "agent-1"); code != http.StatusNotFound {
+		t.Errorf("ingest with the agent's certificate: status %d, want 404 for an unregistered agent", code)
+	}
+}
+
+func TestAdminApproval(t *testing.T) {
+	s := setupTestServer(t)
+	s.SetPolicy(Policy{APIKeys: []string{"reader"}, AdminKeys: []string{"alice", "bob"}})
+	do := func(method, path, key, body string) (int, ledger.AdminRequest) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, req)
+		var resp struct {
+			Data ledger.AdminRequest `json:"data"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp.Data
+	}
+
+	body := `{"action":"archive","params":{"before":1000,"to":"/tmp/archive"}}`
+	if code, _ := do("POST", "/api/v1/admin/requests", "reader", body); code != http.StatusForbidden {
+		t.Fatalf("request with a non-admin key: status %d, want 403", code)
+	}
+	code, req := do("POST", "/api/v1/admin/requests", "alice", body)
+	if code != http.StatusCreated || req.Status != "pending" {
+		t.Fatalf("request: status %d, %+v", code, req)
+	}
+	approve := "/api/v1/admin/requests/" + strconv.FormatInt(req.ID, 10) + "/approve"
+	if code, _ := do("POST", approve, "alice", ""); code != http.StatusForbidden {
+		t.Errorf("self approval: status %d, want 403", code)
+	}
+	code, approved := do("POST", approve, "bob", "")
+	if code != http.StatusOK || approved.Status != "approved" || approved.ApprovedBy == approved.RequestedBy {
+		t.Fatalf("approval: status %d, %+v", code, approved)
+	}
+	if code, _ := do("POST", approve, "bob", ""); code != http.StatusConflict {
+		t.Errorf("second approval: status %d, want 409", code)
+	}
+	if code, _ := do("POST", "/api/v1/admin/requests/999/approve", "bob", ""); code != http.StatusNotFound {
+		t.Errorf("unknown request: status %d, want 404", code)
+	}
+
+	rec, err := s.ledger.GetByID(approved.ApprovalID)
+	if err != nil || rec.Type != ledger.AdminApprovalRecordType || !strings.Contains(rec.Payload, `"request_id":1`) {
+		t.Errorf("approval record = %+v (%v)", rec, err)
+	}
+}
+
+func TestRevokeAPIKey(t *testing.T) {
+	s := setupTestServer(t)
+	s.SetPolicy(Policy{APIKeys: []string{"reader"}, AdminKeys: []string{"alice"}})
+	do := func(method, path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, req)
+		return w
+	}
+	reader := APIKeyFingerprint("reader")
+
+	if w := do("POST", "/api/v1/admin/api-keys/"+APIKeyFingerprint("alice")+"/revoke", "alice", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("self revocation: status %d, want 400", w.Code)
+	}
+	if w := do("POST", "/api/v1/admin/api-keys/"+reader+"/revoke", "alice", `{"reason":"leaked"}`); w.Code != http.StatusOK {
+		t.Fatalf("revoke: status %d: %s", w.Code, w.Body)
+	}
+	if w := do("GET", "/api/v1/records", "reader", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("revoked key: status %d, want 401", w.Code)
+	}
+	if w := do("POST", "/api/v1/admin/api-keys/"+reader+"/revoke", "alice", ""); w.Code != http.StatusConflict {
+		t.Errorf("second revocation: status %d, want 409", w.Code)
+	}
+
+	// The revocation outlives a policy reload.
+	s.SetPolicy(Policy{APIKeys: []string{"reader"}, AdminKeys: []string{"alice"}})
+	if w := do("GET", "/api/v1/records", "reader", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("revoked key after reload: status %d, want 401", w.Code)
+	}
+	list := func(query string) []APIKeyResponse {
+		var resp struct {
+			Data struct {
+				Keys []APIKeyResponse `json:"api_keys"`
+			} `json:"data"`
+		}
+		json.NewDecoder(do("GET", "/api/v1/admin/api-keys"+query, "alice", "").Body).Decode(&resp)
+		return resp.Data.Keys
+	}
+	if keys := list(""); len(keys) != 1 || !keys[0].Admin {
+		t.Errorf("active keys = %+v", keys)
+	}
+	keys := list("?include_revoked=true")
+	if len(keys) != 2 {
+		t.Fatalf("all keys = %+v", keys)
+	}
+	for _, k := range keys {
+		if k.ID == reader && (k.RevokedBy != APIKeyFingerprint("alice") || k.RevokeReason != "leaked" || !k.Configured) {
+			t.Errorf("revoked key = %+v", k)
+		}
+	}
+
+	if w := do("POST", "/api/v1/admin/api-keys/"+reader+"/restore", "alice", ""); w.Code != http.StatusOK {
+		t.Fatalf("restore: status %d: %s", w.Code, w.Body)
+	}
+	if w := do("GET", "/api/v1/records", "reader", ""); w.Code != http.StatusOK {
+		t.Errorf("restored key: status %d, want 200", w.Code)
+	}
+}
+
+func TestRevokeWebhook(t *testing.T) {
+	s := setupTestServer(t)
+	do := func(method, path, body string) (int, WebhookResponse) {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		var resp struct {
+			Data WebhookResponse `json:"data"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp.Data
+	}
+	listed := func(query string) int {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/webhooks"+query, nil))
+		var resp struct {
+			Data struct {
+				Total int `json:"total"`
+			} `json:"data"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp.Data.Total
+	}
+
+	if code, _ := do("POST", "/api/v1/webhooks", `{"id":"ops","url":"http://203.0.113.10/hook"}`); code != http.StatusCreated {
+		t.Fatalf("create: status %d", code)
+	}
+	code, hook := do("DELETE", "/api/v1/webhooks/ops?reason=decommissioned", "")
+	if code != http.StatusOK || hook.Active || hook.RevokedAt == "" || hook.RevokeReason != "decommissioned" {
+		t.Fatalf("revoke: status %d, %+v", code, hook)
+	}
+	if listed("") != 0 || listed("?include_revoked=true") != 1 {
+		t.Errorf("listed %d, with revoked %d", listed(""), listed("?include_revoked=true"))
+	}
+	if code, _ := do("POST", "/api/v1/webhooks", `{"id":"ops","url":"http://203.0.113.10/other"}`); code != http.StatusConflict {
+		t.Errorf("reusing a revoked id: status %d, want 409", code)
+	}
+	if code, _ := do("DELETE", "/api/v1/webhooks/ops", ""); code != http.StatusConflict {
+		t.Errorf("second revocation: status %d, want 409", code)
+	}
+	if code, hook := do("POST", "/api/v1/webhooks/ops/restore", ""); code != http.StatusOK || !hook.Active || hook.RevokedAt != "" {
+		t.Fatalf("restore: status %d, %+v", code, hook)
+	}
+	if code, _ := do("POST", "/api/v1/webhooks/missing/restore", ""); code != http.StatusNotFound {
+		t.Errorf("unknown subscription: status %d, want 404", code)
+	}
+
+	recs, err := s.ledger.List(ledger.ListQuery{Sources: []string{ledger.CredentialWebhook}, Limit: 10})
+	if err != nil || len(recs) != 3 || recs[0].Type != ledger.CredentialCreateRecordType || recs[1].Type != ledger.CredentialRevokeRecordType || recs[2].Type != ledger.CredentialRestoreRecordType {
+		t.Fatalf("lifecycle records = %+v (%v)", recs, err)
+	}
+}
+
+func TestCreateWebhookChecksURL(t *testing.T) {
+	s := setupTestServer(t)
+	create := func(id, url string) int {
+		w := httptest.NewRecorder()
+		body := fmt.Sprintf(`{"id":%q,"url":%q}`, id, url)
+		s.router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/webhooks", strings.NewReader(body)))
+		return w.Code
+	}
+	for _, url := range []string{
+		"ftp://203.0.113.10/hook",
+		"file:///etc/passwd",
+		"http://127.0.0.1:8080/api/v1/admin",
+		"http://localhost/hook",
+		"http://[::1]/hook",
+		"http://[::ffff:127.0.0.1]/hook",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://[fe80::1]/hook",
+		"http://100.100.100.200/latest/meta-data/",
+		"http://0.0.0.0/hook",
+		"http:///hook",
+	} {
+		if code := create("probe", url); code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", url, code)
+		}
+	}
+
+	// An ID already subscribed, here from the config file, is refused
+	// before anything is recorded.
+	if err := s.webhooks.Subscribe("cfg", "https://203.0.113.10/cfg", nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	if code := create("cfg", "https://203.0.113.10/other"); code != http.StatusConflict {
+		t.Fatalf("subscribed id: status %d, want 409", code)
+	}
+	if creds, err := s.ledger.Credentials(ledger.CredentialWebhook, true); err != nil || len(creds) != 0 {
+		t.Fatalf("credentials = %+v (%v)", creds, err)
+	}
+	if hooks, err := s.ledger.Webhooks(); err != nil || len(hooks) != 0 {
+		t.Fatalf("stored webhooks = %+v (%v)", hooks, err)
+	}
+}
+
+func TestWebhooksSurviveRestart(t *testing.T) {
+	s := setupTestServer(t)
+	do := func(s *Server, method, path, body string) int {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w.Code
+	}
+	for _, body := range []string{
+		`{"id":"ops","url":"http://203.0.113.10/ops","events":["drift.detected"],"secret":"s1"}`,
+		`{"id":"ci","url":"http://203.0.113.10/ci"}`,
+	} {
+		if code := do(s, "POST", "/api/v1/webhooks", body); code != http.StatusCreated {
+			t.Fatalf("create %s: status %d", body, code)
+		}
+	}
+	if code := do(s, "DELETE", "/api/v1/webhooks/ops?reason=leaked", ""); code != http.StatusOK {
+		t.Fatalf("revoke: status %d", code)
+	}
+
+	// A server restarted on the same ledger subscribes both again, with
+	// the revocation in force.
+	restarted := NewServer(s.ledger, "localhost:8080")
+	if err := restarted.SyncWebhooks(); err != nil {
+		t.Fatal(err)
+	}
+	ops, ci := restarted.subscription("ops"), restarted.subscription("ci")
+	if ops == nil || ops.Active || ops.RevokeReason != "leaked" || ops.URL != "http://203.0.113.10/ops" || ops.Secret != "s1" || len(ops.Events) != 1 {
+		t.Fatalf("ops after restart = %+v", ops)
+	}
+	if ci == nil || !ci.Active || !ci.CreatedAt.Equal(s.subscription("ci").CreatedAt.Truncate(time.Second)) {
+		t.Fatalf("ci after restart = %+v", ci)
+	}
+	for _, id := range []string{"ops", "ci"} {
+		if code := do(restarted, "POST", "/api/v1/webhooks", `{"id":"`+id+`","url":"http://203.0.113.10/other"}`); code != http.StatusConflict {
+			t.Errorf("subscribing %s again after restart: status %d, want 409", id, code)
+		}
+	}
+	if code := do(restarted, "POST", "/api/v1/webhooks/ops/restore", ""); code != http.StatusOK {
+		t.Errorf("restore after restart: status %d", code)
+	}
+}
+
+func TestSharedRateLimit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.db")
+	replica := func() *Server {
+		l, err := ledger.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { l.Close() })
+		if err := l.InitSchema(); err != nil {
+			t.Fatal(err)
+		}
+		s := NewServer(l, "localhost:8080")
+		s.SetPolicy(Policy{RateLimit: 1, RateBurst: 2, SharedRateLimit: true})
+		return s
+	}
+	get := func(s *Server) int {
+		req := httptest.NewRequest("GET", "/api/v1/stats", nil)
+		req.Header.Set("X-API-Key", "k")
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, req)
+		return w.Code
+	}
+
+	a, b := replica(), replica()
+	if get(a) != http.StatusOK || get(b) != http.StatusOK {
+		t.Fatal("requests within the burst were rejected")
+	}
+	if code := get(a); code != http.StatusTooManyRequests {
+		t.Errorf("third request across replicas: status %d, want 429", code)
+	}
+	// A restarted replica keeps the limit.
+	if code := get(replica()); code != http.StatusTooManyRequests {
+		t.Errorf("after restart: status %d, want 429", code)
+	}
+}
+
+func TestHandleRecordsTimeZone(t *testing.T) {
+	s := setupTestServer(t)
+	if _, err := s.ledger.Append(ledger.RecordInput{Timestamp: 1700000000, Type: "event", Source: "app", Payload: "e"}); err != nil {
+		t.Fatal(err)
+	}
+	timestamp := func(path string) string {
+		t.Helper()
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d", path, w.Code)
+		}
+		var resp struct {
+			Data json.RawMessage `json:"data"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		var page struct {
+			Records []RecordResponse `json:"records"`
+		}
+		var rec RecordResponse
+		if json.Unmarshal(resp.Data, &page) == nil && len(page.Records) > 0 {
+			return page.Records[0].Timestamp
+		}
+		json.Unmarshal(resp.Data, &rec)
+		return rec.Timestamp
+	}
+
+	if got := timestamp("/api/v1/records/1"); got != "2023-11-14T22:13:20Z" {
+		t.Errorf("default timestamp = %s, want UTC", got)
+	}
+	if got := timestamp("/api/v1/records/1?tz=Asia/Kolkata"); got != "2023-11-15T03:43:20+05:30" {
+		t.Errorf("Asia/Kolkata timestamp = %s", got)
+	}
+	if got := timestamp("/api/v1/records?tz=-08:00"); got != "2023-11-14T14:13:20-08:00" {
+		t.Errorf("listed timestamp = %s", got)
+	}
+
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/records?tz=Nowhere/City", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("unknown tz: status %d, want 400", w.Code)
+	}
+}
+
+func TestHandleRecordsLabels(t *testing.T) {
+	s := setupTestServer(t)
+	for _, body := range []string{
+		`{"type":"deploy","payload":"a","labels":{"env":"prod","team":"payments"}}`,
+		`{"type":"deploy","payload":"b","labels":{"env":"staging"}}`,
+		`{"type":"deploy","payload":"c"}`,
+	} {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/records", strings.NewReader(body)))
+		if w.Code != http.StatusCreated {
+			t.Fatalf("create %s: status %d: %s", body, w.Code, w.Body)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/records?label=env=prod&label=team=payments", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	var resp struct {
+		Data struct {
+			Records []RecordResponse `json:"records"`
+		} `json:"data"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if recs := resp.Data.Records; len(recs) != 1 || recs[0].ID != 1 || recs[0].Labels["team"] != "payments" {
+		t.Fatalf("records = %+v", recs)
+	}
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest("GET", "/api/v1/records?label=env", nil),
+		httptest.NewRequest("POST", "/api/v1/records", strings.NewReader(`{"type":"deploy","payload":"d","labels":{"-bad":"x"}}`)),
+	} {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s %s: status %d, want 400", req.Method, req.URL, w.Code)
+		}
+	}
+}
+
+func TestHandleCoverageAnalytics(t *testing.T) {
+	s := setupTestServer(t)
+	day := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC).Unix()
+	if _, err := s.ledger.AppendBatch([]ledger.RecordInput{
+		{Timestamp: day, Type: "code", Source: "billing", Payload: `{"repo":"billing","commit":"abc1234"}`},
+		{Timestamp: day, Type: "config", Source: "billing", Payload: `{"source":"app.yaml","hash":"h","snapshot":"a: 1"}`},
+		{Timestamp: day, Type: "code", Source: "search", Payload: `{"repo":"search","commit":"def5678"}`},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/analytics/coverage?from=2025-03-01&to=2025-03-02&limit=1", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	var resp struct {
+		Data struct {
+			Overall ledger.SourceCoverage   `json:"overall"`
+			Sources []ledger.SourceCoverage `json:"sources"`
+			Total   int                     `json:"total"`
+		} `json:"data"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.Data.Total != 2 || len(resp.Data.Sources) != 1 || resp.Data.Sources[0].Source != "search" || resp.Data.Overall.Days != 2 {
+		t.Fatalf("coverage = %+v", resp.Data)
+	}
+
+	for _, query := range []string{"from=2025-03-02&to=2025-03-01", "from=2024-01-01&to=2025-03-01", "to=march", "limit=-1"} {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/analytics/coverage?"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", query, w.Code)
+		}
+	}
+}
+
+func TestHandleRecordsSinceSeq(t *testing.T) {
+	s := setupTestServer(t)
+	if _, err := s.ledger.AppendBatch([]ledger.RecordInput{
+		{Timestamp: 1000, Type: "event", Source: "app", Payload: "a"},
+		{Timestamp: 1001, Type: "event", Source: "worker", Payload: "b"},
+		{Timestamp: 1002, Type: "event", Source: "app", Payload: "c"},
+		{Timestamp: 1003, Type: "event", Source: "app", Payload: "d"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/records?source=app&since_seq=1", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	var resp struct {
+		Data struct {
+			Records []RecordResponse `json:"records"`
+		} `json:"data"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if recs := resp.Data.Records; len(recs) != 2 || recs[0].Seq != 2 || recs[1].Seq != 3 || recs[1].ID != 4 {
+		t.Fatalf("records = %+v", recs)
+	}
+
+	for _, path := range []string{"/api/v1/records?since_seq=1", "/api/v1/records?source=app,worker&since_seq=1", "/api/v1/records?source=app&since_seq=x"} {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", path, w.Code)
+		}
+	}
+}
+
+func TestHandleRecordsParsePayload(t *testing.T) {
+	s := setupTestServer(t)
+	if _, err := s.ledger.AppendBatch([]ledger.RecordInput{
+		{Timestamp: 1000, Type: "code", Source: "ci", Payload: `{"repo":"app","commit":"abc"}`},
+		{Timestamp: 1001, Type: "event", Source: "app", Payload: `{"user":"u1"}`},
+		{Timestamp: 1002, Type: "event", Source: "app", Payload: `login:u1`},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var resp struct {
+		Data struct {
+			Records []struct {
+				Payload json.RawMessage `json:"payload"`
+			} `json:"records"`
+		} `json:"data"`
+	}
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/records?parse=true", nil))
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Data.Records) != 3 {
+		t.Fatalf("Expected 3 records, got %s", w.Body.String())
+	}
+	var code map[string]interface{}
+	if err := json.Unmarshal(resp.Data.Records[0].Payload, &code); err != nil || code["repo"] != "app" {
+		t.Fatalf("Expected parsed code payload, got %s", resp.Data.Records[0].Payload)
+	}
+	if string(resp.Data.Records[1].Payload) != `{"user":"u1"}` {
+		t.Fatalf("Expected generic JSON payload, got %s", resp.Data.Records[1].Payload)
+	}
+	if string(resp.Data.Records[2].Payload) != `"login:u1"` {
+		t.Fatalf("Expected non-JSON payload as a string, got %s", resp.Data.Records[2].Payload)
+	}
+
+	w = httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/records/1", nil))
+	if !bytes.Contains(w.Body.Bytes(), []byte(`"payload":"{\"repo\":\"app\",\"commit\":\"abc\"}"`)) {
+		t.Fatalf("Expected string payload without parse, got %s", w.Body.String())
+	}
+}
+
+func TestFlightGroupCoalesces(t *testing.T) {
+	var g flightGroup
+	release := make(chan struct{})
+	var runs atomic.Int64
+	var wg sync.WaitGroup
+	results := make([]any, 5)
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], _ = g.do("verify", func() (any, error) {
+				runs.Add(1)
+				<-release
+				return "done", nil
+			})
+		}()
+	}
+	for g.coalesced.Load() < 4 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	if runs.Load() != 1 {
+		t.Fatalf("computation ran %d times", runs.Load())
+	}
+	for _, r := range results {
+		if r != "done" {
+			t.Fatalf("results %v", results)
+		}
+	}
+
+	// A later request computes afresh.
+	if v, err := g.do("verify", func() (any, error) { return "again", nil }); err != nil || v != "again" {
+		t.Fatalf("after the flight: %v %v", v, err)
+	}
+}
+
+func TestHandleListRecordsPayloadFilter(t *testing.T) {
+	s := setupTestServer(t)
+	for _, payload := range []string{`{"user_id":"u1"}`, `{"user_id":"u2"}`, `{"user_id":"u1"}`} {
+		if _, err := s.ledger.Append(ledger.RecordInput{Timestamp: 1, Type: "event", Source: "app", Payload: payload}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/records?payload.user_id=u1", nil))
+	var resp struct {
+		Data struct {
+			Records []RecordResponse `json:"records"`
+		} `json:"data"`
+	}
+	if w.Code != http.StatusOK || json.NewDecoder(w.Body).Decode(&resp) != nil || len(resp.Data.Records) != 2 || resp.Data.Records[1].ID != 3 {
+		t.Fatalf("payload filter: %d %+v", w.Code, resp.Data.Records)
+	}
+
+	w = httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/records?payload.user-id=u1", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid path: %d", w.Code)
+	}
+}
+
+func TestHandleGetRecordByHash(t *testing.T) {
+	s := setupTestServer(t)
+	rec, err := s.ledger.Append(ledger.RecordInput{Timestamp: 1, Type: "deploy", Source: "ci", Payload: "v"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		hash string
+		code int
+	}{
+		{rec.Hash, http.StatusOK},
+		{strings.ToUpper(rec.Hash), http.StatusOK},
+		{strings.Repeat("0", 64), http.StatusNotFound},
+		{"abc", http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/records/hash/"+tc.hash, nil))
+		if w.Code != tc.code {
+			t.Fatalf("%s: got %d, want %d", tc.hash, w.Code, tc.code)
+		}
+		if tc.code != http.StatusOK {
+			continue
+		}
+		var resp struct {
+			Data RecordResponse `json:"data"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || resp.Data.ID != rec.ID {
+			t.Fatalf("%s: %+v %v", tc.hash, resp.Data, err)
+		}
+	}
+}
+
+func TestHandlePolicies(t *testing.T) {
+	s := setupTestServer(t)
+	set, err := s.ledger.SetPolicies(ledger.PolicySet{Determinism: &ledger.DeterminismPolicy{MinScore: 80}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(path string) (int, string) {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w.Code, w.Body.String()
+	}
+
+	if code, body := get("/api/v1/policies"); code != http.StatusOK || !strings.Contains(body, `"min_score":80`) || !strings.Contains(body, `"total":1`) {
+		t.Fatalf("list: status %d, %s", code, body)
+	}
+	before := time.Unix(set[0].SetAt-1, 0).UTC().Format(time.RFC3339)
+	if code, body := get("/api/v1/policies?time=" + before); code != http.StatusOK || !strings.Contains(body, `"total":0`) {
+		t.Fatalf("list before: status %d, %s", code, body)
+	}
+	if code, _ := get("/api/v1/policies?time=yesterday"); code != http.StatusBadRequest {
+		t.Fatalf("invalid time: status %d", code)
+	}
+	if code, body := get("/api/v1/policies/determinism"); code != http.StatusOK || !strings.Contains(body, `"version":1`) {
+		t.Fatalf("get: status %d, %s", code, body)
+	}
+	if code, _ := get("/api/v1/policies/coverage"); code != http.StatusNotFound {
+		t.Fatalf("unset kind: status %d", code)
+	}
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Retr0-XD/StateLedger/internal/ledger"
+)
+
+// defaultQueryBudget is the time a list or snapshot request may spend
+// reading records unless SetQueryBudget changes it
+const defaultQueryBudget = 10 * time.Second
+
+// errPageDone stops a record iteration once a page is full or the query
+// budget is spent
+var errPageDone = errors.New("page done")
+
+// SetQueryBudget sets how long a list or snapshot request may spend
+// reading records. A request that runs out returns the records read so far
+// marked truncated, with a cursor to continue from. Zero disables the
+// budget.
+func (s *Server) SetQueryBudget(d time.Duration) {
+	s.queryBudget.Store(int64(d))
+}
+
+// queryDeadline is the time by which a request starting now must stop
+// reading, or the zero time without a budget
+func (s *Server) queryDeadline() time.Time {
+	d := time.Duration(s.queryBudget.Load())
+	if d <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(d)
+}
+
+// pastDeadline reports whether a request with deadline has run out of time
+func pastDeadline(deadline time.Time) bool {
+	return !deadline.IsZero() && time.Now().After(deadline)
+}
+
+// recordStream writes a record listing as the records are read rather than
+// collecting the page first. The response is written lazily, so an error
+// before the first record still gets its own status code.
+type recordStream struct {
+	w        http.ResponseWriter
+	enc      *json.Encoder
+	limit    int
+	format   recordFormat
+	deadline time.Time
+
+	started   bool
+	count     int
+	lastID    int64
+	more      bool
+	truncated bool
+}
+
+func newRecordStream(w http.ResponseWriter, limit int, format recordFormat, deadline time.Time) *recordStream {
+	return &recordStream{w: w, enc: json.NewEncoder(w), limit: limit, format: format, deadline: deadline}
+}
+
+// add writes rec, or stops the iteration with errPageDone once the page is
+// full or, after at least one record, the budget is spent
+func (rs *recordStream) add(rec ledger.Record) error {
+	if rs.count == rs.limit {
+		rs.more = true
+		return errPageDone
+	}
+	if rs.count > 0 && pastDeadline(rs.deadline) {
+		rs.truncated = true
+		return errPageDone
+	}
+	rs.start()
+	if rs.count > 0 {
+		io.WriteString(rs.w, ",")
+	}
+	rs.count++
+	rs.lastID = rec.ID
+	return rs.enc.Encode(newRecordResponse(rec, rs.format))
+}
+
+func (rs *recordStream) start() {
+	if !rs.started {
+		rs.started = true
+		rs.w.WriteHeader(http.StatusOK)
+		io.WriteString(rs.w, `{"success":true,"data":{"records":[`)
+	}
+}
+
+// nextCursor continues the listing after the last record written, or is
+// empty when the listing is complete
+func (rs *recordStream) nextCursor() string {
+	if !rs.more && !rs.truncated {
+		return ""
+	}
+	return strconv.FormatInt(rs.lastID, 10)
+}
+
+// finish closes the record list and writes the rest of data after it. A
+// listing cut short by errMsg, after records were written, is reported
+// truncated with the error beside the data.
+func (rs *recordStream) finish(data map[string]interface{}, errMsg string) {
+	rs.start()
+	if errMsg != "" {
+		rs.truncated = true
+	}
+	data["total"] = rs.count
+	if cursor := rs.nextCursor(); cursor != "" {
+		data["next_cursor"] = cursor
+	}
+	if rs.truncated {
+		data["truncated"] = true
+	}
+	rest, _ := json.Marshal(data)
+	io.WriteString(rs.w, "]")
+	if len(rest) > 2 {
+		io.WriteString(rs.w, ",")
+	}
+	rs.w.Write(rest[1:])
+
+	tail, _ := json.Marshal(struct {
+		Error string `json:"error,omitempty"`
+		Time  string `json:"time"`
+	}{errMsg, time.Now().UTC().Format(time.RFC3339)})
+	io.WriteString(rs.w, ",")
+	rs.w.Write(tail[1:])
+}
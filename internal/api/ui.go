@@ -0,0 +1,27 @@
+package api
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed ui
+var uiFiles embed.FS
+
+// uiHandler serves the embedded web UI under /ui/. The UI is static; it
+// reads everything through the API, sending the API key the operator
+// enters.
+func uiHandler() http.Handler {
+	root, err := fs.Sub(uiFiles, "ui")
+	if err != nil {
+		panic(err)
+	}
+	return http.StripPrefix("/ui/", http.FileServerFS(root))
+}
+
+// handleUIRedirect sends /ui to /ui/ so the page's relative asset paths
+// resolve
+func handleUIRedirect(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, "/ui/", http.StatusMovedPermanently)
+}
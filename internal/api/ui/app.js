@@ -0,0 +1,218 @@
+// StateLedger web UI. Everything is read through the REST API; the API key,
+// when the server requires one, is kept in localStorage.
+"use strict";
+
+const $ = (sel) => document.querySelector(sel);
+const keyInput = $("#api-key");
+keyInput.value = localStorage.getItem("stateledger-api-key") || "";
+keyInput.addEventListener("change", () => {
+  localStorage.setItem("stateledger-api-key", keyInput.value);
+  refreshStatus();
+  route();
+});
+
+async function api(path) {
+  const headers = {};
+  if (keyInput.value) headers["X-API-Key"] = keyInput.value;
+  const resp = await fetch(path, { headers });
+  let body;
+  try {
+    body = await resp.json();
+  } catch {
+    throw new Error(`${path}: HTTP ${resp.status}`);
+  }
+  if (!resp.ok || body.success === false) {
+    throw new Error(body.error || `${path}: HTTP ${resp.status}`);
+  }
+  return body.data;
+}
+
+function el(tag, attrs = {}, ...children) {
+  const node = document.createElement(tag);
+  for (const [k, v] of Object.entries(attrs)) {
+    if (k === "class") node.className = v;
+    else node.setAttribute(k, v);
+  }
+  for (const child of children) {
+    node.append(child instanceof Node ? child : String(child ?? ""));
+  }
+  return node;
+}
+
+function showError(err) {
+  const box = $("#error");
+  box.hidden = !err;
+  box.textContent = err ? err.message : "";
+}
+
+function pretty(payload) {
+  if (typeof payload === "string") {
+    try {
+      return JSON.stringify(JSON.parse(payload), null, 2);
+    } catch {
+      return payload;
+    }
+  }
+  return JSON.stringify(payload, null, 2);
+}
+
+// Chain status
+
+async function refreshStatus() {
+  try {
+    const stats = await api("/api/v1/stats");
+    $("#chain-records").textContent = `${stats.chain.records} records`;
+    $("#chain-head").textContent = stats.chain.head_id
+      ? `head #${stats.chain.head_id} at ${new Date(stats.chain.last_timestamp * 1000).toISOString()}`
+      : "empty ledger";
+  } catch (err) {
+    showError(err);
+  }
+}
+
+async function verifyChain() {
+  const badge = $("#chain-state");
+  badge.className = "badge";
+  badge.textContent = "chain: verifying…";
+  try {
+    const result = await api("/api/v1/verify");
+    badge.className = result.valid ? "badge ok" : "badge broken";
+    badge.textContent = result.valid
+      ? `chain: ok (${result.checked} checked)`
+      : `chain: broken at #${result.failed_id} (${result.reason})`;
+  } catch (err) {
+    badge.textContent = "chain: unknown";
+    showError(err);
+  }
+}
+
+// Records
+
+const page = { offset: 0, limit: 50 };
+
+async function loadRecords() {
+  const form = new FormData($("#record-filters"));
+  page.limit = Number(form.get("limit"));
+  const params = new URLSearchParams({ limit: page.limit, offset: page.offset, parse: "true" });
+  if (form.get("trace_id")) params.set("trace_id", form.get("trace_id"));
+
+  showError(null);
+  try {
+    const data = await api(`/api/v1/records?${params}`);
+    const type = form.get("type").trim();
+    const text = form.get("text").trim().toLowerCase();
+    const rows = (data.records || []).filter((r) =>
+      (!type || r.kind === type) &&
+      (!text || JSON.stringify(r).toLowerCase().includes(text)));
+
+    $("#records").replaceChildren(...rows.map((r) => el("tr", {},
+      el("td", { class: "mono" }, r.id),
+      el("td", {}, r.timestamp),
+      el("td", {}, r.kind),
+      el("td", { class: "mono", title: r.hash }, r.hash.slice(0, 12)),
+      el("td", { class: "payload" }, el("pre", {}, pretty(r.payload))))));
+    $("#page").textContent = `records ${page.offset + 1}–${page.offset + data.total}` +
+      (rows.length !== data.total ? ` (${rows.length} match)` : "");
+    $("#prev").disabled = page.offset === 0;
+    $("#next").disabled = !data.next_cursor;
+  } catch (err) {
+    showError(err);
+  }
+}
+
+// Snapshot
+
+function snapshotCard(title, rec) {
+  if (!rec) return el("div", { class: "card" }, el("h3", {}, title), "not captured");
+  return el("div", { class: "card" },
+    el("h3", {}, `${title} — record #${rec.id} at ${new Date(rec.timestamp * 1000).toISOString()}`),
+    el("pre", {}, pretty(rec.payload)));
+}
+
+async function loadSnapshot() {
+  const value = new FormData($("#snapshot-form")).get("time");
+  const params = new URLSearchParams();
+  if (value) params.set("time", new Date(value).toISOString().replace(/\.\d{3}Z$/, "Z"));
+
+  showError(null);
+  try {
+    const data = await api(`/api/v1/snapshot?${params}`);
+    const latest = {};
+    let mutations = 0;
+    for (const rec of data.records || []) {
+      latest[rec.type] = rec;
+      if (rec.type === "mutation") mutations++;
+    }
+    $("#snapshot").replaceChildren(
+      el("p", {}, `State at ${data.time}: ${data.count} records, ${mutations} mutations.`),
+      snapshotCard("Code", latest.code),
+      snapshotCard("Config", latest.config),
+      snapshotCard("Environment", latest.environment),
+      snapshotCard("Latest mutation", latest.mutation));
+  } catch (err) {
+    showError(err);
+  }
+}
+
+// Config diff
+
+function renderDiff(text) {
+  const pre = el("pre", { class: "diff" });
+  for (const line of text.split("\n")) {
+    let cls = "";
+    if (line.startsWith("@@")) cls = "hunk";
+    else if (line.startsWith("+") && !line.startsWith("+++")) cls = "add";
+    else if (line.startsWith("-") && !line.startsWith("---")) cls = "del";
+    pre.append(el("span", { class: cls }, line), "\n");
+  }
+  return pre;
+}
+
+async function loadDiff() {
+  const form = new FormData($("#diff-form"));
+  const params = new URLSearchParams();
+  for (const name of ["source", "from", "to"]) {
+    if (form.get(name)) params.set(name, form.get(name));
+  }
+
+  showError(null);
+  try {
+    const d = await api(`/api/v1/config/diff?${params}`);
+    const parts = [el("p", {}, `${d.source}: record #${d.from_id} → #${d.to_id}` + (d.changed ? "" : " (unchanged)"))];
+    if (d.keys && d.keys.length) {
+      parts.push(el("table", {},
+        el("thead", {}, el("tr", {}, el("th", {}, "Key"), el("th", {}, "Change"), el("th", {}, "From"), el("th", {}, "To"))),
+        el("tbody", {}, ...d.keys.map((k) => el("tr", {},
+          el("td", { class: "mono" }, k.key), el("td", {}, k.change),
+          el("td", { class: "mono" }, k.from), el("td", { class: "mono" }, k.to))))));
+    }
+    if (d.diff) parts.push(renderDiff(d.diff));
+    $("#diff").replaceChildren(...parts);
+  } catch (err) {
+    showError(err);
+  }
+}
+
+// Navigation
+
+function route() {
+  const view = (location.hash || "#records").slice(1);
+  for (const section of document.querySelectorAll(".view")) {
+    section.hidden = section.id !== `view-${view}`;
+  }
+  for (const link of document.querySelectorAll("header nav a")) {
+    link.classList.toggle("active", link.getAttribute("href") === `#${view}`);
+  }
+  if (view === "records") loadRecords();
+}
+
+$("#record-filters").addEventListener("submit", (e) => { e.preventDefault(); page.offset = 0; loadRecords(); });
+$("#prev").addEventListener("click", () => { page.offset = Math.max(0, page.offset - page.limit); loadRecords(); });
+$("#next").addEventListener("click", () => { page.offset += page.limit; loadRecords(); });
+$("#snapshot-form").addEventListener("submit", (e) => { e.preventDefault(); loadSnapshot(); });
+$("#diff-form").addEventListener("submit", (e) => { e.preventDefault(); loadDiff(); });
+$("#verify").addEventListener("click", verifyChain);
+window.addEventListener("hashchange", route);
+
+refreshStatus();
+route();
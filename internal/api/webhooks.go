@@ -0,0 +1,233 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Retr0-XD/StateLedger/internal/ledger"
+)
+
+// WebhookRequest is the body of a webhook subscription request
+type WebhookRequest struct {
+	ID     string   `json:"id"`
+	URL    string   `json:"url"`
+	Events []string `json:"events,omitempty"`
+	Secret string   `json:"secret,omitempty"`
+}
+
+// WebhookResponse describes a subscription without exposing its secret
+type WebhookResponse struct {
+	ID           string   `json:"id"`
+	URL          string   `json:"url"`
+	Events       []string `json:"events,omitempty"`
+	Active       bool     `json:"active"`
+	Signed       bool     `json:"signed"`
+	CreatedAt    string   `json:"created_at"`
+	RevokedAt    string   `json:"revoked_at,omitempty"`
+	RevokedBy    string   `json:"revoked_by,omitempty"`
+	RevokeReason string   `json:"revoke_reason,omitempty"`
+}
+
+func newWebhookResponse(sub *ledger.Subscription) WebhookResponse {
+	resp := WebhookResponse{
+		ID:           sub.ID,
+		URL:          sub.URL,
+		Events:       sub.Events,
+		Active:       sub.Active,
+		Signed:       sub.Secret != "",
+		CreatedAt:    sub.CreatedAt.UTC().Format(time.RFC3339),
+		RevokedBy:    sub.RevokedBy,
+		RevokeReason: sub.RevokeReason,
+	}
+	if !sub.RevokedAt.IsZero() {
+		resp.RevokedAt = sub.RevokedAt.UTC().Format(time.RFC3339)
+	}
+	return resp
+}
+
+// subscription returns the subscription id as it is now
+func (s *Server) subscription(id string) *ledger.Subscription {
+	for _, sub := range s.webhooks.ListSubscriptions() {
+		if sub.ID == id {
+			return sub
+		}
+	}
+	return nil
+}
+
+// SyncWebhooks subscribes the webhooks stored in the ledger that are not
+// subscribed yet, then revokes or restores every subscription as the
+// ledger records it, so revocations hold across restarts
+func (s *Server) SyncWebhooks() error {
+	hooks, err := s.ledger.Webhooks()
+	if err != nil {
+		return err
+	}
+	for _, hook := range hooks {
+		if s.subscription(hook.ID) != nil {
+			continue
+		}
+		if err := s.webhooks.SubscribeStored(hook); err != nil {
+			return fmt.Errorf("webhook %s: %w", hook.ID, err)
+		}
+	}
+
+	creds, err := s.ledger.Credentials(ledger.CredentialWebhook, true)
+	if err != nil {
+		return err
+	}
+	recorded := map[string]ledger.Credential{}
+	for _, cred := range creds {
+		recorded[cred.ID] = cred
+	}
+	for _, sub := range s.webhooks.ListSubscriptions() {
+		cred, ok := recorded[sub.ID]
+		switch {
+		case !ok || cred.Revoked() == !sub.RevokedAt.IsZero():
+		case cred.Revoked():
+			_ = s.webhooks.Revoke(sub.ID, cred.RevokedBy, cred.RevokeReason, time.Unix(cred.RevokedAt, 0))
+		default:
+			_ = s.webhooks.Restore(sub.ID)
+		}
+	}
+	return nil
+}
+
+// AddNotifier sends high-severity events to a Slack or Teams notifier
+// alongside the webhook subscriptions
+func (s *Server) AddNotifier(n *ledger.Notifier) {
+	s.webhooks.AddNotifier(n)
+}
+
+// handleListWebhooks lists webhook subscriptions, and with
+// include_revoked=true also the revoked ones
+func (s *Server) handleListWebhooks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	includeRevoked := r.URL.Query().Get("include_revoked") == "true"
+
+	subs := s.webhooks.ListSubscriptions()
+	sort.Slice(subs, func(i, j int) bool { return subs[i].ID < subs[j].ID })
+
+	responses := make([]WebhookResponse, 0, len(subs))
+	for _, sub := range subs {
+		if !sub.RevokedAt.IsZero() && !includeRevoked {
+			continue
+		}
+		responses = append(responses, newWebhookResponse(sub))
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SuccessResponse(map[string]interface{}{
+		"webhooks": responses,
+		"total":    len(responses),
+	}))
+}
+
+// handleCreateWebhook registers a webhook subscription
+func (s *Server) handleCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req WebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse("Invalid request body: " + err.Error()))
+		return
+	}
+	if strings.TrimSpace(req.ID) == "" || strings.TrimSpace(req.URL) == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse("id and url are required"))
+		return
+	}
+
+	if err := ledger.CheckWebhookURL(r.Context(), req.URL); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
+
+	// The subscription is made before it is recorded, so an ID in use
+	// leaves no record. A revoked ID is never reused, so its history stays
+	// with one subscription. The subscription is stored, so it is
+	// subscribed again after a restart.
+	hook := ledger.StoredWebhook{ID: req.ID, URL: req.URL, Events: req.Events, Secret: req.Secret, CreatedAt: time.Now()}
+	if err := s.webhooks.SubscribeStored(hook); err != nil {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
+	if _, err := s.ledger.CreateWebhook(hook, requestActor(r)); err != nil {
+		_ = s.webhooks.Unsubscribe(req.ID)
+		credentialError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(SuccessResponse(newWebhookResponse(s.subscription(req.ID))))
+}
+
+// handleDeleteWebhook revokes a webhook subscription: it receives no more
+// events but stays listed with include_revoked=true, and can be restored
+func (s *Server) handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	s.changeWebhook(w, r, true)
+}
+
+// handleRestoreWebhook resumes deliveries to a revoked subscription
+func (s *Server) handleRestoreWebhook(w http.ResponseWriter, r *http.Request) {
+	s.changeWebhook(w, r, false)
+}
+
+// changeWebhook revokes or restores a subscription and records the change
+// in the ledger
+func (s *Server) changeWebhook(w http.ResponseWriter, r *http.Request, revoke bool) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id := r.PathValue("id")
+	change, err := readCredentialChange(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse("invalid request body: " + err.Error()))
+		return
+	}
+	actor := requestActor(r)
+	before := s.subscription(id)
+
+	if revoke {
+		err = s.webhooks.Revoke(id, actor, change.Reason, time.Now())
+	} else {
+		err = s.webhooks.Restore(id)
+	}
+	if err != nil {
+		status := http.StatusNotFound
+		if errors.Is(err, ledger.ErrCredentialRevoked) || errors.Is(err, ledger.ErrCredentialActive) {
+			status = http.StatusConflict
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
+
+	if revoke {
+		_, err = s.ledger.RevokeCredential(ledger.CredentialWebhook, id, actor, change.Reason)
+	} else {
+		_, err = s.ledger.RestoreCredential(ledger.CredentialWebhook, id, actor, change.Reason)
+	}
+	if err != nil {
+		// Undo the change so the subscription matches its recorded history.
+		if revoke {
+			_ = s.webhooks.Restore(id)
+		} else {
+			_ = s.webhooks.Revoke(id, before.RevokedBy, before.RevokeReason, before.RevokedAt)
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SuccessResponse(newWebhookResponse(s.subscription(id))))
+}
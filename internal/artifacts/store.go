@@ -3,6 +3,7 @@ package artifacts
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"io"
 	"os"
 	"path/filepath"
@@ -14,6 +15,14 @@ type StoredArtifact struct {
 	Size     int64  `json:"size"`
 }
 
+// ErrInvalidChecksum is returned for checksums that are not a hex SHA-256,
+// which could otherwise name a path outside the store.
+var ErrInvalidChecksum = errors.New("invalid artifact checksum")
+
+// ErrCorrupted is returned by Verify when an artifact's content no longer
+// hashes to its checksum.
+var ErrCorrupted = errors.New("artifact content does not match checksum")
+
 func Store(root, sourcePath string) (StoredArtifact, error) {
 	in, err := os.Open(sourcePath)
 	if err != nil {
@@ -21,32 +30,51 @@ func Store(root, sourcePath string) (StoredArtifact, error) {
 	}
 	defer in.Close()
 
-	hash := sha256.New()
-	tee := io.TeeReader(in, hash)
-	data, err := io.ReadAll(tee)
+	// Stream into a temporary file beside the target and rename it into
+	// place, so a concurrent reader never sees a partial artifact.
+	tmp, err := os.CreateTemp(root, ".incoming-*")
 	if err != nil {
 		return StoredArtifact{}, err
 	}
+	defer os.Remove(tmp.Name())
 
-	sum := hex.EncodeToString(hash.Sum(nil))
-	outPath := filepath.Join(root, sum)
-	if err := os.WriteFile(outPath, data, 0o644); err != nil {
-		return StoredArtifact{}, err
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), in)
+	if err == nil {
+		err = tmp.Chmod(0o644)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
 	}
-
-	info, err := os.Stat(outPath)
 	if err != nil {
 		return StoredArtifact{}, err
 	}
 
+	sum := hex.EncodeToString(hash.Sum(nil))
+	outPath := filepath.Join(root, sum)
+	if err := os.Rename(tmp.Name(), outPath); err != nil {
+		// On Windows the rename fails while another process has the
+		// existing artifact open. Artifacts are content-addressed, so an
+		// existing file of the right size already holds these bytes.
+		if info, serr := os.Stat(outPath); serr != nil || info.Size() != size {
+			return StoredArtifact{}, err
+		}
+	}
+
 	return StoredArtifact{
 		Path:     outPath,
 		Checksum: sum,
-		Size:     info.Size(),
+		Size:     size,
 	}, nil
 }
 
 func Retrieve(root, checksum string) (string, error) {
+	if !validChecksum(checksum) {
+		return "", ErrInvalidChecksum
+	}
 	path := filepath.Join(root, checksum)
 	if _, err := os.Stat(path); err != nil {
 		return "", err
@@ -55,7 +83,84 @@ func Retrieve(root, checksum string) (string, error) {
 }
 
 func Exists(root, checksum string) bool {
+	if !validChecksum(checksum) {
+		return false
+	}
 	path := filepath.Join(root, checksum)
 	_, err := os.Stat(path)
 	return err == nil
 }
+
+// Verify checks that the artifact named by checksum is in the store and
+// that its content still hashes to the checksum. A missing artifact returns
+// an error satisfying errors.Is(err, os.ErrNotExist).
+func Verify(root, checksum string) error {
+	path, err := Retrieve(root, checksum)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return err
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != checksum {
+		return ErrCorrupted
+	}
+	return nil
+}
+
+// Usage is the space taken by an artifact store.
+type Usage struct {
+	Artifacts int64 `json:"artifacts"`
+	Bytes     int64 `json:"bytes"`
+	// Largest is the size of the biggest artifact.
+	Largest int64 `json:"largest_bytes"`
+}
+
+// StoreUsage counts the artifacts under root and their total size. Files
+// that are not named by a checksum, such as interrupted uploads, are not
+// counted. A missing store is empty.
+func StoreUsage(root string) (Usage, error) {
+	var u Usage
+	entries, err := os.ReadDir(root)
+	if errors.Is(err, os.ErrNotExist) {
+		return u, nil
+	}
+	if err != nil {
+		return u, err
+	}
+	for _, e := range entries {
+		if !e.Type().IsRegular() || !validChecksum(e.Name()) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return u, err
+		}
+		u.Artifacts++
+		u.Bytes += info.Size()
+		if info.Size() > u.Largest {
+			u.Largest = info.Size()
+		}
+	}
+	return u, nil
+}
+
+// IsChecksum reports whether s is a hex SHA-256 that can name an artifact.
+func IsChecksum(s string) bool {
+	return validChecksum(s)
+}
+
+func validChecksum(checksum string) bool {
+	if len(checksum) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(checksum)
+	return err == nil
+}
@@ -3,6 +3,7 @@ package artifacts
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
@@ -201,3 +202,110 @@ func TestExists(t *testing.T) {
 		}
 	})
 }
+
+func TestVerify(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.txt")
+	if err := os.WriteFile(testFile, []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	artifact, err := Store(tmpDir, testFile)
+	if err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+
+	if err := Verify(tmpDir, artifact.Checksum); err != nil {
+		t.Errorf("Verify() error = %v for intact artifact", err)
+	}
+
+	missing := sha256.Sum256([]byte("other"))
+	if err := Verify(tmpDir, hex.EncodeToString(missing[:])); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Verify() error = %v, want os.ErrNotExist", err)
+	}
+
+	if err := os.WriteFile(artifact.Path, []byte("tampered"), 0644); err != nil {
+		t.Fatalf("Failed to corrupt artifact: %v", err)
+	}
+	if err := Verify(tmpDir, artifact.Checksum); !errors.Is(err, ErrCorrupted) {
+		t.Errorf("Verify() error = %v, want ErrCorrupted", err)
+	}
+}
+
+func TestStorePortability(t *testing.T) {
+	root := t.TempDir()
+	src := filepath.Join(t.TempDir(), "bin.exe")
+	if err := os.WriteFile(src, []byte("artifact bytes"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	first, err := Store(root, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Storing again while a reader holds the artifact open must succeed;
+	// on Windows the file cannot be replaced while it is open.
+	held, err := os.Open(first.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Close()
+	second, err := Store(root, src)
+	if err != nil {
+		t.Fatalf("storing an artifact that is open elsewhere: %v", err)
+	}
+	if second.Path != first.Path || second.Size != first.Size {
+		t.Errorf("got %+v, want %+v", second, first)
+	}
+
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("temporary files left in the store: %v", entries)
+	}
+
+	for _, checksum := range []string{`..\..\secret`, "../../secret", first.Checksum[:10]} {
+		if _, err := Retrieve(root, checksum); !errors.Is(err, ErrInvalidChecksum) {
+			t.Errorf("Retrieve(%q) = %v, want ErrInvalidChecksum", checksum, err)
+		}
+		if Exists(root, checksum) {
+			t.Errorf("Exists(%q) should be false", checksum)
+		}
+	}
+}
+
+func TestStoreUsage(t *testing.T) {
+	tmpDir := t.TempDir()
+	storeRoot := filepath.Join(tmpDir, "artifacts")
+
+	usage, err := StoreUsage(storeRoot)
+	if err != nil || usage != (Usage{}) {
+		t.Fatalf("missing store: %+v, %v", usage, err)
+	}
+
+	if err := os.MkdirAll(storeRoot, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for i, content := range []string{"small", "a larger artifact"} {
+		src := filepath.Join(tmpDir, "src"+string(rune('a'+i)))
+		if err := os.WriteFile(src, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Store(storeRoot, src); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Leftovers of an interrupted store are not artifacts.
+	if err := os.WriteFile(filepath.Join(storeRoot, ".incoming-123"), []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	usage, err = StoreUsage(storeRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Usage{Artifacts: 2, Bytes: 22, Largest: 17}); usage != want {
+		t.Errorf("StoreUsage() = %+v, want %+v", usage, want)
+	}
+}
@@ -11,8 +11,50 @@ type CodePayload struct {
 	Commit    string   `json:"commit"`
 	Artifacts []string `json:"artifacts,omitempty"`
 	Lockfiles []string `json:"lockfiles,omitempty"`
+	// Method is how Commit was derived; empty means a VCS commit.
+	Method string `json:"method,omitempty"`
+	// Build describes the toolchain the code is built with, when known.
+	Build *BuildInfo `json:"build,omitempty"`
+	// LockfileHashes are the dependency lockfiles found with the code.
+	LockfileHashes []LockfileHash `json:"lockfile_hashes,omitempty"`
 }
 
+// LockfileHash records a dependency lockfile as captured and, for a git
+// repository, as committed at the captured commit. A difference means the
+// dependencies were modified locally.
+type LockfileHash struct {
+	// Path is slash-separated and relative to the captured directory.
+	Path string `json:"path"`
+	// Hash is the sha256: hash of the file as captured.
+	Hash string `json:"hash"`
+	// Committed is the sha256: hash of the file at the captured commit,
+	// or empty when it could not be read.
+	Committed string `json:"committed,omitempty"`
+	// Untracked is set when the captured commit does not contain the file.
+	Untracked bool `json:"untracked,omitempty"`
+}
+
+// BuildInfo records the build settings that decide whether the same
+// commit compiles to the same binary.
+type BuildInfo struct {
+	// Compiler is the toolchain version, such as go1.25.4.
+	Compiler string `json:"compiler"`
+	// GOFLAGS holds the flags applied to every go command.
+	GOFLAGS    string `json:"goflags,omitempty"`
+	CGOEnabled bool   `json:"cgo_enabled"`
+	// CC is the C compiler used by cgo.
+	CC       string `json:"cc,omitempty"`
+	Trimpath bool   `json:"trimpath"`
+	Ldflags  string `json:"ldflags,omitempty"`
+	// VCSModified is set when a binary was built from a working tree with
+	// uncommitted changes, so it does not match the recorded commit.
+	VCSModified bool `json:"vcs_modified,omitempty"`
+}
+
+// CodeMethodContentHash marks a code payload whose Commit is a hash of the
+// source tree, captured where there is no VCS.
+const CodeMethodContentHash = "content-hash"
+
 type ConfigPayload struct {
 	Source   string `json:"source"`
 	Version  string `json:"version"`
@@ -36,6 +78,8 @@ type MutationPayload struct {
 	Source      string `json:"source"`
 	Hash        string `json:"hash"`
 	ExternalRef string `json:"external_ref,omitempty"`
+	TraceID     string `json:"trace_id,omitempty"`
+	SpanID      string `json:"span_id,omitempty"`
 }
 
 func (p CodePayload) Validate() error {
@@ -90,8 +134,35 @@ func (p MutationPayload) Validate() error {
 	return nil
 }
 
+// ValidatePayload checks that raw parses as the payload of a collector kind
+// and passes its Validate. known is false for other kinds, which are not
+// checked.
+func ValidatePayload(kind, raw string) (known bool, err error) {
+	switch kind {
+	case "code":
+		return true, validatePayload[CodePayload](raw)
+	case "config":
+		return true, validatePayload[ConfigPayload](raw)
+	case "environment":
+		return true, validatePayload[EnvironmentPayload](raw)
+	case "mutation":
+		return true, validatePayload[MutationPayload](raw)
+	}
+	return false, nil
+}
+
+func validatePayload[T interface{ Validate() error }](raw string) error {
+	var p T
+	if err := ParseJSON(raw, &p); err != nil {
+		return err
+	}
+	return p.Validate()
+}
+
+// MarshalPayload encodes a payload as canonical JSON so that equal
+// payloads always hash identically.
 func MarshalPayload[T any](payload T) (string, error) {
-	data, err := json.Marshal(payload)
+	data, err := CanonicalJSON(payload)
 	if err != nil {
 		return "", err
 	}
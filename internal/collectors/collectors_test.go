@@ -289,3 +289,88 @@ func TestParseJSON(t *testing.T) {
 		}
 	})
 }
+
+func TestCanonicalJSON(t *testing.T) {
+	t.Run("key order independent", func(t *testing.T) {
+		a, err := CanonicalizeJSON([]byte(`{"b":1,"a":{"y":true,"x":null}}`))
+		if err != nil {
+			t.Fatalf("CanonicalizeJSON() error = %v", err)
+		}
+		b, err := CanonicalizeJSON([]byte("{ \"a\": {\"x\": null, \"y\": true},\n \"b\": 1 }"))
+		if err != nil {
+			t.Fatalf("CanonicalizeJSON() error = %v", err)
+		}
+		if string(a) != string(b) {
+			t.Errorf("canonical forms differ: %s vs %s", a, b)
+		}
+		if string(a) != `{"a":{"x":null,"y":true},"b":1}` {
+			t.Errorf("unexpected canonical form: %s", a)
+		}
+	})
+
+	t.Run("number formatting", func(t *testing.T) {
+		tests := map[string]string{
+			`1.0`:    `1`,
+			`1e0`:    `1`,
+			`-0.0`:   `0`,
+			`0.5`:    `0.5`,
+			`1e21`:   `1e+21`,
+			`100`:    `100`,
+			`1.5e-7`: `1.5e-07`,
+		}
+		for in, want := range tests {
+			got, err := CanonicalizeJSON([]byte(in))
+			if err != nil {
+				t.Fatalf("CanonicalizeJSON(%s) error = %v", in, err)
+			}
+			if string(got) != want {
+				t.Errorf("CanonicalizeJSON(%s) = %s, want %s", in, got, want)
+			}
+		}
+	})
+
+	t.Run("large integers kept exactly", func(t *testing.T) {
+		tests := map[string]string{
+			`12345678901234567890`:       `12345678901234567890`,
+			`-98765432109876543210987`:   `-98765432109876543210987`,
+			`{"n":18446744073709551617}`: `{"n":18446744073709551617}`,
+		}
+		for in, want := range tests {
+			got, err := CanonicalizeJSON([]byte(in))
+			if err != nil {
+				t.Fatalf("CanonicalizeJSON(%s) error = %v", in, err)
+			}
+			if string(got) != want {
+				t.Errorf("CanonicalizeJSON(%s) = %s, want %s", in, got, want)
+			}
+		}
+	})
+
+	t.Run("keys ordered by utf-16 code units", func(t *testing.T) {
+		// U+1F600 is the surrogate pair D83D DE00 in UTF-16, so it sorts
+		// before U+FB01 although its UTF-8 bytes sort after.
+		got, err := CanonicalizeJSON([]byte(`{"\ufb01":1,"\ud83d\ude00":2,"a":3}`))
+		if err != nil {
+			t.Fatalf("CanonicalizeJSON() error = %v", err)
+		}
+		if want := "{\"a\":3,\"\U0001F600\":2,\"\uFB01\":1}"; string(got) != want {
+			t.Errorf("CanonicalizeJSON() = %s, want %s", got, want)
+		}
+	})
+
+	t.Run("no html escaping", func(t *testing.T) {
+		got, err := CanonicalJSON(map[string]string{"cmd": "a && b <c>"})
+		if err != nil {
+			t.Fatalf("CanonicalJSON() error = %v", err)
+		}
+		if string(got) != `{"cmd":"a && b <c>"}` {
+			t.Errorf("unexpected output: %s", got)
+		}
+	})
+
+	t.Run("trailing data rejected", func(t *testing.T) {
+		if _, err := CanonicalizeJSON([]byte(`{} {}`)); err == nil {
+			t.Error("expected error for trailing data")
+		}
+	})
+}
@@ -0,0 +1,69 @@
+package collectors
+
+import (
+	"reflect"
+	"strings"
+)
+
+// JSONSchemaDialect is the JSON Schema version payload schemas are written in.
+const JSONSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// BuiltinSchemas returns the JSON Schema of each built-in payload type, keyed
+// by record type. Required fields match the payloads' Validate methods.
+func BuiltinSchemas() map[string]map[string]any {
+	return map[string]map[string]any{
+		"code":        structSchema("code", CodePayload{}, "repo", "commit"),
+		"config":      structSchema("config", ConfigPayload{}, "source", "hash", "snapshot"),
+		"environment": structSchema("environment", EnvironmentPayload{}, "os", "runtime", "arch", "time_source"),
+		"mutation":    structSchema("mutation", MutationPayload{}, "type", "id", "source"),
+	}
+}
+
+// structSchema describes a payload struct from its JSON tags. Payloads
+// reject unknown fields, so the schema does too.
+func structSchema(title string, v any, required ...string) map[string]any {
+	schema := objectSchema(reflect.TypeOf(v))
+	schema["$schema"] = JSONSchemaDialect
+	schema["title"] = title
+	schema["required"] = required
+	return schema
+}
+
+func objectSchema(t reflect.Type) map[string]any {
+	props := map[string]any{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		props[name] = kindSchema(f.Type)
+	}
+	return map[string]any{
+		"type":                 "object",
+		"properties":           props,
+		"additionalProperties": false,
+	}
+}
+
+func kindSchema(t reflect.Type) map[string]any {
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": kindSchema(t.Elem())}
+	case reflect.Pointer:
+		return kindSchema(t.Elem())
+	case reflect.Struct:
+		return objectSchema(t)
+	default:
+		return map[string]any{}
+	}
+}
@@ -0,0 +1,428 @@
+// Package config loads the YAML file that configures `stateledger
+// all-in-one`.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Config is the server and all-in-one configuration: the API server and
+// its request policy, the capture schedule, retention and notifications,
+// all sharing one ledger.
+type Config struct {
+	DB        string     `json:"db"`
+	Artifacts string     `json:"artifacts"`
+	Server    Server     `json:"server"`
+	Auth      Auth       `json:"auth"`
+	RateLimit RateLimit  `json:"rate_limit"`
+	Log       Log        `json:"log"`
+	Notify    Notify     `json:"notify"`
+	Digest    Digest     `json:"digest"`
+	Webhooks  []Webhook  `json:"webhooks"`
+	Schedule  []Schedule `json:"schedule"`
+	Retention Retention  `json:"retention"`
+	Anchor    Anchor     `json:"anchor"`
+	Policies  Policies   `json:"policies"`
+}
+
+// Server configures the API server and its background jobs.
+type Server struct {
+	Addr         string   `json:"addr"`
+	CacheTTL     Duration `json:"cache_ttl"`
+	TLSCert      string   `json:"tls_cert"`
+	TLSKey       string   `json:"tls_key"`
+	ClientCA     string   `json:"client_ca"`
+	MirrorDB     string   `json:"mirror_db"`
+	Journal      string   `json:"journal"`
+	ReadReplicas []string `json:"read_replicas"`
+
+	HA        bool     `json:"ha"`
+	ReplicaID string   `json:"replica_id"`
+	LeaseTTL  Duration `json:"lease_ttl"`
+
+	VerifyInterval      Duration `json:"verify_interval"`
+	AppendSLO           Duration `json:"append_slo"`
+	QueryBudget         Duration `json:"query_budget"`
+	RecordIDs           string   `json:"record_ids"`
+	AlertInterval       Duration `json:"alert_interval"`
+	AgentOfflineAfter   Duration `json:"agent_offline_after"`
+	MinDeterminismScore float64  `json:"min_determinism_score"`
+	AnomalyWindow       Duration `json:"anomaly_window"`
+	AnomalySensitivity  float64  `json:"anomaly_sensitivity"`
+}
+
+// Auth configures API key authentication. Health checks and agents with a
+// verified client certificate need no key.
+type Auth struct {
+	APIKeys []string `json:"api_keys"`
+	// AdminKeys request and approve administrative actions.
+	AdminKeys []string `json:"admin_keys"`
+	// RequireApproval is accepted from configs written when retention
+	// changes needed approval only with it set; they now always do. Once
+	// set, it can only be turned off by a restart.
+	RequireApproval bool     `json:"require_approval"`
+	ApprovalWindow  Duration `json:"approval_window"`
+	// InsecureNoAuth serves writes without an API key while APIKeys is
+	// empty. Without it, such a server only serves reads.
+	InsecureNoAuth bool `json:"insecure_no_auth"`
+}
+
+// RateLimit limits the requests per second of each client, identified by
+// API key or address.
+type RateLimit struct {
+	RequestsPerSecond int `json:"requests_per_second"`
+	Burst             int `json:"burst"`
+	// Shared keeps the limits in the ledger database, so they survive
+	// restarts and hold across replicas sharing it.
+	Shared bool `json:"shared"`
+}
+
+// Log configures request logging to stderr.
+type Log struct {
+	Requests bool `json:"requests"`
+	// Format is "text" or "json".
+	Format string `json:"format"`
+}
+
+// Notify configures chat notifications of high-severity events.
+type Notify struct {
+	Slack       string `json:"slack"`
+	Teams       string `json:"teams"`
+	Templates   string `json:"templates"`
+	MinSeverity string `json:"min_severity"`
+}
+
+// Digest configures the periodic activity digest email. The SMTP password
+// is read from the environment, not the file.
+type Digest struct {
+	Interval Duration `json:"interval"`
+	SMTPAddr string   `json:"smtp_addr"`
+	SMTPFrom string   `json:"smtp_from"`
+	SMTPTo   []string `json:"smtp_to"`
+	SMTPUser string   `json:"smtp_user"`
+}
+
+// Webhook subscribes a URL to ledger events at startup, like
+// POST /api/v1/webhooks.
+type Webhook struct {
+	ID     string   `json:"id"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret"`
+}
+
+// Schedule runs the collectors of a manifest every Interval.
+type Schedule struct {
+	// Name identifies the job in /api/v1/leader. Defaults to the manifest
+	// file name.
+	Name     string   `json:"name"`
+	Manifest string   `json:"manifest"`
+	Interval Duration `json:"interval"`
+	// Source is the source of the captured records. Defaults to Name.
+	Source  string `json:"source"`
+	AgentID string `json:"agent_id"`
+	// RunAtStart captures once at startup instead of waiting an interval.
+	RunAtStart bool `json:"run_at_start"`
+}
+
+// Retention archives records older than ArchiveAfter to To every Interval.
+type Retention struct {
+	ArchiveAfter Duration `json:"archive_after"`
+	Interval     Duration `json:"interval"`
+	To           string   `json:"to"`
+	SegmentSize  int      `json:"segment_size"`
+}
+
+// Anchor publishes the chain head to external witnesses every Interval.
+type Anchor struct {
+	Interval Duration `json:"interval"`
+	// Witnesses are https://host/path, s3://bucket/prefix or
+	// rfc3161+https://host/path locations.
+	Witnesses []string `json:"witnesses"`
+}
+
+// Policies are written to the ledger as versioned policy records at
+// startup and on every reload, so the rules in force at any time can be
+// reconstructed alongside the state they governed. A section left out
+// keeps the version already in force.
+type Policies struct {
+	Determinism *DeterminismPolicy `json:"determinism"`
+	Retention   []RetentionRule    `json:"retention"`
+	Coverage    *CoveragePolicy    `json:"coverage"`
+}
+
+// DeterminismPolicy is the lowest acceptable determinism score (0-100).
+type DeterminismPolicy struct {
+	MinScore float64 `json:"min_score"`
+}
+
+// RetentionRule prunes records of Type, or of every type when empty, older
+// than MaxAge or beyond the newest KeepLast, on prune runs without rules of
+// their own.
+type RetentionRule struct {
+	Type     string   `json:"type"`
+	MaxAge   Duration `json:"max_age"`
+	KeepLast int      `json:"keep_last"`
+}
+
+// CoveragePolicy lists the snapshot kinds a reconstructed state needs to
+// be complete: code, config, environment or mutations.
+type CoveragePolicy struct {
+	Require []string `json:"require"`
+}
+
+// Defaults applied by Parse.
+const (
+	DefaultAddr              = ":8080"
+	DefaultCacheTTL          = 30 * time.Second
+	DefaultQueryBudget       = 10 * time.Second
+	DefaultAgentOfflineAfter = 15 * time.Minute
+	DefaultMinSeverity       = "warning"
+	DefaultRetentionInterval = 24 * time.Hour
+	DefaultSegmentSize       = 10000
+)
+
+// Duration is a time.Duration written as a string such as "90s" or "24h".
+type Duration time.Duration
+
+// UnmarshalJSON accepts a duration string or a number of seconds.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		v, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		*d = Duration(v)
+		return nil
+	}
+	var secs float64
+	if err := json.Unmarshal(data, &secs); err != nil {
+		return fmt.Errorf("invalid duration %s", data)
+	}
+	*d = Duration(secs * float64(time.Second))
+	return nil
+}
+
+// MarshalJSON writes the duration as a string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Load reads the YAML config file at path. ${VAR} references are replaced
+// with environment variables first, so secrets can stay out of the file.
+// Relative manifest paths are resolved against the file's directory.
+func Load(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	cfg, err := Parse(os.ExpandEnv(string(data)))
+	if err != nil {
+		return Config{}, fmt.Errorf("%s: %w", path, err)
+	}
+	for i, s := range cfg.Schedule {
+		if s.Manifest != "" && !filepath.IsAbs(s.Manifest) {
+			cfg.Schedule[i].Manifest = filepath.Join(filepath.Dir(path), s.Manifest)
+		}
+	}
+	return cfg, nil
+}
+
+// Parse decodes and validates a YAML config and fills in defaults.
+func Parse(data string) (Config, error) {
+	doc, err := parseYAML(data)
+	if err != nil {
+		return Config{}, err
+	}
+	if _, ok := doc.(map[string]any); !ok {
+		return Config{}, errors.New("config must be a mapping")
+	}
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		return Config{}, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	var cfg Config
+	if err := dec.Decode(&cfg); err != nil {
+		return Config{}, err
+	}
+	cfg.setDefaults()
+	return cfg, cfg.Validate()
+}
+
+func (c *Config) setDefaults() {
+	if c.DB == "" {
+		c.DB = filepath.Join("data", "ledger.db")
+	}
+	if c.Artifacts == "" {
+		c.Artifacts = "artifacts"
+	}
+	if c.Server.Addr == "" {
+		c.Server.Addr = DefaultAddr
+	}
+	if c.Server.CacheTTL == 0 {
+		c.Server.CacheTTL = Duration(DefaultCacheTTL)
+	}
+	if c.Server.QueryBudget == 0 {
+		c.Server.QueryBudget = Duration(DefaultQueryBudget)
+	}
+	if c.Server.AgentOfflineAfter == 0 {
+		c.Server.AgentOfflineAfter = Duration(DefaultAgentOfflineAfter)
+	}
+	if c.Notify.MinSeverity == "" {
+		c.Notify.MinSeverity = DefaultMinSeverity
+	}
+	if c.Log.Format == "" {
+		c.Log.Format = "text"
+	}
+	for i := range c.Schedule {
+		s := &c.Schedule[i]
+		if s.Name == "" {
+			s.Name = strings.TrimSuffix(filepath.Base(s.Manifest), filepath.Ext(s.Manifest))
+		}
+		if s.Source == "" {
+			s.Source = s.Name
+		}
+	}
+	if c.Retention.Interval == 0 {
+		c.Retention.Interval = Duration(DefaultRetentionInterval)
+	}
+	if c.Retention.SegmentSize == 0 {
+		c.Retention.SegmentSize = DefaultSegmentSize
+	}
+}
+
+// Validate reports missing or inconsistent settings.
+func (c Config) Validate() error {
+	if (c.Server.TLSCert == "") != (c.Server.TLSKey == "") {
+		return errors.New("server.tls_cert and server.tls_key must be set together")
+	}
+	if c.Server.ClientCA != "" && c.Server.TLSCert == "" {
+		return errors.New("server.client_ca requires server.tls_cert")
+	}
+	for i, k := range c.Auth.APIKeys {
+		if k == "" {
+			return fmt.Errorf("auth.api_keys[%d] is empty", i)
+		}
+	}
+	for i, k := range c.Auth.AdminKeys {
+		if k == "" {
+			return fmt.Errorf("auth.admin_keys[%d] is empty", i)
+		}
+	}
+	if c.Auth.InsecureNoAuth && len(c.Auth.APIKeys) > 0 {
+		return errors.New("auth.insecure_no_auth cannot be combined with auth.api_keys")
+	}
+	if c.Auth.RequireApproval && len(c.Auth.AdminKeys) < 2 {
+		return errors.New("auth.require_approval needs at least two auth.admin_keys")
+	}
+	if c.Auth.ApprovalWindow < 0 {
+		return errors.New("auth.approval_window must not be negative")
+	}
+	if c.RateLimit.RequestsPerSecond < 0 || c.RateLimit.Burst < 0 {
+		return errors.New("rate_limit settings must not be negative")
+	}
+	if c.Log.Format != "text" && c.Log.Format != "json" {
+		return fmt.Errorf("log.format: unknown format %q", c.Log.Format)
+	}
+	switch c.Server.RecordIDs {
+	case "", "ulid", "uuidv7":
+	default:
+		return fmt.Errorf("server.record_ids: unknown generator %q (want ulid or uuidv7)", c.Server.RecordIDs)
+	}
+	if c.Server.AnomalyWindow < 0 || c.Server.AnomalySensitivity < 0 {
+		return errors.New("server.anomaly_window and server.anomaly_sensitivity must not be negative")
+	}
+	switch c.Notify.MinSeverity {
+	case "info", "warning", "critical":
+	default:
+		return fmt.Errorf("notify.min_severity: unknown severity %q", c.Notify.MinSeverity)
+	}
+	if c.Digest.Interval > 0 && (c.Digest.SMTPAddr == "" || c.Digest.SMTPFrom == "" || len(c.Digest.SMTPTo) == 0) {
+		return errors.New("digest.interval requires digest.smtp_addr, digest.smtp_from and digest.smtp_to")
+	}
+	ids := map[string]bool{}
+	for i, w := range c.Webhooks {
+		if w.ID == "" || w.URL == "" {
+			return fmt.Errorf("webhooks[%d]: id and url are required", i)
+		}
+		if ids[w.ID] {
+			return fmt.Errorf("webhooks[%d]: duplicate id %q", i, w.ID)
+		}
+		ids[w.ID] = true
+	}
+	names := map[string]bool{}
+	for i, s := range c.Schedule {
+		if s.Manifest == "" {
+			return fmt.Errorf("schedule[%d]: manifest is required", i)
+		}
+		if s.Interval <= 0 {
+			return fmt.Errorf("schedule[%d]: interval must be positive", i)
+		}
+		if names[s.Name] {
+			return fmt.Errorf("schedule[%d]: duplicate name %q", i, s.Name)
+		}
+		names[s.Name] = true
+	}
+	if c.Retention.ArchiveAfter > 0 && c.Retention.To == "" {
+		return errors.New("retention.archive_after requires retention.to")
+	}
+	if c.Retention.ArchiveAfter < 0 || c.Retention.Interval < 0 || c.Retention.SegmentSize < 0 {
+		return errors.New("retention settings must not be negative")
+	}
+	if c.Anchor.Interval < 0 {
+		return errors.New("anchor.interval must not be negative")
+	}
+	if c.Anchor.Interval > 0 && len(c.Anchor.Witnesses) == 0 {
+		return errors.New("anchor.interval requires anchor.witnesses")
+	}
+	for i, w := range c.Anchor.Witnesses {
+		if w == "" {
+			return fmt.Errorf("anchor.witnesses[%d] is empty", i)
+		}
+	}
+	if d := c.Policies.Determinism; d != nil && (d.MinScore < 0 || d.MinScore > 100) {
+		return errors.New("policies.determinism.min_score must be between 0 and 100")
+	}
+	for i, r := range c.Policies.Retention {
+		if r.MaxAge < 0 || r.KeepLast < 0 {
+			return fmt.Errorf("policies.retention[%d] must not be negative", i)
+		}
+		if r.MaxAge == 0 && r.KeepLast == 0 {
+			return fmt.Errorf("policies.retention[%d]: max_age or keep_last is required", i)
+		}
+	}
+	if cov := c.Policies.Coverage; cov != nil {
+		for i, kind := range cov.Require {
+			switch kind {
+			case "code", "config", "environment", "mutations":
+			default:
+				return fmt.Errorf("policies.coverage.require[%d]: unknown kind %q", i, kind)
+			}
+		}
+	}
+	return nil
+}
+
+// RestartRequired reports whether next changes settings of c that a
+// running server cannot reload. Auth, rate limits, logging, webhooks,
+// policies and retention reload, except that turning retention on or off or changing
+// its interval needs a restart.
+func (c Config) RestartRequired(next Config) bool {
+	if (c.Retention.ArchiveAfter > 0) != (next.Retention.ArchiveAfter > 0) || c.Retention.Interval != next.Retention.Interval {
+		return true
+	}
+	c.Auth, c.RateLimit, c.Log, c.Webhooks, c.Retention, c.Policies = Auth{}, RateLimit{}, Log{}, nil, Retention{}, Policies{}
+	next.Auth, next.RateLimit, next.Log, next.Webhooks, next.Retention, next.Policies = Auth{}, RateLimit{}, Log{}, nil, Retention{}, Policies{}
+	return !reflect.DeepEqual(c, next)
+}
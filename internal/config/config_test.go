@@ -0,0 +1,204 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseYAML(t *testing.T) {
+	doc, err := parseYAML(`
+# comment
+name: "quoted: value" # trailing comment
+count: 3
+ratio: 0.5
+on: true
+empty:
+tags: [a, 'b c', 2]
+nested:
+  key: value
+list:
+- one
+- key: x
+  other: y
+-
+  deep: 1
+`)
+	if err != nil {
+		t.Fatalf("parseYAML: %v", err)
+	}
+	want := map[string]any{
+		"name":   "quoted: value",
+		"count":  int64(3),
+		"ratio":  0.5,
+		"on":     true,
+		"empty":  nil,
+		"tags":   []any{"a", "b c", int64(2)},
+		"nested": map[string]any{"key": "value"},
+		"list": []any{
+			"one",
+			map[string]any{"key": "x", "other": "y"},
+			map[string]any{"deep": int64(1)},
+		},
+	}
+	if !reflect.DeepEqual(doc, want) {
+		t.Fatalf("parseYAML = %#v, want %#v", doc, want)
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	tests := map[string]string{
+		"tab indent":    "a:\n\tb: 1",
+		"duplicate key": "a: 1\na: 2",
+		"bad indent":    "a: 1\n  b: 2",
+		"flow mapping":  "a: {b: 1}",
+		"anchor":        "a: &x 1",
+		"unterminated":  `a: "open`,
+		"no colon":      "just text",
+	}
+	for name, doc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parseYAML(doc); err == nil {
+				t.Fatalf("expected error for %q", doc)
+			}
+		})
+	}
+}
+
+func TestParseDefaults(t *testing.T) {
+	cfg, err := Parse(`
+schedule:
+  - manifest: manifests/app.json
+    interval: 15m
+  - manifest: env.json
+    interval: 60
+    source: hosts
+`)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if cfg.Server.Addr != DefaultAddr || time.Duration(cfg.Server.CacheTTL) != DefaultCacheTTL {
+		t.Errorf("server defaults not applied: %+v", cfg.Server)
+	}
+	if cfg.Notify.MinSeverity != DefaultMinSeverity {
+		t.Errorf("min severity = %q", cfg.Notify.MinSeverity)
+	}
+	if cfg.DB != filepath.Join("data", "ledger.db") || cfg.Artifacts != "artifacts" {
+		t.Errorf("paths = %q, %q", cfg.DB, cfg.Artifacts)
+	}
+	app, env := cfg.Schedule[0], cfg.Schedule[1]
+	if app.Name != "app" || app.Source != "app" || time.Duration(app.Interval) != 15*time.Minute {
+		t.Errorf("schedule[0] = %+v", app)
+	}
+	if env.Name != "env" || env.Source != "hosts" || time.Duration(env.Interval) != time.Minute {
+		t.Errorf("schedule[1] = %+v", env)
+	}
+	if time.Duration(cfg.Retention.Interval) != DefaultRetentionInterval || cfg.Retention.SegmentSize != DefaultSegmentSize {
+		t.Errorf("retention defaults not applied: %+v", cfg.Retention)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := map[string]string{
+		"unknown field":      "sever:\n  addr: :80",
+		"bad duration":       "server:\n  cache_ttl: soon",
+		"tls key missing":    "server:\n  tls_cert: cert.pem",
+		"severity":           "notify:\n  min_severity: loud",
+		"digest smtp":        "digest:\n  interval: 24h",
+		"schedule interval":  "schedule:\n  - manifest: m.json",
+		"schedule manifest":  "schedule:\n  - interval: 1m",
+		"duplicate job":      "schedule:\n  - manifest: a/m.json\n    interval: 1m\n  - manifest: b/m.json\n    interval: 1m",
+		"webhook url":        "webhooks:\n  - id: ci",
+		"empty api key":      "auth:\n  api_keys: ['']",
+		"approval keys":      "auth:\n  admin_keys: [a]\n  require_approval: true",
+		"insecure with keys": "auth:\n  api_keys: [a]\n  insecure_no_auth: true",
+		"rate limit":         "rate_limit:\n  requests_per_second: -1",
+		"log format":         "log:\n  format: xml",
+		"retention to":       "retention:\n  archive_after: 720h",
+		"anchor witnesses":   "anchor:\n  interval: 1h",
+		"policy min score":   "policies:\n  determinism:\n    min_score: 120",
+		"policy retention":   "policies:\n  retention:\n    - type: capture.*",
+		"policy coverage":    "policies:\n  coverage:\n    require: [code, logs]",
+		"anomaly window":     "server:\n  anomaly_window: -5m",
+		"not a mapping":      "- a\n- b",
+	}
+	for name, doc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Parse(doc); err == nil {
+				t.Fatalf("expected error for %q", doc)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "stateledger.yaml")
+	t.Setenv("TEST_SLACK_URL", "https://hooks.example.com/x")
+	abs := filepath.Join(t.TempDir(), "other.json")
+	data := "notify:\n  slack: ${TEST_SLACK_URL}\nschedule:\n  - manifest: manifest.json\n    interval: 1h\n  - manifest: '" + abs + "'\n    interval: 1h\n"
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Notify.Slack != "https://hooks.example.com/x" {
+		t.Errorf("slack = %q, want the expanded variable", cfg.Notify.Slack)
+	}
+	if got := cfg.Schedule[0].Manifest; got != filepath.Join(dir, "manifest.json") {
+		t.Errorf("relative manifest = %q", got)
+	}
+	if got := cfg.Schedule[1].Manifest; got != abs {
+		t.Errorf("absolute manifest = %q", got)
+	}
+
+	if _, err := Load(filepath.Join(dir, "missing.yaml")); err == nil {
+		t.Error("expected error for a missing file")
+	}
+	if err := os.WriteFile(path, []byte("server:\n  addr: [\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), path) {
+		t.Errorf("error %v should name the file", err)
+	}
+}
+
+func TestRestartRequired(t *testing.T) {
+	const base = "server:\n  addr: :8080\nretention:\n  archive_after: 720h\n  to: archive\n"
+	cfg, err := Parse(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		next string
+		want bool
+	}{
+		{"unchanged", base, false},
+		{"auth", base + "auth:\n  api_keys: [k]\n", false},
+		{"rate limit and log", base + "rate_limit:\n  requests_per_second: 5\n  shared: true\nlog:\n  requests: true\n", false},
+		{"webhooks", base + "webhooks:\n  - id: ci\n    url: http://ci\n", false},
+		{"policies", base + "policies:\n  determinism:\n    min_score: 80\n", false},
+		{"retention target", strings.Replace(base, "to: archive", "to: elsewhere", 1), false},
+		{"retention interval", base + "  interval: 1h\n", true},
+		{"retention off", "server:\n  addr: :8080\n", true},
+		{"addr", strings.Replace(base, ":8080", ":9090", 1), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next, err := Parse(tt.next)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := cfg.RestartRequired(next); got != tt.want {
+				t.Errorf("RestartRequired = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
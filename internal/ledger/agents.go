@@ -0,0 +1,218 @@
+package ledger
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const agentSchema = `
+CREATE TABLE IF NOT EXISTS ledger_agents (
+	id TEXT PRIMARY KEY,
+	hostname TEXT NOT NULL,
+	version TEXT NOT NULL,
+	public_key TEXT NOT NULL,
+	registered_at INTEGER NOT NULL,
+	last_seen INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS ledger_record_agents (
+	record_id INTEGER PRIMARY KEY,
+	agent_id TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_ledger_record_agents_agent ON ledger_record_agents(agent_id);
+CREATE TABLE IF NOT EXISTS ledger_agent_cursors (
+	agent_id TEXT PRIMARY KEY,
+	last_seq INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS ledger_agent_proofs (
+	record_id INTEGER PRIMARY KEY,
+	agent_id TEXT NOT NULL,
+	seq INTEGER NOT NULL,
+	hash TEXT NOT NULL,
+	prev_hash TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_ledger_agent_proofs_agent ON ledger_agent_proofs(agent_id, seq);
+`
+
+// ErrUnknownAgent is returned for an agent ID that never registered.
+var ErrUnknownAgent = errors.New("agent not registered")
+
+// Agent is a registered capture agent.
+type Agent struct {
+	ID       string `json:"id"`
+	Hostname string `json:"hostname"`
+	Version  string `json:"version"`
+	// PublicKey is the agent's base64-encoded ed25519 public key.
+	PublicKey    string `json:"public_key"`
+	RegisteredAt int64  `json:"registered_at"`
+	LastSeen     int64  `json:"last_seen"`
+	Records      int64  `json:"records"`
+}
+
+// AgentRegistration is what an agent presents when it registers.
+type AgentRegistration struct {
+	Hostname  string `json:"hostname"`
+	Version   string `json:"version"`
+	PublicKey string `json:"public_key"`
+}
+
+// AgentID derives an agent's ID from its base64-encoded ed25519 public key,
+// so an agent keeps its identity across re-registrations.
+func AgentID(publicKey string) (string, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(publicKey))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return "", errors.New("public key must be a base64-encoded ed25519 key")
+	}
+	sum := sha256.Sum256(key)
+	return "agent-" + hex.EncodeToString(sum[:8]), nil
+}
+
+// RegisterAgent registers an agent, or refreshes the hostname, version and
+// last-seen time of an agent that registered with the same key before.
+func (l *Ledger) RegisterAgent(reg AgentRegistration) (Agent, error) {
+	if strings.TrimSpace(reg.Hostname) == "" {
+		return Agent{}, errors.New("hostname required")
+	}
+	id, err := AgentID(reg.PublicKey)
+	if err != nil {
+		return Agent{}, err
+	}
+	if err := l.ensureAgentSchema(); err != nil {
+		return Agent{}, err
+	}
+
+	now := time.Now().Unix()
+	_, err = l.db.Exec(`INSERT INTO ledger_agents(id, hostname, version, public_key, registered_at, last_seen) VALUES(?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET hostname = excluded.hostname, version = excluded.version, last_seen = excluded.last_seen`,
+		id, reg.Hostname, reg.Version, strings.TrimSpace(reg.PublicKey), now, now)
+	if err != nil {
+		return Agent{}, err
+	}
+	return l.Agent(id)
+}
+
+// TouchAgent records that an agent was seen now.
+func (l *Ledger) TouchAgent(id string) (Agent, error) {
+	if err := l.ensureAgentSchema(); err != nil {
+		return Agent{}, err
+	}
+	res, err := l.db.Exec(`UPDATE ledger_agents SET last_seen = MAX(last_seen, ?) WHERE id = ?`, time.Now().Unix(), id)
+	if err != nil {
+		return Agent{}, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return Agent{}, fmt.Errorf("agent %s: %w", id, ErrUnknownAgent)
+	}
+	return l.Agent(id)
+}
+
+// Agent returns a registered agent with its attributed record count.
+func (l *Ledger) Agent(id string) (Agent, error) {
+	if err := l.ensureAgentSchema(); err != nil {
+		return Agent{}, err
+	}
+	row := l.db.QueryRow(agentSelectSQL+` WHERE a.id = ?`, id)
+	agent, err := scanAgent(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Agent{}, fmt.Errorf("agent %s: %w", id, ErrUnknownAgent)
+	}
+	return agent, err
+}
+
+// Agents returns every registered agent, most recently seen first.
+func (l *Ledger) Agents() ([]Agent, error) {
+	if err := l.ensureAgentSchema(); err != nil {
+		return nil, err
+	}
+	rows, err := l.db.Query(agentSelectSQL + ` ORDER BY a.last_seen DESC, a.id ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	agents := []Agent{}
+	for rows.Next() {
+		agent, err := scanAgent(rows)
+		if err != nil {
+			return nil, err
+		}
+		agents = append(agents, agent)
+	}
+	return agents, rows.Err()
+}
+
+const agentSelectSQL = `SELECT a.id, a.hostname, a.version, a.public_key, a.registered_at, a.last_seen,
+	(SELECT COUNT(*) FROM ledger_record_agents r WHERE r.agent_id = a.id) FROM ledger_agents a`
+
+func scanAgent(row interface{ Scan(...any) error }) (Agent, error) {
+	var a Agent
+	err := row.Scan(&a.ID, &a.Hostname, &a.Version, &a.PublicKey, &a.RegisteredAt, &a.LastSeen, &a.Records)
+	return a, err
+}
+
+func (l *Ledger) ensureAgentSchema() error {
+	if l.agentsReady.Load() {
+		return nil
+	}
+	if _, err := l.db.Exec(agentSchema); err != nil {
+		return err
+	}
+	l.agentsReady.Store(true)
+	return nil
+}
+
+// attributeRecord links a record to the agent that captured it within the
+// record's transaction and refreshes the agent's last-seen time.
+func attributeRecord(tx *sql.Tx, recordID int64, agentID string) error {
+	res, err := tx.Exec(`UPDATE ledger_agents SET last_seen = MAX(last_seen, ?) WHERE id = ?`, time.Now().Unix(), agentID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("agent %s: %w", agentID, ErrUnknownAgent)
+	}
+	_, err = tx.Exec(`INSERT INTO ledger_record_agents(record_id, agent_id) VALUES(?, ?)`, recordID, agentID)
+	return err
+}
+
+// attachAgents fills in the agent ID of each record that an agent captured.
+func (l *Ledger) attachAgents(q rowQueryer, records []Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+	if err := l.ensureAgentSchema(); err != nil {
+		return err
+	}
+	lo, hi := records[0].ID, records[0].ID
+	for _, rec := range records {
+		lo, hi = min(lo, rec.ID), max(hi, rec.ID)
+	}
+	rows, err := q.Query(`SELECT record_id, agent_id FROM ledger_record_agents WHERE record_id BETWEEN ? AND ?`, lo, hi)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	agents := map[int64]string{}
+	for rows.Next() {
+		var id int64
+		var agent string
+		if err := rows.Scan(&id, &agent); err != nil {
+			return err
+		}
+		agents[id] = agent
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for i := range records {
+		records[i].AgentID = agents[records[i].ID]
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package ledger
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Retr0-XD/StateLedger/internal/collectors"
+)
+
+// AlertMonitor checks the ledger for conditions worth alerting on and
+// reports each one once, until it clears:
+//   - drift.detected when a config source captures a snapshot that differs
+//     from its previous one
+//   - coverage.degraded when a registered source becomes overdue or missing
+//   - agent.offline when an agent has not been seen for AgentOfflineAfter
+//   - determinism.low when the determinism score of the current state drops
+//     below MinDeterminismScore
+//   - mutation.anomaly when the mutation rate of a source and mutation type
+//     surges or drops sharply from its baseline, also appended as an
+//     anomaly record
+type AlertMonitor struct {
+	Ledger *Ledger
+	// AgentOfflineAfter is how long an agent may go unseen before it is
+	// reported offline. Zero disables the agent check.
+	AgentOfflineAfter time.Duration
+	// MinDeterminismScore is the lowest acceptable determinism score (0-100).
+	// Zero falls back to the determinism policy in force, and disables the
+	// determinism check when there is none.
+	MinDeterminismScore float64
+	// AnomalyWindow is the window over which mutation rates are counted
+	// and compared with the DefaultAnomalyBaseline windows before it.
+	// Zero disables the anomaly check.
+	AnomalyWindow time.Duration
+	// AnomalySensitivity is how many standard deviations from its baseline
+	// a rate must be to be reported. Zero takes DefaultAnomalySensitivity.
+	AnomalySensitivity float64
+
+	mu      sync.Mutex
+	started bool
+	// cursor is the last record scanned for config drift.
+	cursor     int64
+	lastConfig map[string]Record
+	degraded   map[string]string
+	offline    map[string]bool
+	lowScore   bool
+	anomalous  map[string]string
+}
+
+// Check returns the events for conditions that started since the previous
+// check. Drift is detected only in records appended after the first check.
+func (m *AlertMonitor) Check(now time.Time) ([]WebhookEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.started {
+		if err := m.Ledger.db.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM ledger_records`).Scan(&m.cursor); err != nil {
+			return nil, err
+		}
+		m.lastConfig = map[string]Record{}
+		m.degraded = map[string]string{}
+		m.offline = map[string]bool{}
+		m.anomalous = map[string]string{}
+		m.started = true
+	}
+
+	var events []WebhookEvent
+	emit := func(eventType string, data interface{}) {
+		events = append(events, WebhookEvent{EventType: eventType, Severity: EventSeverity(eventType), Timestamp: now, Data: data})
+	}
+
+	drifts, err := m.checkDrift()
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range drifts {
+		emit(EventDriftDetected, d)
+	}
+
+	report, err := m.Ledger.SourceStatus(now.Unix(), DefaultNewSourceWindow)
+	if err != nil {
+		return nil, err
+	}
+	for _, src := range report.Sources {
+		if !src.Registered {
+			continue
+		}
+		if src.Status != SourceOverdue && src.Status != SourceMissing {
+			delete(m.degraded, src.Name)
+			continue
+		}
+		if m.degraded[src.Name] != src.Status {
+			m.degraded[src.Name] = src.Status
+			emit(EventCoverageDegraded, src)
+		}
+	}
+
+	if m.AgentOfflineAfter > 0 {
+		agents, err := m.Ledger.Agents()
+		if err != nil {
+			return nil, err
+		}
+		cutoff := now.Add(-m.AgentOfflineAfter).Unix()
+		for _, a := range agents {
+			if a.LastSeen >= cutoff {
+				delete(m.offline, a.ID)
+				continue
+			}
+			if !m.offline[a.ID] {
+				m.offline[a.ID] = true
+				emit(EventAgentOffline, a)
+			}
+		}
+	}
+
+	threshold := m.MinDeterminismScore
+	if threshold == 0 {
+		if p, err := m.Ledger.Policy(PolicyDeterminism, now.Unix()); err == nil {
+			threshold = p.Determinism.MinScore
+		}
+	}
+	if threshold > 0 {
+		report := New(m.Ledger).ReconstructAtTime(now.Unix())
+		low := report.DeterminismScore < threshold
+		if low && !m.lowScore {
+			emit(EventDeterminismLow, map[string]interface{}{
+				"score":     report.DeterminismScore,
+				"threshold": threshold,
+				"coverage":  report.Coverage,
+				"issues":    report.Issues,
+			})
+		}
+		m.lowScore = low
+	}
+
+	if m.AnomalyWindow > 0 {
+		anomalies, err := m.Ledger.MutationRateAnomalies(now, m.AnomalyWindow, DefaultAnomalyBaseline, m.AnomalySensitivity)
+		if err != nil {
+			return nil, err
+		}
+		current := map[string]string{}
+		for _, a := range anomalies {
+			current[a.Key()] = a.Direction
+			if m.anomalous[a.Key()] == a.Direction {
+				continue
+			}
+			recorded, err := m.Ledger.RecordAnomaly(a)
+			if err != nil {
+				return nil, err
+			}
+			emit(EventMutationAnomaly, recorded)
+		}
+		m.anomalous = current
+	}
+	return events, nil
+}
+
+// checkDrift diffs each config record appended since the cursor against the
+// previous snapshot of the same source.
+func (m *AlertMonitor) checkDrift() ([]ConfigDiff, error) {
+	var drifts []ConfigDiff
+	for {
+		records, err := m.Ledger.recordsAfter(m.cursor, 500)
+		if err != nil {
+			return nil, err
+		}
+		if len(records) == 0 {
+			return drifts, nil
+		}
+		for _, rec := range records {
+			m.cursor = rec.ID
+			if rec.Type != "config" {
+				continue
+			}
+			var cp collectors.ConfigPayload
+			if err := collectors.ParseJSON(rec.Payload, &cp); err != nil {
+				continue
+			}
+
+			prev, ok := m.lastConfig[cp.Source]
+			if !ok {
+				prev, ok, err = m.Ledger.previousConfig(cp.Source, rec.ID)
+				if err != nil {
+					return nil, err
+				}
+			}
+			m.lastConfig[cp.Source] = rec
+			if !ok {
+				continue
+			}
+			diff, err := diffConfigs(prev, rec, true)
+			if err != nil {
+				return nil, err
+			}
+			if diff.Drifted() {
+				drifts = append(drifts, diff)
+			}
+		}
+	}
+}
+
+// previousConfig returns the latest config record of source before id.
+func (l *Ledger) previousConfig(source string, before int64) (Record, bool, error) {
+	rec, err := scanRecord(l.db.QueryRow(`SELECT id, ts, type, source, payload, hash, prev_hash FROM ledger_records
+		WHERE type = 'config' AND id < ? AND CASE WHEN json_valid(payload) THEN json_extract(payload, '$.source') END = ? ORDER BY id DESC LIMIT 1`, before, source))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Record{}, false, nil
+	}
+	return rec, err == nil, err
+}
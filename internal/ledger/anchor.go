@@ -0,0 +1,182 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Retr0-XD/StateLedger/internal/collectors"
+)
+
+// AnchorRecordType is the type of the records holding anchor receipts,
+// with the witness location as source.
+const AnchorRecordType = "anchor.receipt"
+
+// AnchorHead is a chain head as published to a witness.
+type AnchorHead struct {
+	ID   int64  `json:"id"`
+	Hash string `json:"hash"`
+	// Time is when the head was anchored, in unix seconds.
+	Time int64 `json:"time"`
+}
+
+// Witness keeps a copy of published chain heads outside the ledger
+// database, so a rewritten history no longer matches what was published.
+type Witness interface {
+	// Location identifies the witness. OpenWitness resolves it back to
+	// the witness.
+	Location() string
+	// Publish hands head to the witness and returns its receipt.
+	Publish(ctx context.Context, head AnchorHead) (json.RawMessage, error)
+}
+
+// WitnessChecker is implemented by witnesses that can confirm a receipt
+// they issued still matches the head they were given.
+type WitnessChecker interface {
+	Check(ctx context.Context, r AnchorReceipt) error
+}
+
+// AnchorReceipt records that the head HeadID, HeadHash was published to
+// Witness. ID is the ID of the receipt record.
+type AnchorReceipt struct {
+	ID         int64           `json:"id"`
+	Witness    string          `json:"witness"`
+	HeadID     int64           `json:"head_id"`
+	HeadHash   string          `json:"head_hash"`
+	AnchoredAt int64           `json:"anchored_at"`
+	Receipt    json.RawMessage `json:"receipt"`
+}
+
+// AnchorCheck is the outcome of checking one receipt.
+type AnchorCheck struct {
+	Receipt AnchorReceipt `json:"receipt"`
+	OK      bool          `json:"ok"`
+	// Witnessed is set when the witness confirmed the receipt as well as
+	// the local chain.
+	Witnessed bool   `json:"witnessed"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+type anchorRecord struct {
+	HeadID   int64           `json:"head_id"`
+	HeadHash string          `json:"head_hash"`
+	Receipt  json.RawMessage `json:"receipt"`
+}
+
+// Anchor publishes the chain head to each witness and appends the
+// receipts, one record per witness, in a single batch. Witnesses that fail
+// are reported in the error while the receipts of the others are still
+// recorded. Nothing is published when the head is itself a receipt, so an
+// idle ledger is anchored once.
+func (l *Ledger) Anchor(ctx context.Context, witnesses ...Witness) ([]AnchorReceipt, error) {
+	head, err := scanRecord(l.db.QueryRow(`SELECT id, ts, type, source, payload, hash, prev_hash FROM ledger_records ORDER BY id DESC LIMIT 1`))
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && head.Type == AnchorRecordType) {
+		return []AnchorReceipt{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	receipts := []AnchorReceipt{}
+	var inputs []RecordInput
+	var errs []error
+	for _, w := range witnesses {
+		receipt, err := w.Publish(ctx, AnchorHead{ID: head.ID, Hash: head.Hash, Time: now})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("anchor to %s: %w", w.Location(), err))
+			continue
+		}
+		payload, err := collectors.MarshalPayload(anchorRecord{HeadID: head.ID, HeadHash: head.Hash, Receipt: receipt})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("anchor to %s: %w", w.Location(), err))
+			continue
+		}
+		receipts = append(receipts, AnchorReceipt{Witness: w.Location(), HeadID: head.ID, HeadHash: head.Hash, AnchoredAt: now, Receipt: receipt})
+		inputs = append(inputs, RecordInput{Timestamp: now, Type: AnchorRecordType, Source: w.Location(), Payload: payload})
+	}
+	if len(inputs) > 0 {
+		records, err := l.AppendBatch(inputs)
+		if err != nil {
+			return nil, errors.Join(append(errs, err)...)
+		}
+		for i, rec := range records {
+			receipts[i].ID = rec.ID
+		}
+	}
+	return receipts, errors.Join(errs...)
+}
+
+// Anchors lists the anchor receipts recorded in the ledger, oldest first.
+func (l *Ledger) Anchors() ([]AnchorReceipt, error) {
+	receipts := []AnchorReceipt{}
+	var after int64
+	for {
+		recs, err := l.List(ListQuery{Types: []string{AnchorRecordType}, AfterID: after, Limit: 1000})
+		if err != nil {
+			return nil, err
+		}
+		for _, rec := range recs {
+			var payload anchorRecord
+			if err := json.Unmarshal([]byte(rec.Payload), &payload); err != nil {
+				return nil, fmt.Errorf("anchor receipt %d: %w", rec.ID, err)
+			}
+			receipts = append(receipts, AnchorReceipt{
+				ID:         rec.ID,
+				Witness:    rec.Source,
+				HeadID:     payload.HeadID,
+				HeadHash:   payload.HeadHash,
+				AnchoredAt: rec.Timestamp,
+				Receipt:    payload.Receipt,
+			})
+			after = rec.ID
+		}
+		if len(recs) < 1000 {
+			return receipts, nil
+		}
+	}
+}
+
+// VerifyAnchors checks every receipt against the chain: the anchored
+// record must still carry the anchored hash. Receipts of witnesses that
+// implement WitnessChecker are also confirmed with the witness.
+func (l *Ledger) VerifyAnchors(ctx context.Context) ([]AnchorCheck, error) {
+	receipts, err := l.Anchors()
+	if err != nil {
+		return nil, err
+	}
+	checks := make([]AnchorCheck, 0, len(receipts))
+	for _, r := range receipts {
+		check := AnchorCheck{Receipt: r}
+		rec, err := l.GetByID(r.HeadID)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			check.Reason = fmt.Sprintf("anchored record %d is missing", r.HeadID)
+		case err != nil:
+			return nil, err
+		case rec.Hash != r.HeadHash:
+			check.Reason = fmt.Sprintf("record %d hash %s does not match anchored hash %s", r.HeadID, rec.Hash, r.HeadHash)
+		default:
+			check.OK = true
+		}
+		if check.OK {
+			w, err := OpenWitness(r.Witness)
+			if err != nil {
+				return nil, fmt.Errorf("anchor receipt %d: %w", r.ID, err)
+			}
+			if c, ok := w.(WitnessChecker); ok {
+				if err := c.Check(ctx, r); err != nil {
+					check.OK = false
+					check.Reason = err.Error()
+				} else {
+					check.Witnessed = true
+				}
+			}
+		}
+		checks = append(checks, check)
+	}
+	return checks, nil
+}
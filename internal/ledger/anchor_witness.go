@@ -0,0 +1,328 @@
+package ledger
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// OpenWitness resolves a witness location. Supported forms are
+// https://host/path (the head is POSTed as JSON), s3://bucket/prefix (the
+// head is written as an object) and rfc3161+https://host/path (the head
+// hash is timestamped by an RFC 3161 timestamp authority).
+func OpenWitness(location string) (Witness, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("witness %q: %w", location, err)
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return &HTTPSWitness{URL: location}, nil
+	case "s3":
+		store, err := NewS3ArchiveStore(u.Host, strings.Trim(u.Path, "/"))
+		if err != nil {
+			return nil, err
+		}
+		return &S3Witness{Store: store}, nil
+	case "rfc3161+http", "rfc3161+https":
+		return &TimestampWitness{URL: strings.TrimPrefix(location, "rfc3161+")}, nil
+	default:
+		return nil, fmt.Errorf("unsupported witness %q", location)
+	}
+}
+
+func witnessClient(c *http.Client) *http.Client {
+	if c != nil {
+		return c
+	}
+	return &http.Client{Timeout: 30 * time.Second}
+}
+
+// HTTPSWitness POSTs each head as JSON to URL. Any 2xx response is
+// accepted, and its body becomes the receipt.
+type HTTPSWitness struct {
+	URL    string
+	Client *http.Client
+}
+
+type httpsReceipt struct {
+	Status   int    `json:"status"`
+	Response string `json:"response,omitempty"`
+}
+
+func (w *HTTPSWitness) Location() string {
+	return w.URL
+}
+
+func (w *HTTPSWitness) Publish(ctx context.Context, head AnchorHead) (json.RawMessage, error) {
+	body, err := json.Marshal(head)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := witnessClient(w.Client).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.Marshal(httpsReceipt{Status: resp.StatusCode, Response: string(msg)})
+}
+
+// S3Witness writes each head to Store as anchor-<head id>.json. Objects
+// are never overwritten; with Object Lock default retention on the bucket
+// they cannot be deleted either.
+type S3Witness struct {
+	Store *S3ArchiveStore
+}
+
+type s3Receipt struct {
+	Object    string `json:"object"`
+	ETag      string `json:"etag,omitempty"`
+	VersionID string `json:"version_id,omitempty"`
+}
+
+func (w *S3Witness) Location() string {
+	return w.Store.Location()
+}
+
+func anchorObjectName(headID int64) string {
+	return fmt.Sprintf("anchor-%020d.json", headID)
+}
+
+func (w *S3Witness) Publish(ctx context.Context, head AnchorHead) (json.RawMessage, error) {
+	body, err := json.Marshal(head)
+	if err != nil {
+		return nil, err
+	}
+	name := anchorObjectName(head.ID)
+	resp, err := w.Store.do(http.MethodPut, name, body, map[string]string{"If-None-Match": "*"})
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return json.Marshal(s3Receipt{Object: name, ETag: resp.Header.Get("ETag"), VersionID: resp.Header.Get("X-Amz-Version-Id")})
+}
+
+// Check fetches the object of r and compares its head with r.
+func (w *S3Witness) Check(ctx context.Context, r AnchorReceipt) error {
+	var receipt s3Receipt
+	if err := json.Unmarshal(r.Receipt, &receipt); err != nil {
+		return fmt.Errorf("invalid s3 receipt: %w", err)
+	}
+	data, err := w.Store.Get(receipt.Object)
+	if err != nil {
+		return err
+	}
+	var head AnchorHead
+	if err := json.Unmarshal(data, &head); err != nil {
+		return fmt.Errorf("witness object %s: %w", receipt.Object, err)
+	}
+	if head.ID != r.HeadID || head.Hash != r.HeadHash {
+		return fmt.Errorf("witness object %s holds head %d %s", receipt.Object, head.ID, head.Hash)
+	}
+	return nil
+}
+
+// TimestampWitness has the head hash timestamped by the RFC 3161 timestamp
+// authority at URL. The receipt holds the signed timestamp token, which
+// can be checked independently, e.g. with `openssl ts -verify -digest
+// <head hash> -in token.tsr -token_in -CAfile tsa.pem`.
+type TimestampWitness struct {
+	URL    string
+	Client *http.Client
+}
+
+type timestampReceipt struct {
+	// Token is the DER-encoded TimeStampToken.
+	Token   []byte    `json:"token"`
+	GenTime time.Time `json:"gen_time"`
+	Serial  string    `json:"serial"`
+}
+
+func (w *TimestampWitness) Location() string {
+	return "rfc3161+" + w.URL
+}
+
+var (
+	oidSHA256     = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidTSTInfo    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+	oidSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+)
+
+type tsMessageImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+type tsRequest struct {
+	Version        int
+	MessageImprint tsMessageImprint
+	Nonce          *big.Int `asn1:"optional"`
+	CertReq        bool     `asn1:"optional"`
+}
+
+type tsStatusInfo struct {
+	Status       int
+	StatusString []string       `asn1:"optional,utf8"`
+	FailInfo     asn1.BitString `asn1:"optional"`
+}
+
+type tsResponse struct {
+	Status tsStatusInfo
+	Token  asn1.RawValue `asn1:"optional"`
+}
+
+type tsContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"tag:0,explicit,optional"`
+}
+
+type tsSignedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	EncapContentInfo tsContentInfo
+}
+
+type tsAccuracy struct {
+	Seconds int `asn1:"optional"`
+	Millis  int `asn1:"tag:0,optional"`
+	Micros  int `asn1:"tag:1,optional"`
+}
+
+type tsTSTInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint tsMessageImprint
+	SerialNumber   *big.Int
+	GenTime        time.Time  `asn1:"generalized"`
+	Accuracy       tsAccuracy `asn1:"optional"`
+	Ordering       bool       `asn1:"optional"`
+	Nonce          *big.Int   `asn1:"optional"`
+}
+
+func (w *TimestampWitness) Publish(ctx context.Context, head AnchorHead) (json.RawMessage, error) {
+	digest, err := hex.DecodeString(head.Hash)
+	if err != nil {
+		return nil, fmt.Errorf("head hash: %w", err)
+	}
+	nonce, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, err
+	}
+	body, err := asn1.Marshal(tsRequest{
+		Version: 1,
+		MessageImprint: tsMessageImprint{
+			HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue},
+			HashedMessage: digest,
+		},
+		Nonce:   nonce,
+		CertReq: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/timestamp-query")
+
+	resp, err := witnessClient(w.Client).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+
+	var tsr tsResponse
+	if _, err := asn1.Unmarshal(data, &tsr); err != nil {
+		return nil, fmt.Errorf("invalid timestamp response: %w", err)
+	}
+	// 0 is granted, 1 granted with modifications.
+	if tsr.Status.Status > 1 {
+		return nil, fmt.Errorf("timestamp refused (status %d): %s", tsr.Status.Status, strings.Join(tsr.Status.StatusString, "; "))
+	}
+	info, err := parseTimestampToken(tsr.Token.FullBytes)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(info.MessageImprint.HashedMessage, digest) {
+		return nil, errors.New("timestamp token is for a different hash")
+	}
+	if info.Nonce == nil || info.Nonce.Cmp(nonce) != 0 {
+		return nil, errors.New("timestamp token nonce does not match the request")
+	}
+	return json.Marshal(timestampReceipt{Token: tsr.Token.FullBytes, GenTime: info.GenTime.UTC(), Serial: info.SerialNumber.String()})
+}
+
+// Check confirms that the token of r timestamps the anchored hash. It does
+// not verify the authority's signature.
+func (w *TimestampWitness) Check(ctx context.Context, r AnchorReceipt) error {
+	var receipt timestampReceipt
+	if err := json.Unmarshal(r.Receipt, &receipt); err != nil {
+		return fmt.Errorf("invalid timestamp receipt: %w", err)
+	}
+	info, err := parseTimestampToken(receipt.Token)
+	if err != nil {
+		return err
+	}
+	if hex.EncodeToString(info.MessageImprint.HashedMessage) != r.HeadHash {
+		return fmt.Errorf("timestamp token is for hash %x, not %s", info.MessageImprint.HashedMessage, r.HeadHash)
+	}
+	return nil
+}
+
+// parseTimestampToken extracts the TSTInfo from a DER TimeStampToken, a
+// CMS SignedData whose content is the TSTInfo.
+func parseTimestampToken(der []byte) (tsTSTInfo, error) {
+	var ci tsContentInfo
+	if _, err := asn1.Unmarshal(der, &ci); err != nil {
+		return tsTSTInfo{}, fmt.Errorf("invalid timestamp token: %w", err)
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return tsTSTInfo{}, fmt.Errorf("timestamp token is not SignedData: %v", ci.ContentType)
+	}
+	var sd tsSignedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return tsTSTInfo{}, fmt.Errorf("invalid timestamp token: %w", err)
+	}
+	if !sd.EncapContentInfo.ContentType.Equal(oidTSTInfo) {
+		return tsTSTInfo{}, errors.New("timestamp token carries no TSTInfo")
+	}
+	var content []byte
+	if _, err := asn1.Unmarshal(sd.EncapContentInfo.Content.Bytes, &content); err != nil {
+		return tsTSTInfo{}, fmt.Errorf("invalid timestamp token: %w", err)
+	}
+	var info tsTSTInfo
+	if _, err := asn1.Unmarshal(content, &info); err != nil {
+		return tsTSTInfo{}, fmt.Errorf("invalid TSTInfo: %w", err)
+	}
+	return info, nil
+}
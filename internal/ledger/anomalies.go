@@ -0,0 +1,159 @@
+package ledger
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/Retr0-XD/StateLedger/internal/collectors"
+)
+
+// AnomalyRecordType is the record type under which detected mutation rate
+// anomalies are appended, with the mutation source as source, so a
+// reconstruction of an incident shows when its traffic went quiet or
+// flooded in.
+const AnomalyRecordType = "anomaly"
+
+// Directions of a rate anomaly.
+const (
+	AnomalySurge = "surge"
+	AnomalyDrop  = "drop"
+)
+
+// Defaults of rate anomaly detection.
+const (
+	// DefaultAnomalyBaseline is the number of windows before the current
+	// one that make up the baseline rate.
+	DefaultAnomalyBaseline = 12
+	// DefaultAnomalySensitivity is how many standard deviations from the
+	// baseline mean a window's count must be to count as an anomaly.
+	DefaultAnomalySensitivity = 3.0
+)
+
+// RateAnomaly is a window in which the mutation records of one source and
+// mutation type deviated sharply from their baseline rate. RecordID and
+// Hash identify the anomaly record once it is appended.
+type RateAnomaly struct {
+	Source         string  `json:"source"`
+	MutationType   string  `json:"mutation_type"`
+	Direction      string  `json:"direction"`
+	Count          int     `json:"count"`
+	BaselineMean   float64 `json:"baseline_mean"`
+	BaselineStdDev float64 `json:"baseline_stddev"`
+	WindowStart    int64   `json:"window_start"`
+	WindowEnd      int64   `json:"window_end"`
+	RecordID       int64   `json:"record_id,omitempty"`
+	Hash           string  `json:"hash,omitempty"`
+}
+
+// Key identifies the source and mutation type of the anomaly.
+func (a RateAnomaly) Key() string {
+	return a.Source + "\x00" + a.MutationType
+}
+
+// MutationRateAnomalies compares the number of mutation records of each
+// source and mutation type in the window ending at now with the baseline
+// windows before it, and returns those that deviate by more than
+// sensitivity standard deviations, ordered by source and type:
+//   - a surge has at least twice the baseline mean
+//   - a drop has at most half of it
+//
+// Only sources and types with records in at least half of the baseline
+// windows are judged, so a new or sporadic source does not count as a
+// surge, nor a quiet one as a drop. Zero baseline or sensitivity take the
+// defaults.
+func (l *Ledger) MutationRateAnomalies(now time.Time, window time.Duration, baseline int, sensitivity float64) ([]RateAnomaly, error) {
+	if baseline <= 0 {
+		baseline = DefaultAnomalyBaseline
+	}
+	if sensitivity <= 0 {
+		sensitivity = DefaultAnomalySensitivity
+	}
+	width := max(int64(window/time.Second), 1)
+	end := now.Unix()
+	start := end - width*int64(baseline+1)
+
+	rows, err := l.readQuery(`SELECT source, COALESCE(CASE WHEN json_valid(payload) THEN json_extract(payload, '$.type') END, ''), ts
+		FROM ledger_records WHERE type = 'mutation' AND ts >= ? AND ts < ?`, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type key struct{ source, mutationType string }
+	counts := map[key][]int{}
+	for rows.Next() {
+		var k key
+		var ts int64
+		if err := rows.Scan(&k.source, &k.mutationType, &ts); err != nil {
+			return nil, err
+		}
+		if counts[k] == nil {
+			counts[k] = make([]int, baseline+1)
+		}
+		counts[k][(ts-start)/width]++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var anomalies []RateAnomaly
+	for k, windows := range counts {
+		past, current := windows[:baseline], windows[baseline]
+		active := 0
+		var sum float64
+		for _, n := range past {
+			if n > 0 {
+				active++
+			}
+			sum += float64(n)
+		}
+		if active*2 < baseline {
+			continue
+		}
+		mean := sum / float64(baseline)
+		var variance float64
+		for _, n := range past {
+			variance += (float64(n) - mean) * (float64(n) - mean)
+		}
+		stddev := math.Sqrt(variance / float64(baseline))
+
+		deviation := float64(current) - mean
+		var direction string
+		switch {
+		case deviation > sensitivity*stddev && float64(current) >= 2*mean:
+			direction = AnomalySurge
+		case -deviation > sensitivity*stddev && float64(current) <= mean/2:
+			direction = AnomalyDrop
+		default:
+			continue
+		}
+		anomalies = append(anomalies, RateAnomaly{
+			Source:         k.source,
+			MutationType:   k.mutationType,
+			Direction:      direction,
+			Count:          current,
+			BaselineMean:   math.Round(mean*100) / 100,
+			BaselineStdDev: math.Round(stddev*100) / 100,
+			WindowStart:    end - width,
+			WindowEnd:      end,
+		})
+	}
+	sort.Slice(anomalies, func(i, j int) bool { return anomalies[i].Key() < anomalies[j].Key() })
+	return anomalies, nil
+}
+
+// RecordAnomaly appends a as an anomaly record and returns it with the
+// record's ID and hash.
+func (l *Ledger) RecordAnomaly(a RateAnomaly) (RateAnomaly, error) {
+	payload, err := collectors.MarshalPayload(a)
+	if err != nil {
+		return RateAnomaly{}, err
+	}
+	rec, err := l.Append(RecordInput{Timestamp: a.WindowEnd, Type: AnomalyRecordType, Source: a.Source, Payload: payload})
+	if err != nil {
+		return RateAnomaly{}, err
+	}
+	a.RecordID, a.Hash = rec.ID, rec.Hash
+	return a, nil
+}
@@ -0,0 +1,98 @@
+package ledger
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// Backpressure is judged on the appends of the last appendLatencyWindow,
+// at most appendLatencySamples of them.
+const (
+	appendLatencyWindow  = 10 * time.Second
+	appendLatencySamples = 256
+)
+
+// AppendLatency reports how long appends through the ledger take, from the
+// call, including the wait for earlier appends, to the commit, measured
+// against the SLO set with SetAppendSLO.
+type AppendLatency struct {
+	SLO time.Duration `json:"slo_ns"`
+	// Appends and Breaches count the append calls since the ledger was
+	// opened, and those slower than the SLO.
+	Appends  int64 `json:"appends"`
+	Breaches int64 `json:"breaches"`
+	// Recent is the number of appends in the last 10 seconds and RecentP95
+	// their 95th percentile latency.
+	Recent    int           `json:"recent"`
+	RecentP95 time.Duration `json:"recent_p95_ns"`
+	// Behind is set while RecentP95 exceeds the SLO: storage is falling
+	// behind and producers should slow down.
+	Behind bool `json:"behind"`
+}
+
+type latencySample struct {
+	at time.Time
+	d  time.Duration
+}
+
+// appendLatency accumulates AppendLatency. recent is a ring of the last
+// appendLatencySamples appends.
+type appendLatency struct {
+	mu       sync.Mutex
+	slo      time.Duration
+	appends  int64
+	breaches int64
+	recent   [appendLatencySamples]latencySample
+	next     int
+}
+
+// SetAppendSLO sets the append latency objective. Zero, the default,
+// disables breach counting and backpressure.
+func (l *Ledger) SetAppendSLO(slo time.Duration) {
+	a := &l.latency
+	a.mu.Lock()
+	a.slo = max(slo, 0)
+	a.mu.Unlock()
+}
+
+// observeAppend records an append call that started at start. Append
+// methods defer it before taking writeMu.
+func (l *Ledger) observeAppend(start time.Time) {
+	now := time.Now()
+	d := now.Sub(start)
+	a := &l.latency
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.appends++
+	if a.slo > 0 && d > a.slo {
+		a.breaches++
+	}
+	a.recent[a.next] = latencySample{at: now, d: d}
+	a.next = (a.next + 1) % appendLatencySamples
+}
+
+// AppendLatency reports append latency and whether storage is falling
+// behind the SLO.
+func (l *Ledger) AppendLatency() AppendLatency {
+	a := &l.latency
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	out := AppendLatency{SLO: a.slo, Appends: a.appends, Breaches: a.breaches}
+
+	cutoff := time.Now().Add(-appendLatencyWindow)
+	var recent []time.Duration
+	for _, s := range a.recent {
+		if !s.at.IsZero() && s.at.After(cutoff) {
+			recent = append(recent, s.d)
+		}
+	}
+	if len(recent) == 0 {
+		return out
+	}
+	slices.Sort(recent)
+	out.Recent = len(recent)
+	out.RecentP95 = recent[(len(recent)*95+99)/100-1]
+	out.Behind = a.slo > 0 && out.RecentP95 > a.slo
+	return out
+}
@@ -0,0 +1,72 @@
+package ledger
+
+import "sync"
+
+// AppendCount is the number and payload size of appended records.
+type AppendCount struct {
+	Records      int64 `json:"records"`
+	PayloadBytes int64 `json:"payload_bytes"`
+}
+
+// AppendStats breaks down the records appended since the ledger was opened
+// by record type and by source. Records written by other processes sharing
+// the database are not counted.
+type AppendStats struct {
+	Total    AppendCount            `json:"total"`
+	ByType   map[string]AppendCount `json:"by_type"`
+	BySource map[string]AppendCount `json:"by_source"`
+}
+
+// appendCounters accumulates AppendStats as records are committed.
+type appendCounters struct {
+	mu       sync.Mutex
+	total    AppendCount
+	byType   map[string]AppendCount
+	bySource map[string]AppendCount
+}
+
+// countAppended adds records just committed on l to its append counters.
+func (l *Ledger) countAppended(records []Record) {
+	c := &l.appends
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.byType == nil {
+		c.byType = make(map[string]AppendCount)
+		c.bySource = make(map[string]AppendCount)
+	}
+	for _, rec := range records {
+		size := int64(len(rec.Payload))
+		c.total.Records++
+		c.total.PayloadBytes += size
+
+		t := c.byType[rec.Type]
+		t.Records++
+		t.PayloadBytes += size
+		c.byType[rec.Type] = t
+
+		s := c.bySource[rec.Source]
+		s.Records++
+		s.PayloadBytes += size
+		c.bySource[rec.Source] = s
+	}
+}
+
+// AppendStats reports the records and payload bytes appended through l,
+// per record type and per source.
+func (l *Ledger) AppendStats() AppendStats {
+	c := &l.appends
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := AppendStats{
+		Total:    c.total,
+		ByType:   make(map[string]AppendCount, len(c.byType)),
+		BySource: make(map[string]AppendCount, len(c.bySource)),
+	}
+	for k, v := range c.byType {
+		stats.ByType[k] = v
+	}
+	for k, v := range c.bySource {
+		stats.BySource[k] = v
+	}
+	return stats
+}
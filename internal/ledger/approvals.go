@@ -0,0 +1,404 @@
+package ledger
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Retr0-XD/StateLedger/internal/collectors"
+)
+
+const approvalSchema = `
+CREATE TABLE IF NOT EXISTS ledger_approvals (
+	id INTEGER PRIMARY KEY,
+	action TEXT NOT NULL,
+	params TEXT NOT NULL,
+	requested_by TEXT NOT NULL,
+	requested_at INTEGER NOT NULL,
+	expires_at INTEGER NOT NULL,
+	approved_by TEXT NOT NULL DEFAULT '',
+	approved_at INTEGER NOT NULL DEFAULT 0,
+	approval_id INTEGER NOT NULL DEFAULT 0,
+	executed_at INTEGER NOT NULL DEFAULT 0,
+	executed_by TEXT NOT NULL DEFAULT '',
+	execution_id INTEGER NOT NULL DEFAULT 0
+);
+`
+
+// Record types under which approval requests, approvals and executions
+// are appended, so the audit of administrative actions is part of the
+// hash chain.
+const (
+	AdminRequestRecordType   = "admin.request"
+	AdminApprovalRecordType  = "admin.approval"
+	AdminExecutionRecordType = "admin.execution"
+)
+
+// Administrative actions under two-person approval. Each removes or
+// rewrites records, and runs only with an approved request.
+const (
+	// AdminActionArchive archives and prunes records from the local
+	// database. Params: {"before": unix seconds, "to": archive location}.
+	AdminActionArchive = "archive"
+	// AdminActionRetention changes the retention settings of a running
+	// server. Params: the new retention settings.
+	AdminActionRetention = "retention"
+	// AdminActionPrune prunes records under a retention policy. Params:
+	// {"type": type pattern, "max_age": duration, "keep_last": count}, or
+	// {} for the retention policy in force.
+	AdminActionPrune = "prune"
+	// AdminActionRedact blanks the payload of a record. Params:
+	// {"id": record ID, "reason": reason}.
+	AdminActionRedact = "redact"
+	// AdminActionCompact compacts runs of identical captures. Params:
+	// {"types": capture types, "min_run": shortest run}.
+	AdminActionCompact = "compact"
+)
+
+// DefaultApprovalWindow is how long a request waits for approval when no
+// window is given.
+const DefaultApprovalWindow = time.Hour
+
+// Approval errors.
+var (
+	ErrApprovalNotFound = errors.New("approval request not found")
+	ErrApprovalExpired  = errors.New("approval request expired")
+	ErrAlreadyApproved  = errors.New("approval request already approved")
+	ErrSelfApproval     = errors.New("approval must come from a different key than the request")
+	ErrNotApproved      = errors.New("administrative action not approved")
+)
+
+// AdminRequest is a request for an administrative action and, once given,
+// its approval and execution. ID, ApprovalID and ExecutionID are the IDs
+// of the ledger records that made the request, approved it and used it up.
+type AdminRequest struct {
+	ID          int64           `json:"id"`
+	Action      string          `json:"action"`
+	Params      json.RawMessage `json:"params"`
+	RequestedBy string          `json:"requested_by"`
+	RequestedAt int64           `json:"requested_at"`
+	ExpiresAt   int64           `json:"expires_at"`
+	ApprovedBy  string          `json:"approved_by,omitempty"`
+	ApprovedAt  int64           `json:"approved_at,omitempty"`
+	ApprovalID  int64           `json:"approval_id,omitempty"`
+	ExecutedAt  int64           `json:"executed_at,omitempty"`
+	ExecutedBy  string          `json:"executed_by,omitempty"`
+	ExecutionID int64           `json:"execution_id,omitempty"`
+	// Status is pending, expired, approved or executed.
+	Status string `json:"status"`
+}
+
+type adminRequestRecord struct {
+	Action      string          `json:"action"`
+	Params      json.RawMessage `json:"params"`
+	RequestedBy string          `json:"requested_by"`
+	ExpiresAt   int64           `json:"expires_at"`
+}
+
+type adminApprovalRecord struct {
+	RequestID   int64  `json:"request_id"`
+	RequestHash string `json:"request_hash"`
+	Action      string `json:"action"`
+	ApprovedBy  string `json:"approved_by"`
+}
+
+type adminExecutionRecord struct {
+	RequestID    int64  `json:"request_id"`
+	ApprovalID   int64  `json:"approval_id"`
+	ApprovalHash string `json:"approval_hash"`
+	Action       string `json:"action"`
+	ExecutedBy   string `json:"executed_by"`
+}
+
+// RequestAdminAction records a request to perform action with params on
+// behalf of requestedBy, an identifier of the requesting key. The request
+// must be approved by a different key within window before the action can
+// be executed.
+func (l *Ledger) RequestAdminAction(action string, params json.RawMessage, requestedBy string, window time.Duration) (AdminRequest, error) {
+	switch action {
+	case AdminActionArchive, AdminActionRetention, AdminActionPrune, AdminActionRedact, AdminActionCompact:
+	default:
+		return AdminRequest{}, fmt.Errorf("unknown administrative action %q", action)
+	}
+	if strings.TrimSpace(requestedBy) == "" {
+		return AdminRequest{}, errors.New("requester required")
+	}
+	if len(params) == 0 {
+		params = json.RawMessage("{}")
+	}
+	canonical, err := collectors.CanonicalizeJSON(params)
+	if err != nil {
+		return AdminRequest{}, fmt.Errorf("params: %w", err)
+	}
+	if window <= 0 {
+		window = DefaultApprovalWindow
+	}
+	if err := l.ensureApprovalSchema(); err != nil {
+		return AdminRequest{}, err
+	}
+
+	now := time.Now()
+	req := AdminRequest{
+		Action:      action,
+		Params:      canonical,
+		RequestedBy: requestedBy,
+		RequestedAt: now.Unix(),
+		ExpiresAt:   now.Add(window).Unix(),
+		Status:      "pending",
+	}
+	payload, err := collectors.MarshalPayload(adminRequestRecord{Action: action, Params: canonical, RequestedBy: requestedBy, ExpiresAt: req.ExpiresAt})
+	if err != nil {
+		return AdminRequest{}, err
+	}
+
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+
+	tx, err := l.db.Begin()
+	if err != nil {
+		return AdminRequest{}, err
+	}
+	defer tx.Rollback()
+
+	records, err := l.appendBatchTx(tx, []RecordInput{{
+		Timestamp: req.RequestedAt,
+		Type:      AdminRequestRecordType,
+		Source:    action,
+		Payload:   payload,
+	}})
+	if err != nil {
+		return AdminRequest{}, err
+	}
+	req.ID = records[0].ID
+	if _, err := tx.Exec(`INSERT INTO ledger_approvals(id, action, params, requested_by, requested_at, expires_at) VALUES(?, ?, ?, ?, ?, ?)`,
+		req.ID, action, string(canonical), requestedBy, req.RequestedAt, req.ExpiresAt); err != nil {
+		return AdminRequest{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return AdminRequest{}, err
+	}
+	l.invalidateListCache()
+	l.countAppended(records)
+	l.mirrorAppended(records)
+	l.journalAppended(records)
+	return req, l.Sync()
+}
+
+// ApproveAdminAction approves the pending request id on behalf of
+// approvedBy, which must differ from the requester. The approval record
+// names the request record and its hash.
+func (l *Ledger) ApproveAdminAction(id int64, approvedBy string) (AdminRequest, error) {
+	if strings.TrimSpace(approvedBy) == "" {
+		return AdminRequest{}, errors.New("approver required")
+	}
+	if err := l.ensureApprovalSchema(); err != nil {
+		return AdminRequest{}, err
+	}
+
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+
+	tx, err := l.db.Begin()
+	if err != nil {
+		return AdminRequest{}, err
+	}
+	defer tx.Rollback()
+
+	req, err := scanAdminRequest(tx.QueryRow(selectApprovalSQL+` WHERE id = ?`, id))
+	if err != nil {
+		return AdminRequest{}, err
+	}
+	now := time.Now().Unix()
+	switch {
+	case req.ApprovedBy != "":
+		return AdminRequest{}, fmt.Errorf("%w: request %d", ErrAlreadyApproved, id)
+	case now > req.ExpiresAt:
+		return AdminRequest{}, fmt.Errorf("%w: request %d", ErrApprovalExpired, id)
+	case approvedBy == req.RequestedBy:
+		return AdminRequest{}, ErrSelfApproval
+	}
+
+	var requestHash string
+	if err := tx.QueryRow(`SELECT hash FROM ledger_records WHERE id = ?`, id).Scan(&requestHash); err != nil {
+		return AdminRequest{}, err
+	}
+	payload, err := collectors.MarshalPayload(adminApprovalRecord{RequestID: id, RequestHash: requestHash, Action: req.Action, ApprovedBy: approvedBy})
+	if err != nil {
+		return AdminRequest{}, err
+	}
+	records, err := l.appendBatchTx(tx, []RecordInput{{
+		Timestamp: now,
+		Type:      AdminApprovalRecordType,
+		Source:    req.Action,
+		Payload:   payload,
+	}})
+	if err != nil {
+		return AdminRequest{}, err
+	}
+	if _, err := tx.Exec(`UPDATE ledger_approvals SET approved_by = ?, approved_at = ?, approval_id = ? WHERE id = ?`,
+		approvedBy, now, records[0].ID, id); err != nil {
+		return AdminRequest{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return AdminRequest{}, err
+	}
+	l.invalidateListCache()
+	l.countAppended(records)
+	l.mirrorAppended(records)
+	l.journalAppended(records)
+
+	req.ApprovedBy, req.ApprovedAt, req.ApprovalID = approvedBy, now, records[0].ID
+	req.Status = "approved"
+	return req, l.Sync()
+}
+
+// ConsumeApproval marks the approved request id as executed by
+// executedBy so it cannot authorize the action again, and appends an
+// execution record naming the approval record and its hash in the same
+// transaction. It fails with ErrNotApproved unless id is an approved,
+// unexecuted request for action whose params match accepts.
+func (l *Ledger) ConsumeApproval(id int64, action, executedBy string, match func(params json.RawMessage) bool) (AdminRequest, error) {
+	if strings.TrimSpace(executedBy) == "" {
+		return AdminRequest{}, errors.New("executor required")
+	}
+	if err := l.ensureApprovalSchema(); err != nil {
+		return AdminRequest{}, err
+	}
+
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+
+	tx, err := l.db.Begin()
+	if err != nil {
+		return AdminRequest{}, err
+	}
+	defer tx.Rollback()
+
+	req, err := scanAdminRequest(tx.QueryRow(selectApprovalSQL+` WHERE id = ?`, id))
+	if err != nil {
+		return AdminRequest{}, err
+	}
+	switch {
+	case req.Action != action:
+		return AdminRequest{}, fmt.Errorf("%w: request %d is for %s, not %s", ErrNotApproved, id, req.Action, action)
+	case req.ApprovedBy == "":
+		return AdminRequest{}, fmt.Errorf("%w: request %d is %s", ErrNotApproved, id, req.Status)
+	case req.ExecutedAt != 0:
+		return AdminRequest{}, fmt.Errorf("%w: request %d was already executed", ErrNotApproved, id)
+	case match != nil && !match(req.Params):
+		return AdminRequest{}, fmt.Errorf("%w: request %d was approved with different params: %s", ErrNotApproved, id, req.Params)
+	}
+
+	var approvalHash string
+	if err := tx.QueryRow(`SELECT hash FROM ledger_records WHERE id = ?`, req.ApprovalID).Scan(&approvalHash); err != nil {
+		return AdminRequest{}, fmt.Errorf("approval record %d: %w", req.ApprovalID, err)
+	}
+	payload, err := collectors.MarshalPayload(adminExecutionRecord{RequestID: id, ApprovalID: req.ApprovalID, ApprovalHash: approvalHash, Action: action, ExecutedBy: executedBy})
+	if err != nil {
+		return AdminRequest{}, err
+	}
+	now := time.Now().Unix()
+	records, err := l.appendBatchTx(tx, []RecordInput{{
+		Timestamp: now,
+		Type:      AdminExecutionRecordType,
+		Source:    action,
+		Payload:   payload,
+	}})
+	if err != nil {
+		return AdminRequest{}, err
+	}
+	res, err := tx.Exec(`UPDATE ledger_approvals SET executed_at = ?, executed_by = ?, execution_id = ? WHERE id = ? AND executed_at = 0`,
+		now, executedBy, records[0].ID, id)
+	if err != nil {
+		return AdminRequest{}, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return AdminRequest{}, fmt.Errorf("%w: request %d was already executed", ErrNotApproved, id)
+	}
+	if err := tx.Commit(); err != nil {
+		return AdminRequest{}, err
+	}
+	l.invalidateListCache()
+	l.countAppended(records)
+	l.mirrorAppended(records)
+	l.journalAppended(records)
+
+	req.ExecutedAt, req.ExecutedBy, req.ExecutionID = now, executedBy, records[0].ID
+	req.Status = "executed"
+	return req, l.Sync()
+}
+
+// AdminRequests lists the administrative requests, newest first.
+func (l *Ledger) AdminRequests() ([]AdminRequest, error) {
+	if err := l.ensureApprovalSchema(); err != nil {
+		return nil, err
+	}
+	rows, err := l.db.Query(selectApprovalSQL + ` ORDER BY id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []AdminRequest{}
+	for rows.Next() {
+		req, err := scanAdminRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, req)
+	}
+	return out, rows.Err()
+}
+
+const selectApprovalSQL = `SELECT id, action, params, requested_by, requested_at, expires_at, approved_by, approved_at, approval_id, executed_at, executed_by, execution_id FROM ledger_approvals`
+
+func scanAdminRequest(row interface{ Scan(...any) error }) (AdminRequest, error) {
+	var req AdminRequest
+	var params string
+	err := row.Scan(&req.ID, &req.Action, &params, &req.RequestedBy, &req.RequestedAt, &req.ExpiresAt, &req.ApprovedBy, &req.ApprovedAt, &req.ApprovalID, &req.ExecutedAt, &req.ExecutedBy, &req.ExecutionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return AdminRequest{}, ErrApprovalNotFound
+	}
+	if err != nil {
+		return AdminRequest{}, err
+	}
+	req.Params = json.RawMessage(params)
+	switch {
+	case req.ExecutedAt != 0:
+		req.Status = "executed"
+	case req.ApprovedBy != "":
+		req.Status = "approved"
+	case time.Now().Unix() > req.ExpiresAt:
+		req.Status = "expired"
+	default:
+		req.Status = "pending"
+	}
+	return req, nil
+}
+
+func (l *Ledger) ensureApprovalSchema() error {
+	if l.approvalsReady.Load() {
+		return nil
+	}
+	if _, err := l.db.Exec(approvalSchema); err != nil {
+		return err
+	}
+	// Tables created before executions were recorded lack their columns.
+	for _, column := range []string{"executed_by TEXT NOT NULL DEFAULT ''", "execution_id INTEGER NOT NULL DEFAULT 0"} {
+		name, _, _ := strings.Cut(column, " ")
+		var n int
+		if err := l.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('ledger_approvals') WHERE name = ?`, name).Scan(&n); err != nil {
+			return err
+		}
+		if n == 0 {
+			if _, err := l.db.Exec(`ALTER TABLE ledger_approvals ADD COLUMN ` + column); err != nil {
+				return err
+			}
+		}
+	}
+	l.approvalsReady.Store(true)
+	return nil
+}
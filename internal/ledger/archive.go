@@ -0,0 +1,609 @@
+package ledger
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const archiveSchema = `
+CREATE TABLE IF NOT EXISTS ledger_archives (
+	name TEXT PRIMARY KEY,
+	location TEXT NOT NULL,
+	first_id INTEGER NOT NULL,
+	last_id INTEGER NOT NULL,
+	min_ts INTEGER NOT NULL,
+	max_ts INTEGER NOT NULL,
+	count INTEGER NOT NULL,
+	prev_hash TEXT NOT NULL,
+	last_hash TEXT NOT NULL,
+	created_at INTEGER NOT NULL
+);
+`
+
+const (
+	archiveSegmentVersion     = 1
+	defaultArchiveSegmentSize = 10000
+)
+
+// ArchiveSegment is a contiguous run of records exported to an archive
+// store. PrevHash links the segment to the records before it and LastHash
+// to the ones after, so segments and the local chain verify as one chain.
+type ArchiveSegment struct {
+	Version   int      `json:"version"`
+	FirstID   int64    `json:"first_id"`
+	LastID    int64    `json:"last_id"`
+	Count     int      `json:"count"`
+	PrevHash  string   `json:"prev_hash"`
+	LastHash  string   `json:"last_hash"`
+	CreatedAt int64    `json:"created_at"`
+	Records   []Record `json:"records"`
+	// KeyID identifies the key that signed the segment. Segments written
+	// before key versioning have none.
+	KeyID string `json:"key_id,omitempty"`
+	// Signature is an HMAC-SHA256 over the segment header. Because every
+	// record hash chains into LastHash, it authenticates the whole segment.
+	Signature string `json:"signature"`
+}
+
+// ArchiveEntry is the local index entry for an archived segment.
+type ArchiveEntry struct {
+	Name      string `json:"name"`
+	Location  string `json:"location"`
+	FirstID   int64  `json:"first_id"`
+	LastID    int64  `json:"last_id"`
+	MinTS     int64  `json:"min_ts"`
+	MaxTS     int64  `json:"max_ts"`
+	Count     int64  `json:"count"`
+	PrevHash  string `json:"prev_hash"`
+	LastHash  string `json:"last_hash"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// ArchiveOptions controls Archive.
+type ArchiveOptions struct {
+	// Before archives records with a timestamp strictly earlier than this
+	// unix time. Archiving stops at the first newer record so the archived
+	// range is always a prefix of the chain.
+	Before int64
+	// SegmentSize is the maximum number of records per segment.
+	SegmentSize int
+	// Key signs each segment. Required.
+	Key []byte
+}
+
+// ArchiveResult summarizes an Archive run.
+type ArchiveResult struct {
+	Location string         `json:"location"`
+	Archived int64          `json:"archived"`
+	Segments []ArchiveEntry `json:"segments"`
+	// HeldBy is the case of the legal hold that stopped archiving short of
+	// the cutoff.
+	HeldBy string `json:"held_by,omitempty"`
+}
+
+// SetArchiveKey sets the key used to check archive segment signatures when
+// archived records are read back, and the retired keys that signed
+// segments before the archive key was rotated. Without keys, segments are
+// still verified against their hash chain and the local index.
+func (l *Ledger) SetArchiveKey(key []byte, retired ...[]byte) {
+	l.archiveMu.Lock()
+	defer l.archiveMu.Unlock()
+	l.archiveKey = append([]byte(nil), key...)
+	l.archiveRetired = nil
+	for _, k := range retired {
+		l.archiveRetired = append(l.archiveRetired, append([]byte(nil), k...))
+	}
+}
+
+// Archive exports records older than opts.Before to store as signed,
+// hash-continuous segments and prunes them from the local database. Pruned
+// records remain readable: GetByID, List, VerifyChain, VerifyUpTo and
+// ResolveSnapshotAt fetch archived segments transparently. Archiving stops
+// at the first record under an active legal hold.
+func (l *Ledger) Archive(store ArchiveStore, opts ArchiveOptions) (ArchiveResult, error) {
+	if store == nil {
+		return ArchiveResult{}, errors.New("archive store required")
+	}
+	if len(opts.Key) == 0 {
+		return ArchiveResult{}, errors.New("archive signing key required")
+	}
+	if opts.Before <= 0 {
+		return ArchiveResult{}, errors.New("archive cutoff required")
+	}
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = defaultArchiveSegmentSize
+	}
+	if err := l.checkCurrentKey(KeyArchive, opts.Key); err != nil {
+		return ArchiveResult{}, err
+	}
+	if _, err := l.db.Exec(archiveSchema); err != nil {
+		return ArchiveResult{}, err
+	}
+
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+
+	holds, err := l.activeHolds()
+	if err != nil {
+		return ArchiveResult{}, err
+	}
+
+	result := ArchiveResult{Location: store.Location()}
+	for {
+		recs, done, heldBy, err := l.archiveCandidates(opts.Before, opts.SegmentSize, holds)
+		if err != nil {
+			return result, err
+		}
+		result.HeldBy = heldBy
+		if len(recs) == 0 {
+			break
+		}
+
+		entry, err := l.archiveSegment(store, recs, opts.Key)
+		if err != nil {
+			return result, err
+		}
+		result.Segments = append(result.Segments, entry)
+		result.Archived += entry.Count
+
+		if done {
+			break
+		}
+	}
+
+	if result.Archived > 0 {
+		l.invalidateListCache()
+	}
+	return result, nil
+}
+
+// archiveCandidates returns the next run of up to limit records, starting
+// at the oldest local record, whose timestamps precede before. A run ends
+// at a range removed by Prune, so segments never span one. done reports
+// that a newer record, a record under one of holds (whose case is heldBy)
+// or the end of the chain was reached.
+func (l *Ledger) archiveCandidates(before int64, limit int, holds []LegalHold) (recs []Record, done bool, heldBy string, err error) {
+	rows, err := l.db.Query(`SELECT id, ts, type, source, payload, hash, prev_hash FROM ledger_records ORDER BY id ASC LIMIT ?`, limit+1)
+	if err != nil {
+		return nil, false, "", err
+	}
+	defer rows.Close()
+
+	var out []Record
+	for rows.Next() {
+		var rec Record
+		if err := rows.Scan(&rec.ID, &rec.Timestamp, &rec.Type, &rec.Source, &rec.Payload, &rec.Hash, &rec.PrevHash); err != nil {
+			return nil, false, "", err
+		}
+		if rec.Timestamp >= before {
+			return out, true, "", rows.Err()
+		}
+		if h, held := holdCovering(holds, rec.Timestamp); held {
+			return out, true, h.Case, rows.Err()
+		}
+		if len(out) == limit || (len(out) > 0 && rec.ID != out[len(out)-1].ID+1) {
+			return out, false, "", rows.Err()
+		}
+		out = append(out, rec)
+	}
+	return out, true, "", rows.Err()
+}
+
+func (l *Ledger) archiveSegment(store ArchiveStore, recs []Record, key []byte) (ArchiveEntry, error) {
+	if err := l.attachRedactions(replicaQueryer{l}, recs); err != nil {
+		return ArchiveEntry{}, err
+	}
+	if err := l.attachSeqs(replicaQueryer{l}, recs); err != nil {
+		return ArchiveEntry{}, err
+	}
+	if err := l.attachUIDs(replicaQueryer{l}, recs); err != nil {
+		return ArchiveEntry{}, err
+	}
+	if err := l.attachLabels(replicaQueryer{l}, recs); err != nil {
+		return ArchiveEntry{}, err
+	}
+	first, last := recs[0], recs[len(recs)-1]
+
+	// Refuse to archive a range whose chain is already broken; the archive
+	// would otherwise preserve the damage behind a valid signature.
+	prev := first.PrevHash
+	for _, rec := range recs {
+		if reason := checkChainLink(prev, rec); reason != "" {
+			return ArchiveEntry{}, fmt.Errorf("record %d: %s", rec.ID, reason)
+		}
+		prev = rec.Hash
+	}
+
+	seg := ArchiveSegment{
+		Version:   archiveSegmentVersion,
+		FirstID:   first.ID,
+		LastID:    last.ID,
+		Count:     len(recs),
+		PrevHash:  first.PrevHash,
+		LastHash:  last.Hash,
+		CreatedAt: time.Now().Unix(),
+		Records:   recs,
+		KeyID:     KeyID(key),
+	}
+	seg.Signature = seg.sign(key)
+
+	data, err := json.Marshal(seg)
+	if err != nil {
+		return ArchiveEntry{}, err
+	}
+
+	entry := ArchiveEntry{
+		Name:      fmt.Sprintf("segment-%020d-%020d.json", first.ID, last.ID),
+		Location:  store.Location(),
+		FirstID:   first.ID,
+		LastID:    last.ID,
+		MinTS:     first.Timestamp,
+		MaxTS:     first.Timestamp,
+		Count:     int64(len(recs)),
+		PrevHash:  seg.PrevHash,
+		LastHash:  seg.LastHash,
+		CreatedAt: seg.CreatedAt,
+	}
+	for _, rec := range recs {
+		entry.MinTS = min(entry.MinTS, rec.Timestamp)
+		entry.MaxTS = max(entry.MaxTS, rec.Timestamp)
+	}
+
+	if err := store.Put(entry.Name, data); err != nil {
+		return ArchiveEntry{}, fmt.Errorf("upload %s: %w", entry.Name, err)
+	}
+
+	tx, err := l.db.Begin()
+	if err != nil {
+		return ArchiveEntry{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`INSERT INTO ledger_archives(name, location, first_id, last_id, min_ts, max_ts, count, prev_hash, last_hash, created_at) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		entry.Name, entry.Location, entry.FirstID, entry.LastID, entry.MinTS, entry.MaxTS, entry.Count, entry.PrevHash, entry.LastHash, entry.CreatedAt)
+	if err != nil {
+		return ArchiveEntry{}, err
+	}
+	err = withRecordsUnlocked(tx, func() error {
+		_, err := tx.Exec(`DELETE FROM ledger_records WHERE id <= ?`, entry.LastID)
+		return err
+	})
+	if err != nil {
+		return ArchiveEntry{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return ArchiveEntry{}, err
+	}
+
+	l.archiveMu.Lock()
+	if l.archiveStores == nil {
+		l.archiveStores = map[string]ArchiveStore{}
+	}
+	l.archiveStores[entry.Location] = store
+	l.archiveMu.Unlock()
+
+	return entry, l.Sync()
+}
+
+func (s ArchiveSegment) sign(key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(strconv.Itoa(s.Version) + "|" +
+		strconv.FormatInt(s.FirstID, 10) + "|" +
+		strconv.FormatInt(s.LastID, 10) + "|" +
+		strconv.Itoa(s.Count) + "|" +
+		s.PrevHash + "|" + s.LastHash))
+	if s.KeyID != "" {
+		mac.Write([]byte("|" + s.KeyID))
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// checkSignature checks the signature with the configured key that signed
+// the segment. Segments without a key ID may match any configured key.
+func (s ArchiveSegment) checkSignature(keys [][]byte) error {
+	configured := false
+	for _, key := range keys {
+		if len(key) == 0 {
+			continue
+		}
+		configured = true
+		if s.KeyID != "" && KeyID(key) != s.KeyID {
+			continue
+		}
+		if hmac.Equal([]byte(s.sign(key)), []byte(s.Signature)) {
+			return nil
+		}
+	}
+	if !configured {
+		return nil
+	}
+	return errors.New("signature mismatch")
+}
+
+// Archives lists archived segments in chain order. It returns nil when the
+// ledger has never been archived.
+func (l *Ledger) Archives() ([]ArchiveEntry, error) {
+	rows, err := l.db.Query(`SELECT name, location, first_id, last_id, min_ts, max_ts, count, prev_hash, last_hash, created_at FROM ledger_archives ORDER BY first_id ASC`)
+	if err != nil {
+		if isMissingTable(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []ArchiveEntry
+	for rows.Next() {
+		var e ArchiveEntry
+		if err := rows.Scan(&e.Name, &e.Location, &e.FirstID, &e.LastID, &e.MinTS, &e.MaxTS, &e.Count, &e.PrevHash, &e.LastHash, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+func isMissingTable(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "no such table")
+}
+
+// archiveTip returns the hash of the last archived record, which is the
+// chain head once every local record has been pruned.
+func archiveTip(q interface {
+	QueryRow(query string, args ...any) *sql.Row
+}) (string, error) {
+	hash, err := scanHash(q.QueryRow(`SELECT last_hash FROM ledger_archives ORDER BY last_id DESC LIMIT 1`))
+	if isMissingTable(err) {
+		return "", nil
+	}
+	return hash, err
+}
+
+// loadSegment fetches, authenticates and caches the records of an archived
+// segment.
+func (l *Ledger) loadSegment(e ArchiveEntry) ([]Record, error) {
+	l.archiveMu.Lock()
+	if recs, ok := l.archiveSegments[e.Name]; ok {
+		l.archiveMu.Unlock()
+		return recs, nil
+	}
+	store := l.archiveStores[e.Location]
+	keys := append([][]byte{l.archiveKey}, l.archiveRetired...)
+	l.archiveMu.Unlock()
+
+	if store == nil {
+		var err error
+		if store, err = OpenArchiveStore(e.Location); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := store.Get(e.Name)
+	if err != nil {
+		return nil, fmt.Errorf("fetch archive segment %s: %w", e.Name, err)
+	}
+
+	var seg ArchiveSegment
+	if err := json.Unmarshal(data, &seg); err != nil {
+		return nil, fmt.Errorf("decode archive segment %s: %w", e.Name, err)
+	}
+	if err := seg.verify(e, keys); err != nil {
+		return nil, fmt.Errorf("archive segment %s: %w", e.Name, err)
+	}
+
+	l.archiveMu.Lock()
+	if l.archiveStores == nil {
+		l.archiveStores = map[string]ArchiveStore{}
+	}
+	if l.archiveSegments == nil {
+		l.archiveSegments = map[string][]Record{}
+	}
+	l.archiveStores[e.Location] = store
+	l.archiveSegments[e.Name] = seg.Records
+	l.archiveMu.Unlock()
+
+	return seg.Records, nil
+}
+
+func (s ArchiveSegment) verify(e ArchiveEntry, keys [][]byte) error {
+	if s.Version != archiveSegmentVersion {
+		return fmt.Errorf("unsupported segment version %d", s.Version)
+	}
+	if s.FirstID != e.FirstID || s.LastID != e.LastID || s.PrevHash != e.PrevHash || s.LastHash != e.LastHash || int64(s.Count) != e.Count {
+		return errors.New("segment does not match archive index")
+	}
+	if len(s.Records) != s.Count {
+		return errors.New("record count mismatch")
+	}
+	if err := s.checkSignature(keys); err != nil {
+		return err
+	}
+
+	prev := s.PrevHash
+	for _, rec := range s.Records {
+		if reason := checkChainLink(prev, rec); reason != "" {
+			return fmt.Errorf("record %d: %s", rec.ID, reason)
+		}
+		prev = rec.Hash
+	}
+	if prev != s.LastHash {
+		return errors.New("last hash mismatch")
+	}
+	return nil
+}
+
+// checkChainLink returns why rec does not extend a chain ending in prev, or
+// "" when it does.
+func checkChainLink(prev string, rec Record) string {
+	if rec.PrevHash != prev {
+		return "prev_hash mismatch"
+	}
+	if !hashMatches(prev, rec) {
+		return "hash mismatch"
+	}
+	return ""
+}
+
+// archivedRecord returns an archived record by id.
+func (l *Ledger) archivedRecord(id int64) (Record, bool, error) {
+	entries, err := l.Archives()
+	if err != nil {
+		return Record{}, false, err
+	}
+	for _, e := range entries {
+		if id < e.FirstID || id > e.LastID {
+			continue
+		}
+		recs, err := l.loadSegment(e)
+		if err != nil {
+			return Record{}, false, err
+		}
+		i := sort.Search(len(recs), func(i int) bool { return recs[i].ID >= id })
+		if i < len(recs) && recs[i].ID == id {
+			return recs[i], true, nil
+		}
+	}
+	return Record{}, false, nil
+}
+
+// archivedRecordByHash returns an archived record by hash. Segments are
+// indexed by id only, so each is fetched until the record is found.
+func (l *Ledger) archivedRecordByHash(hash string) (Record, bool, error) {
+	var found Record
+	err := l.iterateArchived(ListQuery{}, func(rec Record) error {
+		if rec.Hash != hash {
+			return nil
+		}
+		found = rec
+		return errIterationDone
+	})
+	if errors.Is(err, errIterationDone) {
+		return found, true, nil
+	}
+	return Record{}, false, err
+}
+
+// archivedRecords returns archived records matching q in id order, fetching
+// only segments whose time range overlaps the query.
+func (l *Ledger) archivedRecords(q ListQuery) ([]Record, error) {
+	var out []Record
+	err := l.iterateArchived(q, func(rec Record) error {
+		if q.Limit > 0 && len(out) >= q.Limit {
+			return errIterationDone
+		}
+		out = append(out, rec)
+		return nil
+	})
+	if err != nil && !errors.Is(err, errIterationDone) {
+		return nil, err
+	}
+	return out, nil
+}
+
+// iterateArchived calls fn with each archived record matching q, ignoring
+// its limit and offset, in id order, fetching only segments whose time
+// range overlaps the query.
+func (l *Ledger) iterateArchived(q ListQuery, fn func(Record) error) error {
+	entries, err := l.Archives()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if (q.Since > 0 && e.MaxTS < q.Since) || (q.Until > 0 && e.MinTS > q.Until) || e.LastID <= q.AfterID {
+			continue
+		}
+		recs, err := l.loadSegment(e)
+		if err != nil {
+			return err
+		}
+		for _, rec := range recs {
+			if !matchesListQuery(q, rec) {
+				continue
+			}
+			if err := fn(rec); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// matchesListQuery applies the List filters to a record held in memory.
+func matchesListQuery(q ListQuery, rec Record) bool {
+	if q.Since > 0 && rec.Timestamp < q.Since {
+		return false
+	}
+	if q.Until > 0 && rec.Timestamp > q.Until {
+		return false
+	}
+	if rec.ID <= q.AfterID {
+		return false
+	}
+	if len(q.Types) > 0 && !slices.Contains(q.Types, rec.Type) {
+		return false
+	}
+	if len(q.Sources) > 0 && !slices.Contains(q.Sources, rec.Source) {
+		return false
+	}
+	if q.SinceSeq > 0 && rec.Seq <= q.SinceSeq {
+		return false
+	}
+	if !matchesLabels(q.Labels, rec.Labels) {
+		return false
+	}
+	if !matchesJSONFilters(q.JSONFilters, rec.Payload) {
+		return false
+	}
+	if q.TraceID != "" {
+		var p struct {
+			TraceID string `json:"trace_id"`
+		}
+		if json.Unmarshal([]byte(rec.Payload), &p) != nil || p.TraceID != q.TraceID {
+			return false
+		}
+	}
+	return true
+}
+
+// verifyArchived checks every archived segment (only records with ts <=
+// until when until > 0) and returns the chain head to continue from,
+// following the chain across gaps. A non-empty reason reports the first
+// failure.
+func (l *Ledger) verifyArchived(until int64, gaps prunedGaps) (prev string, checked int64, lastID int64, failedID int64, reason string, err error) {
+	entries, err := l.Archives()
+	if err != nil {
+		return "", 0, 0, 0, "", err
+	}
+	for _, e := range entries {
+		if until > 0 && e.MinTS > until {
+			continue
+		}
+		recs, loadErr := l.loadSegment(e)
+		if loadErr != nil {
+			return prev, checked, lastID, e.FirstID, loadErr.Error(), nil
+		}
+		for _, rec := range recs {
+			if until > 0 && rec.Timestamp > until {
+				continue
+			}
+			prev = gaps.bridge(prev, lastID, rec)
+			if r := checkChainLink(prev, rec); r != "" {
+				return prev, checked, lastID, rec.ID, r, nil
+			}
+			prev = rec.Hash
+			lastID = rec.ID
+			checked++
+		}
+	}
+	return prev, checked, lastID, 0, "", nil
+}
@@ -0,0 +1,276 @@
+package ledger
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3ArchiveStore stores archive segments in an S3-compatible bucket using
+// SigV4-signed requests. Credentials and region come from the standard
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN and AWS_REGION
+// environment variables. AWS_ENDPOINT_URL_S3 (or AWS_ENDPOINT_URL) selects
+// an alternative endpoint such as MinIO, addressed path-style.
+type S3ArchiveStore struct {
+	Bucket string
+	Prefix string
+
+	Region       string
+	Endpoint     string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+
+	Client *http.Client
+	now    func() time.Time
+}
+
+// NewS3ArchiveStore returns a store for bucket/prefix configured from the
+// environment.
+func NewS3ArchiveStore(bucket, prefix string) (*S3ArchiveStore, error) {
+	if bucket == "" {
+		return nil, errors.New("s3 bucket required")
+	}
+	s := &S3ArchiveStore{
+		Bucket:       bucket,
+		Prefix:       prefix,
+		Region:       firstEnv("AWS_REGION", "AWS_DEFAULT_REGION"),
+		Endpoint:     firstEnv("AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL"),
+		AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if s.Region == "" {
+		s.Region = "us-east-1"
+	}
+	if s.AccessKey == "" || s.SecretKey == "" {
+		return nil, errors.New("s3 archive requires AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	return s, nil
+}
+
+func firstEnv(keys ...string) string {
+	for _, k := range keys {
+		if v := os.Getenv(k); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func (s *S3ArchiveStore) Location() string {
+	loc := "s3://" + s.Bucket
+	if s.Prefix != "" {
+		loc += "/" + s.Prefix
+	}
+	return loc
+}
+
+func (s *S3ArchiveStore) Put(name string, data []byte) error {
+	resp, err := s.do(http.MethodPut, name, data, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// PutLocked writes the object with an Object Lock retention of mode until
+// retainUntil. The bucket must have Object Lock enabled. The write fails if
+// the object already exists, so a locked object is never replaced by a new
+// version.
+func (s *S3ArchiveStore) PutLocked(name string, data []byte, mode string, retainUntil time.Time) error {
+	sum := md5.Sum(data)
+	resp, err := s.do(http.MethodPut, name, data, map[string]string{
+		"Content-MD5":                         base64.StdEncoding.EncodeToString(sum[:]),
+		"If-None-Match":                       "*",
+		"X-Amz-Object-Lock-Mode":              mode,
+		"X-Amz-Object-Lock-Retain-Until-Date": retainUntil.UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3ArchiveStore) Get(name string) ([]byte, error) {
+	resp, err := s.do(http.MethodGet, name, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+func (s *S3ArchiveStore) objectURL(name string) (*url.URL, error) {
+	key := name
+	if s.Prefix != "" {
+		key = s.Prefix + "/" + name
+	}
+
+	if s.Endpoint == "" {
+		return url.Parse(fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", s.Bucket, s.Region, key))
+	}
+	u, err := url.Parse(strings.TrimRight(s.Endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+	u.Path += "/" + s.Bucket + "/" + key
+	return u, nil
+}
+
+func (s *S3ArchiveStore) do(method, name string, body []byte, header map[string]string) (*http.Response, error) {
+	u, err := s.objectURL(name)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+
+	sum := sha256.Sum256(body)
+	now := time.Now
+	if s.now != nil {
+		now = s.now
+	}
+	signS3Request(req, hex.EncodeToString(sum[:]), s.Region, s.AccessKey, s.SecretKey, s.SessionToken, now())
+
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: 60 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("s3 %s %s: %s: %s", method, name, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// signS3Request adds AWS Signature Version 4 headers for S3 to req.
+func signS3Request(req *http.Request, payloadHash, region, accessKey, secretKey, sessionToken string, t time.Time) {
+	signAWSRequest(req, "s3", payloadHash, region, accessKey, secretKey, sessionToken, t)
+}
+
+// signAWSRequest adds AWS Signature Version 4 headers for service to req.
+// Every header already present on req is signed, together with host.
+func signAWSRequest(req *http.Request, service, payloadHash, region, accessKey, secretKey, sessionToken string, t time.Time) {
+	t = t.UTC()
+	amzDate := t.Format("20060102T150405Z")
+	day := t.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3EscapePath(req.URL.Path),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + region + "/" + service + "/aws4_request"
+	crSum := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(crSum[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func canonicalQuery(v url.Values) string {
+	keys := make([]string, 0, len(v))
+	for k := range v {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		vals := append([]string(nil), v[k]...)
+		sort.Strings(vals)
+		for _, val := range vals {
+			parts = append(parts, s3Escape(k, true)+"="+s3Escape(val, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func s3EscapePath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	return s3Escape(p, false)
+}
+
+// s3Escape percent-encodes everything except RFC 3986 unreserved characters
+// (and '/' unless encodeSlash is set), as SigV4 requires.
+func s3Escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,94 @@
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ArchiveStore persists archive segments. Implementations are addressed by
+// a location URI so the ledger can reopen them when it needs to read
+// archived records.
+type ArchiveStore interface {
+	// Location returns the URI that OpenArchiveStore resolves to this store.
+	Location() string
+	Put(name string, data []byte) error
+	Get(name string) ([]byte, error)
+}
+
+// OpenArchiveStore resolves an archive location. Supported forms are
+// s3://bucket/prefix, file:///path and plain filesystem paths.
+func OpenArchiveStore(location string) (ArchiveStore, error) {
+	if strings.TrimSpace(location) == "" {
+		return nil, errors.New("archive location required")
+	}
+
+	u, err := url.Parse(location)
+	if err != nil || u.Scheme == "" || len(u.Scheme) == 1 {
+		// Plain path (a one-letter scheme is a Windows drive letter).
+		return NewDirArchiveStore(location)
+	}
+
+	switch u.Scheme {
+	case "file":
+		return NewDirArchiveStore(u.Path)
+	case "s3":
+		return NewS3ArchiveStore(u.Host, strings.Trim(u.Path, "/"))
+	default:
+		return nil, fmt.Errorf("unsupported archive scheme %q", u.Scheme)
+	}
+}
+
+// DirArchiveStore keeps archive segments in a local directory, typically a
+// mounted network filesystem.
+type DirArchiveStore struct {
+	dir string
+}
+
+// NewDirArchiveStore returns a store rooted at dir, creating it if needed.
+func NewDirArchiveStore(dir string) (*DirArchiveStore, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(abs, 0o755); err != nil {
+		return nil, err
+	}
+	return &DirArchiveStore{dir: abs}, nil
+}
+
+func (s *DirArchiveStore) Location() string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(s.dir)}).String()
+}
+
+// Put writes the segment atomically so readers never observe a partial
+// file.
+func (s *DirArchiveStore) Put(name string, data []byte) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s *DirArchiveStore) Get(name string) ([]byte, error) {
+	path, err := s.path(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(path)
+}
+
+func (s *DirArchiveStore) path(name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return "", fmt.Errorf("invalid segment name %q", name)
+	}
+	return filepath.Join(s.dir, name), nil
+}
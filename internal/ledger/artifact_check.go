@@ -0,0 +1,79 @@
+package ledger
+
+import (
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/Retr0-XD/StateLedger/internal/artifacts"
+)
+
+// ArtifactCoverage reports the artifacts a snapshot references and whether
+// the artifact store can still produce them.
+type ArtifactCoverage struct {
+	Store      string `json:"store"`
+	Referenced int    `json:"referenced"`
+	Available  int    `json:"available"`
+	// Missing and Corrupted list checksums that are not in the store or
+	// whose content no longer hashes to the checksum.
+	Missing   []string `json:"missing,omitempty"`
+	Corrupted []string `json:"corrupted,omitempty"`
+}
+
+// SetArtifactStore makes reconstruction check that every artifact a
+// snapshot references is in the artifact store at root and intact. An
+// empty root turns the check off.
+func (l *Ledger) SetArtifactStore(root string) {
+	l.artifactStore.Store(&root)
+}
+
+func (l *Ledger) artifactRoot() string {
+	if root := l.artifactStore.Load(); root != nil {
+		return *root
+	}
+	return ""
+}
+
+// artifactRefs returns the artifact checksums a snapshot references. Code
+// artifacts that are not checksums, such as file names, do not name an
+// artifact in the store and are skipped.
+func artifactRefs(state *SnapshotState) []string {
+	if state == nil || state.Code == nil {
+		return nil
+	}
+	seen := map[string]bool{}
+	var refs []string
+	for _, a := range state.Code.Artifacts {
+		sum := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(a), "sha256:"))
+		if seen[sum] || !artifacts.IsChecksum(sum) {
+			continue
+		}
+		seen[sum] = true
+		refs = append(refs, sum)
+	}
+	return refs
+}
+
+// checkArtifacts looks up the snapshot's artifacts in the store at root
+// and adds an issue for each one that cannot be retrieved.
+func checkArtifacts(root string, state *SnapshotState, report *ReconstructionReport) *ArtifactCoverage {
+	refs := artifactRefs(state)
+	coverage := &ArtifactCoverage{Store: root, Referenced: len(refs)}
+	for _, sum := range refs {
+		err := artifacts.Verify(root, sum)
+		switch {
+		case err == nil:
+			coverage.Available++
+		case errors.Is(err, os.ErrNotExist):
+			coverage.Missing = append(coverage.Missing, sum)
+			report.Issues = append(report.Issues, "artifact: missing "+sum)
+		case errors.Is(err, artifacts.ErrCorrupted):
+			coverage.Corrupted = append(coverage.Corrupted, sum)
+			report.Issues = append(report.Issues, "artifact: corrupted "+sum)
+		default:
+			coverage.Missing = append(coverage.Missing, sum)
+			report.Issues = append(report.Issues, "artifact: "+sum+": "+err.Error())
+		}
+	}
+	return coverage
+}
@@ -3,6 +3,8 @@ package ledger
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 )
 
@@ -12,6 +14,16 @@ type AuditBundle struct {
 	Snapshot    ReconstructionReport `json:"snapshot"`
 	Proof       *ProofResult         `json:"proof,omitempty"`
 	Notes       []string             `json:"notes,omitempty"`
+	Attachments []BundleAttachment   `json:"attachments,omitempty"`
+	// Signature is set when the ledger has a signer.
+	Signature *Signature `json:"signature,omitempty"`
+}
+
+// BundleAttachment is a supporting document carried inside an audit bundle.
+type BundleAttachment struct {
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	Content     string `json:"content"`
 }
 
 func (r *Reconstructor) ExportAuditBundle(targetTime int64) (AuditBundle, error) {
@@ -20,6 +32,9 @@ func (r *Reconstructor) ExportAuditBundle(targetTime int64) (AuditBundle, error)
 	}
 
 	report := r.ReconstructAtTime(targetTime)
+	if r.Mode == ModeStrict && !report.Success {
+		return AuditBundle{}, fmt.Errorf("%w: %s", ErrStrictReconstruction, strings.Join(report.StrictFailures(), "; "))
+	}
 
 	bundle := AuditBundle{
 		GeneratedAt: time.Now().Unix(),
@@ -39,7 +54,19 @@ func (r *Reconstructor) ExportAuditBundle(targetTime int64) (AuditBundle, error)
 		bundle.Notes = append(bundle.Notes, "snapshot missing required dimensions")
 	}
 
-	return bundle, nil
+	diff, ok, err := r.l.LatestConfigDiff(targetTime, "", true)
+	if err != nil {
+		bundle.Notes = append(bundle.Notes, "config diff: "+err.Error())
+	} else if ok && diff.Changed {
+		bundle.Notes = append(bundle.Notes, "config drift detected; see config.diff attachment")
+		bundle.Attachments = append(bundle.Attachments, BundleAttachment{
+			Name:        "config.diff",
+			ContentType: "text/x-diff",
+			Content:     diff.Diff,
+		})
+	}
+
+	return bundle, r.l.signBundle(&bundle)
 }
 
 func (b AuditBundle) ToJSON() (string, error) {
@@ -0,0 +1,230 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"modernc.org/sqlite"
+)
+
+// ErrBackupUnverified is returned by Restore for a backup whose file or
+// chain is damaged; the ledger is left untouched.
+var ErrBackupUnverified = errors.New("backup does not verify")
+
+// BackupResult describes a backup written by Backup. Records and HeadID are
+// read back from the backup, and are 0 for an encrypted one.
+type BackupResult struct {
+	Path      string `json:"path"`
+	Bytes     int64  `json:"bytes"`
+	Encrypted bool   `json:"encrypted,omitempty"`
+	Records   int64  `json:"records,omitempty"`
+	HeadID    int64  `json:"head_id,omitempty"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// RestoreResult describes a ledger restored by Restore. Verify is the
+// verification of the restored chain.
+type RestoreResult struct {
+	Records   int64        `json:"records"`
+	HeadID    int64        `json:"head_id"`
+	Verify    VerifyResult `json:"verify"`
+	Timestamp int64        `json:"timestamp"`
+}
+
+// backupConn is the driver connection of modernc.org/sqlite, which exposes
+// SQLite's online backup API.
+type backupConn interface {
+	NewBackup(dstURI string) (*sqlite.Backup, error)
+	NewRestore(srcURI string) (*sqlite.Backup, error)
+}
+
+// Backup writes a consistent copy of the database to path, which must not
+// exist, while the ledger stays open. Unlike copying the database file,
+// which can catch a transaction half written, SQLite's online backup API
+// copies a single committed state. Appends through the ledger wait for the
+// copy; other processes writing meanwhile may find the database busy, as
+// during any long read. The copy is written next to path and renamed into
+// place, so path never holds a partial backup. An encrypted ledger is
+// backed up as an encrypted snapshot that opens with the same key.
+func (l *Ledger) Backup(path string) (BackupResult, error) {
+	if _, err := os.Lstat(path); err == nil {
+		return BackupResult{}, fmt.Errorf("%s already exists", path)
+	}
+	result := BackupResult{Path: path, Encrypted: l.encrypted != nil}
+
+	if l.encrypted != nil {
+		if err := l.encrypted.backup(path); err != nil {
+			return BackupResult{}, err
+		}
+	} else {
+		tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+		if err != nil {
+			return BackupResult{}, err
+		}
+		tmp.Close()
+		defer os.Remove(tmp.Name())
+		l.writeMu.Lock()
+		err = l.withBackupConn(func(c backupConn) error {
+			return runBackup(c.NewBackup(tmp.Name()))
+		})
+		l.writeMu.Unlock()
+		if err != nil {
+			return BackupResult{}, err
+		}
+		if err := os.Rename(tmp.Name(), path); err != nil {
+			return BackupResult{}, err
+		}
+
+		copied, err := Open(path)
+		if err != nil {
+			return BackupResult{}, err
+		}
+		defer copied.Close()
+		stats, err := copied.ChainStats()
+		if err != nil {
+			return BackupResult{}, err
+		}
+		result.Records, result.HeadID = stats.Records, stats.HeadID
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return BackupResult{}, err
+	}
+	result.Bytes = info.Size()
+	result.Timestamp = time.Now().Unix()
+	return result, nil
+}
+
+// backup writes the database to path as a sealed snapshot.
+func (e *encryptedDB) backup(path string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	snapshot, err := takeSnapshot(context.Background(), e.anchor)
+	if err != nil {
+		return err
+	}
+	header := e.header()
+	block, _, err := e.sealBlock(snapshot, header)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(header, block...))
+}
+
+// Restore replaces the contents of the ledger with src, a ledger opened
+// from a backup, and verifies the restored chain. src is checked first:
+// a backup that fails SQLite's integrity check or chain verification is
+// refused with ErrBackupUnverified. The copy is made with SQLite's backup
+// API, so other connections to the ledger see the old or the new database,
+// never a mix; a server should still be stopped, as it caches what it read
+// before. An encrypted backup restores with the key it was taken with.
+func (l *Ledger) Restore(src *Ledger) (RestoreResult, error) {
+	problems, err := src.integrityCheck()
+	if err != nil {
+		return RestoreResult{}, err
+	}
+	if len(problems) > 0 {
+		return RestoreResult{}, fmt.Errorf("%w: %s", ErrBackupUnverified, problems[0])
+	}
+	// Verifying with verifyFrom leaves the backup as it was, without a
+	// stored verification status.
+	check, _, err := src.verifyFrom(VerifyCheckpoint{})
+	if err != nil {
+		return RestoreResult{}, err
+	}
+	if !check.OK {
+		return RestoreResult{}, fmt.Errorf("%w: record %d: %s", ErrBackupUnverified, check.FailedID, check.Reason)
+	}
+	srcURI, err := src.databasePath()
+	if src.encrypted != nil {
+		srcURI = src.encrypted.uri
+	}
+	if err != nil {
+		return RestoreResult{}, err
+	}
+
+	l.writeMu.Lock()
+	err = l.withBackupConn(func(c backupConn) error {
+		return runBackup(c.NewRestore(srcURI))
+	})
+	if err == nil {
+		if l.encrypted != nil {
+			l.encrypted.replaced()
+		}
+		l.resetSchemaState()
+		err = l.upgradeReadSchemas()
+	}
+	l.writeMu.Unlock()
+	if err != nil {
+		return RestoreResult{}, err
+	}
+	if err := l.Sync(); err != nil {
+		return RestoreResult{}, err
+	}
+
+	result := RestoreResult{Timestamp: time.Now().Unix()}
+	stats, err := l.ChainStats()
+	if err != nil {
+		return result, err
+	}
+	result.Records, result.HeadID = stats.Records, stats.HeadID
+	if result.Verify, err = l.VerifyChain(); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// withBackupConn runs fn with a connection of the ledger's database.
+func (l *Ledger) withBackupConn(fn func(backupConn) error) error {
+	conn, err := l.db.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Raw(func(dc any) error {
+		c, ok := dc.(backupConn)
+		if !ok {
+			return errors.New("the database driver does not support online backup")
+		}
+		return fn(c)
+	})
+}
+
+// runBackup copies every page of a backup or restore started with err.
+func runBackup(b *sqlite.Backup, err error) error {
+	if err != nil {
+		return err
+	}
+	if _, err := b.Step(-1); err != nil {
+		b.Finish()
+		return err
+	}
+	return b.Finish()
+}
+
+// resetSchemaState forgets which side tables exist and what was cached
+// from the database, after its contents were replaced.
+func (l *Ledger) resetSchemaState() {
+	for _, ready := range []*atomic.Bool{
+		&l.agentsReady, &l.leasesReady, &l.schemasReady, &l.rateBucketsReady, &l.approvalsReady,
+		&l.holdsReady, &l.credentialsReady, &l.policiesReady, &l.labelsReady, &l.prunedReady,
+		&l.redactionsReady, &l.checkpointReady, &l.wormReady, &l.keysReady, &l.signaturesReady,
+		&l.uidsReady, &l.seqsReady, &l.typesReady, &l.idempotencyReady,
+	} {
+		ready.Store(false)
+	}
+	l.canonicalTypes.Store(nil)
+	l.archiveMu.Lock()
+	l.archiveSegments = nil
+	l.archiveMu.Unlock()
+	l.invalidateListCache()
+	if l.cache != nil {
+		l.cache.Clear()
+	}
+}
@@ -1,7 +1,9 @@
 package ledger
 
 import (
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -16,6 +18,20 @@ type Cache struct {
 	mu    sync.RWMutex
 	items map[string]CacheEntry
 	ttl   time.Duration
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// CacheStats reports cache effectiveness
+type CacheStats struct {
+	Hits    uint64  `json:"hits"`
+	Misses  uint64  `json:"misses"`
+	HitRate float64 `json:"hit_rate"`
+	Entries int     `json:"entries"`
 }
 
 // NewCache creates a new cache with the specified TTL
@@ -23,6 +39,7 @@ func NewCache(ttl time.Duration) *Cache {
 	c := &Cache{
 		items: make(map[string]CacheEntry),
 		ttl:   ttl,
+		stop:  make(chan struct{}),
 	}
 	go c.cleanup()
 	return c
@@ -34,14 +51,12 @@ func (c *Cache) Get(key string) (interface{}, bool) {
 	defer c.mu.RUnlock()
 
 	entry, found := c.items[key]
-	if !found {
-		return nil, false
-	}
-
-	if time.Now().After(entry.Expiration) {
+	if !found || time.Now().After(entry.Expiration) {
+		c.misses.Add(1)
 		return nil, false
 	}
 
+	c.hits.Add(1)
 	return entry.Value, true
 }
 
@@ -63,6 +78,17 @@ func (c *Cache) Delete(key string) {
 	delete(c.items, key)
 }
 
+// DeletePrefix removes every value whose key starts with prefix
+func (c *Cache) DeletePrefix(prefix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.items {
+		if strings.HasPrefix(key, prefix) {
+			delete(c.items, key)
+		}
+	}
+}
+
 // Clear removes all items from the cache
 func (c *Cache) Clear() {
 	c.mu.Lock()
@@ -70,12 +96,40 @@ func (c *Cache) Clear() {
 	c.items = make(map[string]CacheEntry)
 }
 
+// Stats returns hit/miss counters and the current number of entries
+func (c *Cache) Stats() CacheStats {
+	c.mu.RLock()
+	entries := len(c.items)
+	c.mu.RUnlock()
+
+	stats := CacheStats{
+		Hits:    c.hits.Load(),
+		Misses:  c.misses.Load(),
+		Entries: entries,
+	}
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(total)
+	}
+	return stats
+}
+
+// Close stops the background cleanup goroutine
+func (c *Cache) Close() {
+	c.stopOnce.Do(func() { close(c.stop) })
+}
+
 // cleanup periodically removes expired items
 func (c *Cache) cleanup() {
 	ticker := time.NewTicker(1 * time.Minute)
 	defer ticker.Stop()
 
-	for range ticker.C {
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+		}
+
 		c.mu.Lock()
 		now := time.Now()
 		for key, entry := range c.items {
@@ -0,0 +1,194 @@
+package ledger
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// FuzzAppendVerify appends one record with arbitrary fields. Either the
+// record is rejected, or it reads back byte for byte, the chain verifies,
+// and an export/import round trip reproduces its hash.
+func FuzzAppendVerify(f *testing.F) {
+	f.Add(int64(1000), "event", "svc", "plain")
+	f.Add(int64(1000), "event", "svc", "a|b|c")
+	f.Add(int64(-1), "a", "b", `{"k":"v|w"}`)
+	f.Add(int64(0), "ev|ent", "svc", "x")
+	f.Add(int64(1<<62), "event", "sv|c", "x")
+	f.Add(int64(5), "événement", "svc\n", "line\nbreak\r\n\ttab")
+	f.Add(int64(5), "event", "", "nul\x00byte")
+	f.Add(int64(5), "event", "svc", "\xff\xfe invalid utf-8")
+
+	f.Fuzz(func(t *testing.T, ts int64, typ, source, payload string) {
+		l := newTestLedger(t)
+		defer l.Close()
+
+		in := RecordInput{Timestamp: ts, Type: typ, Source: source, Payload: payload, FreeForm: true}
+		rec, err := l.Append(in)
+		if err != nil {
+			if in.validate() == nil {
+				t.Fatalf("append rejected a valid record: %v", err)
+			}
+			return
+		}
+		if strings.Contains(typ, "|") || strings.Contains(source, "|") {
+			t.Fatalf("append accepted a pipe in type %q or source %q", typ, source)
+		}
+
+		got, err := l.GetByID(rec.ID)
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		if got.Timestamp != ts || got.Type != typ || got.Source != source || got.Payload != payload {
+			t.Fatalf("record did not read back: got %+v", got)
+		}
+		if res, err := l.VerifyChain(); err != nil || !res.OK {
+			t.Fatalf("verify: %+v err=%v", res, err)
+		}
+
+		// Import trims the type and JSON cannot carry invalid UTF-8, so only
+		// records without either survive export unchanged.
+		if typ != strings.TrimSpace(typ) || !utf8.ValidString(typ+source+payload) {
+			return
+		}
+		other := newTestLedger(t)
+		defer other.Close()
+		if last := roundTrip(t, l, other); last != rec.Hash {
+			t.Fatalf("round trip hash %s, want %s", last, rec.Hash)
+		}
+	})
+}
+
+// FuzzImportJSONL imports arbitrary input. Whatever is imported, including
+// the batches committed before an error, must form a verifiable chain.
+func FuzzImportJSONL(f *testing.F) {
+	f.Add([]byte(`{"timestamp":1,"type":"event","source":"s","payload":"a|b"}`))
+	f.Add([]byte("{\"ts\":2,\"type\":\"event\",\"payload\":{\"x\":[1,2]}}\n\n{\"ts\":3,\"type\":\" event \",\"payload\":\"y\"}"))
+	f.Add([]byte(`{"ts":4,"type":"event","source":"a|b","payload":"x"}`))
+	f.Add([]byte(`{"ts":5,"type":"event","payload":null}`))
+	f.Add([]byte("{\"ts\":6,\"type\":\"event\",\"payload\":\"ok\"}\nnot json"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		l := newTestLedger(t)
+		defer l.Close()
+
+		result, importErr := l.ImportJSONL(bytes.NewReader(data), ImportOptions{RebuildChain: true, BatchSize: 2, FreeForm: true})
+		res, err := l.VerifyChain()
+		if err != nil || !res.OK {
+			t.Fatalf("chain broken after import (import err %v): %+v err=%v", importErr, res, err)
+		}
+		if importErr == nil && res.Checked != result.Imported {
+			t.Fatalf("verified %d records, imported %d", res.Checked, result.Imported)
+		}
+	})
+}
+
+// TestChainRandomOperations runs random sequences of appends, archive
+// prunes, export/import round trips and in-place payload edits. The chain
+// must verify after every step until a record is edited, and from then on
+// verification must report the first edited record.
+func TestChainRandomOperations(t *testing.T) {
+	pieces := []string{"a", "|", "||", `"`, `\`, "\n", "\x00", "é", "💥", "{", "}", " ", "null", "0"}
+	randomString := func(r *rand.Rand, n int) string {
+		var b strings.Builder
+		for i := 0; i < n; i++ {
+			b.WriteString(pieces[r.Intn(len(pieces))])
+		}
+		return b.String()
+	}
+
+	for seed := int64(1); seed <= 20; seed++ {
+		t.Run(fmt.Sprintf("seed%d", seed), func(t *testing.T) {
+			r := rand.New(rand.NewSource(seed))
+			l := newTestLedger(t)
+			defer l.Close()
+			store, err := NewDirArchiveStore(t.TempDir())
+			if err != nil {
+				t.Fatalf("store: %v", err)
+			}
+			key := []byte("fuzz-key")
+			l.SetArchiveKey(key)
+
+			ts := int64(1000)
+			var total, archived, edited int64
+			for step := 0; step < 40; step++ {
+				var op string
+				switch n := r.Intn(10); {
+				case n < 6 || total == archived:
+					op = "append"
+					ts += int64(r.Intn(3))
+					in := RecordInput{
+						Timestamp: ts,
+						Type:      strings.ReplaceAll("t"+randomString(r, 2), "|", "/"),
+						Source:    strings.ReplaceAll(randomString(r, 2), "|", "/"),
+						Payload:   "p" + randomString(r, r.Intn(6)),
+						FreeForm:  true,
+					}
+					if _, err := l.Append(in); err != nil {
+						t.Fatalf("step %d: append %+v: %v", step, in, err)
+					}
+					total++
+				case n < 7 && edited == 0:
+					op = "archive"
+					res, err := l.Archive(store, ArchiveOptions{Before: ts, SegmentSize: 1 + r.Intn(3), Key: key})
+					if err != nil {
+						t.Fatalf("step %d: archive: %v", step, err)
+					}
+					archived += res.Archived
+				case n < 8 && edited == 0:
+					op = "round trip"
+					other := newTestLedger(t)
+					roundTrip(t, l, other)
+					other.Close()
+				default:
+					op = "edit"
+					var id int64
+					if err := l.db.QueryRow(`SELECT id FROM ledger_records ORDER BY RANDOM() LIMIT 1`).Scan(&id); err != nil {
+						t.Fatalf("step %d: pick record: %v", step, err)
+					}
+					tamper(t, l, `UPDATE ledger_records SET payload = payload || '|edited' WHERE id = ?`, id)
+					if edited == 0 || id < edited {
+						edited = id
+					}
+				}
+
+				res, err := l.VerifyChain()
+				if err != nil {
+					t.Fatalf("step %d (%s): verify: %v", step, op, err)
+				}
+				if edited == 0 && (!res.OK || res.Checked != total) {
+					t.Fatalf("step %d (%s): expected %d verified records, got %+v", step, op, total, res)
+				}
+				if edited != 0 && (res.OK || res.FailedID != edited || res.Reason != "hash mismatch") {
+					t.Fatalf("step %d (%s): expected break at %d, got %+v", step, op, edited, res)
+				}
+			}
+		})
+	}
+}
+
+// roundTrip exports the live records of from into the empty ledger to,
+// checks that the imported chain verifies, and returns its last hash.
+func roundTrip(t *testing.T, from, to *Ledger) string {
+	t.Helper()
+	var buf bytes.Buffer
+	n, err := from.ExportJSONL(&buf, ExportOptions{})
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	result, err := to.ImportJSONL(&buf, ImportOptions{RebuildChain: true, FreeForm: true})
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if result.Imported != n {
+		t.Fatalf("imported %d of %d exported records", result.Imported, n)
+	}
+	res, err := to.VerifyChain()
+	if err != nil || !res.OK || res.Checked != n {
+		t.Fatalf("imported chain does not verify: %+v err=%v", res, err)
+	}
+	return result.LastHash
+}
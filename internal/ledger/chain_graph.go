@@ -0,0 +1,189 @@
+package ledger
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Retr0-XD/StateLedger/internal/collectors"
+)
+
+const (
+	// DefaultGraphLimit is the number of records ChainGraph returns when no
+	// limit is given.
+	DefaultGraphLimit = 200
+	// MaxGraphLimit caps the records in one graph.
+	MaxGraphLimit = 5000
+)
+
+// Graph node kinds.
+const (
+	GraphNodeRecord   = "record"
+	GraphNodeArtifact = "artifact"
+)
+
+// Graph edge kinds.
+const (
+	// GraphEdgeChain links a record to the record before it in the hash
+	// chain.
+	GraphEdgeChain = "chain"
+	// GraphEdgeArtifact links a code record to an artifact it references.
+	GraphEdgeArtifact = "artifact"
+	// GraphEdgeTrace links a record to the previous record of the same
+	// trace, giving the causal order of a request.
+	GraphEdgeTrace = "trace"
+)
+
+// GraphNode is a record or an artifact in a ChainGraph.
+type GraphNode struct {
+	ID        string `json:"id"`
+	Kind      string `json:"kind"`
+	Label     string `json:"label"`
+	RecordID  int64  `json:"record_id,omitempty"`
+	Timestamp int64  `json:"ts,omitempty"`
+	Type      string `json:"type,omitempty"`
+	Source    string `json:"source,omitempty"`
+	Hash      string `json:"hash,omitempty"`
+	TraceID   string `json:"trace_id,omitempty"`
+}
+
+// GraphEdge points from a node to the node it refers to.
+type GraphEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Kind string `json:"kind"`
+	// Broken marks a chain edge whose prev_hash does not match the hash of
+	// the record before it.
+	Broken bool `json:"broken,omitempty"`
+}
+
+// ChainGraph is a range of the chain as nodes and edges, for rendering the
+// chain and the causal links between records.
+type ChainGraph struct {
+	FromID int64       `json:"from_id"`
+	ToID   int64       `json:"to_id"`
+	Nodes  []GraphNode `json:"nodes"`
+	Edges  []GraphEdge `json:"edges"`
+	// Truncated is set when the range held more records than the limit.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+func recordNodeID(id int64) string {
+	return "record:" + strconv.FormatInt(id, 10)
+}
+
+// ChainGraph builds the graph of local records with fromID <= id <= toID,
+// at most limit of them. toID <= 0 means the chain head; fromID <= 0 means
+// the last limit records up to toID. Chain edges are drawn between records
+// in the range, trace edges between records of the range that share a
+// trace_id, and artifact edges from code records to the artifact checksums
+// they reference.
+func (l *Ledger) ChainGraph(fromID, toID int64, limit int) (ChainGraph, error) {
+	if limit <= 0 {
+		limit = DefaultGraphLimit
+	}
+	if limit > MaxGraphLimit {
+		limit = MaxGraphLimit
+	}
+	if fromID <= 0 {
+		end := toID
+		if end <= 0 {
+			if err := l.db.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM ledger_records`).Scan(&end); err != nil {
+				return ChainGraph{}, err
+			}
+		}
+		fromID = max(end-int64(limit)+1, 1)
+	}
+	if toID > 0 && toID < fromID {
+		return ChainGraph{}, fmt.Errorf("to %d is before from %d", toID, fromID)
+	}
+
+	recs, err := l.RecordsByID(fromID, toID, limit+1)
+	if err != nil {
+		return ChainGraph{}, err
+	}
+	g := ChainGraph{FromID: fromID, ToID: toID, Nodes: []GraphNode{}, Edges: []GraphEdge{}}
+	if len(recs) > limit {
+		recs, g.Truncated = recs[:limit], true
+	}
+	if len(recs) > 0 {
+		g.ToID = recs[len(recs)-1].ID
+	}
+
+	artifactNodes := map[string]bool{}
+	lastInTrace := map[string]string{}
+	for i, rec := range recs {
+		node := GraphNode{
+			ID:        recordNodeID(rec.ID),
+			Kind:      GraphNodeRecord,
+			Label:     fmt.Sprintf("#%d %s", rec.ID, rec.Type),
+			RecordID:  rec.ID,
+			Timestamp: rec.Timestamp,
+			Type:      rec.Type,
+			Source:    rec.Source,
+			Hash:      rec.Hash,
+		}
+		var refs struct {
+			TraceID string `json:"trace_id"`
+		}
+		if json.Unmarshal([]byte(rec.Payload), &refs) == nil {
+			node.TraceID = refs.TraceID
+		}
+		g.Nodes = append(g.Nodes, node)
+
+		if i > 0 && recs[i-1].ID == rec.ID-1 {
+			g.Edges = append(g.Edges, GraphEdge{From: node.ID, To: recordNodeID(recs[i-1].ID), Kind: GraphEdgeChain, Broken: rec.PrevHash != recs[i-1].Hash})
+		}
+		if node.TraceID != "" {
+			if prev, ok := lastInTrace[node.TraceID]; ok {
+				g.Edges = append(g.Edges, GraphEdge{From: node.ID, To: prev, Kind: GraphEdgeTrace})
+			}
+			lastInTrace[node.TraceID] = node.ID
+		}
+		if rec.Type != "code" {
+			continue
+		}
+		var cp collectors.CodePayload
+		if collectors.ParseJSON(rec.Payload, &cp) != nil {
+			continue
+		}
+		for _, sum := range artifactRefs(&SnapshotState{Code: &cp}) {
+			id := GraphNodeArtifact + ":" + sum
+			if !artifactNodes[id] {
+				artifactNodes[id] = true
+				g.Nodes = append(g.Nodes, GraphNode{ID: id, Kind: GraphNodeArtifact, Label: sum[:12], Hash: sum})
+			}
+			g.Edges = append(g.Edges, GraphEdge{From: node.ID, To: id, Kind: GraphEdgeArtifact})
+		}
+	}
+	return g, nil
+}
+
+// DOT renders the graph in Graphviz DOT. Broken chain edges are drawn in
+// red, trace edges dashed and artifact edges dotted.
+func (g ChainGraph) DOT() string {
+	var b strings.Builder
+	b.WriteString("digraph ledger {\n\trankdir=LR;\n\tnode [shape=box, fontname=\"monospace\"];\n")
+	for _, n := range g.Nodes {
+		attrs := "label=" + strconv.Quote(n.Label)
+		if n.Kind == GraphNodeArtifact {
+			attrs += ", shape=note"
+		}
+		fmt.Fprintf(&b, "\t%s [%s];\n", strconv.Quote(n.ID), attrs)
+	}
+	for _, e := range g.Edges {
+		var attrs string
+		switch {
+		case e.Broken:
+			attrs = ` [color=red, label="broken"]`
+		case e.Kind == GraphEdgeTrace:
+			attrs = " [style=dashed]"
+		case e.Kind == GraphEdgeArtifact:
+			attrs = " [style=dotted]"
+		}
+		fmt.Fprintf(&b, "\t%s -> %s%s;\n", strconv.Quote(e.From), strconv.Quote(e.To), attrs)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
@@ -0,0 +1,98 @@
+package ledger
+
+// ChainStats summarizes the size and recency of the ledger, including
+// records moved to archive segments.
+type ChainStats struct {
+	Records int64 `json:"records"`
+	// LastTimestamp is the timestamp of the newest record, or 0 for an
+	// empty ledger.
+	LastTimestamp int64 `json:"last_timestamp"`
+	HeadID        int64 `json:"head_id"`
+}
+
+// ChainStats counts live and archived records and reports the newest one.
+func (l *Ledger) ChainStats() (ChainStats, error) {
+	var stats ChainStats
+	err := l.db.QueryRow(`SELECT
+			(SELECT COUNT(*) FROM ledger_records) + (SELECT COALESCE(SUM(count), 0) FROM ledger_archives),
+			MAX((SELECT COALESCE(MAX(ts), 0) FROM ledger_records), (SELECT COALESCE(MAX(max_ts), 0) FROM ledger_archives)),
+			MAX((SELECT COALESCE(MAX(id), 0) FROM ledger_records), (SELECT COALESCE(MAX(last_id), 0) FROM ledger_archives))`).
+		Scan(&stats.Records, &stats.LastTimestamp, &stats.HeadID)
+	return stats, err
+}
+
+// Stats is an overview of the ledger for dashboards.
+type Stats struct {
+	ChainStats
+	// ArchivedRecords are the records of Records moved to archive
+	// segments. ByType and BySource count the local ones only.
+	ArchivedRecords int64 `json:"archived_records"`
+	// ChainLength is the number of links in the chain: every record ever
+	// appended, pruned ones included.
+	ChainLength int64 `json:"chain_length"`
+	// FirstTimestamp is the timestamp of the oldest live or archived
+	// record, or 0 for an empty ledger.
+	FirstTimestamp int64            `json:"first_timestamp"`
+	ByType         map[string]int64 `json:"by_type"`
+	BySource       map[string]int64 `json:"by_source"`
+	DatabaseBytes  int64            `json:"database_bytes"`
+	// LastVerification is the result of the last whole or incremental
+	// chain verification, nil when the chain has not been verified.
+	LastVerification *VerifyResult `json:"last_verification,omitempty"`
+}
+
+// Stats counts records by type and source and reports the extent of the
+// chain, the size of the database and the last verification.
+func (l *Ledger) Stats() (Stats, error) {
+	stats := Stats{ByType: map[string]int64{}, BySource: map[string]int64{}}
+	var err error
+	if stats.ChainStats, err = l.ChainStats(); err != nil {
+		return stats, err
+	}
+	stats.ChainLength = stats.HeadID
+	if err := l.db.QueryRow(`SELECT
+			(SELECT COALESCE(SUM(count), 0) FROM ledger_archives),
+			COALESCE(MIN((SELECT MIN(ts) FROM ledger_records), (SELECT MIN(min_ts) FROM ledger_archives)),
+				(SELECT MIN(ts) FROM ledger_records), (SELECT MIN(min_ts) FROM ledger_archives), 0)`).
+		Scan(&stats.ArchivedRecords, &stats.FirstTimestamp); err != nil {
+		return stats, err
+	}
+
+	for column, counts := range map[string]map[string]int64{"type": stats.ByType, "source": stats.BySource} {
+		rows, err := l.db.Query(`SELECT ` + column + `, COUNT(*) FROM ledger_records GROUP BY ` + column)
+		if err != nil {
+			return stats, err
+		}
+		for rows.Next() {
+			var key string
+			var n int64
+			if err := rows.Scan(&key, &n); err != nil {
+				rows.Close()
+				return stats, err
+			}
+			counts[key] = n
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return stats, err
+		}
+	}
+
+	var pageSize, pages int64
+	if err := l.db.QueryRow(`PRAGMA page_size`).Scan(&pageSize); err != nil {
+		return stats, err
+	}
+	if err := l.db.QueryRow(`PRAGMA page_count`).Scan(&pages); err != nil {
+		return stats, err
+	}
+	stats.DatabaseBytes = pageSize * pages
+
+	last, ok, err := l.LastVerification()
+	if err != nil {
+		return stats, err
+	}
+	if ok {
+		stats.LastVerification = &last
+	}
+	return stats, nil
+}
@@ -0,0 +1,214 @@
+package ledger
+
+import (
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/Retr0-XD/StateLedger/internal/collectors"
+)
+
+// CompactionRecordType is the type of the record appended by Compact, with
+// the ledger as source. Its payload lists the compacted runs and, like a
+// retention checkpoint, the pruned ranges.
+const CompactionRecordType = "capture.compacted"
+
+// DefaultCompactTypes are the capture types Compact collapses when none are
+// given.
+var DefaultCompactTypes = []string{"environment", "config"}
+
+// CompactedRun is a run of consecutive captures of one type with the same
+// source and payload. The first, KeptID, stays in the ledger; the rest are
+// pruned. The captured state was unchanged from ValidFrom to ValidTo, the
+// timestamps of the first and last capture of the run.
+type CompactedRun struct {
+	Type        string `json:"type"`
+	Source      string `json:"source"`
+	KeptID      int64  `json:"kept_id"`
+	LastID      int64  `json:"last_id"`
+	Count       int64  `json:"count"`
+	ValidFrom   int64  `json:"valid_from"`
+	ValidTo     int64  `json:"valid_to"`
+	PayloadHash string `json:"payload_hash"`
+}
+
+type compactionRecord struct {
+	Runs   []CompactedRun `json:"runs"`
+	Pruned int64          `json:"pruned"`
+	Ranges []PrunedRange  `json:"ranges"`
+}
+
+// CompactOptions controls Compact.
+type CompactOptions struct {
+	// Types are the capture types to compact. Empty means
+	// DefaultCompactTypes.
+	Types []string
+	// MinRun is the shortest run that is compacted. Zero means 2.
+	MinRun int
+	// DryRun reports what would be compacted without changing the ledger.
+	DryRun bool
+	// Now stamps the compaction record. Zero means time.Now.
+	Now time.Time
+}
+
+// CompactResult summarizes a Compact run.
+type CompactResult struct {
+	Runs   []CompactedRun `json:"runs"`
+	Pruned int64          `json:"pruned"`
+	Ranges []PrunedRange  `json:"ranges"`
+	// RecordID is the compaction record appended for the run.
+	RecordID int64 `json:"record_id,omitempty"`
+	DryRun   bool  `json:"dry_run,omitempty"`
+}
+
+// Compact collapses runs of identical captures: consecutive local records
+// of one of opts.Types, among all records of that type, with the same
+// source and payload. The first record of each run is kept and the rest
+// are pruned, and a compaction record listing each run's validity interval
+// and the pruned ranges is appended, in one transaction. Because the kept
+// record precedes the pruned ones and has the same payload, reconstruction
+// at any time in the run yields the same state. Records under an active
+// legal hold and redacted records end a run and are not pruned.
+func (l *Ledger) Compact(opts CompactOptions) (CompactResult, error) {
+	if len(opts.Types) == 0 {
+		opts.Types = DefaultCompactTypes
+	}
+	for _, t := range opts.Types {
+		if err := validTypeName(t); err != nil {
+			return CompactResult{}, err
+		}
+		if slices.Contains(referencedTypes, t) {
+			return CompactResult{}, errors.New("cannot compact " + t + " records")
+		}
+	}
+	if opts.MinRun < 0 {
+		return CompactResult{}, errors.New("min run must not be negative")
+	}
+	opts.MinRun = max(opts.MinRun, 2)
+	if opts.Now.IsZero() {
+		opts.Now = time.Now()
+	}
+	if err := l.ensurePrunedSchema(); err != nil {
+		return CompactResult{}, err
+	}
+	if err := l.ensureRedactionSchema(); err != nil {
+		return CompactResult{}, err
+	}
+	if err := l.ensureSignatureSchema(); err != nil {
+		return CompactResult{}, err
+	}
+	if err := l.ensureLabelSchema(); err != nil {
+		return CompactResult{}, err
+	}
+
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+
+	holds, err := l.activeHolds()
+	if err != nil {
+		return CompactResult{}, err
+	}
+
+	tx, err := l.db.Begin()
+	if err != nil {
+		return CompactResult{}, err
+	}
+	defer tx.Rollback()
+
+	result := CompactResult{Runs: []CompactedRun{}, DryRun: opts.DryRun}
+	selected := map[int64]bool{}
+	for _, t := range opts.Types {
+		rows, err := tx.Query(`SELECT r.id, r.ts, r.source, r.payload, x.record_id IS NOT NULL, `+labelsColumn+`
+			FROM ledger_records r LEFT JOIN ledger_redactions x ON x.record_id = r.id WHERE r.type = ? ORDER BY r.id ASC`, t)
+		if err != nil {
+			return CompactResult{}, err
+		}
+		var run CompactedRun
+		var payload, labels string
+		var pruned []int64
+		flush := func() {
+			if run.Count >= int64(opts.MinRun) {
+				result.Runs = append(result.Runs, run)
+				for _, id := range pruned {
+					selected[id] = true
+				}
+			}
+			run, payload, labels, pruned = CompactedRun{}, "", "", nil
+		}
+		for rows.Next() {
+			var id, ts int64
+			var source, p, lb string
+			var redacted bool
+			if err := rows.Scan(&id, &ts, &source, &p, &redacted, &lb); err != nil {
+				rows.Close()
+				return CompactResult{}, err
+			}
+			if _, held := holdCovering(holds, ts); held || redacted {
+				flush()
+				continue
+			}
+			if run.Count > 0 && source == run.Source && p == payload && lb == labels {
+				run.LastID, run.ValidTo = id, ts
+				run.Count++
+				pruned = append(pruned, id)
+				continue
+			}
+			flush()
+			run = CompactedRun{Type: t, Source: source, KeptID: id, LastID: id, Count: 1, ValidFrom: ts, ValidTo: ts, PayloadHash: PayloadHash(p)}
+			payload, labels = p, lb
+		}
+		flush()
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return CompactResult{}, err
+		}
+	}
+	if len(selected) == 0 {
+		return result, nil
+	}
+
+	ranges, err := prunedRanges(tx, selected)
+	if err != nil {
+		return CompactResult{}, err
+	}
+	result.Ranges = ranges
+	for _, r := range ranges {
+		result.Pruned += r.Count
+	}
+	if opts.DryRun {
+		return result, nil
+	}
+
+	payload, err := collectors.MarshalPayload(compactionRecord{Runs: result.Runs, Pruned: result.Pruned, Ranges: ranges})
+	if err != nil {
+		return CompactResult{}, err
+	}
+	records, err := l.appendBatchTx(tx, []RecordInput{{
+		Timestamp: opts.Now.Unix(),
+		Type:      CompactionRecordType,
+		Source:    "ledger",
+		Payload:   payload,
+	}})
+	if err != nil {
+		return CompactResult{}, err
+	}
+	result.RecordID = records[0].ID
+
+	if err := deleteRanges(tx, result.Ranges, result.RecordID, opts.Now.Unix()); err != nil {
+		return CompactResult{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return CompactResult{}, err
+	}
+
+	if l.cache != nil {
+		for id := range selected {
+			l.cache.Delete(recordCacheKey(id))
+		}
+	}
+	l.invalidateListCache()
+	l.countAppended(records)
+	l.mirrorAppended(records)
+	l.journalAppended(records)
+	return result, l.Sync()
+}
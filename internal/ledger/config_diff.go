@@ -0,0 +1,330 @@
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Retr0-XD/StateLedger/internal/collectors"
+)
+
+const diffContextLines = 3
+
+// ConfigDiff is a unified diff between two stored config snapshots and,
+// when both parse, the keys whose values differ.
+type ConfigDiff struct {
+	Source  string `json:"source"`
+	FromID  int64  `json:"from_id"`
+	ToID    int64  `json:"to_id"`
+	FromTS  int64  `json:"from_ts"`
+	ToTS    int64  `json:"to_ts"`
+	Changed bool   `json:"changed"`
+	Masked  bool   `json:"masked"`
+	Diff    string `json:"diff,omitempty"`
+	// Format is set when both snapshots parse, and Keys then lists the
+	// changed keys.
+	Format string          `json:"format,omitempty"`
+	Keys   []ConfigKeyDiff `json:"keys,omitempty"`
+}
+
+// Drifted reports whether the config changed. Snapshots that parse drift
+// only when a key changed, not when comments, ordering or formatting did.
+func (d ConfigDiff) Drifted() bool {
+	if d.Format != "" {
+		return len(d.Keys) > 0
+	}
+	return d.Changed
+}
+
+var sensitiveKeyPattern = regexp.MustCompile(`(?i)(password|passwd|secret|token|api[_-]?key|private[_-]?key|credential|auth)`)
+
+var configValuePattern = regexp.MustCompile(`^(\s*"?)([A-Za-z0-9_.\-]+)("?\s*[:=]\s*)(.*?)(,?)\s*$`)
+
+// MaskSecrets replaces the values of keys that look sensitive (passwords,
+// tokens, keys) with a fixed marker so diffs never leak secrets. Snapshots
+// that NormalizeConfig parses are masked by key path; others fall back to
+// masking line-oriented "key: value", "key=value" and JSON "key": "value"
+// forms.
+func MaskSecrets(snapshot string) string {
+	if cfg, err := NormalizeConfig("", snapshot); err == nil {
+		return cfg.Redacted()
+	}
+	return maskSecretLines(snapshot)
+}
+
+func maskSecretLines(snapshot string) string {
+	lines := strings.Split(snapshot, "\n")
+	for i, line := range lines {
+		m := configValuePattern.FindStringSubmatch(line)
+		if m == nil || !sensitiveKeyPattern.MatchString(m[2]) {
+			continue
+		}
+		value := strings.TrimSpace(m[4])
+		if value == "" || value == "{" || value == "[" {
+			continue
+		}
+		masked := "****"
+		if strings.HasPrefix(value, `"`) {
+			masked = `"****"`
+		}
+		lines[i] = m[1] + m[2] + m[3] + masked + m[5]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// DiffConfigRecords renders a unified diff between two config records. When
+// mask is set, sensitive values are masked on both sides before diffing.
+func (l *Ledger) DiffConfigRecords(fromID, toID int64, mask bool) (ConfigDiff, error) {
+	from, err := l.GetByID(fromID)
+	if err != nil {
+		return ConfigDiff{}, fmt.Errorf("record %d: %w", fromID, err)
+	}
+	to, err := l.GetByID(toID)
+	if err != nil {
+		return ConfigDiff{}, fmt.Errorf("record %d: %w", toID, err)
+	}
+	return diffConfigs(from, to, mask)
+}
+
+// LatestConfigDiff diffs the latest config snapshot at or before ts against
+// the previous snapshot of the same source. ok is false when there is no
+// earlier snapshot to compare against.
+func (l *Ledger) LatestConfigDiff(ts int64, source string, mask bool) (ConfigDiff, bool, error) {
+	query := `SELECT id, ts, type, source, payload, hash, prev_hash FROM ledger_records WHERE type = 'config' AND ts <= ? ORDER BY id DESC`
+	rows, err := l.readQuery(query, ts)
+	if err != nil {
+		return ConfigDiff{}, false, err
+	}
+	defer rows.Close()
+
+	var latest *Record
+	var latestSource string
+	for rows.Next() {
+		var rec Record
+		if err := rows.Scan(&rec.ID, &rec.Timestamp, &rec.Type, &rec.Source, &rec.Payload, &rec.Hash, &rec.PrevHash); err != nil {
+			return ConfigDiff{}, false, err
+		}
+		var cp collectors.ConfigPayload
+		if err := collectors.ParseJSON(rec.Payload, &cp); err != nil {
+			continue
+		}
+		if source != "" && cp.Source != source {
+			continue
+		}
+		if latest == nil {
+			latest = &rec
+			latestSource = cp.Source
+			continue
+		}
+		if cp.Source != latestSource {
+			continue
+		}
+		if err := rows.Close(); err != nil {
+			return ConfigDiff{}, false, err
+		}
+		diff, err := diffConfigs(rec, *latest, mask)
+		return diff, err == nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return ConfigDiff{}, false, err
+	}
+	return ConfigDiff{}, false, nil
+}
+
+func diffConfigs(from, to Record, mask bool) (ConfigDiff, error) {
+	if from.Type != "config" || to.Type != "config" {
+		return ConfigDiff{}, errors.New("both records must be config records")
+	}
+
+	var fromPayload, toPayload collectors.ConfigPayload
+	if err := collectors.ParseJSON(from.Payload, &fromPayload); err != nil {
+		return ConfigDiff{}, fmt.Errorf("record %d: %w", from.ID, err)
+	}
+	if err := collectors.ParseJSON(to.Payload, &toPayload); err != nil {
+		return ConfigDiff{}, fmt.Errorf("record %d: %w", to.ID, err)
+	}
+
+	fromText, toText := fromPayload.Snapshot, toPayload.Snapshot
+	fromCfg, fromErr := NormalizeConfig(fromPayload.Source, fromText)
+	toCfg, toErr := NormalizeConfig(toPayload.Source, toText)
+	if mask {
+		fromText, toText = maskSecretLines(fromText), maskSecretLines(toText)
+		if fromErr == nil {
+			fromText = fromCfg.Redacted()
+		}
+		if toErr == nil {
+			toText = toCfg.Redacted()
+		}
+	}
+
+	source := toPayload.Source
+	if source == "" {
+		source = fromPayload.Source
+	}
+
+	diff := UnifiedDiff(fromText, toText,
+		fmt.Sprintf("%s@%d", fromPayload.Source, from.ID),
+		fmt.Sprintf("%s@%d", toPayload.Source, to.ID))
+
+	d := ConfigDiff{
+		Source:  source,
+		FromID:  from.ID,
+		ToID:    to.ID,
+		FromTS:  from.Timestamp,
+		ToTS:    to.Timestamp,
+		Changed: fromPayload.Snapshot != toPayload.Snapshot,
+		Masked:  mask,
+		Diff:    diff,
+	}
+	if fromErr == nil && toErr == nil {
+		d.Format = toCfg.Format
+		d.Keys = DiffConfigKeys(fromCfg, toCfg, mask)
+	}
+	return d, nil
+}
+
+type diffOp struct {
+	kind byte // ' ', '-', '+'
+	line string
+}
+
+// UnifiedDiff renders a line-based unified diff of a and b with three lines
+// of context. It returns an empty string when the inputs are identical.
+func UnifiedDiff(a, b, fromLabel, toLabel string) string {
+	if a == b {
+		return ""
+	}
+
+	ops := diffLines(splitLines(a), splitLines(b))
+
+	var sb strings.Builder
+	sb.WriteString("--- " + fromLabel + "\n")
+	sb.WriteString("+++ " + toLabel + "\n")
+
+	aLine, bLine := 1, 1
+	i := 0
+	for i < len(ops) {
+		// Skip unchanged lines outside the context window.
+		start := i
+		for start < len(ops) && ops[start].kind == ' ' {
+			start++
+		}
+		if start == len(ops) {
+			break
+		}
+		ctxStart := start - diffContextLines
+		if ctxStart < i {
+			ctxStart = i
+		}
+		for k := i; k < ctxStart; k++ {
+			aLine++
+			bLine++
+		}
+
+		// Extend the hunk until a run of unchanged lines exceeds twice the context.
+		end := start
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			run := end
+			for run < len(ops) && ops[run].kind == ' ' {
+				run++
+			}
+			if run == len(ops) || run-end > 2*diffContextLines {
+				break
+			}
+			end = run
+		}
+		ctxEnd := end + diffContextLines
+		if ctxEnd > len(ops) {
+			ctxEnd = len(ops)
+		}
+
+		aCount, bCount := 0, 0
+		for _, op := range ops[ctxStart:ctxEnd] {
+			if op.kind != '+' {
+				aCount++
+			}
+			if op.kind != '-' {
+				bCount++
+			}
+		}
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n", hunkRange(aLine, aCount), hunkRange(bLine, bCount))
+		for _, op := range ops[ctxStart:ctxEnd] {
+			sb.WriteByte(op.kind)
+			sb.WriteString(op.line)
+			sb.WriteByte('\n')
+		}
+
+		aLine += aCount
+		bLine += bCount
+		i = ctxEnd
+	}
+
+	return sb.String()
+}
+
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start-1)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// diffLines computes a minimal edit script using the longest common
+// subsequence of the two line slices.
+func diffLines(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	ops := make([]diffOp, 0, n+m)
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{kind: ' ', line: a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{kind: '-', line: a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{kind: '+', line: b[j]})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		ops = append(ops, diffOp{kind: '-', line: a[i]})
+	}
+	for ; j < m; j++ {
+		ops = append(ops, diffOp{kind: '+', line: b[j]})
+	}
+	return ops
+}
@@ -0,0 +1,80 @@
+package ledger
+
+import (
+	"fmt"
+
+	"github.com/Retr0-XD/StateLedger/internal/collectors"
+)
+
+// ConfigKeyChange is a value a config key took on, starting at RecordID.
+type ConfigKeyChange struct {
+	RecordID  int64  `json:"record_id"`
+	Timestamp int64  `json:"ts"`
+	Format    string `json:"format"`
+	// Present is false when the snapshot does not set the key.
+	Present bool   `json:"present"`
+	Value   string `json:"value,omitempty"`
+}
+
+// ConfigKeyHistory is the history of one key across the config snapshots
+// of a source. Changes holds the first snapshot and every snapshot where
+// the value differs from the one before.
+type ConfigKeyHistory struct {
+	Source    string `json:"source"`
+	Key       string `json:"key"`
+	Masked    bool   `json:"masked"`
+	Snapshots int    `json:"snapshots"`
+	// Unparsed counts snapshots that could not be parsed and were skipped.
+	Unparsed int               `json:"unparsed,omitempty"`
+	Changes  []ConfigKeyChange `json:"changes"`
+}
+
+// ConfigKeyHistory parses every config snapshot of source, oldest first,
+// and returns the values of key, a dotted path such as
+// "database.pool_size", as found by NormalizedConfig.Lookup. When mask is
+// set, values of sensitive keys are masked, but changes to them are still
+// reported.
+func (l *Ledger) ConfigKeyHistory(source, key string, mask bool) (ConfigKeyHistory, error) {
+	history := ConfigKeyHistory{Source: source, Key: key, Masked: mask, Changes: []ConfigKeyChange{}}
+	rows, err := l.readQuery(`SELECT id, ts, payload FROM ledger_records WHERE type = 'config' ORDER BY id`)
+	if err != nil {
+		return history, err
+	}
+	defer rows.Close()
+
+	var last *ConfigKeyChange
+	for rows.Next() {
+		var id, ts int64
+		var payload string
+		if err := rows.Scan(&id, &ts, &payload); err != nil {
+			return history, err
+		}
+		var cp collectors.ConfigPayload
+		if err := collectors.ParseJSON(payload, &cp); err != nil || cp.Source != source {
+			continue
+		}
+		history.Snapshots++
+
+		cfg, err := NormalizeConfig(cp.Source, cp.Snapshot)
+		if err != nil {
+			history.Unparsed++
+			continue
+		}
+		value, ok := cfg.Lookup(key)
+		if last != nil && last.Present == ok && last.Value == value {
+			continue
+		}
+		last = &ConfigKeyChange{Present: ok, Value: value}
+		if mask {
+			value, _ = cfg.Masked().Lookup(key)
+		}
+		history.Changes = append(history.Changes, ConfigKeyChange{RecordID: id, Timestamp: ts, Format: cfg.Format, Present: ok, Value: value})
+	}
+	if err := rows.Err(); err != nil {
+		return history, err
+	}
+	if history.Snapshots == 0 {
+		return history, fmt.Errorf("no config snapshots for source %s", source)
+	}
+	return history, nil
+}
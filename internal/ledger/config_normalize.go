@@ -0,0 +1,580 @@
+package ledger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Config snapshot formats understood by NormalizeConfig.
+const (
+	ConfigFormatJSON = "json"
+	ConfigFormatYAML = "yaml"
+	ConfigFormatTOML = "toml"
+	ConfigFormatEnv  = "env"
+)
+
+// NormalizedConfig is a config snapshot parsed into dotted key paths, so
+// snapshots compare key by key whatever their format or layout.
+type NormalizedConfig struct {
+	Format string `json:"format"`
+	// Values maps the path of each scalar, e.g. "database.pool_size" or
+	// "hosts.0", to its unquoted value. Mappings and lists have no entry of
+	// their own.
+	Values map[string]string `json:"values"`
+
+	snapshot string
+	entries  []configEntry
+}
+
+// configEntry is a scalar in a snapshot and the byte range of its raw text.
+type configEntry struct {
+	path       string
+	value      string
+	start, end int
+}
+
+// NormalizeConfig parses a config snapshot. The format comes from the
+// extension of name (.json, .yaml, .yml, .toml, .env) or, failing that, from
+// the content. YAML, TOML and env files are read leniently: anchors, flow
+// mappings and inline tables are kept as written rather than expanded.
+func NormalizeConfig(name, snapshot string) (NormalizedConfig, error) {
+	c := NormalizedConfig{Format: DetectConfigFormat(name, snapshot), Values: map[string]string{}, snapshot: snapshot}
+	var err error
+	switch c.Format {
+	case ConfigFormatJSON:
+		c.entries, err = parseJSONConfig(snapshot)
+	case ConfigFormatTOML:
+		c.entries, err = parseTOMLConfig(snapshot)
+	case ConfigFormatEnv:
+		c.entries = parseEnvConfig(snapshot)
+	default:
+		c.entries = parseYAMLConfig(snapshot)
+	}
+	if err != nil {
+		return NormalizedConfig{}, fmt.Errorf("parse %s config: %w", c.Format, err)
+	}
+	// Later assignments win, as when an env file is sourced.
+	for _, e := range c.entries {
+		c.Values[e.path] = e.value
+	}
+	return c, nil
+}
+
+var (
+	envLinePattern    = regexp.MustCompile(`^(export\s+)?[A-Za-z_][A-Za-z0-9_.]*=`)
+	tomlHeaderPattern = regexp.MustCompile(`^\[\[?[^\]]+\]\]?$`)
+	tomlAssignPattern = regexp.MustCompile(`^[A-Za-z0-9_.\-"' ]+=`)
+	yamlKeyPattern    = regexp.MustCompile(`^\s*(- )?[A-Za-z0-9_.\-"']+:(\s|$)`)
+)
+
+// DetectConfigFormat returns the format of a snapshot named name. Valid
+// JSON is always read as JSON. Otherwise the extension decides, and without
+// a known one env files are detected by every line being a KEY=value
+// assignment, TOML by table headers or "key = value"
+// lines without YAML keys, and anything else is read as YAML.
+func DetectConfigFormat(name, snapshot string) string {
+	// JSON is also valid YAML, so a .yaml file holding JSON is read as JSON.
+	trimmed := strings.TrimSpace(snapshot)
+	if (strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[")) && json.Valid([]byte(trimmed)) {
+		return ConfigFormatJSON
+	}
+	base := strings.ToLower(filepath.Base(name))
+	switch filepath.Ext(base) {
+	case ".json":
+		return ConfigFormatJSON
+	case ".yaml", ".yml":
+		return ConfigFormatYAML
+	case ".toml":
+		return ConfigFormatTOML
+	case ".env":
+		return ConfigFormatEnv
+	}
+	if strings.HasPrefix(base, ".env") {
+		return ConfigFormatEnv
+	}
+
+	env, toml := true, false
+	for _, line := range strings.Split(trimmed, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if yamlKeyPattern.MatchString(line) {
+			return ConfigFormatYAML
+		}
+		if !envLinePattern.MatchString(line) {
+			env = false
+		}
+		if tomlHeaderPattern.MatchString(line) || tomlAssignPattern.MatchString(line) {
+			toml = true
+		}
+	}
+	switch {
+	case env && trimmed != "":
+		return ConfigFormatEnv
+	case toml:
+		return ConfigFormatTOML
+	}
+	return ConfigFormatYAML
+}
+
+// Lookup returns the value at the dotted key. Env files also match the key
+// in upper case with '.' and '-' as '_', so database.pool_size finds
+// DATABASE_POOL_SIZE. A key naming a mapping or list returns its scalars as
+// a JSON object keyed by the rest of their paths.
+func (c NormalizedConfig) Lookup(key string) (string, bool) {
+	if v, ok := c.Values[key]; ok {
+		return v, true
+	}
+	if c.Format == ConfigFormatEnv {
+		if v, ok := c.Values[strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(key))]; ok {
+			return v, true
+		}
+	}
+	sub := map[string]string{}
+	for path, v := range c.Values {
+		if rest, ok := strings.CutPrefix(path, key+"."); ok {
+			sub[rest] = v
+		}
+	}
+	if len(sub) == 0 {
+		return "", false
+	}
+	out, _ := json.Marshal(sub)
+	return string(out), true
+}
+
+// Masked returns a copy of c with the values of sensitive key paths
+// replaced by "****".
+func (c NormalizedConfig) Masked() NormalizedConfig {
+	masked := c
+	masked.Values = make(map[string]string, len(c.Values))
+	for path, v := range c.Values {
+		if sensitiveConfigPath(path) {
+			v = "****"
+		}
+		masked.Values[path] = v
+	}
+	return masked
+}
+
+// Redacted returns the snapshot text with the values of sensitive key paths
+// masked in place, keeping comments and layout. A path is sensitive when
+// any of its segments looks like a password, token or key, so every value
+// under a "credentials" mapping is masked.
+func (c NormalizedConfig) Redacted() string {
+	var sb strings.Builder
+	pos := 0
+	for _, e := range c.entries {
+		if !sensitiveConfigPath(e.path) || e.start < pos {
+			continue
+		}
+		sb.WriteString(c.snapshot[pos:e.start])
+		switch q := c.snapshot[e.start]; q {
+		case '"', '\'':
+			sb.WriteString(string(q) + "****" + string(q))
+		default:
+			sb.WriteString("****")
+		}
+		pos = e.end
+	}
+	sb.WriteString(c.snapshot[pos:])
+	return sb.String()
+}
+
+func sensitiveConfigPath(path string) bool {
+	for _, segment := range strings.Split(path, ".") {
+		if sensitiveKeyPattern.MatchString(segment) {
+			return true
+		}
+	}
+	return false
+}
+
+// ConfigKeyDiff is a key whose value differs between two config snapshots.
+type ConfigKeyDiff struct {
+	Key string `json:"key"`
+	// Change is "added", "removed" or "changed".
+	Change string `json:"change"`
+	From   string `json:"from,omitempty"`
+	To     string `json:"to,omitempty"`
+}
+
+// String renders the change as "+ key = value", "- key = value" or
+// "~ key: old -> new".
+func (d ConfigKeyDiff) String() string {
+	switch d.Change {
+	case "added":
+		return fmt.Sprintf("+ %s = %s", d.Key, d.To)
+	case "removed":
+		return fmt.Sprintf("- %s = %s", d.Key, d.From)
+	}
+	return fmt.Sprintf("~ %s: %s -> %s", d.Key, d.From, d.To)
+}
+
+// DiffConfigKeys compares two normalized snapshots key by key, sorted by
+// key. When mask is set, values of sensitive keys are masked, but changes
+// to them are still reported.
+func DiffConfigKeys(from, to NormalizedConfig, mask bool) []ConfigKeyDiff {
+	shown := func(path, v string) string {
+		if mask && sensitiveConfigPath(path) {
+			return "****"
+		}
+		return v
+	}
+	diffs := []ConfigKeyDiff{}
+	for path, old := range from.Values {
+		cur, ok := to.Values[path]
+		switch {
+		case !ok:
+			diffs = append(diffs, ConfigKeyDiff{Key: path, Change: "removed", From: shown(path, old)})
+		case cur != old:
+			diffs = append(diffs, ConfigKeyDiff{Key: path, Change: "changed", From: shown(path, old), To: shown(path, cur)})
+		}
+	}
+	for path, cur := range to.Values {
+		if _, ok := from.Values[path]; !ok {
+			diffs = append(diffs, ConfigKeyDiff{Key: path, Change: "added", To: shown(path, cur)})
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Key < diffs[j].Key })
+	return diffs
+}
+
+func joinConfigPath(prefix, segment string) string {
+	if prefix == "" {
+		return segment
+	}
+	return prefix + "." + segment
+}
+
+// configLine is a line of a snapshot without its line ending, and the
+// offset of its first byte.
+type configLine struct {
+	text string
+	off  int
+}
+
+func configLines(s string) []configLine {
+	var lines []configLine
+	off := 0
+	for _, text := range strings.SplitAfter(s, "\n") {
+		lines = append(lines, configLine{text: strings.TrimRight(text, "\r\n"), off: off})
+		off += len(text)
+	}
+	return lines
+}
+
+// stripConfigComment removes a '#' comment outside of quotes. With
+// afterSpace, as in YAML and env files, '#' only starts a comment at the
+// start of the line or after whitespace.
+func stripConfigComment(line string, afterSpace bool) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (!afterSpace || i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func unquoteConfigValue(v string) string {
+	if len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"' {
+		if s, err := strconv.Unquote(v); err == nil {
+			return s
+		}
+		return v[1 : len(v)-1]
+	}
+	if len(v) >= 2 && v[0] == '\'' && v[len(v)-1] == '\'' {
+		return strings.ReplaceAll(v[1:len(v)-1], "''", "'")
+	}
+	return v
+}
+
+// parseJSONConfig walks a JSON document token by token, recording where
+// each scalar starts and ends.
+func parseJSONConfig(s string) ([]configEntry, error) {
+	type frame struct {
+		prefix  string
+		array   bool
+		index   int
+		key     string
+		wantKey bool
+	}
+	var stack []frame
+	valuePath := func() string {
+		if len(stack) == 0 {
+			return ""
+		}
+		f := stack[len(stack)-1]
+		if f.array {
+			return joinConfigPath(f.prefix, strconv.Itoa(f.index))
+		}
+		return joinConfigPath(f.prefix, f.key)
+	}
+	afterValue := func() {
+		if len(stack) == 0 {
+			return
+		}
+		if f := &stack[len(stack)-1]; f.array {
+			f.index++
+		} else {
+			f.wantKey = true
+		}
+	}
+
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	var entries []configEntry
+	for {
+		before := int(dec.InputOffset())
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if n := len(stack); n > 0 && stack[n-1].wantKey {
+			if tok == json.Delim('}') {
+				stack = stack[:n-1]
+				afterValue()
+				continue
+			}
+			stack[n-1].key, stack[n-1].wantKey = tok.(string), false
+			continue
+		}
+
+		var value string
+		switch t := tok.(type) {
+		case json.Delim:
+			switch t {
+			case '{':
+				stack = append(stack, frame{prefix: valuePath(), wantKey: true})
+			case '[':
+				stack = append(stack, frame{prefix: valuePath(), array: true})
+			default:
+				stack = stack[:len(stack)-1]
+				afterValue()
+			}
+			continue
+		case string:
+			value = t
+		case json.Number:
+			value = t.String()
+		case bool:
+			value = strconv.FormatBool(t)
+		case nil:
+			value = "null"
+		}
+		start := before + len(s[before:]) - len(strings.TrimLeft(s[before:], " \t\r\n:,"))
+		entries = append(entries, configEntry{path: valuePath(), value: value, start: start, end: int(dec.InputOffset())})
+		afterValue()
+	}
+	if len(stack) > 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return entries, nil
+}
+
+// parseYAMLConfig reads block mappings and lists by indentation. Lists are
+// indexed by position, and block scalars (| and >) are read as one value.
+func parseYAMLConfig(s string) []configEntry {
+	type level struct {
+		indent int
+		path   string
+		items  int
+	}
+	lines := configLines(s)
+	stack := []level{{indent: -1}}
+	var entries []configEntry
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		text := strings.TrimRight(stripConfigComment(line.text, true), " \t")
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" || trimmed == "---" || trimmed == "..." {
+			continue
+		}
+		indent := len(text) - len(trimmed)
+		end := line.off + len(text)
+
+		if item, ok := strings.CutPrefix(trimmed, "-"); ok && (item == "" || item[0] == ' ') {
+			for len(stack) > 1 && stack[len(stack)-1].indent > indent {
+				stack = stack[:len(stack)-1]
+			}
+			top := &stack[len(stack)-1]
+			path := joinConfigPath(top.path, strconv.Itoa(top.items))
+			top.items++
+			item = strings.TrimLeft(item, " ")
+			switch k, rest, isKey := strings.Cut(item, ":"); {
+			case item == "":
+				stack = append(stack, level{indent: indent, path: path})
+				continue
+			case isKey && (rest == "" || rest[0] == ' ') && !(item[0] == '"' || item[0] == '\'') && k != "":
+				// "- key: value" starts a mapping whose keys line up with key.
+				col := indent + len(trimmed) - len(item)
+				stack = append(stack, level{indent: col - 1, path: path})
+				trimmed, indent = item, col
+			default:
+				entries = append(entries, configEntry{path: path, value: unquoteConfigValue(item), start: end - len(item), end: end})
+				continue
+			}
+		}
+
+		for stack[len(stack)-1].indent >= indent {
+			stack = stack[:len(stack)-1]
+		}
+		k, value, ok := strings.Cut(trimmed, ":")
+		if !ok || (value != "" && value[0] != ' ') {
+			continue
+		}
+		path := joinConfigPath(stack[len(stack)-1].path, unquoteConfigValue(strings.TrimSpace(k)))
+		value = strings.TrimSpace(value)
+		start := end - len(value)
+		switch {
+		case value == "":
+			stack = append(stack, level{indent: indent, path: path})
+		case value[0] == '|' || value[0] == '>':
+			// A block scalar holds the lines indented below the key.
+			var block []string
+			for i+1 < len(lines) {
+				next := strings.TrimRight(lines[i+1].text, " \t")
+				if strings.TrimSpace(next) != "" && len(next)-len(strings.TrimLeft(next, " ")) <= indent {
+					break
+				}
+				i++
+				if strings.TrimSpace(next) != "" {
+					end = lines[i].off + len(next)
+				}
+				block = append(block, strings.TrimSpace(next))
+			}
+			sep := "\n"
+			if value[0] == '>' {
+				sep = " "
+			}
+			entries = append(entries, configEntry{path: path, value: strings.TrimSpace(strings.Join(block, sep)), start: start, end: end})
+		default:
+			entries = append(entries, configEntry{path: path, value: unquoteConfigValue(value), start: start, end: end})
+		}
+	}
+	return entries
+}
+
+// parseTOMLConfig reads tables, arrays of tables (indexed like lists),
+// dotted keys, and multi-line strings and arrays. Inline arrays and tables
+// are kept as one value, as written.
+func parseTOMLConfig(s string) ([]configEntry, error) {
+	lines := configLines(s)
+	table := ""
+	arrays := map[string]int{}
+	var entries []configEntry
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		text := strings.TrimRight(stripConfigComment(line.text, false), " \t")
+		trimmed := strings.TrimSpace(text)
+		switch {
+		case trimmed == "":
+			continue
+		case strings.HasPrefix(trimmed, "[[") && strings.HasSuffix(trimmed, "]]"):
+			name := tomlKey(trimmed[2 : len(trimmed)-2])
+			table = joinConfigPath(name, strconv.Itoa(arrays[name]))
+			arrays[name]++
+			continue
+		case strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]"):
+			table = tomlKey(trimmed[1 : len(trimmed)-1])
+			continue
+		}
+
+		eq := strings.IndexByte(stripConfigComment(text, false), '=')
+		if eq < 0 || strings.TrimSpace(text[:eq]) == "" {
+			return nil, fmt.Errorf("line %d: expected key = value", i+1)
+		}
+		path := joinConfigPath(table, tomlKey(text[:eq]))
+		value := strings.TrimSpace(text[eq+1:])
+		start := line.off + len(text) - len(value)
+		end := line.off + len(text)
+
+		switch {
+		case strings.HasPrefix(value, `"""`) || strings.HasPrefix(value, "'''"):
+			delim := value[:3]
+			raw := value
+			for !strings.Contains(raw[3:], delim) {
+				if i+1 >= len(lines) {
+					return nil, fmt.Errorf("line %d: unterminated multi-line string", i+1)
+				}
+				i++
+				raw += "\n" + lines[i].text
+			}
+			raw = raw[:3+strings.Index(raw[3:], delim)+3]
+			end = start + len(raw)
+			value = strings.TrimPrefix(raw[3:len(raw)-3], "\n")
+		case strings.HasPrefix(value, "["):
+			for strings.Count(value, "[") > strings.Count(value, "]") {
+				if i+1 >= len(lines) {
+					return nil, fmt.Errorf("line %d: unterminated array", i+1)
+				}
+				i++
+				next := strings.TrimRight(stripConfigComment(lines[i].text, false), " \t")
+				value += " " + strings.TrimSpace(next)
+				end = lines[i].off + len(next)
+			}
+		default:
+			value = unquoteConfigValue(value)
+		}
+		entries = append(entries, configEntry{path: path, value: value, start: start, end: end})
+	}
+	return entries, nil
+}
+
+// tomlKey turns a bare, quoted or dotted TOML key into a key path.
+func tomlKey(key string) string {
+	parts := strings.Split(strings.TrimSpace(key), ".")
+	for i, p := range parts {
+		parts[i] = unquoteConfigValue(strings.TrimSpace(p))
+	}
+	return strings.Join(parts, ".")
+}
+
+// parseEnvConfig reads KEY=value lines, with an optional "export " prefix.
+// Keys are kept as written.
+func parseEnvConfig(s string) []configEntry {
+	var entries []configEntry
+	for _, line := range configLines(s) {
+		text := strings.TrimSpace(line.text)
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		eq := strings.IndexByte(line.text, '=')
+		if eq < 0 {
+			continue
+		}
+		name := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line.text[:eq]), "export "))
+		raw := line.text[eq+1:]
+		value := strings.TrimSpace(raw)
+		if value != "" && value[0] != '"' && value[0] != '\'' {
+			value = strings.TrimSpace(stripConfigComment(value, true))
+		}
+		start := line.off + eq + 1 + len(raw) - len(strings.TrimLeft(raw, " \t"))
+		entries = append(entries, configEntry{path: name, value: unquoteConfigValue(value), start: start, end: start + len(value)})
+	}
+	return entries
+}
@@ -0,0 +1,305 @@
+package ledger
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Retr0-XD/StateLedger/internal/collectors"
+)
+
+// CoverageAllSources is the Source of the CoverageDay rows that combine
+// every source, as reconstruction does.
+const CoverageAllSources = "*"
+
+// CoverageDay is the reconstruction coverage of one source, or of all
+// sources, at the end of a UTC day.
+type CoverageDay struct {
+	Date   string `json:"date"`
+	Source string `json:"source"`
+	// Records counts the records timestamped that day, and Total those
+	// timestamped up to its end.
+	Records int64 `json:"records"`
+	Total   int64 `json:"total_records"`
+	CoverageReport
+	DeterminismScore float64 `json:"determinism_score"`
+}
+
+// coverageFact is what coverage needs to know of one record.
+type coverageFact struct {
+	id, ts     int64
+	source     string
+	kind       string
+	timeSource string
+	missingRef bool
+}
+
+// coverageState accumulates the facts of one source, or of all sources.
+type coverageState struct {
+	coverage   CoverageReport
+	records    int64
+	total      int64
+	env        *collectors.EnvironmentPayload
+	envID      int64
+	missingRef bool
+}
+
+// add accumulates f; today is set when f is timestamped on the day being
+// reported.
+func (s *coverageState) add(f coverageFact, today bool) {
+	if today {
+		s.records++
+	}
+	s.total++
+	switch f.kind {
+	case "code":
+		s.coverage.HasCode = true
+	case "config":
+		s.coverage.HasConfig = true
+	case "environment":
+		s.coverage.HasEnvironment = true
+		// Reconstruction keeps the environment with the highest ID.
+		if f.id > s.envID {
+			s.env, s.envID = &collectors.EnvironmentPayload{TimeSource: f.timeSource}, f.id
+		}
+	case "mutation":
+		s.coverage.HasMutations = true
+		s.missingRef = s.missingRef || f.missingRef
+	}
+}
+
+func (s *coverageState) day(date, source string) CoverageDay {
+	c := s.coverage
+	c.Complete = c.HasCode && c.HasConfig && c.HasEnvironment && c.HasMutations
+	return CoverageDay{
+		Date:             date,
+		Source:           source,
+		Records:          s.records,
+		Total:            s.total,
+		CoverageReport:   c,
+		DeterminismScore: determinismScore(c, s.env, s.missingRef),
+	}
+}
+
+// CoverageHistory reports, for each UTC day from the day of from to the day
+// of to, the coverage and determinism score that reconstructing at the end
+// of the day would give, for all sources combined (CoverageAllSources) and
+// for each source on its own. A source has rows from the first day it has
+// records. Rows are ordered by date, then source, with the combined row
+// first.
+func (l *Ledger) CoverageHistory(from, to time.Time) ([]CoverageDay, error) {
+	from, to = from.UTC(), to.UTC()
+	start := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	end := time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, 1)
+	if !end.After(start) {
+		return nil, errors.New("to is before from")
+	}
+
+	facts, err := l.coverageFacts(end.Unix() - 1)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(facts, func(i, j int) bool { return facts[i].ts < facts[j].ts })
+
+	all := &coverageState{}
+	bySource := map[string]*coverageState{}
+	var days []CoverageDay
+	next := 0
+	for day := start; day.Before(end); day = day.AddDate(0, 0, 1) {
+		dayStart, dayEnd := day.Unix(), day.AddDate(0, 0, 1).Unix()
+		all.records = 0
+		for _, s := range bySource {
+			s.records = 0
+		}
+		for ; next < len(facts) && facts[next].ts < dayEnd; next++ {
+			f := facts[next]
+			s := bySource[f.source]
+			if s == nil {
+				s = &coverageState{}
+				bySource[f.source] = s
+			}
+			all.add(f, f.ts >= dayStart)
+			s.add(f, f.ts >= dayStart)
+		}
+
+		date := day.Format(time.DateOnly)
+		days = append(days, all.day(date, CoverageAllSources))
+		sources := make([]string, 0, len(bySource))
+		for source := range bySource {
+			sources = append(sources, source)
+		}
+		sort.Strings(sources)
+		for _, source := range sources {
+			days = append(days, bySource[source].day(date, source))
+		}
+	}
+	return days, nil
+}
+
+// coverageFacts reads the coverage facts of archived and local records
+// timestamped at or before until. Payloads that do not parse are skipped,
+// as reconstruction skips them.
+func (l *Ledger) coverageFacts(until int64) ([]coverageFact, error) {
+	var facts []coverageFact
+	add := func(rec Record) {
+		f := coverageFact{id: rec.ID, ts: rec.Timestamp, source: rec.Source, kind: rec.Type}
+		switch rec.Type {
+		case "code", "config":
+			var p map[string]any
+			if collectors.ParseJSON(rec.Payload, &p) != nil {
+				return
+			}
+		case "environment":
+			var ep collectors.EnvironmentPayload
+			if collectors.ParseJSON(rec.Payload, &ep) != nil {
+				return
+			}
+			f.timeSource = ep.TimeSource
+		case "mutation":
+			var mp collectors.MutationPayload
+			if collectors.ParseJSON(rec.Payload, &mp) != nil {
+				return
+			}
+			f.missingRef = strings.TrimSpace(mp.ExternalRef) == ""
+		}
+		facts = append(facts, f)
+	}
+
+	archived, err := l.archivedRecords(ListQuery{Until: until})
+	if err != nil {
+		return nil, err
+	}
+	for _, rec := range archived {
+		add(rec)
+	}
+
+	rows, err := l.readQuery(`SELECT id, ts, type, source, payload FROM ledger_records WHERE ts <= ? ORDER BY id`, until)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var rec Record
+		if err := rows.Scan(&rec.ID, &rec.Timestamp, &rec.Type, &rec.Source, &rec.Payload); err != nil {
+			return nil, err
+		}
+		add(rec)
+	}
+	return facts, rows.Err()
+}
+
+// WriteCoverageCSV writes coverage rows as CSV with a header row, for
+// spreadsheets. Booleans are written as 1 and 0, and sources that a
+// spreadsheet would evaluate as a formula are prefixed with a quote.
+func WriteCoverageCSV(w io.Writer, days []CoverageDay) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"date", "source", "records", "total_records", "has_code", "has_config", "has_environment", "has_mutations", "complete", "determinism_score"})
+	flag := func(b bool) string {
+		if b {
+			return "1"
+		}
+		return "0"
+	}
+	for _, d := range days {
+		_ = cw.Write([]string{
+			d.Date,
+			csvText(d.Source),
+			strconv.FormatInt(d.Records, 10),
+			strconv.FormatInt(d.Total, 10),
+			flag(d.HasCode),
+			flag(d.HasConfig),
+			flag(d.HasEnvironment),
+			flag(d.HasMutations),
+			flag(d.Complete),
+			strconv.FormatFloat(d.DeterminismScore, 'f', 1, 64),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// csvText keeps spreadsheets from evaluating a cell that starts like a
+// formula.
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// SourceCoverage summarizes the daily coverage of one source, or of all
+// sources, over a range of days, to rank sources by how reconstructable
+// their state is.
+type SourceCoverage struct {
+	Source string `json:"source"`
+	// Days counts the days of the range on which the source had records
+	// up to the end of the day, and CompleteDays those with complete
+	// coverage.
+	Days         int `json:"days"`
+	CompleteDays int `json:"complete_days"`
+	// Records counts the records timestamped within the range.
+	Records int64 `json:"records"`
+	// AvgDeterminismScore and MinDeterminismScore are taken over Days.
+	AvgDeterminismScore float64 `json:"avg_determinism_score"`
+	MinDeterminismScore float64 `json:"min_determinism_score"`
+	// Latest is the coverage at the end of the range.
+	Latest CoverageDay `json:"latest"`
+	// Series holds the daily rows when they were asked for.
+	Series []CoverageDay `json:"series,omitempty"`
+}
+
+// SummarizeCoverage summarizes the rows of CoverageHistory per source. It
+// returns the summary of all sources combined and one per source, least
+// reconstructable first: by average determinism score, then complete days,
+// then source. With series set, each summary keeps its daily rows.
+func SummarizeCoverage(days []CoverageDay, series bool) (SourceCoverage, []SourceCoverage) {
+	bySource := map[string]*SourceCoverage{}
+	var order []string
+	for _, d := range days {
+		s := bySource[d.Source]
+		if s == nil {
+			s = &SourceCoverage{Source: d.Source, MinDeterminismScore: d.DeterminismScore}
+			bySource[d.Source] = s
+			order = append(order, d.Source)
+		}
+		s.Days++
+		if d.Complete {
+			s.CompleteDays++
+		}
+		s.Records += d.Records
+		s.AvgDeterminismScore += d.DeterminismScore
+		s.MinDeterminismScore = min(s.MinDeterminismScore, d.DeterminismScore)
+		s.Latest = d
+		if series {
+			s.Series = append(s.Series, d)
+		}
+	}
+
+	overall := SourceCoverage{Source: CoverageAllSources}
+	sources := make([]SourceCoverage, 0, len(order))
+	for _, source := range order {
+		s := bySource[source]
+		s.AvgDeterminismScore = math.Round(s.AvgDeterminismScore/float64(s.Days)*10) / 10
+		if source == CoverageAllSources {
+			overall = *s
+			continue
+		}
+		sources = append(sources, *s)
+	}
+	sort.Slice(sources, func(i, j int) bool {
+		a, b := sources[i], sources[j]
+		if a.AvgDeterminismScore != b.AvgDeterminismScore {
+			return a.AvgDeterminismScore < b.AvgDeterminismScore
+		}
+		if a.CompleteDays != b.CompleteDays {
+			return a.CompleteDays < b.CompleteDays
+		}
+		return a.Source < b.Source
+	})
+	return overall, sources
+}
@@ -0,0 +1,244 @@
+package ledger
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Retr0-XD/StateLedger/internal/collectors"
+)
+
+const credentialSchema = `
+CREATE TABLE IF NOT EXISTS ledger_credentials (
+	kind TEXT NOT NULL,
+	id TEXT NOT NULL,
+	created_at INTEGER NOT NULL DEFAULT 0,
+	created_by TEXT NOT NULL DEFAULT '',
+	revoked_at INTEGER NOT NULL DEFAULT 0,
+	revoked_by TEXT NOT NULL DEFAULT '',
+	revoke_reason TEXT NOT NULL DEFAULT '',
+	PRIMARY KEY(kind, id)
+);
+CREATE TABLE IF NOT EXISTS ledger_webhooks (
+	id TEXT PRIMARY KEY,
+	url TEXT NOT NULL,
+	events TEXT NOT NULL DEFAULT '[]',
+	secret TEXT NOT NULL DEFAULT '',
+	created_at INTEGER NOT NULL
+);
+`
+
+// Credential kinds.
+const (
+	// CredentialWebhook is a webhook subscription, by subscription ID.
+	CredentialWebhook = "webhook"
+	// CredentialAPIKey is an API key, by the fingerprint the API reports
+	// for it; the key itself is never stored.
+	CredentialAPIKey = "api_key"
+)
+
+// Record types of the credential lifecycle, with the credential kind as
+// source.
+const (
+	CredentialCreateRecordType  = "credential.create"
+	CredentialRevokeRecordType  = "credential.revoke"
+	CredentialRestoreRecordType = "credential.restore"
+)
+
+// ErrCredentialRevoked is returned when revoking a revoked credential, or
+// creating one whose ID was revoked; it must be restored instead, so its
+// history stays in one place. ErrCredentialActive is returned when
+// restoring a credential that is not revoked.
+var (
+	ErrCredentialRevoked = errors.New("credential is revoked")
+	ErrCredentialActive  = errors.New("credential is not revoked")
+)
+
+// Credential is the lifecycle of a webhook subscription or API key.
+// Credentials are revoked rather than deleted, so who revoked one and why
+// stays on record. CreatedAt is 0 for credentials that were configured
+// rather than created through the API.
+type Credential struct {
+	Kind         string `json:"kind"`
+	ID           string `json:"id"`
+	CreatedAt    int64  `json:"created_at,omitempty"`
+	CreatedBy    string `json:"created_by,omitempty"`
+	RevokedAt    int64  `json:"revoked_at,omitempty"`
+	RevokedBy    string `json:"revoked_by,omitempty"`
+	RevokeReason string `json:"revoke_reason,omitempty"`
+}
+
+// Revoked reports whether the credential is revoked.
+func (c Credential) Revoked() bool {
+	return c.RevokedAt != 0
+}
+
+type credentialRecord struct {
+	Kind   string `json:"kind"`
+	ID     string `json:"id"`
+	Actor  string `json:"actor,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// CreateCredential records that actor created the credential kind/id.
+// Creating an active credential again changes nothing; creating a revoked
+// one fails with ErrCredentialRevoked.
+func (l *Ledger) CreateCredential(kind, id, actor string) (Credential, error) {
+	return l.changeCredential(kind, id, actor, "", CredentialCreateRecordType, nil)
+}
+
+// RevokeCredential marks the credential kind/id revoked by actor for
+// reason. Credentials never created through the ledger, such as API keys
+// from the config file, can be revoked too.
+func (l *Ledger) RevokeCredential(kind, id, actor, reason string) (Credential, error) {
+	return l.changeCredential(kind, id, actor, reason, CredentialRevokeRecordType, nil)
+}
+
+// RestoreCredential lifts the revocation of the credential kind/id.
+func (l *Ledger) RestoreCredential(kind, id, actor, reason string) (Credential, error) {
+	return l.changeCredential(kind, id, actor, reason, CredentialRestoreRecordType, nil)
+}
+
+// changeCredential applies a lifecycle change and appends its record in
+// one transaction, in which also runs, when set, with the change applied.
+func (l *Ledger) changeCredential(kind, id, actor, reason, recordType string, also func(tx *sql.Tx) error) (Credential, error) {
+	id = strings.TrimSpace(id)
+	if kind != CredentialWebhook && kind != CredentialAPIKey {
+		return Credential{}, fmt.Errorf("unknown credential kind %q: use %s or %s", kind, CredentialWebhook, CredentialAPIKey)
+	}
+	if id == "" {
+		return Credential{}, errors.New("credential id required")
+	}
+	if err := l.ensureCredentialSchema(); err != nil {
+		return Credential{}, err
+	}
+	payload, err := collectors.MarshalPayload(credentialRecord{Kind: kind, ID: id, Actor: actor, Reason: reason})
+	if err != nil {
+		return Credential{}, err
+	}
+
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+
+	tx, err := l.db.Begin()
+	if err != nil {
+		return Credential{}, err
+	}
+	defer tx.Rollback()
+
+	cred, err := scanCredential(tx.QueryRow(`SELECT kind, id, created_at, created_by, revoked_at, revoked_by, revoke_reason
+		FROM ledger_credentials WHERE kind = ? AND id = ?`, kind, id))
+	exists := err == nil
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return Credential{}, err
+	}
+	if !exists {
+		cred = Credential{Kind: kind, ID: id}
+	}
+
+	now := time.Now().Unix()
+	switch recordType {
+	case CredentialCreateRecordType:
+		if cred.Revoked() {
+			return Credential{}, fmt.Errorf("%s %s: %w", kind, id, ErrCredentialRevoked)
+		}
+		if exists && also == nil {
+			return cred, nil
+		}
+		if exists {
+			// Nothing to record, but also still applies.
+			if err := also(tx); err != nil {
+				return Credential{}, err
+			}
+			if err := tx.Commit(); err != nil {
+				return Credential{}, err
+			}
+			return cred, l.Sync()
+		}
+		cred.CreatedAt, cred.CreatedBy = now, actor
+	case CredentialRevokeRecordType:
+		if cred.Revoked() {
+			return Credential{}, fmt.Errorf("%s %s: %w", kind, id, ErrCredentialRevoked)
+		}
+		cred.RevokedAt, cred.RevokedBy, cred.RevokeReason = now, actor, reason
+	case CredentialRestoreRecordType:
+		if !cred.Revoked() {
+			return Credential{}, fmt.Errorf("%s %s: %w", kind, id, ErrCredentialActive)
+		}
+		cred.RevokedAt, cred.RevokedBy, cred.RevokeReason = 0, "", ""
+	}
+
+	records, err := l.appendBatchTx(tx, []RecordInput{{
+		Timestamp: now,
+		Type:      recordType,
+		Source:    kind,
+		Payload:   payload,
+	}})
+	if err != nil {
+		return Credential{}, err
+	}
+	if _, err := tx.Exec(`INSERT INTO ledger_credentials(kind, id, created_at, created_by, revoked_at, revoked_by, revoke_reason)
+		VALUES(?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(kind, id) DO UPDATE SET revoked_at = excluded.revoked_at, revoked_by = excluded.revoked_by, revoke_reason = excluded.revoke_reason`,
+		kind, id, cred.CreatedAt, cred.CreatedBy, cred.RevokedAt, cred.RevokedBy, cred.RevokeReason); err != nil {
+		return Credential{}, err
+	}
+	if also != nil {
+		if err := also(tx); err != nil {
+			return Credential{}, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return Credential{}, err
+	}
+	l.invalidateListCache()
+	l.countAppended(records)
+	l.mirrorAppended(records)
+	l.journalAppended(records)
+	return cred, l.Sync()
+}
+
+// Credentials lists the credentials of kind, or of every kind when kind is
+// empty, by kind and ID. Revoked credentials are left out unless
+// includeRevoked is set.
+func (l *Ledger) Credentials(kind string, includeRevoked bool) ([]Credential, error) {
+	if err := l.ensureCredentialSchema(); err != nil {
+		return nil, err
+	}
+	rows, err := l.db.Query(`SELECT kind, id, created_at, created_by, revoked_at, revoked_by, revoke_reason
+		FROM ledger_credentials WHERE (? = '' OR kind = ?) AND (? OR revoked_at = 0) ORDER BY kind, id`,
+		kind, kind, includeRevoked)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	creds := []Credential{}
+	for rows.Next() {
+		cred, err := scanCredential(rows)
+		if err != nil {
+			return nil, err
+		}
+		creds = append(creds, cred)
+	}
+	return creds, rows.Err()
+}
+
+func scanCredential(row interface{ Scan(...any) error }) (Credential, error) {
+	var c Credential
+	err := row.Scan(&c.Kind, &c.ID, &c.CreatedAt, &c.CreatedBy, &c.RevokedAt, &c.RevokedBy, &c.RevokeReason)
+	return c, err
+}
+
+func (l *Ledger) ensureCredentialSchema() error {
+	if l.credentialsReady.Load() {
+		return nil
+	}
+	if _, err := l.db.Exec(credentialSchema); err != nil {
+		return err
+	}
+	l.credentialsReady.Store(true)
+	return nil
+}
@@ -1,6 +1,7 @@
 package ledger
 
 import (
+	"cmp"
 	"encoding/json"
 	"strings"
 
@@ -85,6 +86,10 @@ func AnalyzeCode(code *collectors.CodePayload) DeterminismAnalysis {
 		analysis.Score -= 40
 	}
 
+	if b := code.Build; b != nil {
+		analyzeBuild(b, &analysis)
+	}
+
 	if analysis.Score >= 80 {
 		analysis.RiskLevel = "low"
 		analysis.Recommendation = "Code version pinned; deterministic replay possible"
@@ -97,6 +102,38 @@ func AnalyzeCode(code *collectors.CodePayload) DeterminismAnalysis {
 	return analysis
 }
 
+// analyzeBuild penalizes build settings known to make the binary built
+// from a commit differ between builds.
+func analyzeBuild(b *collectors.BuildInfo, analysis *DeterminismAnalysis) {
+	if b.VCSModified {
+		analysis.Violations = append(analysis.Violations, "binary built from a modified working tree")
+		analysis.CanReplay = false
+		analysis.Score -= 40
+	}
+	if b.Compiler == "" {
+		analysis.Warnings = append(analysis.Warnings, "compiler version missing")
+		analysis.Score -= 15
+	} else if strings.Contains(b.Compiler, "devel") {
+		analysis.Warnings = append(analysis.Warnings, "development compiler "+b.Compiler)
+		analysis.Score -= 15
+	}
+	if !b.Trimpath {
+		analysis.Warnings = append(analysis.Warnings, "built without -trimpath (binary embeds build paths)")
+		analysis.Score -= 10
+	}
+	if b.CGOEnabled {
+		analysis.Warnings = append(analysis.Warnings, "cgo enabled (binary depends on the host C toolchain)")
+		analysis.ExternalDeps = append(analysis.ExternalDeps, "cc:"+cmp.Or(b.CC, "unknown"))
+		analysis.Score -= 10
+	}
+	for _, f := range strings.Fields(b.GOFLAGS) {
+		if f == "-mod=mod" {
+			analysis.Warnings = append(analysis.Warnings, "-mod=mod lets the build update go.mod dependencies")
+			analysis.Score -= 10
+		}
+	}
+}
+
 func AnalyzeConfig(config *collectors.ConfigPayload) DeterminismAnalysis {
 	analysis := DeterminismAnalysis{
 		Score:        100.0,
@@ -0,0 +1,207 @@
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"net/smtp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Retr0-XD/StateLedger/internal/collectors"
+)
+
+// Digest summarizes ledger activity over a period for people who do not
+// watch dashboards.
+type Digest struct {
+	From int64 `json:"from"`
+	To   int64 `json:"to"`
+	// Appended counts the records in the period by type.
+	Appended map[string]int64 `json:"appended"`
+	Total    int64            `json:"total"`
+	// Drift lists config snapshots in the period that changed from the
+	// previous snapshot of their source.
+	Drift        []ConfigDiff   `json:"drift"`
+	Verification VerifyResult   `json:"verification"`
+	Coverage     []SourceStatus `json:"coverage"`
+}
+
+// Digest summarizes records timestamped in (from, to], verifies the chain
+// and reports source coverage at to. Archived records are not counted.
+func (l *Ledger) Digest(from, to int64) (Digest, error) {
+	d := Digest{From: from, To: to, Appended: map[string]int64{}, Drift: []ConfigDiff{}}
+
+	rows, err := l.readQuery(`SELECT type, COUNT(*) FROM ledger_records WHERE ts > ? AND ts <= ? GROUP BY type`, from, to)
+	if err != nil {
+		return Digest{}, err
+	}
+	for rows.Next() {
+		var typ string
+		var n int64
+		if err := rows.Scan(&typ, &n); err != nil {
+			rows.Close()
+			return Digest{}, err
+		}
+		d.Appended[typ] = n
+		d.Total += n
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return Digest{}, err
+	}
+
+	if d.Drift, err = l.configDrift(from, to); err != nil {
+		return Digest{}, err
+	}
+	if d.Verification, err = l.VerifyChain(); err != nil {
+		return Digest{}, err
+	}
+	report, err := l.SourceStatus(to, DefaultNewSourceWindow)
+	if err != nil {
+		return Digest{}, err
+	}
+	d.Coverage = report.Sources
+	return d, nil
+}
+
+// configDrift diffs each config record timestamped in (from, to] against
+// the previous snapshot of its source.
+func (l *Ledger) configDrift(from, to int64) ([]ConfigDiff, error) {
+	rows, err := l.readQuery(`SELECT id, ts, type, source, payload, hash, prev_hash FROM ledger_records
+		WHERE type = 'config' AND ts > ? AND ts <= ? ORDER BY id ASC`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	var records []Record
+	for rows.Next() {
+		var rec Record
+		if err := rows.Scan(&rec.ID, &rec.Timestamp, &rec.Type, &rec.Source, &rec.Payload, &rec.Hash, &rec.PrevHash); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	drift := []ConfigDiff{}
+	last := map[string]Record{}
+	for _, rec := range records {
+		var cp collectors.ConfigPayload
+		if err := collectors.ParseJSON(rec.Payload, &cp); err != nil {
+			continue
+		}
+		prev, ok := last[cp.Source]
+		if !ok {
+			prev, ok, err = l.previousConfig(cp.Source, rec.ID)
+			if err != nil {
+				return nil, err
+			}
+		}
+		last[cp.Source] = rec
+		if !ok {
+			continue
+		}
+		diff, err := diffConfigs(prev, rec, true)
+		if err != nil {
+			return nil, err
+		}
+		if diff.Drifted() {
+			diff.Diff = ""
+			drift = append(drift, diff)
+		}
+	}
+	return drift, nil
+}
+
+// Text renders the digest as a plain-text email body, with times in UTC.
+func (d Digest) Text() string {
+	return d.TextIn(time.UTC)
+}
+
+// TextIn renders the digest as Text does, with times in loc.
+func (d Digest) TextIn(loc *time.Location) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "StateLedger digest for %s to %s\n\n", FormatTime(d.From, loc), FormatTime(d.To, loc))
+
+	fmt.Fprintf(&b, "Records appended: %d\n", d.Total)
+	types := make([]string, 0, len(d.Appended))
+	for typ := range d.Appended {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	for _, typ := range types {
+		fmt.Fprintf(&b, "  %-12s %d\n", typ, d.Appended[typ])
+	}
+
+	fmt.Fprintf(&b, "\nConfig drift: %d change(s)\n", len(d.Drift))
+	for _, diff := range d.Drift {
+		fmt.Fprintf(&b, "  %s: record %d -> %d at %s\n", diff.Source, diff.FromID, diff.ToID, FormatTime(diff.ToTS, loc))
+		for _, k := range diff.Keys {
+			fmt.Fprintf(&b, "    %s\n", k)
+		}
+	}
+
+	b.WriteString("\nChain verification: ")
+	if d.Verification.OK {
+		fmt.Fprintf(&b, "OK (%d records checked)\n", d.Verification.Checked)
+	} else {
+		fmt.Fprintf(&b, "FAILED at record %d: %s\n", d.Verification.FailedID, d.Verification.Reason)
+	}
+
+	b.WriteString("\nCoverage by source:\n")
+	if len(d.Coverage) == 0 {
+		b.WriteString("  no sources\n")
+	}
+	for _, src := range d.Coverage {
+		fmt.Fprintf(&b, "  %-20s %s\n", src.Name, src.Status)
+		for _, ks := range src.Kinds {
+			last := "never"
+			if ks.LastSeen > 0 {
+				last = FormatTime(ks.LastSeen, loc)
+			}
+			fmt.Fprintf(&b, "    %-16s %-8s last %s\n", ks.Kind, ks.Status, last)
+		}
+	}
+	return b.String()
+}
+
+// SMTPConfig is where digests are mailed.
+type SMTPConfig struct {
+	// Addr is the SMTP server as host:port.
+	Addr string
+	From string
+	To   []string
+	// Username and Password enable PLAIN authentication, which net/smtp
+	// only performs over TLS or to localhost.
+	Username string
+	Password string
+}
+
+// SendDigest mails the digest as plain text.
+func SendDigest(cfg SMTPConfig, d Digest) error {
+	if cfg.Addr == "" || cfg.From == "" || len(cfg.To) == 0 {
+		return errors.New("smtp address, sender and recipients are required")
+	}
+	var auth smtp.Auth
+	if cfg.Username != "" {
+		host, _, _ := strings.Cut(cfg.Addr, ":")
+		auth = smtp.PlainAuth("", cfg.Username, cfg.Password, host)
+	}
+
+	subject := "StateLedger digest: " + time.Unix(d.To, 0).UTC().Format("2006-01-02")
+	if !d.Verification.OK {
+		subject += " (chain verification FAILED)"
+	}
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", cfg.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(cfg.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().UTC().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(d.Text(), "\n", "\r\n"))
+
+	return smtp.SendMail(cfg.Addr, auth, cfg.From, cfg.To, []byte(msg.String()))
+}
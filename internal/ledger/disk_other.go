@@ -0,0 +1,7 @@
+//go:build !(darwin || dragonfly || freebsd || linux || windows)
+
+package ledger
+
+func diskSpace(path string) (free, total uint64, err error) {
+	return 0, 0, errDiskSpaceUnsupported
+}
@@ -0,0 +1,15 @@
+//go:build darwin || dragonfly || freebsd || linux
+
+package ledger
+
+import "golang.org/x/sys/unix"
+
+// diskSpace returns the bytes available to unprivileged users and the
+// size of the file system holding path.
+func diskSpace(path string) (free, total uint64, err error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), uint64(st.Blocks) * uint64(st.Bsize), nil
+}
@@ -0,0 +1,22 @@
+//go:build windows
+
+package ledger
+
+import (
+	"path/filepath"
+
+	"golang.org/x/sys/windows"
+)
+
+// diskSpace returns the bytes available to the process and the size of the
+// volume holding path.
+func diskSpace(path string) (free, total uint64, err error) {
+	dir, err := windows.UTF16PtrFromString(filepath.Dir(path))
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := windows.GetDiskFreeSpaceEx(dir, &free, &total, nil); err != nil {
+		return 0, 0, err
+	}
+	return free, total, nil
+}
@@ -0,0 +1,1073 @@
+package ledger
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"modernc.org/sqlite"
+)
+
+// Encrypted ledgers never hold plaintext on disk. The pure-Go SQLite driver
+// has no page codec, so the live database is kept in a shared in-memory
+// database. The file on disk is a sealed snapshot of it followed by a
+// journal of sealed frames, one per flush:
+//
+//	magic (8) | salt (16) | snapshot block | frame block...
+//	block: length (4) | nonce (12) | AES-256-GCM sealed gob
+//
+// A frame holds the rows written since the block before it, as they are
+// when it is flushed, so a flush costs the size of what changed rather than
+// of the database. When the frames outgrow the snapshot, or the schema
+// changes, the file is rewritten atomically as a new snapshot.
+//
+// The cipher key is derived from the caller's key with PBKDF2-SHA256 and the
+// file's salt. The header is authenticated as additional data of every
+// block; a frame's also holds the snapshot's nonce and the frame's position,
+// so frames cannot be reordered or moved to another file. Files written
+// before the journal, a single sealed snapshot under encryptedMagicV1, are
+// read and rewritten in this format on the first flush.
+const (
+	encryptedMagic         = "SLENC02\n"
+	encryptedMagicV1       = "SLENC01\n"
+	encryptedSaltSize      = 16
+	encryptedKDFIterations = 200000
+
+	// encryptedJournalMin is the size the frames may reach before the file
+	// is compacted, however small the snapshot.
+	encryptedJournalMin = 1 << 20
+
+	// DefaultFlushInterval is how often an encrypted ledger writes changes
+	// that are not written before the call making them returns.
+	DefaultFlushInterval = time.Second
+)
+
+// ErrDatabaseEncrypted is returned when an encrypted database is opened
+// without a key.
+var ErrDatabaseEncrypted = errors.New("database is encrypted; a key is required")
+
+// ErrDatabaseKey is returned when the key does not open an encrypted
+// database, or the file has been modified.
+var ErrDatabaseKey = errors.New("wrong database key or corrupt encrypted database")
+
+// ErrDatabaseLocked is returned when an encrypted database is already open
+// in another process.
+var ErrDatabaseLocked = errors.New("encrypted database is open in another process")
+
+// OpenOptions configures OpenWithOptions.
+type OpenOptions struct {
+	// Key enables whole-database encryption. The database is held in memory
+	// and written to path only encrypted, so a single process may have it
+	// open at a time; it holds an exclusive lock on path+".lock" while it
+	// does.
+	Key []byte
+	// FlushInterval is how often other changes to an encrypted database are
+	// written to disk. Writes that append records or change approvals,
+	// holds, keys, credentials, policies, schemas or archives are written
+	// before they return. The rest, such as agent leases, rate limits and
+	// webhook subscriptions, are lost if the process dies within
+	// FlushInterval of them. Close and Sync flush immediately. Defaults to
+	// DefaultFlushInterval.
+	FlushInterval time.Duration
+}
+
+// encryptedDB persists the in-memory database behind an encrypted ledger.
+type encryptedDB struct {
+	path string
+	// uri opens the in-memory database, for SQLite's backup API.
+	uri  string
+	salt []byte
+	aead cipher.AEAD
+	// lock holds the exclusive lock on the lock file beside path.
+	lock *os.File
+
+	mu sync.Mutex
+	// anchor keeps the in-memory database alive for as long as the ledger is
+	// open and is used for flushes only.
+	anchor *sql.Conn
+	// file is path opened for appending frames, nil until the snapshot in
+	// it is current.
+	file *os.File
+	// base is the nonce of the file's snapshot block, and frames the number
+	// of frames after it.
+	base   []byte
+	frames int64
+	// snapshotSize and journalSize are the sizes of the snapshot block and
+	// of the frames after it.
+	snapshotSize, journalSize int64
+	// version and schema are the anchor's data_version and schema_version
+	// at the last flush.
+	version, schema int64
+	// rewrite makes the next flush write a new snapshot, for a file in the
+	// old format or after a flush failed with changes taken.
+	rewrite bool
+	written bool
+
+	changedMu sync.Mutex
+	// changed holds the rows written since the last flush.
+	changed map[changedRow]struct{}
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// changedRow is a row written to the in-memory database.
+type changedRow struct {
+	table string
+	rowid int64
+}
+
+// dbSnapshot is the logical contents of a database: its schema objects in
+// creation order and the rows of each table.
+type dbSnapshot struct {
+	Objects []dbObject
+	Tables  []dbTable
+}
+
+type dbObject struct {
+	Type string
+	Name string
+	SQL  string
+}
+
+type dbTable struct {
+	Name string
+	// Columns names the table's columns when each row starts with its
+	// rowid. Snapshots written before the journal have neither.
+	Columns []string
+	Rows    [][]interface{}
+}
+
+// dbFrame is the rows written since the block before it: those that still
+// exist as they are now, rowid first, the rowids of those deleted by table,
+// and the contents of sqlite_sequence, whose counters change without the
+// pre-update hook seeing them.
+type dbFrame struct {
+	Tables   []dbTable
+	Deleted  map[string][]int64
+	Sequence [][]interface{}
+}
+
+var (
+	trackChangesOnce sync.Once
+
+	encryptedDBsMu sync.Mutex
+	// encryptedDBs maps the DSN of each open encrypted ledger's in-memory
+	// database to it.
+	encryptedDBs = map[string]*encryptedDB{}
+)
+
+// trackChanges registers the pre-update hook of connections to an
+// encrypted ledger's in-memory database. SQLite calls it before every row
+// is inserted, updated or deleted, including by triggers and REPLACE, so
+// a flush only reads back the rows it saw.
+func trackChanges(conn sqlite.ExecQuerierContext, dsn string) error {
+	encryptedDBsMu.Lock()
+	e := encryptedDBs[dsn]
+	encryptedDBsMu.Unlock()
+	if e == nil {
+		return nil
+	}
+	hooks, ok := conn.(sqlite.HookRegisterer)
+	if !ok {
+		return errors.New("the database driver does not support update hooks")
+	}
+	hooks.RegisterPreUpdateHook(e.track)
+	return nil
+}
+
+func (e *encryptedDB) track(d sqlite.SQLitePreUpdateData) {
+	if d.DatabaseName != "main" || strings.HasPrefix(d.TableName, "sqlite_") {
+		return
+	}
+	e.changedMu.Lock()
+	defer e.changedMu.Unlock()
+	if e.changed == nil {
+		return
+	}
+	e.changed[changedRow{d.TableName, d.OldRowID}] = struct{}{}
+	// An update that changes a row's rowid writes a second row.
+	if d.NewRowID != d.OldRowID {
+		e.changed[changedRow{d.TableName, d.NewRowID}] = struct{}{}
+	}
+}
+
+// takeChanges returns the rows written since it was last called.
+func (e *encryptedDB) takeChanges() map[changedRow]struct{} {
+	e.changedMu.Lock()
+	defer e.changedMu.Unlock()
+	changed := e.changed
+	e.changed = make(map[changedRow]struct{})
+	return changed
+}
+
+func (e *encryptedDB) hasChanges() bool {
+	e.changedMu.Lock()
+	defer e.changedMu.Unlock()
+	return len(e.changed) > 0
+}
+
+func openEncrypted(path string, opts OpenOptions) (l *Ledger, err error) {
+	enc := &encryptedDB{path: path, changed: make(map[changedRow]struct{})}
+	var (
+		snapshot *dbSnapshot
+		frames   []*dbFrame
+		size     int
+	)
+
+	// The file is replaced on every compaction, so the lock is taken on a
+	// file that is not.
+	if enc.lock, err = os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0o600); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if l == nil {
+			enc.lock.Close()
+		}
+	}()
+	if err := lockFile(enc.lock); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		enc.salt = make([]byte, encryptedSaltSize)
+		if _, err := rand.Read(enc.salt); err != nil {
+			return nil, err
+		}
+		if enc.aead, err = newDBCipher(opts.Key, enc.salt); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	default:
+		if !isEncryptedDatabase(data) {
+			return nil, fmt.Errorf("%s is not an encrypted ledger; convert it with EncryptDatabase", path)
+		}
+		enc.salt = data[len(encryptedMagic) : len(encryptedMagic)+encryptedSaltSize]
+		if enc.aead, err = newDBCipher(opts.Key, enc.salt); err != nil {
+			return nil, err
+		}
+		if snapshot, frames, size, err = enc.load(data); err != nil {
+			return nil, err
+		}
+		enc.written = true
+	}
+
+	enc.uri = "file:/stateledger-" + uuid.NewString() + "?vfs=memdb"
+	dsn := sqliteDSN(enc.uri)
+	trackChangesOnce.Do(func() { sqlite.RegisterConnectionHook(trackChanges) })
+	encryptedDBsMu.Lock()
+	encryptedDBs[dsn] = enc
+	encryptedDBsMu.Unlock()
+	defer func() {
+		if l == nil {
+			enc.forget()
+		}
+	}()
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(25)
+	db.SetMaxIdleConns(5)
+	db.SetConnMaxLifetime(5 * time.Minute)
+	db.SetConnMaxIdleTime(1 * time.Minute)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if enc.anchor, err = db.Conn(ctx); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	closeDB := func() {
+		_ = enc.anchor.Close()
+		_ = db.Close()
+	}
+	if snapshot != nil {
+		if err := restoreSnapshot(ctx, enc.anchor, snapshot, frames...); err != nil {
+			closeDB()
+			return nil, fmt.Errorf("restore encrypted database: %w", err)
+		}
+		// The restored rows are already on disk.
+		enc.takeChanges()
+	}
+	if enc.version, err = enc.dataVersion(); err == nil {
+		err = enc.anchor.QueryRowContext(ctx, `PRAGMA schema_version`).Scan(&enc.schema)
+	}
+	if err == nil && enc.written && !enc.rewrite {
+		enc.file, err = openJournal(path, size, len(data))
+	}
+	if err != nil {
+		closeDB()
+		return nil, err
+	}
+
+	interval := opts.FlushInterval
+	if interval <= 0 {
+		interval = DefaultFlushInterval
+	}
+	enc.stop = make(chan struct{})
+	enc.done = make(chan struct{})
+	go enc.run(interval)
+
+	l = &Ledger{db: db, encrypted: enc}
+	if err := l.upgradeReadSchemas(); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// openJournal opens the file at path, of which size bytes were read, for
+// appending frames. A frame cut short by a crash beyond size is cut off.
+func openJournal(path string, valid, size int) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return nil, err
+	}
+	if valid < size {
+		err = f.Truncate(int64(valid))
+		if err == nil {
+			err = f.Sync()
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func newDBCipher(key, salt []byte) (cipher.AEAD, error) {
+	if len(key) == 0 {
+		return nil, errors.New("database key required")
+	}
+	derived, err := pbkdf2.Key(sha256.New, string(key), salt, encryptedKDFIterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(derived)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// isEncryptedDatabase reports whether data starts with an encrypted ledger
+// header.
+func isEncryptedDatabase(data []byte) bool {
+	if len(data) < len(encryptedMagic)+encryptedSaltSize {
+		return false
+	}
+	magic := string(data[:len(encryptedMagic)])
+	return magic == encryptedMagic || magic == encryptedMagicV1
+}
+
+// fileIsEncrypted reports whether the file at path is an encrypted ledger.
+// A missing file is not.
+func fileIsEncrypted(path string) (bool, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	header := make([]byte, len(encryptedMagic)+encryptedSaltSize)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return false, nil
+	}
+	return isEncryptedDatabase(header), nil
+}
+
+func (e *encryptedDB) header() []byte {
+	return append([]byte(encryptedMagic), e.salt...)
+}
+
+// frameAD is the additional data of the nth frame after the snapshot
+// sealed with nonce base.
+func (e *encryptedDB) frameAD(base []byte, n int64) []byte {
+	ad := append(e.header(), base...)
+	return binary.BigEndian.AppendUint64(ad, uint64(n))
+}
+
+// sealBlock gob-encodes v and seals it with ad as a block.
+func (e *encryptedDB) sealBlock(v interface{}, ad []byte) (block, nonce []byte, err error) {
+	var plain bytes.Buffer
+	if err := gob.NewEncoder(&plain).Encode(v); err != nil {
+		return nil, nil, err
+	}
+	nonce = make([]byte, e.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+	size := len(nonce) + plain.Len() + e.aead.Overhead()
+	if size > math.MaxUint32 {
+		return nil, nil, errors.New("encrypted database block too large")
+	}
+	block = binary.BigEndian.AppendUint32(make([]byte, 0, 4+size), uint32(size))
+	block = append(block, nonce...)
+	return e.aead.Seal(block, nonce, plain.Bytes(), ad), nonce, nil
+}
+
+// openBlock opens the block at the start of data into v. size is the
+// length of the block, or 0 if data ends before it does.
+func (e *encryptedDB) openBlock(data, ad []byte, v interface{}) (nonce []byte, size int, err error) {
+	if len(data) < 4 {
+		return nil, 0, ErrDatabaseKey
+	}
+	n := int(binary.BigEndian.Uint32(data))
+	if len(data)-4 < n {
+		return nil, 0, ErrDatabaseKey
+	}
+	size = 4 + n
+	if n < e.aead.NonceSize() {
+		return nil, size, ErrDatabaseKey
+	}
+	nonce = data[4 : 4+e.aead.NonceSize()]
+	plain, err := e.aead.Open(nil, nonce, data[4+len(nonce):size], ad)
+	if err != nil {
+		return nil, size, ErrDatabaseKey
+	}
+	if err := gob.NewDecoder(bytes.NewReader(plain)).Decode(v); err != nil {
+		return nil, size, fmt.Errorf("decode encrypted database: %w", err)
+	}
+	return nonce, size, nil
+}
+
+// load reads the snapshot and frames of an encrypted file. valid is the
+// length of the file up to the last whole frame: a frame cut short by a
+// crash was never synced, so was never acknowledged, and is dropped.
+func (e *encryptedDB) load(data []byte) (s *dbSnapshot, frames []*dbFrame, valid int, err error) {
+	if string(data[:len(encryptedMagicV1)]) == encryptedMagicV1 {
+		s, err = e.loadV1(data)
+		e.rewrite = true
+		return s, nil, len(data), err
+	}
+
+	header := e.header()
+	s = &dbSnapshot{}
+	nonce, size, err := e.openBlock(data[len(header):], header, s)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	e.base = bytes.Clone(nonce)
+	e.snapshotSize = int64(size)
+	valid = len(header) + size
+	for valid < len(data) {
+		frame := &dbFrame{}
+		_, size, err := e.openBlock(data[valid:], e.frameAD(e.base, e.frames+1), frame)
+		if err != nil {
+			if size > 0 && valid+size < len(data) {
+				// A damaged frame with frames after it was not cut short.
+				return nil, nil, 0, err
+			}
+			break
+		}
+		frames = append(frames, frame)
+		e.frames++
+		e.journalSize += int64(size)
+		valid += size
+	}
+	return s, frames, valid, nil
+}
+
+// loadV1 reads a file holding a single sealed snapshot:
+//
+//	magic (8) | salt (16) | nonce (12) | sealed gob snapshot
+func (e *encryptedDB) loadV1(data []byte) (*dbSnapshot, error) {
+	header := append([]byte(encryptedMagicV1), e.salt...)
+	rest := data[len(header):]
+	if len(rest) < e.aead.NonceSize() {
+		return nil, ErrDatabaseKey
+	}
+	plain, err := e.aead.Open(nil, rest[:e.aead.NonceSize()], rest[e.aead.NonceSize():], header)
+	if err != nil {
+		return nil, ErrDatabaseKey
+	}
+	var s dbSnapshot
+	if err := gob.NewDecoder(bytes.NewReader(plain)).Decode(&s); err != nil {
+		return nil, fmt.Errorf("decode encrypted database: %w", err)
+	}
+	return &s, nil
+}
+
+func (e *encryptedDB) dataVersion() (int64, error) {
+	var v int64
+	err := e.anchor.QueryRowContext(context.Background(), `PRAGMA data_version`).Scan(&v)
+	return v, err
+}
+
+func (e *encryptedDB) run(interval time.Duration) {
+	defer close(e.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.stop:
+			return
+		case <-ticker.C:
+			// A failed flush is retried on the next tick and reported by
+			// Sync or Close.
+			_ = e.flush()
+		}
+	}
+}
+
+// flush writes the database's changes since the last flush to disk.
+func (e *encryptedDB) flush() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.flushLocked(false)
+}
+
+// flushLocked appends a frame holding the rows changed since the last
+// flush, or with compact set, or when a frame will not do, replaces the
+// file with a new snapshot.
+func (e *encryptedDB) flushLocked(compact bool) error {
+	version, err := e.dataVersion()
+	if err != nil {
+		return err
+	}
+	if version == e.version && e.written && e.file != nil && !compact && !e.rewrite && !e.hasChanges() {
+		return nil
+	}
+
+	// Beginning a write transaction waits for the one in progress, if any,
+	// and holds off the rest, so every tracked row was committed or rolled
+	// back and is read as it now is.
+	ctx := context.Background()
+	tx, err := e.anchor.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var schema int64
+	if err := tx.QueryRowContext(ctx, `PRAGMA schema_version`).Scan(&schema); err != nil {
+		return err
+	}
+	changed := e.takeChanges()
+	// Whatever fails below, the next flush writes a snapshot, as the
+	// changes taken would otherwise be lost.
+	compact = compact || e.rewrite || e.file == nil || schema != e.schema ||
+		e.journalSize > max(e.snapshotSize, encryptedJournalMin)
+	e.rewrite = true
+
+	if compact {
+		snapshot, err := snapshotTx(ctx, tx)
+		if err != nil {
+			return err
+		}
+		_ = tx.Rollback()
+		if len(snapshot.Objects) == 0 && !e.written {
+			e.rewrite = false
+			return nil
+		}
+		if err := e.writeSnapshot(snapshot); err != nil {
+			return err
+		}
+	} else if len(changed) > 0 {
+		frame, err := readChanges(ctx, tx, changed)
+		if err != nil {
+			return err
+		}
+		_ = tx.Rollback()
+		if err := e.appendFrame(frame); err != nil {
+			return err
+		}
+	}
+	e.version, e.schema = version, schema
+	e.rewrite = false
+	e.written = true
+	return nil
+}
+
+// writeSnapshot replaces the file with one holding only s.
+func (e *encryptedDB) writeSnapshot(s *dbSnapshot) error {
+	header := e.header()
+	block, nonce, err := e.sealBlock(s, header)
+	if err != nil {
+		return err
+	}
+	if e.file != nil {
+		// Closed first, as Windows cannot replace a file that is open.
+		_ = e.file.Close()
+		e.file = nil
+	}
+	if err := writeFileAtomic(e.path, append(header, block...)); err != nil {
+		return err
+	}
+	if e.file, err = openJournal(e.path, 0, 0); err != nil {
+		return err
+	}
+	e.base, e.frames = nonce, 0
+	e.snapshotSize, e.journalSize = int64(len(block)), 0
+	return nil
+}
+
+// appendFrame appends frame to the file and syncs it.
+func (e *encryptedDB) appendFrame(frame *dbFrame) error {
+	block, _, err := e.sealBlock(frame, e.frameAD(e.base, e.frames+1))
+	if err != nil {
+		return err
+	}
+	if _, err := e.file.Write(block); err != nil {
+		return err
+	}
+	if err := e.file.Sync(); err != nil {
+		return err
+	}
+	e.frames++
+	e.journalSize += int64(len(block))
+	return nil
+}
+
+// rekey rewrites the database file sealed with key under a fresh salt.
+// The file is replaced atomically, so it always opens with either the old
+// or the new key.
+func (e *encryptedDB) rekey(key []byte) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	salt := make([]byte, encryptedSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	aead, err := newDBCipher(key, salt)
+	if err != nil {
+		return err
+	}
+	oldSalt, oldAEAD := e.salt, e.aead
+	e.salt, e.aead = salt, aead
+	if err := e.flushLocked(true); err != nil {
+		e.salt, e.aead = oldSalt, oldAEAD
+		return err
+	}
+	return nil
+}
+
+// replaced makes the next flush write a snapshot, after the database's
+// pages were replaced without the pre-update hook seeing the rows.
+func (e *encryptedDB) replaced() {
+	e.mu.Lock()
+	e.rewrite = true
+	e.mu.Unlock()
+}
+
+func (e *encryptedDB) close() error {
+	close(e.stop)
+	<-e.done
+	err := e.flush()
+	if cerr := e.anchor.Close(); err == nil {
+		err = cerr
+	}
+	if e.file != nil {
+		if cerr := e.file.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if cerr := e.lock.Close(); err == nil {
+		err = cerr
+	}
+	e.forget()
+	return err
+}
+
+// forget stops tracking changes to the database. Its connections keep
+// their hook, which the driver has no way to remove, until they close.
+func (e *encryptedDB) forget() {
+	encryptedDBsMu.Lock()
+	delete(encryptedDBs, sqliteDSN(e.uri))
+	encryptedDBsMu.Unlock()
+	e.changedMu.Lock()
+	e.changed = nil
+	e.changedMu.Unlock()
+}
+
+// Sync writes pending changes of an encrypted ledger to disk. It is a no-op
+// for unencrypted ledgers, whose writes are durable on commit. Writes that
+// must survive a crash call it before returning.
+func (l *Ledger) Sync() error {
+	if l.encrypted == nil {
+		return nil
+	}
+	return l.encrypted.flush()
+}
+
+// Encrypted reports whether the ledger was opened with a database key.
+func (l *Ledger) Encrypted() bool {
+	return l.encrypted != nil
+}
+
+// writeFileAtomic replaces path with data. The data and then the rename are
+// synced before it returns.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// takeSnapshot reads the schema and rows of conn's database in one read
+// transaction.
+func takeSnapshot(ctx context.Context, conn *sql.Conn) (*dbSnapshot, error) {
+	tx, err := conn.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	return snapshotTx(ctx, tx)
+}
+
+// snapshotTx reads the schema and rows of the database in tx. Rows are
+// read with their rowids, which frames refer to.
+func snapshotTx(ctx context.Context, tx *sql.Tx) (*dbSnapshot, error) {
+	s := &dbSnapshot{}
+	rows, err := tx.QueryContext(ctx, `SELECT type, name, sql FROM sqlite_master
+		WHERE sql IS NOT NULL AND name NOT LIKE 'sqlite_%' ORDER BY rowid`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var obj dbObject
+		if err := rows.Scan(&obj.Type, &obj.Name, &obj.SQL); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		s.Objects = append(s.Objects, obj)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, obj := range s.Objects {
+		if obj.Type != "table" {
+			continue
+		}
+		rows, err := tx.QueryContext(ctx, `SELECT rowid, * FROM `+quoteIdent(obj.Name))
+		if err != nil {
+			return nil, err
+		}
+		table := dbTable{Name: obj.Name}
+		if table.Columns, table.Rows, err = scanRows(rows); err != nil {
+			return nil, err
+		}
+		table.Columns = table.Columns[1:]
+		s.Tables = append(s.Tables, table)
+	}
+	sequence, err := readSequence(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	if sequence != nil {
+		// Restored last so that it overrides the counters advanced by
+		// reinserting rows.
+		s.Tables = append(s.Tables, dbTable{Name: "sqlite_sequence", Rows: sequence})
+	}
+	return s, nil
+}
+
+// readSequence returns the rows of sqlite_sequence, or nil if the database
+// has none.
+func readSequence(ctx context.Context, tx *sql.Tx) ([][]interface{}, error) {
+	var hasSequence int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'sqlite_sequence'`).Scan(&hasSequence); err != nil {
+		return nil, err
+	}
+	if hasSequence == 0 {
+		return nil, nil
+	}
+	rows, err := tx.QueryContext(ctx, `SELECT * FROM sqlite_sequence`)
+	if err != nil {
+		return nil, err
+	}
+	_, values, err := scanRows(rows)
+	if values == nil && err == nil {
+		values = [][]interface{}{}
+	}
+	return values, err
+}
+
+// scanRows reads and closes rows.
+func scanRows(rows *sql.Rows) ([]string, [][]interface{}, error) {
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, nil, err
+	}
+	var out [][]interface{}
+	for rows.Next() {
+		values := make([]interface{}, len(cols))
+		ptrs := make([]interface{}, len(cols))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, nil, err
+		}
+		out = append(out, values)
+	}
+	return cols, out, rows.Err()
+}
+
+// readChanges reads the changed rows as they are in tx.
+func readChanges(ctx context.Context, tx *sql.Tx, changed map[changedRow]struct{}) (*dbFrame, error) {
+	byTable := make(map[string][]int64)
+	for row := range changed {
+		byTable[row.table] = append(byTable[row.table], row.rowid)
+	}
+	names := make([]string, 0, len(byTable))
+	for name := range byTable {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	frame := &dbFrame{Deleted: make(map[string][]int64)}
+	for _, name := range names {
+		rowids := byTable[name]
+		sort.Slice(rowids, func(i, j int) bool { return rowids[i] < rowids[j] })
+		stmt, err := tx.PrepareContext(ctx, `SELECT rowid, * FROM `+quoteIdent(name)+` WHERE rowid = ?`)
+		if err != nil {
+			return nil, err
+		}
+		table := dbTable{Name: name}
+		for _, rowid := range rowids {
+			rows, err := stmt.QueryContext(ctx, rowid)
+			if err != nil {
+				stmt.Close()
+				return nil, err
+			}
+			cols, values, err := scanRows(rows)
+			if err != nil {
+				stmt.Close()
+				return nil, err
+			}
+			if len(values) == 0 {
+				frame.Deleted[name] = append(frame.Deleted[name], rowid)
+				continue
+			}
+			table.Columns = cols[1:]
+			table.Rows = append(table.Rows, values[0])
+		}
+		stmt.Close()
+		if len(table.Rows) > 0 {
+			frame.Tables = append(frame.Tables, table)
+		}
+	}
+	var err error
+	frame.Sequence, err = readSequence(ctx, tx)
+	return frame, err
+}
+
+// restoreSnapshot recreates the snapshot in conn's empty database and
+// applies frames to it in order. Indexes and triggers are created after
+// the rows are loaded, so the triggers do not run again for rows that
+// they wrote.
+func restoreSnapshot(ctx context.Context, conn *sql.Conn, s *dbSnapshot, frames ...*dbFrame) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, obj := range s.Objects {
+		if obj.Type == "table" || obj.Type == "view" {
+			if _, err := tx.ExecContext(ctx, obj.SQL); err != nil {
+				return fmt.Errorf("%s %s: %w", obj.Type, obj.Name, err)
+			}
+		}
+	}
+	for _, table := range s.Tables {
+		if table.Name == "sqlite_sequence" {
+			if err := restoreSequence(ctx, tx, table.Rows); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := insertRows(ctx, tx, "INSERT", table); err != nil {
+			return err
+		}
+	}
+	for _, frame := range frames {
+		if err := applyFrame(ctx, tx, frame); err != nil {
+			return err
+		}
+	}
+	for _, obj := range s.Objects {
+		if obj.Type == "index" || obj.Type == "trigger" {
+			if _, err := tx.ExecContext(ctx, obj.SQL); err != nil {
+				return fmt.Errorf("%s %s: %w", obj.Type, obj.Name, err)
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// applyFrame deletes the frame's deleted rows and then writes its rows.
+// Rows are replaced whole: the frame holds every row that changed, so a
+// row another one replaces is also deleted or rewritten by it.
+func applyFrame(ctx context.Context, tx *sql.Tx, frame *dbFrame) error {
+	for name, rowids := range frame.Deleted {
+		for _, rowid := range rowids {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM `+quoteIdent(name)+` WHERE rowid = ?`, rowid); err != nil {
+				return fmt.Errorf("table %s: %w", name, err)
+			}
+		}
+	}
+	for _, table := range frame.Tables {
+		if err := insertRows(ctx, tx, "INSERT OR REPLACE", table); err != nil {
+			return err
+		}
+	}
+	if frame.Sequence != nil {
+		return restoreSequence(ctx, tx, frame.Sequence)
+	}
+	return nil
+}
+
+// insertRows inserts the rows of table with verb, by rowid when the rows
+// have one.
+func insertRows(ctx context.Context, tx *sql.Tx, verb string, table dbTable) error {
+	if len(table.Rows) == 0 {
+		return nil
+	}
+	columns := ""
+	if table.Columns != nil {
+		quoted := []string{"rowid"}
+		for _, col := range table.Columns {
+			quoted = append(quoted, quoteIdent(col))
+		}
+		columns = "(" + strings.Join(quoted, ", ") + ")"
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(table.Rows[0])), ", ")
+	stmt, err := tx.PrepareContext(ctx, verb+` INTO `+quoteIdent(table.Name)+columns+` VALUES(`+placeholders+`)`)
+	if err != nil {
+		return fmt.Errorf("table %s: %w", table.Name, err)
+	}
+	defer stmt.Close()
+	for _, row := range table.Rows {
+		if _, err := stmt.ExecContext(ctx, row...); err != nil {
+			return fmt.Errorf("table %s: %w", table.Name, err)
+		}
+	}
+	return nil
+}
+
+func restoreSequence(ctx context.Context, tx *sql.Tx, rows [][]interface{}) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM sqlite_sequence`); err != nil {
+		return err
+	}
+	return insertRows(ctx, tx, "INSERT", dbTable{Name: "sqlite_sequence", Rows: rows})
+}
+
+// EncryptDatabase writes an encrypted copy of the unencrypted ledger at src
+// to dst, which must not exist.
+func EncryptDatabase(src, dst string, key []byte) error {
+	if _, err := os.Stat(dst); err == nil {
+		return fmt.Errorf("%s already exists", dst)
+	}
+	plain, err := Open(src)
+	if err != nil {
+		return err
+	}
+	defer plain.Close()
+
+	enc, err := OpenWithOptions(dst, OpenOptions{Key: key})
+	if err != nil {
+		return err
+	}
+	if err := copyDatabase(plain.db, enc.encrypted.anchor); err != nil {
+		enc.Close()
+		_ = os.Remove(dst)
+		return err
+	}
+	return enc.Close()
+}
+
+// DecryptDatabase writes an unencrypted copy of the encrypted ledger at src
+// to dst, which must not exist.
+func DecryptDatabase(src, dst string, key []byte) error {
+	if _, err := os.Stat(dst); err == nil {
+		return fmt.Errorf("%s already exists", dst)
+	}
+	enc, err := OpenWithOptions(src, OpenOptions{Key: key})
+	if err != nil {
+		return err
+	}
+	defer enc.Close()
+
+	plain, err := Open(dst)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	conn, err := plain.db.Conn(ctx)
+	if err == nil {
+		err = copyDatabase(enc.db, conn)
+		conn.Close()
+	}
+	if cerr := plain.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(dst)
+	}
+	return err
+}
+
+func copyDatabase(src *sql.DB, dst *sql.Conn) error {
+	ctx := context.Background()
+	conn, err := src.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	snapshot, err := takeSnapshot(ctx, conn)
+	if err != nil {
+		return err
+	}
+	return restoreSnapshot(ctx, dst, snapshot)
+}
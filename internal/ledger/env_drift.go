@@ -0,0 +1,100 @@
+package ledger
+
+import (
+	"strings"
+
+	"github.com/Retr0-XD/StateLedger/internal/collectors"
+)
+
+// DefaultPreflightThreshold is the minimum compatibility score required
+// before a destructive replay is allowed to proceed.
+const DefaultPreflightThreshold = 80.0
+
+// EnvironmentMismatch describes a single field that differs between the
+// captured environment and the replay host.
+type EnvironmentMismatch struct {
+	Field    string  `json:"field"`
+	Captured string  `json:"captured"`
+	Current  string  `json:"current"`
+	Penalty  float64 `json:"penalty"`
+}
+
+// EnvironmentDrift scores how compatible a replay host is with the
+// environment recorded in a snapshot.
+type EnvironmentDrift struct {
+	Score      float64               `json:"score"`
+	Compatible bool                  `json:"compatible"`
+	Mismatches []EnvironmentMismatch `json:"mismatches"`
+	RiskLevel  string                `json:"risk_level"`
+}
+
+// CompareEnvironments scores the current host against a captured
+// environment. OS and architecture mismatches weigh most heavily, followed
+// by runtime version, kernel and container differences.
+func CompareEnvironments(captured, current *collectors.EnvironmentPayload) EnvironmentDrift {
+	drift := EnvironmentDrift{
+		Score:      100.0,
+		Mismatches: []EnvironmentMismatch{},
+	}
+
+	if captured == nil || current == nil {
+		drift.Score = 0
+		drift.RiskLevel = "high"
+		drift.Mismatches = append(drift.Mismatches, EnvironmentMismatch{
+			Field:   "environment",
+			Penalty: 100,
+		})
+		return drift
+	}
+
+	check := func(field, a, b string, penalty float64) {
+		if strings.EqualFold(strings.TrimSpace(a), strings.TrimSpace(b)) {
+			return
+		}
+		drift.Mismatches = append(drift.Mismatches, EnvironmentMismatch{
+			Field:    field,
+			Captured: a,
+			Current:  b,
+			Penalty:  penalty,
+		})
+		drift.Score -= penalty
+	}
+
+	check("os", captured.OS, current.OS, 40)
+	check("arch", captured.Arch, current.Arch, 30)
+	if runtimeRelease(captured.Runtime) != runtimeRelease(current.Runtime) {
+		check("runtime", captured.Runtime, current.Runtime, 15)
+	} else {
+		check("runtime", captured.Runtime, current.Runtime, 5)
+	}
+	check("kernel", captured.Kernel, current.Kernel, 10)
+	check("container", captured.Container, current.Container, 5)
+	check("time_source", captured.TimeSource, current.TimeSource, 5)
+
+	if drift.Score < 0 {
+		drift.Score = 0
+	}
+
+	switch {
+	case drift.Score >= DefaultPreflightThreshold:
+		drift.RiskLevel = "low"
+	case drift.Score >= 50:
+		drift.RiskLevel = "medium"
+	default:
+		drift.RiskLevel = "high"
+	}
+	drift.Compatible = drift.Score >= DefaultPreflightThreshold
+
+	return drift
+}
+
+// runtimeRelease reduces a runtime version such as "go1.22.3" to its
+// release line ("go1.22") so patch-level differences are penalised less.
+func runtimeRelease(runtime string) string {
+	runtime = strings.TrimSpace(runtime)
+	parts := strings.SplitN(runtime, ".", 3)
+	if len(parts) < 2 {
+		return runtime
+	}
+	return parts[0] + "." + parts[1]
+}
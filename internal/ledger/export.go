@@ -0,0 +1,183 @@
+package ledger
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// exportBatch bounds how many records Export reads at a time.
+const exportBatch = 1000
+
+// Export formats.
+const (
+	ExportNDJSON  = "ndjson"
+	ExportCSV     = "csv"
+	ExportParquet = "parquet"
+)
+
+// exportColumns are the columns of the CSV and Parquet formats. hash and
+// prev_hash keep the chain verifiable: hash covers payload_hash, which is
+// kept for redacted records whose payload is blank, and labels, in
+// canonical JSON as hashed.
+var exportColumns = []string{"id", "timestamp", "type", "source", "payload", "payload_hash", "labels", "hash", "prev_hash", "signature", "signing_key_id"}
+
+// exportFields returns the values of the export columns after id and
+// timestamp.
+func exportFields(rec Record) []string {
+	payloadHash := PayloadHash(rec.Payload)
+	if rec.Redaction != nil {
+		payloadHash = rec.Redaction.PayloadHash
+	}
+	var labels string
+	if len(rec.Labels) > 0 {
+		labels = canonicalLabels(rec.Labels)
+	}
+	return []string{rec.Type, rec.Source, rec.Payload, payloadHash, labels, rec.Hash, rec.PrevHash, rec.Signature, rec.SigningKeyID}
+}
+
+// ExportOptions filters the records written by Export. Zero values match
+// everything.
+type ExportOptions struct {
+	Since  int64
+	Until  int64
+	Type   string
+	Source string
+}
+
+// An Exporter writes records in one export format. Close writes what is
+// still buffered, such as the Parquet footer; it does not close the
+// underlying writer.
+type Exporter interface {
+	Write(rec Record) error
+	Close() error
+}
+
+// NewExporter returns an Exporter writing format to w: ExportNDJSON,
+// ExportCSV or ExportParquet.
+func NewExporter(w io.Writer, format string) (Exporter, error) {
+	switch format {
+	case ExportNDJSON, "jsonl":
+		bw := bufio.NewWriter(w)
+		return &jsonlExporter{w: bw, enc: json.NewEncoder(bw)}, nil
+	case ExportCSV:
+		cw := csv.NewWriter(w)
+		return &csvExporter{w: cw}, cw.Write(exportColumns)
+	case ExportParquet:
+		return newParquetExporter(w), nil
+	}
+	return nil, fmt.Errorf("unknown export format %q: use ndjson, csv or parquet", format)
+}
+
+// jsonlExporter writes one Record per line, as read back by ImportJSONL.
+type jsonlExporter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func (e *jsonlExporter) Write(rec Record) error { return e.enc.Encode(rec) }
+func (e *jsonlExporter) Close() error           { return e.w.Flush() }
+
+// csvExporter writes a header row of export columns, then a row per record.
+type csvExporter struct {
+	w *csv.Writer
+}
+
+func (e *csvExporter) Write(rec Record) error {
+	row := append([]string{strconv.FormatInt(rec.ID, 10), strconv.FormatInt(rec.Timestamp, 10)}, exportFields(rec)...)
+	return e.w.Write(row)
+}
+
+func (e *csvExporter) Close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// ExportJSONL writes live records to w as newline-delimited JSON; see
+// Export.
+func (l *Ledger) ExportJSONL(w io.Writer, opts ExportOptions) (int64, error) {
+	return l.Export(w, ExportNDJSON, opts)
+}
+
+// Export writes live records to w in format, in ID order, and returns how
+// many. NDJSON has one Record per line, signatures included, and can be
+// loaded into another ledger with ImportJSONL. Archived records are not
+// exported.
+func (l *Ledger) Export(w io.Writer, format string, opts ExportOptions) (int64, error) {
+	exp, err := NewExporter(w, format)
+	if err != nil {
+		return 0, err
+	}
+	var where []string
+	var args []interface{}
+	if opts.Since > 0 {
+		where = append(where, "ts >= ?")
+		args = append(args, opts.Since)
+	}
+	if opts.Until > 0 {
+		where = append(where, "ts <= ?")
+		args = append(args, opts.Until)
+	}
+	if opts.Type != "" {
+		where = append(where, "type = ?")
+		args = append(args, opts.Type)
+	}
+	if opts.Source != "" {
+		where = append(where, "source = ?")
+		args = append(args, opts.Source)
+	}
+	where = append(where, "id > ?")
+	query := `SELECT id, ts, type, source, payload, hash, prev_hash FROM ledger_records WHERE ` +
+		strings.Join(where, " AND ") + ` ORDER BY id ASC LIMIT ?`
+
+	var exported, after int64
+	for {
+		rows, err := l.reader().Query(query, append(args, after, exportBatch)...)
+		if err != nil {
+			return exported, err
+		}
+		var batch []Record
+		for rows.Next() {
+			var rec Record
+			if err := rows.Scan(&rec.ID, &rec.Timestamp, &rec.Type, &rec.Source, &rec.Payload, &rec.Hash, &rec.PrevHash); err != nil {
+				rows.Close()
+				return exported, err
+			}
+			batch = append(batch, rec)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return exported, err
+		}
+		if err := l.attachSignatures(replicaQueryer{l}, batch); err != nil {
+			return exported, err
+		}
+		if err := l.attachRedactions(replicaQueryer{l}, batch); err != nil {
+			return exported, err
+		}
+		if err := l.attachSeqs(replicaQueryer{l}, batch); err != nil {
+			return exported, err
+		}
+		if err := l.attachUIDs(replicaQueryer{l}, batch); err != nil {
+			return exported, err
+		}
+		if err := l.attachLabels(replicaQueryer{l}, batch); err != nil {
+			return exported, err
+		}
+		for _, rec := range batch {
+			if err := exp.Write(rec); err != nil {
+				return exported, err
+			}
+			after = rec.ID
+			exported++
+		}
+		if len(batch) < exportBatch {
+			break
+		}
+	}
+	return exported, exp.Close()
+}
@@ -0,0 +1,76 @@
+package ledger
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// guardSchema makes ledger_records append-only. The triggers reject UPDATE
+// and DELETE unless the single ledger_guard row is unlocked, which only
+// sanctioned paths do, inside their own transaction, and undo before
+// committing. Anyone with write access to the file can still drop the
+// triggers; CheckGuard detects that.
+const guardSchema = `
+CREATE TABLE IF NOT EXISTS ledger_guard (
+	id INTEGER PRIMARY KEY CHECK (id = 1),
+	unlocked INTEGER NOT NULL DEFAULT 0
+);
+INSERT OR IGNORE INTO ledger_guard(id, unlocked) VALUES(1, 0);
+CREATE TRIGGER IF NOT EXISTS ledger_records_no_update BEFORE UPDATE ON ledger_records
+WHEN COALESCE((SELECT unlocked FROM ledger_guard WHERE id = 1), 0) = 0
+BEGIN
+	SELECT RAISE(ABORT, 'ledger_records is append-only');
+END;
+CREATE TRIGGER IF NOT EXISTS ledger_records_no_delete BEFORE DELETE ON ledger_records
+WHEN COALESCE((SELECT unlocked FROM ledger_guard WHERE id = 1), 0) = 0
+BEGIN
+	SELECT RAISE(ABORT, 'ledger_records is append-only');
+END;
+`
+
+var guardTriggers = []string{"ledger_records_no_update", "ledger_records_no_delete"}
+
+// ErrGuardMissing is returned by CheckGuard when the append-only triggers
+// are missing or disabled.
+var ErrGuardMissing = errors.New("ledger immutability guard missing")
+
+// CheckGuard verifies that the triggers keeping ledger_records append-only
+// are installed and not left unlocked. InitSchema installs them.
+func (l *Ledger) CheckGuard() error {
+	var missing []string
+	for _, name := range guardTriggers {
+		var n int
+		if err := l.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name = ? AND tbl_name = 'ledger_records'`, name).Scan(&n); err != nil {
+			return err
+		}
+		if n == 0 {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: triggers %s not installed", ErrGuardMissing, strings.Join(missing, ", "))
+	}
+
+	var unlocked int
+	err := l.db.QueryRow(`SELECT unlocked FROM ledger_guard WHERE id = 1`).Scan(&unlocked)
+	if errors.Is(err, sql.ErrNoRows) || unlocked != 0 {
+		return fmt.Errorf("%w: guard row missing or unlocked", ErrGuardMissing)
+	}
+	return err
+}
+
+// withRecordsUnlocked runs fn with UPDATE and DELETE on ledger_records
+// allowed for tx only. It is reserved for sanctioned paths such as pruning
+// archived records.
+func withRecordsUnlocked(tx *sql.Tx, fn func() error) error {
+	if _, err := tx.Exec(`UPDATE ledger_guard SET unlocked = 1 WHERE id = 1`); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`UPDATE ledger_guard SET unlocked = 0 WHERE id = 1`)
+	return err
+}
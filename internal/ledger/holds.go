@@ -0,0 +1,236 @@
+package ledger
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Retr0-XD/StateLedger/internal/collectors"
+)
+
+const holdSchema = `
+CREATE TABLE IF NOT EXISTS ledger_holds (
+	id INTEGER PRIMARY KEY,
+	case_id TEXT NOT NULL,
+	from_ts INTEGER NOT NULL,
+	to_ts INTEGER NOT NULL,
+	created_at INTEGER NOT NULL,
+	released_at INTEGER NOT NULL DEFAULT 0,
+	release_id INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS idx_ledger_holds_case ON ledger_holds(case_id);
+`
+
+// Record types under which legal holds are placed and released, with the
+// case as source.
+const (
+	HoldRecordType        = "hold.create"
+	HoldReleaseRecordType = "hold.release"
+)
+
+// LegalHold keeps the records with timestamps in [From, To] from being
+// archived, pruned or redacted until it is released. ID and ReleaseID are
+// the IDs of the ledger records that placed and released it.
+type LegalHold struct {
+	ID         int64  `json:"id"`
+	Case       string `json:"case"`
+	From       int64  `json:"from"`
+	To         int64  `json:"to"`
+	CreatedAt  int64  `json:"created_at"`
+	ReleasedAt int64  `json:"released_at,omitempty"`
+	ReleaseID  int64  `json:"release_id,omitempty"`
+}
+
+// Active reports whether the hold has not been released.
+func (h LegalHold) Active() bool {
+	return h.ReleasedAt == 0
+}
+
+// Covers reports whether ts falls in the held range.
+func (h LegalHold) Covers(ts int64) bool {
+	return ts >= h.From && ts <= h.To
+}
+
+type holdRecord struct {
+	Case string `json:"case"`
+	From int64  `json:"from"`
+	To   int64  `json:"to"`
+}
+
+type holdReleaseRecord struct {
+	Case   string `json:"case"`
+	HoldID int64  `json:"hold_id"`
+}
+
+// PlaceHold puts the records with timestamps from from to to, inclusive,
+// under legal hold for caseID. A case has at most one active hold.
+func (l *Ledger) PlaceHold(caseID string, from, to int64) (LegalHold, error) {
+	caseID = strings.TrimSpace(caseID)
+	if caseID == "" {
+		return LegalHold{}, errors.New("case required")
+	}
+	if to < from {
+		return LegalHold{}, errors.New("hold ends before it starts")
+	}
+	if err := l.ensureHoldSchema(); err != nil {
+		return LegalHold{}, err
+	}
+	payload, err := collectors.MarshalPayload(holdRecord{Case: caseID, From: from, To: to})
+	if err != nil {
+		return LegalHold{}, err
+	}
+
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+
+	tx, err := l.db.Begin()
+	if err != nil {
+		return LegalHold{}, err
+	}
+	defer tx.Rollback()
+
+	var active int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM ledger_holds WHERE case_id = ? AND released_at = 0`, caseID).Scan(&active); err != nil {
+		return LegalHold{}, err
+	}
+	if active > 0 {
+		return LegalHold{}, fmt.Errorf("case %s already has an active hold", caseID)
+	}
+
+	hold := LegalHold{Case: caseID, From: from, To: to, CreatedAt: time.Now().Unix()}
+	records, err := l.appendBatchTx(tx, []RecordInput{{
+		Timestamp: hold.CreatedAt,
+		Type:      HoldRecordType,
+		Source:    caseID,
+		Payload:   payload,
+	}})
+	if err != nil {
+		return LegalHold{}, err
+	}
+	hold.ID = records[0].ID
+	if _, err := tx.Exec(`INSERT INTO ledger_holds(id, case_id, from_ts, to_ts, created_at) VALUES(?, ?, ?, ?, ?)`,
+		hold.ID, caseID, from, to, hold.CreatedAt); err != nil {
+		return LegalHold{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return LegalHold{}, err
+	}
+	l.invalidateListCache()
+	l.countAppended(records)
+	l.mirrorAppended(records)
+	l.journalAppended(records)
+	return hold, l.Sync()
+}
+
+// ReleaseHold releases the active hold of caseID.
+func (l *Ledger) ReleaseHold(caseID string) (LegalHold, error) {
+	if err := l.ensureHoldSchema(); err != nil {
+		return LegalHold{}, err
+	}
+
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+
+	tx, err := l.db.Begin()
+	if err != nil {
+		return LegalHold{}, err
+	}
+	defer tx.Rollback()
+
+	var hold LegalHold
+	err = tx.QueryRow(`SELECT id, case_id, from_ts, to_ts, created_at FROM ledger_holds WHERE case_id = ? AND released_at = 0`, caseID).
+		Scan(&hold.ID, &hold.Case, &hold.From, &hold.To, &hold.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return LegalHold{}, fmt.Errorf("case %s has no active hold", caseID)
+		}
+		return LegalHold{}, err
+	}
+
+	payload, err := collectors.MarshalPayload(holdReleaseRecord{Case: caseID, HoldID: hold.ID})
+	if err != nil {
+		return LegalHold{}, err
+	}
+	hold.ReleasedAt = time.Now().Unix()
+	records, err := l.appendBatchTx(tx, []RecordInput{{
+		Timestamp: hold.ReleasedAt,
+		Type:      HoldReleaseRecordType,
+		Source:    caseID,
+		Payload:   payload,
+	}})
+	if err != nil {
+		return LegalHold{}, err
+	}
+	hold.ReleaseID = records[0].ID
+	if _, err := tx.Exec(`UPDATE ledger_holds SET released_at = ?, release_id = ? WHERE id = ?`, hold.ReleasedAt, hold.ReleaseID, hold.ID); err != nil {
+		return LegalHold{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return LegalHold{}, err
+	}
+	l.invalidateListCache()
+	l.countAppended(records)
+	l.mirrorAppended(records)
+	l.journalAppended(records)
+	return hold, l.Sync()
+}
+
+// Holds lists the legal holds, active and released, oldest first.
+func (l *Ledger) Holds() ([]LegalHold, error) {
+	if err := l.ensureHoldSchema(); err != nil {
+		return nil, err
+	}
+	rows, err := l.db.Query(`SELECT id, case_id, from_ts, to_ts, created_at, released_at, release_id FROM ledger_holds ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	holds := []LegalHold{}
+	for rows.Next() {
+		var h LegalHold
+		if err := rows.Scan(&h.ID, &h.Case, &h.From, &h.To, &h.CreatedAt, &h.ReleasedAt, &h.ReleaseID); err != nil {
+			return nil, err
+		}
+		holds = append(holds, h)
+	}
+	return holds, rows.Err()
+}
+
+// activeHolds returns the holds that have not been released.
+func (l *Ledger) activeHolds() ([]LegalHold, error) {
+	holds, err := l.Holds()
+	if err != nil {
+		return nil, err
+	}
+	active := holds[:0]
+	for _, h := range holds {
+		if h.Active() {
+			active = append(active, h)
+		}
+	}
+	return active, nil
+}
+
+// holdCovering returns the first of holds covering ts, if any.
+func holdCovering(holds []LegalHold, ts int64) (LegalHold, bool) {
+	for _, h := range holds {
+		if h.Covers(ts) {
+			return h, true
+		}
+	}
+	return LegalHold{}, false
+}
+
+func (l *Ledger) ensureHoldSchema() error {
+	if l.holdsReady.Load() {
+		return nil
+	}
+	if _, err := l.db.Exec(holdSchema); err != nil {
+		return err
+	}
+	l.holdsReady.Store(true)
+	return nil
+}
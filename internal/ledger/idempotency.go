@@ -0,0 +1,216 @@
+package ledger
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrIdempotentRecordPruned is returned for an idempotency key whose
+// record was pruned before its hash was stored with the key.
+var ErrIdempotentRecordPruned = errors.New("record appended under idempotency key was pruned")
+
+// ErrIdempotencyConflict is returned when an idempotency key is used again
+// for a record whose type, source or payload differs from the record first
+// appended under it.
+var ErrIdempotencyConflict = errors.New("idempotency key reused for a different record")
+
+// AppendIdempotent appends input under the idempotency key key, as Append
+// does with RecordInput.IdempotencyKey set, and also reports whether the
+// record was created. When a record was already appended under key, that
+// record is returned and created is false.
+func (l *Ledger) AppendIdempotent(key string, input RecordInput) (rec Record, created bool, err error) {
+	if strings.TrimSpace(key) == "" {
+		return Record{}, false, fmt.Errorf("%w: idempotency key required", ErrInvalidRecord)
+	}
+	input.IdempotencyKey = key
+	records, createdAt, err := l.AppendBatchIdempotent([]RecordInput{input})
+	if err != nil {
+		return Record{}, false, err
+	}
+	return records[0], createdAt[0], nil
+}
+
+// AppendBatchIdempotent is AppendBatch that also reports, for each input,
+// whether its record was created or, for an input whose idempotency key
+// was used before, the existing record returned. A key used before for a
+// different type, source or payload fails with ErrIdempotencyConflict;
+// payloads are compared after canonicalization. When that record has
+// since been pruned, only its ID and hash are returned; a key stored
+// before hashes were kept with keys then fails with ErrIdempotentRecordPruned.
+func (l *Ledger) AppendBatchIdempotent(inputs []RecordInput) (records []Record, created []bool, err error) {
+	if len(inputs) == 0 {
+		return nil, nil, fmt.Errorf("%w: no inputs provided", ErrInvalidRecord)
+	}
+
+	defer l.observeAppend(time.Now())
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+
+	return l.appendBatchKeyed(inputs)
+}
+
+// appendBatchKeyed appends the inputs whose idempotency key is new, or
+// that have none, in one transaction. Inputs whose key is already stored,
+// or repeats a key earlier in the batch, resolve to the record appended
+// under it. The caller must hold writeMu.
+func (l *Ledger) appendBatchKeyed(inputs []RecordInput) ([]Record, []bool, error) {
+	if err := l.ensureSeqSchema(); err != nil {
+		return nil, nil, err
+	}
+	if err := l.ensureIdempotencySchema(); err != nil {
+		return nil, nil, err
+	}
+	for _, input := range inputs {
+		if input.AgentID != "" {
+			if err := l.ensureAgentSchema(); err != nil {
+				return nil, nil, err
+			}
+			break
+		}
+	}
+	for _, input := range inputs {
+		if len(input.Labels) > 0 {
+			if err := l.ensureLabelSchema(); err != nil {
+				return nil, nil, err
+			}
+			break
+		}
+	}
+	tx, err := l.db.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	// existing holds the stored record of each input whose key was used
+	// before, firstOfKey the input that appends each new key.
+	existing := map[int]Record{}
+	repeats := map[int]int{}
+	firstOfKey := map[string]int{}
+	requests := map[string]string{}
+	fresh := make([]RecordInput, 0, len(inputs))
+	for i, input := range inputs {
+		key := input.IdempotencyKey
+		if key == "" {
+			fresh = append(fresh, input)
+			continue
+		}
+		request, err := l.requestHash(tx, input)
+		if err != nil {
+			return nil, nil, err
+		}
+		if first, ok := firstOfKey[key]; ok {
+			if requests[key] != request {
+				return nil, nil, fmt.Errorf("%w: key %q", ErrIdempotencyConflict, key)
+			}
+			repeats[i] = first
+			continue
+		}
+		var stored Record
+		var storedRequest string
+		err = tx.QueryRow(`SELECT record_id, hash, request_hash FROM ledger_idempotency WHERE key = ?`, key).Scan(&stored.ID, &stored.Hash, &storedRequest)
+		switch {
+		case err == nil:
+			// Keys stored before requests were hashed match any retry.
+			if storedRequest != "" && storedRequest != request {
+				return nil, nil, fmt.Errorf("%w: key %q", ErrIdempotencyConflict, key)
+			}
+			existing[i] = stored
+			continue
+		case !errors.Is(err, sql.ErrNoRows):
+			return nil, nil, err
+		}
+		firstOfKey[key] = i
+		requests[key] = request
+		fresh = append(fresh, input)
+	}
+
+	var appended []Record
+	if len(fresh) > 0 {
+		if appended, err = l.appendBatchTx(tx, fresh); err != nil {
+			return nil, nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, nil, err
+		}
+		l.invalidateListCache()
+		l.countAppended(appended)
+		l.mirrorAppended(appended)
+		l.journalAppended(appended)
+		if err := l.Sync(); err != nil {
+			return nil, nil, err
+		}
+	}
+	_ = tx.Rollback()
+
+	records := make([]Record, len(inputs))
+	created := make([]bool, len(inputs))
+	next := 0
+	for i := range inputs {
+		if stored, ok := existing[i]; ok {
+			// The record may have been archived since, so resolve it
+			// through GetByID rather than the transaction.
+			records[i], err = l.GetByID(stored.ID)
+			switch {
+			case errors.Is(err, sql.ErrNoRows) && stored.Hash != "":
+				records[i] = stored
+			case errors.Is(err, sql.ErrNoRows):
+				return nil, nil, fmt.Errorf("%w: record %d", ErrIdempotentRecordPruned, stored.ID)
+			case err != nil:
+				return nil, nil, err
+			}
+			continue
+		}
+		if first, ok := repeats[i]; ok {
+			records[i] = records[first]
+			continue
+		}
+		records[i], created[i] = appended[next], true
+		next++
+	}
+	return records, created, nil
+}
+
+// requestHash returns the SHA-256 of the type, source and canonicalized
+// payload of input, which a retry under the same idempotency key must
+// repeat. Timestamps are left out, since a retry may default its own.
+func (l *Ledger) requestHash(q rowQueryer, input RecordInput) (string, error) {
+	if err := l.canonicalize(q, &input); err != nil {
+		return "", err
+	}
+	fields, err := json.Marshal([]string{input.Type, input.Source, input.Payload})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(fields)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// ensureIdempotencySchema adds the hash and request_hash columns to
+// idempotency tables created before them. Keys without a hash only resolve
+// while their record is stored, and keys without a request hash are not
+// checked against the retry.
+func (l *Ledger) ensureIdempotencySchema() error {
+	if l.idempotencyReady.Load() {
+		return nil
+	}
+	for _, column := range []string{"hash", "request_hash"} {
+		var n int
+		if err := l.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('ledger_idempotency') WHERE name = ?`, column).Scan(&n); err != nil {
+			return err
+		}
+		if n == 0 {
+			if _, err := l.db.Exec(`ALTER TABLE ledger_idempotency ADD COLUMN ` + column + ` TEXT NOT NULL DEFAULT ''`); err != nil {
+				return err
+			}
+		}
+	}
+	l.idempotencyReady.Store(true)
+	return nil
+}
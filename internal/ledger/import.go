@@ -0,0 +1,392 @@
+package ledger
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const defaultImportBatchSize = 5000
+
+// ErrImportChainBroken is returned by ImportJSONL when the records it
+// restores with their original hashes do not form a verifiable chain.
+var ErrImportChainBroken = errors.New("imported chain is broken")
+
+// ImportOptions controls bulk loading of historical events.
+type ImportOptions struct {
+	// BatchSize is the number of records committed per transaction.
+	BatchSize int
+	// RebuildChain recomputes hash and prev_hash for every imported record,
+	// continuing from the current chain head.
+	RebuildChain bool
+	// Restore loads an export into an empty ledger with its original ids
+	// and hashes, verifying the chain as it is read. Exactly one of
+	// RebuildChain and Restore must be set.
+	Restore bool
+	// DeferIndexes drops secondary indexes for the duration of the import
+	// and recreates them afterwards, which is much faster for large loads.
+	DeferIndexes bool
+	// FreeForm imports payloads of collector kinds without validating them.
+	FreeForm bool
+	// QuarantinePath, with Restore, receives the records
+	// from the first one that breaks the chain as JSON lines, and the
+	// records before it are imported. Without it a broken chain is refused.
+	QuarantinePath string
+}
+
+// ImportResult summarizes a bulk import.
+type ImportResult struct {
+	Imported int64  `json:"imported"`
+	Batches  int    `json:"batches"`
+	FirstID  int64  `json:"first_id,omitempty"`
+	LastID   int64  `json:"last_id,omitempty"`
+	LastHash string `json:"last_hash,omitempty"`
+	// FailedID and Reason identify the first restored record that breaks
+	// the chain; it and the records after it went to Quarantine.
+	FailedID    int64  `json:"failed_id,omitempty"`
+	Reason      string `json:"reason,omitempty"`
+	Quarantined int64  `json:"quarantined,omitempty"`
+	Quarantine  string `json:"quarantine,omitempty"`
+}
+
+// importLine is one line of a JSONL import. The payload may be either a
+// JSON string or an inline JSON value.
+type importLine struct {
+	Timestamp *int64            `json:"timestamp"`
+	TS        *int64            `json:"ts"`
+	Type      string            `json:"type"`
+	Source    string            `json:"source"`
+	Payload   json.RawMessage   `json:"payload"`
+	Labels    map[string]string `json:"labels"`
+}
+
+// ImportJSONL bulk-loads newline-delimited JSON events. With
+// opts.RebuildChain records are appended in input order inside batched
+// transactions and hashed sequentially. With opts.Restore the input must be
+// an export of a whole chain, restored into an empty ledger with its
+// original ids, hashes, labels, signatures and redactions: the chain is
+// verified as it is read, in one transaction, and a broken chain is refused
+// with ErrImportChainBroken unless opts.QuarantinePath is set.
+func (l *Ledger) ImportJSONL(r io.Reader, opts ImportOptions) (ImportResult, error) {
+	switch {
+	case opts.RebuildChain && opts.Restore:
+		return ImportResult{}, errors.New("import either rebuilds the chain or restores it, not both")
+	case !opts.RebuildChain && !opts.Restore:
+		return ImportResult{}, errors.New("import requires RebuildChain or Restore")
+	case opts.QuarantinePath != "" && !opts.Restore:
+		return ImportResult{}, errors.New("a quarantine path only applies to a restore")
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultImportBatchSize
+	}
+	if opts.Restore {
+		for _, ensure := range []func() error{l.ensureSignatureSchema, l.ensureRedactionSchema, l.ensureLabelSchema, l.ensureUIDSchema} {
+			if err := ensure(); err != nil {
+				return ImportResult{}, err
+			}
+		}
+	}
+
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+
+	if !opts.DeferIndexes {
+		return l.importLocked(r, opts)
+	}
+	for _, idx := range []string{"idx_ledger_records_ts", "idx_ledger_records_type", "idx_ledger_records_source"} {
+		if _, err := l.db.Exec(`DROP INDEX IF EXISTS ` + idx); err != nil {
+			return ImportResult{}, err
+		}
+	}
+	result, err := l.importLocked(r, opts)
+	// The indexes are recreated whether or not the import succeeded; a
+	// ledger left without them still works, but every query scans.
+	if _, ierr := l.db.Exec(schema); ierr != nil && err == nil {
+		err = fmt.Errorf("recreate indexes: %w", ierr)
+	}
+	return result, err
+}
+
+// importLocked performs ImportJSONL; the caller holds writeMu.
+func (l *Ledger) importLocked(r io.Reader, opts ImportOptions) (ImportResult, error) {
+	if opts.Restore {
+		return l.restoreJSONL(r, opts.QuarantinePath)
+	}
+
+	result := ImportResult{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), 64<<20)
+
+	batch := make([]RecordInput, 0, opts.BatchSize)
+	lineNo := 0
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		records, err := l.appendBatchLocked(batch)
+		if err != nil {
+			return err
+		}
+		if result.FirstID == 0 {
+			result.FirstID = records[0].ID
+		}
+		last := records[len(records)-1]
+		result.LastID = last.ID
+		result.LastHash = last.Hash
+		result.Imported += int64(len(records))
+		result.Batches++
+		batch = batch[:0]
+		return nil
+	}
+
+	for scanner.Scan() {
+		lineNo++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		input, err := parseImportLine(line)
+		if err == nil {
+			input.FreeForm = opts.FreeForm
+			err = input.validate()
+		}
+		if err != nil {
+			return result, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		batch = append(batch, input)
+
+		if len(batch) >= opts.BatchSize {
+			if err := flush(); err != nil {
+				return result, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return result, err
+	}
+	if err := flush(); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// restoreJSONL inserts the exported records read from r with their
+// original ids and hashes, checking each one continues the chain. The
+// caller holds writeMu.
+func (l *Ledger) restoreJSONL(r io.Reader, quarantinePath string) (ImportResult, error) {
+	tx, err := l.db.Begin()
+	if err != nil {
+		return ImportResult{}, err
+	}
+	defer tx.Rollback()
+
+	var live int64
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM ledger_records`).Scan(&live); err != nil {
+		return ImportResult{}, err
+	}
+	tip, err := archiveTip(tx)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	if live > 0 || tip != "" {
+		return ImportResult{}, errors.New("restoring original hashes requires an empty ledger")
+	}
+
+	stmt, err := tx.Prepare(`INSERT INTO ledger_records(id, ts, type, source, payload, hash, prev_hash) VALUES(?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	defer stmt.Close()
+
+	result := ImportResult{}
+	sigs := l.signatureCheck()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), 64<<20)
+
+	var quarantine *os.File
+	defer func() {
+		if quarantine != nil {
+			quarantine.Close()
+		}
+	}()
+	var prev string
+	var lastID int64
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return ImportResult{}, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+
+		if quarantine == nil {
+			if reason := restoreCheck(rec, prev, lastID, sigs); reason != "" {
+				if quarantinePath == "" {
+					return ImportResult{}, fmt.Errorf("%w at record %d (line %d): %s", ErrImportChainBroken, rec.ID, lineNo, reason)
+				}
+				if quarantine, err = os.OpenFile(quarantinePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600); err != nil {
+					return ImportResult{}, err
+				}
+				result.FailedID, result.Reason, result.Quarantine = rec.ID, reason, quarantinePath
+			}
+		}
+		if quarantine != nil {
+			// The line is kept as read, so the quarantine holds exactly
+			// what the export said.
+			if _, err := quarantine.Write(append(line, '\n')); err != nil {
+				return ImportResult{}, err
+			}
+			result.Quarantined++
+			continue
+		}
+
+		if _, err := stmt.Exec(rec.ID, rec.Timestamp, rec.Type, rec.Source, rec.Payload, rec.Hash, rec.PrevHash); err != nil {
+			return ImportResult{}, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		if err := restoreAttachments(tx, rec); err != nil {
+			return ImportResult{}, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		if result.FirstID == 0 {
+			result.FirstID = rec.ID
+		}
+		result.LastID, result.LastHash = rec.ID, rec.Hash
+		result.Imported++
+		prev, lastID = rec.Hash, rec.ID
+	}
+	if err := scanner.Err(); err != nil {
+		return ImportResult{}, err
+	}
+	if quarantine != nil {
+		err := quarantine.Close()
+		quarantine = nil
+		if err != nil {
+			return ImportResult{}, err
+		}
+	}
+	// A redaction is only proven by the record that logged it, so a chain
+	// cut before that record does not verify.
+	var redacted, by int64
+	err = tx.QueryRow(`SELECT record_id, redaction_id FROM ledger_redactions
+		WHERE redaction_id NOT IN (SELECT id FROM ledger_records) ORDER BY record_id LIMIT 1`).Scan(&redacted, &by)
+	if err == nil {
+		if result.Quarantine != "" {
+			os.Remove(result.Quarantine)
+		}
+		return ImportResult{}, fmt.Errorf("%w: record %d was redacted by record %d, which is not imported", ErrImportChainBroken, redacted, by)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return ImportResult{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return ImportResult{}, err
+	}
+	if result.Imported > 0 {
+		result.Batches = 1
+		l.invalidateListCache()
+	}
+	return result, l.Sync()
+}
+
+// restoreCheck returns why rec cannot follow the record lastID, whose hash
+// is prev, in a restored chain, or "" when it can.
+func restoreCheck(rec Record, prev string, lastID int64, sigs *signatureCheck) string {
+	switch {
+	case rec.ID <= lastID:
+		return "id out of order"
+	case rec.PrevHash != prev:
+		return "prev_hash mismatch"
+	case !hashMatches(prev, rec):
+		return "hash mismatch"
+	case sigs != nil:
+		return sigs.check(rec)
+	}
+	return ""
+}
+
+// restoreAttachments inserts the labels, signature, redaction and UID a
+// restored record was exported with. Seqs are numbered again by the
+// insert trigger, in the same order.
+func restoreAttachments(tx *sql.Tx, rec Record) error {
+	if err := insertLabels(tx, rec.ID, rec.Labels); err != nil {
+		return err
+	}
+	if rec.Signature != "" {
+		if _, err := tx.Exec(`INSERT INTO ledger_record_signatures(record_id, key_id, signature, key_ref) VALUES(?, ?, ?, ?)`,
+			rec.ID, rec.SigningKeyID, rec.Signature, rec.SigningKeyRef); err != nil {
+			return err
+		}
+	}
+	if r := rec.Redaction; r != nil {
+		if _, err := tx.Exec(`INSERT INTO ledger_redactions(record_id, payload_hash, reason, redaction_id, redacted_at) VALUES(?, ?, ?, ?, ?)`,
+			rec.ID, r.PayloadHash, r.Reason, r.RedactionID, r.RedactedAt); err != nil {
+			return err
+		}
+	}
+	if rec.UID != "" {
+		if _, err := tx.Exec(`INSERT INTO ledger_record_uids(record_id, uid) VALUES(?, ?)`, rec.ID, rec.UID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseImportLine(line []byte) (RecordInput, error) {
+	var in importLine
+	if err := json.Unmarshal(line, &in); err != nil {
+		return RecordInput{}, err
+	}
+
+	ts := in.Timestamp
+	if ts == nil {
+		ts = in.TS
+	}
+	if ts == nil {
+		return RecordInput{}, errors.New("timestamp required")
+	}
+
+	payload, err := importPayload(in.Payload)
+	if err != nil {
+		return RecordInput{}, err
+	}
+
+	return RecordInput{
+		Timestamp: *ts,
+		Type:      strings.TrimSpace(in.Type),
+		Source:    in.Source,
+		Payload:   payload,
+		Labels:    in.Labels,
+	}, nil
+}
+
+func importPayload(raw json.RawMessage) (string, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return "", errors.New("payload required")
+	}
+	if raw[0] == '"' {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return "", err
+		}
+		return s, nil
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, raw); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
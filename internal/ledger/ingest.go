@@ -0,0 +1,233 @@
+package ledger
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// MaxIngestBatch bounds the number of records in one agent push.
+const MaxIngestBatch = 10000
+
+// ErrSequenceGap is returned when a push does not continue from the agent's
+// last accepted sequence. The agent should resume from IngestCursor.
+var ErrSequenceGap = errors.New("sequence gap")
+
+// ErrInvalidProof is returned when a pushed record's chain proof does not
+// match its contents or does not follow the agent's previous record.
+var ErrInvalidProof = errors.New("invalid source chain proof")
+
+// AgentRecord is a record buffered by an agent. Seq numbers the agent's
+// records from 1 without gaps.
+//
+// Hash and PrevHash are the record's link in the agent's local chain,
+// computed with RecordHash when the record was buffered. They are optional;
+// when present the server verifies them and keeps them as proof of the
+// chain the agent recorded while offline.
+type AgentRecord struct {
+	Seq       int64  `json:"seq"`
+	Timestamp int64  `json:"timestamp"`
+	Type      string `json:"type"`
+	Source    string `json:"source"`
+	Payload   string `json:"payload"`
+	Hash      string `json:"hash,omitempty"`
+	PrevHash  string `json:"prev_hash,omitempty"`
+}
+
+// IngestResult acknowledges a push. LastSeq is the agent's last accepted
+// sequence; the agent may drop everything up to it.
+type IngestResult struct {
+	Accepted   int   `json:"accepted"`
+	Duplicates int   `json:"duplicates"`
+	LastSeq    int64 `json:"last_seq"`
+}
+
+// IngestCursor returns the last sequence accepted from an agent, or 0 if the
+// agent has not pushed yet.
+func (l *Ledger) IngestCursor(agentID string) (int64, error) {
+	if _, err := l.Agent(agentID); err != nil {
+		return 0, err
+	}
+	var seq int64
+	err := l.db.QueryRow(`SELECT last_seq FROM ledger_agent_cursors WHERE agent_id = ?`, agentID).Scan(&seq)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return seq, err
+}
+
+// IngestAgentBatch appends an agent's pushed records, attributed to the
+// agent, and advances its cursor in the same transaction. Records at or
+// below the cursor were accepted by an earlier push whose acknowledgement
+// was lost and are skipped, so a push can be retried safely. The remaining
+// records must continue the cursor without gaps.
+func (l *Ledger) IngestAgentBatch(agentID string, batch []AgentRecord) (IngestResult, error) {
+	if len(batch) > MaxIngestBatch {
+		return IngestResult{}, fmt.Errorf("batch exceeds %d records", MaxIngestBatch)
+	}
+	for i := 1; i < len(batch); i++ {
+		if batch[i].Seq != batch[i-1].Seq+1 {
+			return IngestResult{}, fmt.Errorf("%w: seq %d follows %d in batch", ErrSequenceGap, batch[i].Seq, batch[i-1].Seq)
+		}
+		if batch[i].Hash != "" && batch[i-1].Hash != "" && batch[i].PrevHash != batch[i-1].Hash {
+			return IngestResult{}, fmt.Errorf("%w: seq %d does not follow seq %d", ErrInvalidProof, batch[i].Seq, batch[i-1].Seq)
+		}
+	}
+	for _, rec := range batch {
+		if rec.Hash != "" && !RecordHashMatches(rec.Hash, rec.PrevHash, rec.Timestamp, rec.Type, rec.Source, rec.Payload) {
+			return IngestResult{}, fmt.Errorf("%w: hash mismatch at seq %d", ErrInvalidProof, rec.Seq)
+		}
+	}
+	if err := l.ensureAgentSchema(); err != nil {
+		return IngestResult{}, err
+	}
+	if err := l.ensureSeqSchema(); err != nil {
+		return IngestResult{}, err
+	}
+
+	defer l.observeAppend(time.Now())
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+
+	tx, err := l.db.Begin()
+	if err != nil {
+		return IngestResult{}, err
+	}
+	defer tx.Rollback()
+
+	var registered int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM ledger_agents WHERE id = ?`, agentID).Scan(&registered); err != nil {
+		return IngestResult{}, err
+	}
+	if registered == 0 {
+		return IngestResult{}, fmt.Errorf("agent %s: %w", agentID, ErrUnknownAgent)
+	}
+
+	var last int64
+	err = tx.QueryRow(`SELECT last_seq FROM ledger_agent_cursors WHERE agent_id = ?`, agentID).Scan(&last)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return IngestResult{}, err
+	}
+
+	var head string
+	err = tx.QueryRow(`SELECT hash FROM ledger_agent_proofs WHERE agent_id = ? ORDER BY seq DESC LIMIT 1`, agentID).Scan(&head)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return IngestResult{}, err
+	}
+
+	result := IngestResult{LastSeq: last}
+	var inputs []RecordInput
+	var accepted []AgentRecord
+	for _, rec := range batch {
+		if rec.Seq <= last {
+			result.Duplicates++
+			continue
+		}
+		if len(inputs) == 0 && rec.Seq != last+1 {
+			return result, fmt.Errorf("%w: expected seq %d, got %d", ErrSequenceGap, last+1, rec.Seq)
+		}
+		// An empty PrevHash starts a new chain, as a recreated spool does.
+		if len(inputs) == 0 && rec.PrevHash != "" && rec.PrevHash != head {
+			return result, fmt.Errorf("%w: seq %d does not follow the agent's last accepted record", ErrInvalidProof, rec.Seq)
+		}
+		accepted = append(accepted, rec)
+		inputs = append(inputs, RecordInput{
+			Timestamp: rec.Timestamp,
+			Type:      rec.Type,
+			Source:    rec.Source,
+			Payload:   rec.Payload,
+			AgentID:   agentID,
+		})
+		result.LastSeq = rec.Seq
+	}
+	if len(inputs) == 0 {
+		return result, nil
+	}
+
+	records, err := l.appendBatchTx(tx, inputs)
+	if err != nil {
+		return IngestResult{LastSeq: last}, err
+	}
+	for i, rec := range accepted {
+		if rec.Hash == "" {
+			continue
+		}
+		if records[i].Payload != rec.Payload {
+			// The proof covers the bytes the agent sent, which the
+			// ledger would not keep.
+			return IngestResult{LastSeq: last}, fmt.Errorf("%w: seq %d: payloads of canonical type %s must be sent as canonical JSON", ErrInvalidPayload, rec.Seq, rec.Type)
+		}
+		if _, err := tx.Exec(`INSERT INTO ledger_agent_proofs(record_id, agent_id, seq, hash, prev_hash) VALUES(?, ?, ?, ?, ?)`,
+			records[i].ID, agentID, rec.Seq, rec.Hash, rec.PrevHash); err != nil {
+			return IngestResult{LastSeq: last}, err
+		}
+	}
+	if _, err := tx.Exec(`INSERT INTO ledger_agent_cursors(agent_id, last_seq) VALUES(?, ?)
+		ON CONFLICT(agent_id) DO UPDATE SET last_seq = excluded.last_seq`, agentID, result.LastSeq); err != nil {
+		return IngestResult{LastSeq: last}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return IngestResult{LastSeq: last}, err
+	}
+	l.invalidateListCache()
+	l.countAppended(records)
+	l.mirrorAppended(records)
+	l.journalAppended(records)
+
+	result.Accepted = len(inputs)
+	return result, l.Sync()
+}
+
+// VerifyAgentChain checks the chain proofs kept for an agent's records. Each
+// record must still hash to the value the agent computed when it buffered
+// it, and each proof must follow the agent's previous one. A proof with an
+// empty prev hash starts a new chain. Records that were pruned from the
+// ledger are checked for linkage only.
+func (l *Ledger) VerifyAgentChain(agentID string) (VerifyResult, error) {
+	if _, err := l.Agent(agentID); err != nil {
+		return VerifyResult{}, err
+	}
+	if err := l.ensureRedactionSchema(); err != nil {
+		return VerifyResult{}, err
+	}
+	rows, err := l.db.Query(`SELECT p.record_id, p.hash, p.prev_hash, r.id IS NOT NULL, COALESCE(r.ts, 0), COALESCE(r.type, ''), COALESCE(r.source, ''), COALESCE(r.payload, ''), COALESCE(x.payload_hash, '')
+		FROM ledger_agent_proofs p LEFT JOIN ledger_records r ON r.id = p.record_id
+		LEFT JOIN ledger_redactions x ON x.record_id = p.record_id
+		WHERE p.agent_id = ? ORDER BY p.seq ASC`, agentID)
+	if err != nil {
+		return VerifyResult{}, err
+	}
+	defer rows.Close()
+
+	fail := func(id, checked int64, reason string) VerifyResult {
+		return VerifyResult{OK: false, FailedID: id, Reason: reason, Checked: checked, Timestamp: time.Now().Unix()}
+	}
+
+	var prev string
+	var checked int64
+	for rows.Next() {
+		var id, ts int64
+		var hash, prevHash, rtype, source, payload, payloadHash string
+		var present bool
+		if err := rows.Scan(&id, &hash, &prevHash, &present, &ts, &rtype, &source, &payload, &payloadHash); err != nil {
+			return VerifyResult{}, err
+		}
+		if prevHash != "" && prevHash != prev {
+			return fail(id, checked, "prev_hash mismatch"), nil
+		}
+		rec := Record{Timestamp: ts, Type: rtype, Source: source, Payload: payload, Hash: hash}
+		if payloadHash != "" {
+			rec.Redaction = &Redaction{PayloadHash: payloadHash}
+		}
+		if present && !hashMatches(prevHash, rec) {
+			return fail(id, checked, "hash mismatch"), nil
+		}
+		prev = hash
+		checked++
+	}
+	if err := rows.Err(); err != nil {
+		return VerifyResult{}, err
+	}
+	return VerifyResult{OK: true, Checked: checked, Timestamp: time.Now().Unix()}, nil
+}
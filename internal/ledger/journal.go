@@ -0,0 +1,413 @@
+package ledger
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrJournalDiverged is returned when a journal's records do not match the
+// ledger it is attached to.
+var ErrJournalDiverged = errors.New("journal diverged from ledger")
+
+// journalBatch bounds how many records a journal catch-up reads at a time.
+const journalBatch = 500
+
+// journal is an append-only file holding one JSON record per line, written
+// after each commit so that the chain can be cross-checked or rebuilt if
+// the SQLite file is damaged. The records carry their own hash chain.
+type journal struct {
+	path string
+
+	mu     sync.Mutex
+	file   *os.File
+	status JournalStatus
+}
+
+// JournalStatus summarizes an attached journal.
+type JournalStatus struct {
+	Path     string `json:"path"`
+	LastID   int64  `json:"last_id"`
+	LastHash string `json:"last_hash"`
+	Written  int64  `json:"written"`
+	// Behind is set when a write failed; the missing records are written on
+	// the next append.
+	Behind    bool   `json:"behind"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// JournalCheck is the result of verifying a journal file.
+type JournalCheck struct {
+	OK       bool   `json:"ok"`
+	Records  int64  `json:"records"`
+	FirstID  int64  `json:"first_id,omitempty"`
+	LastID   int64  `json:"last_id,omitempty"`
+	LastHash string `json:"last_hash,omitempty"`
+	// TornTail is set when the file ends in an incomplete line, as left by
+	// a crash mid-write. The records before it are still usable.
+	TornTail bool   `json:"torn_tail,omitempty"`
+	FailedID int64  `json:"failed_id,omitempty"`
+	Line     int64  `json:"line,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+
+	// Set when the journal is compared with a ledger.
+	Compared bool `json:"compared,omitempty"`
+	// Lag is the number of live ledger records after the journal's last
+	// record.
+	Lag int64 `json:"lag,omitempty"`
+	// MismatchID is the first record whose hash differs between the
+	// journal and the ledger.
+	MismatchID int64 `json:"mismatch_id,omitempty"`
+	// MissingFromLedger counts journal records after the ledger's last live
+	// record, which the ledger has lost.
+	MissingFromLedger int64 `json:"missing_from_ledger,omitempty"`
+}
+
+// SetJournal writes every record committed to l to the journal file at path
+// as well, fsyncing once per batch. A journal attached to a ledger that
+// already holds records is backfilled first; an existing journal must match
+// the ledger's chain. An incomplete last line left by a crash is dropped and
+// the record rewritten from the ledger.
+func (l *Ledger) SetJournal(path string) error {
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+
+	if path == "" {
+		if old := l.journal.Swap(nil); old != nil {
+			return old.close()
+		}
+		return nil
+	}
+
+	check, err := VerifyJournal(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err == nil && !check.OK {
+		return fmt.Errorf("journal %s: %s at line %d", path, check.Reason, check.Line)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	if check.TornTail {
+		if err := truncateTornTail(f); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		f.Close()
+		return err
+	}
+
+	j := &journal{path: path, file: f, status: JournalStatus{Path: path, LastID: check.LastID, LastHash: check.LastHash}}
+	if check.LastID > 0 {
+		var hash string
+		err := l.db.QueryRow(`SELECT hash FROM ledger_records WHERE id = ?`, check.LastID).Scan(&hash)
+		if err != nil || hash != check.LastHash {
+			f.Close()
+			return fmt.Errorf("%w: journal record %d is not in the ledger", ErrJournalDiverged, check.LastID)
+		}
+	}
+	if _, err := j.catchUp(l); err != nil {
+		f.Close()
+		return err
+	}
+
+	if old := l.journal.Swap(j); old != nil {
+		_ = old.close()
+	}
+	return nil
+}
+
+// JournalStatus reports the attached journal's progress. ok is false when
+// no journal is attached.
+func (l *Ledger) JournalStatus() (status JournalStatus, ok bool) {
+	j := l.journal.Load()
+	if j == nil {
+		return JournalStatus{}, false
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status, true
+}
+
+// journalAppended writes records just committed on l. The caller must hold
+// l.writeMu so that records reach the journal in commit order. A failed
+// write does not fail the append; the journal catches up on the next one.
+func (l *Ledger) journalAppended(records []Record) {
+	j := l.journal.Load()
+	if j == nil || len(records) == 0 {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.status.Behind || records[0].ID != j.status.LastID+1 {
+		// Records appended by another process, or after a failed write,
+		// are written along with these.
+		_, _ = j.catchUp(l)
+		return
+	}
+	_ = j.write(records)
+}
+
+// catchUp writes the ledger records that follow the journal's last record.
+func (j *journal) catchUp(l *Ledger) (int, error) {
+	written := 0
+	for {
+		records, err := l.recordsAfter(j.status.LastID, journalBatch)
+		if err != nil {
+			j.fail(err)
+			return written, err
+		}
+		if len(records) == 0 {
+			j.status.Behind = false
+			return written, nil
+		}
+		if err := j.write(records); err != nil {
+			return written, err
+		}
+		written += len(records)
+	}
+}
+
+// write appends records to the file and fsyncs it.
+func (j *journal) write(records []Record) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, rec := range records {
+		rec.AgentID = ""
+		if err := enc.Encode(rec); err != nil {
+			j.fail(err)
+			return err
+		}
+	}
+	if _, err := j.file.Write(buf.Bytes()); err != nil {
+		j.fail(err)
+		return err
+	}
+	if err := j.file.Sync(); err != nil {
+		j.fail(err)
+		return err
+	}
+	last := records[len(records)-1]
+	j.status.LastID = last.ID
+	j.status.LastHash = last.Hash
+	j.status.Written += int64(len(records))
+	return nil
+}
+
+func (j *journal) fail(err error) {
+	j.status.Behind = true
+	j.status.LastError = fmt.Sprintf("%s: %v", time.Now().UTC().Format(time.RFC3339), err)
+}
+
+func (j *journal) close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.file.Close()
+}
+
+// truncateTornTail cuts f after its last complete line.
+func truncateTornTail(f *os.File) error {
+	data, err := io.ReadAll(io.NewSectionReader(f, 0, 1<<62))
+	if err != nil {
+		return err
+	}
+	end := bytes.LastIndexByte(data, '\n') + 1
+	if err := f.Truncate(int64(end)); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// readJournal calls fn for each complete record in the journal at path.
+// A final line without a newline is reported as a torn tail, not an error.
+func readJournal(path string, fn func(line int64, rec Record) error) (torn bool, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReaderSize(f, 64*1024)
+	var line int64
+	for {
+		data, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return len(data) > 0, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		line++
+		var rec Record
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return false, fmt.Errorf("line %d: %w", line, err)
+		}
+		if err := fn(line, rec); err != nil {
+			return false, err
+		}
+	}
+}
+
+// errJournalInvalid stops readJournal when a record fails verification.
+var errJournalInvalid = errors.New("invalid journal")
+
+// VerifyJournal checks the hash chain of the journal file at path. The
+// first record may chain to records written before the journal was
+// started.
+func VerifyJournal(path string) (JournalCheck, error) {
+	check := JournalCheck{OK: true}
+	invalid := func(line int64, id int64, reason string) error {
+		check.OK = false
+		check.Line = line
+		check.FailedID = id
+		check.Reason = reason
+		return errJournalInvalid
+	}
+
+	torn, err := readJournal(path, func(line int64, rec Record) error {
+		if check.Records > 0 {
+			if rec.ID <= check.LastID {
+				return invalid(line, rec.ID, "record id out of order")
+			}
+			if rec.PrevHash != check.LastHash {
+				return invalid(line, rec.ID, "prev_hash mismatch")
+			}
+		}
+		if !hashMatches(rec.PrevHash, rec) {
+			return invalid(line, rec.ID, "hash mismatch")
+		}
+		if check.Records == 0 {
+			check.FirstID = rec.ID
+		}
+		check.Records++
+		check.LastID = rec.ID
+		check.LastHash = rec.Hash
+		return nil
+	})
+	if errors.Is(err, errJournalInvalid) {
+		return check, nil
+	}
+	if err != nil {
+		var syntax *json.SyntaxError
+		if errors.As(err, &syntax) {
+			check.OK = false
+			check.Line = check.Records + 1
+			check.Reason = "malformed record"
+			return check, nil
+		}
+		return JournalCheck{}, err
+	}
+	check.TornTail = torn
+	return check, nil
+}
+
+// CompareJournal verifies the journal at path and compares it with l's
+// live records. Records the ledger has archived are not compared.
+func CompareJournal(l *Ledger, path string) (JournalCheck, error) {
+	check, err := VerifyJournal(path)
+	if err != nil || !check.OK {
+		return check, err
+	}
+	check.Compared = true
+
+	ledgerHashes := map[int64]string{}
+	var ledgerLast int64
+	rows, err := l.db.Query(`SELECT id, hash FROM ledger_records WHERE id >= ? ORDER BY id ASC`, check.FirstID)
+	if err != nil {
+		return JournalCheck{}, err
+	}
+	for rows.Next() {
+		var id int64
+		var hash string
+		if err := rows.Scan(&id, &hash); err != nil {
+			rows.Close()
+			return JournalCheck{}, err
+		}
+		ledgerHashes[id] = hash
+		ledgerLast = id
+		if id > check.LastID {
+			check.Lag++
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return JournalCheck{}, err
+	}
+
+	_, err = readJournal(path, func(_ int64, rec Record) error {
+		if rec.ID > ledgerLast {
+			check.MissingFromLedger++
+			return nil
+		}
+		hash, ok := ledgerHashes[rec.ID]
+		if ok && hash != rec.Hash && check.MismatchID == 0 {
+			check.MismatchID = rec.ID
+		}
+		return nil
+	})
+	if err != nil {
+		return JournalCheck{}, err
+	}
+	if check.MismatchID != 0 || check.MissingFromLedger > 0 {
+		check.OK = false
+		check.Reason = "ledger does not match journal"
+	}
+	return check, nil
+}
+
+// RebuildFromJournal creates a new ledger at dbPath holding every complete
+// record of the journal at journalPath, with their original IDs and hashes.
+// dbPath must not exist.
+func RebuildFromJournal(journalPath, dbPath string) (JournalCheck, error) {
+	check, err := VerifyJournal(journalPath)
+	if err != nil {
+		return JournalCheck{}, err
+	}
+	if !check.OK {
+		return check, fmt.Errorf("journal %s: %s at line %d", journalPath, check.Reason, check.Line)
+	}
+	if _, err := os.Stat(dbPath); err == nil {
+		return check, fmt.Errorf("%s already exists", dbPath)
+	}
+
+	l, err := Open(dbPath)
+	if err != nil {
+		return check, err
+	}
+	defer l.Close()
+	if err := l.InitSchema(); err != nil {
+		return check, err
+	}
+
+	tx, err := l.db.Begin()
+	if err != nil {
+		return check, err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(`INSERT INTO ledger_records(id, ts, type, source, payload, hash, prev_hash) VALUES(?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return check, err
+	}
+	defer stmt.Close()
+	_, err = readJournal(journalPath, func(_ int64, rec Record) error {
+		if _, err := stmt.Exec(rec.ID, rec.Timestamp, rec.Type, rec.Source, rec.Payload, rec.Hash, rec.PrevHash); err != nil {
+			return err
+		}
+		return insertLabels(tx, rec.ID, rec.Labels)
+	})
+	if err != nil {
+		return check, err
+	}
+	return check, tx.Commit()
+}
@@ -0,0 +1,112 @@
+package ledger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ErrInvalidJSONPath is returned for a JSON filter path that is not a
+// dot-separated list of field names.
+var ErrInvalidJSONPath = errors.New("invalid JSON path")
+
+// jsonPathField is one field of a JSON filter path. Paths are spliced into
+// SQL, so that an index on the path can serve the filter, and are limited
+// to plain identifiers.
+var jsonPathField = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// normalizeJSONPath validates a dotted field path such as "data.order_id",
+// also accepted as "$.data.order_id", and returns it without the "$.".
+func normalizeJSONPath(path string) (string, error) {
+	path = strings.TrimPrefix(path, "$.")
+	for _, field := range strings.Split(path, ".") {
+		if !jsonPathField.MatchString(field) {
+			return "", fmt.Errorf("%w %q: want dot-separated field names", ErrInvalidJSONPath, path)
+		}
+	}
+	return path, nil
+}
+
+// jsonPathExpr is the SQL value of path in a record's payload: the value
+// as text, or NULL when the payload is not JSON or has no such field. The
+// filter and IndexJSONPath must use the same expression.
+func jsonPathExpr(path string) (string, error) {
+	path, err := normalizeJSONPath(path)
+	if err != nil {
+		return "", err
+	}
+	return "CASE WHEN json_valid(payload) THEN CAST(json_extract(payload, '$." + path + "') AS TEXT) END", nil
+}
+
+// IndexJSONPath indexes the payload field at path, so that List queries
+// filtering on it with JSONFilters read only the matching records.
+func (l *Ledger) IndexJSONPath(path string) error {
+	expr, err := jsonPathExpr(path)
+	if err != nil {
+		return err
+	}
+	path, _ = normalizeJSONPath(path)
+	_, err = l.db.Exec(`CREATE INDEX IF NOT EXISTS "idx_ledger_records_json_` + path + `" ON ledger_records(` + expr + `)`)
+	return err
+}
+
+// JSONPathValue returns the value at path in a JSON payload as a JSON
+// filter compares it: strings as they are, numbers in their JSON form,
+// booleans as "1" and "0". ok is false when the payload is not JSON, the
+// field is missing or null, or the value is an object or array.
+func JSONPathValue(payload, path string) (value string, ok bool) {
+	path, err := normalizeJSONPath(path)
+	if err != nil {
+		return "", false
+	}
+	dec := json.NewDecoder(bytes.NewReader([]byte(payload)))
+	dec.UseNumber()
+	var v any
+	if dec.Decode(&v) != nil {
+		return "", false
+	}
+	for _, field := range strings.Split(path, ".") {
+		obj, isObj := v.(map[string]any)
+		if !isObj {
+			return "", false
+		}
+		if v, ok = obj[field]; !ok {
+			return "", false
+		}
+	}
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		if v {
+			return "1", true
+		}
+		return "0", true
+	}
+	return "", false
+}
+
+// checkJSONFilters validates the paths of JSON filters.
+func checkJSONFilters(filters map[string]string) error {
+	for path := range filters {
+		if _, err := normalizeJSONPath(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchesJSONFilters applies JSON filters to a record held in memory.
+func matchesJSONFilters(filters map[string]string, payload string) bool {
+	for path, want := range filters {
+		if got, ok := JSONPathValue(payload, path); !ok || got != want {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,131 @@
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Positions a consumer group can be reset to from a replay plan.
+const (
+	// KafkaResetFirst resets to the first planned mutation of each
+	// partition, so consumers process the plan again.
+	KafkaResetFirst = "first"
+	// KafkaResetNext resets to the offset after the last planned mutation,
+	// so consumers resume where the reconstructed state ends.
+	KafkaResetNext = "next"
+)
+
+// KafkaOffset is the offset of one topic partition.
+type KafkaOffset struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	Offset    int64  `json:"offset"`
+}
+
+// KafkaReset is a consumer group offset reset derived from a replay plan,
+// shaped as the Admin API's AlterConsumerGroupOffsets request. Skipped
+// lists the namespaces whose mutations lack numeric offsets.
+type KafkaReset struct {
+	Group    string        `json:"group"`
+	Position string        `json:"position"`
+	Offsets  []KafkaOffset `json:"offsets"`
+	Skipped  []string      `json:"skipped,omitempty"`
+}
+
+// KafkaResetFromPlan converts the offsets of each namespace of plan into
+// an offset reset of group to position, KafkaResetFirst or KafkaResetNext.
+// Namespaces are read as [kafka:]topic[:partition]; without a partition
+// they are taken as partition 0 of a single-partition topic. Offsets are
+// ordered by topic and partition.
+func KafkaResetFromPlan(plan *ReplayPlan, group, position string) (KafkaReset, error) {
+	if strings.TrimSpace(group) == "" {
+		return KafkaReset{}, errors.New("consumer group is required")
+	}
+	if position != KafkaResetFirst && position != KafkaResetNext {
+		return KafkaReset{}, fmt.Errorf("unknown position %q: use %s or %s", position, KafkaResetFirst, KafkaResetNext)
+	}
+	reset := KafkaReset{Group: group, Position: position, Offsets: []KafkaOffset{}}
+	if plan == nil {
+		return reset, nil
+	}
+
+	type partition struct {
+		topic string
+		id    int32
+	}
+	offsets := map[partition]int64{}
+	for _, ns := range plan.Namespaces {
+		if !ns.Ordered || len(ns.Records) == 0 {
+			reset.Skipped = append(reset.Skipped, ns.Namespace)
+			continue
+		}
+		topic, id := KafkaTopicPartition(ns.Namespace)
+		p := partition{topic, id}
+		// Records of an ordered namespace are sorted by offset.
+		offset := ns.Records[0].Offset
+		if position == KafkaResetNext {
+			offset = ns.Records[len(ns.Records)-1].Offset + 1
+		}
+		// Namespaces naming the same partition, such as kafka:orders and
+		// orders, take the earliest first or the latest next offset.
+		if prev, ok := offsets[p]; ok && (position == KafkaResetFirst) == (prev < offset) {
+			continue
+		}
+		offsets[p] = offset
+	}
+	for p, offset := range offsets {
+		reset.Offsets = append(reset.Offsets, KafkaOffset{Topic: p.topic, Partition: p.id, Offset: offset})
+	}
+	sort.Slice(reset.Offsets, func(i, j int) bool {
+		a, b := reset.Offsets[i], reset.Offsets[j]
+		if a.Topic == b.Topic {
+			return a.Partition < b.Partition
+		}
+		return a.Topic < b.Topic
+	})
+	return reset, nil
+}
+
+// KafkaTopicPartition reads a mutation namespace as
+// [kafka:]topic[:partition], partition 0 when it has none.
+func KafkaTopicPartition(namespace string) (string, int32) {
+	topic := strings.TrimPrefix(namespace, "kafka:")
+	if idx := strings.LastIndex(topic, ":"); idx != -1 {
+		if id, err := strconv.ParseInt(topic[idx+1:], 10, 32); err == nil && id >= 0 {
+			return topic[:idx], int32(id)
+		}
+	}
+	return topic, 0
+}
+
+// Script returns a shell script that applies the reset with
+// kafka-consumer-groups, one partition per command. The bootstrap server
+// is read from $BOOTSTRAP_SERVER. Unless execute is set the commands only
+// print the resulting offsets (--dry-run).
+func (r KafkaReset) Script(execute bool) string {
+	mode := "--dry-run"
+	if execute {
+		mode = "--execute"
+	}
+	var b strings.Builder
+	b.WriteString("#!/bin/sh\n")
+	fmt.Fprintf(&b, "# Resets consumer group %s to the %s offsets of a StateLedger replay plan.\n", r.Group, r.Position)
+	for _, ns := range r.Skipped {
+		fmt.Fprintf(&b, "# Skipped namespace %s: its mutations lack numeric offsets.\n", ns)
+	}
+	b.WriteString("set -e\n")
+	b.WriteString(": \"${BOOTSTRAP_SERVER:?set BOOTSTRAP_SERVER to the Kafka bootstrap server}\"\n")
+	for _, o := range r.Offsets {
+		fmt.Fprintf(&b, "kafka-consumer-groups --bootstrap-server \"$BOOTSTRAP_SERVER\" --group %s --reset-offsets --topic %s --to-offset %d %s\n",
+			shellQuote(r.Group), shellQuote(o.Topic+":"+strconv.Itoa(int(o.Partition))), o.Offset, mode)
+	}
+	return b.String()
+}
+
+// shellQuote quotes s as a single POSIX shell word.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,222 @@
+package ledger
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Retr0-XD/StateLedger/internal/collectors"
+)
+
+const keySchema = `
+CREATE TABLE IF NOT EXISTS ledger_keys (
+	purpose TEXT NOT NULL,
+	version INTEGER NOT NULL,
+	key_id TEXT NOT NULL,
+	created_at INTEGER NOT NULL,
+	record_id INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (purpose, version)
+);
+`
+
+// Key purposes that can be rotated.
+const (
+	// KeyArchive signs archive segments.
+	KeyArchive = "archive"
+	// KeyDatabase encrypts the database file.
+	KeyDatabase = "database"
+)
+
+// KeyRotatedRecordType is the type of the records appended when a key is
+// rotated, with the purpose as source.
+const KeyRotatedRecordType = "key.rotated"
+
+// ErrKeyMismatch is returned when a key is not the current version for
+// its purpose.
+var ErrKeyMismatch = errors.New("key is not the current key version")
+
+// KeyVersion is a version of a key. Only its ID, a fingerprint, is stored.
+// RecordID is the ID of the rotation record that introduced it, or 0 for
+// the key in use before the first rotation.
+type KeyVersion struct {
+	Purpose   string `json:"purpose"`
+	Version   int    `json:"version"`
+	KeyID     string `json:"key_id"`
+	CreatedAt int64  `json:"created_at"`
+	RecordID  int64  `json:"record_id,omitempty"`
+}
+
+type keyRotatedRecord struct {
+	Purpose         string `json:"purpose"`
+	Version         int    `json:"version"`
+	KeyID           string `json:"key_id"`
+	PreviousVersion int    `json:"previous_version"`
+	PreviousKeyID   string `json:"previous_key_id"`
+}
+
+// KeyID returns the fingerprint that identifies key in key versions and
+// archive segments.
+func KeyID(key []byte) string {
+	sum := sha256.Sum256(append([]byte("stateledger-key|"), key...))
+	return hex.EncodeToString(sum[:8])
+}
+
+// RotateKey makes next the current key for purpose in place of current,
+// which must be the current version, and appends a key.rotated record.
+// The key in use before the first rotation becomes version 1. Rotating the
+// database key re-encrypts the database file with next. Archive segments
+// keep the ID of the key that signed them, so retired archive keys passed
+// to SetArchiveKey still verify them.
+func (l *Ledger) RotateKey(purpose string, current, next []byte) (KeyVersion, error) {
+	switch purpose {
+	case KeyArchive:
+	case KeyDatabase:
+		if !l.Encrypted() {
+			return KeyVersion{}, errors.New("database is not encrypted")
+		}
+	default:
+		return KeyVersion{}, fmt.Errorf("unknown key purpose %q", purpose)
+	}
+	if len(current) == 0 || len(next) == 0 {
+		return KeyVersion{}, errors.New("current and new key required")
+	}
+	if err := l.ensureKeySchema(); err != nil {
+		return KeyVersion{}, err
+	}
+
+	versions, err := l.KeyVersions(purpose)
+	if err != nil {
+		return KeyVersion{}, err
+	}
+	prev := KeyVersion{Purpose: purpose, Version: 1, KeyID: KeyID(current), CreatedAt: time.Now().Unix()}
+	if len(versions) > 0 {
+		prev = versions[len(versions)-1]
+		if prev.KeyID != KeyID(current) {
+			return KeyVersion{}, fmt.Errorf("%w %d for %s", ErrKeyMismatch, prev.Version, purpose)
+		}
+	}
+	if KeyID(next) == prev.KeyID {
+		return KeyVersion{}, errors.New("new key is the current key")
+	}
+	for _, v := range versions {
+		if v.KeyID == KeyID(next) {
+			return KeyVersion{}, fmt.Errorf("new key is %s key version %d", purpose, v.Version)
+		}
+	}
+
+	kv := KeyVersion{Purpose: purpose, Version: prev.Version + 1, KeyID: KeyID(next), CreatedAt: time.Now().Unix()}
+	payload, err := collectors.MarshalPayload(keyRotatedRecord{
+		Purpose:         purpose,
+		Version:         kv.Version,
+		KeyID:           kv.KeyID,
+		PreviousVersion: prev.Version,
+		PreviousKeyID:   prev.KeyID,
+	})
+	if err != nil {
+		return KeyVersion{}, err
+	}
+
+	// The file is rewritten with the new key first: if that fails nothing
+	// has changed, and the rotation record is then flushed under the new key.
+	if purpose == KeyDatabase {
+		if err := l.encrypted.rekey(next); err != nil {
+			return KeyVersion{}, fmt.Errorf("re-encrypt database: %w", err)
+		}
+	}
+
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+
+	tx, err := l.db.Begin()
+	if err != nil {
+		return KeyVersion{}, err
+	}
+	defer tx.Rollback()
+
+	if len(versions) == 0 {
+		if _, err := tx.Exec(`INSERT INTO ledger_keys(purpose, version, key_id, created_at) VALUES(?, ?, ?, ?)`,
+			prev.Purpose, prev.Version, prev.KeyID, prev.CreatedAt); err != nil {
+			return KeyVersion{}, err
+		}
+	}
+	records, err := l.appendBatchTx(tx, []RecordInput{{
+		Timestamp: kv.CreatedAt,
+		Type:      KeyRotatedRecordType,
+		Source:    purpose,
+		Payload:   payload,
+	}})
+	if err != nil {
+		return KeyVersion{}, err
+	}
+	kv.RecordID = records[0].ID
+	if _, err := tx.Exec(`INSERT INTO ledger_keys(purpose, version, key_id, created_at, record_id) VALUES(?, ?, ?, ?, ?)`,
+		kv.Purpose, kv.Version, kv.KeyID, kv.CreatedAt, kv.RecordID); err != nil {
+		return KeyVersion{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return KeyVersion{}, err
+	}
+	l.invalidateListCache()
+	l.countAppended(records)
+	l.mirrorAppended(records)
+	l.journalAppended(records)
+	return kv, l.Sync()
+}
+
+// KeyVersions lists the versions of the key for purpose, oldest first. It
+// is empty until the key is first rotated.
+func (l *Ledger) KeyVersions(purpose string) ([]KeyVersion, error) {
+	if err := l.ensureKeySchema(); err != nil {
+		return nil, err
+	}
+	rows, err := l.db.Query(`SELECT purpose, version, key_id, created_at, record_id FROM ledger_keys WHERE purpose = ? ORDER BY version`, purpose)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []KeyVersion{}
+	for rows.Next() {
+		var v KeyVersion
+		if err := rows.Scan(&v.Purpose, &v.Version, &v.KeyID, &v.CreatedAt, &v.RecordID); err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+// checkCurrentKey fails with ErrKeyMismatch when purpose has been rotated
+// and key is not its latest version.
+func (l *Ledger) checkCurrentKey(purpose string, key []byte) error {
+	if err := l.ensureKeySchema(); err != nil {
+		return err
+	}
+	var version int
+	var id string
+	err := l.db.QueryRow(`SELECT version, key_id FROM ledger_keys WHERE purpose = ? ORDER BY version DESC LIMIT 1`, purpose).Scan(&version, &id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if id != KeyID(key) {
+		return fmt.Errorf("%w %d for %s", ErrKeyMismatch, version, purpose)
+	}
+	return nil
+}
+
+func (l *Ledger) ensureKeySchema() error {
+	if l.keysReady.Load() {
+		return nil
+	}
+	if _, err := l.db.Exec(keySchema); err != nil {
+		return err
+	}
+	l.keysReady.Store(true)
+	return nil
+}
@@ -0,0 +1,183 @@
+package ledger
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+const labelSchema = `
+CREATE TABLE IF NOT EXISTS ledger_record_labels (
+	record_id INTEGER NOT NULL,
+	key TEXT NOT NULL,
+	value TEXT NOT NULL,
+	PRIMARY KEY (record_id, key)
+);
+CREATE INDEX IF NOT EXISTS idx_ledger_record_labels_key ON ledger_record_labels(key, value, record_id);
+`
+
+// labelsColumn selects the labels of record r as a JSON object, or "" when
+// it has none, for queries that check hashes.
+const labelsColumn = `COALESCE((SELECT json_group_object(key, value) FROM ledger_record_labels WHERE record_id = r.id), '')`
+
+// Limits on record labels.
+const (
+	MaxLabels          = 64
+	MaxLabelKeyLength  = 128
+	MaxLabelValueBytes = 1024
+)
+
+// checkLabels validates record labels. Keys start with a letter or digit
+// and may contain letters, digits and . _ / -, as Kubernetes label keys
+// do, so selectors such as env=prod parse without quoting. Values may be
+// empty but must not contain ','.
+func checkLabels(labels map[string]string) error {
+	if len(labels) > MaxLabels {
+		return fmt.Errorf("at most %d labels are allowed", MaxLabels)
+	}
+	for key, value := range labels {
+		if err := checkLabelKey(key); err != nil {
+			return err
+		}
+		if len(value) > MaxLabelValueBytes {
+			return fmt.Errorf("label %s: value longer than %d bytes", key, MaxLabelValueBytes)
+		}
+		if strings.Contains(value, ",") {
+			return fmt.Errorf("label %s: value must not contain ','", key)
+		}
+	}
+	return nil
+}
+
+func checkLabelKey(key string) error {
+	if key == "" || len(key) > MaxLabelKeyLength {
+		return fmt.Errorf("label key %q must be 1 to %d characters", key, MaxLabelKeyLength)
+	}
+	for i, c := range key {
+		alnum := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+		if !alnum && (i == 0 || !strings.ContainsRune("._/-", c)) {
+			return fmt.Errorf("label key %q: use letters, digits and . _ / -, starting with a letter or digit", key)
+		}
+	}
+	return nil
+}
+
+// ParseLabelSelector parses a selector of comma-separated key=value pairs,
+// such as "env=prod,team=payments", as used by --label and ?label=.
+func ParseLabelSelector(selector string) (map[string]string, error) {
+	labels := map[string]string{}
+	for _, term := range strings.Split(selector, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(term, "=")
+		if !ok {
+			return nil, fmt.Errorf("label selector %q: want key=value", term)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if err := checkLabelKey(key); err != nil {
+			return nil, err
+		}
+		if prev, dup := labels[key]; dup && prev != value {
+			return nil, fmt.Errorf("label selector: %s selected twice", key)
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+// canonicalLabels encodes labels for the record hash: a JSON object with
+// sorted keys and no whitespace.
+func canonicalLabels(labels map[string]string) string {
+	// encoding/json sorts map keys, so the encoding is canonical.
+	b, _ := json.Marshal(labels)
+	return string(b)
+}
+
+// parseLabels decodes the labels column of a record, "" meaning none.
+func parseLabels(column string) (map[string]string, error) {
+	if column == "" || column == "{}" {
+		return nil, nil
+	}
+	var labels map[string]string
+	if err := json.Unmarshal([]byte(column), &labels); err != nil {
+		return nil, fmt.Errorf("invalid labels: %w", err)
+	}
+	return labels, nil
+}
+
+// insertLabels stores the labels of record id within tx.
+func insertLabels(tx *sql.Tx, id int64, labels map[string]string) error {
+	for _, key := range slices.Sorted(maps.Keys(labels)) {
+		if _, err := tx.Exec(`INSERT INTO ledger_record_labels(record_id, key, value) VALUES(?, ?, ?)`, id, key, labels[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchesLabels reports whether labels has every label of selector.
+func matchesLabels(selector, labels map[string]string) bool {
+	for key, value := range selector {
+		if got, ok := labels[key]; !ok || got != value {
+			return false
+		}
+	}
+	return true
+}
+
+// attachLabels fills in the labels of each record that has any.
+func (l *Ledger) attachLabels(q rowQueryer, records []Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+	if err := l.ensureLabelSchema(); err != nil {
+		return err
+	}
+	lo, hi := records[0].ID, records[0].ID
+	for _, rec := range records {
+		lo, hi = min(lo, rec.ID), max(hi, rec.ID)
+	}
+	rows, err := q.Query(`SELECT record_id, key, value FROM ledger_record_labels WHERE record_id BETWEEN ? AND ?`, lo, hi)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	labels := map[int64]map[string]string{}
+	for rows.Next() {
+		var id int64
+		var key, value string
+		if err := rows.Scan(&id, &key, &value); err != nil {
+			return err
+		}
+		if labels[id] == nil {
+			labels[id] = map[string]string{}
+		}
+		labels[id][key] = value
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for i := range records {
+		if set, ok := labels[records[i].ID]; ok {
+			records[i].Labels = set
+		}
+	}
+	return nil
+}
+
+func (l *Ledger) ensureLabelSchema() error {
+	if l.labelsReady.Load() {
+		return nil
+	}
+	if _, err := l.db.Exec(labelSchema); err != nil {
+		return err
+	}
+	l.labelsReady.Store(true)
+	return nil
+}
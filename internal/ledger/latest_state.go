@@ -0,0 +1,180 @@
+package ledger
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+// LatestRecord is the most recent record of one state dimension.
+type LatestRecord struct {
+	RecordID  int64           `json:"record_id"`
+	Timestamp int64           `json:"timestamp"`
+	Source    string          `json:"source"`
+	Hash      string          `json:"hash"`
+	Payload   json.RawMessage `json:"payload"`
+}
+
+// NamespaceHighWater summarizes the mutations recorded for one namespace.
+// HighWaterOffset is set when every external_ref in the namespace carries a
+// numeric offset (e.g. "orders:42").
+type NamespaceHighWater struct {
+	Namespace       string `json:"namespace"`
+	Count           int64  `json:"count"`
+	LastRecordID    int64  `json:"last_record_id"`
+	LastTimestamp   int64  `json:"last_timestamp"`
+	HighWaterOffset *int64 `json:"high_water_offset,omitempty"`
+}
+
+// LatestState is the current known state per dimension, read directly from
+// the newest records rather than through a full reconstruction.
+type LatestState struct {
+	GeneratedAt  int64                `json:"generated_at"`
+	LastRecordID int64                `json:"last_record_id"`
+	Code         *LatestRecord        `json:"code,omitempty"`
+	Config       *LatestRecord        `json:"config,omitempty"`
+	Environment  *LatestRecord        `json:"environment,omitempty"`
+	Mutations    []NamespaceHighWater `json:"mutations"`
+}
+
+// LatestState returns the newest code, config and environment records and
+// per-namespace mutation high-water marks. Archived segments are consulted
+// only when a dimension has no local record or mutations were archived.
+func (l *Ledger) LatestState() (LatestState, error) {
+	state := LatestState{
+		GeneratedAt: time.Now().Unix(),
+		Mutations:   []NamespaceHighWater{},
+	}
+
+	// All local queries go to one reader so the marks agree with each other.
+	db := l.reader()
+	if err := db.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM ledger_records`).Scan(&state.LastRecordID); err != nil {
+		return LatestState{}, err
+	}
+
+	dims := map[string]**LatestRecord{
+		"code":        &state.Code,
+		"config":      &state.Config,
+		"environment": &state.Environment,
+	}
+	for typ, dst := range dims {
+		rec, err := scanRecord(db.QueryRow(`SELECT id, ts, type, source, payload, hash, prev_hash FROM ledger_records WHERE type = ? ORDER BY id DESC LIMIT 1`, typ))
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return LatestState{}, err
+		}
+		*dst = latestRecord(rec)
+	}
+
+	marks := map[string]*NamespaceHighWater{}
+	numeric := map[string]bool{}
+	observe := func(id, ts int64, ref string) {
+		ns, offset, ok := parseExternalRef(ref)
+		if strings.TrimSpace(ns) == "" {
+			ns = "default"
+		}
+		m, seen := marks[ns]
+		if !seen {
+			m = &NamespaceHighWater{Namespace: ns}
+			marks[ns] = m
+			numeric[ns] = true
+		}
+		m.Count++
+		if id > m.LastRecordID {
+			m.LastRecordID = id
+			m.LastTimestamp = ts
+		}
+		if !ok {
+			numeric[ns] = false
+			return
+		}
+		if m.HighWaterOffset == nil || offset > *m.HighWaterOffset {
+			v := offset
+			m.HighWaterOffset = &v
+		}
+	}
+
+	archives, err := l.Archives()
+	if err != nil {
+		return LatestState{}, err
+	}
+	if len(archives) > 0 {
+		if err := l.observeArchived(&state, dims, observe); err != nil {
+			return LatestState{}, err
+		}
+	}
+
+	rows, err := db.Query(`SELECT id, ts, CASE WHEN json_valid(payload) THEN COALESCE(json_extract(payload, '$.external_ref'), '') ELSE '' END FROM ledger_records WHERE type = 'mutation' ORDER BY id ASC`)
+	if err != nil {
+		return LatestState{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, ts int64
+		var ref string
+		if err := rows.Scan(&id, &ts, &ref); err != nil {
+			return LatestState{}, err
+		}
+		observe(id, ts, ref)
+	}
+	if err := rows.Err(); err != nil {
+		return LatestState{}, err
+	}
+
+	for ns, m := range marks {
+		if !numeric[ns] {
+			m.HighWaterOffset = nil
+		}
+		state.Mutations = append(state.Mutations, *m)
+	}
+	sort.Slice(state.Mutations, func(i, j int) bool {
+		return state.Mutations[i].Namespace < state.Mutations[j].Namespace
+	})
+
+	return state, nil
+}
+
+// observeArchived feeds archived mutations to observe and fills dimensions
+// that have no local record from the newest archived one.
+func (l *Ledger) observeArchived(state *LatestState, dims map[string]**LatestRecord, observe func(id, ts int64, ref string)) error {
+	recs, err := l.archivedRecords(ListQuery{})
+	if err != nil {
+		return err
+	}
+	for _, rec := range recs {
+		if rec.ID > state.LastRecordID {
+			state.LastRecordID = rec.ID
+		}
+		if rec.Type == "mutation" {
+			var p struct {
+				ExternalRef string `json:"external_ref"`
+			}
+			_ = json.Unmarshal([]byte(rec.Payload), &p)
+			observe(rec.ID, rec.Timestamp, p.ExternalRef)
+			continue
+		}
+		if dst, ok := dims[rec.Type]; ok && (*dst == nil || (*dst).RecordID < rec.ID) {
+			*dst = latestRecord(rec)
+		}
+	}
+	return nil
+}
+
+func latestRecord(rec Record) *LatestRecord {
+	payload := json.RawMessage(rec.Payload)
+	if !json.Valid(payload) {
+		payload, _ = json.Marshal(rec.Payload)
+	}
+	return &LatestRecord{
+		RecordID:  rec.ID,
+		Timestamp: rec.Timestamp,
+		Source:    rec.Source,
+		Hash:      rec.Hash,
+		Payload:   payload,
+	}
+}
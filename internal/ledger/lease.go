@@ -0,0 +1,175 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const leaseSchema = `
+CREATE TABLE IF NOT EXISTS ledger_leases (
+	name TEXT PRIMARY KEY,
+	holder TEXT NOT NULL,
+	acquired_at INTEGER NOT NULL,
+	expires_at INTEGER NOT NULL
+);
+`
+
+// DefaultLeaseTTL is how long a lease is held without renewal.
+const DefaultLeaseTTL = 15 * time.Second
+
+// Lease is a named lock in the ledger database, held by one replica until it
+// expires.
+type Lease struct {
+	Name   string `json:"name"`
+	Holder string `json:"holder"`
+	// AcquiredAt and ExpiresAt are unix milliseconds.
+	AcquiredAt int64 `json:"acquired_at"`
+	ExpiresAt  int64 `json:"expires_at"`
+}
+
+// AcquireLease takes or renews the named lease for holder until ttl from
+// now. It reports false when another holder's lease has not expired yet.
+// Replicas compare expiry times using their own clocks, so ttl must be well
+// above the clock skew between them.
+func (l *Ledger) AcquireLease(name, holder string, ttl time.Duration) (bool, error) {
+	if strings.TrimSpace(name) == "" || strings.TrimSpace(holder) == "" {
+		return false, errors.New("lease name and holder required")
+	}
+	if ttl <= 0 {
+		return false, errors.New("lease ttl must be positive")
+	}
+	if err := l.ensureLeaseSchema(); err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+	res, err := l.db.Exec(`INSERT INTO ledger_leases(name, holder, acquired_at, expires_at) VALUES(?, ?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET
+			acquired_at = CASE WHEN ledger_leases.holder = excluded.holder THEN ledger_leases.acquired_at ELSE excluded.acquired_at END,
+			holder = excluded.holder,
+			expires_at = excluded.expires_at
+		WHERE ledger_leases.holder = excluded.holder OR ledger_leases.expires_at <= ?`,
+		name, holder, now.UnixMilli(), now.Add(ttl).UnixMilli(), now.UnixMilli())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// ReleaseLease gives up the named lease if holder holds it, so another
+// replica can take over without waiting for it to expire.
+func (l *Ledger) ReleaseLease(name, holder string) error {
+	if err := l.ensureLeaseSchema(); err != nil {
+		return err
+	}
+	_, err := l.db.Exec(`DELETE FROM ledger_leases WHERE name = ? AND holder = ?`, name, holder)
+	return err
+}
+
+// CurrentLease returns the named lease. ok is false when nobody holds it or
+// it has expired.
+func (l *Ledger) CurrentLease(name string) (lease Lease, ok bool, err error) {
+	if err := l.ensureLeaseSchema(); err != nil {
+		return Lease{}, false, err
+	}
+	err = l.db.QueryRow(`SELECT name, holder, acquired_at, expires_at FROM ledger_leases WHERE name = ?`, name).
+		Scan(&lease.Name, &lease.Holder, &lease.AcquiredAt, &lease.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Lease{}, false, nil
+	}
+	if err != nil {
+		return Lease{}, false, err
+	}
+	return lease, lease.ExpiresAt > time.Now().UnixMilli(), nil
+}
+
+func (l *Ledger) ensureLeaseSchema() error {
+	if l.leasesReady.Load() {
+		return nil
+	}
+	if _, err := l.db.Exec(leaseSchema); err != nil {
+		return err
+	}
+	l.leasesReady.Store(true)
+	return nil
+}
+
+// Elector campaigns for a lease so that one of several replicas sharing a
+// ledger database acts as leader.
+type Elector struct {
+	Ledger *Ledger
+	// Name is the lease to campaign for. Defaults to "leader".
+	Name string
+	// ID identifies this replica as the lease holder.
+	ID string
+	// TTL is the lease duration; the lease is renewed every TTL/3.
+	// Defaults to DefaultLeaseTTL.
+	TTL time.Duration
+	// OnChange is called when this replica gains or loses leadership.
+	OnChange func(leader bool)
+	// OnError receives lease failures. Leadership is given up on the first
+	// failed renewal, before the lease can expire and be taken over.
+	OnError func(err error)
+
+	leader atomic.Bool
+	// until is when the lease held by this replica lapses, in unix
+	// nanoseconds, so that a renewal stuck on the database cannot extend
+	// leadership past it.
+	until atomic.Int64
+}
+
+// IsLeader reports whether this replica currently holds the lease.
+func (e *Elector) IsLeader() bool {
+	return e.leader.Load() && time.Now().UnixNano() < e.until.Load()
+}
+
+// LeaseName returns the lease the elector campaigns for.
+func (e *Elector) LeaseName() string {
+	if e.Name == "" {
+		return "leader"
+	}
+	return e.Name
+}
+
+// Run campaigns until ctx is cancelled, then releases the lease if held.
+func (e *Elector) Run(ctx context.Context) error {
+	ttl := e.TTL
+	if ttl <= 0 {
+		ttl = DefaultLeaseTTL
+	}
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		start := time.Now()
+		held, err := e.Ledger.AcquireLease(e.LeaseName(), e.ID, ttl)
+		if err != nil && e.OnError != nil {
+			e.OnError(err)
+		}
+		if held && err == nil {
+			e.until.Store(start.Add(ttl).UnixNano())
+		}
+		e.setLeader(held && err == nil)
+
+		select {
+		case <-ctx.Done():
+			if e.leader.Load() {
+				e.setLeader(false)
+				_ = e.Ledger.ReleaseLease(e.LeaseName(), e.ID)
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *Elector) setLeader(leader bool) {
+	if e.leader.Swap(leader) != leader && e.OnChange != nil {
+		e.OnChange(leader)
+	}
+}
@@ -2,14 +2,22 @@ package ledger
 
 import (
 	"context"
+	"crypto"
 	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/Retr0-XD/StateLedger/internal/collectors"
 	_ "modernc.org/sqlite"
 )
 
@@ -24,10 +32,87 @@ CREATE TABLE IF NOT EXISTS ledger_records (
 	prev_hash TEXT NOT NULL
 );
 CREATE INDEX IF NOT EXISTS idx_ledger_records_ts ON ledger_records(ts);
+CREATE INDEX IF NOT EXISTS idx_ledger_records_type ON ledger_records(type, id);
+CREATE INDEX IF NOT EXISTS idx_ledger_records_source ON ledger_records(source, id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_ledger_records_hash ON ledger_records(hash);
+CREATE TABLE IF NOT EXISTS ledger_idempotency (
+	key TEXT PRIMARY KEY,
+	record_id INTEGER NOT NULL,
+	hash TEXT NOT NULL DEFAULT '',
+	request_hash TEXT NOT NULL DEFAULT ''
+);
 `
 
+const insertRecordSQL = `INSERT INTO ledger_records(ts, type, source, payload, hash, prev_hash) VALUES(?, ?, ?, ?, ?, ?)`
+
+// verifySelectSQL reads records for verification with their signatures
+// and the payload hashes and redaction records of redacted records.
+const verifySelectSQL = `SELECT r.id, r.ts, r.type, r.source, r.payload, r.hash, r.prev_hash, COALESCE(s.key_id, ''), COALESCE(s.signature, ''), COALESCE(x.payload_hash, ''), COALESCE(x.redaction_id, 0), ` + labelsColumn + `
+	FROM ledger_records r LEFT JOIN ledger_record_signatures s ON s.record_id = r.id
+	LEFT JOIN ledger_redactions x ON x.record_id = r.id`
+
+const lastHashSQL = `SELECT hash FROM ledger_records ORDER BY id DESC LIMIT 1`
+
 type Ledger struct {
 	db *sql.DB
+
+	// writeMu serializes appends so that reading the chain head and
+	// inserting the next record happen atomically within this process.
+	writeMu sync.Mutex
+
+	stmtMu       sync.Mutex
+	insertStmt   *sql.Stmt
+	lastHashStmt *sql.Stmt
+
+	cache   *Cache
+	listGen atomic.Uint64
+
+	archiveMu       sync.Mutex
+	archiveKey      []byte
+	archiveRetired  [][]byte
+	archiveStores   map[string]ArchiveStore
+	archiveSegments map[string][]Record
+
+	agentsReady      atomic.Bool
+	leasesReady      atomic.Bool
+	schemasReady     atomic.Bool
+	rateBucketsReady atomic.Bool
+	approvalsReady   atomic.Bool
+	holdsReady       atomic.Bool
+	credentialsReady atomic.Bool
+	policiesReady    atomic.Bool
+	labelsReady      atomic.Bool
+	prunedReady      atomic.Bool
+	redactionsReady  atomic.Bool
+	checkpointReady  atomic.Bool
+	wormReady        atomic.Bool
+	keysReady        atomic.Bool
+	signaturesReady  atomic.Bool
+	uidsReady        atomic.Bool
+	seqsReady        atomic.Bool
+	typesReady       atomic.Bool
+	idempotencyReady atomic.Bool
+
+	// canonicalTypes caches the types whose payloads are canonicalized;
+	// nil until loaded.
+	canonicalTypes atomic.Pointer[[]string]
+
+	signer     atomic.Pointer[recordSigner]
+	uidGen     atomic.Pointer[IDGenerator]
+	signMu     sync.RWMutex
+	verifyKeys map[string]crypto.PublicKey
+
+	mirror   atomic.Pointer[mirror]
+	replicas atomic.Pointer[readReplicas]
+	journal  atomic.Pointer[journal]
+
+	artifactStore atomic.Pointer[string]
+
+	appends appendCounters
+	latency appendLatency
+
+	// encrypted is set when the database is encrypted at rest.
+	encrypted *encryptedDB
 }
 
 type Record struct {
@@ -38,6 +123,25 @@ type Record struct {
 	Payload   string `json:"payload"`
 	Hash      string `json:"hash"`
 	PrevHash  string `json:"prev_hash"`
+	// AgentID is the registered agent that captured the record, if any.
+	AgentID string `json:"agent_id,omitempty"`
+	// Signature is the base64 signature of Hash by the key SigningKeyID,
+	// for records appended with a signer set. SigningKeyRef names the key
+	// in the KMS or token that holds it.
+	Signature     string `json:"signature,omitempty"`
+	SigningKeyID  string `json:"signing_key_id,omitempty"`
+	SigningKeyRef string `json:"signing_key_ref,omitempty"`
+	// Redaction is set when the payload was blanked by Redact.
+	Redaction *Redaction `json:"redaction,omitempty"`
+	// UID is the globally unique ID generated at append when an ID
+	// generator is set; see SetIDGenerator.
+	UID string `json:"uid,omitempty"`
+	// Seq numbers the records of Source from 1 in append order, so a
+	// consumer can detect a collector's records missing or reordered.
+	Seq int64 `json:"seq,omitempty"`
+	// Labels are the key/value labels the record was appended with. They
+	// are part of its hash.
+	Labels map[string]string `json:"labels,omitempty"`
 }
 
 type RecordInput struct {
@@ -45,20 +149,113 @@ type RecordInput struct {
 	Type      string
 	Source    string
 	Payload   string
+	// AgentID attributes the record to a registered agent.
+	AgentID string
+	// Labels tag the record for selection with ListQuery.Labels, such as
+	// env=prod. They are included in the record hash.
+	Labels map[string]string
+	// IdempotencyKey, when set, is stored with the record under a unique
+	// index. Appending again with the same key, as a collector retrying
+	// after a timeout does, returns the existing record instead of a
+	// duplicate.
+	IdempotencyKey string
+	// FreeForm skips payload validation for records whose type is a
+	// collector kind (code, config, environment, mutation) but whose
+	// payload does not follow that collector's format.
+	FreeForm bool
+}
+
+// ErrInvalidPayload is returned when the payload of a collector kind does
+// not parse or fails the collector's validation.
+var ErrInvalidPayload = errors.New("invalid payload")
+
+// ErrInvalidRecord is returned for inputs missing a required field or
+// carrying malformed fields or labels.
+var ErrInvalidRecord = errors.New("invalid record")
+
+// validate checks the fields required of every record and, unless
+// FreeForm is set, the payload of collector kinds.
+func (in RecordInput) validate() error {
+	if strings.TrimSpace(in.Type) == "" {
+		return fmt.Errorf("%w: type required", ErrInvalidRecord)
+	}
+	if strings.TrimSpace(in.Payload) == "" {
+		return fmt.Errorf("%w: payload required", ErrInvalidRecord)
+	}
+	// The hash joins fields with '|', so a pipe in type or source could
+	// move between them without changing the hash. Payload is the last
+	// field and may contain pipes.
+	if strings.Contains(in.Type, "|") || strings.Contains(in.Source, "|") {
+		return fmt.Errorf("%w: type and source must not contain '|'", ErrInvalidRecord)
+	}
+	if err := checkLabels(in.Labels); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidRecord, err)
+	}
+	if in.FreeForm {
+		return nil
+	}
+	if _, err := collectors.ValidatePayload(in.Type, in.Payload); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalidPayload, in.Type, err)
+	}
+	return nil
 }
 
 type ListQuery struct {
 	Since int64
 	Until int64
 	Limit int
+	// AfterID restricts results to records with a greater ID, so a listing
+	// can be paged by passing the ID of the last record of each page.
+	AfterID int64
+	// Offset skips the first matching records. Prefer AfterID, which does
+	// not read the skipped records.
+	Offset int
+	// TraceID restricts results to records whose payload carries the given
+	// W3C trace ID.
+	TraceID string
+	// Types and Sources restrict results to records of any of the given
+	// types and sources.
+	Types   []string
+	Sources []string
+	// JSONFilters restricts results to records whose JSON payload has, at
+	// each field path such as "user_id" or "data.order_id", the given
+	// value. See JSONPathValue for how values compare.
+	JSONFilters map[string]string
+	// SinceSeq restricts results to records with a greater Seq. It needs
+	// exactly one source in Sources.
+	SinceSeq int64
+	// Labels restricts results to records with each of the given labels
+	// and values.
+	Labels map[string]string
+}
+
+// checkListQuery validates the filters of q that can be malformed.
+func checkListQuery(q ListQuery) error {
+	if q.SinceSeq > 0 && len(q.Sources) != 1 {
+		return ErrSinceSeqSource
+	}
+	for key := range q.Labels {
+		if err := checkLabelKey(key); err != nil {
+			return err
+		}
+	}
+	return checkJSONFilters(q.JSONFilters)
 }
 
 type VerifyResult struct {
-	OK        bool   `json:"ok"`
-	FailedID  int64  `json:"failed_id,omitempty"`
-	Reason    string `json:"reason,omitempty"`
-	Checked   int64  `json:"checked"`
-	Timestamp int64  `json:"timestamp"`
+	OK       bool   `json:"ok"`
+	FailedID int64  `json:"failed_id,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+	Checked  int64  `json:"checked"`
+	// ExpectedHash and ActualHash are the link that failed: the chain hash
+	// FailedID should follow and its prev_hash, or the hash it should have
+	// and its stored hash.
+	ExpectedHash string `json:"expected_hash,omitempty"`
+	ActualHash   string `json:"actual_hash,omitempty"`
+	// Since is the checkpoint record an incremental verification resumed
+	// after. Records up to it were not checked again.
+	Since     int64 `json:"since,omitempty"`
+	Timestamp int64 `json:"timestamp"`
 }
 
 type ProofResult struct {
@@ -72,11 +269,25 @@ type ProofResult struct {
 }
 
 func Open(path string) (*Ledger, error) {
+	return OpenWithOptions(path, OpenOptions{})
+}
+
+// OpenWithOptions opens the ledger at path. With opts.Key the whole database
+// is encrypted at rest.
+func OpenWithOptions(path string, opts OpenOptions) (*Ledger, error) {
 	if path == "" {
 		return nil, errors.New("db path required")
 	}
+	if len(opts.Key) > 0 {
+		return openEncrypted(path, opts)
+	}
+	if encrypted, err := fileIsEncrypted(path); err != nil {
+		return nil, err
+	} else if encrypted {
+		return nil, ErrDatabaseEncrypted
+	}
 
-	db, err := sql.Open("sqlite", path)
+	db, err := sql.Open("sqlite", sqliteDSN(path))
 	if err != nil {
 		return nil, err
 	}
@@ -94,50 +305,153 @@ func Open(path string) (*Ledger, error) {
 		return nil, err
 	}
 
-	return &Ledger{db: db}, nil
+	l := &Ledger{db: db}
+	if err := l.upgradeReadSchemas(); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// sqliteDSN adds the connection parameters of a ledger database to path.
+// Write transactions take the write lock when they begin, so reading the
+// chain head and inserting after it cannot interleave with an append by
+// another process sharing the file, and writers wait for each other
+// instead of failing.
+func sqliteDSN(path string) string {
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	return path + sep + "_txlock=immediate&_pragma=busy_timeout(5000)"
 }
 
 func (l *Ledger) Close() error {
 	if l == nil || l.db == nil {
 		return nil
 	}
+	l.stmtMu.Lock()
+	if l.insertStmt != nil {
+		_ = l.insertStmt.Close()
+		l.insertStmt = nil
+	}
+	if l.lastHashStmt != nil {
+		_ = l.lastHashStmt.Close()
+		l.lastHashStmt = nil
+	}
+	l.stmtMu.Unlock()
+	if r := l.replicas.Swap(nil); r != nil {
+		r.close()
+	}
+	if j := l.journal.Swap(nil); j != nil {
+		_ = j.close()
+	}
+	if l.cache != nil {
+		l.cache.Close()
+	}
+	if l.encrypted != nil {
+		err := l.encrypted.close()
+		if cerr := l.db.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	}
 	return l.db.Close()
 }
 
 func (l *Ledger) InitSchema() error {
-	_, err := l.db.Exec(schema)
-	return err
+	_, err := l.db.Exec(schema + archiveSchema + sourcesSchema + agentSchema + leaseSchema + schemaRegistrySchema + guardSchema + signatureSchema + redactionSchema + verifyCheckpointSchema + recordUIDSchema + labelSchema)
+	if err != nil {
+		return err
+	}
+	return l.ensureSeqSchema()
+}
+
+// upgradeReadSchemas creates the side tables that reads join, which
+// ledgers written before them lack. Read views are snapshots that do not
+// see tables created after they start, so the tables are created when the
+// ledger is opened rather than by reads. A new database gets them from
+// InitSchema.
+func (l *Ledger) upgradeReadSchemas() error {
+	var n int
+	if err := l.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'ledger_records'`).Scan(&n); err != nil || n == 0 {
+		return err
+	}
+	for _, ensure := range []func() error{l.ensureAgentSchema, l.ensureSignatureSchema, l.ensureRedactionSchema, l.ensureUIDSchema, l.ensureSeqSchema, l.ensureLabelSchema} {
+		if err := ensure(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (l *Ledger) Append(input RecordInput) (Record, error) {
-	if strings.TrimSpace(input.Type) == "" {
-		return Record{}, errors.New("type required")
+	if err := input.validate(); err != nil {
+		return Record{}, err
 	}
-	if strings.TrimSpace(input.Payload) == "" {
-		return Record{}, errors.New("payload required")
+	if input.AgentID != "" || input.IdempotencyKey != "" || len(input.Labels) > 0 || l.signer.Load() != nil || l.uidGen.Load() != nil {
+		// Attribution, idempotency keys, labels, signatures and record IDs
+		// are written in the record's transaction.
+		records, err := l.AppendBatch([]RecordInput{input})
+		if err != nil {
+			return Record{}, err
+		}
+		return records[0], nil
 	}
 
-	prevHash, err := l.lastHash()
+	if err := l.canonicalize(l.db, &input); err != nil {
+		return Record{}, err
+	}
+	if err := l.ensureSeqSchema(); err != nil {
+		return Record{}, err
+	}
+	insert, lastHash, err := l.appendStmts()
 	if err != nil {
 		return Record{}, err
 	}
 
-	hash := computeHash(prevHash, input.Timestamp, input.Type, input.Source, input.Payload)
+	defer l.observeAppend(time.Now())
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+
+	// writeMu only orders appends within this process; the transaction
+	// holds the write lock from the head read to the insert against other
+	// processes too.
+	tx, err := l.db.Begin()
+	if err != nil {
+		return Record{}, err
+	}
+	defer tx.Rollback()
 
-	res, err := l.db.Exec(
-		`INSERT INTO ledger_records(ts, type, source, payload, hash, prev_hash) VALUES(?, ?, ?, ?, ?, ?)`,
-		input.Timestamp, input.Type, input.Source, input.Payload, hash, prevHash,
-	)
+	prevHash, err := scanHash(tx.Stmt(lastHash).QueryRow())
+	if err == nil && prevHash == "" {
+		prevHash, err = archiveTip(tx)
+	}
 	if err != nil {
 		return Record{}, err
 	}
 
+	hash := computeHash(prevHash, input.Timestamp, input.Type, input.Source, input.Payload)
+
+	res, err := tx.Stmt(insert).Exec(input.Timestamp, input.Type, input.Source, input.Payload, hash, prevHash)
+	if err != nil {
+		return Record{}, unknownTypeError(tx, err, input.Type)
+	}
+
 	id, err := res.LastInsertId()
 	if err != nil {
 		return Record{}, err
 	}
+	seq, err := recordSeq(tx, id)
+	if err != nil {
+		return Record{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Record{}, err
+	}
+	l.invalidateListCache()
 
-	return Record{
+	rec := Record{
 		ID:        id,
 		Timestamp: input.Timestamp,
 		Type:      input.Type,
@@ -145,54 +459,90 @@ func (l *Ledger) Append(input RecordInput) (Record, error) {
 		Payload:   input.Payload,
 		Hash:      hash,
 		PrevHash:  prevHash,
-	}, nil
+		Seq:       seq,
+	}
+	l.countAppended([]Record{rec})
+	l.mirrorAppended([]Record{rec})
+	l.journalAppended([]Record{rec})
+	return rec, l.Sync()
 }
 
 // AppendBatch appends multiple records in a single transaction for better performance
 func (l *Ledger) AppendBatch(inputs []RecordInput) ([]Record, error) {
 	if len(inputs) == 0 {
-		return nil, errors.New("no inputs provided")
+		return nil, fmt.Errorf("%w: no inputs provided", ErrInvalidRecord)
 	}
 
-	tx, err := l.db.Begin()
-	if err != nil {
-		return nil, err
-	}
-	defer tx.Rollback()
+	defer l.observeAppend(time.Now())
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
 
+	return l.appendBatchLocked(inputs)
+}
+
+// appendBatchLocked performs AppendBatch; the caller must hold writeMu.
+func (l *Ledger) appendBatchLocked(inputs []RecordInput) ([]Record, error) {
+	records, _, err := l.appendBatchKeyed(inputs)
+	return records, err
+}
+
+// appendBatchTx chains inputs onto the current head within tx, with the
+// payloads of canonical types canonicalized. The caller must hold writeMu
+// and commit tx.
+func (l *Ledger) appendBatchTx(tx *sql.Tx, inputs []RecordInput) ([]Record, error) {
 	prevHash, err := l.lastHashTx(tx)
 	if err != nil {
 		return nil, err
 	}
 
 	records := make([]Record, 0, len(inputs))
-	stmt, err := tx.Prepare(`INSERT INTO ledger_records(ts, type, source, payload, hash, prev_hash) VALUES(?, ?, ?, ?, ?, ?)`)
+	stmt, err := tx.Prepare(insertRecordSQL)
 	if err != nil {
 		return nil, err
 	}
 	defer stmt.Close()
 
 	for _, input := range inputs {
-		if strings.TrimSpace(input.Type) == "" {
-			return nil, errors.New("type required")
+		if err := input.validate(); err != nil {
+			return nil, err
 		}
-		if strings.TrimSpace(input.Payload) == "" {
-			return nil, errors.New("payload required")
+		if err := l.canonicalize(tx, &input); err != nil {
+			return nil, err
 		}
 
-		hash := computeHash(prevHash, input.Timestamp, input.Type, input.Source, input.Payload)
+		hash := labeledHash(prevHash, input.Timestamp, input.Type, input.Source, input.Payload, input.Labels)
 
 		res, err := stmt.Exec(input.Timestamp, input.Type, input.Source, input.Payload, hash, prevHash)
 		if err != nil {
-			return nil, err
+			return nil, unknownTypeError(tx, err, input.Type)
 		}
 
 		id, err := res.LastInsertId()
 		if err != nil {
 			return nil, err
 		}
-
-		records = append(records, Record{
+		if input.AgentID != "" {
+			if err := attributeRecord(tx, id, input.AgentID); err != nil {
+				return nil, err
+			}
+		}
+		if input.IdempotencyKey != "" {
+			request, err := l.requestHash(tx, input)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := tx.Exec(`INSERT INTO ledger_idempotency(key, record_id, hash, request_hash) VALUES(?, ?, ?, ?)`, input.IdempotencyKey, id, hash, request); err != nil {
+				return nil, err
+			}
+		}
+		if err := insertLabels(tx, id, input.Labels); err != nil {
+			return nil, err
+		}
+		seq, err := recordSeq(tx, id)
+		if err != nil {
+			return nil, err
+		}
+		rec := Record{
 			ID:        id,
 			Timestamp: input.Timestamp,
 			Type:      input.Type,
@@ -200,31 +550,265 @@ func (l *Ledger) AppendBatch(inputs []RecordInput) ([]Record, error) {
 			Payload:   input.Payload,
 			Hash:      hash,
 			PrevHash:  prevHash,
-		})
+			AgentID:   input.AgentID,
+			Seq:       seq,
+			Labels:    input.Labels,
+		}
+		sig, ok, err := l.sign(hash)
+		if err != nil {
+			return nil, fmt.Errorf("sign record: %w", err)
+		}
+		if ok {
+			if _, err := tx.Exec(`INSERT INTO ledger_record_signatures(record_id, key_id, key_ref, signature) VALUES(?, ?, ?, ?)`, id, sig.KeyID, sig.KeyRef, sig.Signature); err != nil {
+				return nil, err
+			}
+			rec.SigningKeyID, rec.SigningKeyRef, rec.Signature = sig.KeyID, sig.KeyRef, sig.Signature
+		}
+		if err := l.assignUID(tx, &rec); err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
 
 		prevHash = hash
 	}
 
-	if err := tx.Commit(); err != nil {
-		return nil, err
-	}
-
 	return records, nil
 }
 
 func (l *Ledger) GetByID(id int64) (Record, error) {
-	row := l.db.QueryRow(`SELECT id, ts, type, source, payload, hash, prev_hash FROM ledger_records WHERE id = ?`, id)
-	return scanRecord(row)
+	if l.cache != nil {
+		if v, ok := l.cache.Get(recordCacheKey(id)); ok {
+			return v.(Record), nil
+		}
+	}
+
+	rec, err := l.readRecord(`SELECT id, ts, type, source, payload, hash, prev_hash FROM ledger_records WHERE id = ?`, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		archived, ok, archErr := l.archivedRecord(id)
+		if archErr != nil {
+			return Record{}, archErr
+		}
+		if ok {
+			rec, err = archived, nil
+		}
+	}
+	if err != nil {
+		return Record{}, err
+	}
+	single, reads := []Record{rec}, replicaQueryer{l}
+	if err := l.attachAgents(reads, single); err != nil {
+		return Record{}, err
+	}
+	if err := l.attachSignatures(reads, single); err != nil {
+		return Record{}, err
+	}
+	if err := l.attachRedactions(reads, single); err != nil {
+		return Record{}, err
+	}
+	if err := l.attachUIDs(reads, single); err != nil {
+		return Record{}, err
+	}
+	if err := l.attachSeqs(reads, single); err != nil {
+		return Record{}, err
+	}
+	if err := l.attachLabels(reads, single); err != nil {
+		return Record{}, err
+	}
+	rec = single[0]
+
+	if l.cache != nil {
+		l.cache.Set(recordCacheKey(id), rec)
+	}
+	return rec, nil
+}
+
+// GetByHash returns the record whose chain hash is hash, local or
+// archived, or sql.ErrNoRows.
+func (l *Ledger) GetByHash(hash string) (Record, error) {
+	rec, err := l.readRecord(`SELECT id, ts, type, source, payload, hash, prev_hash FROM ledger_records WHERE hash = ?`, hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		archived, ok, archErr := l.archivedRecordByHash(hash)
+		if archErr != nil {
+			return Record{}, archErr
+		}
+		if ok {
+			rec, err = archived, nil
+		}
+	}
+	if err != nil {
+		return Record{}, err
+	}
+	return l.GetByID(rec.ID)
 }
 
 func (l *Ledger) List(q ListQuery) ([]Record, error) {
+	out, _, err := l.ListWithHead(q)
+	return out, err
+}
+
+// listResult is a List result as cached.
+type listResult struct {
+	records []Record
+	head    ChainHead
+}
+
+// ListWithHead is List that also returns the chain head the records were
+// read against. All of the records come from one consistent prefix of the
+// chain, ending at head, even while appends are in flight.
+func (l *Ledger) ListWithHead(q ListQuery) ([]Record, ChainHead, error) {
 	if q.Limit <= 0 {
 		q.Limit = 100
 	}
+	if err := checkListQuery(q); err != nil {
+		return nil, ChainHead{}, err
+	}
+
+	if l.cache == nil {
+		return l.list(q)
+	}
+
+	key := listCacheKey(q)
+	if v, ok := l.cache.Get(key); ok {
+		res := v.(listResult)
+		return copyRecords(res.records), res.head, nil
+	}
+
+	gen := l.listGen.Load()
+	out, head, err := l.list(q)
+	if err != nil {
+		return nil, ChainHead{}, err
+	}
+	// Skip caching if an append raced with the query.
+	if l.listGen.Load() == gen {
+		l.cache.Set(key, listResult{records: copyRecords(out), head: head})
+	}
+	return out, head, nil
+}
 
+func (l *Ledger) list(q ListQuery) ([]Record, ChainHead, error) {
+	v, err := l.openReadView()
+	if err != nil {
+		return nil, ChainHead{}, err
+	}
+	defer v.close()
+
+	// Archived records precede every local record in the chain, so the
+	// offset skips them first.
+	aq := q
+	aq.Limit = q.Offset + q.Limit
+	archived, err := l.archivedRecords(aq)
+	if err != nil {
+		return nil, ChainHead{}, err
+	}
+	if len(archived) > q.Offset {
+		archived = archived[q.Offset:]
+		q.Offset = 0
+	} else {
+		q.Offset -= len(archived)
+		archived = nil
+	}
+	if len(archived) >= q.Limit {
+		return archived, v.head, nil
+	}
+	q.Limit -= len(archived)
+
+	local, err := l.listLocal(v, q)
+	if err != nil {
+		return nil, ChainHead{}, err
+	}
+	return append(archived, local...), v.head, nil
+}
+
+// iteratePageSize is the number of local records Iterate reads at a time.
+const iteratePageSize = 1000
+
+// errIterationDone stops an iteration early without reporting an error.
+var errIterationDone = errors.New("iteration done")
+
+// Iterate calls fn with each record matching q in chain order, archived
+// records first, like List but without collecting the result: local
+// records are read a page at a time. Limit zero means every matching
+// record. Like ListWithHead it reads one consistent prefix of the chain.
+// An error from fn stops the iteration and is returned.
+func (l *Ledger) Iterate(q ListQuery, fn func(Record) error) error {
+	_, err := l.IterateWithHead(q, fn)
+	return err
+}
+
+// IterateWithHead is Iterate that also returns the head of the prefix it
+// read, including when fn stops the iteration with an error.
+func (l *Ledger) IterateWithHead(q ListQuery, fn func(Record) error) (ChainHead, error) {
+	if err := checkListQuery(q); err != nil {
+		return ChainHead{}, err
+	}
+	v, err := l.openReadView()
+	if err != nil {
+		return ChainHead{}, err
+	}
+	defer v.close()
+	return v.head, l.iterate(v, q, fn)
+}
+
+func (l *Ledger) iterate(v *readView, q ListQuery, fn func(Record) error) error {
+	remaining := q.Limit
+	if remaining <= 0 {
+		remaining = -1
+	}
+	skip := q.Offset
+	var lastID int64
+	err := l.iterateArchived(q, func(rec Record) error {
+		lastID = rec.ID
+		if skip > 0 {
+			skip--
+			return nil
+		}
+		if remaining == 0 {
+			return errIterationDone
+		}
+		remaining--
+		return fn(rec)
+	})
+	if errors.Is(err, errIterationDone) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	q.Offset = skip
+	q.AfterID = max(q.AfterID, lastID)
+	for remaining != 0 {
+		q.Limit = iteratePageSize
+		if remaining > 0 {
+			q.Limit = min(remaining, iteratePageSize)
+		}
+		page, err := l.listLocal(v, q)
+		if err != nil {
+			return err
+		}
+		for _, rec := range page {
+			if err := fn(rec); err != nil {
+				return err
+			}
+		}
+		if len(page) < q.Limit {
+			return nil
+		}
+		if remaining > 0 {
+			remaining -= len(page)
+		}
+		q.Offset = 0
+		q.AfterID = page[len(page)-1].ID
+	}
+	return nil
+}
+
+// listLocal returns the local records in v matching q, which must have a
+// limit.
+func (l *Ledger) listLocal(v *readView, q ListQuery) ([]Record, error) {
 	query := `SELECT id, ts, type, source, payload, hash, prev_hash FROM ledger_records`
-	args := []any{}
-	clauses := []string{}
+	args := []any{v.head.ID}
+	clauses := []string{"id <= ?"}
 
 	if q.Since > 0 {
 		clauses = append(clauses, "ts >= ?")
@@ -234,13 +818,47 @@ func (l *Ledger) List(q ListQuery) ([]Record, error) {
 		clauses = append(clauses, "ts <= ?")
 		args = append(args, q.Until)
 	}
-	if len(clauses) > 0 {
-		query += " WHERE " + strings.Join(clauses, " AND ")
+	if q.TraceID != "" {
+		clauses = append(clauses, "CASE WHEN json_valid(payload) THEN json_extract(payload, '$.trace_id') END = ?")
+		args = append(args, q.TraceID)
+	}
+	if q.AfterID > 0 {
+		clauses = append(clauses, "id > ?")
+		args = append(args, q.AfterID)
+	}
+	if len(q.Types) > 0 {
+		clauses = append(clauses, "type IN ("+placeholders(len(q.Types))+")")
+		for _, t := range q.Types {
+			args = append(args, t)
+		}
+	}
+	if len(q.Sources) > 0 {
+		clauses = append(clauses, "source IN ("+placeholders(len(q.Sources))+")")
+		for _, src := range q.Sources {
+			args = append(args, src)
+		}
+	}
+	if q.SinceSeq > 0 {
+		clauses = append(clauses, "id IN (SELECT record_id FROM ledger_record_seqs WHERE source = ? AND seq > ?)")
+		args = append(args, q.Sources[0], q.SinceSeq)
+	}
+	for _, key := range slices.Sorted(maps.Keys(q.Labels)) {
+		clauses = append(clauses, "id IN (SELECT record_id FROM ledger_record_labels WHERE key = ? AND value = ?)")
+		args = append(args, key, q.Labels[key])
+	}
+	for _, path := range slices.Sorted(maps.Keys(q.JSONFilters)) {
+		expr, err := jsonPathExpr(path)
+		if err != nil {
+			return nil, err
+		}
+		clauses = append(clauses, expr+" = ?")
+		args = append(args, q.JSONFilters[path])
 	}
-	query += " ORDER BY id ASC LIMIT ?"
-	args = append(args, q.Limit)
+	query += " WHERE " + strings.Join(clauses, " AND ")
+	query += " ORDER BY id ASC LIMIT ? OFFSET ?"
+	args = append(args, q.Limit, q.Offset)
 
-	rows, err := l.db.Query(query, args...)
+	rows, err := v.q.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -254,132 +872,346 @@ func (l *Ledger) List(q ListQuery) ([]Record, error) {
 		}
 		out = append(out, rec)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := l.attachAgents(v.q, out); err != nil {
+		return nil, err
+	}
+	if err := l.attachSignatures(v.q, out); err != nil {
+		return nil, err
+	}
+	if err := l.attachRedactions(v.q, out); err != nil {
+		return nil, err
+	}
+	if err := l.attachUIDs(v.q, out); err != nil {
+		return nil, err
+	}
+	if err := l.attachLabels(v.q, out); err != nil {
+		return nil, err
+	}
+	return out, l.attachSeqs(v.q, out)
+}
 
-	return out, rows.Err()
+// placeholders returns n comma-separated bind parameters.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
 }
 
 func (l *Ledger) VerifyChain() (VerifyResult, error) {
-	rows, err := l.db.Query(`SELECT id, ts, type, source, payload, hash, prev_hash FROM ledger_records ORDER BY id ASC`)
+	res, _, err := l.verifyFrom(VerifyCheckpoint{})
 	if err != nil {
 		return VerifyResult{}, err
 	}
-	defer rows.Close()
+	if err := l.storeLastVerification(res); err != nil {
+		return VerifyResult{}, err
+	}
+	return res, nil
+}
 
-	var prev string
+// verifyFrom verifies the chain after cp, or the whole chain, archived
+// segments included, when cp is empty. When the chain verifies it also
+// returns the checkpoint at its head.
+func (l *Ledger) verifyFrom(cp VerifyCheckpoint) (VerifyResult, VerifyCheckpoint, error) {
+	pruned, err := l.PrunedRanges()
+	if err != nil {
+		return VerifyResult{}, cp, err
+	}
+	gaps := newPrunedGaps(pruned)
+	prev, lastID := cp.LastHash, cp.LastID
 	var checked int64
-	for rows.Next() {
-		var rec Record
-		if err := rows.Scan(&rec.ID, &rec.Timestamp, &rec.Type, &rec.Source, &rec.Payload, &rec.Hash, &rec.PrevHash); err != nil {
-			return VerifyResult{}, err
+	if cp.LastID == 0 {
+		var failedID int64
+		var reason string
+		prev, checked, lastID, failedID, reason, err = l.verifyArchived(0, gaps)
+		if err != nil {
+			return VerifyResult{}, cp, err
 		}
-
-		if rec.PrevHash != prev {
+		if reason != "" {
 			return VerifyResult{
 				OK:        false,
-				FailedID:  rec.ID,
-				Reason:    "prev_hash mismatch",
+				FailedID:  failedID,
+				Reason:    reason,
 				Checked:   checked,
 				Timestamp: time.Now().Unix(),
-			}, nil
+			}, cp, nil
 		}
+	}
 
-		expected := computeHash(prev, rec.Timestamp, rec.Type, rec.Source, rec.Payload)
-		if rec.Hash != expected {
-			return VerifyResult{
-				OK:        false,
-				FailedID:  rec.ID,
-				Reason:    "hash mismatch",
-				Checked:   checked,
-				Timestamp: time.Now().Unix(),
-			}, nil
+	if err := l.ensureSignatureSchema(); err != nil {
+		return VerifyResult{}, cp, err
+	}
+	if err := l.ensureRedactionSchema(); err != nil {
+		return VerifyResult{}, cp, err
+	}
+	if err := l.ensureLabelSchema(); err != nil {
+		return VerifyResult{}, cp, err
+	}
+	sigs := l.signatureCheck()
+	if sigs != nil && cp.LastID > 0 {
+		// Records after a signed one must be signed too.
+		if err := l.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM ledger_record_signatures WHERE record_id <= ?)`, cp.LastID).Scan(&sigs.signed); err != nil {
+			return VerifyResult{}, cp, err
 		}
-
-		prev = rec.Hash
-		checked++
+	}
+	res, prev, lastID, err := l.verifyRecords(verifySelectSQL+` WHERE r.id > ? ORDER BY r.id ASC`, []any{lastID}, prev, lastID, gaps, sigs)
+	if err != nil {
+		return VerifyResult{}, cp, err
+	}
+	checked += res.Checked
+	if !res.OK {
+		res.Checked = checked
+		return res, cp, nil
 	}
 
-	if err := rows.Err(); err != nil {
-		return VerifyResult{}, err
+	r, reason, err := l.checkPrunedCheckpoints(pruned)
+	if err != nil {
+		return VerifyResult{}, cp, err
+	}
+	if reason != "" {
+		return VerifyResult{
+			OK:        false,
+			FailedID:  r.FirstID,
+			Reason:    reason,
+			Checked:   checked,
+			Timestamp: time.Now().Unix(),
+		}, cp, nil
+	}
+	redactedID, reason, err := l.checkRedactions()
+	if err != nil {
+		return VerifyResult{}, cp, err
+	}
+	if reason != "" {
+		return VerifyResult{
+			OK:        false,
+			FailedID:  redactedID,
+			Reason:    reason,
+			Checked:   checked,
+			Timestamp: time.Now().Unix(),
+		}, cp, nil
 	}
 
+	now := time.Now().Unix()
 	return VerifyResult{
 		OK:        true,
 		Checked:   checked,
-		Timestamp: time.Now().Unix(),
-	}, nil
+		Timestamp: now,
+	}, VerifyCheckpoint{LastID: lastID, LastHash: prev, VerifiedAt: now}, nil
 }
 
-func (l *Ledger) VerifyUpTo(targetTime int64) (ProofResult, error) {
-	rows, err := l.db.Query(`SELECT id, ts, type, source, payload, hash, prev_hash FROM ledger_records WHERE ts <= ? ORDER BY id ASC`, targetTime)
+// VerifyRange verifies the local records with ids from fromID to toID,
+// without reading the rest of the chain. The first record must follow the
+// nearest local record before it, or, when there is none, is taken on
+// trust. On failure the result names the first failing record with its
+// expected and actual hash. Pruned ranges inside the range are bridged as
+// in VerifyChain, but their checkpoints and redaction records outside the
+// range are not checked.
+func (l *Ledger) VerifyRange(fromID, toID int64) (VerifyResult, error) {
+	if fromID <= 0 || toID < fromID {
+		return VerifyResult{}, fmt.Errorf("invalid range %d-%d", fromID, toID)
+	}
+	gaps, err := l.prunedGaps()
 	if err != nil {
-		return ProofResult{}, err
+		return VerifyResult{}, err
+	}
+	if err := l.ensureSignatureSchema(); err != nil {
+		return VerifyResult{}, err
+	}
+	if err := l.ensureRedactionSchema(); err != nil {
+		return VerifyResult{}, err
+	}
+	if err := l.ensureLabelSchema(); err != nil {
+		return VerifyResult{}, err
 	}
-	defer rows.Close()
 
 	var prev string
-	var checked int64
 	var lastID int64
-	var lastHash string
+	err = l.db.QueryRow(`SELECT id, hash FROM ledger_records WHERE id < ? ORDER BY id DESC LIMIT 1`, fromID).Scan(&lastID, &prev)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return VerifyResult{}, err
+	}
+	if lastID == 0 {
+		// Nothing local to link to: start from the first record's own
+		// prev_hash.
+		if err := l.db.QueryRow(`SELECT prev_hash, id - 1 FROM ledger_records WHERE id >= ? ORDER BY id ASC LIMIT 1`, fromID).Scan(&prev, &lastID); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return VerifyResult{}, err
+		}
+	}
+	sigs := l.signatureCheck()
+	if sigs != nil {
+		if err := l.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM ledger_record_signatures WHERE record_id < ?)`, fromID).Scan(&sigs.signed); err != nil {
+			return VerifyResult{}, err
+		}
+	}
+
+	res, _, _, err := l.verifyRecords(verifySelectSQL+` WHERE r.id BETWEEN ? AND ? ORDER BY r.id ASC`, []any{fromID, toID}, prev, lastID, gaps, sigs)
+	return res, err
+}
+
+// verifyRecords checks the local records query selects, with the columns
+// of verifySelectSQL in id order, as continuing a chain that ends in prev
+// at lastID. It returns the result, which names the first failing record
+// and its expected and actual hash, and the id and hash of the last
+// record checked.
+func (l *Ledger) verifyRecords(query string, args []any, prev string, lastID int64, gaps prunedGaps, sigs *signatureCheck) (VerifyResult, string, int64, error) {
+	rows, err := l.db.Query(query, args...)
+	if err != nil {
+		return VerifyResult{}, prev, lastID, err
+	}
+	defer rows.Close()
+
+	var checked int64
+	fail := func(rec Record, reason, expected, actual string) (VerifyResult, string, int64, error) {
+		return VerifyResult{
+			OK:           false,
+			FailedID:     rec.ID,
+			Reason:       reason,
+			ExpectedHash: expected,
+			ActualHash:   actual,
+			Checked:      checked,
+			Timestamp:    time.Now().Unix(),
+		}, prev, lastID, nil
+	}
 	for rows.Next() {
 		var rec Record
-		if err := rows.Scan(&rec.ID, &rec.Timestamp, &rec.Type, &rec.Source, &rec.Payload, &rec.Hash, &rec.PrevHash); err != nil {
-			return ProofResult{}, err
+		var payloadHash, labels string
+		var redactionID int64
+		if err := rows.Scan(&rec.ID, &rec.Timestamp, &rec.Type, &rec.Source, &rec.Payload, &rec.Hash, &rec.PrevHash, &rec.SigningKeyID, &rec.Signature, &payloadHash, &redactionID, &labels); err != nil {
+			return VerifyResult{}, prev, lastID, err
+		}
+		if payloadHash != "" {
+			rec.Redaction = &Redaction{PayloadHash: payloadHash, RedactionID: redactionID}
+		}
+		if rec.Labels, err = parseLabels(labels); err != nil {
+			return VerifyResult{}, prev, lastID, err
 		}
 
+		prev = gaps.bridge(prev, lastID, rec)
 		if rec.PrevHash != prev {
-			return ProofResult{
-				OK:        false,
-				FailedID:  rec.ID,
-				Reason:    "prev_hash mismatch",
-				Checked:   checked,
-				Timestamp: time.Now().Unix(),
-			}, nil
+			return fail(rec, "prev_hash mismatch", prev, rec.PrevHash)
 		}
-
-		expected := computeHash(prev, rec.Timestamp, rec.Type, rec.Source, rec.Payload)
-		if rec.Hash != expected {
-			return ProofResult{
-				OK:        false,
-				FailedID:  rec.ID,
-				Reason:    "hash mismatch",
-				Checked:   checked,
-				Timestamp: time.Now().Unix(),
-			}, nil
+		if !hashMatches(prev, rec) {
+			return fail(rec, "hash mismatch", expectedHash(prev, rec), rec.Hash)
+		}
+		if reason := redactionProblem(rec); reason != "" {
+			return fail(rec, reason, "", "")
+		}
+		if sigs != nil {
+			if reason := sigs.check(rec); reason != "" {
+				return fail(rec, reason, "", "")
+			}
 		}
 
 		prev = rec.Hash
 		lastID = rec.ID
-		lastHash = rec.Hash
 		checked++
 	}
-
 	if err := rows.Err(); err != nil {
+		return VerifyResult{}, prev, lastID, err
+	}
+	return VerifyResult{OK: true, Checked: checked, Timestamp: time.Now().Unix()}, prev, lastID, nil
+}
+
+// expectedHash is the hash rec should have after prev, in the current
+// layout.
+func expectedHash(prev string, rec Record) string {
+	if rec.Redaction != nil {
+		return commitmentHash(prev, rec.Timestamp, rec.Type, rec.Source, rec.Redaction.PayloadHash, rec.Labels)
+	}
+	return labeledHash(prev, rec.Timestamp, rec.Type, rec.Source, rec.Payload, rec.Labels)
+}
+
+func (l *Ledger) VerifyUpTo(targetTime int64) (ProofResult, error) {
+	return l.verifyUpTo(targetTime, math.MaxInt64)
+}
+
+// verifyUpTo is VerifyUpTo over the records up to headID, so that a proof
+// covers the same prefix of the chain as the read it accompanies.
+func (l *Ledger) verifyUpTo(targetTime, headID int64) (ProofResult, error) {
+	gaps, err := l.prunedGaps()
+	if err != nil {
+		return ProofResult{}, err
+	}
+	prev, checked, lastID, failedID, reason, err := l.verifyArchived(targetTime, gaps)
+	if err != nil {
+		return ProofResult{}, err
+	}
+	if reason != "" {
+		return ProofResult{
+			OK:        false,
+			FailedID:  failedID,
+			Reason:    reason,
+			Checked:   checked,
+			Timestamp: time.Now().Unix(),
+		}, nil
+	}
+	if err := l.ensureSignatureSchema(); err != nil {
+		return ProofResult{}, err
+	}
+	if err := l.ensureRedactionSchema(); err != nil {
 		return ProofResult{}, err
 	}
+	if err := l.ensureLabelSchema(); err != nil {
+		return ProofResult{}, err
+	}
+	res, lastHash, lastID, err := l.verifyRecords(verifySelectSQL+` WHERE r.ts <= ? AND r.id <= ? ORDER BY r.id ASC`, []any{targetTime, headID}, prev, lastID, gaps, l.signatureCheck())
+	if err != nil {
+		return ProofResult{}, err
+	}
+	checked += res.Checked
+	if !res.OK {
+		return ProofResult{
+			OK:        false,
+			FailedID:  res.FailedID,
+			Reason:    res.Reason,
+			Checked:   checked,
+			Timestamp: res.Timestamp,
+		}, nil
+	}
 
 	return ProofResult{
 		OK:        true,
 		Checked:   checked,
 		LastID:    lastID,
 		LastHash:  lastHash,
-		Timestamp: time.Now().Unix(),
+		Timestamp: res.Timestamp,
 	}, nil
 }
 
-func (l *Ledger) lastHash() (string, error) {
-	row := l.db.QueryRow(`SELECT hash FROM ledger_records ORDER BY id DESC LIMIT 1`)
-	var hash string
-	if err := row.Scan(&hash); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return "", nil
+// appendStmts returns the prepared statements used on the single-record
+// append path, preparing them on first use. They are prepared lazily because
+// the schema may not exist when the ledger is opened.
+func (l *Ledger) appendStmts() (*sql.Stmt, *sql.Stmt, error) {
+	l.stmtMu.Lock()
+	defer l.stmtMu.Unlock()
+
+	if l.insertStmt == nil {
+		stmt, err := l.db.Prepare(insertRecordSQL)
+		if err != nil {
+			return nil, nil, err
 		}
-		return "", err
+		l.insertStmt = stmt
 	}
-	return hash, nil
+	if l.lastHashStmt == nil {
+		stmt, err := l.db.Prepare(lastHashSQL)
+		if err != nil {
+			return nil, nil, err
+		}
+		l.lastHashStmt = stmt
+	}
+	return l.insertStmt, l.lastHashStmt, nil
 }
 
 func (l *Ledger) lastHashTx(tx *sql.Tx) (string, error) {
-	row := tx.QueryRow(`SELECT hash FROM ledger_records ORDER BY id DESC LIMIT 1`)
+	hash, err := scanHash(tx.QueryRow(lastHashSQL))
+	if err == nil && hash == "" {
+		return archiveTip(tx)
+	}
+	return hash, err
+}
+
+func scanHash(row *sql.Row) (string, error) {
 	var hash string
 	if err := row.Scan(&hash); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -390,10 +1222,125 @@ func (l *Ledger) lastHashTx(tx *sql.Tx) (string, error) {
 	return hash, nil
 }
 
+const maxPooledHashBuf = 64 << 10
+
+// hashBufPool recycles the scratch buffers used to build hash input so the
+// append path does not allocate a fresh buffer per record.
+var hashBufPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, 0, 512)
+		return &buf
+	},
+}
+
+// RecordHash returns the chain hash of an unlabeled record following
+// prevHash. Agents chain their buffered records with it so the server can
+// verify them.
+func RecordHash(prevHash string, ts int64, rtype, source, payload string) string {
+	return computeHash(prevHash, ts, rtype, source, payload)
+}
+
+// RecordHashMatches reports whether hash is the chain hash of a record
+// following prevHash, either as RecordHash computes it or in the legacy
+// layout over the raw payload used before payload commitments.
+func RecordHashMatches(hash, prevHash string, ts int64, rtype, source, payload string) bool {
+	return hash == computeHash(prevHash, ts, rtype, source, payload) ||
+		hash == legacyHash(prevHash, ts, rtype, source, payload)
+}
+
+// PayloadHash returns the commitment a record hash is computed over in
+// place of the payload: the hex SHA-256 of the payload. Redacted records
+// keep it so they still verify without their payload.
+func PayloadHash(payload string) string {
+	var out [sha256.Size * 2]byte
+	payloadHashHex(&out, payload)
+	return string(out[:])
+}
+
+// payloadHashHex writes PayloadHash(payload) to out. The payload is hashed
+// from a pooled copy, so the append path allocates neither the copy nor
+// the hex digest.
+func payloadHashHex(out *[sha256.Size * 2]byte, payload string) {
+	bufPtr := hashBufPool.Get().(*[]byte)
+	buf := append((*bufPtr)[:0], payload...)
+	sum := sha256.Sum256(buf)
+	if cap(buf) <= maxPooledHashBuf {
+		*bufPtr = buf
+		hashBufPool.Put(bufPtr)
+	}
+	hex.Encode(out[:], sum[:])
+}
+
+// hashMatches reports whether rec.Hash commits to rec following prev.
+func hashMatches(prev string, rec Record) bool {
+	switch {
+	case rec.Redaction != nil:
+		return rec.Hash == commitmentHash(prev, rec.Timestamp, rec.Type, rec.Source, rec.Redaction.PayloadHash, rec.Labels)
+	case len(rec.Labels) > 0:
+		// Labels came after payload commitments; there is no legacy
+		// layout to fall back to.
+		return rec.Hash == labeledHash(prev, rec.Timestamp, rec.Type, rec.Source, rec.Payload, rec.Labels)
+	}
+	return RecordHashMatches(rec.Hash, prev, rec.Timestamp, rec.Type, rec.Source, rec.Payload)
+}
+
 func computeHash(prevHash string, ts int64, rtype, source, payload string) string {
-	value := fmt.Sprintf("%s|%d|%s|%s|%s", prevHash, ts, rtype, source, payload)
-	sum := sha256.Sum256([]byte(value))
-	return hex.EncodeToString(sum[:])
+	return labeledHash(prevHash, ts, rtype, source, payload, nil)
+}
+
+// labeledHash is computeHash for a record with labels.
+func labeledHash(prevHash string, ts int64, rtype, source, payload string, labels map[string]string) string {
+	var payloadHash [sha256.Size * 2]byte
+	payloadHashHex(&payloadHash, payload)
+	if len(labels) > 0 {
+		return commitmentHash(prevHash, ts, rtype, source, string(payloadHash[:]), labels)
+	}
+	return hashFields("v2|", prevHash, ts, rtype, source, payloadHash[:])
+}
+
+// commitmentHash hashes v2|prev|ts|type|source|payloadHash, or for a
+// labeled record v3|prev|ts|type|source|payloadHash|labels with the labels
+// in canonical JSON. The version prefix keeps the input distinct from the
+// legacy layout, which starts with a hex hash or '|'; unlabeled records
+// keep the v2 hash they had before labels.
+func commitmentHash(prevHash string, ts int64, rtype, source, payloadHash string, labels map[string]string) string {
+	if len(labels) > 0 {
+		return hashFields("v3|", prevHash, ts, rtype, source, payloadHash+"|"+canonicalLabels(labels))
+	}
+	return hashFields("v2|", prevHash, ts, rtype, source, payloadHash)
+}
+
+// legacyHash hashes prev|ts|type|source|payload, the layout of records
+// appended before payload commitments.
+func legacyHash(prevHash string, ts int64, rtype, source, payload string) string {
+	return hashFields("", prevHash, ts, rtype, source, payload)
+}
+
+func hashFields[T string | []byte](prefix, prevHash string, ts int64, rtype, source string, last T) string {
+	bufPtr := hashBufPool.Get().(*[]byte)
+	buf := (*bufPtr)[:0]
+
+	buf = append(buf, prefix...)
+	buf = append(buf, prevHash...)
+	buf = append(buf, '|')
+	buf = strconv.AppendInt(buf, ts, 10)
+	buf = append(buf, '|')
+	buf = append(buf, rtype...)
+	buf = append(buf, '|')
+	buf = append(buf, source...)
+	buf = append(buf, '|')
+	buf = append(buf, last...)
+
+	sum := sha256.Sum256(buf)
+
+	if cap(buf) <= maxPooledHashBuf {
+		*bufPtr = buf
+		hashBufPool.Put(bufPtr)
+	}
+
+	var out [sha256.Size * 2]byte
+	hex.Encode(out[:], sum[:])
+	return string(out[:])
 }
 
 func scanRecord(row *sql.Row) (Record, error) {
@@ -0,0 +1,16 @@
+//go:build !(darwin || dragonfly || freebsd || linux || windows)
+
+package ledger
+
+import "os"
+
+// lockFile does nothing on platforms without file locks; a second process
+// opening the same encrypted ledger is not detected.
+func lockFile(f *os.File) error {
+	return nil
+}
+
+// syncDir does nothing on platforms without a portable directory sync.
+func syncDir(path string) error {
+	return nil
+}
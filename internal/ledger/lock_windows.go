@@ -0,0 +1,26 @@
+//go:build windows
+
+package ledger
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockFile takes an exclusive lock on f without waiting. The lock is
+// released when f is closed.
+func lockFile(f *os.File) error {
+	err := windows.LockFileEx(windows.Handle(f.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, &windows.Overlapped{})
+	if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+		return ErrDatabaseLocked
+	}
+	return err
+}
+
+// syncDir does nothing on Windows, where directories cannot be synced;
+// NTFS journals the rename itself.
+func syncDir(path string) error {
+	return nil
+}
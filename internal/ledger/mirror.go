@@ -0,0 +1,317 @@
+package ledger
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Divergence kinds reported by a mirror.
+const (
+	// DivergenceWriteFailed means a record could not be written to the
+	// secondary. The mirror falls behind and catches up on the next append.
+	DivergenceWriteFailed = "write_failed"
+	// DivergenceHashMismatch means the secondary chained a record to a
+	// different hash than the primary. The mirror stops until the
+	// secondary is rebuilt.
+	DivergenceHashMismatch = "hash_mismatch"
+)
+
+// mirrorBatch bounds how many records a catch-up copies per transaction.
+const mirrorBatch = 500
+
+// ErrMirrorDiverged is returned when the secondary's chain does not match
+// the primary's, so records can no longer be copied onto it.
+var ErrMirrorDiverged = errors.New("mirror diverged from primary")
+
+// Divergence describes a record the secondary ledger failed to match.
+type Divergence struct {
+	Kind          string `json:"kind"`
+	PrimaryID     int64  `json:"primary_id"`
+	PrimaryHash   string `json:"primary_hash"`
+	SecondaryHash string `json:"secondary_hash,omitempty"`
+	Error         string `json:"error,omitempty"`
+	Time          int64  `json:"time"`
+}
+
+// MirrorStatus summarizes a mirror since it was attached.
+type MirrorStatus struct {
+	Mirrored       int64       `json:"mirrored"`
+	Behind         bool        `json:"behind"`
+	Diverged       bool        `json:"diverged"`
+	Divergences    int64       `json:"divergences"`
+	LastDivergence *Divergence `json:"last_divergence,omitempty"`
+}
+
+// MirrorCheck compares the primary and secondary chains record by record.
+type MirrorCheck struct {
+	Consistent bool `json:"consistent"`
+	// Lag is the number of primary records the secondary does not have yet.
+	Lag            int64  `json:"lag"`
+	PrimaryCount   int64  `json:"primary_count"`
+	SecondaryCount int64  `json:"secondary_count"`
+	PrimaryHead    string `json:"primary_head"`
+	SecondaryHead  string `json:"secondary_head"`
+	// FirstDivergentID is the primary record at which the chains differ.
+	FirstDivergentID int64 `json:"first_divergent_id,omitempty"`
+}
+
+// mirror copies records committed to a ledger onto a secondary ledger.
+type mirror struct {
+	secondary    *Ledger
+	onDivergence func(Divergence)
+
+	mu     sync.Mutex
+	status MirrorStatus
+}
+
+// SetMirror dual-writes every record appended to l onto secondary, so a
+// deployment can move between backends without a gap in capture. Records
+// are copied after they commit on l, in the same order, and the secondary's
+// hashes are compared with l's. A failed write does not fail the append: it
+// is reported to onDivergence and the secondary catches up on the next
+// append. Agent attribution and idempotency keys are not copied.
+//
+// A secondary that is empty is backfilled from l on the first append.
+func (l *Ledger) SetMirror(secondary *Ledger, onDivergence func(Divergence)) {
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+
+	if secondary == nil {
+		l.mirror.Store(nil)
+		return
+	}
+	l.mirror.Store(&mirror{secondary: secondary, onDivergence: onDivergence, status: MirrorStatus{Behind: true}})
+}
+
+// MirrorStatus reports the attached mirror's progress. ok is false when no
+// mirror is attached.
+func (l *Ledger) MirrorStatus() (status MirrorStatus, ok bool) {
+	m := l.mirror.Load()
+	if m == nil {
+		return MirrorStatus{}, false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.status, true
+}
+
+// SyncMirror copies every primary record the secondary is missing and
+// returns the number copied.
+func (l *Ledger) SyncMirror() (int, error) {
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+
+	m := l.mirror.Load()
+	if m == nil {
+		return 0, errors.New("no mirror attached")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.catchUp(l)
+}
+
+// mirrorAppended copies records just committed on l. The caller must hold
+// l.writeMu so that records reach the secondary in commit order.
+func (l *Ledger) mirrorAppended(records []Record) {
+	m := l.mirror.Load()
+	if m == nil || len(records) == 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.status.Diverged {
+		return
+	}
+	if m.status.Behind {
+		// The records just committed are copied along with the backlog.
+		_, _ = m.catchUp(l)
+		return
+	}
+	_ = m.copy(records)
+}
+
+// copy appends records to the secondary and compares the resulting hashes.
+func (m *mirror) copy(records []Record) error {
+	inputs := make([]RecordInput, len(records))
+	for i, rec := range records {
+		inputs[i] = RecordInput{Timestamp: rec.Timestamp, Type: rec.Type, Source: rec.Source, Payload: rec.Payload, Labels: rec.Labels}
+	}
+	copied, err := m.secondary.AppendBatch(inputs)
+	if err != nil {
+		m.status.Behind = true
+		m.report(Divergence{Kind: DivergenceWriteFailed, PrimaryID: records[0].ID, PrimaryHash: records[0].Hash, Error: err.Error()})
+		return err
+	}
+	for i, rec := range copied {
+		if rec.Hash != records[i].Hash {
+			m.status.Diverged = true
+			m.report(Divergence{Kind: DivergenceHashMismatch, PrimaryID: records[i].ID, PrimaryHash: records[i].Hash, SecondaryHash: rec.Hash})
+			return fmt.Errorf("%w at record %d", ErrMirrorDiverged, records[i].ID)
+		}
+	}
+	m.status.Mirrored += int64(len(copied))
+	return nil
+}
+
+// catchUp copies the primary records that follow the secondary's head.
+func (m *mirror) catchUp(primary *Ledger) (int, error) {
+	head, err := m.secondary.headHash()
+	if err != nil {
+		m.report(Divergence{Kind: DivergenceWriteFailed, Error: err.Error()})
+		return 0, err
+	}
+	after, err := primary.idOfHash(head)
+	if errors.Is(err, ErrMirrorDiverged) {
+		m.status.Diverged = true
+		m.report(Divergence{Kind: DivergenceHashMismatch, SecondaryHash: head, Error: err.Error()})
+		return 0, err
+	}
+	if err != nil {
+		m.report(Divergence{Kind: DivergenceWriteFailed, Error: err.Error()})
+		return 0, err
+	}
+
+	copied := 0
+	for {
+		records, err := primary.recordsAfter(after, mirrorBatch)
+		if err != nil {
+			m.report(Divergence{Kind: DivergenceWriteFailed, Error: err.Error()})
+			return copied, err
+		}
+		if len(records) == 0 {
+			m.status.Behind = false
+			return copied, nil
+		}
+		if err := m.copy(records); err != nil {
+			return copied, err
+		}
+		copied += len(records)
+		after = records[len(records)-1].ID
+	}
+}
+
+func (m *mirror) report(d Divergence) {
+	d.Time = time.Now().Unix()
+	m.status.Divergences++
+	m.status.LastDivergence = &d
+	if m.onDivergence != nil {
+		m.onDivergence(d)
+	}
+}
+
+// CompareMirror walks the primary and secondary chains and reports whether
+// the secondary holds a prefix of the primary's records.
+func (l *Ledger) CompareMirror() (MirrorCheck, error) {
+	m := l.mirror.Load()
+	if m == nil {
+		return MirrorCheck{}, errors.New("no mirror attached")
+	}
+	return CompareLedgers(l, m.secondary)
+}
+
+// CompareLedgers compares the chains of a primary ledger and a ledger that
+// mirrors it.
+func CompareLedgers(primary, secondary *Ledger) (MirrorCheck, error) {
+	prows, err := primary.db.Query(`SELECT id, hash FROM ledger_records ORDER BY id ASC`)
+	if err != nil {
+		return MirrorCheck{}, err
+	}
+	defer prows.Close()
+	srows, err := secondary.db.Query(`SELECT hash FROM ledger_records ORDER BY id ASC`)
+	if err != nil {
+		return MirrorCheck{}, err
+	}
+	defer srows.Close()
+
+	check := MirrorCheck{Consistent: true}
+	for prows.Next() {
+		var id int64
+		var hash string
+		if err := prows.Scan(&id, &hash); err != nil {
+			return MirrorCheck{}, err
+		}
+		check.PrimaryCount++
+		check.PrimaryHead = hash
+		if !srows.Next() {
+			check.Lag++
+			continue
+		}
+		var shash string
+		if err := srows.Scan(&shash); err != nil {
+			return MirrorCheck{}, err
+		}
+		check.SecondaryCount++
+		check.SecondaryHead = shash
+		if shash != hash && check.Consistent {
+			check.Consistent = false
+			check.FirstDivergentID = id
+		}
+	}
+	if err := prows.Err(); err != nil {
+		return MirrorCheck{}, err
+	}
+	for srows.Next() {
+		// The secondary holds records the primary does not.
+		var shash string
+		if err := srows.Scan(&shash); err != nil {
+			return MirrorCheck{}, err
+		}
+		check.SecondaryCount++
+		check.SecondaryHead = shash
+		check.Consistent = false
+	}
+	return check, srows.Err()
+}
+
+// headHash returns the hash of the ledger's last record.
+func (l *Ledger) headHash() (string, error) {
+	hash, err := scanHash(l.db.QueryRow(lastHashSQL))
+	if err == nil && hash == "" {
+		return archiveTip(l.db)
+	}
+	return hash, err
+}
+
+// idOfHash returns the ID of the live record with hash, or 0 for the empty
+// hash when the chain starts in this ledger.
+func (l *Ledger) idOfHash(hash string) (int64, error) {
+	if hash == "" {
+		var prev string
+		err := l.db.QueryRow(`SELECT prev_hash FROM ledger_records ORDER BY id ASC LIMIT 1`).Scan(&prev)
+		if err == nil && prev != "" {
+			return 0, fmt.Errorf("%w: primary chain starts from archived records; import them into the secondary first", ErrMirrorDiverged)
+		}
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return 0, err
+		}
+		return 0, nil
+	}
+	var id int64
+	err := l.db.QueryRow(`SELECT id FROM ledger_records WHERE hash = ?`, hash).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("%w: secondary head %s is not in the primary chain", ErrMirrorDiverged, hash)
+	}
+	return id, err
+}
+
+func (l *Ledger) recordsAfter(id int64, limit int) ([]Record, error) {
+	rows, err := l.db.Query(`SELECT id, ts, type, source, payload, hash, prev_hash FROM ledger_records WHERE id > ? ORDER BY id ASC LIMIT ?`, id, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []Record
+	for rows.Next() {
+		var rec Record
+		if err := rows.Scan(&rec.ID, &rec.Timestamp, &rec.Type, &rec.Source, &rec.Payload, &rec.Hash, &rec.PrevHash); err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
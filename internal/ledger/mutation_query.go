@@ -0,0 +1,157 @@
+package ledger
+
+import (
+	"errors"
+
+	"github.com/Retr0-XD/StateLedger/internal/collectors"
+)
+
+const (
+	defaultMutationPageSize = 100
+	maxMutationPageSize     = 1000
+)
+
+// MutationFilter selects mutation records of a snapshot. Empty fields match
+// everything.
+type MutationFilter struct {
+	// Until is the snapshot time; only mutations with ts <= Until match.
+	Until int64
+	// Type and Source match the mutation payload fields exactly.
+	Type   string
+	Source string
+	// Namespace matches the namespace part of external_ref ("kafka:payments"
+	// for "kafka:payments:42").
+	Namespace string
+	// RefFrom and RefTo bound the numeric external_ref offset, inclusive.
+	// Mutations without a numeric offset never match a bounded range.
+	RefFrom *int64
+	RefTo   *int64
+	// Offset and Limit page through the matches in replay order.
+	Offset int
+	Limit  int
+}
+
+// MutationPage is one page of filtered mutation records.
+type MutationPage struct {
+	Records []MutationRecord `json:"records"`
+	Total   int              `json:"total"`
+	Offset  int              `json:"offset"`
+	Limit   int              `json:"limit"`
+}
+
+// QueryMutations returns the mutation records of the snapshot at f.Until
+// that match f, in the same order reconstruction uses, without decoding the
+// rest of the state.
+func (l *Ledger) QueryMutations(f MutationFilter) (MutationPage, error) {
+	if f.Until <= 0 {
+		return MutationPage{}, errors.New("snapshot time required")
+	}
+	if f.Offset < 0 {
+		return MutationPage{}, errors.New("offset must not be negative")
+	}
+	if f.Limit <= 0 {
+		f.Limit = defaultMutationPageSize
+	}
+	if f.Limit > maxMutationPageSize {
+		f.Limit = maxMutationPageSize
+	}
+
+	matched, err := l.matchMutations(f)
+	if err != nil {
+		return MutationPage{}, err
+	}
+	page := MutationPage{Records: []MutationRecord{}, Total: len(matched), Offset: f.Offset, Limit: f.Limit}
+	if f.Offset < len(matched) {
+		end := min(f.Offset+f.Limit, len(matched))
+		page.Records = matched[f.Offset:end]
+	}
+	return page, nil
+}
+
+// matchMutations returns every mutation record of the snapshot at f.Until
+// that matches f, in replay order.
+func (l *Ledger) matchMutations(f MutationFilter) ([]MutationRecord, error) {
+	var matched []MutationRecord
+	add := func(rec Record) {
+		mr, _, err := parseMutationRecord(rec)
+		if err == nil && f.matches(mr) {
+			matched = append(matched, mr)
+		}
+	}
+
+	archived, err := l.archivedRecords(ListQuery{Until: f.Until})
+	if err != nil {
+		return nil, err
+	}
+	for _, rec := range archived {
+		if rec.Type == "mutation" {
+			add(rec)
+		}
+	}
+
+	rows, err := l.readQuery(`SELECT id, ts, type, source, payload, hash, prev_hash FROM ledger_records WHERE type = 'mutation' AND ts <= ? ORDER BY id ASC`, f.Until)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var rec Record
+		if err := rows.Scan(&rec.ID, &rec.Timestamp, &rec.Type, &rec.Source, &rec.Payload, &rec.Hash, &rec.PrevHash); err != nil {
+			return nil, err
+		}
+		add(rec)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(matched) > 1 {
+		orderMutationRecords(matched)
+	}
+	return matched, nil
+}
+
+func (f MutationFilter) matches(mr MutationRecord) bool {
+	if f.Type != "" && mr.Type != f.Type {
+		return false
+	}
+	if f.Source != "" && mr.Source != f.Source {
+		return false
+	}
+	if f.Namespace != "" && mr.Namespace != f.Namespace {
+		return false
+	}
+	if f.RefFrom != nil || f.RefTo != nil {
+		if _, _, ok := parseExternalRef(mr.ExternalRef); !ok {
+			return false
+		}
+		if f.RefFrom != nil && mr.Offset < *f.RefFrom {
+			return false
+		}
+		if f.RefTo != nil && mr.Offset > *f.RefTo {
+			return false
+		}
+	}
+	return true
+}
+
+// parseMutationRecord decodes a mutation record's payload into the form
+// used by reconstruction.
+func parseMutationRecord(rec Record) (MutationRecord, collectors.MutationPayload, error) {
+	var mp collectors.MutationPayload
+	if err := collectors.ParseJSON(rec.Payload, &mp); err != nil {
+		return MutationRecord{}, collectors.MutationPayload{}, err
+	}
+	namespace, offset, _ := parseExternalRef(mp.ExternalRef)
+	return MutationRecord{
+		LedgerID:    rec.ID,
+		Timestamp:   rec.Timestamp,
+		Type:        mp.Type,
+		ID:          mp.ID,
+		Source:      mp.Source,
+		Hash:        mp.Hash,
+		ExternalRef: mp.ExternalRef,
+		Namespace:   namespace,
+		Offset:      offset,
+	}, mp, nil
+}
@@ -0,0 +1,176 @@
+package ledger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Notifier kinds
+const (
+	NotifierSlack = "slack"
+	NotifierTeams = "teams"
+)
+
+// defaultNotifyTemplates are the messages for the high-severity events.
+// Templates are executed against the event's webhook JSON, so fields use
+// their JSON names.
+var defaultNotifyTemplates = map[string]string{
+	EventVerificationFailed: `Hash chain verification failed at record {{.data.failed_id}}: {{.data.reason}}`,
+	EventMirrorDivergence:   `Mirror diverged at record {{.data.primary_id}} ({{.data.kind}}){{with .data.error}}: {{.}}{{end}}`,
+	EventDriftDetected:      `Config drift in {{.data.source}} between records {{.data.from_id}} and {{.data.to_id}}`,
+	EventDeterminismLow:     `Determinism score {{.data.score}} is below {{.data.threshold}}`,
+	EventCoverageDegraded:   `Source {{.data.name}} is {{.data.status}}`,
+	EventAgentOffline:       `Agent {{.data.hostname}} ({{.data.id}}) has not been seen since {{.data.last_seen}}`,
+	EventQuotaExceeded:      `Client {{.data.client}} exceeded its rate limit`,
+	EventMutationAnomaly:    `Mutation rate {{.data.direction}} in {{.data.source}} ({{.data.mutation_type}}): {{.data.count}} records against a baseline of {{.data.baseline_mean}}`,
+	EventWebhookLagging:     `Webhook {{.data.id}} is {{.data.lag_seconds}}s behind with {{.data.queued}} deliveries queued and {{.data.dropped}} events dropped`,
+}
+
+const fallbackNotifyTemplate = `{{.event_type}}`
+
+var severityRank = map[string]int{
+	SeverityInfo:     0,
+	SeverityWarning:  1,
+	SeverityCritical: 2,
+}
+
+var teamsColors = map[string]string{
+	SeverityInfo:     "2EB886",
+	SeverityWarning:  "DAA038",
+	SeverityCritical: "A30200",
+}
+
+// Notifier posts events as chat messages to a Slack or Microsoft Teams
+// incoming webhook
+type Notifier struct {
+	Kind string
+	URL  string
+	// MinSeverity is the lowest severity sent; defaults to warning
+	MinSeverity string
+	// OnError receives deliveries that failed after retries
+	OnError func(event WebhookEvent, err error)
+
+	templates  map[string]*template.Template
+	httpClient *http.Client
+}
+
+// NewNotifier creates a notifier of the given kind. templates override the
+// default message per event type; "*" overrides the fallback used for
+// event types without a template.
+func NewNotifier(kind, url string, templates map[string]string) (*Notifier, error) {
+	if kind != NotifierSlack && kind != NotifierTeams {
+		return nil, fmt.Errorf("unknown notifier kind %q (want %s or %s)", kind, NotifierSlack, NotifierTeams)
+	}
+	if !strings.HasPrefix(url, "https://") && !strings.HasPrefix(url, "http://") {
+		return nil, fmt.Errorf("notifier url must be http(s): %q", url)
+	}
+
+	sources := map[string]string{"*": fallbackNotifyTemplate}
+	for eventType, text := range defaultNotifyTemplates {
+		sources[eventType] = text
+	}
+	for eventType, text := range templates {
+		sources[eventType] = text
+	}
+
+	n := &Notifier{
+		Kind:        kind,
+		URL:         url,
+		MinSeverity: SeverityWarning,
+		templates:   make(map[string]*template.Template, len(sources)),
+		httpClient:  &http.Client{Timeout: 10 * time.Second},
+	}
+	for eventType, text := range sources {
+		tmpl, err := template.New(eventType).Option("missingkey=zero").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("template for %s: %w", eventType, err)
+		}
+		n.templates[eventType] = tmpl
+	}
+	return n, nil
+}
+
+// Wants reports whether the event is severe enough to send
+func (n *Notifier) Wants(event WebhookEvent) bool {
+	severity := event.Severity
+	if severity == "" {
+		severity = EventSeverity(event.EventType)
+	}
+	return severityRank[severity] >= severityRank[n.MinSeverity]
+}
+
+// Message renders the event's message text
+func (n *Notifier) Message(event WebhookEvent) (string, error) {
+	raw, err := json.Marshal(event)
+	if err != nil {
+		return "", err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return "", err
+	}
+
+	tmpl, ok := n.templates[event.EventType]
+	if !ok {
+		tmpl = n.templates["*"]
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, fields); err != nil {
+		return "", fmt.Errorf("template for %s: %w", event.EventType, err)
+	}
+	return buf.String(), nil
+}
+
+// Payload builds the Slack or Teams request body for the event
+func (n *Notifier) Payload(event WebhookEvent) (interface{}, error) {
+	text, err := n.Message(event)
+	if err != nil {
+		return nil, err
+	}
+	severity := event.Severity
+	if severity == "" {
+		severity = EventSeverity(event.EventType)
+	}
+	title := fmt.Sprintf("[%s] %s", severity, event.EventType)
+
+	if n.Kind == NotifierTeams {
+		return map[string]interface{}{
+			"@type":      "MessageCard",
+			"@context":   "https://schema.org/extensions",
+			"themeColor": teamsColors[severity],
+			"summary":    title,
+			"title":      title,
+			"text":       text,
+		}, nil
+	}
+	return map[string]interface{}{
+		"text": fmt.Sprintf("*%s*\n%s", title, text),
+	}, nil
+}
+
+// Notify posts the event once
+func (n *Notifier) Notify(event WebhookEvent) error {
+	body, err := n.Payload(event)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	resp, err := n.httpClient.Post(n.URL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s notifier: unexpected status %d", n.Kind, resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,291 @@
+package ledger
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+)
+
+// Bounds of a Parquet row group: the rows buffered before they are written.
+const (
+	parquetRowGroupRows  = 10000
+	parquetRowGroupBytes = 64 << 20
+)
+
+var parquetMagic = []byte("PAR1")
+
+// Parquet enum values, from parquet.thrift.
+const (
+	parquetInt64        = 2
+	parquetByteArray    = 6
+	parquetRequired     = 0
+	parquetConvertedUTF = 0
+	parquetPlain        = 0
+	parquetRLE          = 3
+	parquetUncompressed = 0
+	parquetDataPage     = 0
+)
+
+// parquetExporter writes records as a Parquet file: one required column
+// per export column, plain encoded and uncompressed, in row groups of at
+// most parquetRowGroupRows rows. It needs no Parquet library and reads in
+// any Parquet reader, such as DuckDB, pandas or Spark.
+type parquetExporter struct {
+	w      *bufio.Writer
+	offset int64
+	err    error
+
+	ints    [2][]int64
+	strs    [][]string
+	bytes   int
+	rows    int64
+	groups  []parquetRowGroup
+	started bool
+}
+
+type parquetRowGroup struct {
+	rows    int64
+	size    int64
+	columns []parquetChunk
+}
+
+type parquetChunk struct {
+	offset int64
+	size   int64
+	values int64
+}
+
+func newParquetExporter(w io.Writer) *parquetExporter {
+	return &parquetExporter{w: bufio.NewWriter(w), strs: make([][]string, len(exportColumns)-2)}
+}
+
+func (p *parquetExporter) write(b []byte) {
+	if p.err != nil {
+		return
+	}
+	_, p.err = p.w.Write(b)
+	p.offset += int64(len(b))
+}
+
+func (p *parquetExporter) Write(rec Record) error {
+	if !p.started {
+		p.write(parquetMagic)
+		p.started = true
+	}
+	p.ints[0] = append(p.ints[0], rec.ID)
+	p.ints[1] = append(p.ints[1], rec.Timestamp)
+	for i, v := range exportFields(rec) {
+		p.strs[i] = append(p.strs[i], v)
+		p.bytes += len(v)
+	}
+	p.rows++
+	if len(p.ints[0]) >= parquetRowGroupRows || p.bytes >= parquetRowGroupBytes {
+		p.flushRowGroup()
+	}
+	return p.err
+}
+
+// flushRowGroup writes the buffered rows as a row group with one data page
+// per column.
+func (p *parquetExporter) flushRowGroup() {
+	n := len(p.ints[0])
+	if n == 0 {
+		return
+	}
+	group := parquetRowGroup{rows: int64(n)}
+	for c := range exportColumns {
+		var page []byte
+		if c < 2 {
+			for _, v := range p.ints[c] {
+				page = binary.LittleEndian.AppendUint64(page, uint64(v))
+			}
+		} else {
+			for _, v := range p.strs[c-2] {
+				page = binary.LittleEndian.AppendUint32(page, uint32(len(v)))
+				page = append(page, v...)
+			}
+		}
+		header := parquetPageHeader(n, len(page))
+		chunk := parquetChunk{offset: p.offset, size: int64(len(header) + len(page)), values: int64(n)}
+		p.write(header)
+		p.write(page)
+		group.columns = append(group.columns, chunk)
+		group.size += chunk.size
+	}
+	p.groups = append(p.groups, group)
+
+	p.ints[0], p.ints[1] = p.ints[0][:0], p.ints[1][:0]
+	for i := range p.strs {
+		p.strs[i] = p.strs[i][:0]
+	}
+	p.bytes = 0
+}
+
+// Close writes the remaining rows and the file footer.
+func (p *parquetExporter) Close() error {
+	if !p.started {
+		p.write(parquetMagic)
+		p.started = true
+	}
+	p.flushRowGroup()
+	footer := p.footer()
+	p.write(footer)
+	p.write(binary.LittleEndian.AppendUint32(nil, uint32(len(footer))))
+	p.write(parquetMagic)
+	if p.err != nil {
+		return p.err
+	}
+	return p.w.Flush()
+}
+
+func parquetColumnType(c int) int32 {
+	if c < 2 {
+		return parquetInt64
+	}
+	return parquetByteArray
+}
+
+// parquetPageHeader encodes the PageHeader of a data page of n required
+// values.
+func parquetPageHeader(n, size int) []byte {
+	var t thriftCompact
+	t.begin()
+	t.i32(1, parquetDataPage)
+	t.i32(2, int32(size))
+	t.i32(3, int32(size))
+	t.beginStruct(5)
+	t.i32(1, int32(n))
+	t.i32(2, parquetPlain)
+	t.i32(3, parquetRLE)
+	t.i32(4, parquetRLE)
+	t.end()
+	t.end()
+	return t.b
+}
+
+// footer encodes the FileMetaData of the file.
+func (p *parquetExporter) footer() []byte {
+	var t thriftCompact
+	t.begin()
+	t.i32(1, 1)
+	t.list(2, thriftStruct, len(exportColumns)+1)
+	t.beginElem()
+	t.str(4, "ledger_records")
+	t.i32(5, int32(len(exportColumns)))
+	t.end()
+	for c, name := range exportColumns {
+		t.beginElem()
+		t.i32(1, parquetColumnType(c))
+		t.i32(3, parquetRequired)
+		t.str(4, name)
+		if c >= 2 {
+			t.i32(6, parquetConvertedUTF)
+		}
+		t.end()
+	}
+	t.i64(3, p.rows)
+	t.list(4, thriftStruct, len(p.groups))
+	for _, g := range p.groups {
+		t.beginElem()
+		t.list(1, thriftStruct, len(g.columns))
+		for c, chunk := range g.columns {
+			t.beginElem()
+			t.i64(2, chunk.offset)
+			t.beginStruct(3)
+			t.i32(1, parquetColumnType(c))
+			t.list(2, thriftI32, 1)
+			t.elemI32(parquetPlain)
+			t.list(3, thriftBinary, 1)
+			t.elemStr(exportColumns[c])
+			t.i32(4, parquetUncompressed)
+			t.i64(5, chunk.values)
+			t.i64(6, chunk.size)
+			t.i64(7, chunk.size)
+			t.i64(9, chunk.offset)
+			t.end()
+			t.end()
+		}
+		t.i64(2, g.size)
+		t.i64(3, g.rows)
+		t.end()
+	}
+	t.str(6, "stateledger")
+	t.end()
+	return t.b
+}
+
+// Thrift compact protocol types.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftCompact encodes Thrift structs in the compact protocol, as Parquet
+// metadata is written.
+type thriftCompact struct {
+	b    []byte
+	last []int16 // the last field ID of each open struct
+}
+
+func (t *thriftCompact) begin() { t.last = append(t.last, 0) }
+
+// end closes the innermost struct.
+func (t *thriftCompact) end() {
+	t.b = append(t.b, 0)
+	t.last = t.last[:len(t.last)-1]
+}
+
+func (t *thriftCompact) field(id int16, typ byte) {
+	last := &t.last[len(t.last)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		t.b = append(t.b, byte(delta)<<4|typ)
+	} else {
+		t.b = append(t.b, typ)
+		t.b = binary.AppendVarint(t.b, int64(id))
+	}
+	*last = id
+}
+
+func (t *thriftCompact) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.b = binary.AppendVarint(t.b, int64(v))
+}
+
+func (t *thriftCompact) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.b = binary.AppendVarint(t.b, v)
+}
+
+func (t *thriftCompact) str(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.elemStr(s)
+}
+
+func (t *thriftCompact) beginStruct(id int16) {
+	t.field(id, thriftStruct)
+	t.begin()
+}
+
+// list starts a list field of n elements of type elem.
+func (t *thriftCompact) list(id int16, elem byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.b = append(t.b, byte(n)<<4|elem)
+	} else {
+		t.b = append(t.b, 0xf0|elem)
+		t.b = binary.AppendUvarint(t.b, uint64(n))
+	}
+}
+
+// beginElem starts a struct element of a list.
+func (t *thriftCompact) beginElem() { t.begin() }
+
+func (t *thriftCompact) elemI32(v int32) { t.b = binary.AppendVarint(t.b, int64(v)) }
+
+func (t *thriftCompact) elemStr(s string) {
+	t.b = binary.AppendUvarint(t.b, uint64(len(s)))
+	t.b = append(t.b, s...)
+}
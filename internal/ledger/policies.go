@@ -0,0 +1,376 @@
+package ledger
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/Retr0-XD/StateLedger/internal/collectors"
+)
+
+const policySchema = `
+CREATE TABLE IF NOT EXISTS ledger_policies (
+	kind TEXT NOT NULL,
+	version INTEGER NOT NULL,
+	record_id INTEGER NOT NULL,
+	set_at INTEGER NOT NULL,
+	PRIMARY KEY (kind, version)
+);
+`
+
+// PolicyRecordType is the record type under which policy versions are
+// appended, with the policy kind as source, so the rules in force at any
+// time can be read back from the chain alongside the state they governed.
+const PolicyRecordType = "policy"
+
+// Policy kinds.
+const (
+	PolicyDeterminism = "determinism"
+	PolicyRetention   = "retention"
+	PolicyCoverage    = "coverage"
+)
+
+// ErrUnknownPolicy is returned for a policy kind without a version in force.
+var ErrUnknownPolicy = errors.New("no policy in force")
+
+// DeterminismPolicy is the lowest acceptable determinism score (0-100) of
+// a reconstructed state. Zero accepts any score.
+type DeterminismPolicy struct {
+	MinScore float64 `json:"min_score"`
+}
+
+// RetentionRules are the retention policies applied by prune runs that are
+// not given policies of their own.
+type RetentionRules struct {
+	Rules []RetentionPolicy `json:"rules"`
+}
+
+// CoveragePolicy lists the snapshot kinds a reconstructed state needs to
+// count as complete: any of code, config, environment and mutations.
+type CoveragePolicy struct {
+	Require []string `json:"require"`
+}
+
+// PolicySet holds one version of each kind of policy. Sections left nil
+// are not part of the set; SetPolicies leaves their version in force.
+type PolicySet struct {
+	Determinism *DeterminismPolicy `json:"determinism,omitempty"`
+	Retention   *RetentionRules    `json:"retention,omitempty"`
+	Coverage    *CoveragePolicy    `json:"coverage,omitempty"`
+}
+
+// Policy is one version of a policy kind, with the section of that kind
+// set. RecordID, Hash and SetAt identify the ledger record that set it; it
+// is in force from SetAt until the next version.
+type Policy struct {
+	Kind        string             `json:"kind"`
+	Version     int                `json:"version"`
+	Determinism *DeterminismPolicy `json:"determinism,omitempty"`
+	Retention   *RetentionRules    `json:"retention,omitempty"`
+	Coverage    *CoveragePolicy    `json:"coverage,omitempty"`
+	RecordID    int64              `json:"record_id,omitempty"`
+	Hash        string             `json:"hash,omitempty"`
+	SetAt       int64              `json:"set_at,omitempty"`
+}
+
+// policyRecord is the payload of a policy record.
+type policyRecord struct {
+	Kind        string             `json:"kind"`
+	Version     int                `json:"version"`
+	Determinism *DeterminismPolicy `json:"determinism,omitempty"`
+	Retention   *RetentionRules    `json:"retention,omitempty"`
+	Coverage    *CoveragePolicy    `json:"coverage,omitempty"`
+}
+
+// sections splits set into one policy record per kind it holds, by kind.
+func (set PolicySet) sections() ([]policyRecord, error) {
+	var out []policyRecord
+	if d := set.Determinism; d != nil {
+		if d.MinScore < 0 || d.MinScore > 100 {
+			return nil, errors.New("determinism.min_score must be between 0 and 100")
+		}
+		out = append(out, policyRecord{Kind: PolicyDeterminism, Determinism: d})
+	}
+	if r := set.Retention; r != nil {
+		for i, rule := range r.Rules {
+			if rule.MaxAge <= 0 && rule.KeepLast <= 0 {
+				return nil, fmt.Errorf("retention.rules[%d]: max_age or keep_last is required", i)
+			}
+		}
+		out = append(out, policyRecord{Kind: PolicyRetention, Retention: r})
+	}
+	if c := set.Coverage; c != nil {
+		for _, kind := range c.Require {
+			if !slices.Contains([]string{"code", "config", "environment", "mutations"}, kind) {
+				return nil, fmt.Errorf("coverage.require: unknown kind %q: use code, config, environment or mutations", kind)
+			}
+		}
+		out = append(out, policyRecord{Kind: PolicyCoverage, Coverage: c})
+	}
+	return out, nil
+}
+
+// SetPolicies appends a new version of each policy in set that differs from
+// the version in force, and returns the versions appended. Applying the
+// same set again appends nothing, so a policy file can be applied on every
+// deploy.
+func (l *Ledger) SetPolicies(set PolicySet) ([]Policy, error) {
+	sections, err := set.sections()
+	if err != nil {
+		return nil, err
+	}
+	if err := l.ensurePolicySchema(); err != nil {
+		return nil, err
+	}
+	current, err := l.PoliciesAt(time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+
+	tx, err := l.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().Unix()
+	var inputs []RecordInput
+	for _, section := range sections {
+		if i := slices.IndexFunc(current, func(p Policy) bool { return p.Kind == section.Kind }); i >= 0 && samePolicy(current[i], section) {
+			continue
+		}
+		if err := tx.QueryRow(`SELECT COALESCE(MAX(version), 0) + 1 FROM ledger_policies WHERE kind = ?`, section.Kind).Scan(&section.Version); err != nil {
+			return nil, err
+		}
+		payload, err := collectors.MarshalPayload(section)
+		if err != nil {
+			return nil, err
+		}
+		inputs = append(inputs, RecordInput{Timestamp: now, Type: PolicyRecordType, Source: section.Kind, Payload: payload})
+	}
+	if len(inputs) == 0 {
+		return []Policy{}, nil
+	}
+
+	records, err := l.appendBatchTx(tx, inputs)
+	if err != nil {
+		return nil, err
+	}
+	policies := make([]Policy, 0, len(records))
+	for _, rec := range records {
+		p, err := policyFromRecord(rec)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(`INSERT INTO ledger_policies(kind, version, record_id, set_at) VALUES(?, ?, ?, ?)`, p.Kind, p.Version, p.RecordID, p.SetAt); err != nil {
+			return nil, err
+		}
+		policies = append(policies, p)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	l.invalidateListCache()
+	l.countAppended(records)
+	l.mirrorAppended(records)
+	l.journalAppended(records)
+	return policies, l.Sync()
+}
+
+// samePolicy reports whether p already holds the rules of section.
+func samePolicy(p Policy, section policyRecord) bool {
+	a, _ := json.Marshal(policyRecord{Kind: p.Kind, Determinism: p.Determinism, Retention: p.Retention, Coverage: p.Coverage})
+	b, _ := json.Marshal(section)
+	return bytes.Equal(a, b)
+}
+
+// PoliciesAt returns the version of each policy kind in force at ts, by
+// kind. Kinds never set are left out.
+func (l *Ledger) PoliciesAt(ts int64) ([]Policy, error) {
+	if err := l.ensurePolicySchema(); err != nil {
+		return nil, err
+	}
+	rows, err := l.db.Query(`SELECT p.record_id FROM ledger_policies p
+		WHERE p.set_at <= ? AND p.version = (
+			SELECT MAX(version) FROM ledger_policies q WHERE q.kind = p.kind AND q.set_at <= ?
+		) ORDER BY p.kind`, ts, ts)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := scanIDs(rows)
+	if err != nil {
+		return nil, err
+	}
+	return l.policiesByRecord(ids)
+}
+
+// Policy returns the version of kind in force at ts, or ErrUnknownPolicy.
+func (l *Ledger) Policy(kind string, ts int64) (Policy, error) {
+	policies, err := l.PoliciesAt(ts)
+	if err != nil {
+		return Policy{}, err
+	}
+	for _, p := range policies {
+		if p.Kind == kind {
+			return p, nil
+		}
+	}
+	return Policy{}, fmt.Errorf("%s: %w", kind, ErrUnknownPolicy)
+}
+
+// PolicyHistory returns every version of a policy kind, oldest first.
+func (l *Ledger) PolicyHistory(kind string) ([]Policy, error) {
+	if err := l.ensurePolicySchema(); err != nil {
+		return nil, err
+	}
+	rows, err := l.db.Query(`SELECT record_id FROM ledger_policies WHERE kind = ? ORDER BY version ASC`, kind)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := scanIDs(rows)
+	if err != nil {
+		return nil, err
+	}
+	return l.policiesByRecord(ids)
+}
+
+func (l *Ledger) policiesByRecord(ids []int64) ([]Policy, error) {
+	policies := make([]Policy, 0, len(ids))
+	for _, id := range ids {
+		rec, err := l.GetByID(id)
+		if err != nil {
+			return nil, err
+		}
+		p, err := policyFromRecord(rec)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, p)
+	}
+	return policies, nil
+}
+
+func scanIDs(rows *sql.Rows) ([]int64, error) {
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func policyFromRecord(rec Record) (Policy, error) {
+	var body policyRecord
+	if err := json.Unmarshal([]byte(rec.Payload), &body); err != nil {
+		return Policy{}, fmt.Errorf("record %d: invalid policy payload: %w", rec.ID, err)
+	}
+	return Policy{
+		Kind:        body.Kind,
+		Version:     body.Version,
+		Determinism: body.Determinism,
+		Retention:   body.Retention,
+		Coverage:    body.Coverage,
+		RecordID:    rec.ID,
+		Hash:        rec.Hash,
+		SetAt:       rec.Timestamp,
+	}, nil
+}
+
+// policiesInForce tracks the policy records met while reading the chain in
+// order, so a reconstruction reports the policies in force at its target
+// time from the same records as its state.
+type policiesInForce map[string]Policy
+
+func (p policiesInForce) add(rec Record) error {
+	policy, err := policyFromRecord(rec)
+	if err != nil {
+		return err
+	}
+	p[policy.Kind] = policy
+	return nil
+}
+
+// list returns the policies by kind.
+func (p policiesInForce) list() []Policy {
+	out := make([]Policy, 0, len(p))
+	for _, policy := range p {
+		out = append(out, policy)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Kind < out[j].Kind })
+	return out
+}
+
+// missingCoverage returns the snapshot kinds of require that coverage
+// lacks.
+func missingCoverage(coverage CoverageReport, require []string) []string {
+	has := map[string]bool{
+		"code":        coverage.HasCode,
+		"config":      coverage.HasConfig,
+		"environment": coverage.HasEnvironment,
+		"mutations":   coverage.HasMutations,
+	}
+	var missing []string
+	for _, kind := range require {
+		if !has[kind] {
+			missing = append(missing, kind)
+		}
+	}
+	return missing
+}
+
+// retentionJSON is RetentionPolicy with durations written as in Go, e.g.
+// "2160h".
+type retentionJSON struct {
+	Type     string `json:"type,omitempty"`
+	MaxAge   string `json:"max_age,omitempty"`
+	KeepLast int    `json:"keep_last,omitempty"`
+}
+
+// MarshalJSON writes MaxAge as a duration string such as "2160h0m0s".
+func (p RetentionPolicy) MarshalJSON() ([]byte, error) {
+	out := retentionJSON{Type: p.Type, KeepLast: p.KeepLast}
+	if p.MaxAge > 0 {
+		out.MaxAge = p.MaxAge.String()
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON reads MaxAge as a duration string such as "2160h".
+func (p *RetentionPolicy) UnmarshalJSON(data []byte) error {
+	var in retentionJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	*p = RetentionPolicy{Type: in.Type, KeepLast: in.KeepLast}
+	if in.MaxAge != "" {
+		d, err := time.ParseDuration(in.MaxAge)
+		if err != nil {
+			return fmt.Errorf("max_age: %w", err)
+		}
+		p.MaxAge = d
+	}
+	return nil
+}
+
+func (l *Ledger) ensurePolicySchema() error {
+	if l.policiesReady.Load() {
+		return nil
+	}
+	if _, err := l.db.Exec(policySchema); err != nil {
+		return err
+	}
+	l.policiesReady.Store(true)
+	return nil
+}
@@ -0,0 +1,74 @@
+package ledger
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+const rateBucketSchema = `
+CREATE TABLE IF NOT EXISTS ledger_rate_buckets (
+	key TEXT PRIMARY KEY,
+	tokens REAL NOT NULL,
+	updated_at INTEGER NOT NULL,
+	rejected INTEGER NOT NULL DEFAULT 0
+);
+`
+
+// takeRateToken refills a bucket for the time since it was last updated,
+// capped at capacity, and spends a token when one is left. Replicas can
+// disagree about the time, so a bucket never refills for negative time.
+// SET expressions see the row as it was, so refill is repeated in each.
+const takeRateToken = `INSERT INTO ledger_rate_buckets(key, tokens, updated_at, rejected) VALUES(?1, ?3 - 1, ?4, 0)
+	ON CONFLICT(key) DO UPDATE SET
+		tokens = CASE WHEN ` + refill + ` >= 1 THEN ` + refill + ` - 1 ELSE ` + refill + ` END,
+		rejected = CASE WHEN ` + refill + ` >= 1 THEN 0 ELSE rejected + 1 END,
+		updated_at = MAX(updated_at, ?4)
+	RETURNING rejected`
+
+const refill = `MIN(?3, tokens + MAX(?4 - updated_at, 0) / 1000.0 * ?2)`
+
+// TakeRateToken spends a token from the token bucket of key, which refills
+// at rate tokens per second up to capacity. allowed reports whether a token
+// was left; exceeded is set on the first rejection since key was last
+// allowed. Buckets live in the ledger database, so they survive restarts
+// and are shared by every replica using it. The update is a single
+// statement, so concurrent replicas never spend the same token.
+func (l *Ledger) TakeRateToken(key string, rate, capacity int, now time.Time) (allowed, exceeded bool, err error) {
+	if strings.TrimSpace(key) == "" {
+		return false, false, errors.New("rate limit key required")
+	}
+	if rate <= 0 || capacity <= 0 {
+		return false, false, errors.New("rate and capacity must be positive")
+	}
+	if err := l.ensureRateBucketSchema(); err != nil {
+		return false, false, err
+	}
+
+	var rejected int64
+	if err := l.db.QueryRow(takeRateToken, key, rate, capacity, now.UnixMilli()).Scan(&rejected); err != nil {
+		return false, false, err
+	}
+	return rejected == 0, rejected == 1, nil
+}
+
+// PruneRateTokens deletes the buckets last used before idleBefore. A bucket
+// idle long enough to refill is the same as no bucket.
+func (l *Ledger) PruneRateTokens(idleBefore time.Time) error {
+	if err := l.ensureRateBucketSchema(); err != nil {
+		return err
+	}
+	_, err := l.db.Exec(`DELETE FROM ledger_rate_buckets WHERE updated_at < ?`, idleBefore.UnixMilli())
+	return err
+}
+
+func (l *Ledger) ensureRateBucketSchema() error {
+	if l.rateBucketsReady.Load() {
+		return nil
+	}
+	if _, err := l.db.Exec(rateBucketSchema); err != nil {
+		return err
+	}
+	l.rateBucketsReady.Store(true)
+	return nil
+}
@@ -0,0 +1,106 @@
+package ledger
+
+import (
+	"maps"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	cacheKeyRecord = "record:"
+	cacheKeyList   = "list:"
+)
+
+// EnableReadCache turns on a read-through cache for GetByID and List.
+// Records are immutable once appended, so cached records stay valid until
+// their TTL expires; cached List results are dropped on every append.
+func (l *Ledger) EnableReadCache(ttl time.Duration) {
+	if l.cache != nil {
+		l.cache.Close()
+	}
+	l.cache = NewCache(ttl)
+}
+
+// CacheStats reports read cache effectiveness. ok is false when the read
+// cache is disabled.
+func (l *Ledger) CacheStats() (CacheStats, bool) {
+	if l.cache == nil {
+		return CacheStats{}, false
+	}
+	return l.cache.Stats(), true
+}
+
+// invalidateListCache drops cached List results after the chain grows.
+func (l *Ledger) invalidateListCache() {
+	l.listGen.Add(1)
+	if l.cache != nil {
+		l.cache.DeletePrefix(cacheKeyList)
+	}
+}
+
+func recordCacheKey(id int64) string {
+	return cacheKeyRecord + strconv.FormatInt(id, 10)
+}
+
+// listCacheKey builds a cache key from a normalized query so equivalent
+// queries share an entry.
+func listCacheKey(q ListQuery) string {
+	buf := make([]byte, 0, 64)
+	buf = append(buf, cacheKeyList...)
+	buf = strconv.AppendInt(buf, q.Since, 10)
+	buf = append(buf, '|')
+	buf = strconv.AppendInt(buf, q.Until, 10)
+	buf = append(buf, '|')
+	buf = strconv.AppendInt(buf, int64(q.Limit), 10)
+	buf = append(buf, '|')
+	buf = strconv.AppendInt(buf, q.AfterID, 10)
+	buf = append(buf, '|')
+	buf = strconv.AppendInt(buf, int64(q.Offset), 10)
+	buf = append(buf, '|')
+	buf = append(buf, q.TraceID...)
+	buf = append(buf, '|')
+	buf = strconv.AppendInt(buf, q.SinceSeq, 10)
+	// Types and sources cannot contain '|', so each list is unambiguous.
+	for _, list := range [][]string{q.Types, q.Sources} {
+		buf = append(buf, '|')
+		buf = strconv.AppendInt(buf, int64(len(list)), 10)
+		for _, v := range list {
+			buf = append(buf, '|')
+			buf = append(buf, v...)
+		}
+	}
+	// Label keys cannot contain '|' or '='; values are length-prefixed,
+	// and the count sets the labels apart from the JSON filters.
+	buf = append(buf, '|')
+	buf = strconv.AppendInt(buf, int64(len(q.Labels)), 10)
+	for _, key := range slices.Sorted(maps.Keys(q.Labels)) {
+		v := q.Labels[key]
+		buf = append(buf, '|')
+		buf = append(buf, key...)
+		buf = append(buf, '=')
+		buf = strconv.AppendInt(buf, int64(len(v)), 10)
+		buf = append(buf, ':')
+		buf = append(buf, v...)
+	}
+	// Paths cannot contain '|' or '='; values are length-prefixed.
+	for _, path := range slices.Sorted(maps.Keys(q.JSONFilters)) {
+		v := q.JSONFilters[path]
+		buf = append(buf, '|')
+		buf = append(buf, path...)
+		buf = append(buf, '=')
+		buf = strconv.AppendInt(buf, int64(len(v)), 10)
+		buf = append(buf, ':')
+		buf = append(buf, v...)
+	}
+	return string(buf)
+}
+
+func copyRecords(in []Record) []Record {
+	if in == nil {
+		return nil
+	}
+	out := make([]Record, len(in))
+	copy(out, in)
+	return out
+}
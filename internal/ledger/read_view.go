@@ -0,0 +1,116 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+)
+
+// ChainHead is the last record of the chain as seen by a read. Records
+// appended after the read started are not part of its result.
+type ChainHead struct {
+	ID   int64  `json:"id"`
+	Hash string `json:"hash"`
+}
+
+// rowQueryer runs the queries of a read on a database, a transaction or,
+// through replicaQueryer, the next read replica.
+type rowQueryer interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// replicaQueryer routes queries through readQuery.
+type replicaQueryer struct{ l *Ledger }
+
+func (r replicaQueryer) Query(query string, args ...any) (*sql.Rows, error) {
+	return r.l.readQuery(query, args...)
+}
+
+// readView is a consistent prefix of the chain, the records up to head,
+// for reads made of several queries. With a WAL journal the queries share
+// a read transaction, a snapshot that also hides records pruned or redacted
+// meanwhile. With a rollback journal a held transaction would block appends
+// for the whole read, so the queries only stop at head.
+type readView struct {
+	q    rowQueryer
+	tx   *sql.Tx
+	head ChainHead
+}
+
+// openReadView starts a view on the next reader, falling back to the
+// primary when the replica fails. The caller must close it. The tables the
+// view joins exist since the ledger was opened or initialized; see
+// upgradeReadSchemas.
+func (l *Ledger) openReadView() (*readView, error) {
+	db := l.reader()
+	v, err := openReadViewOn(db)
+	if err != nil && db != l.db {
+		l.replicas.Load().fallbacks.Add(1)
+		v, err = openReadViewOn(l.db)
+	}
+	return v, err
+}
+
+func openReadViewOn(db *sql.DB) (*readView, error) {
+	tx, err := db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	var mode string
+	if err := tx.QueryRow(`PRAGMA journal_mode`).Scan(&mode); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	v := &readView{q: tx, tx: tx}
+	if !strings.EqualFold(mode, "wal") {
+		tx.Rollback()
+		v.q, v.tx = db, nil
+	}
+
+	head, err := readChainHead(v.q)
+	if err != nil {
+		v.close()
+		return nil, err
+	}
+	v.head = head
+	return v, nil
+}
+
+// readChainHead returns the last local record, or the last archived one
+// when every local record has been pruned.
+func readChainHead(q rowQueryer) (ChainHead, error) {
+	for _, query := range []string{
+		`SELECT id, hash FROM ledger_records ORDER BY id DESC LIMIT 1`,
+		`SELECT last_id, last_hash FROM ledger_archives ORDER BY last_id DESC LIMIT 1`,
+	} {
+		rows, err := q.Query(query)
+		if isMissingTable(err) {
+			continue
+		}
+		if err != nil {
+			return ChainHead{}, err
+		}
+		var head ChainHead
+		found := rows.Next()
+		if found {
+			err = rows.Scan(&head.ID, &head.Hash)
+		}
+		if err == nil {
+			err = rows.Err()
+		}
+		rows.Close()
+		if err != nil {
+			return ChainHead{}, err
+		}
+		if found {
+			return head, nil
+		}
+	}
+	return ChainHead{}, nil
+}
+
+func (v *readView) close() {
+	if v.tx != nil {
+		v.tx.Rollback()
+	}
+}
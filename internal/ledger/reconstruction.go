@@ -34,7 +34,7 @@ func (l *Ledger) ResolveSnapshotAt(ts int64) (Snapshot, error) {
 		WHERE ts <= ? 
 		ORDER BY id DESC
 	`
-	rows, err := l.db.Query(query, ts)
+	rows, err := l.readQuery(query, ts)
 	if err != nil {
 		return Snapshot{}, err
 	}
@@ -46,12 +46,7 @@ func (l *Ledger) ResolveSnapshotAt(ts int64) (Snapshot, error) {
 		MutationRecords: []Record{},
 	}
 
-	for rows.Next() {
-		var rec Record
-		if err := rows.Scan(&rec.ID, &rec.Timestamp, &rec.Type, &rec.Source, &rec.Payload, &rec.Hash, &rec.PrevHash); err != nil {
-			return Snapshot{}, err
-		}
-
+	add := func(rec Record) {
 		snapshot.Records = append(snapshot.Records, rec)
 
 		switch rec.Type {
@@ -72,10 +67,28 @@ func (l *Ledger) ResolveSnapshotAt(ts int64) (Snapshot, error) {
 		}
 	}
 
+	for rows.Next() {
+		var rec Record
+		if err := rows.Scan(&rec.ID, &rec.Timestamp, &rec.Type, &rec.Source, &rec.Payload, &rec.Hash, &rec.PrevHash); err != nil {
+			return Snapshot{}, err
+		}
+		add(rec)
+	}
+
 	if err := rows.Err(); err != nil {
 		return Snapshot{}, err
 	}
 
+	// Archived records are older than every local record, so they follow
+	// in descending id order.
+	archived, err := l.archivedRecords(ListQuery{Until: ts})
+	if err != nil {
+		return Snapshot{}, err
+	}
+	for i := len(archived) - 1; i >= 0; i-- {
+		add(archived[i])
+	}
+
 	if snapshot.ID == 0 && len(snapshot.Records) > 0 {
 		snapshot.ID = snapshot.Records[0].ID
 	}
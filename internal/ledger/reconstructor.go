@@ -4,6 +4,7 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"sort"
 	"strconv"
@@ -43,8 +44,57 @@ type ReconstructionReport struct {
 	DeterminismScore float64        `json:"determinism_score"`
 	Issues           []string       `json:"issues,omitempty"`
 	Proof            *ProofResult   `json:"proof,omitempty"`
-	ReplayPlan       *ReplayPlan    `json:"replay_plan,omitempty"`
-	State            *SnapshotState `json:"state,omitempty"`
+	// Head is the chain head the report was computed against.
+	Head       *ChainHead     `json:"head,omitempty"`
+	ReplayPlan *ReplayPlan    `json:"replay_plan,omitempty"`
+	State      *SnapshotState `json:"state,omitempty"`
+	// Policies are the versions of each policy kind in force at the target
+	// time, read from the same records as the state.
+	Policies []Policy `json:"policies,omitempty"`
+	// Mode is ModeStrict for a report reconstructed in strict mode.
+	Mode string `json:"mode,omitempty"`
+}
+
+// Reconstruction modes.
+const (
+	// ModeLenient reports parse errors, provenance issues and coverage gaps
+	// as issues of a successful report.
+	ModeLenient = "lenient"
+	// ModeStrict fails the report on any of them, for reports and bundles
+	// used as formal evidence.
+	ModeStrict = "strict"
+)
+
+// ErrStrictReconstruction is returned by ExportAuditBundle in strict mode
+// for a reconstruction that has issues.
+var ErrStrictReconstruction = errors.New("reconstruction failed in strict mode")
+
+// ParseMode checks a reconstruction mode, "" meaning ModeLenient.
+func ParseMode(mode string) (string, error) {
+	switch mode {
+	case "", ModeLenient:
+		return ModeLenient, nil
+	case ModeStrict:
+		return ModeStrict, nil
+	}
+	return "", fmt.Errorf("unknown mode %q: use %s or %s", mode, ModeStrict, ModeLenient)
+}
+
+// StrictFailures returns the issues that fail report in strict mode: every
+// issue but the warnings about dimensions not captured, which fail it only
+// when coverage is incomplete. A coverage policy can leave dimensions out.
+func (report ReconstructionReport) StrictFailures() []string {
+	var failures []string
+	for _, issue := range report.Issues {
+		if report.Coverage.Complete && strings.HasPrefix(issue, "warning: ") {
+			continue
+		}
+		failures = append(failures, issue)
+	}
+	if !report.Coverage.Complete && len(failures) == 0 {
+		failures = append(failures, "coverage incomplete")
+	}
+	return failures
 }
 
 type CoverageReport struct {
@@ -53,6 +103,10 @@ type CoverageReport struct {
 	HasEnvironment bool `json:"has_environment"`
 	HasMutations   bool `json:"has_mutations"`
 	Complete       bool `json:"complete"`
+	// Artifacts is set when the ledger has an artifact store to check
+	// referenced artifacts against. Missing or corrupted artifacts make the
+	// coverage incomplete.
+	Artifacts *ArtifactCoverage `json:"artifacts,omitempty"`
 }
 
 type ReplayPlan struct {
@@ -69,6 +123,11 @@ type NamespacePlan struct {
 
 type Reconstructor struct {
 	l *Ledger
+	// Now stamps reports and their proofs. Nil means time.Now; tests set it
+	// to get reproducible reports.
+	Now func() time.Time
+	// Mode is ModeLenient or ModeStrict; empty means lenient.
+	Mode string
 }
 
 func New(l *Ledger) *Reconstructor {
@@ -76,30 +135,36 @@ func New(l *Ledger) *Reconstructor {
 }
 
 func (r *Reconstructor) ReconstructAtTime(targetTime int64) ReconstructionReport {
+	now := time.Now
+	if r.Now != nil {
+		now = r.Now
+	}
 	report := ReconstructionReport{
-		RequestTime: time.Now().Unix(),
+		RequestTime: now().Unix(),
 		TargetTime:  targetTime,
 		Issues:      []string{},
 	}
+	if r.Mode == ModeStrict {
+		report.Mode = ModeStrict
+	}
 
-	recs, err := r.l.List(ListQuery{
-		Since: 0,
-		Until: targetTime,
-		Limit: 10000,
-	})
+	// The records and the proof come from one prefix of the chain, even
+	// while appends are in flight.
+	v, err := r.l.openReadView()
 	if err != nil {
 		report.Issues = append(report.Issues, err.Error())
 		return report
 	}
+	defer v.close()
+	report.Head = &v.head
 
-	if proof, err := r.l.VerifyUpTo(targetTime); err == nil {
+	if proof, err := r.l.verifyUpTo(targetTime, v.head.ID); err == nil {
+		proof.Timestamp = report.RequestTime
 		report.Proof = &proof
 	} else {
 		report.Issues = append(report.Issues, "proof: "+err.Error())
 	}
 
-	report.RecordsMatched = len(recs)
-
 	state := &SnapshotState{
 		Timestamp:       targetTime,
 		Mutations:       []collectors.MutationPayload{},
@@ -107,10 +172,12 @@ func (r *Reconstructor) ReconstructAtTime(targetTime int64) ReconstructionReport
 	}
 
 	coverage := CoverageReport{}
+	policies := policiesInForce{}
 
-	for _, rec := range recs {
+	err = r.l.iterate(v, ListQuery{Until: targetTime}, func(rec Record) error {
+		report.RecordsMatched++
 		if rec.Timestamp > targetTime {
-			continue
+			return nil
 		}
 
 		switch rec.Type {
@@ -118,7 +185,7 @@ func (r *Reconstructor) ReconstructAtTime(targetTime int64) ReconstructionReport
 			var cp collectors.CodePayload
 			if err := collectors.ParseJSON(rec.Payload, &cp); err != nil {
 				report.Issues = append(report.Issues, "code parse error: "+err.Error())
-				continue
+				return nil
 			}
 			state.Code = &cp
 			coverage.HasCode = true
@@ -127,7 +194,7 @@ func (r *Reconstructor) ReconstructAtTime(targetTime int64) ReconstructionReport
 			var cp collectors.ConfigPayload
 			if err := collectors.ParseJSON(rec.Payload, &cp); err != nil {
 				report.Issues = append(report.Issues, "config parse error: "+err.Error())
-				continue
+				return nil
 			}
 			state.Config = &cp
 			coverage.HasConfig = true
@@ -136,32 +203,31 @@ func (r *Reconstructor) ReconstructAtTime(targetTime int64) ReconstructionReport
 			var ep collectors.EnvironmentPayload
 			if err := collectors.ParseJSON(rec.Payload, &ep); err != nil {
 				report.Issues = append(report.Issues, "environment parse error: "+err.Error())
-				continue
+				return nil
 			}
 			state.Environment = &ep
 			coverage.HasEnvironment = true
 
 		case "mutation":
-			var mp collectors.MutationPayload
-			if err := collectors.ParseJSON(rec.Payload, &mp); err != nil {
+			mr, mp, err := parseMutationRecord(rec)
+			if err != nil {
 				report.Issues = append(report.Issues, "mutation parse error: "+err.Error())
-				continue
+				return nil
 			}
-			namespace, offset, _ := parseExternalRef(mp.ExternalRef)
 			state.Mutations = append(state.Mutations, mp)
-			state.MutationRecords = append(state.MutationRecords, MutationRecord{
-				LedgerID:    rec.ID,
-				Timestamp:   rec.Timestamp,
-				Type:        mp.Type,
-				ID:          mp.ID,
-				Source:      mp.Source,
-				Hash:        mp.Hash,
-				ExternalRef: mp.ExternalRef,
-				Namespace:   namespace,
-				Offset:      offset,
-			})
+			state.MutationRecords = append(state.MutationRecords, mr)
 			coverage.HasMutations = len(state.Mutations) > 0
+
+		case PolicyRecordType:
+			if err := policies.add(rec); err != nil {
+				report.Issues = append(report.Issues, "policy parse error: "+err.Error())
+			}
 		}
+		return nil
+	})
+	if err != nil {
+		report.Issues = append(report.Issues, err.Error())
+		return report
 	}
 
 	if len(state.MutationRecords) > 1 {
@@ -169,6 +235,17 @@ func (r *Reconstructor) ReconstructAtTime(targetTime int64) ReconstructionReport
 	}
 
 	coverage.Complete = coverage.HasCode && coverage.HasConfig && coverage.HasEnvironment && coverage.HasMutations
+	if p, ok := policies[PolicyCoverage]; ok {
+		missing := missingCoverage(coverage, p.Coverage.Require)
+		coverage.Complete = len(missing) == 0
+		for _, kind := range missing {
+			report.Issues = append(report.Issues, fmt.Sprintf("policy: %s snapshot required by coverage policy v%d is missing", kind, p.Version))
+		}
+	}
+	if root := r.l.artifactRoot(); root != "" {
+		coverage.Artifacts = checkArtifacts(root, state, &report)
+		coverage.Complete = coverage.Complete && coverage.Artifacts.Available == coverage.Artifacts.Referenced
+	}
 
 	report.Coverage = coverage
 	report.State = state
@@ -179,6 +256,11 @@ func (r *Reconstructor) ReconstructAtTime(targetTime int64) ReconstructionReport
 
 	applyProvenanceChecks(state, &report)
 
+	report.Policies = policies.list()
+	if p, ok := policies[PolicyDeterminism]; ok && report.DeterminismScore < p.Determinism.MinScore {
+		report.Issues = append(report.Issues, fmt.Sprintf("policy: determinism score %.1f is below %.1f required by determinism policy v%d", report.DeterminismScore, p.Determinism.MinScore, p.Version))
+	}
+
 	if !coverage.HasCode {
 		report.Issues = append(report.Issues, "warning: no code snapshot")
 	}
@@ -192,10 +274,26 @@ func (r *Reconstructor) ReconstructAtTime(targetTime int64) ReconstructionReport
 		report.Issues = append(report.Issues, "warning: no mutations recorded")
 	}
 
+	if r.Mode == ModeStrict && len(report.StrictFailures()) > 0 {
+		report.Success = false
+	}
 	return report
 }
 
 func (r *Reconstructor) calculateDeterminismScore(state *SnapshotState, coverage CoverageReport) float64 {
+	missingRef := false
+	for _, m := range state.Mutations {
+		if strings.TrimSpace(m.ExternalRef) == "" {
+			missingRef = true
+			break
+		}
+	}
+	return determinismScore(coverage, state.Environment, missingRef)
+}
+
+// determinismScore scores coverage out of 100, with deductions for a
+// non-system time source and for mutations without an external_ref.
+func determinismScore(coverage CoverageReport, env *collectors.EnvironmentPayload, missingRef bool) float64 {
 	score := 0.0
 
 	if coverage.HasCode {
@@ -211,19 +309,14 @@ func (r *Reconstructor) calculateDeterminismScore(state *SnapshotState, coverage
 		score += 25.0
 	}
 
-	if state.Environment != nil {
-		if state.Environment.TimeSource != "system" {
+	if env != nil {
+		if env.TimeSource != "system" {
 			score -= 5.0
 		}
 	}
 
-	if len(state.Mutations) > 0 {
-		for _, m := range state.Mutations {
-			if strings.TrimSpace(m.ExternalRef) == "" {
-				score -= 2.0
-				break
-			}
-		}
+	if missingRef {
+		score -= 2.0
 	}
 
 	if score < 0 {
@@ -242,6 +335,14 @@ func applyProvenanceChecks(state *SnapshotState, report *ReconstructionReport) {
 		if len(strings.TrimSpace(state.Code.Commit)) < 7 {
 			report.Issues = append(report.Issues, "provenance: code commit hash too short")
 		}
+		for _, lf := range state.Code.LockfileHashes {
+			switch {
+			case lf.Untracked:
+				report.Issues = append(report.Issues, "provenance: lockfile "+lf.Path+" not in commit "+state.Code.Commit)
+			case lf.Committed != "" && lf.Committed != lf.Hash:
+				report.Issues = append(report.Issues, "provenance: lockfile "+lf.Path+" modified locally from commit "+state.Code.Commit)
+			}
+		}
 	}
 
 	if state.Config != nil {
@@ -318,12 +419,24 @@ func orderMutationRecords(records []MutationRecord) {
 	}
 
 	if allNumeric && sameNamespace {
-		sort.Slice(records, func(i, j int) bool {
+		// Sort indices rather than records so each offset stays paired with
+		// its record while elements move.
+		idx := make([]int, len(records))
+		for i := range idx {
+			idx[i] = i
+		}
+		sort.Slice(idx, func(a, b int) bool {
+			i, j := idx[a], idx[b]
 			if parsed[i] == parsed[j] {
 				return records[i].LedgerID < records[j].LedgerID
 			}
 			return parsed[i] < parsed[j]
 		})
+		sorted := make([]MutationRecord, len(records))
+		for k, i := range idx {
+			sorted[k] = records[i]
+		}
+		copy(records, sorted)
 		return
 	}
 
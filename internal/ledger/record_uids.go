@@ -0,0 +1,180 @@
+package ledger
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const recordUIDSchema = `
+CREATE TABLE IF NOT EXISTS ledger_record_uids (
+	record_id INTEGER PRIMARY KEY,
+	uid TEXT NOT NULL UNIQUE
+);
+`
+
+// IDGenerator returns a new globally unique record ID. Record IDs keep
+// records addressable across ledgers whose integer IDs overlap, such as
+// replicas or ledgers merged from several sites.
+type IDGenerator func() (string, error)
+
+// Record ID generators accepted by IDGeneratorByName.
+const (
+	IDGeneratorULID   = "ulid"
+	IDGeneratorUUIDv7 = "uuidv7"
+)
+
+// IDGeneratorByName returns the generator named name, or nil for "".
+func IDGeneratorByName(name string) (IDGenerator, error) {
+	switch strings.ToLower(name) {
+	case "":
+		return nil, nil
+	case IDGeneratorULID:
+		return NewULID, nil
+	case IDGeneratorUUIDv7:
+		return NewUUIDv7, nil
+	default:
+		return nil, fmt.Errorf("unknown record id generator %q (want %s or %s)", name, IDGeneratorULID, IDGeneratorUUIDv7)
+	}
+}
+
+// NewUUIDv7 returns a time-ordered RFC 9562 version 7 UUID.
+func NewUUIDv7() (string, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return "", err
+	}
+	return id.String(), nil
+}
+
+// crockford is the Crockford base32 alphabet used by ULIDs.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// NewULID returns a ULID: a 48-bit millisecond timestamp and 80 random
+// bits, as 26 Crockford base32 characters that sort by time.
+func NewULID() (string, error) {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(time.Now().UnixMilli())<<16)
+	if _, err := rand.Read(b[6:]); err != nil {
+		return "", err
+	}
+	// 128 bits in 26 characters: the first carries the top 3 bits.
+	hi, lo := binary.BigEndian.Uint64(b[:8]), binary.BigEndian.Uint64(b[8:])
+	out := make([]byte, 26)
+	for i := 25; i >= 0; i-- {
+		out[i] = crockford[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out), nil
+}
+
+// IsRecordUID reports whether s has the form of a generated record ID: a
+// ULID or a UUID.
+func IsRecordUID(s string) bool {
+	if len(s) == 26 {
+		return strings.Trim(strings.ToUpper(s), crockford) == "" && s[0] <= '7'
+	}
+	_, err := uuid.Parse(s)
+	return err == nil && len(s) == 36
+}
+
+// SetIDGenerator makes every later append also give the record a globally
+// unique ID from gen, stored beside the integer ID and returned as UID. A
+// nil gen stops generating them; records keep the IDs they have.
+func (l *Ledger) SetIDGenerator(gen IDGenerator) error {
+	if gen == nil {
+		l.uidGen.Store(nil)
+		return nil
+	}
+	if err := l.ensureUIDSchema(); err != nil {
+		return err
+	}
+	l.uidGen.Store(&gen)
+	return nil
+}
+
+// assignUID gives rec a generated ID within tx when a generator is set.
+func (l *Ledger) assignUID(tx *sql.Tx, rec *Record) error {
+	gen := l.uidGen.Load()
+	if gen == nil {
+		return nil
+	}
+	uid, err := (*gen)()
+	if err != nil {
+		return fmt.Errorf("generate record id: %w", err)
+	}
+	if _, err := tx.Exec(`INSERT INTO ledger_record_uids(record_id, uid) VALUES(?, ?)`, rec.ID, uid); err != nil {
+		return err
+	}
+	rec.UID = uid
+	return nil
+}
+
+// GetByUID returns the record with the globally unique ID uid, or
+// sql.ErrNoRows.
+func (l *Ledger) GetByUID(uid string) (Record, error) {
+	var id int64
+	err := l.db.QueryRow(`SELECT record_id FROM ledger_record_uids WHERE uid = ?`, uid).Scan(&id)
+	if isMissingTable(err) {
+		return Record{}, sql.ErrNoRows
+	}
+	if err != nil {
+		return Record{}, err
+	}
+	return l.GetByID(id)
+}
+
+// attachUIDs fills in the generated ID of each record that has one.
+func (l *Ledger) attachUIDs(q rowQueryer, records []Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+	if err := l.ensureUIDSchema(); err != nil {
+		return err
+	}
+	lo, hi := records[0].ID, records[0].ID
+	for _, rec := range records {
+		lo, hi = min(lo, rec.ID), max(hi, rec.ID)
+	}
+	rows, err := q.Query(`SELECT record_id, uid FROM ledger_record_uids WHERE record_id BETWEEN ? AND ?`, lo, hi)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	uids := map[int64]string{}
+	for rows.Next() {
+		var id int64
+		var uid string
+		if err := rows.Scan(&id, &uid); err != nil {
+			return err
+		}
+		uids[id] = uid
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for i := range records {
+		if uid, ok := uids[records[i].ID]; ok {
+			records[i].UID = uid
+		}
+	}
+	return nil
+}
+
+func (l *Ledger) ensureUIDSchema() error {
+	if l.uidsReady.Load() {
+		return nil
+	}
+	if _, err := l.db.Exec(recordUIDSchema); err != nil {
+		return err
+	}
+	l.uidsReady.Store(true)
+	return nil
+}
@@ -0,0 +1,247 @@
+package ledger
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// DefaultMaxTornTail is the most records Recover removes unless told
+// otherwise. A longer run of unverifiable records is more likely tampering
+// or corruption in the middle of the chain than an interrupted write.
+const DefaultMaxTornTail = 100
+
+// ErrRecoveryRefused is returned when Recover finds damage it will not
+// repair by truncating the chain.
+var ErrRecoveryRefused = errors.New("recovery refused")
+
+// RecoverOptions configures Recover.
+type RecoverOptions struct {
+	// Apply removes the torn tail; without it Recover only reports.
+	Apply bool
+	// MaxTail is the most records Recover may remove. Defaults to
+	// DefaultMaxTornTail.
+	MaxTail int
+	// QuarantinePath receives the removed records as JSON lines before
+	// they are deleted. Required with Apply.
+	QuarantinePath string
+}
+
+// RecoveryReport describes the state of a ledger after a crash.
+type RecoveryReport struct {
+	// IntegrityOK is the result of SQLite's integrity check.
+	IntegrityOK bool     `json:"integrity_ok"`
+	Integrity   []string `json:"integrity,omitempty"`
+	// LastDurableID and LastDurableHash identify the last record whose
+	// chain verifies; it is 0 when no live record verifies.
+	LastDurableID   int64  `json:"last_durable_id"`
+	LastDurableHash string `json:"last_durable_hash,omitempty"`
+	Verified        int64  `json:"verified"`
+	// TornTail counts the records from FirstTornID on that do not verify.
+	TornTail    int64  `json:"torn_tail"`
+	FirstTornID int64  `json:"first_torn_id,omitempty"`
+	Reason      string `json:"reason,omitempty"`
+	Removed     int64  `json:"removed"`
+	Quarantine  string `json:"quarantine,omitempty"`
+}
+
+// Clean reports whether the ledger needs no recovery.
+func (r RecoveryReport) Clean() bool {
+	return r.IntegrityOK && r.TornTail == 0
+}
+
+// Recover checks the database file and the hash chain after a crash and
+// reports the last durable record. With opts.Apply it rolls back a torn
+// tail: the records from the first one that fails verification to the end
+// of the chain are written to opts.QuarantinePath and deleted, along with
+// their attribution and idempotency keys. It refuses when the database
+// file is corrupt, the failure lies in archived records, or the tail is
+// longer than opts.MaxTail.
+func (l *Ledger) Recover(opts RecoverOptions) (RecoveryReport, error) {
+	if opts.MaxTail <= 0 {
+		opts.MaxTail = DefaultMaxTornTail
+	}
+	if opts.Apply && opts.QuarantinePath == "" {
+		return RecoveryReport{}, errors.New("quarantine path required to remove records")
+	}
+
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+
+	var report RecoveryReport
+	integrity, err := l.integrityCheck()
+	if err != nil {
+		return report, err
+	}
+	report.IntegrityOK = len(integrity) == 0
+	report.Integrity = integrity
+
+	verify, err := l.VerifyChain()
+	if err != nil {
+		return report, err
+	}
+	report.Verified = verify.Checked
+	if verify.OK {
+		if err := l.lastDurable(&report, -1); err != nil {
+			return report, err
+		}
+		if !report.IntegrityOK {
+			return report, fmt.Errorf("%w: database file is corrupt; rebuild it from a journal or backup", ErrRecoveryRefused)
+		}
+		return report, nil
+	}
+
+	report.FirstTornID = verify.FailedID
+	report.Reason = verify.Reason
+	var minLive int64
+	if err := l.db.QueryRow(`SELECT COALESCE(MIN(id), 0) FROM ledger_records`).Scan(&minLive); err != nil {
+		return report, err
+	}
+	if minLive == 0 || verify.FailedID < minLive {
+		return report, fmt.Errorf("%w: archived record %d fails verification", ErrRecoveryRefused, verify.FailedID)
+	}
+	if err := l.db.QueryRow(`SELECT COUNT(*) FROM ledger_records WHERE id >= ?`, verify.FailedID).Scan(&report.TornTail); err != nil {
+		return report, err
+	}
+	if err := l.lastDurable(&report, verify.FailedID); err != nil {
+		return report, err
+	}
+
+	if !report.IntegrityOK {
+		return report, fmt.Errorf("%w: database file is corrupt; rebuild it from a journal or backup", ErrRecoveryRefused)
+	}
+	if report.TornTail > int64(opts.MaxTail) {
+		return report, fmt.Errorf("%w: %d records fail verification from record %d, more than the %d a torn write leaves", ErrRecoveryRefused, report.TornTail, verify.FailedID, opts.MaxTail)
+	}
+	if !opts.Apply {
+		return report, nil
+	}
+
+	if err := l.quarantine(opts.QuarantinePath, verify.FailedID); err != nil {
+		return report, err
+	}
+	report.Quarantine = opts.QuarantinePath
+	if report.Removed, err = l.removeTail(verify.FailedID); err != nil {
+		return report, err
+	}
+	l.invalidateListCache()
+	if l.cache != nil {
+		l.cache.DeletePrefix(cacheKeyRecord)
+	}
+	return report, nil
+}
+
+// integrityCheck returns SQLite's integrity check findings; none means the
+// file is sound.
+func (l *Ledger) integrityCheck() ([]string, error) {
+	rows, err := l.db.Query(`PRAGMA integrity_check`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var msg string
+		if err := rows.Scan(&msg); err != nil {
+			return nil, err
+		}
+		if msg != "ok" {
+			problems = append(problems, msg)
+		}
+	}
+	return problems, rows.Err()
+}
+
+// lastDurable fills in the last live record before id, or the last live
+// record when id is negative.
+func (l *Ledger) lastDurable(report *RecoveryReport, before int64) error {
+	query := `SELECT id, hash FROM ledger_records ORDER BY id DESC LIMIT 1`
+	args := []interface{}{}
+	if before >= 0 {
+		query = `SELECT id, hash FROM ledger_records WHERE id < ? ORDER BY id DESC LIMIT 1`
+		args = append(args, before)
+	}
+	err := l.db.QueryRow(query, args...).Scan(&report.LastDurableID, &report.LastDurableHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		report.LastDurableHash, err = archiveTip(l.db)
+	}
+	return err
+}
+
+// quarantine writes the records from id on to path as JSON lines.
+func (l *Ledger) quarantine(path string, from int64) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	rows, err := l.db.Query(`SELECT id, ts, type, source, payload, hash, prev_hash FROM ledger_records WHERE id >= ? ORDER BY id ASC`, from)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	defer rows.Close()
+
+	enc := json.NewEncoder(f)
+	for rows.Next() {
+		var rec Record
+		if err := rows.Scan(&rec.ID, &rec.Timestamp, &rec.Type, &rec.Source, &rec.Payload, &rec.Hash, &rec.PrevHash); err != nil {
+			f.Close()
+			return err
+		}
+		if err := enc.Encode(rec); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// removeTail deletes the records from id on and the rows that refer to
+// them.
+func (l *Ledger) removeTail(from int64) (int64, error) {
+	tx, err := l.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var removed int64
+	err = withRecordsUnlocked(tx, func() error {
+		res, err := tx.Exec(`DELETE FROM ledger_records WHERE id >= ?`, from)
+		if err != nil {
+			return err
+		}
+		removed, err = res.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, table := range []string{"ledger_idempotency", "ledger_record_agents", "ledger_agent_proofs", "ledger_record_signatures", "ledger_record_labels"} {
+		var n int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, table).Scan(&n); err != nil {
+			return 0, err
+		}
+		if n == 0 {
+			continue
+		}
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE record_id >= ?`, from); err != nil {
+			return 0, err
+		}
+	}
+	if err := rewindSeqs(tx, from); err != nil {
+		return 0, err
+	}
+	return removed, tx.Commit()
+}
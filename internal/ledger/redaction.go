@@ -0,0 +1,278 @@
+package ledger
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Retr0-XD/StateLedger/internal/collectors"
+)
+
+const redactionSchema = `
+CREATE TABLE IF NOT EXISTS ledger_redactions (
+	record_id INTEGER PRIMARY KEY,
+	payload_hash TEXT NOT NULL,
+	reason TEXT NOT NULL,
+	redaction_id INTEGER NOT NULL,
+	redacted_at INTEGER NOT NULL
+);
+`
+
+// RedactionRecordType is the type of the record appended by Redact, with
+// the ledger as source. Its payload names the redacted record, its payload
+// hash and the reason.
+const RedactionRecordType = "record.redacted"
+
+// ErrNotRedactable is returned by Redact for records it cannot blank.
+var ErrNotRedactable = errors.New("record cannot be redacted")
+
+// Redaction describes a payload blanked by Redact. The record hash commits
+// to PayloadHash rather than to the payload, so the record still verifies.
+type Redaction struct {
+	PayloadHash string `json:"payload_hash"`
+	Reason      string `json:"reason,omitempty"`
+	// RedactionID is the record that logged the redaction.
+	RedactionID int64 `json:"redaction_id,omitempty"`
+	RedactedAt  int64 `json:"redacted_at,omitempty"`
+}
+
+type redactionRecord struct {
+	RecordID    int64  `json:"record_id"`
+	PayloadHash string `json:"payload_hash"`
+	Reason      string `json:"reason"`
+}
+
+// Redact blanks the payload of the local record id, for example to erase
+// personal data, and appends a redaction record naming it, in one
+// transaction. The record keeps its hash, and the payload hash it commits
+// to is stored in its place, so VerifyChain still passes. Redact refuses,
+// with ErrNotRedactable, records hashed before payload commitments,
+// archived records, records under an active legal hold and records of the
+// types the ledger refers to by ID. Copies already written to journals,
+// mirrors, archives and exports are not touched.
+func (l *Ledger) Redact(id int64, reason string) (Record, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return Record{}, errors.New("redaction reason required")
+	}
+	if err := l.ensureRedactionSchema(); err != nil {
+		return Record{}, err
+	}
+	if err := l.ensureSignatureSchema(); err != nil {
+		return Record{}, err
+	}
+	if err := l.ensureLabelSchema(); err != nil {
+		return Record{}, err
+	}
+
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+
+	holds, err := l.activeHolds()
+	if err != nil {
+		return Record{}, err
+	}
+
+	tx, err := l.db.Begin()
+	if err != nil {
+		return Record{}, err
+	}
+	defer tx.Rollback()
+
+	var rec Record
+	var redacted bool
+	var labels string
+	err = tx.QueryRow(`SELECT r.id, r.ts, r.type, r.source, r.payload, r.hash, r.prev_hash, x.record_id IS NOT NULL, `+labelsColumn+`
+		FROM ledger_records r LEFT JOIN ledger_redactions x ON x.record_id = r.id WHERE r.id = ?`, id).
+		Scan(&rec.ID, &rec.Timestamp, &rec.Type, &rec.Source, &rec.Payload, &rec.Hash, &rec.PrevHash, &redacted, &labels)
+	if err == nil {
+		rec.Labels, err = parseLabels(labels)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		if _, archived, archErr := l.archivedRecord(id); archErr == nil && archived {
+			return Record{}, fmt.Errorf("%w: record %d is archived", ErrNotRedactable, id)
+		}
+	}
+	if err != nil {
+		return Record{}, err
+	}
+	switch {
+	case redacted:
+		return Record{}, fmt.Errorf("%w: record %d is already redacted", ErrNotRedactable, id)
+	case slices.Contains(referencedTypes, rec.Type):
+		return Record{}, fmt.Errorf("%w: %s records are referenced by the ledger", ErrNotRedactable, rec.Type)
+	case rec.Hash != labeledHash(rec.PrevHash, rec.Timestamp, rec.Type, rec.Source, rec.Payload, rec.Labels):
+		return Record{}, fmt.Errorf("%w: record %d was hashed over its raw payload", ErrNotRedactable, id)
+	}
+	if h, held := holdCovering(holds, rec.Timestamp); held {
+		return Record{}, fmt.Errorf("%w: record %d is under legal hold %s", ErrNotRedactable, id, h.Case)
+	}
+
+	now := time.Now().Unix()
+	redaction := Redaction{PayloadHash: PayloadHash(rec.Payload), Reason: reason, RedactedAt: now}
+	payload, err := collectors.MarshalPayload(redactionRecord{RecordID: id, PayloadHash: redaction.PayloadHash, Reason: reason})
+	if err != nil {
+		return Record{}, err
+	}
+	records, err := l.appendBatchTx(tx, []RecordInput{{
+		Timestamp: now,
+		Type:      RedactionRecordType,
+		Source:    "ledger",
+		Payload:   payload,
+	}})
+	if err != nil {
+		return Record{}, err
+	}
+	redaction.RedactionID = records[0].ID
+
+	// secure_delete overwrites the freed payload bytes instead of leaving
+	// them in free pages of the database file.
+	if _, err := tx.Exec(`PRAGMA secure_delete = ON`); err != nil {
+		return Record{}, err
+	}
+	err = withRecordsUnlocked(tx, func() error {
+		_, err := tx.Exec(`UPDATE ledger_records SET payload = '' WHERE id = ?`, id)
+		return err
+	})
+	if err != nil {
+		return Record{}, err
+	}
+	if _, err := tx.Exec(`INSERT INTO ledger_redactions(record_id, payload_hash, reason, redaction_id, redacted_at) VALUES(?, ?, ?, ?, ?)`,
+		id, redaction.PayloadHash, redaction.Reason, redaction.RedactionID, redaction.RedactedAt); err != nil {
+		return Record{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Record{}, err
+	}
+
+	if l.cache != nil {
+		l.cache.Delete(recordCacheKey(id))
+	}
+	l.invalidateListCache()
+	l.countAppended(records)
+	l.mirrorAppended(records)
+	l.journalAppended(records)
+	if err := l.Sync(); err != nil {
+		return Record{}, err
+	}
+
+	rec.Payload = ""
+	rec.Redaction = &redaction
+	return rec, nil
+}
+
+// attachRedactions fills in the redaction of each redacted record.
+// Archived records carry theirs in the segment and are left as they are.
+func (l *Ledger) attachRedactions(q rowQueryer, records []Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+	if err := l.ensureRedactionSchema(); err != nil {
+		return err
+	}
+	lo, hi := records[0].ID, records[0].ID
+	for _, rec := range records {
+		lo, hi = min(lo, rec.ID), max(hi, rec.ID)
+	}
+	rows, err := q.Query(`SELECT record_id, payload_hash, reason, redaction_id, redacted_at FROM ledger_redactions WHERE record_id BETWEEN ? AND ?`, lo, hi)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	redactions := map[int64]Redaction{}
+	for rows.Next() {
+		var id int64
+		var r Redaction
+		if err := rows.Scan(&id, &r.PayloadHash, &r.Reason, &r.RedactionID, &r.RedactedAt); err != nil {
+			return err
+		}
+		redactions[id] = r
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for i := range records {
+		if r, ok := redactions[records[i].ID]; ok {
+			records[i].Redaction = &r
+		}
+	}
+	return nil
+}
+
+// redactionProblem reports why a redacted record read for verification is
+// not as Redact left it: with a blank payload, logged by a later record.
+// Its hash only commits to the payload hash, so a payload written back
+// into the row would otherwise go unnoticed.
+func redactionProblem(rec Record) string {
+	switch {
+	case rec.Redaction == nil:
+		return ""
+	case rec.Payload != "":
+		return "payload present on redacted record"
+	case rec.Redaction.RedactionID <= rec.ID:
+		return fmt.Sprintf("redaction record %d does not follow the redacted record", rec.Redaction.RedactionID)
+	}
+	return ""
+}
+
+// checkRedactions confirms that every redaction is logged by its
+// redaction record. It returns the first redacted record that is not and
+// why.
+func (l *Ledger) checkRedactions() (int64, string, error) {
+	rows, err := l.db.Query(`SELECT record_id, payload_hash, redaction_id FROM ledger_redactions ORDER BY record_id ASC`)
+	if err != nil {
+		if isMissingTable(err) {
+			return 0, "", nil
+		}
+		return 0, "", err
+	}
+	type redacted struct {
+		id, redactionID int64
+		payloadHash     string
+	}
+	var all []redacted
+	for rows.Next() {
+		var r redacted
+		if err := rows.Scan(&r.id, &r.payloadHash, &r.redactionID); err != nil {
+			rows.Close()
+			return 0, "", err
+		}
+		all = append(all, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, "", err
+	}
+
+	for _, r := range all {
+		rec, err := l.GetByID(r.redactionID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return r.id, fmt.Sprintf("redaction record %d missing", r.redactionID), nil
+		}
+		if err != nil {
+			return 0, "", err
+		}
+		var logged redactionRecord
+		if rec.Type != RedactionRecordType || json.Unmarshal([]byte(rec.Payload), &logged) != nil ||
+			logged.RecordID != r.id || logged.PayloadHash != r.payloadHash {
+			return r.id, fmt.Sprintf("redaction not logged by record %d", r.redactionID), nil
+		}
+	}
+	return 0, "", nil
+}
+
+func (l *Ledger) ensureRedactionSchema() error {
+	if l.redactionsReady.Load() {
+		return nil
+	}
+	if _, err := l.db.Exec(redactionSchema); err != nil {
+		return err
+	}
+	l.redactionsReady.Store(true)
+	return nil
+}
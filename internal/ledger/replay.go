@@ -0,0 +1,251 @@
+package ledger
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const replaySchema = `
+CREATE TABLE IF NOT EXISTS ledger_replay_progress (
+	replay TEXT NOT NULL,
+	namespace TEXT NOT NULL,
+	last_ledger_id INTEGER NOT NULL,
+	last_external_ref TEXT NOT NULL DEFAULT '',
+	applied INTEGER NOT NULL,
+	target_time INTEGER NOT NULL,
+	updated_at INTEGER NOT NULL,
+	PRIMARY KEY (replay, namespace)
+);
+`
+
+// DefaultReplayName names the progress of replays run without a name.
+const DefaultReplayName = "default"
+
+// ErrResumePosition is returned when a replay's stored position or resume
+// token names a mutation that is not in the replay plan, for example after
+// resuming against a different target time.
+var ErrResumePosition = errors.New("resume position not in replay plan")
+
+// ReplayProgress is the high-water mark of one namespace of a replay: the
+// last mutation applied and committed.
+type ReplayProgress struct {
+	Replay          string `json:"replay"`
+	Namespace       string `json:"namespace"`
+	LastLedgerID    int64  `json:"last_ledger_id"`
+	LastExternalRef string `json:"last_external_ref,omitempty"`
+	// Applied counts the mutations applied so far, across resumes.
+	Applied    int64  `json:"applied"`
+	TargetTime int64  `json:"target_time"`
+	UpdatedAt  int64  `json:"updated_at"`
+	Token      string `json:"resume_token"`
+}
+
+type resumeToken struct {
+	Replay       string `json:"r"`
+	Namespace    string `json:"n"`
+	LastLedgerID int64  `json:"id"`
+}
+
+func (p *ReplayProgress) setToken() {
+	data, _ := json.Marshal(resumeToken{Replay: p.Replay, Namespace: p.Namespace, LastLedgerID: p.LastLedgerID})
+	p.Token = base64.RawURLEncoding.EncodeToString(data)
+}
+
+// ParseResumeToken decodes a resume token from ReplayProgress into the
+// position it names.
+func ParseResumeToken(token string) (ReplayProgress, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return ReplayProgress{}, fmt.Errorf("invalid resume token: %w", err)
+	}
+	var t resumeToken
+	if err := json.Unmarshal(data, &t); err != nil || t.Namespace == "" || t.LastLedgerID <= 0 {
+		return ReplayProgress{}, errors.New("invalid resume token")
+	}
+	p := ReplayProgress{Replay: t.Replay, Namespace: t.Namespace, LastLedgerID: t.LastLedgerID}
+	p.setToken()
+	return p, nil
+}
+
+// ReplayProgress returns the stored positions of a replay, or of all
+// replays when replay is empty, sorted by replay and namespace.
+func (l *Ledger) ReplayProgress(replay string) ([]ReplayProgress, error) {
+	if _, err := l.db.Exec(replaySchema); err != nil {
+		return nil, err
+	}
+	rows, err := l.db.Query(`SELECT replay, namespace, last_ledger_id, last_external_ref, applied, target_time, updated_at
+		FROM ledger_replay_progress WHERE ? = '' OR replay = ? ORDER BY replay, namespace`, replay, replay)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	progress := []ReplayProgress{}
+	for rows.Next() {
+		var p ReplayProgress
+		if err := rows.Scan(&p.Replay, &p.Namespace, &p.LastLedgerID, &p.LastExternalRef, &p.Applied, &p.TargetTime, &p.UpdatedAt); err != nil {
+			return nil, err
+		}
+		p.setToken()
+		progress = append(progress, p)
+	}
+	return progress, rows.Err()
+}
+
+// ResetReplayProgress forgets the position of namespace in a replay, or of
+// every namespace when namespace is empty, so the next run starts over.
+func (l *Ledger) ResetReplayProgress(replay, namespace string) error {
+	if _, err := l.db.Exec(replaySchema); err != nil {
+		return err
+	}
+	_, err := l.db.Exec(`DELETE FROM ledger_replay_progress WHERE replay = ? AND (? = '' OR namespace = ?)`, replay, namespace, namespace)
+	return err
+}
+
+func (l *Ledger) commitReplayProgress(p ReplayProgress) error {
+	_, err := l.db.Exec(`INSERT INTO ledger_replay_progress(replay, namespace, last_ledger_id, last_external_ref, applied, target_time, updated_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(replay, namespace) DO UPDATE SET last_ledger_id = excluded.last_ledger_id,
+			last_external_ref = excluded.last_external_ref, applied = excluded.applied,
+			target_time = excluded.target_time, updated_at = excluded.updated_at`,
+		p.Replay, p.Namespace, p.LastLedgerID, p.LastExternalRef, p.Applied, p.TargetTime, p.UpdatedAt)
+	return err
+}
+
+// ReplayExecutor applies the mutations of the replay plan at a target time
+// in plan order. The position of each namespace is committed after every
+// applied mutation, so a replay that is interrupted or fails resumes after
+// the last mutation it applied instead of starting over.
+type ReplayExecutor struct {
+	Ledger *Ledger
+	// Name identifies the replay's stored progress, so replays to different
+	// targets resume independently. Defaults to DefaultReplayName.
+	Name string
+	// Apply applies one mutation. rec is the full ledger record.
+	Apply func(ctx context.Context, m MutationRecord, rec Record) error
+	// Namespaces limits the replay to these namespaces. Empty replays all.
+	Namespaces []string
+	// FromStart ignores stored progress and replays every mutation.
+	FromStart bool
+	// Resume positions override the stored progress of their namespaces.
+	Resume []ReplayProgress
+}
+
+// ReplayNamespaceResult reports one namespace of a replay run.
+type ReplayNamespaceResult struct {
+	Namespace string `json:"namespace"`
+	Total     int    `json:"total"`
+	// Skipped mutations were applied by an earlier run.
+	Skipped  int            `json:"skipped"`
+	Applied  int            `json:"applied"`
+	Progress ReplayProgress `json:"progress"`
+}
+
+// ReplayResult reports a replay run.
+type ReplayResult struct {
+	Replay     string                  `json:"replay"`
+	TargetTime int64                   `json:"target_time"`
+	Namespaces []ReplayNamespaceResult `json:"namespaces"`
+}
+
+// Run replays the plan at targetTime. It stops at the first mutation Apply
+// fails on, or when ctx is done, returning the result so far.
+func (e *ReplayExecutor) Run(ctx context.Context, targetTime int64) (ReplayResult, error) {
+	name := e.Name
+	if name == "" {
+		name = DefaultReplayName
+	}
+	result := ReplayResult{Replay: name, TargetTime: targetTime, Namespaces: []ReplayNamespaceResult{}}
+	if e.Apply == nil {
+		return result, errors.New("replay executor has no Apply function")
+	}
+
+	positions := map[string]ReplayProgress{}
+	if !e.FromStart {
+		stored, err := e.Ledger.ReplayProgress(name)
+		if err != nil {
+			return result, err
+		}
+		for _, p := range stored {
+			positions[p.Namespace] = p
+		}
+	} else if _, err := e.Ledger.db.Exec(replaySchema); err != nil {
+		return result, err
+	}
+	for _, p := range e.Resume {
+		if p.Replay != "" && p.Replay != name {
+			return result, fmt.Errorf("resume token is for replay %s, not %s", p.Replay, name)
+		}
+		stored := positions[p.Namespace]
+		stored.Namespace, stored.LastLedgerID = p.Namespace, p.LastLedgerID
+		positions[p.Namespace] = stored
+	}
+	only := map[string]bool{}
+	for _, ns := range e.Namespaces {
+		only[ns] = true
+	}
+
+	report := New(e.Ledger).ReconstructAtTime(targetTime)
+	if !report.Success {
+		return result, fmt.Errorf("reconstruct at %d: %v", targetTime, report.Issues)
+	}
+	if report.ReplayPlan == nil {
+		return result, nil
+	}
+	for _, plan := range report.ReplayPlan.Namespaces {
+		if len(only) > 0 && !only[plan.Namespace] {
+			continue
+		}
+		nr := ReplayNamespaceResult{Namespace: plan.Namespace, Total: plan.Count}
+		progress := ReplayProgress{Replay: name, Namespace: plan.Namespace, TargetTime: targetTime}
+		if pos, ok := positions[plan.Namespace]; ok {
+			found := false
+			for i, m := range plan.Records {
+				if m.LedgerID == pos.LastLedgerID {
+					nr.Skipped, found = i+1, true
+					break
+				}
+			}
+			if !found {
+				return result, fmt.Errorf("namespace %s: %w: ledger id %d", plan.Namespace, ErrResumePosition, pos.LastLedgerID)
+			}
+			progress = pos
+			progress.Replay, progress.TargetTime = name, targetTime
+		}
+
+		for _, m := range plan.Records[nr.Skipped:] {
+			if err := ctx.Err(); err != nil {
+				result.Namespaces = append(result.Namespaces, nr)
+				return result, err
+			}
+			rec, err := e.Ledger.GetByID(m.LedgerID)
+			if err == nil {
+				err = e.Apply(ctx, m, rec)
+			}
+			if err != nil {
+				result.Namespaces = append(result.Namespaces, nr)
+				return result, fmt.Errorf("namespace %s: mutation %d (%s): %w", plan.Namespace, m.LedgerID, m.ExternalRef, err)
+			}
+			progress.LastLedgerID, progress.LastExternalRef = m.LedgerID, m.ExternalRef
+			progress.Applied++
+			progress.UpdatedAt = time.Now().Unix()
+			if err := e.Ledger.commitReplayProgress(progress); err != nil {
+				result.Namespaces = append(result.Namespaces, nr)
+				return result, err
+			}
+			nr.Applied++
+			nr.Progress = progress
+			nr.Progress.setToken()
+		}
+		if nr.Applied == 0 {
+			nr.Progress = progress
+			nr.Progress.setToken()
+		}
+		result.Namespaces = append(result.Namespaces, nr)
+	}
+	return result, nil
+}
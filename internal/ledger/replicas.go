@@ -0,0 +1,121 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// readReplicas spreads read queries across read-only copies of the ledger
+// database.
+type readReplicas struct {
+	dbs  []*sql.DB
+	next atomic.Uint64
+	// fallbacks counts replica queries that failed and were retried on the
+	// primary.
+	fallbacks atomic.Int64
+}
+
+// ReplicaStats reports how reads were routed.
+type ReplicaStats struct {
+	Replicas  int   `json:"replicas"`
+	Fallbacks int64 `json:"fallbacks"`
+}
+
+// SetReadReplicas routes List, GetByID, snapshot and latest-state reads to
+// the databases at dsns in turn, while appends and verification stay on
+// the primary. A DSN is a SQLite path or file: URI, typically a read-only
+// copy kept up to date by replication, e.g. "file:/replica/ledger.db?mode=ro".
+// A read that fails on a replica is retried on the primary, as is a lookup
+// by ID that the replica has not caught up with yet.
+func (l *Ledger) SetReadReplicas(dsns ...string) error {
+	replicas := &readReplicas{}
+	for _, dsn := range dsns {
+		db, err := sql.Open("sqlite", dsn)
+		if err != nil {
+			replicas.close()
+			return err
+		}
+		db.SetMaxOpenConns(25)
+		db.SetMaxIdleConns(5)
+		db.SetConnMaxLifetime(5 * time.Minute)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		err = db.PingContext(ctx)
+		cancel()
+		if err != nil {
+			_ = db.Close()
+			replicas.close()
+			return fmt.Errorf("read replica %s: %w", dsn, err)
+		}
+		replicas.dbs = append(replicas.dbs, db)
+	}
+
+	if old := l.replicas.Swap(replicas); old != nil {
+		old.close()
+	}
+	return nil
+}
+
+// ReplicaStats reports the configured read replicas and how often reads fell
+// back to the primary.
+func (l *Ledger) ReplicaStats() ReplicaStats {
+	r := l.replicas.Load()
+	if r == nil {
+		return ReplicaStats{}
+	}
+	return ReplicaStats{Replicas: len(r.dbs), Fallbacks: r.fallbacks.Load()}
+}
+
+// reader returns the next replica, or the primary when no replica is
+// configured. Reads that must see one consistent state use a single reader
+// for all of their queries.
+func (l *Ledger) reader() *sql.DB {
+	r := l.replicas.Load()
+	if r == nil || len(r.dbs) == 0 {
+		return l.db
+	}
+	return r.pick()
+}
+
+// readQuery runs a read on the next replica, or on the primary when no
+// replica is configured or the replica fails.
+func (l *Ledger) readQuery(query string, args ...any) (*sql.Rows, error) {
+	r := l.replicas.Load()
+	if r == nil || len(r.dbs) == 0 {
+		return l.db.Query(query, args...)
+	}
+	rows, err := r.pick().Query(query, args...)
+	if err == nil {
+		return rows, nil
+	}
+	r.fallbacks.Add(1)
+	return l.db.Query(query, args...)
+}
+
+// readRecord scans a single record read from the next replica, retrying on
+// the primary if the replica fails or does not have it yet.
+func (l *Ledger) readRecord(query string, args ...any) (Record, error) {
+	r := l.replicas.Load()
+	if r == nil || len(r.dbs) == 0 {
+		return scanRecord(l.db.QueryRow(query, args...))
+	}
+	rec, err := scanRecord(r.pick().QueryRow(query, args...))
+	if err == nil {
+		return rec, nil
+	}
+	r.fallbacks.Add(1)
+	return scanRecord(l.db.QueryRow(query, args...))
+}
+
+func (r *readReplicas) pick() *sql.DB {
+	return r.dbs[int(r.next.Add(1)%uint64(len(r.dbs)))]
+}
+
+func (r *readReplicas) close() {
+	for _, db := range r.dbs {
+		_ = db.Close()
+	}
+}
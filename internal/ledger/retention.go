@@ -0,0 +1,480 @@
+package ledger
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Retr0-XD/StateLedger/internal/collectors"
+)
+
+const prunedSchema = `
+CREATE TABLE IF NOT EXISTS ledger_pruned (
+	first_id INTEGER PRIMARY KEY,
+	last_id INTEGER NOT NULL,
+	count INTEGER NOT NULL,
+	prev_hash TEXT NOT NULL,
+	last_hash TEXT NOT NULL,
+	rolling_hash TEXT NOT NULL,
+	checkpoint_id INTEGER NOT NULL,
+	pruned_at INTEGER NOT NULL
+);
+`
+
+// RetentionCheckpointRecordType is the type of the record appended when
+// records are pruned, with the ledger as source. Its payload lists the
+// pruned ranges, which lets the chain be verified across them.
+const RetentionCheckpointRecordType = "retention.checkpoint"
+
+// referencedTypes are the types Prune never removes and Redact never
+// blanks: checkpoints and compaction records, which vouch for pruned
+// ranges, redaction records,
+// the records behind holds, approvals, keys, schemas, policies and
+// anchors, which the ledger refers to by ID, and the credential lifecycle,
+// which audits who could reach the API.
+var referencedTypes = []string{
+	RetentionCheckpointRecordType,
+	CompactionRecordType,
+	RedactionRecordType,
+	SchemaRecordType,
+	AdminRequestRecordType,
+	AdminApprovalRecordType,
+	AdminExecutionRecordType,
+	HoldRecordType,
+	HoldReleaseRecordType,
+	AnchorRecordType,
+	KeyRotatedRecordType,
+	CredentialCreateRecordType,
+	CredentialRevokeRecordType,
+	CredentialRestoreRecordType,
+	PolicyRecordType,
+}
+
+// RetentionPolicy selects records to prune. A record is pruned when it is
+// older than MaxAge and not among the newest KeepLast records of Type;
+// a policy with only one of the two applies that one alone.
+type RetentionPolicy struct {
+	// Type limits the policy to one record type. A trailing '*' matches a
+	// prefix, and "" matches every type.
+	Type string `json:"type,omitempty"`
+	// MaxAge prunes records whose timestamp is older than this.
+	MaxAge time.Duration `json:"max_age,omitempty"`
+	// KeepLast keeps the newest KeepLast matching records.
+	KeepLast int `json:"keep_last,omitempty"`
+}
+
+// String describes the policy as recorded in checkpoint records.
+func (p RetentionPolicy) String() string {
+	parts := []string{"type=" + cmp.Or(p.Type, "*")}
+	if p.MaxAge > 0 {
+		parts = append(parts, "max_age="+p.MaxAge.String())
+	}
+	if p.KeepLast > 0 {
+		parts = append(parts, "keep_last="+strconv.Itoa(p.KeepLast))
+	}
+	return strings.Join(parts, " ")
+}
+
+// PrunedRange summarizes a run of consecutive records removed by Prune.
+// PrevHash is the chain hash before the first of them and LastHash the
+// hash of the last, so the records around the range still verify as one
+// chain. RollingHash commits to every pruned record hash, in order; see
+// PrunedRollingHash.
+type PrunedRange struct {
+	FirstID     int64  `json:"first_id"`
+	LastID      int64  `json:"last_id"`
+	Count       int64  `json:"count"`
+	PrevHash    string `json:"prev_hash"`
+	LastHash    string `json:"last_hash"`
+	RollingHash string `json:"rolling_hash"`
+	// CheckpointID is the checkpoint record that lists the range.
+	CheckpointID int64 `json:"checkpoint_id,omitempty"`
+	PrunedAt     int64 `json:"pruned_at,omitempty"`
+}
+
+// PrunedRollingHash folds record hashes into the rolling hash of a pruned
+// range: starting from "", each step is the hex SHA-256 of the previous
+// value followed by the next record hash. Holders of an older export can
+// recompute it to confirm which records a range removed.
+func PrunedRollingHash(hashes ...string) string {
+	var rolling string
+	for _, h := range hashes {
+		sum := sha256.Sum256([]byte(rolling + h))
+		rolling = hex.EncodeToString(sum[:])
+	}
+	return rolling
+}
+
+type retentionCheckpoint struct {
+	Policies []string      `json:"policies"`
+	Pruned   int64         `json:"pruned"`
+	Ranges   []PrunedRange `json:"ranges"`
+}
+
+// PruneOptions controls Prune.
+type PruneOptions struct {
+	Policies []RetentionPolicy
+	// DryRun reports what would be pruned without changing the ledger.
+	DryRun bool
+	// Now is the time MaxAge is measured from. Zero means time.Now.
+	Now time.Time
+}
+
+// PruneResult summarizes a Prune run.
+type PruneResult struct {
+	Pruned int64         `json:"pruned"`
+	Ranges []PrunedRange `json:"ranges"`
+	// CheckpointID is the checkpoint record appended for the run.
+	CheckpointID int64 `json:"checkpoint_id,omitempty"`
+	// Held counts records that matched a policy but are under legal hold.
+	Held   int64 `json:"held,omitempty"`
+	DryRun bool  `json:"dry_run,omitempty"`
+}
+
+// Prune deletes the local records selected by opts.Policies and appends a
+// checkpoint record listing each pruned range, in one transaction. Unlike
+// Archive, pruned records are gone: GetByID and List no longer find them.
+// VerifyChain and VerifyUpTo follow the chain across pruned ranges, and
+// VerifyChain requires every range to be listed by its checkpoint. Records
+// under an active legal hold and records of the types the ledger refers to
+// by ID are never pruned.
+func (l *Ledger) Prune(opts PruneOptions) (PruneResult, error) {
+	if len(opts.Policies) == 0 {
+		return PruneResult{}, errors.New("retention policy required")
+	}
+	for _, p := range opts.Policies {
+		if p.MaxAge < 0 || p.KeepLast < 0 {
+			return PruneResult{}, errors.New("retention policy must not be negative")
+		}
+		if p.MaxAge == 0 && p.KeepLast == 0 {
+			return PruneResult{}, fmt.Errorf("retention policy %s needs max_age or keep_last", p)
+		}
+		if p.Type != "" {
+			if err := validTypeName(p.Type); err != nil {
+				return PruneResult{}, err
+			}
+		}
+	}
+	if opts.Now.IsZero() {
+		opts.Now = time.Now()
+	}
+	if err := l.ensurePrunedSchema(); err != nil {
+		return PruneResult{}, err
+	}
+	if err := l.ensureSignatureSchema(); err != nil {
+		return PruneResult{}, err
+	}
+
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+
+	holds, err := l.activeHolds()
+	if err != nil {
+		return PruneResult{}, err
+	}
+
+	tx, err := l.db.Begin()
+	if err != nil {
+		return PruneResult{}, err
+	}
+	defer tx.Rollback()
+
+	result := PruneResult{DryRun: opts.DryRun}
+	selected := map[int64]bool{}
+	for _, p := range opts.Policies {
+		held, err := selectPrunable(tx, p, opts.Now, holds, selected)
+		if err != nil {
+			return PruneResult{}, err
+		}
+		result.Held += held
+	}
+	if len(selected) == 0 {
+		return result, nil
+	}
+
+	ranges, err := prunedRanges(tx, selected)
+	if err != nil {
+		return PruneResult{}, err
+	}
+	result.Ranges = ranges
+	for _, r := range ranges {
+		result.Pruned += r.Count
+	}
+	if opts.DryRun {
+		return result, nil
+	}
+
+	checkpoint := retentionCheckpoint{Pruned: result.Pruned, Ranges: ranges}
+	for _, p := range opts.Policies {
+		checkpoint.Policies = append(checkpoint.Policies, p.String())
+	}
+	payload, err := collectors.MarshalPayload(checkpoint)
+	if err != nil {
+		return PruneResult{}, err
+	}
+	// The checkpoint is appended before the delete so that it follows the
+	// current head, which may itself be pruned.
+	records, err := l.appendBatchTx(tx, []RecordInput{{
+		Timestamp: opts.Now.Unix(),
+		Type:      RetentionCheckpointRecordType,
+		Source:    "ledger",
+		Payload:   payload,
+	}})
+	if err != nil {
+		return PruneResult{}, err
+	}
+	result.CheckpointID = records[0].ID
+
+	if err := deleteRanges(tx, result.Ranges, result.CheckpointID, opts.Now.Unix()); err != nil {
+		return PruneResult{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return PruneResult{}, err
+	}
+
+	if l.cache != nil {
+		for id := range selected {
+			l.cache.Delete(recordCacheKey(id))
+		}
+	}
+	l.invalidateListCache()
+	l.countAppended(records)
+	l.mirrorAppended(records)
+	l.journalAppended(records)
+	return result, l.Sync()
+}
+
+// deleteRanges deletes the records of ranges and indexes each range in
+// ledger_pruned under the record that lists it, filling in CheckpointID
+// and PrunedAt.
+func deleteRanges(tx *sql.Tx, ranges []PrunedRange, checkpointID, prunedAt int64) error {
+	return withRecordsUnlocked(tx, func() error {
+		for i := range ranges {
+			r := &ranges[i]
+			if _, err := tx.Exec(`DELETE FROM ledger_records WHERE id BETWEEN ? AND ?`, r.FirstID, r.LastID); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(`DELETE FROM ledger_record_signatures WHERE record_id BETWEEN ? AND ?`, r.FirstID, r.LastID); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(`DELETE FROM ledger_record_uids WHERE record_id BETWEEN ? AND ?`, r.FirstID, r.LastID); err != nil && !isMissingTable(err) {
+				return err
+			}
+			if _, err := tx.Exec(`DELETE FROM ledger_record_labels WHERE record_id BETWEEN ? AND ?`, r.FirstID, r.LastID); err != nil && !isMissingTable(err) {
+				return err
+			}
+			r.CheckpointID, r.PrunedAt = checkpointID, prunedAt
+			if _, err := tx.Exec(`INSERT INTO ledger_pruned(first_id, last_id, count, prev_hash, last_hash, rolling_hash, checkpoint_id, pruned_at) VALUES(?, ?, ?, ?, ?, ?, ?, ?)`,
+				r.FirstID, r.LastID, r.Count, r.PrevHash, r.LastHash, r.RollingHash, r.CheckpointID, r.PrunedAt); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// selectPrunable adds the ids of the local records p selects to selected
+// and returns how many it skipped for legal holds.
+func selectPrunable(tx *sql.Tx, p RetentionPolicy, now time.Time, holds []LegalHold, selected map[int64]bool) (int64, error) {
+	query := `SELECT id, ts FROM ledger_records WHERE type NOT IN ('` + strings.Join(referencedTypes, "', '") + `')`
+	var args []any
+	if p.Type != "" {
+		query += ` AND type GLOB ?`
+		args = append(args, strings.NewReplacer("[", "[[]", "?", "[?]").Replace(p.Type))
+	}
+	query += ` ORDER BY id DESC`
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var cutoff int64
+	if p.MaxAge > 0 {
+		cutoff = now.Add(-p.MaxAge).Unix()
+	}
+	var seen, held int64
+	for rows.Next() {
+		var id, ts int64
+		if err := rows.Scan(&id, &ts); err != nil {
+			return 0, err
+		}
+		seen++
+		if seen <= int64(p.KeepLast) || (cutoff != 0 && ts >= cutoff) {
+			continue
+		}
+		if _, ok := holdCovering(holds, ts); ok {
+			held++
+			continue
+		}
+		selected[id] = true
+	}
+	return held, rows.Err()
+}
+
+// prunedRanges groups the selected ids into runs of consecutive ids and
+// summarizes each run.
+func prunedRanges(tx *sql.Tx, selected map[int64]bool) ([]PrunedRange, error) {
+	ids := make([]int64, 0, len(selected))
+	for id := range selected {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+
+	var out []PrunedRange
+	for start := 0; start < len(ids); {
+		end := start
+		for end+1 < len(ids) && ids[end+1] == ids[end]+1 {
+			end++
+		}
+		rows, err := tx.Query(`SELECT hash, prev_hash FROM ledger_records WHERE id BETWEEN ? AND ? ORDER BY id ASC`, ids[start], ids[end])
+		if err != nil {
+			return nil, err
+		}
+		r := PrunedRange{FirstID: ids[start], LastID: ids[end], Count: int64(end - start + 1)}
+		var hashes []string
+		for rows.Next() {
+			var hash, prevHash string
+			if err := rows.Scan(&hash, &prevHash); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if len(hashes) == 0 {
+				r.PrevHash = prevHash
+			}
+			hashes = append(hashes, hash)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		r.LastHash = hashes[len(hashes)-1]
+		r.RollingHash = PrunedRollingHash(hashes...)
+		out = append(out, r)
+		start = end + 1
+	}
+	return out, nil
+}
+
+// PrunedRanges lists the ranges removed by Prune in id order.
+func (l *Ledger) PrunedRanges() ([]PrunedRange, error) {
+	rows, err := l.db.Query(`SELECT first_id, last_id, count, prev_hash, last_hash, rolling_hash, checkpoint_id, pruned_at FROM ledger_pruned ORDER BY first_id ASC`)
+	if err != nil {
+		if isMissingTable(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []PrunedRange
+	for rows.Next() {
+		var r PrunedRange
+		if err := rows.Scan(&r.FirstID, &r.LastID, &r.Count, &r.PrevHash, &r.LastHash, &r.RollingHash, &r.CheckpointID, &r.PrunedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+func (l *Ledger) ensurePrunedSchema() error {
+	if l.prunedReady.Load() {
+		return nil
+	}
+	if _, err := l.db.Exec(prunedSchema); err != nil {
+		return err
+	}
+	l.prunedReady.Store(true)
+	return nil
+}
+
+// prunedGaps indexes pruned ranges by the id of their last record.
+type prunedGaps map[int64]PrunedRange
+
+func newPrunedGaps(ranges []PrunedRange) prunedGaps {
+	if len(ranges) == 0 {
+		return nil
+	}
+	g := make(prunedGaps, len(ranges))
+	for _, r := range ranges {
+		g[r.LastID] = r
+	}
+	return g
+}
+
+// prunedGaps returns the ledger's pruned ranges, or nil when nothing has
+// been pruned.
+func (l *Ledger) prunedGaps() (prunedGaps, error) {
+	ranges, err := l.PrunedRanges()
+	return newPrunedGaps(ranges), err
+}
+
+// leading returns the pruned ranges that end right before rec and chain
+// into it, oldest first, walking back while the ids stay above afterID.
+func (g prunedGaps) leading(afterID int64, rec Record) []PrunedRange {
+	var out []PrunedRange
+	hash, id := rec.PrevHash, rec.ID-1
+	for id > afterID {
+		r, ok := g[id]
+		if !ok || r.LastHash != hash {
+			break
+		}
+		out = append(out, r)
+		hash, id = r.PrevHash, r.FirstID-1
+	}
+	slices.Reverse(out)
+	return out
+}
+
+// bridge returns the chain hash rec must follow: rec.PrevHash when every
+// record between afterID and rec was pruned from a chain ending in prev,
+// and prev otherwise, so that checking rec reports the broken link.
+func (g prunedGaps) bridge(prev string, afterID int64, rec Record) string {
+	if rec.PrevHash == prev || len(g) == 0 {
+		return prev
+	}
+	ranges := g.leading(afterID, rec)
+	if len(ranges) > 0 && ranges[0].FirstID == afterID+1 && ranges[0].PrevHash == prev {
+		return rec.PrevHash
+	}
+	return prev
+}
+
+// checkPrunedCheckpoints confirms that every pruned range is listed, as
+// pruned, by its checkpoint record. It returns the first range that is
+// not and why.
+func (l *Ledger) checkPrunedCheckpoints(ranges []PrunedRange) (PrunedRange, string, error) {
+	listed := map[int64][]PrunedRange{}
+	for _, r := range ranges {
+		if _, ok := listed[r.CheckpointID]; !ok {
+			rec, err := l.GetByID(r.CheckpointID)
+			if errors.Is(err, sql.ErrNoRows) {
+				return r, fmt.Sprintf("checkpoint record %d missing", r.CheckpointID), nil
+			}
+			if err != nil {
+				return r, "", err
+			}
+			// Compaction records list their ranges the same way.
+			var cp retentionCheckpoint
+			if (rec.Type != RetentionCheckpointRecordType && rec.Type != CompactionRecordType) || json.Unmarshal([]byte(rec.Payload), &cp) != nil {
+				return r, fmt.Sprintf("record %d is not a retention checkpoint", r.CheckpointID), nil
+			}
+			listed[r.CheckpointID] = cp.Ranges
+		}
+		want := r
+		want.CheckpointID, want.PrunedAt = 0, 0
+		if !slices.Contains(listed[r.CheckpointID], want) {
+			return r, fmt.Sprintf("pruned range %d-%d not listed by checkpoint %d", r.FirstID, r.LastID, r.CheckpointID), nil
+		}
+	}
+	return PrunedRange{}, "", nil
+}
@@ -0,0 +1,273 @@
+package ledger
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Retr0-XD/StateLedger/internal/collectors"
+)
+
+const schemaRegistrySchema = `
+CREATE TABLE IF NOT EXISTS ledger_schemas (
+	type TEXT NOT NULL,
+	version INTEGER NOT NULL,
+	record_id INTEGER NOT NULL,
+	PRIMARY KEY (type, version)
+);
+`
+
+// SchemaRecordType is the record type under which custom payload schema
+// versions are appended, so their history is part of the hash chain.
+const SchemaRecordType = "schema"
+
+// ErrUnknownSchema is returned for a record type without a registered schema.
+var ErrUnknownSchema = errors.New("schema not registered")
+
+// ErrInvalidSchema is returned when a schema cannot be registered as given.
+var ErrInvalidSchema = errors.New("invalid schema")
+
+// PayloadSchema is one version of the JSON Schema describing the payloads of
+// a record type. Built-in schemas are defined by the collectors and have no
+// ledger record.
+type PayloadSchema struct {
+	Type    string          `json:"type"`
+	Version int             `json:"version"`
+	Builtin bool            `json:"builtin,omitempty"`
+	Schema  json.RawMessage `json:"schema"`
+	// RecordID, Hash and RegisteredAt identify the ledger record that
+	// registered a custom schema version.
+	RecordID     int64  `json:"record_id,omitempty"`
+	Hash         string `json:"hash,omitempty"`
+	RegisteredAt int64  `json:"registered_at,omitempty"`
+}
+
+type schemaRecord struct {
+	Type    string          `json:"type"`
+	Version int             `json:"version"`
+	Schema  json.RawMessage `json:"schema"`
+}
+
+// RegisterSchema records a new version of the payload schema for a custom
+// record type. schema must be a JSON object, normally a JSON Schema
+// document. Built-in types cannot be redefined.
+func (l *Ledger) RegisterSchema(recordType string, schema json.RawMessage) (PayloadSchema, error) {
+	recordType = strings.TrimSpace(recordType)
+	switch {
+	case recordType == "" || strings.ContainsAny(recordType, "/?#"):
+		return PayloadSchema{}, fmt.Errorf("%w: record type must be a non-empty name without '/', '?' or '#'", ErrInvalidSchema)
+	case recordType == SchemaRecordType:
+		return PayloadSchema{}, fmt.Errorf("%w: %q is reserved", ErrInvalidSchema, SchemaRecordType)
+	}
+	if _, ok := collectors.BuiltinSchemas()[recordType]; ok {
+		return PayloadSchema{}, fmt.Errorf("%w: %s is a built-in type; its schema cannot be replaced", ErrInvalidSchema, recordType)
+	}
+	var obj map[string]any
+	if err := json.Unmarshal(schema, &obj); err != nil || obj == nil {
+		return PayloadSchema{}, fmt.Errorf("%w: schema must be a JSON object", ErrInvalidSchema)
+	}
+	canonical, err := collectors.CanonicalizeJSON(schema)
+	if err != nil {
+		return PayloadSchema{}, err
+	}
+	if err := l.ensureSchemaRegistry(); err != nil {
+		return PayloadSchema{}, err
+	}
+
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+
+	tx, err := l.db.Begin()
+	if err != nil {
+		return PayloadSchema{}, err
+	}
+	defer tx.Rollback()
+
+	var version int
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(version), 0) + 1 FROM ledger_schemas WHERE type = ?`, recordType).Scan(&version); err != nil {
+		return PayloadSchema{}, err
+	}
+	payload, err := collectors.MarshalPayload(schemaRecord{Type: recordType, Version: version, Schema: canonical})
+	if err != nil {
+		return PayloadSchema{}, err
+	}
+	records, err := l.appendBatchTx(tx, []RecordInput{{
+		Timestamp: time.Now().Unix(),
+		Type:      SchemaRecordType,
+		Source:    recordType,
+		Payload:   payload,
+	}})
+	if err != nil {
+		return PayloadSchema{}, err
+	}
+	if _, err := tx.Exec(`INSERT INTO ledger_schemas(type, version, record_id) VALUES(?, ?, ?)`, recordType, version, records[0].ID); err != nil {
+		return PayloadSchema{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return PayloadSchema{}, err
+	}
+	l.invalidateListCache()
+	l.countAppended(records)
+	l.mirrorAppended(records)
+	l.journalAppended(records)
+	if err := l.Sync(); err != nil {
+		return PayloadSchema{}, err
+	}
+	return schemaFromRecord(records[0])
+}
+
+// Schema returns a version of a record type's payload schema; version 0
+// selects the latest.
+func (l *Ledger) Schema(recordType string, version int) (PayloadSchema, error) {
+	if builtin, ok := builtinSchema(recordType); ok {
+		if version > 1 {
+			return PayloadSchema{}, fmt.Errorf("%s version %d: %w", recordType, version, ErrUnknownSchema)
+		}
+		return builtin, nil
+	}
+	if err := l.ensureSchemaRegistry(); err != nil {
+		return PayloadSchema{}, err
+	}
+
+	var recordID int64
+	err := l.db.QueryRow(`SELECT record_id FROM ledger_schemas WHERE type = ? AND (version = ? OR ? = 0)
+		ORDER BY version DESC LIMIT 1`, recordType, version, version).Scan(&recordID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return PayloadSchema{}, fmt.Errorf("%s: %w", recordType, ErrUnknownSchema)
+	}
+	if err != nil {
+		return PayloadSchema{}, err
+	}
+	rec, err := l.GetByID(recordID)
+	if err != nil {
+		return PayloadSchema{}, err
+	}
+	return schemaFromRecord(rec)
+}
+
+// SchemaHistory returns every version of a record type's payload schema,
+// oldest first.
+func (l *Ledger) SchemaHistory(recordType string) ([]PayloadSchema, error) {
+	if builtin, ok := builtinSchema(recordType); ok {
+		return []PayloadSchema{builtin}, nil
+	}
+	if err := l.ensureSchemaRegistry(); err != nil {
+		return nil, err
+	}
+
+	rows, err := l.db.Query(`SELECT record_id FROM ledger_schemas WHERE type = ? ORDER BY version ASC`, recordType)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("%s: %w", recordType, ErrUnknownSchema)
+	}
+
+	history := make([]PayloadSchema, 0, len(ids))
+	for _, id := range ids {
+		rec, err := l.GetByID(id)
+		if err != nil {
+			return nil, err
+		}
+		s, err := schemaFromRecord(rec)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, s)
+	}
+	return history, nil
+}
+
+// Schemas returns the latest schema of every built-in and custom record
+// type, sorted by type.
+func (l *Ledger) Schemas() ([]PayloadSchema, error) {
+	if err := l.ensureSchemaRegistry(); err != nil {
+		return nil, err
+	}
+	rows, err := l.db.Query(`SELECT DISTINCT type FROM ledger_schemas`)
+	if err != nil {
+		return nil, err
+	}
+	types := []string{}
+	for rows.Next() {
+		var typ string
+		if err := rows.Scan(&typ); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		types = append(types, typ)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for typ := range collectors.BuiltinSchemas() {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+
+	out := make([]PayloadSchema, 0, len(types))
+	for _, typ := range types {
+		s, err := l.Schema(typ, 0)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+func (l *Ledger) ensureSchemaRegistry() error {
+	if l.schemasReady.Load() {
+		return nil
+	}
+	if _, err := l.db.Exec(schemaRegistrySchema); err != nil {
+		return err
+	}
+	l.schemasReady.Store(true)
+	return nil
+}
+
+func builtinSchema(recordType string) (PayloadSchema, bool) {
+	schema, ok := collectors.BuiltinSchemas()[recordType]
+	if !ok {
+		return PayloadSchema{}, false
+	}
+	raw, err := collectors.CanonicalJSON(schema)
+	if err != nil {
+		return PayloadSchema{}, false
+	}
+	return PayloadSchema{Type: recordType, Version: 1, Builtin: true, Schema: raw}, true
+}
+
+func schemaFromRecord(rec Record) (PayloadSchema, error) {
+	var body schemaRecord
+	if err := json.Unmarshal([]byte(rec.Payload), &body); err != nil {
+		return PayloadSchema{}, fmt.Errorf("record %d: invalid schema payload: %w", rec.ID, err)
+	}
+	return PayloadSchema{
+		Type:         body.Type,
+		Version:      body.Version,
+		Schema:       body.Schema,
+		RecordID:     rec.ID,
+		Hash:         rec.Hash,
+		RegisteredAt: rec.Timestamp,
+	}, nil
+}
@@ -0,0 +1,312 @@
+package ledger
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// SegmentFormat identifies portable segment files.
+const SegmentFormat = "stateledger-segment"
+
+const segmentVersion = 1
+
+// SegmentHeader is the first line of a segment file.
+type SegmentHeader struct {
+	Format  string `json:"format"`
+	Version int    `json:"version"`
+	// PrevSegmentHash is the SegmentHash of the segment exported before
+	// this one, or "" for the first segment of a transfer.
+	PrevSegmentHash string `json:"prev_segment_hash"`
+	// PrevHash is the chain hash preceding the first record.
+	PrevHash  string `json:"prev_hash"`
+	FirstID   int64  `json:"first_id"`
+	LastID    int64  `json:"last_id"`
+	Count     int    `json:"count"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// SegmentTrailer is the last line of a segment file. SegmentHash is the
+// SHA-256 of every preceding line, newline included.
+type SegmentTrailer struct {
+	Trailer     bool   `json:"trailer"`
+	Count       int    `json:"count"`
+	LastHash    string `json:"last_hash"`
+	SegmentHash string `json:"segment_hash"`
+}
+
+// Segment is a decoded and verified segment file.
+type Segment struct {
+	Header  SegmentHeader
+	Records []Record
+	Trailer SegmentTrailer
+}
+
+// WriteSegment writes recs as a segment file. recs must be a contiguous,
+// valid run of the chain.
+func WriteSegment(w io.Writer, recs []Record, prevSegmentHash string) (SegmentTrailer, error) {
+	if len(recs) == 0 {
+		return SegmentTrailer{}, errors.New("segment requires at least one record")
+	}
+
+	prev := recs[0].PrevHash
+	for _, rec := range recs {
+		if reason := checkChainLink(prev, rec); reason != "" {
+			return SegmentTrailer{}, fmt.Errorf("record %d: %s", rec.ID, reason)
+		}
+		prev = rec.Hash
+	}
+
+	h := sha256.New()
+	bw := bufio.NewWriter(w)
+	out := io.MultiWriter(bw, h)
+
+	header := SegmentHeader{
+		Format:          SegmentFormat,
+		Version:         segmentVersion,
+		PrevSegmentHash: prevSegmentHash,
+		PrevHash:        recs[0].PrevHash,
+		FirstID:         recs[0].ID,
+		LastID:          recs[len(recs)-1].ID,
+		Count:           len(recs),
+		CreatedAt:       time.Now().Unix(),
+	}
+	if err := writeJSONLine(out, header); err != nil {
+		return SegmentTrailer{}, err
+	}
+	for _, rec := range recs {
+		if err := writeJSONLine(out, rec); err != nil {
+			return SegmentTrailer{}, err
+		}
+	}
+
+	trailer := SegmentTrailer{
+		Trailer:     true,
+		Count:       len(recs),
+		LastHash:    prev,
+		SegmentHash: hex.EncodeToString(h.Sum(nil)),
+	}
+	if err := writeJSONLine(bw, trailer); err != nil {
+		return SegmentTrailer{}, err
+	}
+	return trailer, bw.Flush()
+}
+
+func writeJSONLine(w io.Writer, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// ReadSegment decodes a segment file and verifies its trailer hash, record
+// count and hash chain.
+func ReadSegment(r io.Reader) (Segment, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+
+	var seg Segment
+	h := sha256.New()
+	var lines [][]byte
+	for sc.Scan() {
+		lines = append(lines, append([]byte(nil), sc.Bytes()...))
+	}
+	if err := sc.Err(); err != nil {
+		return Segment{}, err
+	}
+	if len(lines) < 2 {
+		return Segment{}, errors.New("segment truncated: missing header or trailer")
+	}
+
+	if err := json.Unmarshal(lines[0], &seg.Header); err != nil {
+		return Segment{}, fmt.Errorf("segment header: %w", err)
+	}
+	if seg.Header.Format != SegmentFormat {
+		return Segment{}, fmt.Errorf("not a segment file (format %q)", seg.Header.Format)
+	}
+	if seg.Header.Version != segmentVersion {
+		return Segment{}, fmt.Errorf("unsupported segment version %d", seg.Header.Version)
+	}
+
+	last := lines[len(lines)-1]
+	if err := json.Unmarshal(last, &seg.Trailer); err != nil || !seg.Trailer.Trailer {
+		return Segment{}, errors.New("segment truncated: missing trailer")
+	}
+
+	for i, line := range lines[:len(lines)-1] {
+		h.Write(line)
+		h.Write([]byte{'\n'})
+		if i == 0 {
+			continue
+		}
+		var rec Record
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&rec); err != nil {
+			return Segment{}, fmt.Errorf("segment line %d: %w", i+1, err)
+		}
+		seg.Records = append(seg.Records, rec)
+	}
+
+	if got := hex.EncodeToString(h.Sum(nil)); got != seg.Trailer.SegmentHash {
+		return Segment{}, errors.New("segment hash mismatch")
+	}
+	if len(seg.Records) != seg.Header.Count || seg.Trailer.Count != seg.Header.Count {
+		return Segment{}, errors.New("segment record count mismatch")
+	}
+
+	prev := seg.Header.PrevHash
+	for _, rec := range seg.Records {
+		if reason := checkChainLink(prev, rec); reason != "" {
+			return Segment{}, fmt.Errorf("record %d: %s", rec.ID, reason)
+		}
+		prev = rec.Hash
+	}
+	if prev != seg.Trailer.LastHash {
+		return Segment{}, errors.New("segment last hash mismatch")
+	}
+	if seg.Records[0].ID != seg.Header.FirstID || seg.Records[len(seg.Records)-1].ID != seg.Header.LastID {
+		return Segment{}, errors.New("segment id range mismatch")
+	}
+	return seg, nil
+}
+
+// VerifySegmentSequence checks that segments link to each other in order:
+// each header names the previous segment's hash and continues its chain.
+func VerifySegmentSequence(segs []Segment) error {
+	for i := 1; i < len(segs); i++ {
+		prev, cur := segs[i-1], segs[i]
+		if cur.Header.PrevSegmentHash != prev.Trailer.SegmentHash {
+			return fmt.Errorf("segment %d does not follow segment %d (prev_segment_hash mismatch)", i+1, i)
+		}
+		if cur.Header.PrevHash != prev.Trailer.LastHash {
+			return fmt.Errorf("segment %d does not continue the chain of segment %d", i+1, i)
+		}
+	}
+	return nil
+}
+
+// RecordsByID returns up to limit local records with fromID <= id <= toID
+// in id order, with their signatures. toID <= 0 means no upper bound.
+func (l *Ledger) RecordsByID(fromID, toID int64, limit int) ([]Record, error) {
+	query := `SELECT id, ts, type, source, payload, hash, prev_hash FROM ledger_records WHERE id >= ?`
+	args := []any{fromID}
+	if toID > 0 {
+		query += ` AND id <= ?`
+		args = append(args, toID)
+	}
+	query += ` ORDER BY id ASC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := l.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Record
+	for rows.Next() {
+		var rec Record
+		if err := rows.Scan(&rec.ID, &rec.Timestamp, &rec.Type, &rec.Source, &rec.Payload, &rec.Hash, &rec.PrevHash); err != nil {
+			return nil, err
+		}
+		out = append(out, rec)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	if err := l.attachSignatures(l.db, out); err != nil {
+		return nil, err
+	}
+	if err := l.attachRedactions(l.db, out); err != nil {
+		return nil, err
+	}
+	if err := l.attachUIDs(l.db, out); err != nil {
+		return nil, err
+	}
+	if err := l.attachLabels(l.db, out); err != nil {
+		return nil, err
+	}
+	return out, l.attachSeqs(l.db, out)
+}
+
+// ImportSegment appends a verified segment, preserving record ids and
+// hashes. The segment must continue the local chain; records that are
+// already present with identical hashes are skipped, so re-importing a
+// segment is a no-op. It returns the number of records inserted.
+func (l *Ledger) ImportSegment(seg Segment) (int, error) {
+	if err := l.ensureLabelSchema(); err != nil {
+		return 0, err
+	}
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+
+	tx, err := l.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	head, err := l.lastHashTx(tx)
+	if err != nil {
+		return 0, err
+	}
+	var maxID int64
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM ledger_records`).Scan(&maxID); err != nil {
+		return 0, err
+	}
+
+	stmt, err := tx.Prepare(`INSERT INTO ledger_records(id, ts, type, source, payload, hash, prev_hash) VALUES(?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	inserted := 0
+	for _, rec := range seg.Records {
+		if rec.ID <= maxID {
+			var existing string
+			err := tx.QueryRow(`SELECT hash FROM ledger_records WHERE id = ?`, rec.ID).Scan(&existing)
+			if errors.Is(err, sql.ErrNoRows) {
+				return 0, fmt.Errorf("record %d is older than the local chain head", rec.ID)
+			}
+			if err != nil {
+				return 0, err
+			}
+			if existing != rec.Hash {
+				return 0, fmt.Errorf("record %d conflicts with local record", rec.ID)
+			}
+			continue
+		}
+
+		if head != rec.PrevHash {
+			return 0, fmt.Errorf("record %d does not continue the local chain", rec.ID)
+		}
+		if _, err := stmt.Exec(rec.ID, rec.Timestamp, rec.Type, rec.Source, rec.Payload, rec.Hash, rec.PrevHash); err != nil {
+			return 0, err
+		}
+		if err := insertLabels(tx, rec.ID, rec.Labels); err != nil {
+			return 0, err
+		}
+		head = rec.Hash
+		inserted++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	if inserted > 0 {
+		l.invalidateListCache()
+	}
+	return inserted, l.Sync()
+}
@@ -0,0 +1,397 @@
+package ledger
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Retr0-XD/StateLedger/internal/collectors"
+)
+
+const signatureSchema = `
+CREATE TABLE IF NOT EXISTS ledger_record_signatures (
+	record_id INTEGER PRIMARY KEY,
+	key_id TEXT NOT NULL,
+	signature TEXT NOT NULL,
+	key_ref TEXT NOT NULL DEFAULT ''
+);
+`
+
+// Signer signs records and audit bundles. Implementations backed by a KMS
+// or a hardware token never expose the private key to the ledger host.
+type Signer interface {
+	// KeyRef identifies the key in the store that holds it, such as a KMS
+	// key ARN. It is recorded with every signature, and is empty for keys
+	// held in memory.
+	KeyRef() string
+	// PublicKey returns the public half of the key: an ed25519.PublicKey,
+	// *ecdsa.PublicKey or *rsa.PublicKey.
+	PublicKey(ctx context.Context) (crypto.PublicKey, error)
+	// Sign signs msg. Ed25519 keys sign msg itself. ECDSA keys sign its
+	// SHA-256 digest, with an ASN.1 DER signature, and RSA keys its SHA-256
+	// digest with PKCS #1 v1.5.
+	Sign(ctx context.Context, msg []byte) ([]byte, error)
+}
+
+// KeySigner signs with an ed25519 private key held in memory.
+type KeySigner struct {
+	Key ed25519.PrivateKey
+}
+
+func (s KeySigner) KeyRef() string {
+	return ""
+}
+
+func (s KeySigner) PublicKey(ctx context.Context) (crypto.PublicKey, error) {
+	return s.Key.Public(), nil
+}
+
+func (s KeySigner) Sign(ctx context.Context, msg []byte) ([]byte, error) {
+	return ed25519.Sign(s.Key, msg), nil
+}
+
+// signTimeout bounds a call to a remote signer.
+const signTimeout = 30 * time.Second
+
+// recordSigner is the signer set with SetSigner.
+type recordSigner struct {
+	s   Signer
+	id  string
+	ref string
+}
+
+// SigningKeyID returns the ID under which signatures by the public key pub
+// are stored: a fingerprint of the raw key for ed25519 and of its DER
+// SubjectPublicKeyInfo otherwise.
+func SigningKeyID(pub crypto.PublicKey) string {
+	raw, ok := pub.(ed25519.PublicKey)
+	if !ok {
+		der, err := x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			return ""
+		}
+		raw = der
+	}
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:8])
+}
+
+// ParseSigningKey decodes a base64-encoded ed25519 seed or private key.
+func ParseSigningKey(s string) (ed25519.PrivateKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, errors.New("signing key must be base64-encoded")
+	}
+	switch len(raw) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(raw), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(raw), nil
+	default:
+		return nil, errors.New("signing key must be an ed25519 seed or private key")
+	}
+}
+
+// ParsePublicKey decodes a public key: a base64-encoded raw ed25519 key,
+// or a DER SubjectPublicKeyInfo, base64-encoded or in a PEM block.
+func ParsePublicKey(s string) (crypto.PublicKey, error) {
+	s = strings.TrimSpace(s)
+	var der []byte
+	if block, _ := pem.Decode([]byte(s)); block != nil {
+		der = block.Bytes
+	} else {
+		raw, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, errors.New("public key must be base64 or PEM-encoded")
+		}
+		if len(raw) == ed25519.PublicKeySize {
+			return ed25519.PublicKey(raw), nil
+		}
+		der = raw
+	}
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	if !supportedPublicKey(pub) {
+		return nil, fmt.Errorf("unsupported public key type %T", pub)
+	}
+	return pub, nil
+}
+
+// MarshalPublicKey encodes pub the way ParsePublicKey reads it: the raw
+// key for ed25519 and the DER SubjectPublicKeyInfo otherwise, in base64.
+func MarshalPublicKey(pub crypto.PublicKey) (string, error) {
+	if k, ok := pub.(ed25519.PublicKey); ok {
+		return base64.StdEncoding.EncodeToString(k), nil
+	}
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(der), nil
+}
+
+func supportedPublicKey(pub crypto.PublicKey) bool {
+	switch pub.(type) {
+	case ed25519.PublicKey, *ecdsa.PublicKey, *rsa.PublicKey:
+		return true
+	}
+	return false
+}
+
+// verifySignature checks sig over msg as produced by Signer.Sign.
+func verifySignature(pub crypto.PublicKey, msg, sig []byte) bool {
+	switch k := pub.(type) {
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, msg, sig)
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(msg)
+		return ecdsa.VerifyASN1(k, digest[:], sig)
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(msg)
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) == nil
+	}
+	return false
+}
+
+// SetSigningKey signs every record appended from now on with key.
+func (l *Ledger) SetSigningKey(key ed25519.PrivateKey) error {
+	if len(key) != ed25519.PrivateKeySize {
+		return errors.New("signing key must be an ed25519 private key")
+	}
+	return l.SetSigner(KeySigner{Key: key})
+}
+
+// SetSigner signs every record appended from now on, and every audit
+// bundle, with s. The signature of a record covers its hash, which commits
+// to the record and the whole chain before it. The signer's public key is
+// also added to the keys VerifyChain checks signatures with.
+func (l *Ledger) SetSigner(s Signer) error {
+	ctx, cancel := context.WithTimeout(context.Background(), signTimeout)
+	defer cancel()
+	pub, err := s.PublicKey(ctx)
+	if err != nil {
+		return fmt.Errorf("signing key: %w", err)
+	}
+	if !supportedPublicKey(pub) {
+		return fmt.Errorf("unsupported signing key type %T", pub)
+	}
+	if err := l.ensureSignatureSchema(); err != nil {
+		return err
+	}
+	l.signer.Store(&recordSigner{s: s, id: SigningKeyID(pub), ref: s.KeyRef()})
+	l.AddVerifyKeys(pub)
+	return nil
+}
+
+// AddVerifyKeys adds public keys that VerifyChain and VerifyUpTo check
+// record signatures with. Only the public keys are needed to verify, so
+// auditors do not need the signing key.
+func (l *Ledger) AddVerifyKeys(keys ...crypto.PublicKey) {
+	l.signMu.Lock()
+	defer l.signMu.Unlock()
+	if l.verifyKeys == nil {
+		l.verifyKeys = map[string]crypto.PublicKey{}
+	}
+	for _, k := range keys {
+		l.verifyKeys[SigningKeyID(k)] = k
+	}
+}
+
+// sign signs msg with the ledger's signer. ok is false when no signer is
+// set.
+func (l *Ledger) sign(msg string) (sig Signature, ok bool, err error) {
+	s := l.signer.Load()
+	if s == nil {
+		return Signature{}, false, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), signTimeout)
+	defer cancel()
+	raw, err := s.s.Sign(ctx, []byte(msg))
+	if err != nil {
+		return Signature{}, false, err
+	}
+	return Signature{KeyID: s.id, KeyRef: s.ref, Signature: base64.StdEncoding.EncodeToString(raw)}, true, nil
+}
+
+// VerifyRecordSignature reports whether rec carries a valid signature by
+// pub. It needs nothing but the record, so exported records can be checked
+// offline.
+func VerifyRecordSignature(rec Record, pub crypto.PublicKey) bool {
+	if rec.SigningKeyID != SigningKeyID(pub) {
+		return false
+	}
+	sig, err := base64.StdEncoding.DecodeString(rec.Signature)
+	return err == nil && verifySignature(pub, []byte(rec.Hash), sig)
+}
+
+// signatureCheck checks the signatures of records in chain order. Once a
+// signed record is seen, every later record must be signed too, so
+// signatures cannot be stripped from the tail of the chain.
+type signatureCheck struct {
+	keys   map[string]crypto.PublicKey
+	signed bool
+}
+
+// signatureCheck returns nil when no verify keys are configured.
+func (l *Ledger) signatureCheck() *signatureCheck {
+	l.signMu.RLock()
+	defer l.signMu.RUnlock()
+	if len(l.verifyKeys) == 0 {
+		return nil
+	}
+	keys := make(map[string]crypto.PublicKey, len(l.verifyKeys))
+	for id, k := range l.verifyKeys {
+		keys[id] = k
+	}
+	return &signatureCheck{keys: keys}
+}
+
+// check returns the reason rec fails verification, or "".
+func (c *signatureCheck) check(rec Record) string {
+	if rec.Signature == "" {
+		if c.signed {
+			return "signature missing"
+		}
+		return ""
+	}
+	c.signed = true
+	pub, ok := c.keys[rec.SigningKeyID]
+	if !ok {
+		return "unknown signing key " + rec.SigningKeyID
+	}
+	if !VerifyRecordSignature(rec, pub) {
+		return "signature mismatch"
+	}
+	return ""
+}
+
+// attachSignatures fills in the signature of each signed record.
+func (l *Ledger) attachSignatures(q rowQueryer, records []Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+	if err := l.ensureSignatureSchema(); err != nil {
+		return err
+	}
+	lo, hi := records[0].ID, records[0].ID
+	for _, rec := range records {
+		lo, hi = min(lo, rec.ID), max(hi, rec.ID)
+	}
+	rows, err := q.Query(`SELECT record_id, key_id, key_ref, signature FROM ledger_record_signatures WHERE record_id BETWEEN ? AND ?`, lo, hi)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	sigs := map[int64]Signature{}
+	for rows.Next() {
+		var id int64
+		var s Signature
+		if err := rows.Scan(&id, &s.KeyID, &s.KeyRef, &s.Signature); err != nil {
+			return err
+		}
+		sigs[id] = s
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for i := range records {
+		s := sigs[records[i].ID]
+		records[i].SigningKeyID, records[i].SigningKeyRef, records[i].Signature = s.KeyID, s.KeyRef, s.Signature
+	}
+	return nil
+}
+
+func (l *Ledger) ensureSignatureSchema() error {
+	if l.signaturesReady.Load() {
+		return nil
+	}
+	if _, err := l.db.Exec(signatureSchema); err != nil {
+		return err
+	}
+	// Tables created before key references were recorded lack key_ref.
+	var n int
+	if err := l.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('ledger_record_signatures') WHERE name = 'key_ref'`).Scan(&n); err != nil {
+		return err
+	}
+	if n == 0 {
+		if _, err := l.db.Exec(`ALTER TABLE ledger_record_signatures ADD COLUMN key_ref TEXT NOT NULL DEFAULT ''`); err != nil {
+			return err
+		}
+	}
+	l.signaturesReady.Store(true)
+	return nil
+}
+
+// Signature is a signature by the ledger's signer. On an audit bundle it
+// covers the hex SHA-256 of the bundle in canonical JSON without the
+// signature, which verifiers can recompute from the bundle file alone.
+type Signature struct {
+	KeyID     string `json:"key_id"`
+	KeyRef    string `json:"key_ref,omitempty"`
+	Signature string `json:"signature"`
+}
+
+func bundleDigest(b AuditBundle) (string, error) {
+	b.Signature = nil
+	data, err := collectors.CanonicalJSON(b)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// signBundle signs b with the ledger's signer, if one is set.
+func (l *Ledger) signBundle(b *AuditBundle) error {
+	digest, err := bundleDigest(*b)
+	if err != nil {
+		return err
+	}
+	sig, ok, err := l.sign(digest)
+	if err != nil {
+		return fmt.Errorf("sign audit bundle: %w", err)
+	}
+	if ok {
+		b.Signature = &sig
+	}
+	return nil
+}
+
+// VerifyAuditBundle checks the signature of b against keys.
+func VerifyAuditBundle(b AuditBundle, keys ...crypto.PublicKey) error {
+	if b.Signature == nil {
+		return errors.New("audit bundle is not signed")
+	}
+	var pub crypto.PublicKey
+	for _, k := range keys {
+		if SigningKeyID(k) == b.Signature.KeyID {
+			pub = k
+		}
+	}
+	if pub == nil {
+		return fmt.Errorf("unknown signing key %s", b.Signature.KeyID)
+	}
+	digest, err := bundleDigest(b)
+	if err != nil {
+		return err
+	}
+	sig, err := base64.StdEncoding.DecodeString(b.Signature.Signature)
+	if err != nil || !verifySignature(pub, []byte(digest), sig) {
+		return errors.New("audit bundle signature mismatch")
+	}
+	return nil
+}
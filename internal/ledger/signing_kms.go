@@ -0,0 +1,531 @@
+package ledger
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OpenSigner resolves a reference to a key held outside the ledger host.
+// Supported forms are awskms:<key ID, ARN or alias> (AWS KMS),
+// gcpkms:projects/.../cryptoKeyVersions/<n> (Google Cloud KMS) and an RFC
+// 7512 pkcs11: URI with a module-path attribute (a PKCS #11 token, used
+// through OpenSC's pkcs11-tool).
+func OpenSigner(ref string) (Signer, error) {
+	scheme, rest, _ := strings.Cut(ref, ":")
+	switch scheme {
+	case "awskms":
+		return NewAWSKMSSigner(rest)
+	case "gcpkms":
+		if !strings.HasPrefix(rest, "projects/") || !strings.Contains(rest, "/cryptoKeyVersions/") {
+			return nil, fmt.Errorf("signer %q: gcpkms needs a cryptoKeyVersions resource name", ref)
+		}
+		return &GCPKMSSigner{Name: rest}, nil
+	case "pkcs11":
+		return NewPKCS11Signer(ref)
+	default:
+		return nil, fmt.Errorf("unsupported signer %q", ref)
+	}
+}
+
+func signerClient(c *http.Client) *http.Client {
+	if c != nil {
+		return c
+	}
+	return &http.Client{Timeout: signTimeout}
+}
+
+// readSignerResponse decodes the JSON body of resp into out, or returns
+// the error it reports.
+func readSignerResponse(resp *http.Response, what string, out any) error {
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s: %s", what, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%s: %w", what, err)
+	}
+	return nil
+}
+
+// AWSKMSSigner signs with an asymmetric AWS KMS key of usage SIGN_VERIFY,
+// ECC_NIST_P256 or RSA. Credentials and region come from the same AWS_*
+// environment variables as S3ArchiveStore; the region of a key ARN takes
+// precedence. AWS_ENDPOINT_URL_KMS (or AWS_ENDPOINT_URL) selects another
+// endpoint.
+type AWSKMSSigner struct {
+	KeyID string
+
+	Region       string
+	Endpoint     string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+
+	Client *http.Client
+
+	mu  sync.Mutex
+	arn string
+	pub crypto.PublicKey
+}
+
+// NewAWSKMSSigner returns a signer for keyID configured from the
+// environment.
+func NewAWSKMSSigner(keyID string) (*AWSKMSSigner, error) {
+	if keyID == "" {
+		return nil, errors.New("kms key id required")
+	}
+	s := &AWSKMSSigner{
+		KeyID:        keyID,
+		Region:       firstEnv("AWS_REGION", "AWS_DEFAULT_REGION"),
+		Endpoint:     firstEnv("AWS_ENDPOINT_URL_KMS", "AWS_ENDPOINT_URL"),
+		AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	// arn:aws:kms:<region>:<account>:key/<id>
+	if parts := strings.Split(keyID, ":"); len(parts) > 3 && parts[0] == "arn" {
+		s.Region = parts[3]
+	}
+	if s.Region == "" {
+		s.Region = "us-east-1"
+	}
+	if s.AccessKey == "" || s.SecretKey == "" {
+		return nil, errors.New("aws kms requires AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	return s, nil
+}
+
+// KeyRef returns awskms: and the key ARN, once the public key has been
+// fetched, or the configured key ID.
+func (s *AWSKMSSigner) KeyRef() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.arn != "" {
+		return "awskms:" + s.arn
+	}
+	return "awskms:" + s.KeyID
+}
+
+func (s *AWSKMSSigner) call(ctx context.Context, action string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = "https://kms." + s.Region + ".amazonaws.com"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(endpoint, "/")+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService."+action)
+	sum := sha256.Sum256(body)
+	signAWSRequest(req, "kms", hex.EncodeToString(sum[:]), s.Region, s.AccessKey, s.SecretKey, s.SessionToken, time.Now())
+
+	resp, err := signerClient(s.Client).Do(req)
+	if err != nil {
+		return err
+	}
+	return readSignerResponse(resp, "kms "+action, out)
+}
+
+func (s *AWSKMSSigner) PublicKey(ctx context.Context) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pub != nil {
+		return s.pub, nil
+	}
+	var out struct {
+		KeyId     string
+		KeyUsage  string
+		PublicKey []byte
+	}
+	if err := s.call(ctx, "GetPublicKey", map[string]string{"KeyId": s.KeyID}, &out); err != nil {
+		return nil, err
+	}
+	if out.KeyUsage != "SIGN_VERIFY" {
+		return nil, fmt.Errorf("kms key %s has usage %s, not SIGN_VERIFY", s.KeyID, out.KeyUsage)
+	}
+	pub, err := x509.ParsePKIXPublicKey(out.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("kms key %s: %w", s.KeyID, err)
+	}
+	s.pub, s.arn = pub, out.KeyId
+	return pub, nil
+}
+
+func (s *AWSKMSSigner) Sign(ctx context.Context, msg []byte) ([]byte, error) {
+	pub, err := s.PublicKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var algorithm string
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() {
+			return nil, errors.New("kms ecdsa keys must be ECC_NIST_P256")
+		}
+		algorithm = "ECDSA_SHA_256"
+	case *rsa.PublicKey:
+		algorithm = "RSASSA_PKCS1_V1_5_SHA_256"
+	default:
+		return nil, fmt.Errorf("unsupported kms key type %T", pub)
+	}
+	var out struct{ Signature []byte }
+	err = s.call(ctx, "Sign", map[string]any{
+		"KeyId":            s.KeyID,
+		"Message":          msg,
+		"MessageType":      "RAW",
+		"SigningAlgorithm": algorithm,
+	}, &out)
+	return out.Signature, err
+}
+
+// GCPKMSSigner signs with a Google Cloud KMS asymmetric signing key
+// version of algorithm EC_SIGN_P256_SHA256, EC_SIGN_ED25519 or
+// RSA_SIGN_PKCS1_*_SHA256. The access token is read from
+// GOOGLE_OAUTH_ACCESS_TOKEN or, when that is unset, from the metadata
+// server of the instance.
+type GCPKMSSigner struct {
+	// Name is the resource name of the key version.
+	Name string
+	// Endpoint defaults to https://cloudkms.googleapis.com.
+	Endpoint string
+	// Token returns an OAuth access token, gcpAccessToken by default.
+	Token func(ctx context.Context) (string, error)
+
+	Client *http.Client
+
+	mu        sync.Mutex
+	pub       crypto.PublicKey
+	algorithm string
+}
+
+func (s *GCPKMSSigner) KeyRef() string {
+	return "gcpkms:" + s.Name
+}
+
+func (s *GCPKMSSigner) do(ctx context.Context, method, path string, in, out any) error {
+	token := s.Token
+	if token == nil {
+		token = gcpAccessToken
+	}
+	tok, err := token(ctx)
+	if err != nil {
+		return fmt.Errorf("gcp access token: %w", err)
+	}
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = "https://cloudkms.googleapis.com"
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(endpoint, "/")+"/v1/"+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+tok)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := signerClient(s.Client).Do(req)
+	if err != nil {
+		return err
+	}
+	return readSignerResponse(resp, "cloud kms", out)
+}
+
+func (s *GCPKMSSigner) PublicKey(ctx context.Context) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pub != nil {
+		return s.pub, nil
+	}
+	var out struct {
+		Pem       string `json:"pem"`
+		Algorithm string `json:"algorithm"`
+	}
+	if err := s.do(ctx, http.MethodGet, s.Name+"/publicKey", nil, &out); err != nil {
+		return nil, err
+	}
+	if !(out.Algorithm == "EC_SIGN_P256_SHA256" || out.Algorithm == "EC_SIGN_ED25519" ||
+		strings.HasPrefix(out.Algorithm, "RSA_SIGN_PKCS1_") && strings.HasSuffix(out.Algorithm, "_SHA256")) {
+		return nil, fmt.Errorf("unsupported cloud kms algorithm %s", out.Algorithm)
+	}
+	block, _ := pem.Decode([]byte(out.Pem))
+	if block == nil {
+		return nil, errors.New("cloud kms returned no PEM public key")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	s.pub, s.algorithm = pub, out.Algorithm
+	return pub, nil
+}
+
+func (s *GCPKMSSigner) Sign(ctx context.Context, msg []byte) ([]byte, error) {
+	pub, err := s.PublicKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	in := map[string]any{"data": msg}
+	if _, ok := pub.(ed25519.PublicKey); !ok {
+		digest := sha256.Sum256(msg)
+		in = map[string]any{"digest": map[string][]byte{"sha256": digest[:]}}
+	}
+	var out struct {
+		Signature []byte `json:"signature"`
+	}
+	err = s.do(ctx, http.MethodPost, s.Name+":asymmetricSign", in, &out)
+	return out.Signature, err
+}
+
+var gcpToken struct {
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// gcpAccessToken returns GOOGLE_OAUTH_ACCESS_TOKEN, or a token of the
+// instance's default service account from the metadata server
+// (GCE_METADATA_HOST overrides its address).
+func gcpAccessToken(ctx context.Context) (string, error) {
+	if tok := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); tok != "" {
+		return tok, nil
+	}
+	gcpToken.mu.Lock()
+	defer gcpToken.mu.Unlock()
+	if gcpToken.token != "" && time.Now().Before(gcpToken.expires) {
+		return gcpToken.token, nil
+	}
+
+	host := os.Getenv("GCE_METADATA_HOST")
+	if host == "" {
+		host = "metadata.google.internal"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+host+"/computeMetadata/v1/instance/service-accounts/default/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := signerClient(nil).Do(req)
+	if err != nil {
+		return "", err
+	}
+	var out struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := readSignerResponse(resp, "metadata token", &out); err != nil {
+		return "", err
+	}
+	gcpToken.token = out.AccessToken
+	gcpToken.expires = time.Now().Add(time.Duration(out.ExpiresIn)*time.Second - time.Minute)
+	return out.AccessToken, nil
+}
+
+// PKCS11Signer signs with a key on a PKCS #11 token through OpenSC's
+// pkcs11-tool, so the ledger needs neither cgo nor the vendor's library at
+// build time. Every signature runs the tool once, and records are signed
+// while the ledger's write lock is held, so appends wait on one tool
+// start, login and signature per record. The PIN is passed to the tool in
+// its environment, as pkcs11PINEnv, never on its command line.
+type PKCS11Signer struct {
+	// Module is the path of the token's PKCS #11 library.
+	Module string
+	// Token, Object and ID select the key by token label, key label and
+	// key ID.
+	Token  string
+	Object string
+	ID     []byte
+	PIN    string
+	// Tool is the pkcs11-tool executable, found in PATH by default.
+	Tool string
+
+	mu  sync.Mutex
+	pub crypto.PublicKey
+}
+
+// NewPKCS11Signer parses an RFC 7512 PKCS #11 URI, such as
+// pkcs11:token=ledger;object=signing?module-path=/usr/lib/softhsm/libsofthsm2.so&pin-source=/etc/stateledger/pin.
+// The token, object and id path attributes and the module-path, pin-value
+// and pin-source (a file holding the PIN) query attributes are used.
+func NewPKCS11Signer(uri string) (*PKCS11Signer, error) {
+	rest, ok := strings.CutPrefix(uri, "pkcs11:")
+	if !ok {
+		return nil, fmt.Errorf("%q is not a pkcs11: URI", uri)
+	}
+	path, query, _ := strings.Cut(rest, "?")
+	s := &PKCS11Signer{}
+	attr := func(a string) (string, string, error) {
+		k, v, _ := strings.Cut(a, "=")
+		v, err := url.PathUnescape(v)
+		if err != nil {
+			return "", "", fmt.Errorf("pkcs11 URI attribute %s: %w", k, err)
+		}
+		return k, v, nil
+	}
+	for _, a := range strings.Split(path, ";") {
+		k, v, err := attr(a)
+		if err != nil {
+			return nil, err
+		}
+		switch k {
+		case "token":
+			s.Token = v
+		case "object":
+			s.Object = v
+		case "id":
+			s.ID = []byte(v)
+		}
+	}
+	for _, a := range strings.Split(query, "&") {
+		k, v, err := attr(a)
+		if err != nil {
+			return nil, err
+		}
+		switch k {
+		case "module-path":
+			s.Module = v
+		case "pin-value":
+			s.PIN = v
+		case "pin-source":
+			pin, err := os.ReadFile(v)
+			if err != nil {
+				return nil, fmt.Errorf("pkcs11 pin-source: %w", err)
+			}
+			s.PIN = strings.TrimSpace(string(pin))
+		}
+	}
+	if s.Module == "" {
+		return nil, errors.New("pkcs11 URI needs a module-path")
+	}
+	if s.Object == "" && len(s.ID) == 0 {
+		return nil, errors.New("pkcs11 URI needs an object or id")
+	}
+	return s, nil
+}
+
+// KeyRef returns the URI of the key, without the PIN.
+func (s *PKCS11Signer) KeyRef() string {
+	var attrs []string
+	if s.Token != "" {
+		attrs = append(attrs, "token="+url.PathEscape(s.Token))
+	}
+	if s.Object != "" {
+		attrs = append(attrs, "object="+url.PathEscape(s.Object))
+	}
+	if len(s.ID) > 0 {
+		var id strings.Builder
+		for _, b := range s.ID {
+			fmt.Fprintf(&id, "%%%02x", b)
+		}
+		attrs = append(attrs, "id="+id.String())
+	}
+	return "pkcs11:" + strings.Join(attrs, ";") + "?module-path=" + url.PathEscape(s.Module)
+}
+
+// pkcs11PINEnv is the environment variable that carries the PIN to
+// pkcs11-tool, which reads it for --pin env:NAME. Command lines are
+// readable by every user of the host; a process's environment is not.
+const pkcs11PINEnv = "STATELEDGER_PKCS11_PIN"
+
+func (s *PKCS11Signer) run(ctx context.Context, stdin []byte, args ...string) ([]byte, error) {
+	tool := s.Tool
+	if tool == "" {
+		tool = "pkcs11-tool"
+	}
+	base := []string{"--module", s.Module}
+	if s.Token != "" {
+		base = append(base, "--token-label", s.Token)
+	}
+	if s.Object != "" {
+		base = append(base, "--label", s.Object)
+	}
+	if len(s.ID) > 0 {
+		base = append(base, "--id", hex.EncodeToString(s.ID))
+	}
+	cmd := exec.CommandContext(ctx, tool, append(base, args...)...)
+	cmd.Env = append(cmd.Environ(), pkcs11PINEnv+"="+s.PIN)
+	cmd.Stdin = bytes.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s: %w: %s", tool, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+func (s *PKCS11Signer) PublicKey(ctx context.Context) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pub != nil {
+		return s.pub, nil
+	}
+	der, err := s.run(ctx, nil, "--read-object", "--type", "pubkey")
+	if err != nil {
+		return nil, err
+	}
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		// RSA public keys may be written in PKCS #1 form.
+		rsaPub, rsaErr := x509.ParsePKCS1PublicKey(der)
+		if rsaErr != nil {
+			return nil, fmt.Errorf("pkcs11 public key: %w", err)
+		}
+		pub = rsaPub
+	}
+	s.pub = pub
+	return pub, nil
+}
+
+func (s *PKCS11Signer) Sign(ctx context.Context, msg []byte) ([]byte, error) {
+	pub, err := s.PublicKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"--sign", "--login", "--pin", "env:" + pkcs11PINEnv}
+	input := msg
+	switch pub.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(msg)
+		input = digest[:]
+		args = append(args, "--mechanism", "ECDSA", "--signature-format", "openssl")
+	case *rsa.PublicKey:
+		args = append(args, "--mechanism", "SHA256-RSA-PKCS")
+	case ed25519.PublicKey:
+		args = append(args, "--mechanism", "EDDSA")
+	default:
+		return nil, fmt.Errorf("unsupported pkcs11 key type %T", pub)
+	}
+	return s.run(ctx, input, args...)
+}
@@ -0,0 +1,212 @@
+package ledger
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const sourceKeysSchema = `
+CREATE TABLE IF NOT EXISTS ledger_source_keys (
+	source TEXT PRIMARY KEY,
+	algorithm TEXT NOT NULL,
+	key TEXT NOT NULL,
+	added_at INTEGER NOT NULL
+);
+`
+
+// Source key algorithms.
+const (
+	// SourceKeyHMAC signs with HMAC-SHA256 under a secret shared with the
+	// producer.
+	SourceKeyHMAC = "hmac-sha256"
+	// SourceKeyEd25519 signs with the producer's ed25519 private key; the
+	// ledger only holds the public key.
+	SourceKeyEd25519 = "ed25519"
+)
+
+// ErrInvalidSignature is returned when an inbound body lacks a valid
+// signature by the key of a source it carries records for.
+var ErrInvalidSignature = errors.New("invalid source signature")
+
+// SourceKey is the signing key registered for a source. HMAC secrets are
+// never returned.
+type SourceKey struct {
+	Source    string `json:"source"`
+	Algorithm string `json:"algorithm"`
+	// PublicKey is the base64-encoded ed25519 public key.
+	PublicKey string `json:"public_key,omitempty"`
+	AddedAt   int64  `json:"added_at"`
+}
+
+// SetSourceKey registers or replaces the key that inbound records of
+// source must be signed with. key is the shared secret for SourceKeyHMAC
+// and the base64-encoded public key for SourceKeyEd25519.
+func (l *Ledger) SetSourceKey(source, algorithm, key string) (SourceKey, error) {
+	source = strings.TrimSpace(source)
+	if source == "" {
+		return SourceKey{}, errors.New("source required")
+	}
+	switch algorithm {
+	case SourceKeyHMAC:
+		if len(key) < 16 {
+			return SourceKey{}, errors.New("hmac secret must be at least 16 bytes")
+		}
+	case SourceKeyEd25519:
+		pub, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+		if err != nil || len(pub) != ed25519.PublicKeySize {
+			return SourceKey{}, errors.New("public key must be a base64-encoded ed25519 key")
+		}
+		key = strings.TrimSpace(key)
+	default:
+		return SourceKey{}, fmt.Errorf("unknown algorithm %q: use %s or %s", algorithm, SourceKeyHMAC, SourceKeyEd25519)
+	}
+	if _, err := l.db.Exec(sourceKeysSchema); err != nil {
+		return SourceKey{}, err
+	}
+
+	sk := SourceKey{Source: source, Algorithm: algorithm, AddedAt: time.Now().Unix()}
+	if algorithm == SourceKeyEd25519 {
+		sk.PublicKey = key
+	}
+	_, err := l.db.Exec(`INSERT INTO ledger_source_keys(source, algorithm, key, added_at) VALUES(?, ?, ?, ?)
+		ON CONFLICT(source) DO UPDATE SET algorithm = excluded.algorithm, key = excluded.key, added_at = excluded.added_at`,
+		sk.Source, algorithm, key, sk.AddedAt)
+	return sk, err
+}
+
+// RemoveSourceKey stops requiring signatures for source.
+func (l *Ledger) RemoveSourceKey(source string) error {
+	if _, err := l.db.Exec(sourceKeysSchema); err != nil {
+		return err
+	}
+	res, err := l.db.Exec(`DELETE FROM ledger_source_keys WHERE source = ?`, source)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("source %s has no key", source)
+	}
+	return nil
+}
+
+// SourceKeys lists the registered source keys by source.
+func (l *Ledger) SourceKeys() ([]SourceKey, error) {
+	if _, err := l.db.Exec(sourceKeysSchema); err != nil {
+		return nil, err
+	}
+	rows, err := l.db.Query(`SELECT source, algorithm, key, added_at FROM ledger_source_keys ORDER BY source`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []SourceKey{}
+	for rows.Next() {
+		var sk SourceKey
+		var key string
+		if err := rows.Scan(&sk.Source, &sk.Algorithm, &key, &sk.AddedAt); err != nil {
+			return nil, err
+		}
+		if sk.Algorithm == SourceKeyEd25519 {
+			sk.PublicKey = key
+		}
+		keys = append(keys, sk)
+	}
+	return keys, rows.Err()
+}
+
+// SignBody signs an inbound request body for a source. key is the shared
+// secret for SourceKeyHMAC and the base64-encoded private key (seed or
+// full key) for SourceKeyEd25519. The signature is unpadded base64url.
+func SignBody(algorithm, key string, body []byte) (string, error) {
+	switch algorithm {
+	case SourceKeyHMAC:
+		mac := hmac.New(sha256.New, []byte(key))
+		mac.Write(body)
+		return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+	case SourceKeyEd25519:
+		raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+		if err != nil {
+			return "", errors.New("private key must be base64-encoded")
+		}
+		var priv ed25519.PrivateKey
+		switch len(raw) {
+		case ed25519.SeedSize:
+			priv = ed25519.NewKeyFromSeed(raw)
+		case ed25519.PrivateKeySize:
+			priv = ed25519.PrivateKey(raw)
+		default:
+			return "", errors.New("private key must be an ed25519 seed or private key")
+		}
+		return base64.RawURLEncoding.EncodeToString(ed25519.Sign(priv, body)), nil
+	default:
+		return "", fmt.Errorf("unknown algorithm %q", algorithm)
+	}
+}
+
+// VerifySourceSignatures checks that body carries a valid signature, in
+// sigs by source, for each of sources that has a registered key. Sources
+// without a key need no signature, and signatures of other sources are
+// ignored. The error wraps ErrInvalidSignature.
+func (l *Ledger) VerifySourceSignatures(body []byte, sources []string, sigs map[string]string) error {
+	if len(sources) == 0 {
+		return nil
+	}
+	if _, err := l.db.Exec(sourceKeysSchema); err != nil {
+		return err
+	}
+
+	seen := map[string]bool{}
+	sorted := make([]string, 0, len(sources))
+	for _, s := range sources {
+		if !seen[s] {
+			seen[s] = true
+			sorted = append(sorted, s)
+		}
+	}
+	sort.Strings(sorted)
+
+	for _, source := range sorted {
+		var algorithm, key string
+		err := l.db.QueryRow(`SELECT algorithm, key FROM ledger_source_keys WHERE source = ?`, source).Scan(&algorithm, &key)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		sig, ok := sigs[source]
+		if !ok {
+			return fmt.Errorf("%w: no signature for source %s", ErrInvalidSignature, source)
+		}
+		if !verifyBody(algorithm, key, body, sig) {
+			return fmt.Errorf("%w: signature for source %s does not match", ErrInvalidSignature, source)
+		}
+	}
+	return nil
+}
+
+func verifyBody(algorithm, key string, body []byte, sig string) bool {
+	raw, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	switch algorithm {
+	case SourceKeyHMAC:
+		mac := hmac.New(sha256.New, []byte(key))
+		mac.Write(body)
+		return hmac.Equal(raw, mac.Sum(nil))
+	case SourceKeyEd25519:
+		pub, err := base64.StdEncoding.DecodeString(key)
+		return err == nil && len(pub) == ed25519.PublicKeySize && ed25519.Verify(ed25519.PublicKey(pub), body, raw)
+	}
+	return false
+}
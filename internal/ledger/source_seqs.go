@@ -0,0 +1,156 @@
+package ledger
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+const sourceSeqSchema = `
+CREATE TABLE IF NOT EXISTS ledger_source_seqs (
+	source TEXT PRIMARY KEY,
+	last_seq INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS ledger_record_seqs (
+	record_id INTEGER PRIMARY KEY,
+	source TEXT NOT NULL,
+	seq INTEGER NOT NULL,
+	UNIQUE(source, seq)
+);
+`
+
+// seqTrigger numbers each inserted record within its source, in the
+// statement that inserts it, so every append path assigns a seq and none
+// can commit a record without one.
+const seqTrigger = `
+CREATE TRIGGER IF NOT EXISTS ledger_records_assign_seq AFTER INSERT ON ledger_records
+BEGIN
+	INSERT INTO ledger_source_seqs(source, last_seq) VALUES(NEW.source, 1)
+		ON CONFLICT(source) DO UPDATE SET last_seq = last_seq + 1;
+	INSERT INTO ledger_record_seqs(record_id, source, seq)
+		SELECT NEW.id, NEW.source, last_seq FROM ledger_source_seqs WHERE source = NEW.source;
+END;
+`
+
+// seqBackfill numbers, in ID order after each source's last seq, the
+// records appended before the trigger was installed.
+const seqBackfill = `
+INSERT INTO ledger_record_seqs(record_id, source, seq)
+SELECT r.id, r.source, COALESCE(s.last_seq, 0) + ROW_NUMBER() OVER (PARTITION BY r.source ORDER BY r.id)
+FROM ledger_records r LEFT JOIN ledger_source_seqs s ON s.source = r.source
+WHERE r.id NOT IN (SELECT record_id FROM ledger_record_seqs);
+INSERT INTO ledger_source_seqs(source, last_seq)
+SELECT source, MAX(seq) FROM ledger_record_seqs WHERE true GROUP BY source
+ON CONFLICT(source) DO UPDATE SET last_seq = MAX(last_seq, excluded.last_seq);
+`
+
+// ErrSinceSeqSource is returned for a ListQuery with SinceSeq that does
+// not name exactly one source: seqs only order the records of one source.
+var ErrSinceSeqSource = errors.New("since seq requires exactly one source")
+
+// SourceSeq returns the seq of the last record appended from source, or 0
+// when it has none.
+func (l *Ledger) SourceSeq(source string) (int64, error) {
+	if err := l.ensureSeqSchema(); err != nil {
+		return 0, err
+	}
+	var seq int64
+	err := l.db.QueryRow(`SELECT last_seq FROM ledger_source_seqs WHERE source = ?`, source).Scan(&seq)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return seq, err
+}
+
+// recordSeq returns the seq the trigger gave the record id within q.
+func recordSeq(q interface {
+	QueryRow(query string, args ...any) *sql.Row
+}, id int64) (int64, error) {
+	var seq int64
+	err := q.QueryRow(`SELECT seq FROM ledger_record_seqs WHERE record_id = ?`, id).Scan(&seq)
+	if errors.Is(err, sql.ErrNoRows) || isMissingTable(err) {
+		// Appended before the trigger was installed; the backfill that
+		// installs it numbers the record.
+		return 0, nil
+	}
+	return seq, err
+}
+
+// attachSeqs fills in the per-source seq of each record.
+func (l *Ledger) attachSeqs(q rowQueryer, records []Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+	if err := l.ensureSeqSchema(); err != nil {
+		return err
+	}
+	lo, hi := records[0].ID, records[0].ID
+	for _, rec := range records {
+		lo, hi = min(lo, rec.ID), max(hi, rec.ID)
+	}
+	rows, err := q.Query(`SELECT record_id, seq FROM ledger_record_seqs WHERE record_id BETWEEN ? AND ?`, lo, hi)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	seqs := map[int64]int64{}
+	for rows.Next() {
+		var id, seq int64
+		if err := rows.Scan(&id, &seq); err != nil {
+			return err
+		}
+		seqs[id] = seq
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for i := range records {
+		records[i].Seq = seqs[records[i].ID]
+	}
+	return nil
+}
+
+// rewindSeqs deletes the seqs of the records from ID from on, which tx has
+// removed, and rewinds each source's counter so their seqs are reused.
+func rewindSeqs(tx *sql.Tx, from int64) error {
+	_, err := tx.Exec(`UPDATE ledger_source_seqs SET last_seq = (
+		SELECT MIN(seq) - 1 FROM ledger_record_seqs s WHERE s.source = ledger_source_seqs.source AND s.record_id >= ?
+	) WHERE source IN (SELECT source FROM ledger_record_seqs WHERE record_id >= ?)`, from, from)
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM ledger_record_seqs WHERE record_id >= ?`, from)
+	}
+	if isMissingTable(err) {
+		return nil
+	}
+	return err
+}
+
+// ensureSeqSchema creates the seq tables and installs the trigger, first
+// numbering the records appended before it.
+func (l *Ledger) ensureSeqSchema() error {
+	if l.seqsReady.Load() {
+		return nil
+	}
+	var installed int
+	if err := l.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name = 'ledger_records_assign_seq'`).Scan(&installed); err != nil {
+		return err
+	}
+	if installed == 0 {
+		tx, err := l.db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		for _, stmt := range []string{sourceSeqSchema, seqBackfill, seqTrigger} {
+			if _, err := tx.Exec(stmt); err != nil {
+				return fmt.Errorf("install source seqs: %w", err)
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	l.seqsReady.Store(true)
+	return nil
+}
@@ -0,0 +1,202 @@
+package ledger
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrEventNotFound is returned by an EventSource that holds no event at a
+// mutation's external_ref.
+var ErrEventNotFound = errors.New("event not found at source")
+
+// An EventSource re-fetches events from the system mutations were recorded
+// from, such as a Kafka topic or a log file, by the external_ref each
+// mutation was recorded with.
+type EventSource interface {
+	// Event returns the bytes of the event m was recorded from, or
+	// ErrEventNotFound.
+	Event(ctx context.Context, m MutationRecord) ([]byte, error)
+}
+
+// Kinds of source divergence.
+const (
+	// DivergenceMissing is a mutation whose event the source does not have.
+	DivergenceMissing = "missing"
+	// DivergenceMismatch is a mutation whose hash differs from the hash of
+	// the event at its external_ref.
+	DivergenceMismatch = "mismatch"
+)
+
+// maxSourceDivergences bounds the divergences a source verification lists;
+// the counts include the rest.
+const maxSourceDivergences = 1000
+
+// EventHash returns the hash a mutation records of an event:
+// "sha256:" and the hex SHA-256 of its bytes, as the recording middleware
+// writes it.
+func EventHash(event []byte) string {
+	sum := sha256.Sum256(event)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// SourceDivergence is a mutation the source does not confirm.
+type SourceDivergence struct {
+	LedgerID    int64  `json:"ledger_id"`
+	MutationID  string `json:"mutation_id,omitempty"`
+	ExternalRef string `json:"external_ref"`
+	Kind        string `json:"kind"`
+	LedgerHash  string `json:"ledger_hash"`
+	SourceHash  string `json:"source_hash,omitempty"`
+}
+
+// SourceVerifyResult reports how the mutations checked against a source
+// compare with the events it holds. Skipped mutations have no external_ref
+// or a hash other than SHA-256, and cannot be checked.
+type SourceVerifyResult struct {
+	OK          bool               `json:"ok"`
+	Checked     int                `json:"checked"`
+	Matched     int                `json:"matched"`
+	Missing     int                `json:"missing"`
+	Mismatched  int                `json:"mismatched"`
+	Skipped     int                `json:"skipped"`
+	Divergences []SourceDivergence `json:"divergences"`
+	Timestamp   int64              `json:"timestamp"`
+}
+
+// VerifyMutationSource re-fetches the event of every mutation matching f
+// from src and checks that its hash is the one the ledger recorded, which
+// proves the ledger reflects what the source actually emitted. f.Until
+// defaults to now; f.Offset and f.Limit are ignored. OK is false when any
+// mutation is missing at the source or differs from it.
+func (l *Ledger) VerifyMutationSource(ctx context.Context, src EventSource, f MutationFilter) (SourceVerifyResult, error) {
+	if f.Until <= 0 {
+		f.Until = time.Now().Unix()
+	}
+	mutations, err := l.matchMutations(f)
+	if err != nil {
+		return SourceVerifyResult{}, err
+	}
+	res := SourceVerifyResult{Divergences: []SourceDivergence{}}
+	for _, m := range mutations {
+		if err := ctx.Err(); err != nil {
+			return SourceVerifyResult{}, err
+		}
+		want, ok := sha256Hex(m.Hash)
+		if strings.TrimSpace(m.ExternalRef) == "" || !ok {
+			res.Skipped++
+			continue
+		}
+		res.Checked++
+		d := SourceDivergence{LedgerID: m.LedgerID, MutationID: m.ID, ExternalRef: m.ExternalRef, LedgerHash: m.Hash}
+		event, err := src.Event(ctx, m)
+		switch {
+		case errors.Is(err, ErrEventNotFound):
+			res.Missing++
+			d.Kind = DivergenceMissing
+		case err != nil:
+			return SourceVerifyResult{}, err
+		default:
+			got := EventHash(event)
+			if got[len("sha256:"):] == want {
+				res.Matched++
+				continue
+			}
+			res.Mismatched++
+			d.Kind, d.SourceHash = DivergenceMismatch, got
+		}
+		if len(res.Divergences) < maxSourceDivergences {
+			res.Divergences = append(res.Divergences, d)
+		}
+	}
+	res.OK = res.Missing == 0 && res.Mismatched == 0
+	res.Timestamp = time.Now().Unix()
+	return res, nil
+}
+
+// sha256Hex returns the lowercase hex digest of a mutation hash written as
+// "sha256:<hex>" or bare hex.
+func sha256Hex(hash string) (string, bool) {
+	digest := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(hash), "sha256:"))
+	if len(digest) != sha256.Size*2 {
+		return "", false
+	}
+	if _, err := hex.DecodeString(digest); err != nil {
+		return "", false
+	}
+	return digest, true
+}
+
+// LogFileSource is an EventSource over a log file with one event per line,
+// addressed by the 1-based line number in the external_ref offset, as in
+// app.log:42. Lines are hashed without their line ending.
+type LogFileSource struct {
+	Path string
+
+	once  sync.Once
+	lines []int64 // the offset of each line, then the file size
+	err   error
+}
+
+// Event returns line m.Offset of the file.
+func (s *LogFileSource) Event(ctx context.Context, m MutationRecord) ([]byte, error) {
+	s.once.Do(s.index)
+	if s.err != nil {
+		return nil, s.err
+	}
+	if _, _, ok := parseExternalRef(m.ExternalRef); !ok || m.Offset < 1 || m.Offset >= int64(len(s.lines)) {
+		return nil, ErrEventNotFound
+	}
+	f, err := os.Open(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	start, end := s.lines[m.Offset-1], s.lines[m.Offset]
+	line := make([]byte, end-start)
+	if _, err := f.ReadAt(line, start); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r")), nil
+}
+
+// index records where each line of the file starts.
+func (s *LogFileSource) index() {
+	f, err := os.Open(s.Path)
+	if err != nil {
+		s.err = err
+		return
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	var offset int64
+	lineStart := true
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if len(chunk) > 0 && lineStart {
+			s.lines = append(s.lines, offset)
+		}
+		offset += int64(len(chunk))
+		// A line longer than the buffer comes in several chunks.
+		lineStart = err == nil
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			s.err = err
+			return
+		}
+	}
+	s.lines = append(s.lines, offset)
+}
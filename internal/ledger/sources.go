@@ -0,0 +1,290 @@
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const sourcesSchema = `
+CREATE TABLE IF NOT EXISTS ledger_sources (
+	name TEXT PRIMARY KEY,
+	kinds TEXT NOT NULL,
+	cadence INTEGER NOT NULL,
+	created_at INTEGER NOT NULL
+);
+`
+
+// Source statuses reported by SourceStatus.
+const (
+	SourceOK      = "ok"
+	SourceOverdue = "overdue"
+	SourceMissing = "missing"
+	SourceUnknown = "unknown"
+	SourceNew     = "new"
+)
+
+// DefaultNewSourceWindow is how long an unregistered source is reported as
+// new before it is reported as unknown.
+const DefaultNewSourceWindow = 24 * time.Hour
+
+// SourceSpec declares a known source and the cadence at which it is
+// expected to capture each of its kinds.
+type SourceSpec struct {
+	Name string `json:"name"`
+	// Kinds are the record types the source captures (e.g. "config").
+	Kinds []string `json:"kinds"`
+	// Cadence is the maximum expected gap between captures, in seconds.
+	Cadence   int64 `json:"cadence"`
+	CreatedAt int64 `json:"created_at"`
+}
+
+// KindStatus is the capture state of one kind of a source.
+type KindStatus struct {
+	Kind      string `json:"kind"`
+	Status    string `json:"status"`
+	Count     int64  `json:"count"`
+	FirstSeen int64  `json:"first_seen,omitempty"`
+	LastSeen  int64  `json:"last_seen,omitempty"`
+	// Overdue is how many seconds past its cadence the kind is.
+	Overdue int64 `json:"overdue,omitempty"`
+}
+
+// SourceStatus is the capture state of one source.
+type SourceStatus struct {
+	Name       string       `json:"name"`
+	Registered bool         `json:"registered"`
+	Status     string       `json:"status"`
+	Cadence    int64        `json:"cadence,omitempty"`
+	Kinds      []KindStatus `json:"kinds"`
+}
+
+// SourceReport compares the registry with the records captured up to At.
+type SourceReport struct {
+	At      int64          `json:"at"`
+	Sources []SourceStatus `json:"sources"`
+}
+
+// RegisterSource adds spec to the registry or replaces the existing entry
+// with the same name.
+func (l *Ledger) RegisterSource(spec SourceSpec) error {
+	spec.Name = strings.TrimSpace(spec.Name)
+	if spec.Name == "" {
+		return errors.New("source name required")
+	}
+	if spec.Cadence <= 0 {
+		return errors.New("cadence must be positive")
+	}
+	kinds := normalizeKinds(spec.Kinds)
+	if len(kinds) == 0 {
+		return errors.New("at least one kind required")
+	}
+	if spec.CreatedAt == 0 {
+		spec.CreatedAt = time.Now().Unix()
+	}
+	if _, err := l.db.Exec(sourcesSchema); err != nil {
+		return err
+	}
+	_, err := l.db.Exec(`INSERT INTO ledger_sources(name, kinds, cadence, created_at) VALUES(?, ?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET kinds = excluded.kinds, cadence = excluded.cadence`,
+		spec.Name, strings.Join(kinds, ","), spec.Cadence, spec.CreatedAt)
+	return err
+}
+
+// RemoveSource deletes a source from the registry.
+func (l *Ledger) RemoveSource(name string) error {
+	if _, err := l.db.Exec(sourcesSchema); err != nil {
+		return err
+	}
+	res, err := l.db.Exec(`DELETE FROM ledger_sources WHERE name = ?`, name)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("source %s not registered", name)
+	}
+	return nil
+}
+
+// Sources returns the registered sources ordered by name.
+func (l *Ledger) Sources() ([]SourceSpec, error) {
+	if _, err := l.db.Exec(sourcesSchema); err != nil {
+		return nil, err
+	}
+	rows, err := l.db.Query(`SELECT name, kinds, cadence, created_at FROM ledger_sources ORDER BY name ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	specs := []SourceSpec{}
+	for rows.Next() {
+		var spec SourceSpec
+		var kinds string
+		if err := rows.Scan(&spec.Name, &kinds, &spec.Cadence, &spec.CreatedAt); err != nil {
+			return nil, err
+		}
+		spec.Kinds = strings.Split(kinds, ",")
+		specs = append(specs, spec)
+	}
+	return specs, rows.Err()
+}
+
+// SourceStatus reports, as of at, which registered sources are overdue or
+// have never captured, and which sources captured records without being
+// registered. Unregistered sources first seen within newWindow of at are
+// reported as new, older ones as unknown.
+func (l *Ledger) SourceStatus(at int64, newWindow time.Duration) (SourceReport, error) {
+	if at <= 0 {
+		at = time.Now().Unix()
+	}
+	specs, err := l.Sources()
+	if err != nil {
+		return SourceReport{}, err
+	}
+
+	type seen struct {
+		count       int64
+		first, last int64
+	}
+	observed := map[string]map[string]*seen{}
+	observe := func(source, kind string, count, first, last int64) {
+		kinds, ok := observed[source]
+		if !ok {
+			kinds = map[string]*seen{}
+			observed[source] = kinds
+		}
+		s, ok := kinds[kind]
+		if !ok {
+			kinds[kind] = &seen{count: count, first: first, last: last}
+			return
+		}
+		s.count += count
+		s.first = min(s.first, first)
+		s.last = max(s.last, last)
+	}
+
+	archives, err := l.Archives()
+	if err != nil {
+		return SourceReport{}, err
+	}
+	if len(archives) > 0 {
+		recs, err := l.archivedRecords(ListQuery{Until: at})
+		if err != nil {
+			return SourceReport{}, err
+		}
+		for _, rec := range recs {
+			observe(rec.Source, rec.Type, 1, rec.Timestamp, rec.Timestamp)
+		}
+	}
+
+	rows, err := l.db.Query(`SELECT source, type, COUNT(*), MIN(ts), MAX(ts) FROM ledger_records WHERE ts <= ? GROUP BY source, type`, at)
+	if err != nil {
+		return SourceReport{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var source, kind string
+		var count, first, last int64
+		if err := rows.Scan(&source, &kind, &count, &first, &last); err != nil {
+			return SourceReport{}, err
+		}
+		observe(source, kind, count, first, last)
+	}
+	if err := rows.Err(); err != nil {
+		return SourceReport{}, err
+	}
+
+	report := SourceReport{At: at, Sources: []SourceStatus{}}
+	registered := map[string]bool{}
+	for _, spec := range specs {
+		registered[spec.Name] = true
+		st := SourceStatus{Name: spec.Name, Registered: true, Status: SourceOK, Cadence: spec.Cadence, Kinds: []KindStatus{}}
+		for _, kind := range spec.Kinds {
+			ks := KindStatus{Kind: kind, Status: SourceMissing}
+			if s := observed[spec.Name][kind]; s != nil {
+				ks.Count, ks.FirstSeen, ks.LastSeen = s.count, s.first, s.last
+				ks.Status = SourceOK
+				if gap := at - s.last; gap > spec.Cadence {
+					ks.Status = SourceOverdue
+					ks.Overdue = gap - spec.Cadence
+				}
+			}
+			st.Status = worseSourceStatus(st.Status, ks.Status)
+			st.Kinds = append(st.Kinds, ks)
+		}
+		report.Sources = append(report.Sources, st)
+	}
+
+	for source, kinds := range observed {
+		if registered[source] {
+			continue
+		}
+		st := SourceStatus{Name: source, Status: SourceNew, Kinds: []KindStatus{}}
+		for kind, s := range kinds {
+			st.Kinds = append(st.Kinds, KindStatus{Kind: kind, Status: SourceUnknown, Count: s.count, FirstSeen: s.first, LastSeen: s.last})
+			if at-s.first > int64(newWindow/time.Second) {
+				st.Status = SourceUnknown
+			}
+		}
+		sort.Slice(st.Kinds, func(i, j int) bool { return st.Kinds[i].Kind < st.Kinds[j].Kind })
+		report.Sources = append(report.Sources, st)
+	}
+	sort.Slice(report.Sources, func(i, j int) bool {
+		return report.Sources[i].Name < report.Sources[j].Name
+	})
+
+	return report, nil
+}
+
+// Warnings describes every source that is not ok, for the advisory.
+func (r SourceReport) Warnings() []string {
+	var warnings []string
+	for _, st := range r.Sources {
+		switch st.Status {
+		case SourceOK:
+			continue
+		case SourceNew:
+			warnings = append(warnings, "new unregistered source: "+st.Name)
+			continue
+		case SourceUnknown:
+			warnings = append(warnings, "unregistered source: "+st.Name)
+			continue
+		}
+		for _, ks := range st.Kinds {
+			switch ks.Status {
+			case SourceOverdue:
+				warnings = append(warnings, fmt.Sprintf("source %s overdue for %s by %ds", st.Name, ks.Kind, ks.Overdue))
+			case SourceMissing:
+				warnings = append(warnings, fmt.Sprintf("source %s has never captured %s", st.Name, ks.Kind))
+			}
+		}
+	}
+	return warnings
+}
+
+// worseSourceStatus returns the more severe of two registered statuses.
+func worseSourceStatus(a, b string) string {
+	rank := map[string]int{SourceOK: 0, SourceOverdue: 1, SourceMissing: 2}
+	if rank[b] > rank[a] {
+		return b
+	}
+	return a
+}
+
+func normalizeKinds(kinds []string) []string {
+	set := map[string]bool{}
+	var out []string
+	for _, k := range kinds {
+		k = strings.TrimSpace(k)
+		if k == "" || set[k] {
+			continue
+		}
+		set[k] = true
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
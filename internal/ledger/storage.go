@@ -0,0 +1,229 @@
+package ledger
+
+import (
+	"sort"
+	"time"
+)
+
+// Defaults for StorageReport.
+const (
+	DefaultStorageTop    = 10
+	DefaultStorageSample = 200
+	DefaultGrowthWindow  = 30 * 24 * time.Hour
+)
+
+// StorageOptions configures StorageReport.
+type StorageOptions struct {
+	// Top is how many of the largest payloads to list.
+	Top int
+	// Sample is how many recent payloads of each type are compressed to
+	// estimate its compression ratio.
+	Sample int
+	// GrowthWindow is the period of recent records the growth projection is
+	// based on.
+	GrowthWindow time.Duration
+	// Now is the unix time the growth window ends at. Defaults to the
+	// current time.
+	Now int64
+}
+
+// StorageReport describes where a ledger's space goes.
+type StorageReport struct {
+	// DatabaseBytes is the size of the SQLite database, of which FreeBytes
+	// are unused pages that VACUUM would release.
+	DatabaseBytes int64 `json:"database_bytes"`
+	FreeBytes     int64 `json:"free_bytes"`
+	Records       int64 `json:"records"`
+	PayloadBytes  int64 `json:"payload_bytes"`
+	// ArchivedRecords have been moved to archive segments and take no space
+	// in the database.
+	ArchivedRecords int64 `json:"archived_records"`
+	// CompressionRatio is the estimated ratio of payload bytes to their gzip
+	// size across all sampled payloads. Payloads are stored uncompressed.
+	CompressionRatio float64       `json:"compression_ratio"`
+	Types            []TypeStorage `json:"types"`
+	Largest          []PayloadSize `json:"largest"`
+	Growth           StorageGrowth `json:"growth"`
+}
+
+// TypeStorage is the space used by one record type.
+type TypeStorage struct {
+	Type         string `json:"type"`
+	Records      int64  `json:"records"`
+	PayloadBytes int64  `json:"payload_bytes"`
+	AvgBytes     int64  `json:"avg_bytes"`
+	MaxBytes     int64  `json:"max_bytes"`
+	// Sampled payloads compressed to SampledCompressed bytes from
+	// SampledBytes.
+	Sampled           int     `json:"sampled"`
+	SampledBytes      int64   `json:"sampled_bytes"`
+	SampledCompressed int64   `json:"sampled_compressed_bytes"`
+	CompressionRatio  float64 `json:"compression_ratio"`
+}
+
+// PayloadSize identifies a large record.
+type PayloadSize struct {
+	ID        int64  `json:"id"`
+	Timestamp int64  `json:"timestamp"`
+	Type      string `json:"type"`
+	Source    string `json:"source"`
+	Bytes     int64  `json:"bytes"`
+}
+
+// StorageGrowth projects payload growth from the rate over a recent window.
+type StorageGrowth struct {
+	WindowStart   int64   `json:"window_start"`
+	WindowEnd     int64   `json:"window_end"`
+	RecordsPerDay float64 `json:"records_per_day"`
+	BytesPerDay   float64 `json:"bytes_per_day"`
+	// Projected payload bytes after 30, 90 and 365 more days at that rate.
+	Projected30d  int64 `json:"projected_30d_bytes"`
+	Projected90d  int64 `json:"projected_90d_bytes"`
+	Projected365d int64 `json:"projected_365d_bytes"`
+}
+
+// StorageReport reports the size of the database and of the payloads of
+// each record type, the largest payloads, estimated compression ratios and
+// projected growth.
+func (l *Ledger) StorageReport(opts StorageOptions) (StorageReport, error) {
+	if opts.Top <= 0 {
+		opts.Top = DefaultStorageTop
+	}
+	if opts.Sample <= 0 {
+		opts.Sample = DefaultStorageSample
+	}
+	if opts.GrowthWindow <= 0 {
+		opts.GrowthWindow = DefaultGrowthWindow
+	}
+	if opts.Now == 0 {
+		opts.Now = time.Now().Unix()
+	}
+
+	report := StorageReport{Types: []TypeStorage{}}
+	var pageSize, pages, free int64
+	for pragma, dst := range map[string]*int64{"page_size": &pageSize, "page_count": &pages, "freelist_count": &free} {
+		if err := l.db.QueryRow(`PRAGMA ` + pragma).Scan(dst); err != nil {
+			return report, err
+		}
+	}
+	report.DatabaseBytes = pageSize * pages
+	report.FreeBytes = pageSize * free
+
+	if err := l.db.QueryRow(`SELECT COALESCE(SUM(count), 0) FROM ledger_archives`).Scan(&report.ArchivedRecords); err != nil {
+		return report, err
+	}
+
+	rows, err := l.db.Query(`SELECT type, COUNT(*), SUM(LENGTH(CAST(payload AS BLOB))), MAX(LENGTH(CAST(payload AS BLOB)))
+		FROM ledger_records GROUP BY type`)
+	if err != nil {
+		return report, err
+	}
+	for rows.Next() {
+		var t TypeStorage
+		if err := rows.Scan(&t.Type, &t.Records, &t.PayloadBytes, &t.MaxBytes); err != nil {
+			rows.Close()
+			return report, err
+		}
+		t.AvgBytes = t.PayloadBytes / t.Records
+		report.Records += t.Records
+		report.PayloadBytes += t.PayloadBytes
+		report.Types = append(report.Types, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return report, err
+	}
+	sort.Slice(report.Types, func(i, j int) bool {
+		if report.Types[i].PayloadBytes != report.Types[j].PayloadBytes {
+			return report.Types[i].PayloadBytes > report.Types[j].PayloadBytes
+		}
+		return report.Types[i].Type < report.Types[j].Type
+	})
+
+	var sampled, compressed int64
+	for i := range report.Types {
+		if err := l.sampleCompression(&report.Types[i], opts.Sample); err != nil {
+			return report, err
+		}
+		sampled += report.Types[i].SampledBytes
+		compressed += report.Types[i].SampledCompressed
+	}
+	report.CompressionRatio = ratio(sampled, compressed)
+
+	if report.Largest, err = l.largestPayloads(opts.Top); err != nil {
+		return report, err
+	}
+	if report.Growth, err = l.storageGrowth(report.PayloadBytes, opts); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// sampleCompression gzips the most recent payloads of t's type to estimate
+// how well they compress.
+func (l *Ledger) sampleCompression(t *TypeStorage, sample int) error {
+	rows, err := l.db.Query(`SELECT payload FROM ledger_records WHERE type = ? ORDER BY id DESC LIMIT ?`, t.Type, sample)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var payload string
+		if err := rows.Scan(&payload); err != nil {
+			return err
+		}
+		gz, err := CompressPayload(payload)
+		if err != nil {
+			return err
+		}
+		t.Sampled++
+		t.SampledBytes += int64(len(payload))
+		t.SampledCompressed += int64(len(gz))
+	}
+	t.CompressionRatio = ratio(t.SampledBytes, t.SampledCompressed)
+	return rows.Err()
+}
+
+func (l *Ledger) largestPayloads(top int) ([]PayloadSize, error) {
+	rows, err := l.db.Query(`SELECT id, ts, type, source, LENGTH(CAST(payload AS BLOB)) AS size
+		FROM ledger_records ORDER BY size DESC, id ASC LIMIT ?`, top)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	largest := []PayloadSize{}
+	for rows.Next() {
+		var p PayloadSize
+		if err := rows.Scan(&p.ID, &p.Timestamp, &p.Type, &p.Source, &p.Bytes); err != nil {
+			return nil, err
+		}
+		largest = append(largest, p)
+	}
+	return largest, rows.Err()
+}
+
+func (l *Ledger) storageGrowth(current int64, opts StorageOptions) (StorageGrowth, error) {
+	g := StorageGrowth{WindowStart: opts.Now - int64(opts.GrowthWindow/time.Second), WindowEnd: opts.Now}
+	var records, bytes int64
+	err := l.db.QueryRow(`SELECT COUNT(*), COALESCE(SUM(LENGTH(CAST(payload AS BLOB))), 0)
+		FROM ledger_records WHERE ts > ? AND ts <= ?`, g.WindowStart, g.WindowEnd).Scan(&records, &bytes)
+	if err != nil {
+		return g, err
+	}
+	days := opts.GrowthWindow.Hours() / 24
+	g.RecordsPerDay = float64(records) / days
+	g.BytesPerDay = float64(bytes) / days
+	g.Projected30d = current + int64(g.BytesPerDay*30)
+	g.Projected90d = current + int64(g.BytesPerDay*90)
+	g.Projected365d = current + int64(g.BytesPerDay*365)
+	return g, nil
+}
+
+// ratio returns raw/compressed rounded to two places, or 0 when nothing
+// was compressed.
+func ratio(raw, compressed int64) float64 {
+	if compressed == 0 {
+		return 0
+	}
+	return float64(raw*100/compressed) / 100
+}
@@ -0,0 +1,42 @@
+package ledger
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	// Time zone names resolve in containers without a zoneinfo database.
+	_ "time/tzdata"
+)
+
+// ParseTimeZone returns the time zone that reports are presented in: UTC
+// for "" or "UTC", a fixed offset such as "+05:30" or "-0800", or an IANA
+// name such as "Europe/Berlin". Time zones only change how times are
+// formatted; the ledger stores and compares Unix seconds.
+func ParseTimeZone(name string) (*time.Location, error) {
+	switch {
+	case name == "" || strings.EqualFold(name, "UTC") || name == "Z":
+		return time.UTC, nil
+	case name[0] == '+' || name[0] == '-':
+		for _, layout := range []string{"-07:00", "-0700", "-07"} {
+			if t, err := time.Parse(layout, name); err == nil {
+				_, offset := t.Zone()
+				return time.FixedZone(name, offset), nil
+			}
+		}
+		return nil, fmt.Errorf("invalid time zone offset %q: want ±hh:mm", name)
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone %q: want UTC, an offset such as +05:30 or an IANA name such as Europe/Berlin", name)
+	}
+	return loc, nil
+}
+
+// FormatTime formats Unix seconds as RFC 3339 in loc, or in UTC when loc
+// is nil.
+func FormatTime(unix int64, loc *time.Location) string {
+	if loc == nil {
+		loc = time.UTC
+	}
+	return time.Unix(unix, 0).In(loc).Format(time.RFC3339)
+}
@@ -0,0 +1,428 @@
+package ledger
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Retr0-XD/StateLedger/internal/collectors"
+)
+
+const typesSchema = `
+CREATE TABLE IF NOT EXISTS ledger_types (
+	type TEXT PRIMARY KEY,
+	description TEXT NOT NULL DEFAULT '',
+	created_at INTEGER NOT NULL,
+	canonical INTEGER NOT NULL DEFAULT 0
+);
+`
+
+// typesTrigger is the trigger that enforces the type registry. It is only
+// installed while enforcement is on.
+const typesTrigger = "ledger_records_known_type"
+
+// unknownTypeMessage is raised by typesTrigger.
+const unknownTypeMessage = "unknown record type"
+
+// ErrUnknownType is returned when appending a record whose type is not in
+// the registry while enforcement is on.
+var ErrUnknownType = errors.New(unknownTypeMessage)
+
+// TypeSpec registers a custom record type. A Name ending in '*' allows
+// every type with that prefix, e.g. "http.*" for the HTTP middleware.
+type TypeSpec struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	CreatedAt   int64  `json:"created_at"`
+	// Canonical stores the JSON payloads of the type in canonical form, so
+	// equal payloads hash the same whatever their key order or layout.
+	Canonical bool `json:"canonical,omitempty"`
+}
+
+// TypeInfo describes one record type known to the registry or found on
+// records.
+type TypeInfo struct {
+	Name string `json:"name"`
+	// Builtin types are the collector kinds, the schema type and the
+	// administrative audit and legal hold types; they are always allowed.
+	Builtin bool `json:"builtin,omitempty"`
+	// Registered is false for types found on records but not allowed by the
+	// registry, such as a misspelled built-in.
+	Registered  bool   `json:"registered"`
+	Description string `json:"description,omitempty"`
+	// Canonical is set for types registered with TypeSpec.Canonical.
+	Canonical bool `json:"canonical,omitempty"`
+	// Schema is set when the type has a built-in or registered payload
+	// schema. Types with a registered schema are allowed.
+	Schema  bool  `json:"schema,omitempty"`
+	Records int64 `json:"records"`
+	// Suggestion is the closest allowed type for an unregistered one.
+	Suggestion string `json:"suggestion,omitempty"`
+}
+
+// TypeRegistry lists the known record types and whether appends of other
+// types are rejected.
+type TypeRegistry struct {
+	Enforced bool       `json:"enforced"`
+	Types    []TypeInfo `json:"types"`
+}
+
+// builtinTypes returns the types that are always allowed, sorted.
+func builtinTypes() []string {
+	types := []string{SchemaRecordType, AdminRequestRecordType, AdminApprovalRecordType, AdminExecutionRecordType, HoldRecordType, HoldReleaseRecordType, AnchorRecordType, KeyRotatedRecordType, CredentialCreateRecordType, CredentialRevokeRecordType, CredentialRestoreRecordType, PolicyRecordType, AnomalyRecordType}
+	for t := range collectors.BuiltinSchemas() {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// RegisterType adds spec to the type registry or updates the description
+// and canonical setting of an existing entry. Records appended before a
+// type was made canonical keep their payloads as they were.
+func (l *Ledger) RegisterType(spec TypeSpec) error {
+	spec.Name = strings.TrimSpace(spec.Name)
+	if err := validTypeName(spec.Name); err != nil {
+		return err
+	}
+	for _, t := range builtinTypes() {
+		if t == spec.Name {
+			return fmt.Errorf("%s is a built-in type", spec.Name)
+		}
+	}
+	if spec.CreatedAt == 0 {
+		spec.CreatedAt = time.Now().Unix()
+	}
+	if err := l.ensureTypesSchema(); err != nil {
+		return err
+	}
+	_, err := l.db.Exec(`INSERT INTO ledger_types(type, description, created_at, canonical) VALUES(?, ?, ?, ?)
+		ON CONFLICT(type) DO UPDATE SET description = excluded.description, canonical = excluded.canonical`,
+		spec.Name, spec.Description, spec.CreatedAt, spec.Canonical)
+	l.canonicalTypes.Store(nil)
+	return err
+}
+
+// validTypeName allows letters, digits, '.', '_', '-' and ':', with an
+// optional trailing '*'.
+func validTypeName(name string) error {
+	if name == "" || name == "*" {
+		return errors.New("type name required")
+	}
+	for i, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', strings.ContainsRune("._-:", c):
+		case c == '*' && i == len(name)-1:
+		default:
+			return fmt.Errorf("invalid type name %q: use letters, digits, '.', '_', '-', ':' and an optional trailing '*'", name)
+		}
+	}
+	return nil
+}
+
+// RemoveType deletes a custom type from the registry. Records of the type
+// are kept, but while enforcement is on no more can be appended.
+func (l *Ledger) RemoveType(name string) error {
+	if err := l.ensureTypesSchema(); err != nil {
+		return err
+	}
+	res, err := l.db.Exec(`DELETE FROM ledger_types WHERE type = ?`, name)
+	if err != nil {
+		return err
+	}
+	l.canonicalTypes.Store(nil)
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("type %s not registered", name)
+	}
+	return nil
+}
+
+// EnforceTypes turns rejection of unregistered record types on or off.
+// Enforcement is a trigger on ledger_records, so it applies to every
+// process writing to the database. Types with a registered payload schema
+// are allowed without a separate registration.
+func (l *Ledger) EnforceTypes(on bool) error {
+	if !on {
+		_, err := l.db.Exec(`DROP TRIGGER IF EXISTS ` + typesTrigger)
+		return err
+	}
+	if err := l.ensureSchemaRegistry(); err != nil {
+		return err
+	}
+	if err := l.ensureTypesSchema(); err != nil {
+		return err
+	}
+	builtins := builtinTypes()
+	quoted := make([]string, len(builtins))
+	for i, t := range builtins {
+		quoted[i] = "'" + t + "'"
+	}
+	_, err := l.db.Exec(`
+DROP TRIGGER IF EXISTS ` + typesTrigger + `;
+CREATE TRIGGER ` + typesTrigger + ` BEFORE INSERT ON ledger_records
+WHEN NEW.type NOT IN (` + strings.Join(quoted, ", ") + `)
+	AND NOT EXISTS (SELECT 1 FROM ledger_types WHERE NEW.type = type OR (type LIKE '%*' AND NEW.type GLOB type))
+	AND NOT EXISTS (SELECT 1 FROM ledger_schemas WHERE type = NEW.type)
+BEGIN
+	SELECT RAISE(ABORT, '` + unknownTypeMessage + `');
+END;`)
+	return err
+}
+
+// TypesEnforced reports whether unregistered record types are rejected.
+func (l *Ledger) TypesEnforced() (bool, error) {
+	var n int
+	err := l.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name = ?`, typesTrigger).Scan(&n)
+	return n > 0, err
+}
+
+// Types returns the built-in, registered and schema-described record types
+// together with any other types found on records, sorted by name.
+func (l *Ledger) Types() (TypeRegistry, error) {
+	reg := TypeRegistry{Types: []TypeInfo{}}
+	var err error
+	if reg.Enforced, err = l.TypesEnforced(); err != nil {
+		return reg, err
+	}
+	if err := l.ensureTypesSchema(); err != nil {
+		return reg, err
+	}
+	if err := l.ensureSchemaRegistry(); err != nil {
+		return reg, err
+	}
+
+	byName := map[string]*TypeInfo{}
+	info := func(name string) *TypeInfo {
+		if t, ok := byName[name]; ok {
+			return t
+		}
+		t := &TypeInfo{Name: name}
+		byName[name] = t
+		return t
+	}
+	for _, name := range builtinTypes() {
+		t := info(name)
+		t.Builtin, t.Registered = true, true
+		_, t.Schema = collectors.BuiltinSchemas()[name]
+	}
+
+	var patterns []string
+	rows, err := l.db.Query(`SELECT type, description, canonical FROM ledger_types`)
+	if err != nil {
+		return reg, err
+	}
+	for rows.Next() {
+		var name, desc string
+		var canonical bool
+		if err := rows.Scan(&name, &desc, &canonical); err != nil {
+			rows.Close()
+			return reg, err
+		}
+		t := info(name)
+		t.Registered, t.Description, t.Canonical = true, desc, canonical
+		if strings.HasSuffix(name, "*") {
+			patterns = append(patterns, strings.TrimSuffix(name, "*"))
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return reg, err
+	}
+
+	rows, err = l.db.Query(`SELECT DISTINCT type FROM ledger_schemas`)
+	if err != nil {
+		return reg, err
+	}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return reg, err
+		}
+		t := info(name)
+		t.Registered, t.Schema = true, true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return reg, err
+	}
+
+	rows, err = l.reader().Query(`SELECT type, COUNT(*) FROM ledger_records GROUP BY type`)
+	if err != nil {
+		return reg, err
+	}
+	for rows.Next() {
+		var name string
+		var count int64
+		if err := rows.Scan(&name, &count); err != nil {
+			rows.Close()
+			return reg, err
+		}
+		t := info(name)
+		t.Records = count
+		for _, prefix := range patterns {
+			if strings.HasPrefix(name, prefix) {
+				t.Registered = true
+			}
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return reg, err
+	}
+
+	var allowed []string
+	for name, t := range byName {
+		if t.Registered && !strings.HasSuffix(name, "*") {
+			allowed = append(allowed, name)
+		}
+	}
+	sort.Strings(allowed)
+	for _, t := range byName {
+		if !t.Registered {
+			t.Suggestion = closestType(t.Name, allowed)
+		}
+		reg.Types = append(reg.Types, *t)
+	}
+	sort.Slice(reg.Types, func(i, j int) bool { return reg.Types[i].Name < reg.Types[j].Name })
+	return reg, nil
+}
+
+// unknownTypeError turns the error raised by typesTrigger into
+// ErrUnknownType, suggesting the closest allowed type. q is the database or
+// the transaction the insert failed in.
+func unknownTypeError(q interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}, err error, recordType string) error {
+	if err == nil || !strings.Contains(err.Error(), unknownTypeMessage) {
+		return err
+	}
+	allowed := builtinTypes()
+	if rows, qerr := q.Query(`SELECT type FROM ledger_types WHERE type NOT LIKE '%*' UNION SELECT type FROM ledger_schemas`); qerr == nil {
+		for rows.Next() {
+			var name string
+			if rows.Scan(&name) == nil {
+				allowed = append(allowed, name)
+			}
+		}
+		rows.Close()
+	}
+	if s := closestType(recordType, allowed); s != "" {
+		return fmt.Errorf("%w %q (did you mean %q?)", ErrUnknownType, recordType, s)
+	}
+	return fmt.Errorf("%w %q; register it with `stateledger types add`", ErrUnknownType, recordType)
+}
+
+// closestType returns the candidate within edit distance 2 of name, or "".
+func closestType(name string, candidates []string) string {
+	best, bestDist := "", 3
+	for _, c := range candidates {
+		if d := editDistance(name, c); d < bestDist {
+			best, bestDist = c, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// canonicalize rewrites the payload of in as canonical JSON when its type
+// is registered as canonical. Such payloads must be JSON.
+func (l *Ledger) canonicalize(q rowQueryer, in *RecordInput) error {
+	if strings.TrimSpace(in.Payload) == "" {
+		return nil
+	}
+	types, err := l.canonicalTypeNames(q)
+	if err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(types, func(t string) bool {
+		return t == in.Type || strings.HasSuffix(t, "*") && strings.HasPrefix(in.Type, strings.TrimSuffix(t, "*"))
+	}) {
+		return nil
+	}
+	canonical, err := collectors.CanonicalizeJSON([]byte(in.Payload))
+	if err != nil {
+		return fmt.Errorf("%w: %s: payloads of a canonical type must be JSON: %v", ErrInvalidPayload, in.Type, err)
+	}
+	in.Payload = string(canonical)
+	return nil
+}
+
+// canonicalTypeNames returns the registered types and type patterns whose
+// payloads are canonicalized. They are loaded once per Ledger and again
+// after RegisterType or RemoveType, so another process's changes apply
+// once the ledger is reopened. They are read through q, which may be the
+// transaction appending, and a registry too old to have canonical types
+// has none.
+func (l *Ledger) canonicalTypeNames(q rowQueryer) ([]string, error) {
+	if types := l.canonicalTypes.Load(); types != nil {
+		return *types, nil
+	}
+	types := []string{}
+	rows, err := q.Query(`SELECT type FROM ledger_types WHERE canonical = 1`)
+	if isMissingTable(err) || err != nil && strings.Contains(err.Error(), "no such column") {
+		l.canonicalTypes.Store(&types)
+		return types, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		types = append(types, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	l.canonicalTypes.Store(&types)
+	return types, nil
+}
+
+func (l *Ledger) ensureTypesSchema() error {
+	if l.typesReady.Load() {
+		return nil
+	}
+	if _, err := l.db.Exec(typesSchema); err != nil {
+		return err
+	}
+	// Registries created before canonical payloads lack the column.
+	var n int
+	if err := l.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('ledger_types') WHERE name = 'canonical'`).Scan(&n); err != nil {
+		return err
+	}
+	if n == 0 {
+		if _, err := l.db.Exec(`ALTER TABLE ledger_types ADD COLUMN canonical INTEGER NOT NULL DEFAULT 0`); err != nil {
+			return err
+		}
+	}
+	l.typesReady.Store(true)
+	return nil
+}
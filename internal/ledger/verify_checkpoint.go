@@ -0,0 +1,170 @@
+package ledger
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const verifyCheckpointSchema = `
+CREATE TABLE IF NOT EXISTS ledger_verify_checkpoint (
+	id INTEGER PRIMARY KEY CHECK (id = 1),
+	last_id INTEGER NOT NULL,
+	last_hash TEXT NOT NULL,
+	verified_at INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS ledger_verify_status (
+	id INTEGER PRIMARY KEY CHECK (id = 1),
+	ok INTEGER NOT NULL,
+	checked INTEGER NOT NULL,
+	failed_id INTEGER NOT NULL,
+	reason TEXT NOT NULL,
+	since INTEGER NOT NULL,
+	verified_at INTEGER NOT NULL
+);
+`
+
+// VerifyCheckpoint is the chain head as of the last verification that
+// passed: everything up to LastID, whose hash was LastHash, verified.
+type VerifyCheckpoint struct {
+	LastID     int64  `json:"last_id"`
+	LastHash   string `json:"last_hash"`
+	VerifiedAt int64  `json:"verified_at"`
+}
+
+// VerifyCheckpoint returns the stored verification checkpoint. ok is false
+// when the chain has not been verified incrementally yet.
+func (l *Ledger) VerifyCheckpoint() (cp VerifyCheckpoint, ok bool, err error) {
+	err = l.db.QueryRow(`SELECT last_id, last_hash, verified_at FROM ledger_verify_checkpoint WHERE id = 1`).
+		Scan(&cp.LastID, &cp.LastHash, &cp.VerifiedAt)
+	if errors.Is(err, sql.ErrNoRows) || isMissingTable(err) {
+		return VerifyCheckpoint{}, false, nil
+	}
+	if err != nil {
+		return VerifyCheckpoint{}, false, err
+	}
+	return cp, true, nil
+}
+
+// VerifyIncremental verifies only the records appended since the stored
+// checkpoint, after confirming the checkpoint record still has the hash it
+// was verified with, and moves the checkpoint to the new head when they
+// pass. With full, or when there is no usable checkpoint, the whole chain
+// is verified as by VerifyChain. A checkpoint is unusable once its record
+// is pruned or archived.
+func (l *Ledger) VerifyIncremental(full bool) (VerifyResult, error) {
+	res, err := l.verifyIncremental(full)
+	if err != nil {
+		return VerifyResult{}, err
+	}
+	if err := l.storeLastVerification(res); err != nil {
+		return VerifyResult{}, err
+	}
+	return res, nil
+}
+
+func (l *Ledger) verifyIncremental(full bool) (VerifyResult, error) {
+	var cp VerifyCheckpoint
+	if !full {
+		var err error
+		if cp, err = l.usableCheckpoint(); err != nil {
+			return VerifyResult{}, err
+		}
+	}
+	if cp.LastID > 0 {
+		var hash string
+		if err := l.db.QueryRow(`SELECT hash FROM ledger_records WHERE id = ?`, cp.LastID).Scan(&hash); err != nil {
+			return VerifyResult{}, err
+		}
+		if hash != cp.LastHash {
+			return VerifyResult{
+				OK:        false,
+				FailedID:  cp.LastID,
+				Reason:    "hash differs from verify checkpoint",
+				Timestamp: time.Now().Unix(),
+			}, nil
+		}
+	}
+
+	res, next, err := l.verifyFrom(cp)
+	if err != nil {
+		return VerifyResult{}, err
+	}
+	res.Since = cp.LastID
+	if !res.OK || next.LastID == 0 {
+		return res, nil
+	}
+	if err := l.storeVerifyCheckpoint(next); err != nil {
+		return VerifyResult{}, err
+	}
+	return res, nil
+}
+
+// usableCheckpoint returns the stored checkpoint, or an empty one when
+// there is none or its record is no longer local.
+func (l *Ledger) usableCheckpoint() (VerifyCheckpoint, error) {
+	cp, ok, err := l.VerifyCheckpoint()
+	if err != nil || !ok {
+		return VerifyCheckpoint{}, err
+	}
+	archives, err := l.Archives()
+	if err != nil {
+		return VerifyCheckpoint{}, err
+	}
+	for _, a := range archives {
+		if a.LastID >= cp.LastID {
+			return VerifyCheckpoint{}, nil
+		}
+	}
+	var exists bool
+	if err := l.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM ledger_records WHERE id = ?)`, cp.LastID).Scan(&exists); err != nil || !exists {
+		return VerifyCheckpoint{}, err
+	}
+	return cp, nil
+}
+
+// LastVerification returns the result of the last whole or incremental
+// chain verification. ok is false when the chain has not been verified.
+func (l *Ledger) LastVerification() (res VerifyResult, ok bool, err error) {
+	err = l.db.QueryRow(`SELECT ok, checked, failed_id, reason, since, verified_at FROM ledger_verify_status WHERE id = 1`).
+		Scan(&res.OK, &res.Checked, &res.FailedID, &res.Reason, &res.Since, &res.Timestamp)
+	if errors.Is(err, sql.ErrNoRows) || isMissingTable(err) {
+		return VerifyResult{}, false, nil
+	}
+	if err != nil {
+		return VerifyResult{}, false, err
+	}
+	return res, true, nil
+}
+
+func (l *Ledger) storeLastVerification(res VerifyResult) error {
+	if err := l.ensureVerifyCheckpointSchema(); err != nil {
+		return err
+	}
+	_, err := l.db.Exec(`INSERT INTO ledger_verify_status(id, ok, checked, failed_id, reason, since, verified_at) VALUES(1, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET ok = excluded.ok, checked = excluded.checked, failed_id = excluded.failed_id,
+			reason = excluded.reason, since = excluded.since, verified_at = excluded.verified_at`,
+		res.OK, res.Checked, res.FailedID, res.Reason, res.Since, res.Timestamp)
+	return err
+}
+
+func (l *Ledger) storeVerifyCheckpoint(cp VerifyCheckpoint) error {
+	if err := l.ensureVerifyCheckpointSchema(); err != nil {
+		return err
+	}
+	_, err := l.db.Exec(`INSERT INTO ledger_verify_checkpoint(id, last_id, last_hash, verified_at) VALUES(1, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET last_id = excluded.last_id, last_hash = excluded.last_hash, verified_at = excluded.verified_at`,
+		cp.LastID, cp.LastHash, cp.VerifiedAt)
+	return err
+}
+
+func (l *Ledger) ensureVerifyCheckpointSchema() error {
+	if l.checkpointReady.Load() {
+		return nil
+	}
+	if _, err := l.db.Exec(verifyCheckpointSchema); err != nil {
+		return err
+	}
+	l.checkpointReady.Store(true)
+	return nil
+}
@@ -0,0 +1,222 @@
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Watchdog check names. A degraded report takes the category of its first
+// failed check, in this order.
+const (
+	WatchdogChain     = "chain"
+	WatchdogDisk      = "disk"
+	WatchdogFreshness = "freshness"
+)
+
+var errDiskSpaceUnsupported = errors.New("free space is not available on this platform")
+
+// WatchdogOptions sets the thresholds of Watchdog. A zero threshold skips
+// the check it bounds.
+type WatchdogOptions struct {
+	// Full verifies the whole chain instead of the records since the last
+	// checkpoint.
+	Full bool
+	// MaxCaptureAge is how old the newest record may be. Registered
+	// sources that are overdue or missing fail the freshness check
+	// regardless.
+	MaxCaptureAge time.Duration
+	// MinFreeBytes and MinFreePercent are the least free space the file
+	// system holding DiskPath must have.
+	MinFreeBytes   uint64
+	MinFreePercent float64
+	// DiskPath defaults to the database file.
+	DiskPath string
+}
+
+// WatchdogCheck is the outcome of one watchdog check.
+type WatchdogCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// WatchdogReport is the health of the ledger as seen by a scheduled
+// watchdog run.
+type WatchdogReport struct {
+	OK bool `json:"ok"`
+	// Category names the first failed check.
+	Category string          `json:"category,omitempty"`
+	Checks   []WatchdogCheck `json:"checks"`
+	Verify   VerifyResult    `json:"verify"`
+	// LastRecordAt is the timestamp of the newest record, 0 for an empty
+	// ledger.
+	LastRecordAt   int64    `json:"last_record_at"`
+	OverdueSources []string `json:"overdue_sources,omitempty"`
+	// DiskFreeBytes and DiskTotalBytes are 0 when free space cannot be
+	// read on the platform.
+	DiskFreeBytes  uint64 `json:"disk_free_bytes"`
+	DiskTotalBytes uint64 `json:"disk_total_bytes"`
+	Timestamp      int64  `json:"timestamp"`
+}
+
+// Watchdog verifies the records appended since the last checkpoint, checks
+// that captures are fresh and that the database has room to grow, and
+// reports every check. Errors are returned only when a check cannot run;
+// a failed check makes the report degraded.
+func (l *Ledger) Watchdog(now time.Time, opts WatchdogOptions) (WatchdogReport, error) {
+	report := WatchdogReport{OK: true, Timestamp: now.Unix()}
+	fail := func(name, detail string) {
+		report.Checks = append(report.Checks, WatchdogCheck{Name: name, Detail: detail})
+		if report.OK {
+			report.OK, report.Category = false, name
+		}
+	}
+	pass := func(name, detail string) {
+		report.Checks = append(report.Checks, WatchdogCheck{Name: name, OK: true, Detail: detail})
+	}
+
+	var err error
+	if report.Verify, err = l.VerifyIncremental(opts.Full); err != nil {
+		return report, err
+	}
+	if report.Verify.OK {
+		pass(WatchdogChain, fmt.Sprintf("%d records verified", report.Verify.Checked))
+	} else {
+		fail(WatchdogChain, fmt.Sprintf("record %d: %s", report.Verify.FailedID, report.Verify.Reason))
+	}
+
+	path := opts.DiskPath
+	if path == "" {
+		if path, err = l.databasePath(); err != nil {
+			return report, err
+		}
+	}
+	free, total, err := diskSpace(path)
+	switch {
+	case path == "" || errors.Is(err, errDiskSpaceUnsupported):
+		pass(WatchdogDisk, "free space unknown")
+	case err != nil:
+		return report, err
+	default:
+		report.DiskFreeBytes, report.DiskTotalBytes = free, total
+		percent := 100 * float64(free) / float64(max(total, 1))
+		detail := fmt.Sprintf("%s free (%.1f%%)", formatBytes(free), percent)
+		if free < opts.MinFreeBytes || percent < opts.MinFreePercent {
+			fail(WatchdogDisk, detail)
+		} else {
+			pass(WatchdogDisk, detail)
+		}
+	}
+
+	stats, err := l.ChainStats()
+	if err != nil {
+		return report, err
+	}
+	report.LastRecordAt = stats.LastTimestamp
+	sources, err := l.SourceStatus(now.Unix(), DefaultNewSourceWindow)
+	if err != nil {
+		return report, err
+	}
+	for _, src := range sources.Sources {
+		if src.Registered && (src.Status == SourceOverdue || src.Status == SourceMissing) {
+			report.OverdueSources = append(report.OverdueSources, src.Name)
+		}
+	}
+	var stale []string
+	if len(report.OverdueSources) > 0 {
+		stale = append(stale, "overdue sources: "+strings.Join(report.OverdueSources, ", "))
+	}
+	if opts.MaxCaptureAge > 0 {
+		switch age := now.Sub(time.Unix(report.LastRecordAt, 0)); {
+		case report.LastRecordAt == 0:
+			stale = append(stale, "no records")
+		case age > opts.MaxCaptureAge:
+			stale = append(stale, fmt.Sprintf("newest record is %s old", age.Truncate(time.Second)))
+		}
+	}
+	if len(stale) > 0 {
+		fail(WatchdogFreshness, strings.Join(stale, "; "))
+	} else {
+		pass(WatchdogFreshness, "")
+	}
+	return report, nil
+}
+
+// databasePath returns the file the ledger is stored in, or "" for an
+// in-memory ledger.
+func (l *Ledger) databasePath() (string, error) {
+	if l.encrypted != nil {
+		return l.encrypted.path, nil
+	}
+	var seq int
+	var name, file string
+	err := l.db.QueryRow(`PRAGMA database_list`).Scan(&seq, &name, &file)
+	return file, err
+}
+
+// StatusLine renders the report as one logfmt line, for cron mail and
+// system logs.
+func (r WatchdogReport) StatusLine() string {
+	var b strings.Builder
+	if r.OK {
+		b.WriteString("status=ok")
+	} else {
+		fmt.Fprintf(&b, "status=degraded category=%s", r.Category)
+	}
+	for _, c := range r.Checks {
+		state := "ok"
+		if !c.OK {
+			state = "failed"
+		}
+		fmt.Fprintf(&b, " %s=%s", c.Name, state)
+		if c.Detail != "" {
+			fmt.Fprintf(&b, " %s_detail=%s", c.Name, strconv.Quote(c.Detail))
+		}
+	}
+	return b.String()
+}
+
+// WriteMetrics writes the report in the Prometheus text format, as read by
+// the node_exporter textfile collector.
+func (r WatchdogReport) WriteMetrics(w io.Writer) error {
+	var b strings.Builder
+	gauge := func(name, help string, value float64) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", name, help, name, name, strconv.FormatFloat(value, 'f', -1, 64))
+	}
+	gauge("stateledger_watchdog_ok", "Whether every watchdog check passed", boolGauge(r.OK))
+	b.WriteString("# HELP stateledger_watchdog_check_ok Whether a watchdog check passed\n# TYPE stateledger_watchdog_check_ok gauge\n")
+	for _, c := range r.Checks {
+		fmt.Fprintf(&b, "stateledger_watchdog_check_ok{check=%q} %g\n", c.Name, boolGauge(c.OK))
+	}
+	gauge("stateledger_watchdog_verified_records", "Records verified by the last watchdog run", float64(r.Verify.Checked))
+	gauge("stateledger_watchdog_last_record_timestamp_seconds", "Timestamp of the newest record", float64(r.LastRecordAt))
+	gauge("stateledger_watchdog_overdue_sources", "Registered sources overdue or missing", float64(len(r.OverdueSources)))
+	if r.DiskTotalBytes > 0 {
+		gauge("stateledger_watchdog_disk_free_bytes", "Free bytes on the file system holding the database", float64(r.DiskFreeBytes))
+		gauge("stateledger_watchdog_disk_total_bytes", "Size of the file system holding the database", float64(r.DiskTotalBytes))
+	}
+	gauge("stateledger_watchdog_last_run_timestamp_seconds", "Time of the last watchdog run", float64(r.Timestamp))
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func boolGauge(ok bool) float64 {
+	if ok {
+		return 1
+	}
+	return 0
+}
+
+// formatBytes renders n in binary units, as 1.5GiB.
+func formatBytes(n uint64) string {
+	if n < 1024 {
+		return strconv.FormatUint(n, 10) + "B"
+	}
+	exp := min(int(math.Log(float64(n))/math.Log(1024)), 6)
+	return fmt.Sprintf("%.1f%ciB", float64(n)/math.Pow(1024, float64(exp)), "KMGTPE"[exp-1])
+}
@@ -0,0 +1,87 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"syscall"
+	"time"
+)
+
+// ErrWebhookURL is returned for webhook URLs that may not be subscribed
+// through the API.
+var ErrWebhookURL = errors.New("webhook URL not allowed")
+
+// metadataAddrs are cloud instance metadata endpoints outside the
+// link-local ranges, such as Alibaba Cloud's and AWS's IPv6 one.
+var metadataAddrs = []netip.Addr{
+	netip.MustParseAddr("100.100.100.200"),
+	netip.MustParseAddr("fd00:ec2::254"),
+}
+
+// blockedWebhookAddr reports whether deliveries to a may not be sent for
+// subscriptions made through the API: loopback, link-local, multicast,
+// unspecified and cloud metadata addresses, which would let API clients
+// reach services of the server's host.
+func blockedWebhookAddr(a netip.Addr) bool {
+	a = a.Unmap()
+	return a.IsLoopback() || a.IsLinkLocalUnicast() || a.IsLinkLocalMulticast() || a.IsMulticast() ||
+		a.IsUnspecified() || slices.Contains(metadataAddrs, a)
+}
+
+// CheckWebhookURL reports whether raw may be subscribed through the API.
+// It must be an http or https URL whose host resolves to no blocked
+// address. Deliveries check the address again as they connect, so a
+// host that resolves differently later is still refused.
+func CheckWebhookURL(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrWebhookURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w: scheme %q: use http or https", ErrWebhookURL, u.Scheme)
+	}
+	host := u.Hostname()
+	if host == "" {
+		return fmt.Errorf("%w: no host", ErrWebhookURL)
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return fmt.Errorf("%w: resolve %s: %v", ErrWebhookURL, host, err)
+	}
+	for _, a := range addrs {
+		if blockedWebhookAddr(a) {
+			return fmt.Errorf("%w: %s resolves to %s", ErrWebhookURL, host, a.Unmap())
+		}
+	}
+	return nil
+}
+
+// guardedWebhookClient returns the client that delivers to subscriptions
+// made through the API. It refuses to connect to blocked addresses,
+// including after a redirect, and ignores proxy settings, which would
+// hide the address connected to.
+func guardedWebhookClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			ap, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if blockedWebhookAddr(ap.Addr()) {
+				return fmt.Errorf("%w: %s", ErrWebhookURL, ap.Addr().Unmap())
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
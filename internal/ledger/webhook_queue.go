@@ -0,0 +1,228 @@
+package ledger
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Slow-consumer defaults: each subscription queues at most
+// defaultQueueSize deliveries behind the one being sent, and is reported
+// as lagging once its oldest pending delivery was older than
+// defaultSlowLag on defaultSlowSamples publishes in a row, or once its
+// queue overflowed.
+const (
+	defaultQueueSize   = 256
+	defaultSlowLag     = 30 * time.Second
+	defaultSlowSamples = 3
+)
+
+// SubscriptionStats describes the deliveries of one subscription.
+type SubscriptionStats struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// InFlight is the delivery being sent or waiting to be retried; Queued
+	// ones wait for it to finish.
+	InFlight int `json:"in_flight"`
+	Queued   int `json:"queued"`
+	// LagSeconds is the age of the oldest pending delivery, 0 when the
+	// subscriber has caught up.
+	LagSeconds float64 `json:"lag_seconds"`
+	// Slow is set once the subscriber lagged consistently or its queue
+	// overflowed, and cleared when its queue drains.
+	Slow      bool   `json:"slow"`
+	Delivered uint64 `json:"delivered"`
+	Failed    uint64 `json:"failed"`
+	// Dropped counts the events not queued because the queue was full,
+	// and those discarded when the subscription was revoked.
+	Dropped uint64 `json:"dropped"`
+}
+
+// WebhookStats describes pending and finished webhook deliveries since the
+// manager was created.
+type WebhookStats struct {
+	InFlight      int                 `json:"in_flight"`
+	Queued        int                 `json:"queued"`
+	Delivered     uint64              `json:"delivered"`
+	Failed        uint64              `json:"failed"`
+	Dropped       uint64              `json:"dropped"`
+	SlowConsumers int                 `json:"slow_consumers"`
+	Subscriptions []SubscriptionStats `json:"subscriptions"`
+}
+
+// delivery is an event waiting in a subscription's queue.
+type delivery struct {
+	event WebhookEvent
+}
+
+// deliveryQueue holds the pending deliveries of a subscription, which a
+// single worker sends in order. A full queue drops new events.
+type deliveryQueue struct {
+	items chan delivery
+	done  chan struct{}
+
+	mu sync.Mutex
+	// published holds the publish times of the in-flight and queued
+	// deliveries, oldest first.
+	published []time.Time
+	inFlight  int
+	delivered uint64
+	failed    uint64
+	dropped   uint64
+	strikes   int
+	slow      bool
+}
+
+func newDeliveryQueue(size int) *deliveryQueue {
+	return &deliveryQueue{
+		items: make(chan delivery, max(size, 1)),
+		done:  make(chan struct{}),
+	}
+}
+
+// enqueue queues event, published at now, unless the queue is full. It
+// reports whether the event was queued.
+func (q *deliveryQueue) enqueue(event WebhookEvent, now time.Time) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	select {
+	case q.items <- delivery{event: event}:
+	default:
+		q.dropped++
+		return false
+	}
+	q.published = append(q.published, now)
+	return true
+}
+
+// run sends the queued deliveries with deliver until stop is called.
+func (q *deliveryQueue) run(deliver func(WebhookEvent) bool) {
+	for {
+		select {
+		case <-q.done:
+			return
+		case d := <-q.items:
+			q.start()
+			q.finish(deliver(d.event))
+		}
+	}
+}
+
+// drain discards the queued deliveries. The one in flight, if any, is
+// left to the worker.
+func (q *deliveryQueue) drain() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		select {
+		case <-q.items:
+			// The queued deliveries are the newest pending ones.
+			q.published = q.published[:len(q.published)-1]
+			q.dropped++
+		default:
+			if len(q.published) == 0 {
+				q.strikes, q.slow = 0, false
+			}
+			return
+		}
+	}
+}
+
+// stop ends the worker; deliveries still queued are not sent.
+func (q *deliveryQueue) stop() {
+	close(q.done)
+}
+
+// start marks the next delivery as in flight. Its publish time stays the
+// oldest pending one until it finishes, however long its retries take.
+func (q *deliveryQueue) start() {
+	q.mu.Lock()
+	q.inFlight++
+	q.mu.Unlock()
+}
+
+// finish removes the in-flight delivery once it was delivered or given up
+// on. A drained queue is no longer slow.
+func (q *deliveryQueue) finish(delivered bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	// Deliveries are sent in order, so the finished one is the oldest.
+	q.published = q.published[1:]
+	q.inFlight--
+	if delivered {
+		q.delivered++
+	} else {
+		q.failed++
+	}
+	if len(q.published) == 0 {
+		q.strikes, q.slow = 0, false
+	}
+}
+
+// observe samples the lag as an event is published, and reports whether
+// the subscriber is now slow: it lagged more than threshold on samples
+// observations in a row, or the event overflowed its queue. A slow
+// subscriber is reported once until its queue drains.
+func (q *deliveryQueue) observe(now time.Time, threshold time.Duration, samples int, overflowed bool) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.lag(now) > threshold {
+		q.strikes++
+	} else {
+		q.strikes = 0
+	}
+	if (overflowed || q.strikes >= samples) && !q.slow {
+		q.slow = true
+		return true
+	}
+	return false
+}
+
+// lag returns the age of the oldest pending delivery. q.mu must be held.
+func (q *deliveryQueue) lag(now time.Time) time.Duration {
+	if len(q.published) == 0 {
+		return 0
+	}
+	return now.Sub(q.published[0])
+}
+
+// stats describes the queue of subscription sub.
+func (q *deliveryQueue) stats(sub *Subscription, now time.Time) SubscriptionStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return SubscriptionStats{
+		ID:         sub.ID,
+		URL:        sub.URL,
+		InFlight:   q.inFlight,
+		Queued:     len(q.published) - q.inFlight,
+		LagSeconds: q.lag(now).Round(time.Millisecond).Seconds(),
+		Slow:       q.slow,
+		Delivered:  q.delivered,
+		Failed:     q.failed,
+		Dropped:    q.dropped,
+	}
+}
+
+// Stats reports the in-flight and queued deliveries and the lag of every
+// subscription, revoked ones included, ordered by ID.
+func (wm *WebhookManager) Stats() WebhookStats {
+	wm.mu.RLock()
+	defer wm.mu.RUnlock()
+
+	now := time.Now()
+	stats := WebhookStats{Subscriptions: make([]SubscriptionStats, 0, len(wm.subscriptions))}
+	for id, sub := range wm.subscriptions {
+		s := wm.queues[id].stats(sub, now)
+		stats.InFlight += s.InFlight
+		stats.Queued += s.Queued
+		stats.Delivered += s.Delivered
+		stats.Failed += s.Failed
+		stats.Dropped += s.Dropped
+		if s.Slow {
+			stats.SlowConsumers++
+		}
+		stats.Subscriptions = append(stats.Subscriptions, s)
+	}
+	sort.Slice(stats.Subscriptions, func(i, j int) bool { return stats.Subscriptions[i].ID < stats.Subscriptions[j].ID })
+	return stats
+}
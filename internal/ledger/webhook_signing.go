@@ -0,0 +1,67 @@
+package ledger
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Webhook delivery headers. pkg/webhookverify checks them on the receiving
+// side.
+const (
+	// HeaderWebhookDelivery identifies a delivery. Retries of a delivery
+	// keep its ID, so receivers can drop duplicates.
+	HeaderWebhookDelivery = "X-StateLedger-Delivery"
+	// HeaderWebhookTimestamp is the Unix time in seconds at which the
+	// attempt was sent.
+	HeaderWebhookTimestamp = "X-StateLedger-Timestamp"
+	// HeaderWebhookSignature is "sha256=" followed by the hex HMAC-SHA256
+	// of the delivery ID, the timestamp and the body, joined with dots and
+	// keyed with the subscription secret. It is only sent to subscriptions
+	// with a secret.
+	HeaderWebhookSignature = "X-StateLedger-Signature"
+)
+
+// CloudEvents attributes of webhook deliveries, sent in binary content mode
+// as ce-* headers so the body stays the WebhookEvent JSON.
+const (
+	CloudEventsSpecVersion = "1.0"
+	CloudEventsSource      = "stateledger"
+)
+
+// SignWebhook returns the HeaderWebhookSignature value of body sent as
+// delivery deliveryID at timestamp.
+func SignWebhook(secret, deliveryID, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(deliveryID))
+	mac.Write([]byte("."))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// setDeliveryHeaders sets the delivery, CloudEvents and, with a secret,
+// signature headers of one delivery attempt of payload.
+func setDeliveryHeaders(h http.Header, deliveryID, secret string, event WebhookEvent, payload []byte, now time.Time) {
+	ts := strconv.FormatInt(now.Unix(), 10)
+	h.Set(HeaderWebhookDelivery, deliveryID)
+	h.Set(HeaderWebhookTimestamp, ts)
+	if secret != "" {
+		h.Set(HeaderWebhookSignature, SignWebhook(secret, deliveryID, ts, payload))
+	}
+
+	h.Set("ce-specversion", CloudEventsSpecVersion)
+	h.Set("ce-id", deliveryID)
+	h.Set("ce-source", CloudEventsSource)
+	h.Set("ce-type", event.EventType)
+	if !event.Timestamp.IsZero() {
+		h.Set("ce-time", event.Timestamp.UTC().Format(time.RFC3339Nano))
+	}
+	if event.Severity != "" {
+		h.Set("ce-severity", event.Severity)
+	}
+}
@@ -0,0 +1,78 @@
+package ledger
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrWebhookExists is returned by CreateWebhook for an ID already stored.
+var ErrWebhookExists = errors.New("webhook already exists")
+
+// StoredWebhook is a webhook subscription created through the API and
+// kept in the ledger database, so it survives restarts. Its secret is
+// stored as given; encrypt the database to protect it.
+type StoredWebhook struct {
+	ID        string
+	URL       string
+	Events    []string
+	Secret    string
+	CreatedAt time.Time
+}
+
+// CreateWebhook stores hook and records that actor created it as the
+// webhook credential hook.ID, in one transaction. It fails with
+// ErrCredentialRevoked when the ID was revoked, and with ErrWebhookExists
+// when a webhook with the ID is already stored.
+func (l *Ledger) CreateWebhook(hook StoredWebhook, actor string) (Credential, error) {
+	events, err := json.Marshal(hook.Events)
+	if err != nil {
+		return Credential{}, err
+	}
+	if hook.CreatedAt.IsZero() {
+		hook.CreatedAt = time.Now()
+	}
+	return l.changeCredential(CredentialWebhook, hook.ID, actor, "", CredentialCreateRecordType, func(tx *sql.Tx) error {
+		var n int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM ledger_webhooks WHERE id = ?`, hook.ID).Scan(&n); err != nil {
+			return err
+		}
+		if n > 0 {
+			return fmt.Errorf("webhook %s: %w", hook.ID, ErrWebhookExists)
+		}
+		_, err := tx.Exec(`INSERT INTO ledger_webhooks(id, url, events, secret, created_at) VALUES(?, ?, ?, ?, ?)`,
+			hook.ID, hook.URL, string(events), hook.Secret, hook.CreatedAt.Unix())
+		return err
+	})
+}
+
+// Webhooks lists the stored webhook subscriptions by ID, revoked ones
+// included; Credentials reports which are revoked.
+func (l *Ledger) Webhooks() ([]StoredWebhook, error) {
+	if err := l.ensureCredentialSchema(); err != nil {
+		return nil, err
+	}
+	rows, err := l.db.Query(`SELECT id, url, events, secret, created_at FROM ledger_webhooks ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hooks := []StoredWebhook{}
+	for rows.Next() {
+		var hook StoredWebhook
+		var events string
+		var created int64
+		if err := rows.Scan(&hook.ID, &hook.URL, &events, &hook.Secret, &created); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(events), &hook.Events); err != nil {
+			return nil, fmt.Errorf("webhook %s: events: %w", hook.ID, err)
+		}
+		hook.CreatedAt = time.Unix(created, 0)
+		hooks = append(hooks, hook)
+	}
+	return hooks, rows.Err()
+}
@@ -7,22 +7,33 @@ import (
 	"net/http"
 	"sync"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 // WebhookEvent represents an event that can be sent via webhook
 type WebhookEvent struct {
 	EventType string      `json:"event_type"`
+	Severity  string      `json:"severity,omitempty"`
 	Timestamp time.Time   `json:"timestamp"`
 	Data      interface{} `json:"data"`
 }
 
 // WebhookManager manages webhook subscriptions and delivery
 type WebhookManager struct {
-	mu           sync.RWMutex
+	mu            sync.RWMutex
 	subscriptions map[string]*Subscription
-	httpClient   *http.Client
-	maxRetries   int
-	retryDelay   time.Duration
+	notifiers     []*Notifier
+	httpClient    *http.Client
+	// guardedClient delivers to subscriptions made through the API
+	guardedClient *http.Client
+	maxRetries    int
+	retryDelay    time.Duration
+	// queues holds the pending deliveries of each subscription
+	queues      map[string]*deliveryQueue
+	queueSize   int
+	slowLag     time.Duration
+	slowSamples int
 }
 
 // Subscription represents a webhook subscription
@@ -33,6 +44,15 @@ type Subscription struct {
 	Secret    string   // for HMAC verification
 	Active    bool
 	CreatedAt time.Time
+	// RevokedAt, RevokedBy and RevokeReason are set while the
+	// subscription is revoked; it then receives no events.
+	RevokedAt    time.Time
+	RevokedBy    string
+	RevokeReason string
+
+	// guarded subscriptions were made through the API, and are not
+	// delivered to addresses CheckWebhookURL refuses
+	guarded bool
 }
 
 // NewWebhookManager creates a new webhook manager
@@ -42,29 +62,92 @@ func NewWebhookManager() *WebhookManager {
 		httpClient: &http.Client{
 			Timeout: 10 * time.Second,
 		},
-		maxRetries: 3,
-		retryDelay: 2 * time.Second,
+		guardedClient: guardedWebhookClient(10 * time.Second),
+		maxRetries:    3,
+		retryDelay:    2 * time.Second,
+		queues:        make(map[string]*deliveryQueue),
+		queueSize:     defaultQueueSize,
+		slowLag:       defaultSlowLag,
+		slowSamples:   defaultSlowSamples,
 	}
 }
 
 // Subscribe adds a new webhook subscription
 func (wm *WebhookManager) Subscribe(id, url string, events []string, secret string) error {
+	return wm.subscribe(id, url, events, secret, time.Now(), false)
+}
+
+// SubscribeStored adds the subscription of a webhook stored in the ledger
+// with CreateWebhook, as it was created. Like every subscription made
+// through the API, it is never delivered to the loopback, link-local or
+// metadata addresses CheckWebhookURL refuses.
+func (wm *WebhookManager) SubscribeStored(hook StoredWebhook) error {
+	return wm.subscribe(hook.ID, hook.URL, hook.Events, hook.Secret, hook.CreatedAt, true)
+}
+
+func (wm *WebhookManager) subscribe(id, url string, events []string, secret string, createdAt time.Time, guarded bool) error {
 	wm.mu.Lock()
 	defer wm.mu.Unlock()
 
-	if _, exists := wm.subscriptions[id]; exists {
+	if sub, exists := wm.subscriptions[id]; exists {
+		if !sub.RevokedAt.IsZero() {
+			return fmt.Errorf("subscription %s: %w", id, ErrCredentialRevoked)
+		}
 		return fmt.Errorf("subscription %s already exists", id)
 	}
 
-	wm.subscriptions[id] = &Subscription{
+	sub := &Subscription{
 		ID:        id,
 		URL:       url,
 		Events:    events,
 		Secret:    secret,
 		Active:    true,
-		CreatedAt: time.Now(),
+		CreatedAt: createdAt,
+		guarded:   guarded,
 	}
+	q := newDeliveryQueue(wm.queueSize)
+	wm.subscriptions[id] = sub
+	wm.queues[id] = q
+	go q.run(func(event WebhookEvent) bool { return wm.deliverWebhook(sub, event) })
+
+	return nil
+}
 
+// Revoke stops deliveries to a subscription but keeps it, marked with who
+// revoked it at what time and why, so it can be listed and restored.
+// Queued events are discarded and a delivery being retried is not
+// attempted again, so nothing more is sent under the subscription's secret.
+func (wm *WebhookManager) Revoke(id, actor, reason string, at time.Time) error {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+
+	sub, exists := wm.subscriptions[id]
+	if !exists {
+		return fmt.Errorf("subscription %s not found", id)
+	}
+	if !sub.RevokedAt.IsZero() {
+		return fmt.Errorf("subscription %s: %w", id, ErrCredentialRevoked)
+	}
+	sub.Active = false
+	sub.RevokedAt, sub.RevokedBy, sub.RevokeReason = at, actor, reason
+	wm.queues[id].drain()
+	return nil
+}
+
+// Restore resumes deliveries to a revoked subscription
+func (wm *WebhookManager) Restore(id string) error {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+
+	sub, exists := wm.subscriptions[id]
+	if !exists {
+		return fmt.Errorf("subscription %s not found", id)
+	}
+	if sub.RevokedAt.IsZero() {
+		return fmt.Errorf("subscription %s: %w", id, ErrCredentialActive)
+	}
+	sub.Active = true
+	sub.RevokedAt, sub.RevokedBy, sub.RevokeReason = time.Time{}, "", ""
 	return nil
 }
 
@@ -78,16 +161,32 @@ func (wm *WebhookManager) Unsubscribe(id string) error {
 	}
 
 	delete(wm.subscriptions, id)
+	wm.queues[id].stop()
+	delete(wm.queues, id)
 	return nil
 }
 
-// Publish sends an event to all matching subscribers
+// Publish queues an event for all matching subscribers. Events without a
+// severity get the default severity of their type. An event that finds a
+// subscriber's queue full is dropped for it. A subscriber that keeps
+// lagging behind its deliveries, or drops events, is reported with a
+// webhook.lagging event to the others.
 func (wm *WebhookManager) Publish(event WebhookEvent) {
-	wm.mu.RLock()
-	defer wm.mu.RUnlock()
+	wm.publish(event, "")
+}
 
+// publish sends an event to all matching subscribers but skip
+func (wm *WebhookManager) publish(event WebhookEvent, skip string) {
+	if event.Severity == "" {
+		event.Severity = EventSeverity(event.EventType)
+	}
+
+	now := time.Now()
+	var lagging []SubscriptionStats
+
+	wm.mu.RLock()
 	for _, sub := range wm.subscriptions {
-		if !sub.Active {
+		if !sub.Active || sub.ID == skip {
 			continue
 		}
 
@@ -96,22 +195,70 @@ func (wm *WebhookManager) Publish(event WebhookEvent) {
 			continue
 		}
 
-		// Send webhook asynchronously
-		go wm.deliverWebhook(sub, event)
+		q := wm.queues[sub.ID]
+		queued := q.enqueue(event, now)
+		if q.observe(now, wm.slowLag, wm.slowSamples, !queued) {
+			lagging = append(lagging, q.stats(sub, now))
+		}
+	}
+
+	for _, n := range wm.notifiers {
+		if n.Wants(event) {
+			go wm.deliverNotification(n, event)
+		}
+	}
+	wm.mu.RUnlock()
+
+	for _, s := range lagging {
+		wm.publish(WebhookEvent{EventType: EventWebhookLagging, Timestamp: now, Data: s}, s.ID)
+	}
+}
+
+// AddNotifier sends events at or above the notifier's minimum severity to
+// a chat sink, alongside the webhook subscriptions
+func (wm *WebhookManager) AddNotifier(n *Notifier) {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+
+	wm.notifiers = append(wm.notifiers, n)
+}
+
+// deliverNotification posts an event to a notifier with retries
+func (wm *WebhookManager) deliverNotification(n *Notifier, event WebhookEvent) {
+	var err error
+	for attempt := 0; attempt < wm.maxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(wm.retryDelay * time.Duration(attempt))
+		}
+		if err = n.Notify(event); err == nil {
+			return
+		}
+	}
+	if n.OnError != nil {
+		n.OnError(event, err)
 	}
 }
 
-// deliverWebhook attempts to deliver a webhook with retries
-func (wm *WebhookManager) deliverWebhook(sub *Subscription, event WebhookEvent) {
+// deliverWebhook attempts to deliver a webhook with retries, and reports
+// whether it was delivered
+func (wm *WebhookManager) deliverWebhook(sub *Subscription, event WebhookEvent) bool {
 	payload, err := json.Marshal(event)
 	if err != nil {
-		return
+		return false
+	}
+	deliveryID := uuid.NewString()
+	client := wm.httpClient
+	if sub.guarded {
+		client = wm.guardedClient
 	}
 
 	for attempt := 0; attempt < wm.maxRetries; attempt++ {
 		if attempt > 0 {
 			time.Sleep(wm.retryDelay * time.Duration(attempt))
 		}
+		if !wm.active(sub) {
+			return false
+		}
 
 		req, err := http.NewRequest("POST", sub.URL, bytes.NewReader(payload))
 		if err != nil {
@@ -121,15 +268,13 @@ func (wm *WebhookManager) deliverWebhook(sub *Subscription, event WebhookEvent)
 		req.Header.Set("Content-Type", "application/json")
 		req.Header.Set("X-Webhook-ID", sub.ID)
 		req.Header.Set("X-Event-Type", event.EventType)
+		req.Header.Set("X-Event-Severity", event.Severity)
 
-		// Add HMAC signature if secret is provided
-		if sub.Secret != "" {
-			// TODO: Implement HMAC signature
-			// sig := hmac256(sub.Secret, payload)
-			// req.Header.Set("X-Signature", sig)
-		}
+		// Each attempt is stamped and signed when it is sent, so retries
+		// are not rejected as stale by the receiver
+		setDeliveryHeaders(req.Header, deliveryID, sub.Secret, event, payload, time.Now())
 
-		resp, err := wm.httpClient.Do(req)
+		resp, err := client.Do(req)
 		if err != nil {
 			continue
 		}
@@ -138,11 +283,19 @@ func (wm *WebhookManager) deliverWebhook(sub *Subscription, event WebhookEvent)
 
 		// Success if 2xx status
 		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
-			return
+			return true
 		}
 	}
 
-	// All retries failed - could log this or mark subscription inactive
+	// All retries failed; counted as failed in Stats
+	return false
+}
+
+// active reports whether sub is still subscribed and not revoked
+func (wm *WebhookManager) active(sub *Subscription) bool {
+	wm.mu.RLock()
+	defer wm.mu.RUnlock()
+	return sub.Active && wm.subscriptions[sub.ID] == sub
 }
 
 // wantsEvent checks if subscription wants this event type
@@ -160,14 +313,16 @@ func (sub *Subscription) wantsEvent(eventType string) bool {
 	return false
 }
 
-// ListSubscriptions returns all active subscriptions
+// ListSubscriptions returns all subscriptions, revoked ones included
 func (wm *WebhookManager) ListSubscriptions() []*Subscription {
 	wm.mu.RLock()
 	defer wm.mu.RUnlock()
 
 	subs := make([]*Subscription, 0, len(wm.subscriptions))
 	for _, sub := range wm.subscriptions {
-		subs = append(subs, sub)
+		// A copy, as Revoke and Restore change subscriptions in place.
+		c := *sub
+		subs = append(subs, &c)
 	}
 
 	return subs
@@ -179,4 +334,34 @@ const (
 	EventBatchAppended  = "batch.appended"
 	EventChainVerified  = "chain.verified"
 	EventSnapshotTaken  = "snapshot.taken"
+
+	// Alert events, emitted by the subsystems that detect them
+	EventDriftDetected      = "drift.detected"
+	EventVerificationFailed = "verification.failed"
+	EventCoverageDegraded   = "coverage.degraded"
+	EventQuotaExceeded      = "quota.exceeded"
+	EventAgentOffline       = "agent.offline"
+	EventMirrorDivergence   = "mirror.divergence"
+	EventDeterminismLow     = "determinism.low"
+	EventMutationAnomaly    = "mutation.anomaly"
+	EventWebhookLagging     = "webhook.lagging"
+)
+
+// Event severities
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
 )
+
+// EventSeverity returns the default severity of an event type
+func EventSeverity(eventType string) string {
+	switch eventType {
+	case EventVerificationFailed, EventMirrorDivergence:
+		return SeverityCritical
+	case EventDriftDetected, EventDeterminismLow, EventCoverageDegraded, EventQuotaExceeded, EventAgentOffline, EventMutationAnomaly, EventWebhookLagging:
+		return SeverityWarning
+	default:
+		return SeverityInfo
+	}
+}
@@ -0,0 +1,171 @@
+package ledger
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const wormSchema = `
+CREATE TABLE IF NOT EXISTS ledger_worm_exports (
+	name TEXT PRIMARY KEY,
+	location TEXT NOT NULL,
+	first_id INTEGER NOT NULL,
+	last_id INTEGER NOT NULL,
+	count INTEGER NOT NULL,
+	segment_hash TEXT NOT NULL,
+	retain_until INTEGER NOT NULL,
+	created_at INTEGER NOT NULL
+);
+`
+
+// Object Lock retention modes. In compliance mode no user, including the
+// bucket owner, can delete or overwrite an object version or shorten its
+// retention until it expires.
+const (
+	ObjectLockCompliance = "COMPLIANCE"
+	ObjectLockGovernance = "GOVERNANCE"
+)
+
+// WORMStore writes objects that cannot be changed or deleted until
+// retainUntil. *S3ArchiveStore implements it with S3 Object Lock.
+type WORMStore interface {
+	Location() string
+	PutLocked(name string, data []byte, mode string, retainUntil time.Time) error
+}
+
+// WORMExportOptions controls ExportWORM.
+type WORMExportOptions struct {
+	// Retain is how long each object is locked after it is written.
+	// Required.
+	Retain time.Duration
+	// Mode is the Object Lock mode, ObjectLockCompliance by default.
+	Mode string
+	// SegmentSize is the maximum number of records per object.
+	SegmentSize int
+}
+
+// WORMObject is an object written by ExportWORM.
+type WORMObject struct {
+	Name        string `json:"name"`
+	Location    string `json:"location"`
+	FirstID     int64  `json:"first_id"`
+	LastID      int64  `json:"last_id"`
+	Count       int64  `json:"count"`
+	SegmentHash string `json:"segment_hash"`
+	RetainUntil int64  `json:"retain_until"`
+	CreatedAt   int64  `json:"created_at"`
+}
+
+// WORMExportResult summarizes an ExportWORM run.
+type WORMExportResult struct {
+	Objects         []WORMObject `json:"objects"`
+	Records         int64        `json:"records"`
+	LastSegmentHash string       `json:"last_segment_hash"`
+}
+
+// ExportWORM writes the live records not yet exported to store as
+// portable segment files locked for opts.Retain. Each run continues the
+// segment sequence of the previous one, so the objects of a store verify
+// together with `stateledger segment verify`. Objects are named after
+// their ID range and segment hash and are never overwritten.
+func (l *Ledger) ExportWORM(store WORMStore, opts WORMExportOptions) (WORMExportResult, error) {
+	if opts.Retain <= 0 {
+		return WORMExportResult{}, errors.New("worm export requires a retention period")
+	}
+	switch opts.Mode {
+	case "":
+		opts.Mode = ObjectLockCompliance
+	case ObjectLockCompliance, ObjectLockGovernance:
+	default:
+		return WORMExportResult{}, fmt.Errorf("unknown object lock mode %q", opts.Mode)
+	}
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = defaultArchiveSegmentSize
+	}
+	if err := l.ensureWORMSchema(); err != nil {
+		return WORMExportResult{}, err
+	}
+
+	res := WORMExportResult{Objects: []WORMObject{}}
+	next := int64(1)
+	err := l.db.QueryRow(`SELECT last_id + 1, segment_hash FROM ledger_worm_exports WHERE location = ? ORDER BY last_id DESC LIMIT 1`,
+		store.Location()).Scan(&next, &res.LastSegmentHash)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return res, err
+	}
+
+	for {
+		recs, err := l.RecordsByID(next, 0, opts.SegmentSize)
+		if err != nil {
+			return res, err
+		}
+		if len(recs) == 0 {
+			return res, nil
+		}
+
+		var buf bytes.Buffer
+		trailer, err := WriteSegment(&buf, recs, res.LastSegmentHash)
+		if err != nil {
+			return res, err
+		}
+		now := time.Now()
+		obj := WORMObject{
+			Name:        fmt.Sprintf("segment-%020d-%020d-%s.jsonl", recs[0].ID, recs[len(recs)-1].ID, trailer.SegmentHash),
+			Location:    store.Location(),
+			FirstID:     recs[0].ID,
+			LastID:      recs[len(recs)-1].ID,
+			Count:       int64(len(recs)),
+			SegmentHash: trailer.SegmentHash,
+			RetainUntil: now.Add(opts.Retain).Unix(),
+			CreatedAt:   now.Unix(),
+		}
+		if err := store.PutLocked(obj.Name, buf.Bytes(), opts.Mode, time.Unix(obj.RetainUntil, 0)); err != nil {
+			return res, fmt.Errorf("upload %s: %w", obj.Name, err)
+		}
+		if _, err := l.db.Exec(`INSERT INTO ledger_worm_exports(name, location, first_id, last_id, count, segment_hash, retain_until, created_at) VALUES(?, ?, ?, ?, ?, ?, ?, ?)`,
+			obj.Name, obj.Location, obj.FirstID, obj.LastID, obj.Count, obj.SegmentHash, obj.RetainUntil, obj.CreatedAt); err != nil {
+			return res, err
+		}
+
+		res.Objects = append(res.Objects, obj)
+		res.Records += obj.Count
+		res.LastSegmentHash = obj.SegmentHash
+		next = obj.LastID + 1
+	}
+}
+
+// WORMExports lists the objects written by ExportWORM, oldest first.
+func (l *Ledger) WORMExports() ([]WORMObject, error) {
+	if err := l.ensureWORMSchema(); err != nil {
+		return nil, err
+	}
+	rows, err := l.db.Query(`SELECT name, location, first_id, last_id, count, segment_hash, retain_until, created_at FROM ledger_worm_exports ORDER BY location, first_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	objs := []WORMObject{}
+	for rows.Next() {
+		var o WORMObject
+		if err := rows.Scan(&o.Name, &o.Location, &o.FirstID, &o.LastID, &o.Count, &o.SegmentHash, &o.RetainUntil, &o.CreatedAt); err != nil {
+			return nil, err
+		}
+		objs = append(objs, o)
+	}
+	return objs, rows.Err()
+}
+
+func (l *Ledger) ensureWORMSchema() error {
+	if l.wormReady.Load() {
+		return nil
+	}
+	if _, err := l.db.Exec(wormSchema); err != nil {
+		return err
+	}
+	l.wormReady.Store(true)
+	return nil
+}
@@ -0,0 +1,368 @@
+package sources
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"debug/buildinfo"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/Retr0-XD/StateLedger/internal/collectors"
+)
+
+// vcsDirs are skipped when hashing a source tree.
+var vcsDirs = map[string]bool{".git": true, ".hg": true, ".svn": true}
+
+// CaptureCode captures the code state at dir: the checked-out commit of a
+// git repository, or a content hash of the tree when there is no VCS.
+// exclude lists glob patterns, matched against each file and directory
+// name, to leave out of the content hash. For a Go module the build
+// settings of the local toolchain are recorded too, and any of
+// DefaultLockfiles found in dir are hashed.
+func CaptureCode(dir string, exclude []string) (collectors.CodePayload, error) {
+	dir = strings.TrimSpace(dir)
+	if dir == "" {
+		dir = "."
+	}
+	var payload collectors.CodePayload
+	var err error
+	if _, serr := os.Stat(filepath.Join(dir, ".git")); serr == nil {
+		payload, err = CaptureGit(dir)
+	} else {
+		payload, err = CaptureContentHash(dir, exclude)
+	}
+	if err != nil {
+		return collectors.CodePayload{}, err
+	}
+	payload.Build = captureGoEnv(dir)
+	if payload.LockfileHashes, err = CaptureLockfiles(dir, payload.Commit, DefaultLockfiles); err != nil {
+		return collectors.CodePayload{}, err
+	}
+	return payload, nil
+}
+
+// captureGoEnv returns the build settings the go command would use in dir,
+// or nil when dir is not a Go module or there is no go command.
+func captureGoEnv(dir string) *collectors.BuildInfo {
+	if _, err := os.Stat(filepath.Join(dir, "go.mod")); err != nil {
+		return nil
+	}
+	cmd := exec.Command("go", "env", "-json", "GOVERSION", "GOFLAGS", "CGO_ENABLED", "CC")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return nil
+	}
+	var env struct {
+		GoVersion  string `json:"GOVERSION"`
+		GoFlags    string `json:"GOFLAGS"`
+		CGOEnabled string `json:"CGO_ENABLED"`
+		CC         string `json:"CC"`
+	}
+	if err := json.Unmarshal(out, &env); err != nil {
+		return nil
+	}
+	build := &collectors.BuildInfo{
+		Compiler:   env.GoVersion,
+		GOFLAGS:    env.GoFlags,
+		CGOEnabled: env.CGOEnabled == "1",
+		Trimpath: slices.ContainsFunc(strings.Fields(env.GoFlags), func(f string) bool {
+			return f == "-trimpath" || f == "-trimpath=true"
+		}),
+	}
+	if build.CGOEnabled {
+		build.CC = env.CC
+	}
+	return build
+}
+
+// CaptureBinaryBuild reads the build settings embedded in a Go binary,
+// which describe how that binary was actually built.
+func CaptureBinaryBuild(binary string) (*collectors.BuildInfo, error) {
+	info, err := buildinfo.ReadFile(binary)
+	if err != nil {
+		return nil, err
+	}
+	build := &collectors.BuildInfo{Compiler: info.GoVersion}
+	var goflags []string
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "-trimpath":
+			build.Trimpath = s.Value == "true"
+		case "CGO_ENABLED":
+			build.CGOEnabled = s.Value == "1"
+		case "-ldflags":
+			build.Ldflags = s.Value
+		case "vcs.modified":
+			build.VCSModified = s.Value == "true"
+		case "-mod", "-tags", "-buildmode", "-race":
+			goflags = append(goflags, s.Key+"="+s.Value)
+		}
+	}
+	build.GOFLAGS = strings.Join(goflags, " ")
+	return build, nil
+}
+
+// readGitRefs resolves the repository name and HEAD commit by reading the
+// .git directory, for hosts without a git binary.
+func readGitRefs(repoPath string) (repo, commit string, err error) {
+	gitDir, commonDir, err := resolveGitDir(repoPath)
+	if err != nil {
+		return "", "", err
+	}
+
+	head, err := os.ReadFile(filepath.Join(gitDir, "HEAD"))
+	if err != nil {
+		return "", "", err
+	}
+	ref := strings.TrimSpace(string(head))
+	commit = ref
+	if name, ok := strings.CutPrefix(ref, "ref: "); ok {
+		if commit, err = resolveGitRef(gitDir, commonDir, name); err != nil {
+			return "", "", err
+		}
+	}
+	if !isCommitHash(commit) {
+		return "", "", fmt.Errorf("unexpected HEAD %q", ref)
+	}
+
+	repo = gitRemoteName(filepath.Join(commonDir, "config"))
+	if repo == "" {
+		abs, err := filepath.Abs(repoPath)
+		if err != nil {
+			return "", "", err
+		}
+		repo = filepath.Base(abs)
+	}
+	return repo, commit, nil
+}
+
+// resolveGitDir returns the git directory of the repository at repoPath
+// and the directory holding its shared refs and config, which differ for
+// linked worktrees. A .git file pointing elsewhere is followed.
+func resolveGitDir(repoPath string) (gitDir, commonDir string, err error) {
+	gitDir = filepath.Join(repoPath, ".git")
+	info, err := os.Stat(gitDir)
+	if err != nil {
+		return "", "", errors.New("not a git repository")
+	}
+	if !info.IsDir() {
+		data, err := os.ReadFile(gitDir)
+		if err != nil {
+			return "", "", err
+		}
+		target, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir: ")
+		if !ok {
+			return "", "", fmt.Errorf("unrecognized .git file in %s", repoPath)
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(repoPath, target)
+		}
+		gitDir = target
+	}
+
+	commonDir = gitDir
+	if data, err := os.ReadFile(filepath.Join(gitDir, "commondir")); err == nil {
+		commonDir = strings.TrimSpace(string(data))
+		if !filepath.IsAbs(commonDir) {
+			commonDir = filepath.Join(gitDir, commonDir)
+		}
+	}
+	return gitDir, commonDir, nil
+}
+
+// resolveGitRef follows symbolic refs to a commit hash, looking in loose
+// ref files and then packed-refs.
+func resolveGitRef(gitDir, commonDir, name string) (string, error) {
+	for depth := 0; depth < 5; depth++ {
+		var data []byte
+		var err error
+		for _, dir := range []string{gitDir, commonDir} {
+			if data, err = os.ReadFile(filepath.Join(dir, filepath.FromSlash(name))); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return packedRef(commonDir, name)
+		}
+		value := strings.TrimSpace(string(data))
+		next, ok := strings.CutPrefix(value, "ref: ")
+		if !ok {
+			return value, nil
+		}
+		name = next
+	}
+	return "", fmt.Errorf("too many levels of symbolic refs resolving %s", name)
+}
+
+func packedRef(commonDir, name string) (string, error) {
+	f, err := os.Open(filepath.Join(commonDir, "packed-refs"))
+	if err != nil {
+		return "", fmt.Errorf("ref %s not found; the repository may have no commits", name)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || line[0] == '#' || line[0] == '^' {
+			continue
+		}
+		hash, ref, ok := strings.Cut(line, " ")
+		if ok && ref == name {
+			return hash, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("ref %s not found; the repository may have no commits", name)
+}
+
+// gitRemoteName returns the repository name from the origin URL in a git
+// config file, the same way getGitRepoName does.
+func gitRemoteName(configPath string) string {
+	f, err := os.Open(configPath)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	inOrigin := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			inOrigin = line == `[remote "origin"]`
+			continue
+		}
+		if !inOrigin {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if ok && strings.TrimSpace(key) == "url" {
+			return repoNameFromURL(value)
+		}
+	}
+	return ""
+}
+
+func isCommitHash(s string) bool {
+	if len(s) != 40 && len(s) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// CaptureContentHash captures a source tree that is not under version
+// control. Commit is a SHA-256 over every file's slash-separated path and
+// content, in path order, so the same tree hashes the same on any host.
+// File modes are ignored since Windows has no executable bit. Symlinks
+// contribute their target; VCS metadata directories and names matching
+// exclude are skipped.
+func CaptureContentHash(root string, exclude []string) (collectors.CodePayload, error) {
+	root = strings.TrimSpace(root)
+	if root == "" {
+		root = "."
+	}
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return collectors.CodePayload{}, err
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return collectors.CodePayload{}, err
+	}
+	if !info.IsDir() {
+		return collectors.CodePayload{}, fmt.Errorf("%s is not a directory", root)
+	}
+
+	var entries []string
+	err = filepath.WalkDir(abs, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == abs {
+			return nil
+		}
+		if (d.IsDir() && vcsDirs[d.Name()]) || excluded(d.Name(), exclude) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(abs, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		switch {
+		case d.Type()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, rel+"\x00link\x00"+filepath.ToSlash(target))
+		case d.Type().IsRegular():
+			sum, err := hashFile(p)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, rel+"\x00file\x00"+sum)
+		}
+		return nil
+	})
+	if err != nil {
+		return collectors.CodePayload{}, err
+	}
+	if len(entries) == 0 {
+		return collectors.CodePayload{}, fmt.Errorf("no files to hash in %s", root)
+	}
+
+	sort.Strings(entries)
+	h := sha256.New()
+	for _, e := range entries {
+		io.WriteString(h, e)
+		io.WriteString(h, "\n")
+	}
+	return collectors.CodePayload{
+		Repo:   filepath.Base(abs),
+		Commit: "sha256:" + hex.EncodeToString(h.Sum(nil)),
+		Method: collectors.CodeMethodContentHash,
+	}, nil
+}
+
+func excluded(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func hashFile(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
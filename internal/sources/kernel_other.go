@@ -0,0 +1,7 @@
+//go:build !(aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris || windows)
+
+package sources
+
+func kernelVersion() string {
+	return ""
+}
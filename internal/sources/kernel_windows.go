@@ -0,0 +1,17 @@
+//go:build windows
+
+package sources
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/windows"
+)
+
+// kernelVersion returns the Windows NT version and build, e.g.
+// "10.0.22631". RtlGetVersion reports the real version, unlike
+// GetVersionEx, which is capped by the application manifest.
+func kernelVersion() string {
+	v := windows.RtlGetVersion()
+	return fmt.Sprintf("%d.%d.%d", v.MajorVersion, v.MinorVersion, v.BuildNumber)
+}
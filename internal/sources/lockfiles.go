@@ -0,0 +1,83 @@
+package sources
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/Retr0-XD/StateLedger/internal/collectors"
+)
+
+// DefaultLockfiles are the dependency lockfiles code capture looks for at
+// the root of the captured directory.
+var DefaultLockfiles = []string{
+	"go.sum",
+	"package-lock.json",
+	"npm-shrinkwrap.json",
+	"yarn.lock",
+	"pnpm-lock.yaml",
+	"Cargo.lock",
+	"poetry.lock",
+	"Pipfile.lock",
+	"uv.lock",
+	"Gemfile.lock",
+	"composer.lock",
+}
+
+// CaptureLockfiles hashes the lockfiles named by names, relative to dir,
+// that exist. When commit is a git commit and git is available, each is
+// also hashed as committed at commit, so locally modified dependencies
+// can be told apart from the ones the commit pins.
+func CaptureLockfiles(dir, commit string, names []string) ([]collectors.LockfileHash, error) {
+	var out []collectors.LockfileHash
+	for _, name := range names {
+		name = filepath.ToSlash(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		lf := collectors.LockfileHash{Path: name, Hash: lockfileHash(data)}
+		if isCommitHash(commit) {
+			lf.Committed, lf.Untracked = committedLockfile(dir, commit, name)
+		}
+		out = append(out, lf)
+	}
+	return out, nil
+}
+
+// committedLockfile returns the hash of name at commit, or untracked when
+// the commit does not contain it. Both are zero when git is missing or
+// cannot read the repository.
+func committedLockfile(dir, commit, name string) (hash string, untracked bool) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return "", false
+	}
+	blob, err := exec.Command("git", "-C", dir, "rev-parse", "--verify", "--quiet", commit+":"+name).Output()
+	var exit *exec.ExitError
+	if errors.As(err, &exit) && exit.ExitCode() == 1 {
+		return "", true
+	}
+	if err != nil {
+		return "", false
+	}
+	data, err := exec.Command("git", "-C", dir, "cat-file", "blob", strings.TrimSpace(string(blob))).Output()
+	if err != nil {
+		return "", false
+	}
+	return lockfileHash(data), false
+}
+
+func lockfileHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
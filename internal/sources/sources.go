@@ -3,10 +3,10 @@ package sources
 import (
 	"crypto/sha256"
 	"encoding/hex"
-	"encoding/json"
 	"errors"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"runtime"
 	"strings"
@@ -31,16 +31,20 @@ func CaptureGit(repoPath string) (collectors.CodePayload, error) {
 		return collectors.CodePayload{}, errors.New("not a git repository")
 	}
 
-	repo, err := getGitRepoName(repoPath)
-	if err != nil {
-		return collectors.CodePayload{}, err
+	// Containers often lack git, or refuse a repository owned by another
+	// user; the refs can still be read directly.
+	if _, err := exec.LookPath("git"); err == nil {
+		repo, rerr := getGitRepoName(repoPath)
+		commit, cerr := getGitCommit(repoPath)
+		if rerr == nil && cerr == nil {
+			return collectors.CodePayload{Repo: repo, Commit: commit}, nil
+		}
 	}
 
-	commit, err := getGitCommit(repoPath)
+	repo, commit, err := readGitRefs(repoPath)
 	if err != nil {
 		return collectors.CodePayload{}, err
 	}
-
 	return collectors.CodePayload{
 		Repo:   repo,
 		Commit: commit,
@@ -50,7 +54,7 @@ func CaptureGit(repoPath string) (collectors.CodePayload, error) {
 func CaptureEnvironment() (collectors.EnvironmentPayload, error) {
 	return collectors.EnvironmentPayload{
 		OS:         runtime.GOOS,
-		Kernel:     runtime.GOARCH,
+		Kernel:     kernelVersion(),
 		Runtime:    runtime.Version(),
 		Arch:       runtime.GOARCH,
 		TimeSource: "system",
@@ -72,7 +76,8 @@ func CaptureConfig(source string) (collectors.ConfigPayload, error) {
 	hash := computeConfigHash(snapshot)
 
 	return collectors.ConfigPayload{
-		Source:   source,
+		// Slash-separated so the same file is one source across hosts.
+		Source:   filepath.ToSlash(source),
 		Version:  "1",
 		Hash:     hash,
 		Snapshot: snapshot,
@@ -90,11 +95,15 @@ func getGitRepoName(repoPath string) (string, error) {
 		}
 		return filepath.Base(strings.TrimSpace(string(output))), nil
 	}
-	url := strings.TrimSpace(string(output))
-	if strings.HasSuffix(url, ".git") {
-		url = url[:len(url)-4]
-	}
-	return filepath.Base(url), nil
+	return repoNameFromURL(string(output)), nil
+}
+
+// repoNameFromURL returns the last path element of a remote URL, which may
+// be an scp-style address (git@host:org/repo.git) or a Windows path.
+func repoNameFromURL(url string) string {
+	url = strings.TrimSuffix(strings.TrimSpace(url), ".git")
+	url = strings.NewReplacer(`\`, "/", ":", "/").Replace(url)
+	return path.Base(strings.TrimRight(url, "/"))
 }
 
 func getGitCommit(repoPath string) (string, error) {
@@ -111,13 +120,34 @@ func computeConfigHash(snapshot string) string {
 	return "sha256:" + hex.EncodeToString(sum[:])
 }
 
+// CaptureFromManifest captures one source. For code, params["exclude"] is a
+// comma-separated list of name patterns left out of a content hash, and
+// params["binary"] a built Go binary whose embedded build settings are
+// recorded instead of the local toolchain's, and params["lockfiles"] a
+// comma-separated list of lockfile paths hashed instead of
+// DefaultLockfiles.
 func CaptureFromManifest(kind, source string, params map[string]string) (CaptureResult, error) {
 	var payload any
 	var err error
 
+	// Windows accepts forward slashes, and recording them keeps a source's
+	// name the same whichever host captured it.
+	source = filepath.ToSlash(source)
+
 	switch kind {
 	case "code":
-		payload, err = CaptureGit(source)
+		var exclude []string
+		if params["exclude"] != "" {
+			exclude = strings.Split(params["exclude"], ",")
+		}
+		code, cerr := CaptureCode(source, exclude)
+		if cerr == nil && params["binary"] != "" {
+			code.Build, cerr = CaptureBinaryBuild(params["binary"])
+		}
+		if cerr == nil && params["lockfiles"] != "" {
+			code.LockfileHashes, cerr = CaptureLockfiles(source, code.Commit, strings.Split(params["lockfiles"], ","))
+		}
+		payload, err = code, cerr
 	case "config":
 		payload, err = CaptureConfig(source)
 	case "environment":
@@ -134,10 +164,17 @@ func CaptureFromManifest(kind, source string, params map[string]string) (Capture
 		}, nil
 	}
 
-	data, _ := json.Marshal(payload)
+	data, err := collectors.MarshalPayload(payload)
+	if err != nil {
+		return CaptureResult{
+			Kind:   kind,
+			Source: source,
+			Error:  err.Error(),
+		}, nil
+	}
 	return CaptureResult{
 		Kind:    kind,
 		Source:  source,
-		Payload: string(data),
+		Payload: data,
 	}, nil
 }
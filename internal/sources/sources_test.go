@@ -3,6 +3,7 @@ package sources
 import (
 	"encoding/json"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"runtime"
 	"strings"
@@ -29,6 +30,12 @@ func TestCaptureEnvironment(t *testing.T) {
 	if payload.TimeSource != "system" {
 		t.Errorf("TimeSource = %v, want system", payload.TimeSource)
 	}
+	switch runtime.GOOS {
+	case "linux", "darwin", "windows", "freebsd":
+		if payload.Kernel == "" || payload.Kernel == runtime.GOARCH {
+			t.Errorf("Kernel = %q, want the kernel release", payload.Kernel)
+		}
+	}
 
 	// Validate the payload
 	if err := payload.Validate(); err != nil {
@@ -207,3 +214,256 @@ func TestCaptureGit(t *testing.T) {
 		}
 	})
 }
+
+func TestReadGitRefs(t *testing.T) {
+	commit := strings.Repeat("ab", 20)
+	write := func(t *testing.T, path, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("packed ref and origin", func(t *testing.T) {
+		dir := t.TempDir()
+		write(t, filepath.Join(dir, ".git", "HEAD"), "ref: refs/heads/main\n")
+		write(t, filepath.Join(dir, ".git", "packed-refs"), "# pack-refs with: peeled\n"+commit+" refs/heads/main\n")
+		write(t, filepath.Join(dir, ".git", "config"), "[core]\n\tbare = false\n[remote \"origin\"]\n\turl = https://github.com/acme/payments.git\n")
+		repo, got, err := readGitRefs(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if repo != "payments" || got != commit {
+			t.Errorf("got %s@%s", repo, got)
+		}
+	})
+
+	t.Run("loose ref overrides packed", func(t *testing.T) {
+		dir := t.TempDir()
+		loose := strings.Repeat("cd", 20)
+		write(t, filepath.Join(dir, ".git", "HEAD"), "ref: refs/heads/main\n")
+		write(t, filepath.Join(dir, ".git", "packed-refs"), commit+" refs/heads/main\n")
+		write(t, filepath.Join(dir, ".git", "refs", "heads", "main"), loose+"\n")
+		repo, got, err := readGitRefs(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if repo != filepath.Base(dir) || got != loose {
+			t.Errorf("got %s@%s", repo, got)
+		}
+	})
+
+	t.Run("detached worktree", func(t *testing.T) {
+		main := t.TempDir()
+		wt := t.TempDir()
+		gitDir := filepath.Join(main, ".git", "worktrees", "wt")
+		write(t, filepath.Join(wt, ".git"), "gitdir: "+gitDir+"\n")
+		write(t, filepath.Join(gitDir, "HEAD"), commit+"\n")
+		write(t, filepath.Join(gitDir, "commondir"), "../..\n")
+		write(t, filepath.Join(main, ".git", "config"), "[remote \"origin\"]\n\turl = git@github.com:acme/api.git\n")
+		repo, got, err := readGitRefs(wt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if repo != "api" || got != commit {
+			t.Errorf("got %s@%s", repo, got)
+		}
+	})
+
+	t.Run("no commits", func(t *testing.T) {
+		dir := t.TempDir()
+		write(t, filepath.Join(dir, ".git", "HEAD"), "ref: refs/heads/main\n")
+		if _, _, err := readGitRefs(dir); err == nil {
+			t.Error("expected an error for an unborn branch")
+		}
+	})
+}
+
+func TestCaptureContentHash(t *testing.T) {
+	tree := func(t *testing.T, files map[string]string) string {
+		t.Helper()
+		dir := t.TempDir()
+		for name, content := range files {
+			path := filepath.Join(dir, filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return dir
+	}
+	files := map[string]string{"main.go": "package main\n", "pkg/util.go": "package pkg\n"}
+
+	a, err := CaptureContentHash(tree(t, files), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Method != collectors.CodeMethodContentHash || !strings.HasPrefix(a.Commit, "sha256:") {
+		t.Fatalf("unexpected payload %+v", a)
+	}
+	if err := a.Validate(); err != nil {
+		t.Fatalf("content-hash payload should validate: %v", err)
+	}
+
+	// VCS metadata and excluded names do not affect the hash.
+	withExtras := tree(t, map[string]string{
+		"main.go": "package main\n", "pkg/util.go": "package pkg\n",
+		".hg/store": "x", "build/out.bin": "y",
+	})
+	b, err := CaptureContentHash(withExtras, []string{"build"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Commit != a.Commit {
+		t.Errorf("identical trees hashed differently: %s vs %s", a.Commit, b.Commit)
+	}
+
+	changed, err := CaptureContentHash(tree(t, map[string]string{"main.go": "package main\n// edit\n", "pkg/util.go": "package pkg\n"}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed.Commit == a.Commit {
+		t.Error("a content change should change the hash")
+	}
+
+	// Without a .git directory, code capture falls back to the content hash.
+	result, err := CaptureFromManifest("code", withExtras, map[string]string{"exclude": "build"})
+	if err != nil || result.Error != "" {
+		t.Fatalf("capture: %+v %v", result, err)
+	}
+	if !strings.Contains(result.Payload, a.Commit) {
+		t.Errorf("payload %s should carry the content hash", result.Payload)
+	}
+}
+
+func TestCaptureBuild(t *testing.T) {
+	// The test binary carries the build settings it was compiled with.
+	build, err := CaptureBinaryBuild(os.Args[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if build.Compiler != runtime.Version() {
+		t.Errorf("compiler = %q, want %q", build.Compiler, runtime.Version())
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	result, err := CaptureFromManifest("code", dir, map[string]string{"binary": os.Args[0]})
+	if err != nil || result.Error != "" {
+		t.Fatalf("capture: %+v %v", result, err)
+	}
+	var payload collectors.CodePayload
+	if err := collectors.ParseJSON(result.Payload, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Build == nil || payload.Build.Compiler != runtime.Version() {
+		t.Errorf("payload build = %+v", payload.Build)
+	}
+	if result, _ := CaptureFromManifest("code", dir, map[string]string{"binary": filepath.Join(dir, "main.go")}); result.Error == "" {
+		t.Error("a file that is not a Go binary should fail the capture")
+	}
+
+	// A Go module records the local toolchain; other trees record nothing.
+	if code, err := CaptureCode(dir, nil); err != nil || code.Build != nil {
+		t.Errorf("non-module build = %+v (%v)", code.Build, err)
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not available")
+	}
+	t.Setenv("GOFLAGS", "-trimpath")
+	t.Setenv("CGO_ENABLED", "0")
+	module := t.TempDir()
+	if err := os.WriteFile(filepath.Join(module, "go.mod"), []byte("module example.com/app\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	code, err := CaptureCode(module, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := code.Build; b == nil || !strings.HasPrefix(b.Compiler, "go") || !b.Trimpath || b.CGOEnabled || b.CC != "" {
+		t.Errorf("module build = %+v", code.Build)
+	}
+}
+
+func TestCaptureLockfiles(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	dir := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=t", "-c", "user.email=t@example.com"}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git("init", "-q")
+	write("go.sum", "example.com/a v1.0.0 h1:abc\n")
+	write("Cargo.lock", "version = 3\n")
+	git("add", ".")
+	git("commit", "-q", "-m", "pin")
+	write("Cargo.lock", "version = 4\n")
+	write("yarn.lock", "# yarn\n")
+
+	code, err := CaptureCode(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]collectors.LockfileHash{}
+	for _, lf := range code.LockfileHashes {
+		got[lf.Path] = lf
+	}
+	if len(got) != 3 {
+		t.Fatalf("lockfiles = %+v", code.LockfileHashes)
+	}
+	if lf := got["go.sum"]; lf.Committed != lf.Hash || lf.Untracked {
+		t.Errorf("unchanged lockfile = %+v", lf)
+	}
+	if lf := got["Cargo.lock"]; lf.Committed == "" || lf.Committed == lf.Hash {
+		t.Errorf("modified lockfile = %+v", lf)
+	}
+	if lf := got["yarn.lock"]; !lf.Untracked || lf.Committed != "" {
+		t.Errorf("untracked lockfile = %+v", lf)
+	}
+
+	result, err := CaptureFromManifest("code", dir, map[string]string{"lockfiles": "go.sum, missing.lock"})
+	if err != nil || result.Error != "" {
+		t.Fatalf("capture: %+v %v", result, err)
+	}
+	var payload collectors.CodePayload
+	if err := collectors.ParseJSON(result.Payload, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if len(payload.LockfileHashes) != 1 || payload.LockfileHashes[0].Path != "go.sum" {
+		t.Errorf("manifest lockfiles = %+v", payload.LockfileHashes)
+	}
+}
+
+func TestRepoNameFromURL(t *testing.T) {
+	tests := map[string]string{
+		"https://github.com/acme/payments.git\n": "payments",
+		"git@github.com:acme/api.git":            "api",
+		"git@host:solo":                          "solo",
+		"ssh://git@host:2222/acme/svc/":          "svc",
+		`C:\repos\tools.git`:                     "tools",
+		"/srv/git/app.git":                       "app",
+	}
+	for url, want := range tests {
+		if got := repoNameFromURL(url); got != want {
+			t.Errorf("repoNameFromURL(%q) = %q, want %q", url, got, want)
+		}
+	}
+}
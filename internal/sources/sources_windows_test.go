@@ -0,0 +1,64 @@
+//go:build windows
+
+package sources
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCaptureEnvironmentWindowsKernel(t *testing.T) {
+	payload, err := CaptureEnvironment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(payload.Kernel, ".")
+	if len(parts) != 3 || parts[0] == "0" {
+		t.Errorf("Kernel = %q, want major.minor.build", payload.Kernel)
+	}
+}
+
+func TestCaptureFromManifestWindowsPaths(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "conf", "app.yaml")
+	if err := os.MkdirAll(filepath.Dir(config), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(config, []byte("port: 8080\r\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := CaptureFromManifest("config", config, nil)
+	if err != nil || result.Error != "" {
+		t.Fatalf("capture: %+v %v", result, err)
+	}
+	if strings.Contains(result.Source, `\`) || strings.Contains(result.Payload, `\\`) {
+		t.Errorf("source should be slash-separated: %s", result.Payload)
+	}
+
+	// Git detection works with backslash paths and a .git file.
+	repo := filepath.Join(dir, "repo")
+	gitDir := filepath.Join(dir, "gitdir")
+	commit := strings.Repeat("ef", 20)
+	if err := os.MkdirAll(gitDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(repo, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(repo, ".git"), []byte("gitdir: "+gitDir+"\r\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(gitDir, "HEAD"), []byte(commit+"\r\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	name, got, err := readGitRefs(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "repo" || got != commit {
+		t.Errorf("got %s@%s", name, got)
+	}
+}
@@ -0,0 +1,329 @@
+package agent
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Retr0-XD/StateLedger/internal/api"
+	"github.com/Retr0-XD/StateLedger/internal/ledger"
+	"github.com/Retr0-XD/StateLedger/pkg/client"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  ed25519.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) testCA {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+func (ca testCA) issue(t *testing.T, key ed25519.PrivateKey) []byte {
+	t.Helper()
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: ID(key)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, key.Public(), ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+type testEnv struct {
+	ledger *ledger.Ledger
+	server *httptest.Server
+	ca     testCA
+}
+
+func newTestEnv(t *testing.T) testEnv {
+	t.Helper()
+	l, err := ledger.Open(filepath.Join(t.TempDir(), "ledger.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.InitSchema(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	ca := newTestCA(t)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	ts := httptest.NewUnstartedServer(api.NewServer(l, "").Handler())
+	ts.TLS = &tls.Config{ClientCAs: pool, ClientAuth: tls.VerifyClientCertIfGiven}
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+
+	return testEnv{ledger: l, server: ts, ca: ca}
+}
+
+// client returns an API client presenting certPEM, or no certificate when
+// certPEM is nil.
+func (env testEnv) client(t *testing.T, key ed25519.PrivateKey, certPEM []byte, opts ...client.Option) *client.Client {
+	t.Helper()
+	tr := env.server.Client().Transport.(*http.Transport).Clone()
+	if certPEM != nil {
+		cfg, err := TLSConfig(key, certPEM, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr.TLSClientConfig.Certificates = cfg.Certificates
+	}
+	c, err := client.New(env.server.URL, append([]client.Option{client.WithHTTPClient(&http.Client{Transport: tr})}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func (env testEnv) registerAgent(t *testing.T) (ed25519.PrivateKey, *client.Client) {
+	t.Helper()
+	key, err := LoadOrCreateKey(filepath.Join(t.TempDir(), "agent.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.ledger.RegisterAgent(ledger.AgentRegistration{Hostname: "edge-1", PublicKey: PublicKey(key)}); err != nil {
+		t.Fatal(err)
+	}
+	return key, env.client(t, key, env.ca.issue(t, key))
+}
+
+func openTestSpool(t *testing.T) *Spool {
+	t.Helper()
+	s, err := OpenSpool(filepath.Join(t.TempDir(), "spool.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func spoolRecords(t *testing.T, s *Spool, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if _, err := s.Add(context.Background(), client.AgentRecord{Timestamp: int64(1000 + i), Type: "code", Source: "edge", Payload: `{"repo":"app","commit":"abc"}`}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPushResumesAfterLostAcknowledgement(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t)
+	key, c := env.registerAgent(t)
+	spool := openTestSpool(t)
+	p := &Pusher{Client: c, Spool: spool, AgentID: ID(key), BatchSize: 2}
+
+	spoolRecords(t, spool, 3)
+	if n, err := p.PushOnce(ctx); err != nil || n != 3 {
+		t.Fatalf("first push: n=%d err=%v", n, err)
+	}
+
+	// The server accepts the next batch but the acknowledgement is lost.
+	spoolRecords(t, spool, 2)
+	pending, _ := spool.Pending(ctx, 10)
+	if err := spool.MarkSent(ctx, pending[len(pending)-1].Seq); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Ingest(ctx, ID(key), pending); err != nil {
+		t.Fatalf("ingest: %v", err)
+	}
+	if n, err := p.PushOnce(ctx); err != nil || n != 0 {
+		t.Fatalf("resumed push should not resend: n=%d err=%v", n, err)
+	}
+	if n, _ := spool.PendingCount(ctx); n != 0 {
+		t.Fatalf("expected drained spool, %d pending", n)
+	}
+
+	// A recreated spool numbers from 1 again; its records must still land.
+	fresh := openTestSpool(t)
+	spoolRecords(t, fresh, 1)
+	p.Spool = fresh
+	if n, err := p.PushOnce(ctx); err != nil || n != 1 {
+		t.Fatalf("push from recreated spool: n=%d err=%v", n, err)
+	}
+
+	records, err := env.ledger.List(ledger.ListQuery{})
+	if err != nil || len(records) != 6 {
+		t.Fatalf("expected 6 records, got %d (%v)", len(records), err)
+	}
+	for _, rec := range records {
+		if rec.AgentID != ID(key) {
+			t.Fatalf("record %d not attributed to agent: %+v", rec.ID, rec)
+		}
+	}
+	if cursor, _ := env.ledger.IngestCursor(ID(key)); cursor != 6 {
+		t.Fatalf("expected cursor 6, got %d", cursor)
+	}
+}
+
+func TestIngestRequiresMatchingClientCertificate(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t)
+	key, _ := env.registerAgent(t)
+
+	var apiErr *client.APIError
+	_, err := env.client(t, key, nil).IngestCursor(ctx, ID(key))
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without certificate, got %v", err)
+	}
+
+	_, other, _ := ed25519.GenerateKey(rand.Reader)
+	_, err = env.client(t, other, env.ca.issue(t, other)).IngestCursor(ctx, ID(key))
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected 403 for another agent's certificate, got %v", err)
+	}
+
+	_, err = env.client(t, key, env.ca.issue(t, key)).Ingest(ctx, ID(key), []client.AgentRecord{{Seq: 2, Timestamp: 1, Type: "code", Payload: "{}"}})
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusConflict {
+		t.Fatalf("expected 409 for a sequence gap, got %v", err)
+	}
+}
+
+func TestPushSignedBySourceKey(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t)
+	key, unsigned := env.registerAgent(t)
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	if _, err := env.ledger.SetSourceKey("edge", ledger.SourceKeyEd25519, base64.StdEncoding.EncodeToString(pub)); err != nil {
+		t.Fatal(err)
+	}
+	spool := openTestSpool(t)
+	spoolRecords(t, spool, 2)
+
+	var apiErr *client.APIError
+	p := &Pusher{Client: unsigned, Spool: spool, AgentID: ID(key)}
+	if _, err := p.PushOnce(ctx); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for an unsigned push, got %v", err)
+	}
+	_, other, _ := ed25519.GenerateKey(rand.Reader)
+	p.Client = env.client(t, key, env.ca.issue(t, key), client.WithSourceKey("edge", client.SourceKeyEd25519, base64.StdEncoding.EncodeToString(other.Seed())))
+	if _, err := p.PushOnce(ctx); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a push signed by another key, got %v", err)
+	}
+
+	p.Client = env.client(t, key, env.ca.issue(t, key), client.WithSourceKey("edge", client.SourceKeyEd25519, base64.StdEncoding.EncodeToString(priv.Seed())))
+	if n, err := p.PushOnce(ctx); err != nil || n != 2 {
+		t.Fatalf("signed push: n=%d err=%v", n, err)
+	}
+}
+
+func TestSyncBuffersWhileOfflineAndPreservesChain(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t)
+	key, c := env.registerAgent(t)
+	spool := openTestSpool(t)
+
+	offline, err := client.New("http://127.0.0.1:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Pusher{Client: offline, Spool: spool, AgentID: ID(key)}
+	spoolRecords(t, spool, 3)
+	if n, isOffline, err := p.Sync(ctx); err != nil || !isOffline || n != 0 {
+		t.Fatalf("offline sync: n=%d offline=%v err=%v", n, isOffline, err)
+	}
+	if n, _ := spool.PendingCount(ctx); n != 3 {
+		t.Fatalf("expected 3 buffered records, got %d", n)
+	}
+	if err := spool.Verify(ctx); err != nil {
+		t.Fatalf("verify spool: %v", err)
+	}
+
+	p.Client = c
+	if n, isOffline, err := p.Sync(ctx); err != nil || isOffline || n != 3 {
+		t.Fatalf("online sync: n=%d offline=%v err=%v", n, isOffline, err)
+	}
+	head, _ := spool.Head(ctx)
+	records, err := env.ledger.List(ledger.ListQuery{})
+	if err != nil || len(records) != 3 {
+		t.Fatalf("expected 3 records, got %d (%v)", len(records), err)
+	}
+	if records[0].Timestamp != 1000 || records[2].Timestamp != 1002 {
+		t.Fatalf("original timestamps not preserved: %+v", records)
+	}
+	if res, err := env.ledger.VerifyAgentChain(ID(key)); err != nil || !res.OK || res.Checked != 3 {
+		t.Fatalf("verify agent chain: %+v %v", res, err)
+	}
+
+	// The chain continues across acknowledgements; a record altered in the
+	// spool is caught locally and rejected by the server.
+	spoolRecords(t, spool, 1)
+	if rec, _ := spool.Pending(ctx, 1); rec[0].PrevHash != head {
+		t.Fatalf("expected chain to continue from %s, got %+v", head, rec[0])
+	}
+	if _, err := spool.db.Exec(`UPDATE agent_spool SET payload = '{}'`); err != nil {
+		t.Fatal(err)
+	}
+	if err := spool.Verify(ctx); !errors.Is(err, ErrSpoolTampered) {
+		t.Fatalf("expected ErrSpoolTampered, got %v", err)
+	}
+	var apiErr *client.APIError
+	if _, _, err := p.Sync(ctx); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a tampered record, got %v", err)
+	}
+}
+
+func TestSyncCanonicalType(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t)
+	if err := env.ledger.RegisterType(ledger.TypeSpec{Name: "inventory", Canonical: true}); err != nil {
+		t.Fatal(err)
+	}
+	key, c := env.registerAgent(t)
+	spool := openTestSpool(t)
+	if _, err := spool.Add(ctx, client.AgentRecord{Timestamp: 1000, Type: "inventory", Source: "edge", Payload: "{\"pods\": 3, \"name\": \"web\"}"}); err != nil {
+		t.Fatal(err)
+	}
+
+	p := &Pusher{Client: c, Spool: spool, AgentID: ID(key)}
+	if n, _, err := p.Sync(ctx); err != nil || n != 1 {
+		t.Fatalf("sync: n=%d err=%v", n, err)
+	}
+	records, err := env.ledger.List(ledger.ListQuery{})
+	if err != nil || len(records) != 1 || records[0].Payload != `{"name":"web","pods":3}` {
+		t.Fatalf("records = %+v (%v)", records, err)
+	}
+	if res, err := env.ledger.VerifyAgentChain(ID(key)); err != nil || !res.OK {
+		t.Fatalf("verify agent chain: %+v %v", res, err)
+	}
+}
@@ -0,0 +1,127 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Retr0-XD/StateLedger/pkg/client"
+)
+
+// Pusher sends spooled records to the server.
+type Pusher struct {
+	Client  *client.Client
+	Spool   *Spool
+	AgentID string
+	// BatchSize bounds how many records are sent per request. Defaults to 500.
+	BatchSize int
+	// Interval is the push period used by Run. Defaults to 30 seconds.
+	Interval time.Duration
+	// OnError receives push failures; Run retries them on the next tick.
+	OnError func(err error)
+}
+
+// PushOnce resumes from the server's cursor and pushes pending records until
+// the spool is drained or a request fails. It returns the number of records
+// the server accepted.
+//
+// A record is dropped from the spool only after the server acknowledges it.
+// If the acknowledgement is lost, the next push resends the batch and the
+// server skips what it already accepted.
+func (p *Pusher) PushOnce(ctx context.Context) (int, error) {
+	batch := p.BatchSize
+	if batch <= 0 {
+		batch = 500
+	}
+
+	if err := p.resume(ctx); err != nil {
+		return 0, err
+	}
+
+	pushed, resynced := 0, false
+	for {
+		records, err := p.Spool.Pending(ctx, batch)
+		if err != nil {
+			return pushed, err
+		}
+		if len(records) == 0 {
+			return pushed, nil
+		}
+
+		if err := p.Spool.MarkSent(ctx, records[len(records)-1].Seq); err != nil {
+			return pushed, err
+		}
+		res, err := p.Client.Ingest(ctx, p.AgentID, records)
+		var apiErr *client.APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusConflict && !resynced {
+			// The server's cursor moved, e.g. another process pushed with
+			// the same key; resynchronize once and continue.
+			if err := p.resume(ctx); err != nil {
+				return pushed, err
+			}
+			resynced = true
+			continue
+		}
+		if err != nil {
+			return pushed, err
+		}
+		if err := p.Spool.Ack(ctx, res.LastSeq); err != nil {
+			return pushed, err
+		}
+		pushed += res.Accepted
+	}
+}
+
+// Sync pushes pending records like PushOnce, for callers that buffer every
+// record before sending it. If the server cannot be reached the records stay
+// spooled for a later push, and Sync reports offline instead of an error.
+func (p *Pusher) Sync(ctx context.Context) (pushed int, offline bool, err error) {
+	pushed, err = p.PushOnce(ctx)
+	if err != nil && unreachable(err) && ctx.Err() == nil {
+		return pushed, true, nil
+	}
+	return pushed, false, err
+}
+
+// unreachable reports whether err means the request never got an answer
+// from the server, as opposed to the server rejecting it.
+func unreachable(err error) bool {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return true
+	}
+	var apiErr *client.APIError
+	return errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusBadGateway ||
+		apiErr.StatusCode == http.StatusServiceUnavailable || apiErr.StatusCode == http.StatusGatewayTimeout)
+}
+
+// Run pushes every Interval until ctx is cancelled.
+func (p *Pusher) Run(ctx context.Context) error {
+	interval := p.Interval
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := p.PushOnce(ctx); err != nil && p.OnError != nil && ctx.Err() == nil {
+			p.OnError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Pusher) resume(ctx context.Context) error {
+	cursor, err := p.Client.IngestCursor(ctx, p.AgentID)
+	if err != nil {
+		return err
+	}
+	return p.Spool.Resume(ctx, cursor)
+}
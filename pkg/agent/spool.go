@@ -0,0 +1,283 @@
+// Package agent buffers records on edge hosts and pushes them to a central
+// StateLedger server.
+//
+// Records are written to a local Spool first, so capture keeps working while
+// the server is unreachable. A Pusher sends pending records in compressed
+// batches over mTLS. Each record carries a sequence number, and the server
+// acknowledges the last sequence it accepted. A push interrupted at any
+// point is resumed from that acknowledgement without losing or duplicating
+// records.
+//
+// The spool is itself a small ledger: each record is hash-chained to the one
+// buffered before it, with the same hash the server uses, and keeps the
+// timestamp it was captured at. The chain travels with the records, so the
+// server can prove they were recorded in order while the agent was offline.
+package agent
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Retr0-XD/StateLedger/internal/collectors"
+	"github.com/Retr0-XD/StateLedger/internal/ledger"
+	"github.com/Retr0-XD/StateLedger/pkg/client"
+
+	_ "modernc.org/sqlite"
+)
+
+const spoolSchema = `
+CREATE TABLE IF NOT EXISTS agent_spool (
+	seq INTEGER PRIMARY KEY AUTOINCREMENT,
+	ts INTEGER NOT NULL,
+	type TEXT NOT NULL,
+	source TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	hash TEXT NOT NULL DEFAULT '',
+	prev_hash TEXT NOT NULL DEFAULT ''
+);
+CREATE TABLE IF NOT EXISTS agent_spool_state (
+	key TEXT PRIMARY KEY,
+	value INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS agent_spool_head (
+	id INTEGER PRIMARY KEY CHECK (id = 1),
+	hash TEXT NOT NULL
+);
+`
+
+// ErrSpoolBehind is returned when records the server never acknowledged
+// are missing from the spool, e.g. because the server was restored from a
+// backup after the spool dropped them.
+var ErrSpoolBehind = errors.New("spool is missing records the server has not accepted")
+
+// ErrSpoolTampered is returned by Verify when a buffered record no longer
+// matches its chain hash.
+var ErrSpoolTampered = errors.New("spool chain broken")
+
+// Spool is a local SQLite buffer of records waiting to be pushed.
+type Spool struct {
+	db *sql.DB
+}
+
+// OpenSpool opens or creates the spool database at path.
+func OpenSpool(path string) (*Spool, error) {
+	if path == "" {
+		return nil, errors.New("spool path required")
+	}
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// A single connection serializes sequence assignment and acknowledgement.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(spoolSchema); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	if err := migrateSpool(db); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &Spool{db: db}, nil
+}
+
+// migrateSpool adds the chain columns to spools created before records were
+// chained. Their existing records are pushed without proofs.
+func migrateSpool(db *sql.DB) error {
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('agent_spool') WHERE name = 'hash'`).Scan(&n); err != nil || n > 0 {
+		return err
+	}
+	_, err := db.Exec(`ALTER TABLE agent_spool ADD COLUMN hash TEXT NOT NULL DEFAULT '';
+		ALTER TABLE agent_spool ADD COLUMN prev_hash TEXT NOT NULL DEFAULT '';`)
+	return err
+}
+
+// Close closes the spool database.
+func (s *Spool) Close() error {
+	return s.db.Close()
+}
+
+// Add buffers a record, chaining it to the previously buffered one, and
+// returns its sequence number. rec.Seq, rec.Hash and rec.PrevHash are
+// ignored.
+func (s *Spool) Add(ctx context.Context, rec client.AgentRecord) (int64, error) {
+	if rec.Type == "" {
+		return 0, errors.New("type required")
+	}
+	if rec.Payload == "" {
+		return 0, errors.New("payload required")
+	}
+	// JSON objects and arrays are buffered as canonical JSON, so that the
+	// record hashes the same when the ledger canonicalizes its type.
+	if p := strings.TrimSpace(rec.Payload); strings.HasPrefix(p, "{") || strings.HasPrefix(p, "[") {
+		if canonical, err := collectors.CanonicalizeJSON([]byte(p)); err == nil {
+			rec.Payload = string(canonical)
+		}
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	prev, err := head(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+	hash := ledger.RecordHash(prev, rec.Timestamp, rec.Type, rec.Source, rec.Payload)
+	res, err := tx.ExecContext(ctx, `INSERT INTO agent_spool(ts, type, source, payload, hash, prev_hash) VALUES(?, ?, ?, ?, ?, ?)`,
+		rec.Timestamp, rec.Type, rec.Source, rec.Payload, hash, prev)
+	if err != nil {
+		return 0, err
+	}
+	seq, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO agent_spool_head(id, hash) VALUES(1, ?)
+		ON CONFLICT(id) DO UPDATE SET hash = excluded.hash`, hash); err != nil {
+		return 0, err
+	}
+	return seq, tx.Commit()
+}
+
+// Head returns the hash of the last record buffered, or "" if nothing has
+// been buffered yet. It survives acknowledgement, so the chain continues
+// across pushes.
+func (s *Spool) Head(ctx context.Context) (string, error) {
+	return head(ctx, s.db)
+}
+
+func head(ctx context.Context, q interface {
+	QueryRowContext(context.Context, string, ...any) *sql.Row
+}) (string, error) {
+	var hash string
+	err := q.QueryRowContext(ctx, `SELECT hash FROM agent_spool_head WHERE id = 1`).Scan(&hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return hash, err
+}
+
+// Pending returns up to limit unacknowledged records in sequence order.
+func (s *Spool) Pending(ctx context.Context, limit int) ([]client.AgentRecord, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT seq, ts, type, source, payload, hash, prev_hash FROM agent_spool ORDER BY seq ASC LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []client.AgentRecord
+	for rows.Next() {
+		var rec client.AgentRecord
+		if err := rows.Scan(&rec.Seq, &rec.Timestamp, &rec.Type, &rec.Source, &rec.Payload, &rec.Hash, &rec.PrevHash); err != nil {
+			return nil, err
+		}
+		out = append(out, rec)
+	}
+	return out, rows.Err()
+}
+
+// Verify checks that the pending records still match their chain hashes
+// and link to one another.
+func (s *Spool) Verify(ctx context.Context) error {
+	records, err := s.Pending(ctx, -1)
+	if err != nil {
+		return err
+	}
+	for i, rec := range records {
+		if rec.Hash == "" {
+			// Buffered before records were chained.
+			continue
+		}
+		if !ledger.RecordHashMatches(rec.Hash, rec.PrevHash, rec.Timestamp, rec.Type, rec.Source, rec.Payload) {
+			return fmt.Errorf("%w: hash mismatch at seq %d", ErrSpoolTampered, rec.Seq)
+		}
+		if i > 0 && records[i-1].Hash != "" && rec.PrevHash != records[i-1].Hash {
+			return fmt.Errorf("%w: seq %d does not follow seq %d", ErrSpoolTampered, rec.Seq, records[i-1].Seq)
+		}
+	}
+	return nil
+}
+
+// PendingCount returns the number of unacknowledged records.
+func (s *Spool) PendingCount(ctx context.Context) (int, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM agent_spool`).Scan(&n)
+	return n, err
+}
+
+// Ack drops every record up to and including seq.
+func (s *Spool) Ack(ctx context.Context, seq int64) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM agent_spool WHERE seq <= ?`, seq)
+	return err
+}
+
+// MarkSent records that every record up to seq has been sent to the
+// server at least once. Resume uses it to tell acknowledged records from
+// records a recreated spool numbered below the server's cursor.
+func (s *Spool) MarkSent(ctx context.Context, seq int64) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO agent_spool_state(key, value) VALUES('sent', ?)
+		ON CONFLICT(key) DO UPDATE SET value = MAX(value, excluded.value)`, seq)
+	return err
+}
+
+// Resume aligns the spool with the server's last accepted sequence. Sent
+// records up to the cursor are dropped. Unsent records numbered at or below
+// the cursor, which happens when the spool was recreated, are renumbered to
+// follow it.
+func (s *Spool) Resume(ctx context.Context, cursor int64) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var sent int64
+	err = tx.QueryRowContext(ctx, `SELECT value FROM agent_spool_state WHERE key = 'sent'`).Scan(&sent)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM agent_spool WHERE seq <= ?`, min(cursor, sent)); err != nil {
+		return err
+	}
+
+	var first sql.NullInt64
+	if err := tx.QueryRowContext(ctx, `SELECT MIN(seq) FROM agent_spool`).Scan(&first); err != nil {
+		return err
+	}
+	switch {
+	case first.Valid && first.Int64 <= cursor:
+		// Negate first so that shifting never collides with a higher seq.
+		shift := cursor + 1 - first.Int64
+		if _, err := tx.ExecContext(ctx, `UPDATE agent_spool SET seq = -seq`); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE agent_spool SET seq = -seq + ?`, shift); err != nil {
+			return err
+		}
+	case first.Valid && first.Int64 > cursor+1:
+		return fmt.Errorf("%w: server accepted up to %d, spool starts at %d", ErrSpoolBehind, cursor, first.Int64)
+	}
+
+	// Keep new sequence numbers above everything the server has accepted.
+	if _, err := tx.ExecContext(ctx, `INSERT INTO sqlite_sequence(name, seq) SELECT 'agent_spool', 0
+		WHERE NOT EXISTS (SELECT 1 FROM sqlite_sequence WHERE name = 'agent_spool')`); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE sqlite_sequence SET seq = MAX(seq, ?, COALESCE((SELECT MAX(seq) FROM agent_spool), 0))
+		WHERE name = 'agent_spool'`, cursor); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO agent_spool_state(key, value) VALUES('sent', ?)
+		ON CONFLICT(key) DO UPDATE SET value = MAX(value, excluded.value)`, cursor); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,254 @@
+// Package client is a typed Go client for the StateLedger HTTP API.
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTimeout    = 30 * time.Second
+	defaultMaxRetries = 3
+	defaultBackoff    = 200 * time.Millisecond
+)
+
+// Client talks to a remote StateLedger server.
+type Client struct {
+	baseURL    *url.URL
+	apiKey     string
+	httpClient *http.Client
+	maxRetries int
+	backoff    time.Duration
+	userAgent  string
+	sourceKeys []sourceKey
+	optErr     error
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithAPIKey authenticates every request with the given API key.
+func WithAPIKey(key string) Option {
+	return func(c *Client) { c.apiKey = key }
+}
+
+// WithHTTPClient replaces the underlying HTTP client.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+// WithRetries sets how many times idempotent requests are retried after
+// network errors or 429/5xx responses, and the initial backoff between
+// attempts. The backoff doubles after each attempt.
+func WithRetries(max int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries = max
+		c.backoff = backoff
+	}
+}
+
+// WithUserAgent sets the User-Agent header sent with every request.
+func WithUserAgent(ua string) Option {
+	return func(c *Client) { c.userAgent = ua }
+}
+
+// New creates a client for the server at baseURL (e.g. http://ledger:8080).
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(strings.TrimRight(baseURL, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, errors.New("base URL must include scheme and host")
+	}
+
+	c := &Client{
+		baseURL:    u,
+		httpClient: &http.Client{Timeout: defaultTimeout},
+		maxRetries: defaultMaxRetries,
+		backoff:    defaultBackoff,
+		userAgent:  "stateledger-go-client",
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.optErr != nil {
+		return nil, c.optErr
+	}
+	return c, nil
+}
+
+// APIError is returned when the server responds with a non-2xx status.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("stateledger: HTTP %d", e.StatusCode)
+	}
+	return fmt.Sprintf("stateledger: HTTP %d: %s", e.StatusCode, e.Message)
+}
+
+// envelope mirrors the server's response wrapper.
+type envelope struct {
+	Success bool            `json:"success"`
+	Data    json.RawMessage `json:"data,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// gzipBody marks a request body to be sent gzip-compressed.
+type gzipBody struct {
+	v any
+}
+
+// retryableBody marks the body of a request that is safe to retry, such as
+// an append with an idempotency key.
+type retryableBody struct {
+	v any
+}
+
+// do sends a request and decodes the envelope's data into out. Idempotent
+// requests are retried on transient failures.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body any, out any) error {
+	var payload []byte
+	var encoding string
+	var sigs []string
+	retryable := method == http.MethodGet || method == http.MethodDelete
+	if rb, ok := body.(retryableBody); ok {
+		body, retryable = rb.v, true
+	}
+	if gz, ok := body.(gzipBody); ok {
+		raw, err := json.Marshal(gz.v)
+		if err != nil {
+			return err
+		}
+		sigs = c.signatures(raw)
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(raw); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		payload, encoding = buf.Bytes(), "gzip"
+	} else if body != nil {
+		var err error
+		payload, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		sigs = c.signatures(payload)
+	}
+
+	retries := 0
+	if retryable {
+		retries = c.maxRetries
+	}
+
+	backoff := c.backoff
+	var lastErr error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+
+		retry, err := c.attempt(ctx, method, path, query, payload, encoding, sigs, out)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		if !retry || ctx.Err() != nil {
+			break
+		}
+	}
+	return lastErr
+}
+
+// signatures signs an uncompressed request body with each source key.
+func (c *Client) signatures(body []byte) []string {
+	sigs := make([]string, 0, len(c.sourceKeys))
+	for _, sk := range c.sourceKeys {
+		sigs = append(sigs, sk.source+"="+sk.sign(body))
+	}
+	return sigs
+}
+
+func (c *Client) attempt(ctx context.Context, method, path string, query url.Values, payload []byte, encoding string, sigs []string, out any) (bool, error) {
+	u := *c.baseURL
+	u.Path = strings.TrimRight(u.Path, "/") + path
+	if query != nil {
+		u.RawQuery = query.Encode()
+	}
+
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	if c.apiKey != "" {
+		req.Header.Set("X-API-Key", c.apiKey)
+	}
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+	for _, sig := range sigs {
+		req.Header.Add(headerSourceSignature, sig)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return true, err
+	}
+
+	var env envelope
+	_ = json.Unmarshal(data, &env)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg := env.Error
+		if msg == "" {
+			msg = strings.TrimSpace(string(data))
+		}
+		retry := resp.StatusCode == http.StatusTooManyRequests ||
+			(resp.StatusCode >= 500 && resp.StatusCode != http.StatusNotImplemented)
+		return retry, &APIError{StatusCode: resp.StatusCode, Message: msg}
+	}
+
+	if out == nil || len(env.Data) == 0 {
+		return false, nil
+	}
+	return false, json.Unmarshal(env.Data, out)
+}
@@ -0,0 +1,197 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Retr0-XD/StateLedger/internal/api"
+	"github.com/Retr0-XD/StateLedger/internal/ledger"
+)
+
+func newTestAPI(t *testing.T, records int) *httptest.Server {
+	t.Helper()
+	l, err := ledger.Open(filepath.Join(t.TempDir(), "ledger.db"))
+	if err != nil {
+		t.Fatalf("open ledger: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	if err := l.InitSchema(); err != nil {
+		t.Fatalf("init schema: %v", err)
+	}
+
+	for i := 0; i < records; i++ {
+		_, err := l.Append(ledger.RecordInput{
+			Timestamp: 1000 + int64(i),
+			Type:      "code",
+			Source:    "test",
+			Payload:   `{"repo":"app","commit":"abc1234"}`,
+		})
+		if err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+
+	s := api.NewServer(l, "")
+	s.SetPolicy(api.Policy{InsecureNoAuth: true})
+	srv := httptest.NewServer(s.Handler())
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestRecordIterator(t *testing.T) {
+	srv := newTestAPI(t, 5)
+	c, err := New(srv.URL)
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+
+	ctx := context.Background()
+	it := c.Records(ListOptions{PageSize: 2})
+	var ids []int64
+	for it.Next(ctx) {
+		ids = append(ids, it.Record().ID)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("iterate: %v", err)
+	}
+	if len(ids) != 5 || ids[0] != 1 || ids[4] != 5 {
+		t.Fatalf("unexpected ids: %v", ids)
+	}
+
+	rec, err := c.Get(ctx, 3)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if rec.Kind != "code" || rec.PayloadText() != `{"repo":"app","commit":"abc1234"}` {
+		t.Fatalf("unexpected record: %+v", rec)
+	}
+
+	var apiErr *APIError
+	if _, err := c.Get(ctx, 99); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 APIError, got %v", err)
+	}
+}
+
+func TestAppendBatch(t *testing.T) {
+	srv := newTestAPI(t, 1)
+	c, err := New(srv.URL)
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	ctx := context.Background()
+
+	recs, err := c.AppendBatch(ctx, []AppendInput{
+		{Type: "code", Source: "ci", Timestamp: 2000, Payload: map[string]string{"repo": "app", "commit": "def"}},
+		{Type: "deploy", Source: "ci", Timestamp: 2001, Payload: "v2"},
+	})
+	if err != nil {
+		t.Fatalf("append batch: %v", err)
+	}
+	if len(recs) != 2 || recs[0].ID != 2 || recs[1].ID != 3 || recs[1].PayloadText() != "v2" {
+		t.Fatalf("records = %+v", recs)
+	}
+
+	// A bad record rejects the whole batch.
+	_, err = c.AppendBatch(ctx, []AppendInput{
+		{Type: "deploy", Payload: "v3"},
+		{Type: "code", Payload: map[string]string{"unknown": "x"}},
+	})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %v", err)
+	}
+	page, err := c.List(ctx, ListOptions{})
+	if err != nil || len(page.Records) != 3 {
+		t.Fatalf("rejected batch left records behind: %d (%v)", len(page.Records), err)
+	}
+	if res, err := c.Verify(ctx); err != nil || !res.Valid {
+		t.Fatalf("chain after batches: %+v %v", res, err)
+	}
+}
+
+func TestVerifySnapshotAudit(t *testing.T) {
+	srv := newTestAPI(t, 2)
+	c, _ := New(srv.URL)
+	ctx := context.Background()
+
+	res, err := c.Verify(ctx)
+	if err != nil || !res.Valid || res.Checked != 2 {
+		t.Fatalf("verify: %+v err=%v", res, err)
+	}
+
+	snap, err := c.Snapshot(ctx, time.Unix(1000, 0))
+	if err != nil || snap.Count != 1 || snap.Records[0].PrevHash != "" {
+		t.Fatalf("snapshot: %+v err=%v", snap, err)
+	}
+
+	bundle, err := c.Audit(ctx, time.Unix(2000, 0))
+	if err != nil || bundle.TargetTime != 2000 || len(bundle.Snapshot) == 0 {
+		t.Fatalf("audit: %+v err=%v", bundle, err)
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	srv := newTestAPI(t, 0)
+	c, _ := New(srv.URL)
+	ctx := context.Background()
+
+	hook, err := c.CreateWebhook(ctx, WebhookInput{ID: "ops", URL: "https://203.0.113.10/hook", Secret: "s"})
+	if err != nil || !hook.Signed {
+		t.Fatalf("create webhook: %+v err=%v", hook, err)
+	}
+
+	hooks, err := c.Webhooks(ctx)
+	if err != nil || len(hooks) != 1 || hooks[0].ID != "ops" {
+		t.Fatalf("list webhooks: %+v err=%v", hooks, err)
+	}
+
+	if err := c.DeleteWebhook(ctx, "ops"); err != nil {
+		t.Fatalf("delete webhook: %v", err)
+	}
+	if err := c.DeleteWebhook(ctx, "ops"); err == nil {
+		t.Fatal("expected error deleting missing webhook")
+	}
+}
+
+func TestRetriesAndAuth(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-API-Key") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"success":true,"data":{"valid":true,"checked":7}}`))
+	}))
+	defer srv.Close()
+
+	c, _ := New(srv.URL, WithAPIKey("secret"), WithRetries(3, time.Millisecond))
+	res, err := c.Verify(context.Background())
+	if err != nil || res.Checked != 7 {
+		t.Fatalf("verify with retries: %+v err=%v", res, err)
+	}
+	if calls.Load() != 3 {
+		t.Fatalf("expected 3 attempts, got %d", calls.Load())
+	}
+
+	calls.Store(0)
+	_, err = c.Append(context.Background(), AppendInput{Type: "code", Payload: map[string]string{"repo": "x"}})
+	if err == nil || calls.Load() != 1 {
+		t.Fatalf("appends must not be retried: calls=%d err=%v", calls.Load(), err)
+	}
+
+	unauth, _ := New(srv.URL, WithRetries(0, 0))
+	var apiErr *APIError
+	if _, err := unauth.Verify(context.Background()); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %v", err)
+	}
+}
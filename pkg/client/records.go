@@ -0,0 +1,691 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Record is a ledger record as returned by the records API.
+type Record struct {
+	ID        int64           `json:"id"`
+	UID       string          `json:"uid,omitempty"`
+	Kind      string          `json:"kind"`
+	Timestamp time.Time       `json:"timestamp"`
+	Hash      string          `json:"hash"`
+	Payload   json.RawMessage `json:"payload"`
+	AgentID   string          `json:"agent_id,omitempty"`
+	// Seq numbers the records of one source in append order.
+	Seq int64 `json:"seq,omitempty"`
+	// Labels tag the record; they are covered by its hash.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// PayloadText returns the payload as text, unquoting it when the server
+// returned it as a JSON string.
+func (r Record) PayloadText() string {
+	var s string
+	if err := json.Unmarshal(r.Payload, &s); err == nil {
+		return s
+	}
+	return string(r.Payload)
+}
+
+// DecodePayload decodes the payload into v, whether the server returned it
+// as a string or parsed (ListOptions.ParsePayloads).
+func (r Record) DecodePayload(v any) error {
+	return json.Unmarshal([]byte(r.PayloadText()), v)
+}
+
+// AppendInput describes a record to append.
+type AppendInput struct {
+	Type      string `json:"type"`
+	Source    string `json:"source,omitempty"`
+	Timestamp int64  `json:"timestamp,omitempty"`
+	Payload   any    `json:"payload"`
+	// IdempotencyKey makes the append safe to retry: the server returns
+	// the record already appended under the key instead of a duplicate.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	// Labels tag the record for ListOptions.Labels, such as env=prod.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// Append creates a new record and returns it with its hash. Appends with
+// an IdempotencyKey are retried like GET requests.
+func (c *Client) Append(ctx context.Context, in AppendInput) (Record, error) {
+	if in.Type == "" {
+		return Record{}, errors.New("type required")
+	}
+	if in.Payload == nil {
+		return Record{}, errors.New("payload required")
+	}
+
+	var body any = in
+	if in.IdempotencyKey != "" {
+		body = retryableBody{v: in}
+	}
+	var rec Record
+	err := c.do(ctx, http.MethodPost, "/api/v1/records", nil, body, &rec)
+	return rec, err
+}
+
+// AppendBatch creates records in one transaction: either all are appended,
+// in order, or none are. It returns the records with their hashes. Records
+// whose IdempotencyKey was used before are returned as appended then, and a
+// batch in which every record has a key is retried like GET requests.
+func (c *Client) AppendBatch(ctx context.Context, in []AppendInput) ([]Record, error) {
+	if len(in) == 0 {
+		return nil, errors.New("no inputs provided")
+	}
+	keyed := true
+	for i, rec := range in {
+		if rec.Type == "" || rec.Payload == nil {
+			return nil, fmt.Errorf("record %d: type and payload required", i)
+		}
+		keyed = keyed && rec.IdempotencyKey != ""
+	}
+
+	var body any = in
+	if keyed {
+		body = retryableBody{v: in}
+	}
+	var out struct {
+		Records []Record `json:"records"`
+	}
+	err := c.do(ctx, http.MethodPost, "/api/v1/records/batch", nil, body, &out)
+	return out.Records, err
+}
+
+// Get fetches a single record by ID.
+func (c *Client) Get(ctx context.Context, id int64) (Record, error) {
+	var rec Record
+	err := c.do(ctx, http.MethodGet, "/api/v1/records/"+strconv.FormatInt(id, 10), nil, nil, &rec)
+	return rec, err
+}
+
+// GetByHash fetches the record with the given chain hash.
+func (c *Client) GetByHash(ctx context.Context, hash string) (Record, error) {
+	var rec Record
+	err := c.do(ctx, http.MethodGet, "/api/v1/records/hash/"+url.PathEscape(hash), nil, nil, &rec)
+	return rec, err
+}
+
+// ListOptions controls record listing.
+type ListOptions struct {
+	// PageSize is the number of records fetched per request. The server
+	// may return fewer, marked Truncated, when it runs out of time.
+	PageSize int
+	// Offset skips the first records of the listing.
+	Offset int
+	// Cursor continues a listing after the page whose NextCursor it is.
+	// It cannot be combined with Offset.
+	Cursor string
+	// TraceID restricts the listing to mutations recorded under a W3C
+	// trace ID.
+	TraceID string
+	// Types and Sources restrict the listing to records of any of the
+	// given types and sources.
+	Types   []string
+	Sources []string
+	// SinceSeq restricts the listing to records with a greater Seq. It
+	// needs exactly one entry in Sources.
+	SinceSeq int64
+	// Labels restricts the listing to records with every given label.
+	Labels map[string]string
+	// PayloadFilters restricts the listing to records whose JSON payload
+	// has the given value at each field path, such as "data.order_id".
+	PayloadFilters map[string]string
+	// ParsePayloads asks the server to return payloads as JSON objects
+	// rather than strings.
+	ParsePayloads bool
+	// TimeZone asks the server to format record timestamps in this zone,
+	// e.g. "Europe/Berlin" or "+05:30", rather than UTC.
+	TimeZone string
+}
+
+// Page is one page of a record listing.
+type Page struct {
+	Records []Record `json:"records"`
+	Offset  int      `json:"offset"`
+	Limit   int      `json:"limit"`
+	Total   int      `json:"total"`
+	// NextCursor fetches the following page; it is empty on the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
+	// Truncated reports a page cut short by the server's query budget;
+	// NextCursor continues it.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// List fetches a single page of records.
+func (c *Client) List(ctx context.Context, opts ListOptions) (Page, error) {
+	if opts.PageSize <= 0 {
+		opts.PageSize = 100
+	}
+
+	q := url.Values{}
+	q.Set("limit", strconv.Itoa(opts.PageSize))
+	if opts.Cursor != "" {
+		q.Set("cursor", opts.Cursor)
+	} else {
+		q.Set("offset", strconv.Itoa(opts.Offset))
+	}
+	if opts.TraceID != "" {
+		q.Set("trace_id", opts.TraceID)
+	}
+	for _, t := range opts.Types {
+		q.Add("kind", t)
+	}
+	for _, src := range opts.Sources {
+		q.Add("source", src)
+	}
+	if opts.SinceSeq > 0 {
+		q.Set("since_seq", strconv.FormatInt(opts.SinceSeq, 10))
+	}
+	for key, v := range opts.Labels {
+		q.Add("label", key+"="+v)
+	}
+	for path, v := range opts.PayloadFilters {
+		q.Set("payload."+path, v)
+	}
+	if opts.ParsePayloads {
+		q.Set("parse", "true")
+	}
+	if opts.TimeZone != "" {
+		q.Set("tz", opts.TimeZone)
+	}
+
+	var page Page
+	err := c.do(ctx, http.MethodGet, "/api/v1/records", q, nil, &page)
+	return page, err
+}
+
+// RecordIterator walks every record of a listing page by page.
+type RecordIterator struct {
+	c      *Client
+	opts   ListOptions
+	page   []Record
+	pos    int
+	cur    Record
+	err    error
+	done   bool
+	offset int
+	cursor string
+	// cursors is set once the server has returned a cursor, after which
+	// a page without one is the last.
+	cursors bool
+}
+
+// Records returns an iterator over all records matching opts.
+//
+//	it := c.Records(client.ListOptions{PageSize: 500})
+//	for it.Next(ctx) {
+//		rec := it.Record()
+//	}
+//	if err := it.Err(); err != nil { ... }
+func (c *Client) Records(opts ListOptions) *RecordIterator {
+	if opts.PageSize <= 0 {
+		opts.PageSize = 100
+	}
+	return &RecordIterator{c: c, opts: opts, offset: opts.Offset, cursor: opts.Cursor, cursors: opts.Cursor != ""}
+}
+
+// Next advances to the next record, fetching a new page when needed.
+func (it *RecordIterator) Next(ctx context.Context) bool {
+	if it.err != nil {
+		return false
+	}
+	for it.pos >= len(it.page) {
+		if it.done {
+			return false
+		}
+		// Pages after the first follow the server's cursor; servers
+		// without cursors are paged by offset.
+		opts := it.opts
+		opts.Offset, opts.Cursor = it.offset, it.cursor
+		if it.cursor != "" {
+			opts.Offset = 0
+		}
+		page, err := it.c.List(ctx, opts)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.page = page.Records
+		it.pos = 0
+		it.offset += len(page.Records)
+		it.cursor = page.NextCursor
+		it.cursors = it.cursors || it.cursor != ""
+		if (len(page.Records) < it.opts.PageSize && !page.Truncated) || (it.cursors && it.cursor == "") {
+			it.done = true
+		}
+		if len(page.Records) == 0 {
+			return false
+		}
+	}
+	it.cur = it.page[it.pos]
+	it.pos++
+	return true
+}
+
+// Record returns the current record.
+func (it *RecordIterator) Record() Record {
+	return it.cur
+}
+
+// Err returns the first error encountered while iterating.
+func (it *RecordIterator) Err() error {
+	return it.err
+}
+
+// VerifyResult is the outcome of a chain verification.
+type VerifyResult struct {
+	Valid    bool   `json:"valid"`
+	Checked  int64  `json:"checked"`
+	FailedID int64  `json:"failed_id,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+	Time     string `json:"time"`
+}
+
+// Verify asks the server to verify the full hash chain.
+func (c *Client) Verify(ctx context.Context) (VerifyResult, error) {
+	var res VerifyResult
+	err := c.do(ctx, http.MethodGet, "/api/v1/verify", nil, nil, &res)
+	return res, err
+}
+
+// LedgerRecord is the raw stored form of a record, including its chain link.
+type LedgerRecord struct {
+	ID        int64  `json:"id"`
+	Timestamp int64  `json:"timestamp"`
+	Type      string `json:"type"`
+	Source    string `json:"source"`
+	Payload   string `json:"payload"`
+	Hash      string `json:"hash"`
+	PrevHash  string `json:"prev_hash"`
+}
+
+// Snapshot is the set of records known at a point in time.
+type Snapshot struct {
+	Time    string         `json:"time"`
+	Records []LedgerRecord `json:"records"`
+	Count   int            `json:"count"`
+}
+
+// snapshotPage is one response of the snapshot endpoint.
+type snapshotPage struct {
+	Snapshot
+	NextCursor string `json:"next_cursor"`
+}
+
+// Snapshot fetches the records known at time t. A snapshot the server
+// truncates is continued from its cursor until it is complete.
+func (c *Client) Snapshot(ctx context.Context, t time.Time) (Snapshot, error) {
+	q := url.Values{}
+	q.Set("time", t.UTC().Format(time.RFC3339))
+
+	var snap Snapshot
+	for {
+		var page snapshotPage
+		if err := c.do(ctx, http.MethodGet, "/api/v1/snapshot", q, nil, &page); err != nil {
+			return Snapshot{}, err
+		}
+		snap.Time = page.Time
+		snap.Records = append(snap.Records, page.Records...)
+		snap.Count += page.Count
+		if page.NextCursor == "" {
+			return snap, nil
+		}
+		q.Set("cursor", page.NextCursor)
+	}
+}
+
+// AuditBundle is an exported audit bundle. The reconstruction report and
+// proof are kept as raw JSON so they can be archived byte-for-byte.
+type AuditBundle struct {
+	GeneratedAt int64           `json:"generated_at"`
+	TargetTime  int64           `json:"target_time"`
+	Snapshot    json.RawMessage `json:"snapshot"`
+	Proof       json.RawMessage `json:"proof,omitempty"`
+	Notes       []string        `json:"notes,omitempty"`
+	Attachments []Attachment    `json:"attachments,omitempty"`
+}
+
+// Attachment is a supporting document carried inside an audit bundle.
+type Attachment struct {
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	Content     string `json:"content"`
+}
+
+// Audit exports an audit bundle for time t.
+func (c *Client) Audit(ctx context.Context, t time.Time) (AuditBundle, error) {
+	q := url.Values{}
+	q.Set("time", t.UTC().Format(time.RFC3339))
+
+	var bundle AuditBundle
+	err := c.do(ctx, http.MethodGet, "/api/v1/audit", q, nil, &bundle)
+	return bundle, err
+}
+
+// LatestRecord is the newest record of one state dimension.
+type LatestRecord struct {
+	RecordID  int64           `json:"record_id"`
+	Timestamp int64           `json:"timestamp"`
+	Source    string          `json:"source"`
+	Hash      string          `json:"hash"`
+	Payload   json.RawMessage `json:"payload"`
+}
+
+// NamespaceHighWater summarizes the mutations of one namespace.
+type NamespaceHighWater struct {
+	Namespace       string `json:"namespace"`
+	Count           int64  `json:"count"`
+	LastRecordID    int64  `json:"last_record_id"`
+	LastTimestamp   int64  `json:"last_timestamp"`
+	HighWaterOffset *int64 `json:"high_water_offset,omitempty"`
+}
+
+// LatestState is the current known state per dimension.
+type LatestState struct {
+	GeneratedAt  int64                `json:"generated_at"`
+	LastRecordID int64                `json:"last_record_id"`
+	Code         *LatestRecord        `json:"code,omitempty"`
+	Config       *LatestRecord        `json:"config,omitempty"`
+	Environment  *LatestRecord        `json:"environment,omitempty"`
+	Mutations    []NamespaceHighWater `json:"mutations"`
+}
+
+// LatestState fetches the newest code, config and environment payloads and
+// the mutation high-water marks per namespace.
+func (c *Client) LatestState(ctx context.Context) (LatestState, error) {
+	var state LatestState
+	err := c.do(ctx, http.MethodGet, "/api/v1/state/latest", nil, nil, &state)
+	return state, err
+}
+
+// MutationRecord is a decoded mutation of a snapshot.
+type MutationRecord struct {
+	LedgerID    int64  `json:"ledger_id"`
+	Timestamp   int64  `json:"timestamp"`
+	Type        string `json:"type"`
+	ID          string `json:"id"`
+	Source      string `json:"source"`
+	Hash        string `json:"hash"`
+	ExternalRef string `json:"external_ref,omitempty"`
+	Namespace   string `json:"namespace,omitempty"`
+	Offset      int64  `json:"offset,omitempty"`
+}
+
+// MutationQuery filters the mutations of a snapshot. Empty fields match
+// everything.
+type MutationQuery struct {
+	Type      string
+	Source    string
+	Namespace string
+	// RefFrom and RefTo bound the numeric external_ref offset, inclusive.
+	RefFrom *int64
+	RefTo   *int64
+	Offset  int
+	Limit   int
+}
+
+// MutationPage is one page of filtered snapshot mutations.
+type MutationPage struct {
+	Time    string           `json:"time"`
+	Records []MutationRecord `json:"records"`
+	Total   int              `json:"total"`
+	Offset  int              `json:"offset"`
+	Limit   int              `json:"limit"`
+}
+
+// SnapshotMutations fetches the mutations known at time t that match mq.
+func (c *Client) SnapshotMutations(ctx context.Context, t time.Time, mq MutationQuery) (MutationPage, error) {
+	q := url.Values{}
+	q.Set("time", t.UTC().Format(time.RFC3339))
+	for k, v := range map[string]string{"type": mq.Type, "source": mq.Source, "namespace": mq.Namespace} {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	if mq.RefFrom != nil {
+		q.Set("ref_from", strconv.FormatInt(*mq.RefFrom, 10))
+	}
+	if mq.RefTo != nil {
+		q.Set("ref_to", strconv.FormatInt(*mq.RefTo, 10))
+	}
+	if mq.Offset > 0 {
+		q.Set("offset", strconv.Itoa(mq.Offset))
+	}
+	if mq.Limit > 0 {
+		q.Set("limit", strconv.Itoa(mq.Limit))
+	}
+
+	var page MutationPage
+	err := c.do(ctx, http.MethodGet, "/api/v1/snapshot/mutations", q, nil, &page)
+	return page, err
+}
+
+// KindStatus is the capture state of one kind of a source.
+type KindStatus struct {
+	Kind      string `json:"kind"`
+	Status    string `json:"status"`
+	Count     int64  `json:"count"`
+	FirstSeen int64  `json:"first_seen,omitempty"`
+	LastSeen  int64  `json:"last_seen,omitempty"`
+	Overdue   int64  `json:"overdue,omitempty"`
+}
+
+// SourceStatus is the capture state of one source.
+type SourceStatus struct {
+	Name       string       `json:"name"`
+	Registered bool         `json:"registered"`
+	Status     string       `json:"status"`
+	Cadence    int64        `json:"cadence,omitempty"`
+	Kinds      []KindStatus `json:"kinds"`
+}
+
+// SourceReport compares the source registry with captured records.
+type SourceReport struct {
+	At      int64          `json:"at"`
+	Sources []SourceStatus `json:"sources"`
+}
+
+// Sources reports which sources are overdue, missing, new or unknown as of
+// the current time.
+func (c *Client) Sources(ctx context.Context) (SourceReport, error) {
+	var report SourceReport
+	err := c.do(ctx, http.MethodGet, "/api/v1/sources", nil, nil, &report)
+	return report, err
+}
+
+// Divergence is a record the server's secondary ledger failed to match.
+type Divergence struct {
+	Kind          string `json:"kind"`
+	PrimaryID     int64  `json:"primary_id"`
+	PrimaryHash   string `json:"primary_hash"`
+	SecondaryHash string `json:"secondary_hash,omitempty"`
+	Error         string `json:"error,omitempty"`
+	Time          int64  `json:"time"`
+}
+
+// MirrorStatus is the progress of the server's dual-write mirror.
+type MirrorStatus struct {
+	Mirrored       int64       `json:"mirrored"`
+	Behind         bool        `json:"behind"`
+	Diverged       bool        `json:"diverged"`
+	Divergences    int64       `json:"divergences"`
+	LastDivergence *Divergence `json:"last_divergence,omitempty"`
+}
+
+// MirrorCheck compares the primary and secondary chains.
+type MirrorCheck struct {
+	Consistent       bool   `json:"consistent"`
+	Lag              int64  `json:"lag"`
+	PrimaryCount     int64  `json:"primary_count"`
+	SecondaryCount   int64  `json:"secondary_count"`
+	PrimaryHead      string `json:"primary_head"`
+	SecondaryHead    string `json:"secondary_head"`
+	FirstDivergentID int64  `json:"first_divergent_id,omitempty"`
+}
+
+// MirrorReport is the state of the server's dual-write mirror. Check is set
+// only when requested.
+type MirrorReport struct {
+	Status MirrorStatus `json:"status"`
+	Check  *MirrorCheck `json:"check,omitempty"`
+}
+
+// Mirror reports the server's dual-write mirror. With check, the server
+// also compares the two chains record by record.
+func (c *Client) Mirror(ctx context.Context, check bool) (MirrorReport, error) {
+	var q url.Values
+	if check {
+		q = url.Values{"check": {"true"}}
+	}
+	var report MirrorReport
+	err := c.do(ctx, http.MethodGet, "/api/v1/mirror", q, nil, &report)
+	return report, err
+}
+
+// PayloadSchema is one version of the JSON Schema for a record type's
+// payloads.
+type PayloadSchema struct {
+	Type         string          `json:"type"`
+	Version      int             `json:"version"`
+	Builtin      bool            `json:"builtin,omitempty"`
+	Schema       json.RawMessage `json:"schema"`
+	RecordID     int64           `json:"record_id,omitempty"`
+	Hash         string          `json:"hash,omitempty"`
+	RegisteredAt int64           `json:"registered_at,omitempty"`
+}
+
+// SchemaVersions is a record type's selected schema and its full history.
+type SchemaVersions struct {
+	Type     string          `json:"type"`
+	Schema   PayloadSchema   `json:"schema"`
+	Versions []PayloadSchema `json:"versions"`
+}
+
+// Schemas returns the latest payload schema of every record type.
+func (c *Client) Schemas(ctx context.Context) ([]PayloadSchema, error) {
+	var out []PayloadSchema
+	err := c.do(ctx, http.MethodGet, "/api/v1/schemas", nil, nil, &out)
+	return out, err
+}
+
+// Schema returns a record type's payload schema and its history; version 0
+// selects the latest.
+func (c *Client) Schema(ctx context.Context, recordType string, version int) (SchemaVersions, error) {
+	var q url.Values
+	if version > 0 {
+		q = url.Values{"version": {strconv.Itoa(version)}}
+	}
+	var out SchemaVersions
+	err := c.do(ctx, http.MethodGet, "/api/v1/schemas/"+url.PathEscape(recordType), q, nil, &out)
+	return out, err
+}
+
+// RegisterSchema records a new schema version for a custom record type.
+func (c *Client) RegisterSchema(ctx context.Context, recordType string, schema json.RawMessage) (PayloadSchema, error) {
+	var out PayloadSchema
+	err := c.do(ctx, http.MethodPost, "/api/v1/schemas/"+url.PathEscape(recordType), nil, schema, &out)
+	return out, err
+}
+
+// Agent is a registered capture agent.
+type Agent struct {
+	ID           string `json:"id"`
+	Hostname     string `json:"hostname"`
+	Version      string `json:"version"`
+	PublicKey    string `json:"public_key"`
+	RegisteredAt int64  `json:"registered_at"`
+	LastSeen     int64  `json:"last_seen"`
+	Records      int64  `json:"records"`
+}
+
+// AgentRegistration is what an agent presents when it registers. PublicKey
+// is a base64-encoded ed25519 public key; the agent ID is derived from it.
+type AgentRegistration struct {
+	Hostname  string `json:"hostname"`
+	Version   string `json:"version"`
+	PublicKey string `json:"public_key"`
+}
+
+// RegisterAgent registers the calling agent, or refreshes its registration.
+func (c *Client) RegisterAgent(ctx context.Context, reg AgentRegistration) (Agent, error) {
+	var agent Agent
+	err := c.do(ctx, http.MethodPost, "/api/v1/agents", nil, reg, &agent)
+	return agent, err
+}
+
+// AgentHeartbeat refreshes an agent's last-seen time.
+func (c *Client) AgentHeartbeat(ctx context.Context, id string) (Agent, error) {
+	var agent Agent
+	err := c.do(ctx, http.MethodPost, "/api/v1/agents/"+url.PathEscape(id)+"/heartbeat", nil, nil, &agent)
+	return agent, err
+}
+
+// VerifyAgent asks the server to verify the chain proofs an agent pushed
+// with its records.
+func (c *Client) VerifyAgent(ctx context.Context, id string) (VerifyResult, error) {
+	var res VerifyResult
+	err := c.do(ctx, http.MethodGet, "/api/v1/agents/"+url.PathEscape(id)+"/verify", nil, nil, &res)
+	return res, err
+}
+
+// Agents lists registered agents, most recently seen first.
+func (c *Client) Agents(ctx context.Context) ([]Agent, error) {
+	var out struct {
+		Agents []Agent `json:"agents"`
+	}
+	err := c.do(ctx, http.MethodGet, "/api/v1/agents", nil, nil, &out)
+	return out.Agents, err
+}
+
+// AgentRecord is a record buffered by an agent for pushing. Seq numbers the
+// agent's records from 1 without gaps.
+//
+// Hash and PrevHash link the record into the agent's local chain; the
+// server verifies them and keeps them as proof.
+type AgentRecord struct {
+	Seq       int64  `json:"seq"`
+	Timestamp int64  `json:"timestamp"`
+	Type      string `json:"type"`
+	Source    string `json:"source"`
+	Payload   string `json:"payload"`
+	Hash      string `json:"hash,omitempty"`
+	PrevHash  string `json:"prev_hash,omitempty"`
+}
+
+// IngestResult acknowledges a push. LastSeq is the agent's last accepted
+// sequence.
+type IngestResult struct {
+	Accepted   int   `json:"accepted"`
+	Duplicates int   `json:"duplicates"`
+	LastSeq    int64 `json:"last_seq"`
+}
+
+// IngestCursor returns the last sequence the server accepted from the
+// agent. The request must be made over mTLS with the agent's certificate.
+func (c *Client) IngestCursor(ctx context.Context, agentID string) (int64, error) {
+	var out struct {
+		LastSeq int64 `json:"last_seq"`
+	}
+	err := c.do(ctx, http.MethodGet, "/api/v1/agents/"+url.PathEscape(agentID)+"/ingest", nil, nil, &out)
+	return out.LastSeq, err
+}
+
+// Ingest pushes a gzip-compressed batch of agent records. The request must
+// be made over mTLS with the agent's certificate. A 409 APIError means the
+// batch does not continue from the server's cursor.
+func (c *Client) Ingest(ctx context.Context, agentID string, records []AgentRecord) (IngestResult, error) {
+	var res IngestResult
+	body := gzipBody{v: map[string]any{"records": records}}
+	err := c.do(ctx, http.MethodPost, "/api/v1/agents/"+url.PathEscape(agentID)+"/ingest", nil, body, &res)
+	return res, err
+}
@@ -0,0 +1,73 @@
+package client
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Source key algorithms, as registered with `stateledger source key`.
+const (
+	SourceKeyHMAC    = "hmac-sha256"
+	SourceKeyEd25519 = "ed25519"
+)
+
+// headerSourceSignature carries one "<source>=<signature>" per signing
+// source.
+const headerSourceSignature = "X-StateLedger-Source-Signature"
+
+// sourceKey signs request bodies for one source.
+type sourceKey struct {
+	source string
+	sign   func(body []byte) string
+}
+
+// WithSourceKey signs the body of every write request with the key of
+// source, for servers that require signatures on records of that source.
+// key is the shared secret for SourceKeyHMAC and the base64-encoded ed25519
+// private key (seed or full key) for SourceKeyEd25519. Give one option per
+// source the client writes records for.
+func WithSourceKey(source, algorithm, key string) Option {
+	return func(c *Client) {
+		sk, err := newSourceKey(source, algorithm, key)
+		if err != nil {
+			c.optErr = errors.Join(c.optErr, err)
+			return
+		}
+		c.sourceKeys = append(c.sourceKeys, sk)
+	}
+}
+
+func newSourceKey(source, algorithm, key string) (sourceKey, error) {
+	switch algorithm {
+	case SourceKeyHMAC:
+		return sourceKey{source: source, sign: func(body []byte) string {
+			mac := hmac.New(sha256.New, []byte(key))
+			mac.Write(body)
+			return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+		}}, nil
+	case SourceKeyEd25519:
+		raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+		if err != nil {
+			return sourceKey{}, fmt.Errorf("source %s: private key must be base64-encoded", source)
+		}
+		var priv ed25519.PrivateKey
+		switch len(raw) {
+		case ed25519.SeedSize:
+			priv = ed25519.NewKeyFromSeed(raw)
+		case ed25519.PrivateKeySize:
+			priv = ed25519.PrivateKey(raw)
+		default:
+			return sourceKey{}, fmt.Errorf("source %s: private key must be an ed25519 seed or private key", source)
+		}
+		return sourceKey{source: source, sign: func(body []byte) string {
+			return base64.RawURLEncoding.EncodeToString(ed25519.Sign(priv, body))
+		}}, nil
+	default:
+		return sourceKey{}, fmt.Errorf("source %s: unknown algorithm %q", source, algorithm)
+	}
+}
@@ -0,0 +1,88 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+)
+
+// Webhook is a webhook subscription registered on the server.
+type Webhook struct {
+	ID        string   `json:"id"`
+	URL       string   `json:"url"`
+	Events    []string `json:"events,omitempty"`
+	Active    bool     `json:"active"`
+	Signed    bool     `json:"signed"`
+	CreatedAt string   `json:"created_at"`
+	// RevokedAt, RevokedBy and RevokeReason are set on a revoked
+	// subscription.
+	RevokedAt    string `json:"revoked_at,omitempty"`
+	RevokedBy    string `json:"revoked_by,omitempty"`
+	RevokeReason string `json:"revoke_reason,omitempty"`
+}
+
+// WebhookInput registers a webhook subscription. An empty Events list
+// subscribes to every event type.
+type WebhookInput struct {
+	ID     string   `json:"id"`
+	URL    string   `json:"url"`
+	Events []string `json:"events,omitempty"`
+	Secret string   `json:"secret,omitempty"`
+}
+
+// Webhooks lists the registered webhook subscriptions that are not
+// revoked.
+func (c *Client) Webhooks(ctx context.Context) ([]Webhook, error) {
+	return c.listWebhooks(ctx, nil)
+}
+
+// AllWebhooks lists the registered webhook subscriptions, revoked ones
+// included.
+func (c *Client) AllWebhooks(ctx context.Context) ([]Webhook, error) {
+	return c.listWebhooks(ctx, url.Values{"include_revoked": {"true"}})
+}
+
+func (c *Client) listWebhooks(ctx context.Context, q url.Values) ([]Webhook, error) {
+	var out struct {
+		Webhooks []Webhook `json:"webhooks"`
+	}
+	err := c.do(ctx, http.MethodGet, "/api/v1/webhooks", q, nil, &out)
+	return out.Webhooks, err
+}
+
+// CreateWebhook registers a new webhook subscription.
+func (c *Client) CreateWebhook(ctx context.Context, in WebhookInput) (Webhook, error) {
+	if in.ID == "" || in.URL == "" {
+		return Webhook{}, errors.New("id and url required")
+	}
+	var hook Webhook
+	err := c.do(ctx, http.MethodPost, "/api/v1/webhooks", nil, in, &hook)
+	return hook, err
+}
+
+// DeleteWebhook revokes a webhook subscription, as RevokeWebhook does
+// without a reason.
+func (c *Client) DeleteWebhook(ctx context.Context, id string) error {
+	_, err := c.RevokeWebhook(ctx, id, "")
+	return err
+}
+
+// RevokeWebhook stops deliveries to a webhook subscription. The server
+// keeps it, with the reason, until it is restored.
+func (c *Client) RevokeWebhook(ctx context.Context, id, reason string) (Webhook, error) {
+	var q url.Values
+	if reason != "" {
+		q = url.Values{"reason": {reason}}
+	}
+	var hook Webhook
+	err := c.do(ctx, http.MethodDelete, "/api/v1/webhooks/"+url.PathEscape(id), q, nil, &hook)
+	return hook, err
+}
+
+// RestoreWebhook resumes deliveries to a revoked webhook subscription.
+func (c *Client) RestoreWebhook(ctx context.Context, id, reason string) (Webhook, error) {
+	var hook Webhook
+	err := c.do(ctx, http.MethodPost, "/api/v1/webhooks/"+url.PathEscape(id)+"/restore", nil, map[string]string{"reason": reason}, &hook)
+	return hook, err
+}
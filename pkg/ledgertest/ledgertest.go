@@ -0,0 +1,206 @@
+// Package ledgertest helps write regression tests for capture pipelines:
+// it seeds deterministic ledgers on a frozen clock and compares
+// reconstruction reports and determinism scores with golden files.
+//
+// Golden files are rewritten instead of compared when the
+// STATELEDGER_UPDATE_GOLDEN environment variable is set to 1:
+//
+//	STATELEDGER_UPDATE_GOLDEN=1 go test ./...
+package ledgertest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Retr0-XD/StateLedger/internal/collectors"
+	"github.com/Retr0-XD/StateLedger/internal/ledger"
+)
+
+// UpdateEnv is the environment variable that makes the Assert helpers
+// rewrite golden files.
+const UpdateEnv = "STATELEDGER_UPDATE_GOLDEN"
+
+// Epoch is the time a Clock from NewClock starts at.
+var Epoch = time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// Clock is a frozen clock that only moves when advanced. It is safe for
+// concurrent use.
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewClock returns a clock frozen at Epoch.
+func NewClock() *Clock {
+	return &Clock{now: Epoch}
+}
+
+// Now returns the clock's current time.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d and returns the new time.
+func (c *Clock) Advance(d time.Duration) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	return c.now
+}
+
+// Set moves the clock to t.
+func (c *Clock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+}
+
+// New returns an empty, initialized ledger in a temporary directory. It is
+// closed when the test ends.
+func New(t testing.TB) *ledger.Ledger {
+	t.Helper()
+	l, err := ledger.Open(filepath.Join(t.TempDir(), "ledger.db"))
+	if err != nil {
+		t.Fatalf("open ledger: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	if err := l.InitSchema(); err != nil {
+		t.Fatalf("init ledger: %v", err)
+	}
+	return l
+}
+
+// Seed appends records in order and returns them. Records without a
+// timestamp are stamped with the clock, which then advances one second,
+// so the same inputs always produce the same ledger and hash chain.
+func Seed(t testing.TB, l *ledger.Ledger, clock *Clock, inputs ...ledger.RecordInput) []ledger.Record {
+	t.Helper()
+	records := make([]ledger.Record, 0, len(inputs))
+	for i, in := range inputs {
+		if in.Timestamp == 0 {
+			in.Timestamp = clock.Now().Unix()
+			clock.Advance(time.Second)
+		}
+		rec, err := l.Append(in)
+		if err != nil {
+			t.Fatalf("seed record %d (%s): %v", i, in.Type, err)
+		}
+		records = append(records, rec)
+	}
+	return records
+}
+
+// Code returns a code record input for repo at commit.
+func Code(repo, commit string) ledger.RecordInput {
+	return payload("code", repo, collectors.CodePayload{Repo: repo, Commit: commit})
+}
+
+// Config returns a config record input for a snapshot of source, with the
+// hash reconstruction expects.
+func Config(source, snapshot string) ledger.RecordInput {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(snapshot)))
+	return payload("config", source, collectors.ConfigPayload{
+		Source:   source,
+		Version:  "1",
+		Hash:     "sha256:" + hex.EncodeToString(sum[:]),
+		Snapshot: snapshot,
+	})
+}
+
+// Environment returns an environment record input for a fixed
+// linux/amd64 host using the system time source.
+func Environment() ledger.RecordInput {
+	return payload("environment", "host", collectors.EnvironmentPayload{
+		OS:         "linux",
+		Kernel:     "6.1.0",
+		Runtime:    "go1.25",
+		Arch:       "amd64",
+		TimeSource: "system",
+	})
+}
+
+// Mutation returns a mutation record input for entity typ/id of source,
+// with a hash derived from its arguments and external ref ref, such as
+// "kafka:orders:0:42".
+func Mutation(source, typ, id, ref string) ledger.RecordInput {
+	sum := sha256.Sum256([]byte(source + "/" + typ + "/" + id + "@" + ref))
+	return payload("mutation", source, collectors.MutationPayload{
+		Type:        typ,
+		ID:          id,
+		Source:      source,
+		Hash:        "sha256:" + hex.EncodeToString(sum[:]),
+		ExternalRef: ref,
+	})
+}
+
+func payload[T any](typ, source string, p T) ledger.RecordInput {
+	data, err := collectors.MarshalPayload(p)
+	if err != nil {
+		panic(err)
+	}
+	return ledger.RecordInput{Type: typ, Source: source, Payload: data}
+}
+
+// Reconstruct reconstructs the ledger at targetTime with the report
+// stamped by clock, so the report is the same on every run.
+func Reconstruct(l *ledger.Ledger, clock *Clock, targetTime int64) ledger.ReconstructionReport {
+	r := ledger.New(l)
+	r.Now = clock.Now
+	return r.ReconstructAtTime(targetTime)
+}
+
+// AssertGolden fails the test when v, encoded as indented JSON, differs
+// from the golden file at path, showing a diff. When UpdateEnv is set to
+// 1 it writes the file instead.
+func AssertGolden(t testing.TB, path string, v any) {
+	t.Helper()
+	got, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		t.Fatalf("encode %s: %v", path, err)
+	}
+	got = append(got, '\n')
+
+	if os.Getenv(UpdateEnv) == "1" {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("update golden file: %v", err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatalf("update golden file: %v", err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read golden file: %v (set %s=1 to create it)", err, UpdateEnv)
+	}
+	if !bytes.Equal(got, want) {
+		diff := ledger.UnifiedDiff(string(want), string(got), path, "got")
+		t.Errorf("%s differs from golden file (set %s=1 to update):\n%s", path, UpdateEnv, diff)
+	}
+}
+
+// AssertReport compares a reconstruction report with the golden file at
+// path. Use Reconstruct for reports that are the same on every run.
+func AssertReport(t testing.TB, path string, report ledger.ReconstructionReport) {
+	t.Helper()
+	AssertGolden(t, path, report)
+}
+
+// AssertDeterminismScore fails the test when the report's determinism
+// score is below min, listing the report's issues.
+func AssertDeterminismScore(t testing.TB, report ledger.ReconstructionReport, min float64) {
+	t.Helper()
+	if report.DeterminismScore < min {
+		t.Errorf("determinism score %.1f below %.1f; issues: %s", report.DeterminismScore, min, strings.Join(report.Issues, "; "))
+	}
+}
@@ -0,0 +1,97 @@
+package ledgerverify
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/Retr0-XD/StateLedger/internal/collectors"
+)
+
+// Signature is the signature of an audit bundle.
+type Signature struct {
+	KeyID     string `json:"key_id"`
+	KeyRef    string `json:"key_ref,omitempty"`
+	Signature string `json:"signature"`
+}
+
+// Proof is the chain verification result carried by an audit bundle.
+type Proof struct {
+	OK       bool   `json:"ok"`
+	Checked  int64  `json:"checked"`
+	LastID   int64  `json:"last_id,omitempty"`
+	LastHash string `json:"last_hash,omitempty"`
+}
+
+// Bundle holds the fields of an audit bundle that the verifier checks.
+type Bundle struct {
+	GeneratedAt int64      `json:"generated_at"`
+	TargetTime  int64      `json:"target_time"`
+	Proof       *Proof     `json:"proof,omitempty"`
+	Signature   *Signature `json:"signature,omitempty"`
+}
+
+// BundleDigest returns the message an audit bundle's signature covers: the
+// hex SHA-256 of the bundle in canonical JSON (object keys sorted, no
+// insignificant whitespace, no HTML escaping), without its signature.
+func BundleDigest(data []byte) (string, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var fields map[string]any
+	if err := dec.Decode(&fields); err != nil {
+		return "", fmt.Errorf("invalid audit bundle: %w", err)
+	}
+	delete(fields, "signature")
+	canonical, err := collectors.CanonicalJSON(fields)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// VerifyBundle decodes an audit bundle and checks its signature against
+// keys.
+func VerifyBundle(data []byte, keys ...crypto.PublicKey) (Bundle, error) {
+	var b Bundle
+	if err := json.Unmarshal(data, &b); err != nil {
+		return Bundle{}, fmt.Errorf("invalid audit bundle: %w", err)
+	}
+	if b.Signature == nil {
+		return b, errors.New("audit bundle is not signed")
+	}
+	pub, ok := keySet(keys)[b.Signature.KeyID]
+	if !ok {
+		return b, fmt.Errorf("unknown signing key %s", b.Signature.KeyID)
+	}
+	digest, err := BundleDigest(data)
+	if err != nil {
+		return b, err
+	}
+	if !VerifySignature(pub, []byte(digest), b.Signature.Signature) {
+		return b, errors.New("audit bundle signature mismatch")
+	}
+	return b, nil
+}
+
+// CheckRecords confirms that the chain head the bundle's proof ends at is
+// among recs, so the bundle and an independently verified export describe
+// the same chain.
+func (b Bundle) CheckRecords(recs []Record) error {
+	if b.Proof == nil || b.Proof.LastID == 0 {
+		return errors.New("audit bundle carries no chain head")
+	}
+	for _, rec := range recs {
+		if rec.ID == b.Proof.LastID {
+			if rec.Hash != b.Proof.LastHash {
+				return fmt.Errorf("audit bundle head %d has hash %s, export has %s", rec.ID, b.Proof.LastHash, rec.Hash)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("audit bundle head %d is not in the export", b.Proof.LastID)
+}
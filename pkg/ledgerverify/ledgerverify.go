@@ -0,0 +1,377 @@
+// Package ledgerverify checks StateLedger exports, segment files and audit
+// bundles without access to the ledger that produced them.
+//
+// It depends on nothing but the standard library and StateLedger's payload
+// encoding, so the verifier built from it (cmd/stateledger-verify) can be handed to external auditors: it
+// recomputes the hash chain of exported records, checks record and bundle
+// signatures against public keys the auditor obtained independently, and
+// never opens or writes a database.
+//
+//	f, _ := os.Open("export.jsonl")
+//	recs, err := ledgerverify.ReadJSONL(f)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	report, err := ledgerverify.VerifyRecords(recs, ledgerverify.Options{Keys: keys})
+//	if err != nil {
+//		log.Fatal(err) // a *RecordError names the offending record
+//	}
+package ledgerverify
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Record is a ledger record as written by `stateledger export` and in
+// segment files.
+type Record struct {
+	ID            int64  `json:"id"`
+	Timestamp     int64  `json:"timestamp"`
+	Type          string `json:"type"`
+	Source        string `json:"source"`
+	Payload       string `json:"payload"`
+	Hash          string `json:"hash"`
+	PrevHash      string `json:"prev_hash"`
+	AgentID       string `json:"agent_id,omitempty"`
+	Signature     string `json:"signature,omitempty"`
+	SigningKeyID  string `json:"signing_key_id,omitempty"`
+	SigningKeyRef string `json:"signing_key_ref,omitempty"`
+	// UID and Seq are assigned beside the record and not covered by its
+	// hash.
+	UID string `json:"uid,omitempty"`
+	Seq int64  `json:"seq,omitempty"`
+	// Labels are covered by the hash; see LabeledRecordHash.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Redaction is set when the payload was blanked; the hash then
+	// commits to Redaction.PayloadHash.
+	Redaction *Redaction `json:"redaction,omitempty"`
+}
+
+// Redaction describes a redacted payload.
+type Redaction struct {
+	PayloadHash string `json:"payload_hash"`
+	Reason      string `json:"reason,omitempty"`
+	RedactionID int64  `json:"redaction_id,omitempty"`
+	RedactedAt  int64  `json:"redacted_at,omitempty"`
+}
+
+// PayloadHash returns the hex SHA-256 of payload, which record hashes
+// commit to in place of the payload.
+func PayloadHash(payload string) string {
+	sum := sha256.Sum256([]byte(payload))
+	return hex.EncodeToString(sum[:])
+}
+
+// RecordHash returns the chain hash of a record following prevHash: the hex
+// SHA-256 of v2|prevHash|timestamp|type|source|PayloadHash(payload).
+func RecordHash(prevHash string, ts int64, rtype, source, payload string) string {
+	return hashFields("v2|", prevHash, ts, rtype, source, PayloadHash(payload))
+}
+
+// LabeledRecordHash returns the chain hash of a record with labels: the hex
+// SHA-256 of v3|prevHash|timestamp|type|source|PayloadHash(payload)|labels,
+// with labels as a JSON object with sorted keys and no whitespace, as
+// encoding/json writes a map. Without labels it is RecordHash.
+func LabeledRecordHash(prevHash string, ts int64, rtype, source, payload string, labels map[string]string) string {
+	return labeledHash(prevHash, ts, rtype, source, PayloadHash(payload), labels)
+}
+
+func labeledHash(prevHash string, ts int64, rtype, source, payloadHash string, labels map[string]string) string {
+	if len(labels) == 0 {
+		return hashFields("v2|", prevHash, ts, rtype, source, payloadHash)
+	}
+	encoded, _ := json.Marshal(labels)
+	return hashFields("v3|", prevHash, ts, rtype, source, payloadHash+"|"+string(encoded))
+}
+
+// LegacyRecordHash returns the chain hash of a record appended before
+// payload commitments: the hex SHA-256 of
+// prevHash|timestamp|type|source|payload.
+func LegacyRecordHash(prevHash string, ts int64, rtype, source, payload string) string {
+	return hashFields("", prevHash, ts, rtype, source, payload)
+}
+
+func hashFields(prefix, prevHash string, ts int64, rtype, source, last string) string {
+	h := sha256.New()
+	io.WriteString(h, prefix+prevHash+"|"+strconv.FormatInt(ts, 10)+"|"+rtype+"|"+source+"|"+last)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// hashMatches reports whether rec.Hash commits to rec, in either layout,
+// or to the payload hash of a redacted record.
+func hashMatches(rec Record) bool {
+	switch {
+	case rec.Redaction != nil:
+		return rec.Hash == labeledHash(rec.PrevHash, rec.Timestamp, rec.Type, rec.Source, rec.Redaction.PayloadHash, rec.Labels)
+	case len(rec.Labels) > 0:
+		return rec.Hash == LabeledRecordHash(rec.PrevHash, rec.Timestamp, rec.Type, rec.Source, rec.Payload, rec.Labels)
+	}
+	return rec.Hash == RecordHash(rec.PrevHash, rec.Timestamp, rec.Type, rec.Source, rec.Payload) ||
+		rec.Hash == LegacyRecordHash(rec.PrevHash, rec.Timestamp, rec.Type, rec.Source, rec.Payload)
+}
+
+// RecordError reports the first record that failed verification.
+type RecordError struct {
+	ID     int64
+	Reason string
+}
+
+func (e *RecordError) Error() string {
+	return fmt.Sprintf("record %d: %s", e.ID, e.Reason)
+}
+
+// Options controls VerifyRecords.
+type Options struct {
+	// Keys are the public keys record signatures are checked with. With
+	// no keys signatures are counted but not checked.
+	Keys []crypto.PublicKey
+	// PrevHash is the chain hash preceding the first record, checked when
+	// set. Records exported from ID 1 follow "".
+	PrevHash string
+	// Contiguous requires consecutive IDs, as in an unfiltered export or a
+	// segment. Filtered exports skip records, and the chain can only be
+	// followed across consecutive IDs.
+	Contiguous bool
+	// ExternalRedactions accepts redacted records whose redaction record
+	// is not among recs, as in segments, whose redaction records may still
+	// be in the ledger. Redaction records that are present are checked
+	// either way.
+	ExternalRedactions bool
+}
+
+// Report summarizes verified records.
+type Report struct {
+	Records int   `json:"records"`
+	FirstID int64 `json:"first_id,omitempty"`
+	LastID  int64 `json:"last_id,omitempty"`
+	// LastHash is the hash of the last record, which the next export or
+	// an anchored chain head should continue from.
+	LastHash string `json:"last_hash,omitempty"`
+	// Gaps counts places where IDs skip and the chain could not be
+	// followed.
+	Gaps int `json:"gaps"`
+	// Signed counts signed records, and SignaturesVerified those whose
+	// signature was checked against a key.
+	Signed             int `json:"signed"`
+	SignaturesVerified int `json:"signatures_verified"`
+}
+
+// VerifyRecords checks recs, in ID order: each record's hash must match its
+// content and its prev_hash, each record must follow the previous one when
+// their IDs are consecutive, and each signature must be valid for one of
+// opts.Keys. Once a signed record is seen every later record must be
+// signed, so signatures cannot be stripped from the tail of an export. A
+// redacted record must have an empty payload and be logged by a later
+// redaction record in recs, naming it and its payload hash.
+func VerifyRecords(recs []Record, opts Options) (Report, error) {
+	keys := keySet(opts.Keys)
+	var rep Report
+	var signed bool
+	var byID map[int64]*Record
+	for i, rec := range recs {
+		if i == 0 {
+			rep.FirstID = rec.ID
+			if opts.PrevHash != "" && rec.PrevHash != opts.PrevHash {
+				return rep, &RecordError{rec.ID, "prev_hash mismatch"}
+			}
+		} else {
+			prev := recs[i-1]
+			switch {
+			case rec.ID <= prev.ID:
+				return rep, &RecordError{rec.ID, fmt.Sprintf("out of order after record %d", prev.ID)}
+			case rec.ID == prev.ID+1:
+				if rec.PrevHash != prev.Hash {
+					return rep, &RecordError{rec.ID, "prev_hash mismatch"}
+				}
+			case opts.Contiguous:
+				return rep, &RecordError{rec.ID, fmt.Sprintf("records %d to %d missing", prev.ID+1, rec.ID-1)}
+			default:
+				rep.Gaps++
+			}
+		}
+		if !hashMatches(rec) {
+			return rep, &RecordError{rec.ID, "hash mismatch"}
+		}
+		if rec.Redaction != nil {
+			if byID == nil {
+				byID = make(map[int64]*Record, len(recs))
+				for j := range recs {
+					byID[recs[j].ID] = &recs[j]
+				}
+			}
+			if reason := redactionProblem(rec, byID, opts.ExternalRedactions); reason != "" {
+				return rep, &RecordError{rec.ID, reason}
+			}
+		}
+
+		if rec.Signature == "" {
+			if signed {
+				return rep, &RecordError{rec.ID, "signature missing"}
+			}
+		} else {
+			signed = true
+			rep.Signed++
+			if len(keys) > 0 {
+				pub, ok := keys[rec.SigningKeyID]
+				if !ok {
+					return rep, &RecordError{rec.ID, "unknown signing key " + rec.SigningKeyID}
+				}
+				if !VerifySignature(pub, []byte(rec.Hash), rec.Signature) {
+					return rep, &RecordError{rec.ID, "signature mismatch"}
+				}
+				rep.SignaturesVerified++
+			}
+		}
+		rep.Records++
+		rep.LastID, rep.LastHash = rec.ID, rec.Hash
+	}
+	return rep, nil
+}
+
+// redactionRecordType is the type of the record that logs a redaction.
+const redactionRecordType = "record.redacted"
+
+// redactionProblem reports why the redaction of rec is not as the ledger
+// makes it. The hash of a redacted record commits only to the payload hash
+// in its redaction stanza, so without these checks any payload could be
+// passed off with the stanza of the original.
+func redactionProblem(rec Record, byID map[int64]*Record, external bool) string {
+	if rec.Payload != "" {
+		return "payload present on redacted record"
+	}
+	if rec.Redaction.RedactionID <= rec.ID {
+		return fmt.Sprintf("redaction record %d does not follow the redacted record", rec.Redaction.RedactionID)
+	}
+	logged, ok := byID[rec.Redaction.RedactionID]
+	if !ok {
+		if external {
+			return ""
+		}
+		return fmt.Sprintf("redaction record %d missing", rec.Redaction.RedactionID)
+	}
+	var payload struct {
+		RecordID    int64  `json:"record_id"`
+		PayloadHash string `json:"payload_hash"`
+	}
+	if logged.Type != redactionRecordType || json.Unmarshal([]byte(logged.Payload), &payload) != nil ||
+		payload.RecordID != rec.ID || payload.PayloadHash != rec.Redaction.PayloadHash {
+		return fmt.Sprintf("redaction not logged by record %d", rec.Redaction.RedactionID)
+	}
+	return ""
+}
+
+// ReadJSONL decodes newline-delimited records, as written by `stateledger
+// export`. Unknown fields are rejected.
+func ReadJSONL(r io.Reader) ([]Record, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	var recs []Record
+	for line := 1; sc.Scan(); line++ {
+		if len(strings.TrimSpace(sc.Text())) == 0 {
+			continue
+		}
+		rec, err := decodeRecord(sc.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		recs = append(recs, rec)
+	}
+	return recs, sc.Err()
+}
+
+func decodeRecord(line []byte) (Record, error) {
+	var rec Record
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&rec)
+	return rec, err
+}
+
+// KeyID returns the ID under which the ledger stores signatures by pub: the
+// first 8 bytes of the SHA-256 of the raw key for ed25519 and of its DER
+// SubjectPublicKeyInfo otherwise, in hex.
+func KeyID(pub crypto.PublicKey) string {
+	raw, ok := pub.(ed25519.PublicKey)
+	if !ok {
+		der, err := x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			return ""
+		}
+		raw = der
+	}
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:8])
+}
+
+func keySet(keys []crypto.PublicKey) map[string]crypto.PublicKey {
+	set := make(map[string]crypto.PublicKey, len(keys))
+	for _, k := range keys {
+		set[KeyID(k)] = k
+	}
+	return set
+}
+
+// ParsePublicKey decodes a public key as printed by `stateledger keys
+// generate` and `stateledger keys public`: a base64-encoded raw ed25519
+// key, or a DER SubjectPublicKeyInfo, base64-encoded or in a PEM block.
+func ParsePublicKey(s string) (crypto.PublicKey, error) {
+	s = strings.TrimSpace(s)
+	var der []byte
+	if block, _ := pem.Decode([]byte(s)); block != nil {
+		der = block.Bytes
+	} else {
+		raw, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, errors.New("public key must be base64 or PEM-encoded")
+		}
+		if len(raw) == ed25519.PublicKeySize {
+			return ed25519.PublicKey(raw), nil
+		}
+		der = raw
+	}
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	switch pub.(type) {
+	case ed25519.PublicKey, *ecdsa.PublicKey, *rsa.PublicKey:
+		return pub, nil
+	}
+	return nil, fmt.Errorf("unsupported public key type %T", pub)
+}
+
+// VerifySignature checks the base64 signature sig of msg: ed25519 over the
+// message, ECDSA (ASN.1) and RSA PKCS #1 v1.5 over its SHA-256.
+func VerifySignature(pub crypto.PublicKey, msg []byte, sig string) bool {
+	raw, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	switch k := pub.(type) {
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, msg, raw)
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(msg)
+		return ecdsa.VerifyASN1(k, digest[:], raw)
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(msg)
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], raw) == nil
+	}
+	return false
+}
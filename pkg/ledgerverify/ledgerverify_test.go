@@ -0,0 +1,273 @@
+package ledgerverify
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Retr0-XD/StateLedger/internal/ledger"
+)
+
+// signedLedger returns a ledger with one unsigned record followed by five
+// records signed with the returned key, alternating types.
+func signedLedger(t *testing.T) (*ledger.Ledger, ed25519.PublicKey) {
+	t.Helper()
+	l, err := ledger.Open(filepath.Join(t.TempDir(), "ledger.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	if err := l.InitSchema(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Append(ledger.RecordInput{Timestamp: 1, Type: "deploy", Source: "ci", Payload: "v1"}); err != nil {
+		t.Fatal(err)
+	}
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	if err := l.SetSigningKey(priv); err != nil {
+		t.Fatal(err)
+	}
+	for i := int64(2); i <= 6; i++ {
+		rtype := "deploy"
+		if i%2 == 1 {
+			rtype = "job"
+		}
+		if _, err := l.Append(ledger.RecordInput{Timestamp: i, Type: rtype, Source: "ci", Payload: "v"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return l, pub
+}
+
+func export(t *testing.T, l *ledger.Ledger, opts ledger.ExportOptions) []Record {
+	t.Helper()
+	var buf bytes.Buffer
+	if _, err := l.ExportJSONL(&buf, opts); err != nil {
+		t.Fatal(err)
+	}
+	recs, err := ReadJSONL(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return recs
+}
+
+func TestVerifyExport(t *testing.T) {
+	l, pub := signedLedger(t)
+	recs := export(t, l, ledger.ExportOptions{})
+
+	report, err := VerifyRecords(recs, Options{Keys: []crypto.PublicKey{pub}, Contiguous: true})
+	if err != nil {
+		t.Fatalf("VerifyRecords: %v", err)
+	}
+	if report.Records != 6 || report.Signed != 5 || report.SignaturesVerified != 5 || report.LastID != 6 || report.LastHash != recs[5].Hash {
+		t.Fatalf("report = %+v", report)
+	}
+	if report, err := VerifyRecords(recs, Options{}); err != nil || report.Signed != 5 || report.SignaturesVerified != 0 {
+		t.Fatalf("without keys: %+v %v", report, err)
+	}
+
+	// A filtered export can only be followed across consecutive ids.
+	deploys := export(t, l, ledger.ExportOptions{Type: "deploy"})
+	if report, err := VerifyRecords(deploys, Options{Keys: []crypto.PublicKey{pub}}); err != nil || report.Records != 4 || report.Gaps != 2 {
+		t.Fatalf("filtered: %+v %v", report, err)
+	}
+	if _, err := VerifyRecords(deploys, Options{Contiguous: true}); err == nil {
+		t.Fatal("gaps accepted with Contiguous")
+	}
+
+	other, _, _ := ed25519.GenerateKey(nil)
+	tamper := func(f func(recs []Record)) []Record {
+		c := append([]Record(nil), recs...)
+		f(c)
+		return c
+	}
+	// Deleting a record and renumbering the rest breaks the chain.
+	dropped := append(append([]Record(nil), recs[:3]...), recs[4:]...)
+	for i := 3; i < len(dropped); i++ {
+		dropped[i].ID--
+	}
+	for name, tc := range map[string]struct {
+		recs   []Record
+		keys   []crypto.PublicKey
+		id     int64
+		reason string
+	}{
+		"payload":           {tamper(func(r []Record) { r[2].Payload = "v2" }), nil, 3, "hash mismatch"},
+		"deleted record":    {dropped, nil, 4, "prev_hash mismatch"},
+		"stripped":          {tamper(func(r []Record) { r[5].Signature = "" }), nil, 6, "signature missing"},
+		"forged signature":  {tamper(func(r []Record) { r[4].Signature = r[5].Signature }), []crypto.PublicKey{pub}, 5, "signature mismatch"},
+		"unknown key":       {recs, []crypto.PublicKey{other}, 2, "unknown signing key " + recs[1].SigningKeyID},
+		"wrong chain start": {recs[1:], nil, 0, ""},
+	} {
+		opts := Options{Keys: tc.keys}
+		if name == "wrong chain start" {
+			opts.PrevHash = recs[2].Hash
+			tc.id, tc.reason = 2, "prev_hash mismatch"
+		}
+		_, err := VerifyRecords(tc.recs, opts)
+		var re *RecordError
+		if !errors.As(err, &re) || re.ID != tc.id || re.Reason != tc.reason {
+			t.Errorf("%s: err = %v, want record %d: %s", name, err, tc.id, tc.reason)
+		}
+	}
+}
+
+func TestVerifySegments(t *testing.T) {
+	l, pub := signedLedger(t)
+	write := func(from, to int64, prevSegmentHash string) []byte {
+		recs, err := l.RecordsByID(from, to, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if _, err := ledger.WriteSegment(&buf, recs, prevSegmentHash); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	read := func(data []byte) Segment {
+		seg, err := ReadSegment(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return seg
+	}
+	first := read(write(1, 3, ""))
+	second := read(write(4, 6, first.Trailer.SegmentHash))
+
+	report, err := VerifySegments([]Segment{first, second}, pub)
+	if err != nil {
+		t.Fatalf("VerifySegments: %v", err)
+	}
+	if report.Records != 6 || report.SignaturesVerified != 5 {
+		t.Fatalf("report = %+v", report)
+	}
+	if _, err := VerifySegments([]Segment{second, first}, pub); err == nil {
+		t.Fatal("segments out of order accepted")
+	}
+
+	data := write(1, 3, "")
+	if _, err := ReadSegment(bytes.NewReader(bytes.Replace(data, []byte(`"payload":"v1"`), []byte(`"payload":"v0"`), 1))); err == nil || !strings.Contains(err.Error(), "segment hash mismatch") {
+		t.Fatalf("edited segment: %v", err)
+	}
+}
+
+func TestVerifyBundle(t *testing.T) {
+	l, pub := signedLedger(t)
+	bundle, err := ledger.New(l).ExportAuditBundle(6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := bundle.ToJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := VerifyBundle([]byte(data), pub)
+	if err != nil {
+		t.Fatalf("VerifyBundle: %v", err)
+	}
+	if b.Signature.KeyID != KeyID(pub) || b.TargetTime != 6 {
+		t.Fatalf("bundle = %+v", b)
+	}
+	recs := export(t, l, ledger.ExportOptions{})
+	if err := b.CheckRecords(recs); err != nil {
+		t.Fatalf("CheckRecords: %v", err)
+	}
+	if err := b.CheckRecords(recs[:2]); err == nil {
+		t.Fatal("export without the bundle head accepted")
+	}
+
+	edited := strings.Replace(data, `"target_time": 6`, `"target_time": 5`, 1)
+	if _, err := VerifyBundle([]byte(edited), pub); err == nil || err.Error() != "audit bundle signature mismatch" {
+		t.Fatalf("edited bundle: %v", err)
+	}
+	other, _, _ := ed25519.GenerateKey(nil)
+	if _, err := VerifyBundle([]byte(data), other); err == nil {
+		t.Fatal("bundle accepted with another key")
+	}
+}
+
+func TestVerifyRedactedExport(t *testing.T) {
+	l, pub := signedLedger(t)
+	if _, err := l.Redact(3, "erasure"); err != nil {
+		t.Fatal(err)
+	}
+	recs := export(t, l, ledger.ExportOptions{})
+	if recs[2].Payload != "" || recs[2].Redaction == nil {
+		t.Fatalf("exported redacted record = %+v", recs[2])
+	}
+	if _, err := VerifyRecords(recs, Options{Keys: []crypto.PublicKey{pub}, Contiguous: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	recs[2].Redaction.PayloadHash = PayloadHash("other")
+	var recErr *RecordError
+	if _, err := VerifyRecords(recs, Options{Contiguous: true}); !errors.As(err, &recErr) || recErr.ID != 3 {
+		t.Fatalf("altered payload hash: %v", err)
+	}
+}
+
+func TestVerifyForgedRedaction(t *testing.T) {
+	l, _ := signedLedger(t)
+	original := export(t, l, ledger.ExportOptions{})
+	forge := func(payload string, redactionID int64) []Record {
+		recs := append([]Record(nil), original...)
+		recs[1].Redaction = &Redaction{PayloadHash: PayloadHash(recs[1].Payload), RedactionID: redactionID}
+		recs[1].Payload = payload
+		return recs
+	}
+
+	// The stanza carries the original payload hash, so the record hash
+	// still matches; the payload and the redaction record give it away.
+	var recErr *RecordError
+	if _, err := VerifyRecords(forge(`{"n":"FORGED"}`, 7), Options{Contiguous: true}); !errors.As(err, &recErr) || recErr.ID != 2 || recErr.Reason != "payload present on redacted record" {
+		t.Fatalf("forged payload: %v", err)
+	}
+	if _, err := VerifyRecords(forge("", 7), Options{Contiguous: true}); !errors.As(err, &recErr) || recErr.ID != 2 || recErr.Reason != "redaction record 7 missing" {
+		t.Fatalf("redaction without its record: %v", err)
+	}
+	if _, err := VerifyRecords(forge("", 4), Options{Contiguous: true}); !errors.As(err, &recErr) || recErr.ID != 2 || recErr.Reason != "redaction not logged by record 4" {
+		t.Fatalf("redaction claimed by an unrelated record: %v", err)
+	}
+	if _, err := VerifyRecords(forge("", 1), Options{Contiguous: true}); !errors.As(err, &recErr) || recErr.ID != 2 {
+		t.Fatalf("redaction logged before the record: %v", err)
+	}
+
+	// Segments may end before the redaction record; present ones are
+	// still checked.
+	if _, err := VerifyRecords(forge("", 7), Options{Contiguous: true, ExternalRedactions: true}); err != nil {
+		t.Fatalf("redaction logged outside the records: %v", err)
+	}
+	if _, err := VerifyRecords(forge("", 4), Options{Contiguous: true, ExternalRedactions: true}); err == nil {
+		t.Fatal("redaction claimed by an unrelated record accepted")
+	}
+}
+
+func TestVerifyLabeledExport(t *testing.T) {
+	l, pub := signedLedger(t)
+	if _, err := l.Append(ledger.RecordInput{Timestamp: 7, Type: "deploy", Source: "ci", Payload: "v", Labels: map[string]string{"env": "prod", "team": "payments"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Redact(7, "erasure"); err != nil {
+		t.Fatal(err)
+	}
+	recs := export(t, l, ledger.ExportOptions{})
+	if recs[6].Labels["env"] != "prod" {
+		t.Fatalf("exported labeled record = %+v", recs[6])
+	}
+	if _, err := VerifyRecords(recs, Options{Keys: []crypto.PublicKey{pub}, Contiguous: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	recs[6].Labels["env"] = "staging"
+	var recErr *RecordError
+	if _, err := VerifyRecords(recs, Options{Contiguous: true}); !errors.As(err, &recErr) || recErr.ID != 7 || recErr.Reason != "hash mismatch" {
+		t.Fatalf("altered label: %v", err)
+	}
+}
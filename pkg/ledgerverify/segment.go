@@ -0,0 +1,131 @@
+package ledgerverify
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// SegmentFormat identifies segment files, as written by `stateledger
+// segment export` and WORM exports.
+const SegmentFormat = "stateledger-segment"
+
+// SegmentHeader is the first line of a segment file.
+type SegmentHeader struct {
+	Format          string `json:"format"`
+	Version         int    `json:"version"`
+	PrevSegmentHash string `json:"prev_segment_hash"`
+	PrevHash        string `json:"prev_hash"`
+	FirstID         int64  `json:"first_id"`
+	LastID          int64  `json:"last_id"`
+	Count           int    `json:"count"`
+	CreatedAt       int64  `json:"created_at"`
+}
+
+// SegmentTrailer is the last line of a segment file. SegmentHash is the
+// SHA-256 of every preceding line, newline included.
+type SegmentTrailer struct {
+	Trailer     bool   `json:"trailer"`
+	Count       int    `json:"count"`
+	LastHash    string `json:"last_hash"`
+	SegmentHash string `json:"segment_hash"`
+}
+
+// Segment is a decoded segment file whose trailer has been checked.
+type Segment struct {
+	Header  SegmentHeader
+	Records []Record
+	Trailer SegmentTrailer
+}
+
+// ReadSegment decodes a segment file and checks its segment hash, record
+// count and ID range. The records themselves are checked by
+// VerifySegments.
+func ReadSegment(r io.Reader) (Segment, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	var lines [][]byte
+	for sc.Scan() {
+		lines = append(lines, append([]byte(nil), sc.Bytes()...))
+	}
+	if err := sc.Err(); err != nil {
+		return Segment{}, err
+	}
+	if len(lines) < 2 {
+		return Segment{}, errors.New("segment truncated: missing header or trailer")
+	}
+
+	var seg Segment
+	if err := json.Unmarshal(lines[0], &seg.Header); err != nil {
+		return Segment{}, fmt.Errorf("segment header: %w", err)
+	}
+	if seg.Header.Format != SegmentFormat {
+		return Segment{}, fmt.Errorf("not a segment file (format %q)", seg.Header.Format)
+	}
+	if seg.Header.Version != 1 {
+		return Segment{}, fmt.Errorf("unsupported segment version %d", seg.Header.Version)
+	}
+	if err := json.Unmarshal(lines[len(lines)-1], &seg.Trailer); err != nil || !seg.Trailer.Trailer {
+		return Segment{}, errors.New("segment truncated: missing trailer")
+	}
+
+	h := sha256.New()
+	for i, line := range lines[:len(lines)-1] {
+		h.Write(line)
+		h.Write([]byte{'\n'})
+		if i == 0 {
+			continue
+		}
+		rec, err := decodeRecord(line)
+		if err != nil {
+			return Segment{}, fmt.Errorf("segment line %d: %w", i+1, err)
+		}
+		seg.Records = append(seg.Records, rec)
+	}
+	if hex.EncodeToString(h.Sum(nil)) != seg.Trailer.SegmentHash {
+		return Segment{}, errors.New("segment hash mismatch")
+	}
+	if len(seg.Records) != seg.Header.Count || seg.Trailer.Count != seg.Header.Count {
+		return Segment{}, errors.New("segment record count mismatch")
+	}
+	if len(seg.Records) == 0 {
+		return Segment{}, errors.New("segment has no records")
+	}
+	if seg.Records[0].ID != seg.Header.FirstID || seg.Records[len(seg.Records)-1].ID != seg.Header.LastID {
+		return Segment{}, errors.New("segment id range mismatch")
+	}
+	return seg, nil
+}
+
+// VerifySegments checks segments given in transfer order: each must follow
+// the previous one, by segment hash and by chain, and the records of each
+// must form an unbroken chain from its header's prev_hash to its trailer's
+// last_hash, with valid signatures and redactions as in VerifyRecords. A
+// redaction logged after the last segment is taken on trust.
+func VerifySegments(segs []Segment, keys ...crypto.PublicKey) (Report, error) {
+	var recs []Record
+	for i, seg := range segs {
+		if i > 0 {
+			prev := segs[i-1]
+			if seg.Header.PrevSegmentHash != prev.Trailer.SegmentHash {
+				return Report{}, fmt.Errorf("segment %d does not follow segment %d (prev_segment_hash mismatch)", i+1, i)
+			}
+			if seg.Header.PrevHash != prev.Trailer.LastHash {
+				return Report{}, fmt.Errorf("segment %d does not continue the chain of segment %d", i+1, i)
+			}
+		}
+		if seg.Records[0].PrevHash != seg.Header.PrevHash {
+			return Report{}, &RecordError{seg.Records[0].ID, "prev_hash mismatch"}
+		}
+		if last := seg.Records[len(seg.Records)-1]; last.Hash != seg.Trailer.LastHash {
+			return Report{}, fmt.Errorf("segment %d: last hash mismatch", i+1)
+		}
+		recs = append(recs, seg.Records...)
+	}
+	return VerifyRecords(recs, Options{Keys: keys, Contiguous: true, ExternalRedactions: true})
+}
@@ -0,0 +1,128 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// HTTPOptions configures the HTTP middleware.
+type HTTPOptions struct {
+	// Methods lists the HTTP methods treated as mutations. Defaults to
+	// POST, PUT, PATCH and DELETE.
+	Methods []string
+	// RefHeader is the request header used as the external reference.
+	// Defaults to X-Request-ID; a UUID is generated when it is absent.
+	RefHeader string
+	// OnError receives recording failures.
+	OnError ErrorHandler
+}
+
+// HTTP returns a middleware that records every successful mutating request
+// as a mutation: type "http.<METHOD>", source set to the matched route
+// pattern, hash over the request body and the request ID as external_ref.
+// A valid W3C traceparent header is propagated to the handler's context and
+// its trace and span IDs are attached to the mutation.
+//
+//	handler := middleware.HTTP(middleware.LedgerRecorder(l, "orders"), middleware.HTTPOptions{})(mux)
+func HTTP(rec Recorder, opts HTTPOptions) func(http.Handler) http.Handler {
+	methods := map[string]bool{}
+	if len(opts.Methods) == 0 {
+		opts.Methods = []string{http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+	}
+	for _, m := range opts.Methods {
+		methods[m] = true
+	}
+	if opts.RefHeader == "" {
+		opts.RefHeader = "X-Request-ID"
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !methods[r.Method] {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ref := r.Header.Get(opts.RefHeader)
+			if ref == "" {
+				ref = uuid.NewString()
+			}
+			ctx := WithExternalRef(r.Context(), ref)
+			tc, traced := ParseTraceparent(r.Header.Get("traceparent"))
+			if traced {
+				ctx = WithTrace(ctx, tc)
+			}
+			r = r.WithContext(ctx)
+
+			body := &hashingBody{ReadCloser: r.Body, h: sha256.New()}
+			if r.Body != nil {
+				r.Body = body
+			}
+
+			sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(sw, r)
+
+			if sw.status >= 400 {
+				return
+			}
+
+			route := r.Pattern
+			if route == "" {
+				route = r.Method + " " + r.URL.Path
+			}
+
+			m := Mutation{
+				Type:        "http." + r.Method,
+				ID:          ref,
+				Source:      route,
+				Hash:        "sha256:" + hex.EncodeToString(body.h.Sum(nil)),
+				ExternalRef: ref,
+				Timestamp:   time.Now(),
+				TraceID:     tc.TraceID,
+				SpanID:      tc.SpanID,
+			}
+			// Record outside the request context so a client disconnect does
+			// not drop the mutation.
+			recCtx := WithExternalRef(context.Background(), ref)
+			if traced {
+				recCtx = WithTrace(recCtx, tc)
+			}
+			if err := rec.Record(recCtx, m); err != nil && opts.OnError != nil {
+				opts.OnError(recCtx, m, err)
+			}
+		})
+	}
+}
+
+// hashingBody hashes the request body as the handler consumes it.
+type hashingBody struct {
+	io.ReadCloser
+	h hash.Hash
+}
+
+func (b *hashingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.h.Write(p[:n])
+	return n, err
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
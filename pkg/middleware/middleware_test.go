@@ -0,0 +1,149 @@
+package middleware
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"modernc.org/sqlite"
+)
+
+type memRecorder struct {
+	mu        sync.Mutex
+	mutations []Mutation
+}
+
+func (r *memRecorder) Record(_ context.Context, m Mutation) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.mutations = append(r.mutations, m)
+	return nil
+}
+
+func (r *memRecorder) all() []Mutation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Mutation(nil), r.mutations...)
+}
+
+func TestHTTPMiddleware(t *testing.T) {
+	rec := &memRecorder{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /orders/{id}", func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusCreated)
+	})
+	mux.HandleFunc("DELETE /orders/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	mux.HandleFunc("GET /orders/{id}", func(w http.ResponseWriter, r *http.Request) {})
+
+	srv := httptest.NewServer(HTTP(rec, HTTPOptions{})(mux))
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/orders/7", strings.NewReader(`{"qty":1}`))
+	req.Header.Set("X-Request-ID", "req-1")
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if _, err := http.DefaultClient.Do(req); err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	http.Get(srv.URL + "/orders/7")
+	req, _ = http.NewRequest(http.MethodDelete, srv.URL+"/orders/7", nil)
+	http.DefaultClient.Do(req)
+
+	got := rec.all()
+	if len(got) != 1 {
+		t.Fatalf("expected 1 mutation, got %d: %+v", len(got), got)
+	}
+	m := got[0]
+	if m.Type != "http.POST" || m.Source != "POST /orders/{id}" || m.ExternalRef != "req-1" {
+		t.Fatalf("unexpected mutation: %+v", m)
+	}
+	if m.Hash != hashBytes([]byte(`{"qty":1}`)) {
+		t.Fatalf("unexpected body hash %s", m.Hash)
+	}
+	if m.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || m.SpanID != "00f067aa0ba902b7" {
+		t.Fatalf("unexpected trace context: %+v", m)
+	}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	cases := map[string]bool{
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01":       true,
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra": true,
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra": false,
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01":       false,
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01":       false,
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01":       false,
+		"garbage": false,
+	}
+	for header, want := range cases {
+		if _, ok := ParseTraceparent(header); ok != want {
+			t.Errorf("ParseTraceparent(%q) ok=%v, want %v", header, ok, want)
+		}
+	}
+}
+
+func TestSQLDriverWrapper(t *testing.T) {
+	rec := &memRecorder{}
+	name := "sqlite-middleware-test"
+	sql.Register(name, WrapDriver(&sqlite.Driver{}, rec, SQLOptions{Source: "orders-db"}))
+
+	db, err := sql.Open(name, filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	ctx := WithExternalRef(context.Background(), "req-9")
+	if _, err := db.ExecContext(ctx, "CREATE TABLE orders (id INTEGER PRIMARY KEY, qty INTEGER)"); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := db.ExecContext(ctx, "INSERT INTO orders (qty) VALUES (?)", 3); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	rows, err := db.QueryContext(ctx, "SELECT * FROM orders")
+	if err != nil {
+		t.Fatalf("select: %v", err)
+	}
+	rows.Close()
+
+	tx, _ := db.BeginTx(ctx, nil)
+	tx.ExecContext(ctx, "UPDATE orders SET qty = 4")
+	tx.Rollback()
+
+	tx, _ = db.BeginTx(ctx, nil)
+	stmt, err := tx.PrepareContext(ctx, "DELETE FROM orders WHERE id = ?")
+	if err != nil {
+		t.Fatalf("prepare: %v", err)
+	}
+	stmt.ExecContext(ctx, 1)
+	if len(rec.all()) != 2 {
+		t.Fatalf("uncommitted statements must not be recorded yet: %+v", rec.all())
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+
+	got := rec.all()
+	var types []string
+	for _, m := range got {
+		types = append(types, m.Type)
+		if m.Source != "orders-db" {
+			t.Fatalf("unexpected source: %+v", m)
+		}
+	}
+	if strings.Join(types, ",") != "sql.create,sql.insert,sql.delete" {
+		t.Fatalf("unexpected mutations: %v", types)
+	}
+	if got[1].ExternalRef != "req-9" || got[1].Hash != hashBytes([]byte("INSERT INTO orders (qty) VALUES (?)")) {
+		t.Fatalf("unexpected insert mutation: %+v", got[1])
+	}
+}
@@ -0,0 +1,115 @@
+// Package middleware records application mutations into StateLedger with
+// minimal wiring: wrap an http.Handler or a database/sql driver and every
+// successful write is appended to the ledger as a mutation record.
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/Retr0-XD/StateLedger/internal/collectors"
+	"github.com/Retr0-XD/StateLedger/internal/ledger"
+	"github.com/Retr0-XD/StateLedger/pkg/client"
+)
+
+// Mutation is a single captured state change.
+type Mutation struct {
+	Type        string
+	ID          string
+	Source      string
+	Hash        string
+	ExternalRef string
+	TraceID     string
+	SpanID      string
+	Timestamp   time.Time
+}
+
+func (m Mutation) payload() collectors.MutationPayload {
+	return collectors.MutationPayload{
+		Type:        m.Type,
+		ID:          m.ID,
+		Source:      m.Source,
+		Hash:        m.Hash,
+		ExternalRef: m.ExternalRef,
+		TraceID:     m.TraceID,
+		SpanID:      m.SpanID,
+	}
+}
+
+// withTrace copies the trace context attached to ctx into m.
+func (m Mutation) withTrace(ctx context.Context) Mutation {
+	if tc, ok := TraceFromContext(ctx); ok {
+		m.TraceID = tc.TraceID
+		m.SpanID = tc.SpanID
+	}
+	return m
+}
+
+// Recorder persists captured mutations.
+type Recorder interface {
+	Record(ctx context.Context, m Mutation) error
+}
+
+// RecorderFunc adapts a function to the Recorder interface.
+type RecorderFunc func(ctx context.Context, m Mutation) error
+
+// Record calls f(ctx, m).
+func (f RecorderFunc) Record(ctx context.Context, m Mutation) error {
+	return f(ctx, m)
+}
+
+// LedgerRecorder appends mutations to a local ledger.
+func LedgerRecorder(l *ledger.Ledger, source string) Recorder {
+	return RecorderFunc(func(ctx context.Context, m Mutation) error {
+		body, err := collectors.MarshalPayload(m.payload())
+		if err != nil {
+			return err
+		}
+		_, err = l.Append(ledger.RecordInput{
+			Timestamp: m.Timestamp.Unix(),
+			Type:      "mutation",
+			Source:    source,
+			Payload:   body,
+		})
+		return err
+	})
+}
+
+// ClientRecorder appends mutations to a remote ledger server.
+func ClientRecorder(c *client.Client, source string) Recorder {
+	return RecorderFunc(func(ctx context.Context, m Mutation) error {
+		_, err := c.Append(ctx, client.AppendInput{
+			Type:      "mutation",
+			Source:    source,
+			Timestamp: m.Timestamp.Unix(),
+			Payload:   m.payload(),
+		})
+		return err
+	})
+}
+
+type externalRefKey struct{}
+
+// WithExternalRef attaches an external reference (request ID, message
+// offset) to ctx. Mutations recorded with ctx carry the reference, which
+// correlates database writes with the request that caused them.
+func WithExternalRef(ctx context.Context, ref string) context.Context {
+	return context.WithValue(ctx, externalRefKey{}, ref)
+}
+
+// ExternalRef returns the external reference attached to ctx, if any.
+func ExternalRef(ctx context.Context) string {
+	ref, _ := ctx.Value(externalRefKey{}).(string)
+	return ref
+}
+
+// ErrorHandler is called when recording a mutation fails. Recording errors
+// never fail the wrapped operation.
+type ErrorHandler func(ctx context.Context, m Mutation, err error)
+
+func hashBytes(b []byte) string {
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,302 @@
+package middleware
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SQLOptions configures the database/sql driver wrapper.
+type SQLOptions struct {
+	// Source is recorded as the mutation source (e.g. the database name).
+	Source string
+	// OnError receives recording failures.
+	OnError ErrorHandler
+}
+
+// WrapDriver wraps a database/sql driver so that every successful
+// data-modifying statement (INSERT, UPDATE, DELETE, DDL) is recorded as a
+// mutation with the SHA-256 of the statement text. Statements executed in a
+// transaction are recorded only once the transaction commits. Argument
+// values are never recorded.
+//
+//	sql.Register("sqlite-ledgered", middleware.WrapDriver(&sqlite.Driver{}, rec, middleware.SQLOptions{Source: "orders-db"}))
+func WrapDriver(d driver.Driver, rec Recorder, opts SQLOptions) driver.Driver {
+	return &wrappedDriver{parent: d, rec: rec, opts: opts}
+}
+
+type wrappedDriver struct {
+	parent driver.Driver
+	rec    Recorder
+	opts   SQLOptions
+}
+
+func (d *wrappedDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.parent.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &wrappedConn{parent: conn, d: d}, nil
+}
+
+type wrappedConn struct {
+	parent driver.Conn
+	d      *wrappedDriver
+
+	mu      sync.Mutex
+	inTx    bool
+	pending []Mutation
+}
+
+func (c *wrappedConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *wrappedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var stmt driver.Stmt
+	var err error
+	if p, ok := c.parent.(driver.ConnPrepareContext); ok {
+		stmt, err = p.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.parent.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &wrappedStmt{parent: stmt, conn: c, query: query}, nil
+}
+
+func (c *wrappedConn) Close() error {
+	return c.parent.Close()
+}
+
+func (c *wrappedConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *wrappedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	var tx driver.Tx
+	var err error
+	if b, ok := c.parent.(driver.ConnBeginTx); ok {
+		tx, err = b.BeginTx(ctx, opts)
+	} else {
+		tx, err = c.parent.Begin() //nolint:staticcheck // fallback for legacy drivers
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.inTx = true
+	c.pending = nil
+	c.mu.Unlock()
+
+	return &wrappedTx{parent: tx, conn: c}, nil
+}
+
+func (c *wrappedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.parent.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	res, err := execer.ExecContext(ctx, query, args)
+	if err == nil {
+		c.captured(ctx, query)
+	}
+	return res, err
+}
+
+func (c *wrappedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.parent.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	return queryer.QueryContext(ctx, query, args)
+}
+
+func (c *wrappedConn) Ping(ctx context.Context) error {
+	if p, ok := c.parent.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *wrappedConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.parent.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *wrappedConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := c.parent.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// captured records a successful statement, deferring it until commit when
+// a transaction is open.
+func (c *wrappedConn) captured(ctx context.Context, query string) {
+	verb, ok := mutationVerb(query)
+	if !ok {
+		return
+	}
+
+	ref := ExternalRef(ctx)
+	m := Mutation{
+		Type:        "sql." + verb,
+		ID:          uuid.NewString(),
+		Source:      c.d.opts.Source,
+		Hash:        hashBytes([]byte(strings.TrimSpace(query))),
+		ExternalRef: ref,
+		Timestamp:   time.Now(),
+	}.withTrace(ctx)
+
+	c.mu.Lock()
+	if c.inTx {
+		c.pending = append(c.pending, m)
+		c.mu.Unlock()
+		return
+	}
+	c.mu.Unlock()
+
+	c.record(ctx, []Mutation{m})
+}
+
+func (c *wrappedConn) record(ctx context.Context, ms []Mutation) {
+	for _, m := range ms {
+		if err := c.d.rec.Record(ctx, m); err != nil && c.d.opts.OnError != nil {
+			c.d.opts.OnError(ctx, m, err)
+		}
+	}
+}
+
+func (c *wrappedConn) endTx(commit bool) []Mutation {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pending := c.pending
+	c.inTx = false
+	c.pending = nil
+	if !commit {
+		return nil
+	}
+	return pending
+}
+
+type wrappedTx struct {
+	parent driver.Tx
+	conn   *wrappedConn
+}
+
+func (t *wrappedTx) Commit() error {
+	if err := t.parent.Commit(); err != nil {
+		t.conn.endTx(false)
+		return err
+	}
+	t.conn.record(context.Background(), t.conn.endTx(true))
+	return nil
+}
+
+func (t *wrappedTx) Rollback() error {
+	t.conn.endTx(false)
+	return t.parent.Rollback()
+}
+
+type wrappedStmt struct {
+	parent driver.Stmt
+	conn   *wrappedConn
+	query  string
+}
+
+func (s *wrappedStmt) Close() error {
+	return s.parent.Close()
+}
+
+func (s *wrappedStmt) NumInput() int {
+	return s.parent.NumInput()
+}
+
+func (s *wrappedStmt) Exec(args []driver.Value) (driver.Result, error) {
+	res, err := s.parent.Exec(args) //nolint:staticcheck // required by driver.Stmt
+	if err == nil {
+		s.conn.captured(context.Background(), s.query)
+	}
+	return res, err
+}
+
+func (s *wrappedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	var res driver.Result
+	var err error
+	if e, ok := s.parent.(driver.StmtExecContext); ok {
+		res, err = e.ExecContext(ctx, args)
+	} else {
+		values, convErr := namedToValues(args)
+		if convErr != nil {
+			return nil, convErr
+		}
+		res, err = s.parent.Exec(values) //nolint:staticcheck // fallback for legacy drivers
+	}
+	if err == nil {
+		s.conn.captured(ctx, s.query)
+	}
+	return res, err
+}
+
+func (s *wrappedStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.parent.Query(args) //nolint:staticcheck // required by driver.Stmt
+}
+
+func (s *wrappedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	if q, ok := s.parent.(driver.StmtQueryContext); ok {
+		return q.QueryContext(ctx, args)
+	}
+	values, err := namedToValues(args)
+	if err != nil {
+		return nil, err
+	}
+	return s.parent.Query(values) //nolint:staticcheck // fallback for legacy drivers
+}
+
+func namedToValues(args []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		if arg.Name != "" {
+			return nil, errors.New("driver does not support named parameters")
+		}
+		values[i] = arg.Value
+	}
+	return values, nil
+}
+
+var mutationVerbs = map[string]bool{
+	"INSERT": true, "UPDATE": true, "DELETE": true, "REPLACE": true,
+	"UPSERT": true, "MERGE": true, "CREATE": true, "ALTER": true,
+	"DROP": true, "TRUNCATE": true,
+}
+
+// mutationVerb returns the leading keyword of a data-modifying statement.
+// Statements starting with WITH are classified by the first mutation verb
+// that follows the CTE.
+func mutationVerb(query string) (string, bool) {
+	fields := strings.Fields(strings.ToUpper(query))
+	if len(fields) == 0 {
+		return "", false
+	}
+	if mutationVerbs[fields[0]] {
+		return strings.ToLower(fields[0]), true
+	}
+	if fields[0] == "WITH" {
+		for _, f := range fields[1:] {
+			if mutationVerbs[f] {
+				return strings.ToLower(f), true
+			}
+		}
+	}
+	return "", false
+}
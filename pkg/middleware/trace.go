@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"context"
+	"encoding/hex"
+	"strings"
+)
+
+// TraceContext identifies the distributed trace span a mutation belongs to.
+type TraceContext struct {
+	TraceID string
+	SpanID  string
+}
+
+// ParseTraceparent parses a W3C traceparent header
+// ("00-<32 hex trace-id>-<16 hex parent-id>-<2 hex flags>"). ok is false
+// for malformed headers and for the all-zero IDs the spec marks invalid.
+func ParseTraceparent(header string) (tc TraceContext, ok bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 {
+		return TraceContext{}, false
+	}
+	version, traceID, spanID, flags := parts[0], parts[1], parts[2], parts[3]
+	if !isLowerHex(version, 2) || version == "ff" || !isLowerHex(flags, 2) {
+		return TraceContext{}, false
+	}
+	// Version 00 has exactly four fields; later versions may append more.
+	if version == "00" && len(parts) != 4 {
+		return TraceContext{}, false
+	}
+	if !isLowerHex(traceID, 32) || !isLowerHex(spanID, 16) {
+		return TraceContext{}, false
+	}
+	if traceID == strings.Repeat("0", 32) || spanID == strings.Repeat("0", 16) {
+		return TraceContext{}, false
+	}
+	return TraceContext{TraceID: traceID, SpanID: spanID}, true
+}
+
+func isLowerHex(s string, n int) bool {
+	if len(s) != n || strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+type traceKey struct{}
+
+// WithTrace attaches a trace context to ctx. Mutations recorded with ctx
+// carry its trace and span IDs.
+func WithTrace(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, traceKey{}, tc)
+}
+
+// TraceFromContext returns the trace context attached to ctx, if any.
+func TraceFromContext(ctx context.Context) (TraceContext, bool) {
+	tc, ok := ctx.Value(traceKey{}).(TraceContext)
+	return tc, ok
+}
@@ -0,0 +1,158 @@
+// Package outbox implements the transactional outbox pattern for StateLedger.
+//
+// Applications enqueue ledger records into an outbox table inside their own
+// database transaction, so a record exists if and only if the business write
+// committed. A Relay then forwards pending entries to the ledger, tagging
+// each with its idempotency key so that redelivery after a crash or timeout
+// never appends the same record twice.
+//
+// The outbox uses "?" placeholders and works with SQLite and MySQL drivers.
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultTable is the outbox table name used when none is configured.
+const DefaultTable = "stateledger_outbox"
+
+var tableNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Entry is a ledger record waiting to be relayed.
+type Entry struct {
+	ID             int64  `json:"id"`
+	IdempotencyKey string `json:"idempotency_key"`
+	Timestamp      int64  `json:"timestamp"`
+	Type           string `json:"type"`
+	Source         string `json:"source"`
+	Payload        string `json:"payload"`
+	Attempts       int    `json:"attempts"`
+	LastError      string `json:"last_error,omitempty"`
+}
+
+// Outbox stores pending ledger records in an application database.
+type Outbox struct {
+	db    *sql.DB
+	table string
+}
+
+// New returns an outbox backed by db. An empty table selects DefaultTable.
+func New(db *sql.DB, table string) (*Outbox, error) {
+	if db == nil {
+		return nil, errors.New("db required")
+	}
+	if table == "" {
+		table = DefaultTable
+	}
+	if !tableNamePattern.MatchString(table) {
+		return nil, fmt.Errorf("invalid outbox table name %q", table)
+	}
+	return &Outbox{db: db, table: table}, nil
+}
+
+// InitSchema creates the outbox table if it does not exist.
+func (o *Outbox) InitSchema(ctx context.Context) error {
+	_, err := o.db.ExecContext(ctx, fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %[1]s (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	idempotency_key TEXT NOT NULL UNIQUE,
+	ts INTEGER NOT NULL,
+	type TEXT NOT NULL,
+	source TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT NOT NULL DEFAULT '',
+	relayed_at INTEGER
+);
+CREATE INDEX IF NOT EXISTS idx_%[1]s_pending ON %[1]s(relayed_at, id);
+`, o.table))
+	return err
+}
+
+// Enqueue adds e to the outbox within tx and returns its idempotency key.
+// A key is generated when e.IdempotencyKey is empty. The entry becomes
+// visible to the relay only if tx commits.
+func (o *Outbox) Enqueue(ctx context.Context, tx *sql.Tx, e Entry) (string, error) {
+	if tx == nil {
+		return "", errors.New("transaction required")
+	}
+	if strings.TrimSpace(e.Type) == "" {
+		return "", errors.New("type required")
+	}
+	if strings.TrimSpace(e.Payload) == "" {
+		return "", errors.New("payload required")
+	}
+	if e.IdempotencyKey == "" {
+		e.IdempotencyKey = uuid.NewString()
+	}
+	if e.Timestamp == 0 {
+		e.Timestamp = time.Now().Unix()
+	}
+
+	_, err := tx.ExecContext(ctx,
+		fmt.Sprintf(`INSERT INTO %s(idempotency_key, ts, type, source, payload) VALUES(?, ?, ?, ?, ?)`, o.table),
+		e.IdempotencyKey, e.Timestamp, e.Type, e.Source, e.Payload)
+	if err != nil {
+		return "", err
+	}
+	return e.IdempotencyKey, nil
+}
+
+// Pending returns up to limit unrelayed entries in enqueue order.
+func (o *Outbox) Pending(ctx context.Context, limit int) ([]Entry, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	rows, err := o.db.QueryContext(ctx,
+		fmt.Sprintf(`SELECT id, idempotency_key, ts, type, source, payload, attempts, last_error FROM %s WHERE relayed_at IS NULL ORDER BY id ASC LIMIT ?`, o.table),
+		limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Entry
+	for rows.Next() {
+		var e Entry
+		if err := rows.Scan(&e.ID, &e.IdempotencyKey, &e.Timestamp, &e.Type, &e.Source, &e.Payload, &e.Attempts, &e.LastError); err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+// PendingCount returns the number of unrelayed entries.
+func (o *Outbox) PendingCount(ctx context.Context) (int64, error) {
+	var n int64
+	err := o.db.QueryRowContext(ctx, fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE relayed_at IS NULL`, o.table)).Scan(&n)
+	return n, err
+}
+
+func (o *Outbox) markRelayed(ctx context.Context, id int64) error {
+	_, err := o.db.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET relayed_at = ?, last_error = '' WHERE id = ?`, o.table), time.Now().Unix(), id)
+	return err
+}
+
+func (o *Outbox) markFailed(ctx context.Context, id int64, cause error) error {
+	_, err := o.db.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET attempts = attempts + 1, last_error = ? WHERE id = ?`, o.table), cause.Error(), id)
+	return err
+}
+
+// Purge deletes entries relayed before the cutoff and returns how many were
+// removed.
+func (o *Outbox) Purge(ctx context.Context, before time.Time) (int64, error) {
+	res, err := o.db.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE relayed_at IS NOT NULL AND relayed_at < ?`, o.table), before.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
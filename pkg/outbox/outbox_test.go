@@ -0,0 +1,131 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/Retr0-XD/StateLedger/internal/ledger"
+	_ "modernc.org/sqlite"
+)
+
+func newTestOutbox(t *testing.T) (*Outbox, *sql.DB) {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatalf("open app db: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	o, err := New(db, "")
+	if err != nil {
+		t.Fatalf("new outbox: %v", err)
+	}
+	if err := o.InitSchema(context.Background()); err != nil {
+		t.Fatalf("init outbox: %v", err)
+	}
+	return o, db
+}
+
+func newTestLedger(t *testing.T) *ledger.Ledger {
+	t.Helper()
+	l, err := ledger.Open(filepath.Join(t.TempDir(), "ledger.db"))
+	if err != nil {
+		t.Fatalf("open ledger: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	if err := l.InitSchema(); err != nil {
+		t.Fatalf("init ledger: %v", err)
+	}
+	return l
+}
+
+func enqueue(t *testing.T, o *Outbox, db *sql.DB, commit bool, e Entry) string {
+	t.Helper()
+	ctx := context.Background()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	key, err := o.Enqueue(ctx, tx, e)
+	if err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	if commit {
+		err = tx.Commit()
+	} else {
+		err = tx.Rollback()
+	}
+	if err != nil {
+		t.Fatalf("end tx: %v", err)
+	}
+	return key
+}
+
+func TestRelayDeliversCommittedEntriesOnce(t *testing.T) {
+	o, db := newTestOutbox(t)
+	l := newTestLedger(t)
+	ctx := context.Background()
+
+	enqueue(t, o, db, true, Entry{Timestamp: 1000, Type: "mutation", Source: "orders", Payload: `{"type":"order_created","id":"1","source":"orders","hash":"h1"}`})
+	enqueue(t, o, db, false, Entry{Timestamp: 1001, Type: "mutation", Source: "orders", Payload: `{"type":"order_created","id":"rolled-back","source":"orders","hash":"h0"}`})
+	enqueue(t, o, db, true, Entry{Timestamp: 1002, Type: "mutation", Source: "orders", Payload: `{"type":"order_created","id":"2","source":"orders","hash":"h2"}`})
+
+	// Simulate a relay that delivered the first entry and crashed before
+	// marking it relayed.
+	pending, _ := o.Pending(ctx, 10)
+	if len(pending) != 2 {
+		t.Fatalf("expected 2 pending entries, got %d", len(pending))
+	}
+	if err := LedgerSink(l).Deliver(ctx, pending[0]); err != nil {
+		t.Fatalf("deliver: %v", err)
+	}
+
+	fail := true
+	var reported int
+	relay := &Relay{
+		Outbox: o,
+		Sink: SinkFunc(func(ctx context.Context, e Entry) error {
+			if e.Payload == `{"type":"order_created","id":"2","source":"orders","hash":"h2"}` && fail {
+				fail = false
+				return errors.New("ledger unavailable")
+			}
+			return LedgerSink(l).Deliver(ctx, e)
+		}),
+		OnError: func(Entry, error) { reported++ },
+	}
+
+	n, err := relay.RelayOnce(ctx)
+	if err == nil || n != 1 || reported != 1 {
+		t.Fatalf("first pass: n=%d err=%v reported=%d", n, err, reported)
+	}
+	pending, _ = o.Pending(ctx, 10)
+	if len(pending) != 1 || pending[0].Attempts != 1 || pending[0].LastError != "ledger unavailable" {
+		t.Fatalf("unexpected pending state: %+v", pending)
+	}
+
+	if n, err := relay.RelayOnce(ctx); err != nil || n != 1 {
+		t.Fatalf("second pass: n=%d err=%v", n, err)
+	}
+	if count, _ := o.PendingCount(ctx); count != 0 {
+		t.Fatalf("expected drained outbox, %d pending", count)
+	}
+
+	recs, err := l.List(ledger.ListQuery{Limit: 10})
+	if err != nil || len(recs) != 2 {
+		t.Fatalf("expected exactly 2 ledger records, got %d err=%v", len(recs), err)
+	}
+	if recs[0].Payload != `{"type":"order_created","id":"1","source":"orders","hash":"h1"}` || recs[1].Payload != `{"type":"order_created","id":"2","source":"orders","hash":"h2"}` {
+		t.Fatalf("unexpected ledger order: %+v", recs)
+	}
+}
+
+func TestNewRejectsInvalidTable(t *testing.T) {
+	db, _ := sql.Open("sqlite", filepath.Join(t.TempDir(), "app.db"))
+	defer db.Close()
+	if _, err := New(db, "outbox; DROP TABLE users"); err == nil {
+		t.Fatal("expected invalid table name error")
+	}
+}
@@ -0,0 +1,118 @@
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/Retr0-XD/StateLedger/internal/ledger"
+)
+
+// Sink receives relayed entries. Implementations must treat the idempotency
+// key as the identity of the record: delivering the same key twice must
+// append at most one record.
+type Sink interface {
+	Deliver(ctx context.Context, e Entry) error
+}
+
+// SinkFunc adapts a function to the Sink interface.
+type SinkFunc func(ctx context.Context, e Entry) error
+
+// Deliver calls f(ctx, e).
+func (f SinkFunc) Deliver(ctx context.Context, e Entry) error {
+	return f(ctx, e)
+}
+
+// LedgerSink delivers entries to a local ledger using AppendIdempotent.
+// Payloads of collector kinds must be valid for their collector.
+func LedgerSink(l *ledger.Ledger) Sink {
+	return SinkFunc(func(ctx context.Context, e Entry) error {
+		_, _, err := l.AppendIdempotent(e.IdempotencyKey, ledger.RecordInput{
+			Timestamp: e.Timestamp,
+			Type:      e.Type,
+			Source:    e.Source,
+			Payload:   e.Payload,
+		})
+		return err
+	})
+}
+
+// Relay forwards outbox entries to a sink in enqueue order.
+type Relay struct {
+	Outbox *Outbox
+	Sink   Sink
+	// BatchSize bounds how many entries are read per pass. Defaults to 100.
+	BatchSize int
+	// Interval is the polling period used by Run. Defaults to one second.
+	Interval time.Duration
+	// OnError receives delivery and bookkeeping failures.
+	OnError func(e Entry, err error)
+}
+
+// RelayOnce delivers pending entries until the outbox is drained or a
+// delivery fails, and returns the number delivered. A failed entry stops the
+// pass so that records reach the ledger in the order they were committed;
+// it is retried on the next pass.
+//
+// An entry is marked relayed only after the sink accepts it. If the process
+// dies in between, the entry is delivered again and the sink's idempotency
+// check discards the duplicate, giving exactly-once appends end to end.
+func (r *Relay) RelayOnce(ctx context.Context) (int, error) {
+	batch := r.BatchSize
+	if batch <= 0 {
+		batch = 100
+	}
+
+	delivered := 0
+	for {
+		entries, err := r.Outbox.Pending(ctx, batch)
+		if err != nil {
+			return delivered, err
+		}
+		if len(entries) == 0 {
+			return delivered, nil
+		}
+
+		for _, e := range entries {
+			if err := ctx.Err(); err != nil {
+				return delivered, err
+			}
+			if err := r.Sink.Deliver(ctx, e); err != nil {
+				r.reportError(e, err)
+				if markErr := r.Outbox.markFailed(ctx, e.ID, err); markErr != nil {
+					r.reportError(e, markErr)
+				}
+				return delivered, err
+			}
+			if err := r.Outbox.markRelayed(ctx, e.ID); err != nil {
+				return delivered, err
+			}
+			delivered++
+		}
+	}
+}
+
+// Run relays entries every Interval until ctx is cancelled.
+func (r *Relay) Run(ctx context.Context) error {
+	interval := r.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		// Delivery errors are reported via OnError and retried next tick.
+		_, _ = r.RelayOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Relay) reportError(e Entry, err error) {
+	if r.OnError != nil {
+		r.OnError(e, err)
+	}
+}
@@ -0,0 +1,179 @@
+// Package replay checks that application state can actually be rebuilt
+// from a ledger: it folds the replay plan at a point in time into a state
+// machine and compares the resulting state hash with the expected one.
+package replay
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/Retr0-XD/StateLedger/internal/ledger"
+)
+
+// ErrStateMismatch is returned by Simulate when the rebuilt state's
+// checkpoint differs from the expected hash.
+var ErrStateMismatch = errors.New("rebuilt state does not match expected hash")
+
+// Mutation is one mutation of the replay plan with its record payload.
+type Mutation struct {
+	RecordID    int64
+	Timestamp   int64
+	Namespace   string
+	Type        string
+	ID          string
+	Source      string
+	Hash        string
+	ExternalRef string
+	// Offset is the numeric position in ExternalRef, when it has one.
+	Offset  int64
+	Payload string
+}
+
+// StateMachine is application state rebuilt by applying mutations in
+// replay order.
+type StateMachine interface {
+	// Apply applies one mutation to the state.
+	Apply(m Mutation) error
+	// Checkpoint returns a hash of the current state. Equal states must
+	// return equal hashes.
+	Checkpoint() string
+}
+
+// Options configure Simulate.
+type Options struct {
+	// Expected is the state hash the replay must produce. Empty skips the
+	// comparison.
+	Expected string
+	// Namespaces limits the replay to these namespaces. Empty replays all.
+	Namespaces []string
+}
+
+// NamespaceResult reports one namespace of a simulation.
+type NamespaceResult struct {
+	Namespace string `json:"namespace"`
+	Applied   int    `json:"applied"`
+	// Checkpoint is the state hash after the namespace was applied.
+	Checkpoint string `json:"checkpoint"`
+}
+
+// Failure is the mutation a state machine refused.
+type Failure struct {
+	RecordID    int64  `json:"record_id"`
+	ExternalRef string `json:"external_ref,omitempty"`
+	Error       string `json:"error"`
+}
+
+// Result reports a simulation.
+type Result struct {
+	TargetTime int64             `json:"target_time"`
+	Applied    int               `json:"applied"`
+	Namespaces []NamespaceResult `json:"namespaces"`
+	Hash       string            `json:"hash"`
+	Expected   string            `json:"expected,omitempty"`
+	Match      bool              `json:"match"`
+	Failure    *Failure          `json:"failure,omitempty"`
+}
+
+// Simulate folds the replay plan at targetTime into sm, namespace by
+// namespace in plan order, and checkpoints the result. It returns an error
+// wrapping the state machine's when a mutation fails to apply, and
+// ErrStateMismatch when opts.Expected is set and differs from the final
+// checkpoint. Simulate only reads the ledger.
+func Simulate(ctx context.Context, l *ledger.Ledger, targetTime int64, sm StateMachine, opts Options) (Result, error) {
+	result := Result{TargetTime: targetTime, Namespaces: []NamespaceResult{}, Expected: opts.Expected}
+	report := ledger.New(l).ReconstructAtTime(targetTime)
+	if !report.Success {
+		return result, fmt.Errorf("reconstruct at %d: %v", targetTime, report.Issues)
+	}
+
+	only := map[string]bool{}
+	for _, ns := range opts.Namespaces {
+		only[ns] = true
+	}
+	var namespaces []ledger.NamespacePlan
+	if report.ReplayPlan != nil {
+		namespaces = report.ReplayPlan.Namespaces
+	}
+	for _, plan := range namespaces {
+		if len(only) > 0 && !only[plan.Namespace] {
+			continue
+		}
+		nr := NamespaceResult{Namespace: plan.Namespace}
+		for _, m := range plan.Records {
+			if err := ctx.Err(); err != nil {
+				return result, err
+			}
+			rec, err := l.GetByID(m.LedgerID)
+			if err != nil {
+				return result, err
+			}
+			err = sm.Apply(Mutation{
+				RecordID:    m.LedgerID,
+				Timestamp:   m.Timestamp,
+				Namespace:   plan.Namespace,
+				Type:        m.Type,
+				ID:          m.ID,
+				Source:      m.Source,
+				Hash:        m.Hash,
+				ExternalRef: m.ExternalRef,
+				Offset:      m.Offset,
+				Payload:     rec.Payload,
+			})
+			if err != nil {
+				result.Failure = &Failure{RecordID: m.LedgerID, ExternalRef: m.ExternalRef, Error: err.Error()}
+				result.Namespaces = append(result.Namespaces, nr)
+				return result, fmt.Errorf("apply mutation %d (%s): %w", m.LedgerID, m.ExternalRef, err)
+			}
+			nr.Applied++
+			result.Applied++
+		}
+		nr.Checkpoint = sm.Checkpoint()
+		result.Namespaces = append(result.Namespaces, nr)
+	}
+
+	result.Hash = sm.Checkpoint()
+	result.Match = opts.Expected == "" || opts.Expected == result.Hash
+	if !result.Match {
+		return result, fmt.Errorf("%w: got %s, want %s", ErrStateMismatch, result.Hash, opts.Expected)
+	}
+	return result, nil
+}
+
+// EntityState is a StateMachine that tracks the latest hash of every
+// entity, identified by mutation source, type and ID. It checks that a
+// replay reaches the same entity versions without application code.
+type EntityState struct {
+	entities map[string]string
+}
+
+// NewEntityState returns an empty EntityState.
+func NewEntityState() *EntityState {
+	return &EntityState{entities: map[string]string{}}
+}
+
+// Apply records m's hash as the entity's current version.
+func (s *EntityState) Apply(m Mutation) error {
+	if m.ID == "" {
+		return errors.New("mutation has no entity id")
+	}
+	s.entities[m.Source+"/"+m.Type+"/"+m.ID] = m.Hash
+	return nil
+}
+
+// Checkpoint hashes the entities and their versions in sorted order.
+func (s *EntityState) Checkpoint() string {
+	keys := make([]string, 0, len(s.entities))
+	for k := range s.entities {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, k := range keys {
+		fmt.Fprintf(h, "%s=%s\n", k, s.entities[k])
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
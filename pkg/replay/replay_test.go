@@ -0,0 +1,86 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/Retr0-XD/StateLedger/internal/ledger"
+)
+
+func newTestLedger(t *testing.T) *ledger.Ledger {
+	t.Helper()
+	l, err := ledger.Open(filepath.Join(t.TempDir(), "ledger.db"))
+	if err != nil {
+		t.Fatalf("open ledger: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	if err := l.InitSchema(); err != nil {
+		t.Fatalf("init ledger: %v", err)
+	}
+	return l
+}
+
+// store is a key-value state machine: "put" sets a key to the mutation
+// hash and "delete" removes an existing key.
+type store map[string]string
+
+func (s store) Apply(m Mutation) error {
+	switch m.Type {
+	case "put":
+		s[m.ID] = m.Hash
+	case "delete":
+		if _, ok := s[m.ID]; !ok {
+			return fmt.Errorf("delete of missing key %s", m.ID)
+		}
+		delete(s, m.ID)
+	}
+	return nil
+}
+
+func (s store) Checkpoint() string {
+	return fmt.Sprintf("a=%s b=%s", s["a"], s["b"])
+}
+
+func TestSimulate(t *testing.T) {
+	l := newTestLedger(t)
+	mutation := func(ts int64, typ, key string, offset int) ledger.RecordInput {
+		return ledger.RecordInput{Timestamp: ts, Type: "mutation", Source: "kv", Payload: fmt.Sprintf(
+			`{"type":%q,"id":%q,"source":"kv","hash":"v%d","external_ref":"kafka:kv:%d"}`, typ, key, offset, offset)}
+	}
+	// Appended out of order; the plan replays them by offset.
+	if _, err := l.AppendBatch([]ledger.RecordInput{
+		mutation(1000, "put", "a", 2),
+		mutation(1001, "put", "a", 1),
+		mutation(1002, "put", "b", 3),
+		mutation(3000, "delete", "c", 4),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	result, err := Simulate(ctx, l, 2000, store{}, Options{Expected: "a=v2 b=v3"})
+	if err != nil {
+		t.Fatalf("Simulate: %v", err)
+	}
+	if !result.Match || result.Applied != 3 || len(result.Namespaces) != 1 || result.Namespaces[0].Checkpoint != "a=v2 b=v3" {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+
+	if _, err := Simulate(ctx, l, 2000, store{}, Options{Expected: "a=v1 b=v3"}); !errors.Is(err, ErrStateMismatch) {
+		t.Fatalf("expected ErrStateMismatch, got %v", err)
+	}
+
+	result, err = Simulate(ctx, l, 4000, store{}, Options{})
+	if err == nil || result.Failure == nil || result.Failure.ExternalRef != "kafka:kv:4" {
+		t.Fatalf("expected failure at offset 4, got %+v, %v", result, err)
+	}
+
+	first, _ := Simulate(ctx, l, 2000, NewEntityState(), Options{})
+	again, _ := Simulate(ctx, l, 2000, NewEntityState(), Options{Expected: first.Hash})
+	if !again.Match || first.Hash == NewEntityState().Checkpoint() {
+		t.Fatalf("entity state not reproducible: %+v, %+v", first, again)
+	}
+}
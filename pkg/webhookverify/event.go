@@ -0,0 +1,110 @@
+package webhookverify
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"time"
+)
+
+// Event is a delivered event as a CloudEvents 1.0 event.
+type Event struct {
+	SpecVersion     string    `json:"specversion"`
+	ID              string    `json:"id"`
+	Source          string    `json:"source"`
+	Type            string    `json:"type"`
+	Time            time.Time `json:"time,omitempty"`
+	DataContentType string    `json:"datacontenttype,omitempty"`
+	// Severity is the StateLedger severity extension: info, warning or
+	// critical.
+	Severity string          `json:"severity,omitempty"`
+	Data     json.RawMessage `json:"data,omitempty"`
+}
+
+// DecodeData unmarshals the event data into v.
+func (e Event) DecodeData(v any) error {
+	if len(e.Data) == 0 {
+		return errors.New("webhookverify: event has no data")
+	}
+	return json.Unmarshal(e.Data, v)
+}
+
+// ledgerEvent is the body the StateLedger server sends.
+type ledgerEvent struct {
+	EventType string          `json:"event_type"`
+	Severity  string          `json:"severity"`
+	Timestamp time.Time       `json:"timestamp"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// ParseEvent reads a delivery as a CloudEvent. A body with Content-Type
+// application/cloudevents+json is read in structured mode. Otherwise the
+// body is a StateLedger event, whose attributes come from the ce-* headers
+// (binary mode) and, when those are absent, from the body and the delivery
+// header.
+func ParseEvent(h http.Header, body []byte) (Event, error) {
+	if mt, _, _ := mime.ParseMediaType(h.Get("Content-Type")); mt == "application/cloudevents+json" {
+		var e Event
+		if err := json.Unmarshal(body, &e); err != nil {
+			return Event{}, fmt.Errorf("webhookverify: parse cloudevent: %w", err)
+		}
+		return e, e.validate()
+	}
+
+	var le ledgerEvent
+	if err := json.Unmarshal(body, &le); err != nil {
+		return Event{}, fmt.Errorf("webhookverify: parse event: %w", err)
+	}
+	e := Event{
+		SpecVersion:     headerValue(h, "ce-specversion"),
+		ID:              headerValue(h, "ce-id"),
+		Source:          headerValue(h, "ce-source"),
+		Type:            headerValue(h, "ce-type"),
+		Severity:        headerValue(h, "ce-severity"),
+		DataContentType: "application/json",
+		Data:            le.Data,
+	}
+	if t := headerValue(h, "ce-time"); t != "" {
+		parsed, err := time.Parse(time.RFC3339Nano, t)
+		if err != nil {
+			return Event{}, fmt.Errorf("webhookverify: parse ce-time: %w", err)
+		}
+		e.Time = parsed
+	}
+	if e.SpecVersion == "" {
+		e.SpecVersion = "1.0"
+	}
+	if e.ID == "" {
+		e.ID = h.Get(HeaderDelivery)
+	}
+	if e.Source == "" {
+		e.Source = "stateledger"
+	}
+	if e.Type == "" {
+		e.Type = le.EventType
+	}
+	if e.Severity == "" {
+		e.Severity = le.Severity
+	}
+	if e.Time.IsZero() {
+		e.Time = le.Timestamp
+	}
+	return e, e.validate()
+}
+
+// validate checks the attributes CloudEvents requires.
+func (e Event) validate() error {
+	switch {
+	case e.SpecVersion != "1.0":
+		return fmt.Errorf("webhookverify: unsupported specversion %q", e.SpecVersion)
+	case e.ID == "":
+		return errors.New("webhookverify: event has no id")
+	case e.Source == "":
+		return errors.New("webhookverify: event has no source")
+	case e.Type == "":
+		return errors.New("webhookverify: event has no type")
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package webhookverify
+
+import (
+	"sync"
+	"time"
+)
+
+// DeliveryStore remembers accepted delivery IDs. Implement it over a shared
+// cache such as Redis when several replicas receive the same subscription.
+type DeliveryStore interface {
+	// Seen records id until expires and reports whether it was already
+	// recorded and not yet expired. It must be atomic.
+	Seen(id string, expires time.Time) bool
+	// Forget removes id, so that a retry of a delivery whose processing
+	// failed is accepted.
+	Forget(id string)
+}
+
+// MemoryStore is an in-process DeliveryStore.
+type MemoryStore struct {
+	mu      sync.Mutex
+	expires map[string]time.Time
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{expires: map[string]time.Time{}}
+}
+
+// Seen implements DeliveryStore. Expired IDs are dropped as it goes.
+func (s *MemoryStore) Seen(id string, expires time.Time) bool {
+	now := time.Now
+	if s.Now != nil {
+		now = s.Now
+	}
+	t := now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.expires == nil {
+		s.expires = map[string]time.Time{}
+	}
+	if exp, ok := s.expires[id]; ok && t.Before(exp) {
+		return true
+	}
+	for k, exp := range s.expires {
+		if !t.Before(exp) {
+			delete(s.expires, k)
+		}
+	}
+	s.expires[id] = expires
+	return false
+}
+
+// Forget implements DeliveryStore.
+func (s *MemoryStore) Forget(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.expires, id)
+}
+
+// Len returns the number of remembered IDs, including expired ones not yet
+// dropped.
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.expires)
+}
@@ -0,0 +1,227 @@
+// Package webhookverify helps services that receive StateLedger webhooks to
+// check that a delivery is authentic and new before acting on it.
+//
+// A Verifier checks the HMAC signature of a delivery against the
+// subscription secret, rejects deliveries whose timestamp is outside the
+// tolerance, and, with a DeliveryStore, rejects deliveries it has already
+// accepted. ParseEvent reads the event as a CloudEvent, whether it was sent
+// with ce-* headers, as an application/cloudevents+json body or as a plain
+// StateLedger event body.
+//
+//	v := &webhookverify.Verifier{
+//		Secrets:    []string{os.Getenv("STATELEDGER_WEBHOOK_SECRET")},
+//		Deliveries: webhookverify.NewMemoryStore(),
+//	}
+//	http.HandleFunc("/hooks/stateledger", func(w http.ResponseWriter, r *http.Request) {
+//		event, err := v.VerifyRequest(r)
+//		if err != nil {
+//			http.Error(w, err.Error(), webhookverify.StatusCode(err))
+//			return
+//		}
+//		if err := handle(event); err != nil {
+//			v.Deliveries.Forget(event.ID) // accept the retry
+//			http.Error(w, err.Error(), http.StatusInternalServerError)
+//		}
+//	})
+package webhookverify
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Delivery headers set by the StateLedger server.
+const (
+	HeaderDelivery  = "X-StateLedger-Delivery"
+	HeaderTimestamp = "X-StateLedger-Timestamp"
+	HeaderSignature = "X-StateLedger-Signature"
+)
+
+const (
+	// DefaultTolerance is how far the delivery timestamp may be from the
+	// receiver's clock when Verifier.Tolerance is zero.
+	DefaultTolerance = 5 * time.Minute
+	// DefaultMaxBodyBytes bounds the body read by VerifyRequest when
+	// Verifier.MaxBodyBytes is zero.
+	DefaultMaxBodyBytes = 1 << 20
+)
+
+var (
+	ErrMissingSignature  = errors.New("webhookverify: missing signature")
+	ErrInvalidSignature  = errors.New("webhookverify: signature does not match")
+	ErrMissingTimestamp  = errors.New("webhookverify: missing or malformed timestamp")
+	ErrStaleTimestamp    = errors.New("webhookverify: timestamp outside tolerance")
+	ErrMissingDelivery   = errors.New("webhookverify: missing delivery ID")
+	ErrDuplicateDelivery = errors.New("webhookverify: delivery already received")
+	ErrBodyTooLarge      = errors.New("webhookverify: body too large")
+)
+
+// Sign returns the signature header value of body sent as delivery
+// deliveryID at timestamp, as the server computes it: "sha256=" and the hex
+// HMAC-SHA256 of "<deliveryID>.<timestamp>.<body>" keyed with secret. It is
+// exported for tests of receivers.
+func Sign(secret, deliveryID, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(deliveryID))
+	mac.Write([]byte("."))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature reports whether signature is the signature of body sent
+// as delivery deliveryID at timestamp under secret. The comparison is
+// constant-time.
+func VerifySignature(secret, deliveryID, timestamp string, body []byte, signature string) error {
+	if signature == "" {
+		return ErrMissingSignature
+	}
+	if !hmac.Equal([]byte(Sign(secret, deliveryID, timestamp, body)), []byte(signature)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// Verifier checks deliveries of one subscription.
+type Verifier struct {
+	// Secrets are the accepted subscription secrets. Listing the old and
+	// new secret while a subscription is re-registered keeps deliveries
+	// flowing during the rotation. With no secrets, signatures are not
+	// checked; only do this for subscriptions registered without one.
+	Secrets []string
+	// Tolerance is the largest accepted difference between the delivery
+	// timestamp and Now. Zero means DefaultTolerance; a negative value
+	// disables the check.
+	Tolerance time.Duration
+	// Deliveries, when set, rejects delivery IDs that were already
+	// accepted within the tolerance.
+	Deliveries DeliveryStore
+	// MaxBodyBytes bounds the body read by VerifyRequest. Zero means
+	// DefaultMaxBodyBytes.
+	MaxBodyBytes int64
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// Verify checks the headers of a delivery with the given body: the
+// signature, which covers the delivery ID, then the timestamp, then that
+// the delivery ID is new. A ce-id header must repeat the delivery ID, so
+// a replay cannot pass as a new event under another ID. The delivery is
+// only recorded in Deliveries once everything else passed, so forged or
+// stale requests cannot block a real delivery.
+func (v *Verifier) Verify(h http.Header, body []byte) error {
+	ts := h.Get(HeaderTimestamp)
+	id := h.Get(HeaderDelivery)
+	if ceID := headerValue(h, "ce-id"); ceID != "" && ceID != id {
+		return fmt.Errorf("%w: ce-id %q is not the delivery ID %q", ErrInvalidSignature, ceID, id)
+	}
+	if len(v.Secrets) > 0 {
+		sig := h.Get(HeaderSignature)
+		if sig == "" {
+			return ErrMissingSignature
+		}
+		err := ErrInvalidSignature
+		for _, secret := range v.Secrets {
+			if VerifySignature(secret, id, ts, body, sig) == nil {
+				err = nil
+				break
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	now := time.Now
+	if v.Now != nil {
+		now = v.Now
+	}
+	tolerance := v.Tolerance
+	if tolerance == 0 {
+		tolerance = DefaultTolerance
+	}
+	sent, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return ErrMissingTimestamp
+	}
+	sentAt := time.Unix(sent, 0)
+	if tolerance > 0 {
+		if d := now().Sub(sentAt); d > tolerance || d < -tolerance {
+			return fmt.Errorf("%w: sent %s", ErrStaleTimestamp, sentAt.UTC().Format(time.RFC3339))
+		}
+	}
+
+	if v.Deliveries != nil {
+		if id == "" {
+			return ErrMissingDelivery
+		}
+		// A replay past the tolerance fails the timestamp check, so the ID
+		// only needs remembering that long.
+		keep := tolerance
+		if keep < 0 {
+			keep = DefaultTolerance
+		}
+		if v.Deliveries.Seen(id, now().Add(keep)) {
+			return fmt.Errorf("%w: %s", ErrDuplicateDelivery, id)
+		}
+	}
+	return nil
+}
+
+// VerifyRequest reads the body of r, verifies the delivery and parses its
+// event.
+func (v *Verifier) VerifyRequest(r *http.Request) (Event, error) {
+	limit := v.MaxBodyBytes
+	if limit <= 0 {
+		limit = DefaultMaxBodyBytes
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		return Event{}, err
+	}
+	if int64(len(body)) > limit {
+		return Event{}, ErrBodyTooLarge
+	}
+	if err := v.Verify(r.Header, body); err != nil {
+		return Event{}, err
+	}
+	return ParseEvent(r.Header, body)
+}
+
+// StatusCode is the HTTP status a receiver should answer a failed
+// verification with. Duplicates get 200 so the server stops retrying them.
+func StatusCode(err error) int {
+	switch {
+	case err == nil, errors.Is(err, ErrDuplicateDelivery):
+		return http.StatusOK
+	case errors.Is(err, ErrBodyTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrMissingSignature), errors.Is(err, ErrInvalidSignature), errors.Is(err, ErrStaleTimestamp):
+		return http.StatusUnauthorized
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// headerValue returns the first value of a header whose name matches key
+// case-insensitively, for ce-* headers set without canonicalization.
+func headerValue(h http.Header, key string) string {
+	if v := h.Get(key); v != "" {
+		return v
+	}
+	for k, vs := range h {
+		if strings.EqualFold(k, key) && len(vs) > 0 {
+			return vs[0]
+		}
+	}
+	return ""
+}
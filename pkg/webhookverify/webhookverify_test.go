@@ -0,0 +1,202 @@
+package webhookverify
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Retr0-XD/StateLedger/internal/ledger"
+)
+
+func signedHeader(secret string, sentAt time.Time, id string, body []byte) http.Header {
+	ts := strconv.FormatInt(sentAt.Unix(), 10)
+	h := http.Header{}
+	h.Set("Content-Type", "application/json")
+	h.Set(HeaderDelivery, id)
+	h.Set(HeaderTimestamp, ts)
+	h.Set(HeaderSignature, Sign(secret, id, ts, body))
+	return h
+}
+
+func TestVerifyServerDelivery(t *testing.T) {
+	type delivery struct {
+		header http.Header
+		body   []byte
+	}
+	got := make(chan delivery, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got <- delivery{r.Header.Clone(), body}
+	}))
+	defer srv.Close()
+
+	wm := ledger.NewWebhookManager()
+	if err := wm.Subscribe("hook", srv.URL, nil, "s3cret"); err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	wm.Publish(ledger.WebhookEvent{EventType: ledger.EventDriftDetected, Timestamp: at, Data: map[string]string{"source": "app.yaml"}})
+
+	var d delivery
+	select {
+	case d = <-got:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no delivery")
+	}
+
+	v := &Verifier{Secrets: []string{"old", "s3cret"}, Deliveries: NewMemoryStore()}
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(d.body))
+	req.Header = d.header
+	event, err := v.VerifyRequest(req)
+	if err != nil {
+		t.Fatalf("VerifyRequest: %v", err)
+	}
+	if event.Type != ledger.EventDriftDetected || event.Severity != ledger.SeverityWarning || event.Source != "stateledger" || event.SpecVersion != "1.0" {
+		t.Fatalf("event = %+v", event)
+	}
+	if event.ID == "" || event.ID != d.header.Get(HeaderDelivery) || !event.Time.Equal(at) {
+		t.Fatalf("event id/time = %q %s", event.ID, event.Time)
+	}
+	var data struct{ Source string }
+	if err := event.DecodeData(&data); err != nil || data.Source != "app.yaml" {
+		t.Fatalf("data = %+v, %v", data, err)
+	}
+
+	// The same delivery again is a replay.
+	if err := v.Verify(d.header, d.body); !errors.Is(err, ErrDuplicateDelivery) || StatusCode(err) != http.StatusOK {
+		t.Fatalf("replay: %v", err)
+	}
+	// A tampered body fails the signature.
+	tampered := bytes.Replace(d.body, []byte("app.yaml"), []byte("db.yaml"), 1)
+	if err := (&Verifier{Secrets: []string{"s3cret"}}).Verify(d.header, tampered); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("tampered: %v", err)
+	}
+	if err := (&Verifier{Secrets: []string{"other"}}).Verify(d.header, d.body); StatusCode(err) != http.StatusUnauthorized {
+		t.Fatalf("wrong secret: %v", err)
+	}
+
+	// Replaying the delivery under a new ID, in either header, fails the
+	// signature rather than passing as a new delivery.
+	for _, header := range []string{HeaderDelivery, "ce-id"} {
+		replayed := d.header.Clone()
+		replayed.Set(header, "replayed-id")
+		if header == HeaderDelivery {
+			replayed.Set("ce-id", "replayed-id")
+		}
+		if err := v.Verify(replayed, d.body); !errors.Is(err, ErrInvalidSignature) {
+			t.Fatalf("replay with a new %s: %v", header, err)
+		}
+	}
+}
+
+func TestVerifyTimestamp(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	body := []byte(`{"event_type":"record.appended"}`)
+	v := &Verifier{Secrets: []string{"k"}, Now: func() time.Time { return now }}
+
+	if err := v.Verify(signedHeader("k", now.Add(-4*time.Minute), "d1", body), body); err != nil {
+		t.Fatalf("within tolerance: %v", err)
+	}
+	if err := v.Verify(signedHeader("k", now.Add(-6*time.Minute), "d1", body), body); !errors.Is(err, ErrStaleTimestamp) {
+		t.Fatalf("stale: %v", err)
+	}
+	if err := v.Verify(signedHeader("k", now.Add(6*time.Minute), "d1", body), body); !errors.Is(err, ErrStaleTimestamp) {
+		t.Fatalf("future: %v", err)
+	}
+	v.Tolerance = -1
+	if err := v.Verify(signedHeader("k", now.Add(-time.Hour), "d1", body), body); err != nil {
+		t.Fatalf("check disabled: %v", err)
+	}
+
+	// Moving the timestamp forward breaks the signature.
+	h := signedHeader("k", now.Add(-time.Hour), "d1", body)
+	h.Set(HeaderTimestamp, strconv.FormatInt(now.Unix(), 10))
+	if err := v.Verify(h, body); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("restamped: %v", err)
+	}
+	h.Del(HeaderSignature)
+	if err := v.Verify(h, body); !errors.Is(err, ErrMissingSignature) {
+		t.Fatalf("unsigned: %v", err)
+	}
+}
+
+func TestVerifyDedupe(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	clock := func() time.Time { return now }
+	store := &MemoryStore{Now: clock}
+	v := &Verifier{Secrets: []string{"k"}, Deliveries: store, Now: clock}
+	body := []byte(`{}`)
+
+	// A forged request does not claim the delivery ID.
+	forged := signedHeader("wrong", now, "d1", body)
+	if err := v.Verify(forged, body); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("forged: %v", err)
+	}
+	if err := v.Verify(signedHeader("k", now, "d1", body), body); err != nil {
+		t.Fatalf("first: %v", err)
+	}
+	if err := v.Verify(signedHeader("k", now, "d1", body), body); !errors.Is(err, ErrDuplicateDelivery) {
+		t.Fatalf("second: %v", err)
+	}
+
+	// Forget lets a retry through after processing failed.
+	store.Forget("d1")
+	if err := v.Verify(signedHeader("k", now, "d1", body), body); err != nil {
+		t.Fatalf("after forget: %v", err)
+	}
+
+	// IDs are dropped once a replay would be stale anyway.
+	now = now.Add(DefaultTolerance + time.Second)
+	if err := v.Verify(signedHeader("k", now, "d2", body), body); err != nil {
+		t.Fatal(err)
+	}
+	if store.Len() != 1 {
+		t.Fatalf("store keeps %d IDs, want 1", store.Len())
+	}
+
+	h := signedHeader("k", now, "", body)
+	if err := v.Verify(h, body); !errors.Is(err, ErrMissingDelivery) {
+		t.Fatalf("no delivery ID: %v", err)
+	}
+}
+
+func TestParseEventStructured(t *testing.T) {
+	h := http.Header{}
+	h.Set("Content-Type", "application/cloudevents+json; charset=utf-8")
+	body := []byte(`{"specversion":"1.0","id":"abc","source":"stateledger","type":"chain.verified","time":"2025-01-01T00:00:00Z","severity":"info","data":{"valid":true}}`)
+	e, err := ParseEvent(h, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.ID != "abc" || e.Type != ledger.EventChainVerified || e.Severity != "info" || e.Time.IsZero() {
+		t.Fatalf("event = %+v", e)
+	}
+
+	if _, err := ParseEvent(h, []byte(`{"specversion":"0.3","id":"a","source":"s","type":"t"}`)); err == nil {
+		t.Fatal("accepted specversion 0.3")
+	}
+
+	// A plain body without ce-* headers takes its attributes from the body.
+	plain := http.Header{}
+	plain.Set(HeaderDelivery, "d9")
+	e, err = ParseEvent(plain, []byte(`{"event_type":"agent.offline","severity":"warning","timestamp":"2025-01-01T00:00:00Z","data":{"agent":"a1"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.ID != "d9" || e.Type != ledger.EventAgentOffline || e.Severity != "warning" || e.Source != "stateledger" {
+		t.Fatalf("event = %+v", e)
+	}
+}
+
+func TestVerifyRequestBodyLimit(t *testing.T) {
+	v := &Verifier{MaxBodyBytes: 8}
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader([]byte(`{"event_type":"x"}`)))
+	if _, err := v.VerifyRequest(req); !errors.Is(err, ErrBodyTooLarge) || StatusCode(err) != http.StatusRequestEntityTooLarge {
+		t.Fatalf("err = %v", err)
+	}
+}